@@ -25,6 +25,16 @@ Type 'help' for available commands, 'exit' to quit.
 func main() {
 	dataDir := flag.String("data", "./minidb-data", "Data directory")
 	bufferSize := flag.Int("buffer", 1024, "Buffer pool size (pages)")
+	evictBatch := flag.Int("evict-batch", 1, "Pages evicted at once when the buffer pool is full")
+	evictionPolicy := flag.String("eviction-policy", "lru", "Buffer pool eviction policy: lru or clock")
+	walBuffer := flag.Int("wal-buffer", 64*1024, "WAL buffer size (bytes)")
+	safeUpdates := flag.Bool("safe-updates", false, "Reject UPDATE/DELETE without WHERE")
+	recoverTo := flag.Uint64("recover-to-lsn", 0, "Recover only the WAL up to this LSN (0 = all)")
+	separateCatalog := flag.Bool("separate-catalog", false, "Keep the catalog of a new database in catalog.db")
+	strictChecks := flag.Bool("strict-checks", false, "Validate pages, B-Tree nodes and tuples after every change (slow)")
+	pageChecksums := flag.Bool("page-checksums", false, "Store a CRC32 in each page written and verify it on read")
+	checkpointInterval := flag.Duration("checkpoint-interval", 0, "Checkpoint in the background this often (0 = only on the checkpoint command)")
+	readOnly := flag.Bool("read-only", false, "Open an existing database without writing to its files")
 	flag.Parse()
 
 	fmt.Print(banner)
@@ -34,8 +44,18 @@ func main() {
 	fmt.Printf("Buffer pool: %d pages (%d KB)\n", *bufferSize, *bufferSize*4)
 
 	db, err := engine.New(engine.Config{
-		DataDir:        *dataDir,
-		BufferPoolSize: *bufferSize,
+		DataDir:                 *dataDir,
+		BufferPoolSize:          *bufferSize,
+		EvictBatchSize:          *evictBatch,
+		EvictionPolicy:          *evictionPolicy,
+		WALBufferSize:           *walBuffer,
+		RequireWhereForMutation: *safeUpdates,
+		RecoveryTargetLSN:       types.LSN(*recoverTo),
+		SeparateCatalog:         *separateCatalog,
+		StrictChecks:            *strictChecks,
+		PageChecksums:           *pageChecksums,
+		CheckpointInterval:      *checkpointInterval,
+		ReadOnly:                *readOnly,
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to start database: %v\n", err)
@@ -84,9 +104,36 @@ func main() {
 		case lower == "vacuum":
 			vacuumDB(db)
 			continue
+		case lower == "reindex":
+			if err := db.Reindex(); err != nil {
+				fmt.Printf("REINDEX failed: %v\n", err)
+			} else {
+				fmt.Println("REINDEX: rebuilt all indexes.")
+			}
+			continue
+		case lower == "repair":
+			repairDB(db)
+			continue
 		case lower == "tables" || lower == "\\dt":
 			printTables(db)
 			continue
+		case lower == "\\pages" || strings.HasPrefix(lower, "\\pages "):
+			tableName := strings.TrimSpace(input[len("\\pages"):])
+			if tableName == "" {
+				fmt.Println("Usage: \\pages <table>")
+				continue
+			}
+			printPages(db, tableName)
+			continue
+		case lower == "\\copy" || strings.HasPrefix(lower, "\\copy "):
+			args := strings.TrimSpace(input[len("\\copy"):])
+			fields := strings.Fields(args)
+			if len(fields) < 2 {
+				fmt.Println("Usage: \\copy <file> <query>")
+				continue
+			}
+			copyQuery(db, fields[0], strings.TrimSpace(args[len(fields[0]):]))
+			continue
 		case strings.HasPrefix(lower, "create index on "):
 			rest := strings.TrimPrefix(lower, "create index on ")
 			rest = strings.TrimSpace(rest)
@@ -104,11 +151,28 @@ func main() {
 				fmt.Printf("Index created on %s(%s)\n", tableName, columnName)
 			}
 			continue
+		case strings.HasPrefix(lower, "drop index on "):
+			rest := strings.TrimSpace(strings.TrimPrefix(lower, "drop index on "))
+			// Parse: table(column)
+			parenIdx := strings.Index(rest, "(")
+			if parenIdx < 0 || !strings.HasSuffix(rest, ")") {
+				fmt.Println("Usage: drop index on <table>(<column>)")
+				continue
+			}
+			tableName := strings.TrimSpace(rest[:parenIdx])
+			columnName := strings.TrimSpace(rest[parenIdx+1 : len(rest)-1])
+			if err := db.DropIndex(tableName, columnName); err != nil {
+				fmt.Printf("Drop index failed: %v\n", err)
+			} else {
+				fmt.Printf("Index dropped on %s(%s)\n", tableName, columnName)
+			}
+			continue
 		}
 
-		// Execute SQL
-		result := db.Execute(input)
-		printResult(result)
+		// Execute SQL, one or more statements separated by semicolons
+		for _, result := range db.ExecuteScript(input) {
+			printResult(result)
+		}
 	}
 }
 
@@ -118,27 +182,58 @@ Commands:
   help, \h          Show this help message
   stats, \s         Show database statistics
   tables, \dt       List all tables
+  \pages <table>    List a table's heap pages and their free space
+  \copy <file> <query>  Write a query's result to a CSV file
   checkpoint        Create a checkpoint
   vacuum            Remove dead tuples (MVCC garbage collection)
+  reindex           Rebuild every index from its table
+  repair            Reclaim orphaned pages onto the free list
   create index on <table>(<column>)  Create B-Tree index
+  drop index on <table>(<column>)    Drop a column's index
   exit, quit        Exit the database
 
 SQL Statements:
   CREATE TABLE name (col1 TYPE, col2 TYPE, ...)
-    Types: INT, TEXT, BOOL
+    Types: INT, TEXT, BOOL, SERIAL
+    Constraints: NOT NULL, DEFAULT value, UNIQUE, PRIMARY KEY, COLLATE NOCASE
     
   INSERT INTO table (col1, col2) VALUES (val1, val2)
   
   SELECT col1, col2 FROM table [WHERE condition]
   SELECT * FROM table
+  SELECT expr1, expr2 [FROM table]   e.g. SELECT 1 + 1, UPPER('hi')
+  SELECT expr AS alias FROM table    Name an output column
+  SELECT ... ORDER BY col|expr|position [ASC|DESC], ...
+  SELECT ... [LIMIT n] [OFFSET m]
+  SELECT ... INTO table2 FROM table  Create table2 from the result
+  SELECT expr, COUNT(*) FROM table GROUP BY expr, ...
+    Aggregates: COUNT(*), COUNT(x), SUM(x), AVG(x), MIN(x), MAX(x)
+  SELECT /*+ seqscan | indexscan | index(table_col) */ ...
+  EXPLAIN SELECT ...                 Show the query plan
+  
+  VALUES (val1, val2), (val3, val4)  Return literal rows
   
   UPDATE table SET col1 = val1 [WHERE condition]
   
   DELETE FROM table [WHERE condition]
   
+  TRUNCATE [TABLE] table [RESTART IDENTITY | CONTINUE IDENTITY]
+  
+  COPY table TO 'file.csv' [WITH (FORMAT csv, HEADER true)]
+  COPY table FROM 'file.csv' [WITH (FORMAT csv, HEADER true)]
+  
+  COMMENT ON TABLE table IS 'text'
+  COMMENT ON COLUMN table.column IS 'text'
+  DESCRIBE table
+  
   BEGIN       Start a transaction
   COMMIT      Commit the current transaction
   ROLLBACK    Rollback the current transaction
+  
+  SET name = value   Change a session setting
+  SHOW name          Show a session setting
+    Settings: autocommit (on|off), isolation ('read committed'|'repeatable read'),
+              require_where (on|off)
 
 Storage Architecture:
   ┌─────────────────────────────────────────┐
@@ -164,6 +259,31 @@ Examples:
 	fmt.Println(help)
 }
 
+// copyQuery runs a query and writes its result to a CSV file with a
+// header row.
+func copyQuery(db *engine.Engine, path, query string) {
+	result := db.Execute(query)
+	if result.Error != nil {
+		fmt.Printf("ERROR: %v\n", result.Error)
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("\\copy failed: %v\n", err)
+		return
+	}
+	if err := result.ToCSV(f); err != nil {
+		f.Close()
+		fmt.Printf("\\copy failed: %v\n", err)
+		return
+	}
+	if err := f.Close(); err != nil {
+		fmt.Printf("\\copy failed: %v\n", err)
+		return
+	}
+	fmt.Printf("COPY %d\n", len(result.Rows))
+}
+
 func vacuumDB(db *engine.Engine) {
 	result, err := db.Vacuum()
 	if err != nil {
@@ -177,12 +297,25 @@ func vacuumDB(db *engine.Engine) {
 		fmt.Printf("VACUUM: removed %d dead tuples.\n", total)
 		for _, ts := range result.Tables {
 			if ts.TuplesRemoved > 0 {
-				fmt.Printf("  %s: scanned %d, removed %d\n", ts.TableName, ts.TuplesScanned, ts.TuplesRemoved)
+				fmt.Printf("  %s: scanned %d, removed %d, index entries removed %d\n",
+					ts.TableName, ts.TuplesScanned, ts.TuplesRemoved, ts.IndexEntriesRemoved)
 			}
 		}
 	}
 }
 
+func repairDB(db *engine.Engine) {
+	result, err := db.Repair()
+	if err != nil {
+		fmt.Printf("REPAIR failed: %v\n", err)
+		return
+	}
+	fmt.Printf("REPAIR: scanned %d pages, reclaimed %d orphaned pages.\n", result.PagesScanned, len(result.Reclaimed))
+	for _, pageID := range result.Reclaimed {
+		fmt.Printf("  page %d\n", pageID)
+	}
+}
+
 func printStats(db *engine.Engine) {
 	stats := db.Stats()
 	fmt.Println("\n╔══════════════════════════════════════════╗")
@@ -190,6 +323,8 @@ func printStats(db *engine.Engine) {
 	fmt.Println("╠══════════════════════════════════════════╣")
 	fmt.Printf("║  WAL Current LSN:    %-19v ║\n", stats["wal_current_lsn"])
 	fmt.Printf("║  WAL Flushed LSN:    %-19v ║\n", stats["wal_flushed_lsn"])
+	fmt.Printf("║  WAL Size (bytes):   %-19v ║\n", stats["wal_size_bytes"])
+	fmt.Printf("║  WAL Since Ckpt:     %-19v ║\n", fmt.Sprintf("%v recs / %v B", stats["wal_records_since_checkpoint"], stats["wal_bytes_since_checkpoint"]))
 	fmt.Printf("║  Active Txns:        %-19v ║\n", stats["active_txns"])
 	fmt.Println("╠══════════════════════════════════════════╣")
 	fmt.Printf("║  Disk Pages:         %-19v ║\n", stats["disk_pages"])
@@ -198,6 +333,7 @@ func printStats(db *engine.Engine) {
 	fmt.Printf("║  Buffer Pool Hits:   %-19v ║\n", stats["buffer_pool_hits"])
 	fmt.Printf("║  Buffer Pool Misses: %-19v ║\n", stats["buffer_pool_misses"])
 	fmt.Printf("║  Buffer Pool Cached: %-19v ║\n", stats["buffer_pool_cached"])
+	fmt.Printf("║  Prefetched Pages:   %-19v ║\n", stats["buffer_pool_prefetches"])
 	fmt.Printf("║  Buffer Hit Rate:    %-19v ║\n", stats["buffer_hit_rate"])
 	fmt.Println("╚══════════════════════════════════════════╝")
 	fmt.Println()
@@ -230,6 +366,13 @@ func printTables(db *engine.Engine) {
 				typeName = "TEXT"
 			case types.ValueTypeBool:
 				typeName = "BOOL"
+			case types.ValueTypeBytes:
+				typeName = "BLOB"
+			case types.ValueTypeTimestamp:
+				typeName = "TIMESTAMP"
+			}
+			if col.Collation != types.CollationBinary {
+				typeName += " COLLATE " + col.Collation.String()
 			}
 			fmt.Printf("    - %s %s%s\n", col.Name, typeName, nullable)
 		}
@@ -237,6 +380,23 @@ func printTables(db *engine.Engine) {
 	fmt.Println()
 }
 
+func printPages(db *engine.Engine, tableName string) {
+	pages, err := db.TablePages(tableName)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return
+	}
+
+	fmt.Printf("\nPages of %s:\n", tableName)
+	fmt.Printf("  %8s  %6s  %10s\n", "page", "live", "free bytes")
+	live := 0
+	for _, p := range pages {
+		fmt.Printf("  %8d  %6d  %10d\n", p.PageID, p.LiveTuples, p.FreeSpace)
+		live += int(p.LiveTuples)
+	}
+	fmt.Printf("(%d pages, %d live tuples)\n\n", len(pages), live)
+}
+
 func printResult(result *sql.Result) {
 	if result.Error != nil {
 		fmt.Printf("ERROR: %v\n", result.Error)
@@ -297,6 +457,10 @@ func formatValue(val types.Value) string {
 			return "true"
 		}
 		return "false"
+	case types.ValueTypeBytes:
+		return types.FormatBytes(val.BytesVal)
+	case types.ValueTypeTimestamp:
+		return types.FormatTimestamp(val.IntVal)
 	default:
 		return "NULL"
 	}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"minidb/internal/sql"
+)
+
+// autoAnalyzeBaseRows is how many rows of a table must change, beyond the
+// threshold fraction, before auto-analyze refreshes its statistics.
+const autoAnalyzeBaseRows = 50
+
+// autoAnalyzer refreshes table statistics in the background once enough
+// rows have changed, like autovacuum does for dead tuples.
+type autoAnalyzer struct {
+	executor  *sql.Executor // Own session, so ANALYZE never runs inside a user's transaction
+	threshold float64
+	trigger   chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// startAutoAnalyze starts the background analyzer. Execute wakes it after a
+// statement leaves some table past the threshold.
+func (e *Engine) startAutoAnalyze(threshold float64) {
+	executor := sql.NewExecutor(e.txnManager, e.walWriter)
+	executor.SetStorage(e.catalog, e.bufferPool)
+	executor.SetIndexes(e.indexes)
+	executor.SetStatistics(e.stats)
+
+	e.analyzer = &autoAnalyzer{
+		executor:  executor,
+		threshold: threshold,
+		trigger:   make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go e.runAutoAnalyze(e.analyzer)
+}
+
+// triggerAutoAnalyze wakes the analyzer if a table is due. A wake-up already
+// pending covers this one.
+func (e *Engine) triggerAutoAnalyze() {
+	a := e.analyzer
+	if a == nil || len(e.stats.Due(a.threshold, autoAnalyzeBaseRows)) == 0 {
+		return
+	}
+	select {
+	case a.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// runAutoAnalyze analyzes the due tables on each wake-up, between the
+// statements of other sessions.
+func (e *Engine) runAutoAnalyze(a *autoAnalyzer) {
+	defer close(a.done)
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-a.trigger:
+		}
+
+		e.mu.Lock()
+		for _, table := range e.stats.Due(a.threshold, autoAnalyzeBaseRows) {
+			// A table dropped since its changes were counted is forgotten
+			if r := a.executor.Execute("ANALYZE " + table); r.Error != nil {
+				e.stats.Forget(table)
+			}
+		}
+		e.mu.Unlock()
+	}
+}
+
+// stopAutoAnalyze stops the analyzer and waits for a running ANALYZE to
+// finish.
+func (e *Engine) stopAutoAnalyze() {
+	if e.analyzer == nil {
+		return
+	}
+	close(e.analyzer.stop)
+	<-e.analyzer.done
+	e.analyzer = nil
+}
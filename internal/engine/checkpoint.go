@@ -0,0 +1,61 @@
+package engine
+
+import "time"
+
+// checkpointer checkpoints in the background on a timer, so that recovery
+// after a crash replays at most about one interval of WAL.
+type checkpointer struct {
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// startCheckpointer starts the background checkpointer.
+func (e *Engine) startCheckpointer(interval time.Duration) {
+	e.checkpointer = &checkpointer{
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.runCheckpointer(e.checkpointer)
+}
+
+// runCheckpointer checkpoints on each tick, between the statements of other
+// sessions. A tick is skipped if another checkpoint ran since the last one
+// (a user's, or one that held the engine when the tick fired), or if
+// nothing has been logged since the last checkpoint. A failed checkpoint is
+// retried on the next tick.
+func (e *Engine) runCheckpointer(c *checkpointer) {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	e.mu.Lock()
+	seen := e.checkpoints
+	e.mu.Unlock()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		e.mu.Lock()
+		if records, _ := e.walWriter.SinceCheckpoint(); records > 0 && e.checkpoints == seen {
+			e.checkpoint()
+		}
+		seen = e.checkpoints
+		e.mu.Unlock()
+	}
+}
+
+// stopCheckpointer stops the checkpointer and waits for a running
+// checkpoint to finish.
+func (e *Engine) stopCheckpointer() {
+	if e.checkpointer == nil {
+		return
+	}
+	close(e.checkpointer.stop)
+	<-e.checkpointer.done
+	e.checkpointer = nil
+}
@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"minidb/internal/sql"
+	"minidb/pkg/types"
+)
+
+// RowCursor streams the rows of a SELECT started with Query. It runs in a
+// session of its own, so it reads a snapshot of committed data and does not
+// see the changes of an open Execute transaction. Until it is closed it
+// keeps its snapshot, which VACUUM respects, and pins the heap page it is
+// reading.
+type RowCursor struct {
+	session *Session
+	cursor  *sql.Cursor
+}
+
+// Query runs a SELECT and returns a cursor over its result. A sequential
+// scan reads the table as the cursor advances instead of building every
+// row first (see sql.Cursor). The caller must Close the cursor.
+func (e *Engine) Query(sqlStr string) (*RowCursor, error) {
+	s := e.NewSession()
+	e.mu.Lock()
+	c, err := s.executor.Query(sqlStr)
+	e.mu.Unlock()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return &RowCursor{session: s, cursor: c}, nil
+}
+
+// Columns returns the names of the result's columns.
+func (rc *RowCursor) Columns() []string {
+	return rc.cursor.Columns()
+}
+
+// Next returns the next row, or nil once the result is exhausted. Each
+// call takes the engine's lock, so statements of other sessions can run
+// between rows.
+func (rc *RowCursor) Next() (*types.Row, error) {
+	rc.session.engine.mu.Lock()
+	defer rc.session.engine.mu.Unlock()
+	return rc.cursor.Next()
+}
+
+// Close releases the cursor's page and snapshot. Closing it again does
+// nothing; so does closing it after the engine was closed.
+func (rc *RowCursor) Close() error {
+	rc.session.engine.mu.Lock()
+	err := rc.cursor.Close()
+	rc.session.engine.mu.Unlock()
+	rc.session.Close()
+	return err
+}
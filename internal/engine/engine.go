@@ -2,6 +2,7 @@
 package engine
 
 import (
+	"context"
 	"fmt"
 	"minidb/internal/index"
 	"minidb/internal/sql"
@@ -11,6 +12,8 @@ import (
 	"minidb/pkg/types"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 // Engine represents the database engine.
@@ -20,20 +23,116 @@ type Engine struct {
 	diskManager *storage.DiskManager
 	bufferPool  *storage.BufferPool
 	catalog     *storage.Catalog
+	catalogDisk *storage.DiskManager // Nil unless the catalog has its own file
+	catalogPool *storage.BufferPool  // Nil unless the catalog has its own file
 	txnManager  *txn.Manager
-	executor    *sql.Executor
-	indexes     map[uint32]*index.BTree // tableID -> index
+	indexes     map[uint32]map[string]*index.BTree // tableID -> column -> index
+	queryCache  *sql.QueryCache
+	stats       *sql.Statistics
+	retention   VacuumRetention
+
+	// Serializes the entry points that touch tables or the index map,
+	// including the background auto-analyzer and checkpointer, so that
+	// Execute and the rest can be called from several goroutines. The
+	// catalog, buffer pool, WAL and transaction manager lock themselves.
+	mu           sync.Mutex
+	analyzer     *autoAnalyzer // Nil unless auto-analyze is enabled
+	checkpointer *checkpointer // Nil unless CheckpointInterval is set
+	checkpoints  uint64        // Checkpoints taken since the engine opened
+	readOnly     bool          // Opened with Config.ReadOnly
+	requireWhere bool          // Config.RequireWhereForMutation, for new sessions
+	scriptErrors bool          // Config.ContinueScriptOnError
+
+	session  *Session              // Runs Execute
+	executor *sql.Executor         // The session's executor
+	sessions map[*Session]struct{} // Open sessions, including session
+	funcs    []registeredFunc      // Registered with RegisterFunction, in order
 }
 
 // Config holds engine configuration.
 type Config struct {
 	DataDir        string
 	BufferPoolSize int
+	EvictBatchSize int    // Pages the buffer pool evicts at once when full (0 evicts one)
+	EvictionPolicy string // storage.EvictionLRU (the default if empty) or storage.EvictionClock
+	QueryCacheSize int    // Max cached SELECT results (0 disables the cache)
+	WALBufferSize  int    // WAL auto-flush threshold in bytes (0 uses wal.DefaultBufferSize)
+
+	// Return from COMMIT without syncing the WAL. A background flusher
+	// syncs it every WALFlushInterval (0 uses defaultWALFlushInterval), so
+	// a crash loses at most that much committed work.
+	AsyncCommit      bool
+	WALFlushInterval time.Duration
+
+	// Checkpoint in the background this often, so that recovery after a
+	// crash replays at most about this much WAL. 0 checkpoints only when
+	// asked (Checkpoint, the REPL's checkpoint command).
+	CheckpointInterval time.Duration
+
+	// Reject UPDATE/DELETE without a WHERE clause. A statement that really
+	// means every row can say so with WHERE TRUE.
+	RequireWhereForMutation bool
+
+	// Keep recently deleted tuple versions through VACUUM so that older
+	// snapshots can still read them
+	VacuumRetention VacuumRetention
+
+	// Re-analyze a table in the background once the rows inserted, updated
+	// or deleted since its last ANALYZE exceed this fraction of its rows
+	// (plus autoAnalyzeBaseRows, so small tables are not analyzed on every
+	// change). 0 disables auto-analyze.
+	AutoAnalyzeThreshold float64
+
+	// Keep the catalog page in catalogFileName instead of data.db, so the
+	// schema can be backed up on its own and damage to one file does not
+	// take the other with it. A database created this way always opens
+	// with its separate catalog; an existing database cannot be converted.
+	SeparateCatalog bool
+
+	// Validate page layouts, B-Tree node order and tuple encoding after
+	// every change, panicking on a violation. For development: it walks a
+	// page's slot array on every write.
+	StrictChecks bool
+
+	// Store a CRC32 in each page written and verify it on read, so a torn
+	// write or bit flip fails with storage.ErrPageCorrupt instead of being
+	// read as data. Pages last written without one are read unchecked,
+	// so an existing database opens and gains checksums as pages are
+	// rewritten.
+	PageChecksums bool
+
+	// Recover only the WAL up to this LSN, rolling back every transaction
+	// that had not committed by then (0 recovers the whole log)
+	RecoveryTargetLSN types.LSN
+
+	// Open an existing database without writing to its files. The files
+	// are opened read-only; recovery still runs, but what it redoes and
+	// undoes stays in memory and its CLRs are not logged. Statements that
+	// write, Checkpoint, Vacuum and the other maintenance calls fail with
+	// storage.ErrReadOnly. CheckpointInterval is ignored.
+	ReadOnly bool
+
+	// Let ExecuteScript run the rest of a script after a statement fails,
+	// returning every statement's result. By default it stops at the
+	// first failure.
+	ContinueScriptOnError bool
+}
+
+// VacuumRetention sets how long VACUUM keeps dead tuple versions after the
+// transaction that deleted them. A version is kept if either limit covers
+// it; the zero value keeps nothing beyond what active transactions need.
+type VacuumRetention struct {
+	Txns     uint64        // Keep versions deleted by the last Txns transactions
+	Duration time.Duration // Keep versions deleted within this long
 }
 
 const (
-	defaultBufferPoolSize = 1024 // 1024 pages = 4MB
-	metaFileName          = "minidb.meta"
+	defaultBufferPoolSize   = 1024 // 1024 pages = 4MB
+	defaultWALFlushInterval = 100 * time.Millisecond
+	shutdownPollInterval    = 10 * time.Millisecond // How often Shutdown checks for active transactions
+	metaFileName            = "minidb.meta"
+	catalogFileName         = "catalog.db"
+	catalogPoolSize         = 4 // The separate catalog file holds a single page
 )
 
 // New creates a new database engine.
@@ -41,93 +140,182 @@ func New(cfg Config) (*Engine, error) {
 	if cfg.BufferPoolSize == 0 {
 		cfg.BufferPoolSize = defaultBufferPoolSize
 	}
-
-	// Create data directory if needed
-	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	if cfg.WALBufferSize == 0 {
+		cfg.WALBufferSize = wal.DefaultBufferSize
 	}
 
 	walPath := filepath.Join(cfg.DataDir, "wal.log")
 	dataPath := filepath.Join(cfg.DataDir, "data.db")
 	metaPath := filepath.Join(cfg.DataDir, metaFileName)
+	catalogPath := filepath.Join(cfg.DataDir, catalogFileName)
+
+	if cfg.ReadOnly {
+		if _, err := os.Stat(metaPath); err != nil {
+			return nil, fmt.Errorf("open %s read-only: no database there: %w", cfg.DataDir, err)
+		}
+	} else if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
 
 	// Initialize WAL writer
-	walWriter, err := wal.NewWriter(walPath)
+	newWriter := func() (*wal.Writer, error) { return wal.NewWriterSize(walPath, cfg.WALBufferSize) }
+	newDiskManager := storage.NewDiskManager
+	if cfg.ReadOnly {
+		newWriter = func() (*wal.Writer, error) { return wal.NewReadOnlyWriter(walPath) }
+		newDiskManager = storage.NewReadOnlyDiskManager
+	}
+	walWriter, err := newWriter()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create WAL writer: %w", err)
 	}
 
 	// Initialize disk manager
-	diskManager, err := storage.NewDiskManager(dataPath)
+	diskManager, err := newDiskManager(dataPath)
 	if err != nil {
 		walWriter.Close()
 		return nil, fmt.Errorf("failed to create disk manager: %w", err)
 	}
+	diskManager.SetChecksums(cfg.PageChecksums)
 
 	// Initialize buffer pool
 	bufferPool := storage.NewBufferPool(diskManager, cfg.BufferPoolSize)
+	bufferPool.SetEvictBatch(cfg.EvictBatchSize)
+	if err := bufferPool.SetEvictionPolicy(cfg.EvictionPolicy); err != nil {
+		diskManager.Close()
+		walWriter.Close()
+		return nil, err
+	}
+	bufferPool.SetStrictChecks(cfg.StrictChecks)
+
+	// A database created with a separate catalog is recognized by its file
+	_, statErr := os.Stat(metaPath)
+	newDatabase := os.IsNotExist(statErr)
+	separate := cfg.SeparateCatalog
+	if _, err := os.Stat(catalogPath); err == nil && !newDatabase {
+		separate = true
+	} else if separate && !newDatabase {
+		diskManager.Close()
+		walWriter.Close()
+		return nil, fmt.Errorf("database in %s keeps its catalog in data.db; it cannot be moved to %s", cfg.DataDir, catalogFileName)
+	}
+
+	var catalogDisk *storage.DiskManager
+	var catalogPool *storage.BufferPool
+	pagePool := bufferPool // Holds the catalog page
+	closeFiles := func() {
+		if catalogDisk != nil {
+			catalogDisk.Close()
+		}
+		diskManager.Close()
+		walWriter.Close()
+	}
+	if separate {
+		catalogDisk, err = newDiskManager(catalogPath)
+		if err != nil {
+			closeFiles()
+			return nil, fmt.Errorf("failed to open catalog file: %w", err)
+		}
+		catalogDisk.SetChecksums(cfg.PageChecksums)
+		catalogPool = storage.NewBufferPool(catalogDisk, catalogPoolSize)
+		catalogPool.SetStrictChecks(cfg.StrictChecks)
+		pagePool = catalogPool
+	}
 
 	// Initialize or load catalog
 	var catalog *storage.Catalog
-	if _, err := os.Stat(metaPath); os.IsNotExist(err) {
+	if newDatabase {
 		// New database
-		catalog, err = storage.NewCatalog(bufferPool)
+		catalog, err = storage.NewSeparateCatalog(pagePool, bufferPool)
 		if err != nil {
-			diskManager.Close()
-			walWriter.Close()
+			closeFiles()
 			return nil, fmt.Errorf("failed to create catalog: %w", err)
 		}
+		// The catalog page must reach its file before the meta names it
+		if err := pagePool.FlushAllPages(); err != nil {
+			closeFiles()
+			return nil, fmt.Errorf("failed to write catalog: %w", err)
+		}
 		// Save meta
 		if err := saveMeta(metaPath, catalog.GetCatalogPageID()); err != nil {
-			diskManager.Close()
-			walWriter.Close()
+			closeFiles()
 			return nil, err
 		}
 	} else {
 		// Load existing database
 		catalogPageID, err := loadMeta(metaPath)
 		if err != nil {
-			diskManager.Close()
-			walWriter.Close()
+			closeFiles()
 			return nil, err
 		}
-		catalog, err = storage.LoadCatalog(bufferPool, catalogPageID)
+		catalog, err = storage.LoadSeparateCatalog(pagePool, bufferPool, catalogPageID)
 		if err != nil {
-			diskManager.Close()
-			walWriter.Close()
+			closeFiles()
 			return nil, fmt.Errorf("failed to load catalog: %w", err)
 		}
 	}
 
+	// Keep the catalog cached under scan pressure; B-Tree roots are kept
+	// the same way by the trees themselves
+	pagePool.PinPermanent(catalog.GetCatalogPageID())
+
 	txnManager := txn.NewManager(walWriter)
 
-	// Create executor
-	executor := sql.NewExecutor(txnManager, walWriter)
-	executor.SetStorage(catalog, bufferPool)
+	// Cached results are invalidated when a writer of their table commits
+	var queryCache *sql.QueryCache
+	if cfg.QueryCacheSize > 0 {
+		queryCache = sql.NewQueryCache(cfg.QueryCacheSize, txnManager.CommitSeq)
+		txnManager.OnCommit(func(t *txn.Transaction) {
+			queryCache.InvalidateTables(t.WriteSet())
+		})
+	}
 
 	e := &Engine{
-		dataDir:     cfg.DataDir,
-		walWriter:   walWriter,
-		diskManager: diskManager,
-		bufferPool:  bufferPool,
-		catalog:     catalog,
-		txnManager:  txnManager,
-		executor:    executor,
-		indexes:     make(map[uint32]*index.BTree),
+		dataDir:      cfg.DataDir,
+		walWriter:    walWriter,
+		diskManager:  diskManager,
+		bufferPool:   bufferPool,
+		catalog:      catalog,
+		catalogDisk:  catalogDisk,
+		catalogPool:  catalogPool,
+		txnManager:   txnManager,
+		indexes:      make(map[uint32]map[string]*index.BTree),
+		queryCache:   queryCache,
+		stats:        sql.NewStatistics(),
+		retention:    cfg.VacuumRetention,
+		readOnly:     cfg.ReadOnly,
+		requireWhere: cfg.RequireWhereForMutation,
+		scriptErrors: cfg.ContinueScriptOnError,
+		sessions:     make(map[*Session]struct{}),
 	}
 
 	// Load existing indexes
 	e.loadIndexes()
 
-	// Share indexes with executor
-	e.executor.SetIndexes(e.indexes)
+	// The engine's own session, used by Execute
+	e.session = e.NewSession()
+	e.executor = e.session.executor
 
 	// Perform recovery if needed
-	if err := e.recover(); err != nil {
+	if err := e.recover(cfg.RecoveryTargetLSN); err != nil {
 		e.Close()
 		return nil, fmt.Errorf("recovery failed: %w", err)
 	}
 
+	if cfg.AsyncCommit {
+		interval := cfg.WALFlushInterval
+		if interval <= 0 {
+			interval = defaultWALFlushInterval
+		}
+		walWriter.SetAsyncCommit(true)
+		walWriter.StartFlusher(interval)
+	}
+	if cfg.AutoAnalyzeThreshold > 0 {
+		e.startAutoAnalyze(cfg.AutoAnalyzeThreshold)
+	}
+	if cfg.CheckpointInterval > 0 && !cfg.ReadOnly {
+		e.startCheckpointer(cfg.CheckpointInterval)
+	}
+
 	return e, nil
 }
 
@@ -155,14 +343,32 @@ func loadMeta(path string) (types.PageID, error) {
 func (e *Engine) loadIndexes() {
 	for _, tableName := range e.catalog.GetAllTables() {
 		tableID, _ := e.catalog.GetTableID(tableName)
-		if rootPageID, ok := e.catalog.GetIndexRoot(tableID); ok && rootPageID != types.InvalidPageID {
-			e.indexes[tableID] = index.LoadBTree(e.bufferPool, rootPageID, 64)
+		for _, column := range e.catalog.GetIndexColumns(tableID) {
+			if rootPageID, _ := e.catalog.GetIndexRoot(tableID, column); rootPageID != types.InvalidPageID {
+				e.setIndex(tableID, column, index.LoadBTree(e.bufferPool, rootPageID, 64))
+			}
 		}
 	}
 }
 
-// recover performs crash recovery.
-func (e *Engine) recover() error {
+// setIndex records the index on a table's column and keeps the catalog's
+// root for it current when a split or a collapse moves the root. Recording
+// the initial root is up to the caller.
+func (e *Engine) setIndex(tableID uint32, column string, btree *index.BTree) {
+	if e.indexes[tableID] == nil {
+		e.indexes[tableID] = make(map[string]*index.BTree)
+	}
+	e.indexes[tableID][column] = btree
+	btree.OnRootChange(func(root types.PageID) {
+		if e.indexes[tableID][column] == btree {
+			e.catalog.SetIndexRoot(tableID, root, column)
+		}
+	})
+}
+
+// recover performs crash recovery, stopping at targetLSN unless it is
+// InvalidLSN.
+func (e *Engine) recover(targetLSN types.LSN) error {
 	walPath := filepath.Join(e.dataDir, "wal.log")
 
 	// Check if WAL exists
@@ -173,6 +379,7 @@ func (e *Engine) recover() error {
 	fmt.Println("Performing crash recovery...")
 
 	rm := wal.NewRecoveryManager(walPath, e.walWriter)
+	rm.SetTargetLSN(targetLSN)
 
 	// Set recovery callbacks
 	rm.SetCallbacks(
@@ -200,10 +407,18 @@ func (e *Engine) recover() error {
 	}
 
 	// Flush all dirty pages after recovery
-	if err := e.bufferPool.FlushAllPages(); err != nil {
+	if err := e.flushPages(); err != nil {
 		return fmt.Errorf("failed to flush pages after recovery: %w", err)
 	}
 
+	// The log past the target still holds commits that were rolled back;
+	// checkpoint so that the next recovery starts after them
+	if targetLSN != types.InvalidLSN && !e.readOnly {
+		if err := e.Checkpoint(); err != nil {
+			return fmt.Errorf("failed to checkpoint after point-in-time recovery: %w", err)
+		}
+	}
+
 	// Update transaction manager's next ID using max from WAL
 	maxTxnID := e.walWriter.GetMaxTxnID()
 	att := rm.GetActiveTxnTable()
@@ -248,9 +463,7 @@ func (e *Engine) applyRedo(record *wal.LogRecord) error {
 		e.bufferPool.UnpinPage(record.PageID, true)
 
 		// Redo update step 2: set XMax on old version
-		// Old location is encoded in RowID: PageID<<16 | SlotNum
-		oldPageID := types.PageID(record.RowID >> 16)
-		oldSlotNum := uint16(record.RowID & 0xFFFF)
+		oldPageID, oldSlotNum := record.OldPageID, record.OldSlotNum
 		if oldPageID != record.PageID || oldSlotNum != record.SlotNum {
 			oldPage, err := e.bufferPool.FetchPage(oldPageID)
 			if err != nil {
@@ -355,8 +568,7 @@ func (e *Engine) applyUndo(record *wal.LogRecord) error {
 		e.bufferPool.UnpinPage(record.PageID, true)
 
 		// Undo update step 2: clear XMax on old version
-		oldPageID := types.PageID(record.RowID >> 16)
-		oldSlotNum := uint16(record.RowID & 0xFFFF)
+		oldPageID, oldSlotNum := record.OldPageID, record.OldSlotNum
 		oldPage, err := e.bufferPool.FetchPage(oldPageID)
 		if err != nil {
 			return fmt.Errorf("undo update fetch old page: %w", err)
@@ -405,21 +617,69 @@ func (e *Engine) applyUndo(record *wal.LogRecord) error {
 	return nil
 }
 
-// Execute executes a SQL statement.
+// Execute executes a SQL statement in the engine's own session. A
+// transaction begun this way is shared by every caller of Execute; use
+// NewSession for transactions of their own.
 func (e *Engine) Execute(sqlStr string) *sql.Result {
-	return e.executor.Execute(sqlStr)
+	return e.session.Execute(sqlStr)
+}
+
+// ExecuteScript runs the semicolon-separated statements of a script in the
+// engine's own session, like Session.ExecuteScript.
+func (e *Engine) ExecuteScript(script string) []*sql.Result {
+	return e.session.ExecuteScript(script)
 }
 
-// CreateIndex creates a B-Tree index on the specified column.
+// ImportCSV inserts the rows of a CSV file into a table in one
+// transaction, or in the open one, and returns how many it inserted. The
+// file's first line must name the table's columns in order. An empty field
+// is NULL; a field that does not parse as its column's type, or a row that
+// breaks a constraint, aborts the import with the line number, leaving the
+// table unchanged.
+func (e *Engine) ImportCSV(tableName, path string) (int, error) {
+	e.mu.Lock()
+	n, err := e.executor.ImportCSV(tableName, path)
+	e.mu.Unlock()
+	e.triggerAutoAnalyze()
+	return n, err
+}
+
+// RegisterFunction makes fn callable from SQL as name(args...), looked up
+// case-insensitively after the built-in functions. fn checks its own
+// argument count and types; an error it returns fails the statement.
+func (e *Engine) RegisterFunction(name string, fn func(args []types.Value) (types.Value, error)) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.executor.RegisterFunction(name, fn); err != nil {
+		return err
+	}
+	e.funcs = append(e.funcs, registeredFunc{name, fn})
+	for s := range e.sessions {
+		if s != e.session {
+			s.executor.RegisterFunction(name, fn)
+		}
+	}
+	return nil
+}
+
+// CreateIndex creates a B-Tree index on the specified column. A table can
+// have an index on each of its columns.
 func (e *Engine) CreateIndex(tableName, columnName string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.readOnly {
+		return storage.ErrReadOnly
+	}
+
 	tableID, ok := e.catalog.GetTableID(tableName)
 	if !ok {
 		return fmt.Errorf("table %s not found", tableName)
 	}
 
 	// Check if index already exists
-	if _, exists := e.indexes[tableID]; exists {
-		return fmt.Errorf("index already exists for table %s", tableName)
+	if _, exists := e.indexes[tableID][columnName]; exists {
+		return fmt.Errorf("index already exists on %s(%s)", tableName, columnName)
 	}
 
 	// Verify column exists
@@ -427,6 +687,10 @@ func (e *Engine) CreateIndex(tableName, columnName string) error {
 	columnFound := false
 	for _, col := range schema.Columns {
 		if col.Name == columnName {
+			// Keys are zero-padded, so x'01' and x'0100' would collide
+			if col.Type == types.ValueTypeBytes {
+				return fmt.Errorf("cannot index BLOB column %s", columnName)
+			}
 			columnFound = true
 			break
 		}
@@ -435,53 +699,113 @@ func (e *Engine) CreateIndex(tableName, columnName string) error {
 		return fmt.Errorf("column %s not found in table %s", columnName, tableName)
 	}
 
-	// Create B-Tree
-	btree, err := index.NewBTree(e.bufferPool, 64)
+	btree, err := e.buildIndex(tableID, schema, columnName)
 	if err != nil {
 		return err
 	}
+	e.setIndex(tableID, columnName, btree)
+	e.catalog.SetIndexRoot(tableID, btree.GetRootPageID(), columnName)
 
-	// Index existing data
-	heap := e.catalog.GetTableHeap(tableID)
-	tuples, err := heap.Scan()
+	return nil
+}
+
+// buildIndex creates a B-Tree over a column of the table's live tuples.
+func (e *Engine) buildIndex(tableID uint32, schema *types.Schema, columnName string) (*index.BTree, error) {
+	btree, err := index.NewBTree(e.bufferPool, 64)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	tuples, err := e.catalog.GetTableHeap(tableID).Scan()
+	if err != nil {
+		return nil, err
+	}
 	for _, t := range tuples {
 		// Skip dead tuples
 		if t.Tuple.IsDeleted() {
 			continue
 		}
-
 		rowData, err := types.DeserializeRow(schema, t.Tuple.Data)
 		if err != nil {
 			continue
 		}
-
 		val, ok := rowData[columnName]
 		if !ok {
 			continue
 		}
+		rid := index.RID{PageID: t.PageID, SlotNum: t.SlotNum, TableID: tableID}
+		btree.Insert(index.EntryKey(schema, columnName, val, rid, 64), rid)
+	}
+	return btree, nil
+}
 
-		key := index.EncodeKey(val, 64)
-		rid := index.RID{
-			PageID:  t.PageID,
-			SlotNum: t.SlotNum,
-			TableID: tableID,
-		}
+// DropIndex removes the index on a table's column: the in-memory B-Tree,
+// the catalog's record of it, and its pages, which go to the free list. A
+// PRIMARY KEY or UNIQUE column the index served is still enforced, by
+// scanning the table.
+func (e *Engine) DropIndex(tableName, columnName string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-		btree.Insert(key, rid)
+	if e.readOnly {
+		return storage.ErrReadOnly
 	}
 
-	e.indexes[tableID] = btree
-	e.catalog.SetIndexRoot(tableID, btree.GetRootPageID(), columnName)
+	tableID, ok := e.catalog.GetTableID(tableName)
+	if !ok {
+		return fmt.Errorf("table %s not found", tableName)
+	}
+	btree, ok := e.indexes[tableID][columnName]
+	if !ok {
+		return fmt.Errorf("no index on %s(%s)", tableName, columnName)
+	}
 
+	e.bufferPool.UnpinPermanent(btree.GetRootPageID())
+	delete(e.indexes[tableID], columnName)
+	if len(e.indexes[tableID]) == 0 {
+		delete(e.indexes, tableID)
+	}
+	e.catalog.ClearIndexRoot(tableID, columnName)
+
+	// The pages can be reused once the catalog on disk no longer names
+	// the root
+	if err := e.flushPages(); err != nil {
+		return fmt.Errorf("drop index: %w", err)
+	}
+	if err := e.freeIndexPages(btree); err != nil {
+		return fmt.Errorf("drop index: %w", err)
+	}
+	return nil
+}
+
+// freeIndexPages puts the pages of a B-Tree that is no longer used on the
+// free list. Index pages are not logged, so they can be reused without a
+// checkpoint.
+func (e *Engine) freeIndexPages(btree *index.BTree) error {
+	pages, err := btree.Pages()
+	if err != nil {
+		return fmt.Errorf("walk index: %w", err)
+	}
+	for _, pageID := range pages {
+		if err := e.bufferPool.FreePage(pageID); err != nil {
+			return fmt.Errorf("free index page %d: %w", pageID, err)
+		}
+	}
 	return nil
 }
 
 // Checkpoint creates a checkpoint.
 func (e *Engine) Checkpoint() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.readOnly {
+		return storage.ErrReadOnly
+	}
+	return e.checkpoint()
+}
+
+func (e *Engine) checkpoint() error {
 	// Get dirty pages BEFORE flushing
 	dirtyPages := e.bufferPool.GetDirtyPages()
 	activeTxns := e.txnManager.GetActiveTxns()
@@ -492,24 +816,103 @@ func (e *Engine) Checkpoint() error {
 	}
 
 	// Then flush dirty pages
-	if err := e.bufferPool.FlushAllPages(); err != nil {
+	if err := e.flushPages(); err != nil {
 		return err
 	}
 
 	// Write checkpoint record
-	_, err := e.walWriter.LogCheckpoint(activeTxns, dirtyPages)
-	return err
+	lsn, err := e.walWriter.LogCheckpoint(activeTxns, dirtyPages)
+	if err != nil {
+		return err
+	}
+	e.checkpoints++
+
+	// With the pages on disk, recovery needs the log only from the oldest
+	// RecLSN and, to roll them back, from the active transactions' BEGIN
+	return e.walWriter.Truncate(truncationLSN(lsn, dirtyPages, e.txnManager.OldestActiveLSN()))
+}
+
+// truncationLSN returns the first record a checkpoint at checkpointLSN
+// must keep in the log: the smallest of its dirty pages' RecLSNs, the
+// oldest active transaction's BEGIN and the checkpoint itself. Pages that
+// were never logged have no RecLSN and need no record.
+func truncationLSN(checkpointLSN types.LSN, dirtyPages map[types.PageID]types.LSN, oldestActive types.LSN) types.LSN {
+	keep := checkpointLSN
+	for _, recLSN := range dirtyPages {
+		if recLSN != types.InvalidLSN && recLSN < keep {
+			keep = recLSN
+		}
+	}
+	if oldestActive != types.InvalidLSN && oldestActive < keep {
+		keep = oldestActive
+	}
+	return keep
 }
 
-// Close shuts down the engine.
+// Close shuts down the engine. Transactions still in progress are rolled
+// back, with an ABORT record in the WAL, rather than left for recovery.
 func (e *Engine) Close() error {
-	// Flush any pending writes
+	e.stopCheckpointer()
+	e.stopAutoAnalyze()
+	e.abortActiveTxns()
+	return e.close()
+}
+
+// Shutdown closes the engine once in-flight transactions have finished. It
+// waits until no transaction is active or ctx is done; transactions still
+// running then are rolled back as in Close. The engine is closed either way,
+// and ctx's error is returned if any transaction had to be aborted.
+func (e *Engine) Shutdown(ctx context.Context) error {
+	e.stopCheckpointer()
+	e.stopAutoAnalyze()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for len(e.txnManager.GetActiveTxns()) > 0 {
+		select {
+		case <-ctx.Done():
+			aborted := e.abortActiveTxns()
+			if err := e.close(); err != nil {
+				return err
+			}
+			return fmt.Errorf("shutdown: aborted %d active transactions: %w", aborted, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return e.close()
+}
+
+// abortActiveTxns rolls back every transaction in progress and returns how
+// many there were. The sessions also drop their temp tables.
+func (e *Engine) abortActiveTxns() int {
+	aborted := 0
+	e.mu.Lock()
+	for s := range e.sessions {
+		if s.executor.HasTransaction() {
+			aborted++
+		}
+		s.executor.Close()
+	}
+	e.mu.Unlock()
+
+	for _, txnID := range e.txnManager.GetActiveTxns() {
+		if t := e.txnManager.GetTransaction(txnID); t != nil && e.txnManager.Rollback(t) == nil {
+			aborted++
+		}
+	}
+	return aborted
+}
+
+// close flushes and closes the engine's files.
+func (e *Engine) close() error {
+
+	// Stop the background flusher and flush any pending writes
+	e.walWriter.StopFlusher()
 	if err := e.walWriter.Flush(); err != nil {
 		return err
 	}
 
 	// Flush all dirty pages
-	if err := e.bufferPool.FlushAllPages(); err != nil {
+	if err := e.flushPages(); err != nil {
 		return err
 	}
 
@@ -522,10 +925,27 @@ func (e *Engine) Close() error {
 	if err := e.diskManager.Close(); err != nil {
 		return err
 	}
+	if e.catalogDisk != nil {
+		if err := e.catalogDisk.Close(); err != nil {
+			return err
+		}
+	}
 
 	return e.walWriter.Close()
 }
 
+// flushPages writes every dirty page to data.db and, if the catalog has
+// its own file, the catalog page to that file.
+func (e *Engine) flushPages() error {
+	if err := e.bufferPool.FlushAllPages(); err != nil {
+		return err
+	}
+	if e.catalogPool != nil {
+		return e.catalogPool.FlushAllPages()
+	}
+	return nil
+}
+
 // Stats returns engine statistics.
 func (e *Engine) Stats() map[string]interface{} {
 	hits, misses, cached := e.bufferPool.Stats()
@@ -534,17 +954,34 @@ func (e *Engine) Stats() map[string]interface{} {
 		hitRate = float64(hits) / float64(hits+misses) * 100
 	}
 
-	return map[string]interface{}{
-		"wal_current_lsn":    e.walWriter.GetCurrentLSN(),
-		"wal_flushed_lsn":    e.walWriter.GetFlushedLSN(),
-		"active_txns":        len(e.txnManager.GetActiveTxns()),
-		"buffer_pool_hits":   hits,
-		"buffer_pool_misses": misses,
-		"buffer_pool_cached": cached,
-		"buffer_hit_rate":    fmt.Sprintf("%.1f%%", hitRate),
-		"disk_pages":         e.diskManager.GetNumPages(),
-		"tables":             len(e.catalog.GetAllTables()),
+	stats := map[string]interface{}{
+		"wal_current_lsn":        e.walWriter.GetCurrentLSN(),
+		"wal_flushed_lsn":        e.walWriter.GetFlushedLSN(),
+		"active_txns":            len(e.txnManager.GetActiveTxns()),
+		"buffer_pool_hits":       hits,
+		"buffer_pool_misses":     misses,
+		"buffer_pool_cached":     cached,
+		"buffer_pool_prefetches": e.bufferPool.Prefetches(),
+		"buffer_hit_rate":        fmt.Sprintf("%.1f%%", hitRate),
+		"disk_pages":             e.diskManager.GetNumPages(),
+		"tables":                 len(e.catalog.GetAllTables()),
+	}
+
+	if size, err := e.walWriter.FileSize(); err == nil {
+		stats["wal_size_bytes"] = size
 	}
+	walRecords, walBytes := e.walWriter.SinceCheckpoint()
+	stats["wal_records_since_checkpoint"] = walRecords
+	stats["wal_bytes_since_checkpoint"] = walBytes
+
+	if e.queryCache != nil {
+		qcHits, qcMisses, qcCached := e.queryCache.Stats()
+		stats["query_cache_hits"] = qcHits
+		stats["query_cache_misses"] = qcMisses
+		stats["query_cache_cached"] = qcCached
+	}
+
+	return stats
 }
 
 // GetCatalog returns the catalog (for executor).
@@ -557,9 +994,55 @@ func (e *Engine) GetBufferPool() *storage.BufferPool {
 	return e.bufferPool
 }
 
-// GetIndex returns the index for a table.
-func (e *Engine) GetIndex(tableID uint32) *index.BTree {
-	return e.indexes[tableID]
+// GetIndex returns the index on a table's column, or nil if it has none.
+func (e *Engine) GetIndex(tableID uint32, columnName string) *index.BTree {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.indexes[tableID][columnName]
+}
+
+// TablePages returns the header statistics of each page of a table's heap,
+// in chain order. It only reads pages.
+func (e *Engine) TablePages(tableName string) ([]storage.HeapPageStats, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tableID, ok := e.catalog.GetTableID(tableName)
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", tableName)
+	}
+	return e.catalog.GetTableHeap(tableID).PageStats()
+}
+
+// ScanTable calls fn with the row ID and column values of every row of a
+// table visible to a fresh snapshot, reading the heap a page at a time
+// instead of collecting the rows first. It stops at the first error fn
+// returns and returns that error. fn runs with the engine locked, so it
+// must not call back into the engine.
+func (e *Engine) ScanTable(name string, fn func(rowID uint64, values map[string]types.Value) error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tableID, ok := e.catalog.GetTableID(name)
+	if !ok {
+		return fmt.Errorf("table %s does not exist", name)
+	}
+	schema := e.catalog.GetSchema(name)
+	heap := e.catalog.GetTableHeap(tableID)
+
+	t := e.txnManager.Begin()
+	defer e.txnManager.Commit(t)
+
+	return heap.ForEach(func(tuple *storage.TupleWithRID) error {
+		if !t.Snapshot.IsVisible(tuple.Tuple) {
+			return nil
+		}
+		values, err := types.DeserializeRow(schema, tuple.Tuple.Data)
+		if err != nil {
+			return fmt.Errorf("scan %s: row at (page=%d, slot=%d): %w", name, tuple.PageID, tuple.SlotNum, err)
+		}
+		return fn(tuple.Tuple.RowID, values)
+	})
 }
 
 // VacuumResult holds the result of a VACUUM operation.
@@ -578,14 +1061,50 @@ func (r *VacuumResult) TotalRemoved() int {
 
 // VacuumTableStats holds per-table VACUUM statistics.
 type VacuumTableStats struct {
-	TableName     string
-	TuplesScanned int
-	TuplesRemoved int
+	TableName           string
+	TuplesScanned       int
+	TuplesRemoved       int
+	IndexEntriesRemoved int // Index keys that pointed at a removed tuple
 }
 
-// Vacuum removes dead tuples from all tables.
+// vacuumHorizon returns the transaction ID below which a committed deleter
+// makes a tuple version removable: the oldest active transaction, lowered
+// to honour the retention window.
+func (e *Engine) vacuumHorizon() types.TxnID {
+	horizon := e.txnManager.GetGlobalXmin()
+	if n := e.retention.Txns; n > 0 {
+		last := uint64(e.txnManager.LastTxnID())
+		keepFrom := types.TxnID(0)
+		if last >= n {
+			keepFrom = types.TxnID(last - n + 1)
+		}
+		if keepFrom < horizon {
+			horizon = keepFrom
+		}
+	}
+	if e.retention.Duration > 0 {
+		since := time.Now().Add(-e.retention.Duration)
+		if keepFrom := e.txnManager.OldestCommittedSince(since); keepFrom < horizon {
+			horizon = keepFrom
+		}
+	}
+	return horizon
+}
+
+// Vacuum removes dead tuples from all tables. An index key that points at
+// a removed tuple is deleted from the existing B-Tree; the rest of the tree
+// is left as it is. Removing a tuple does not move the others, since a page
+// keeps its slot numbers when it compacts, so no other key needs its RID
+// changed.
 func (e *Engine) Vacuum() (*VacuumResult, error) {
-	globalXmin := e.txnManager.GetGlobalXmin()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.readOnly {
+		return nil, storage.ErrReadOnly
+	}
+
+	globalXmin := e.vacuumHorizon()
 	result := &VacuumResult{}
 
 	for _, tableName := range e.catalog.GetAllTables() {
@@ -594,6 +1113,7 @@ func (e *Engine) Vacuum() (*VacuumResult, error) {
 			continue
 		}
 
+		schema := e.catalog.GetSchema(tableName)
 		heap := e.catalog.GetTableHeap(tableID)
 		tuples, err := heap.Scan()
 		if err != nil {
@@ -608,7 +1128,8 @@ func (e *Engine) Vacuum() (*VacuumResult, error) {
 		for _, t := range tuples {
 			// Dead tuple conditions:
 			// 1. XMax is set (deleted/updated)
-			// 2. XMax < globalXmin (invisible to all active txns)
+			// 2. XMax < globalXmin (invisible to all active txns and
+			//    outside the retention window)
 			// 3. XMax txn actually committed (not aborted)
 			if t.Tuple.XMax != types.InvalidTxnID &&
 				t.Tuple.XMax < globalXmin &&
@@ -617,67 +1138,215 @@ func (e *Engine) Vacuum() (*VacuumResult, error) {
 					return nil, fmt.Errorf("vacuum delete %s: %w", tableName, err)
 				}
 				stats.TuplesRemoved++
+				stats.IndexEntriesRemoved += e.removeIndexEntries(tableID, schema, t)
 			}
 		}
 
 		result.Tables = append(result.Tables, stats)
 	}
 
-	// Rebuild indexes for tables that have them
+	// Flush all modified pages
+	if err := e.flushPages(); err != nil {
+		return nil, fmt.Errorf("vacuum flush: %w", err)
+	}
+
+	// Free the overflow pages of the removed tuples and the pages the
+	// deletes merged away, now that the heaps, the trees and the catalog
+	// on disk no longer name them
 	for _, tableName := range e.catalog.GetAllTables() {
-		tableID, ok := e.catalog.GetTableID(tableName)
+		tableID, _ := e.catalog.GetTableID(tableName)
+		for _, pageID := range e.catalog.GetTableHeap(tableID).TakeUnlinked() {
+			if err := e.bufferPool.FreePage(pageID); err != nil {
+				return nil, fmt.Errorf("vacuum free overflow page %d: %w", pageID, err)
+			}
+		}
+	}
+	for _, trees := range e.indexes {
+		for _, bt := range trees {
+			for _, pageID := range bt.TakeUnlinked() {
+				if err := e.bufferPool.FreePage(pageID); err != nil {
+					return nil, fmt.Errorf("vacuum free index page %d: %w", pageID, err)
+				}
+			}
+		}
+	}
+
+	// Clean up committed txn records that are no longer needed
+	e.txnManager.PruneCommittedBefore(globalXmin)
+
+	return result, nil
+}
+
+// removeIndexEntries deletes the keys of a removed tuple from the indexes
+// of its table and returns how many it deleted. A key is only deleted while
+// it still points at the tuple: a later version of the row, or another row
+// with the same key, may have taken it over.
+func (e *Engine) removeIndexEntries(tableID uint32, schema *types.Schema, t *storage.TupleWithRID) int {
+	trees := e.indexes[tableID]
+	if len(trees) == 0 {
+		return 0
+	}
+	rowData, err := types.DeserializeRow(schema, t.Tuple.Data)
+	if err != nil {
+		return 0
+	}
+
+	removed := 0
+	for colName, bt := range trees {
+		val, ok := rowData[colName]
 		if !ok {
 			continue
 		}
-		if _, exists := e.indexes[tableID]; !exists {
-			continue
+		key := index.EntryKey(schema, colName, val, index.RID{PageID: t.PageID, SlotNum: t.SlotNum, TableID: tableID}, 64)
+		if rid, found := bt.Search(key); found && rid.PageID == t.PageID && rid.SlotNum == t.SlotNum {
+			if bt.Delete(key) {
+				removed++
+			}
 		}
-		colName, ok := e.catalog.GetIndexColumn(tableID)
+	}
+	return removed
+}
+
+// Reindex rebuilds every index from the live tuples of its table and frees
+// the pages of the old trees. Vacuum keeps the indexes up to date on its
+// own; Reindex is for compacting a tree that many deletes have left
+// sparse, and costs a write of every page of every index.
+func (e *Engine) Reindex() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.readOnly {
+		return storage.ErrReadOnly
+	}
+
+	var oldTrees []*index.BTree
+	for _, tableName := range e.catalog.GetAllTables() {
+		tableID, ok := e.catalog.GetTableID(tableName)
 		if !ok {
 			continue
 		}
-
 		schema := e.catalog.GetSchema(tableName)
-		heap := e.catalog.GetTableHeap(tableID)
+		for _, colName := range e.catalog.GetIndexColumns(tableID) {
+			oldBtree, exists := e.indexes[tableID][colName]
+			if !exists {
+				continue
+			}
+			newBtree, err := e.buildIndex(tableID, schema, colName)
+			if err != nil {
+				return fmt.Errorf("reindex %s(%s): %w", tableName, colName, err)
+			}
 
-		newBtree, err := index.NewBTree(e.bufferPool, 64)
-		if err != nil {
-			return nil, fmt.Errorf("vacuum rebuild index %s: %w", tableName, err)
+			// The old tree is abandoned, so its root need not stay cached
+			e.bufferPool.UnpinPermanent(oldBtree.GetRootPageID())
+			oldTrees = append(oldTrees, oldBtree)
+			e.setIndex(tableID, colName, newBtree)
+			e.catalog.SetIndexRoot(tableID, newBtree.GetRootPageID(), colName)
 		}
+	}
 
-		tuples, err := heap.Scan()
-		if err != nil {
-			return nil, fmt.Errorf("vacuum rescan %s: %w", tableName, err)
+	if err := e.flushPages(); err != nil {
+		return fmt.Errorf("reindex flush: %w", err)
+	}
+
+	// Free the old trees' pages only now that the catalog on disk names
+	// the new roots
+	for _, tree := range oldTrees {
+		if err := e.freeIndexPages(tree); err != nil {
+			return fmt.Errorf("reindex: %w", err)
 		}
+	}
+	return nil
+}
 
-		for _, t := range tuples {
-			if t.Tuple.IsDeleted() {
-				continue
-			}
-			rowData, err := types.DeserializeRow(schema, t.Tuple.Data)
+// RepairResult holds the result of a Repair run.
+type RepairResult struct {
+	PagesScanned int            // Pages allocated in the data file
+	Reclaimed    []types.PageID // Orphaned pages moved to the free list
+}
+
+// Repair finds pages that are allocated in the data file but not reachable
+// from the catalog, a table heap chain or its tuples' overflow pages, an
+// index tree or the free list, and puts them on the free list. Such orphans
+// are left behind by a crash between allocating a page and linking it, by
+// dropped tables whose writes were logged, and by the overflow pages of
+// inserts that recovery undid.
+func (e *Engine) Repair() (*RepairResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.readOnly {
+		return nil, storage.ErrReadOnly
+	}
+
+	if active := e.txnManager.GetActiveTxns(); len(active) > 0 {
+		return nil, fmt.Errorf("repair needs no active transactions, %d running", len(active))
+	}
+
+	// Persist the current page links before judging reachability on disk
+	if err := e.flushPages(); err != nil {
+		return nil, fmt.Errorf("repair flush: %w", err)
+	}
+
+	reachable := make(map[types.PageID]bool)
+	if e.catalogPool == nil {
+		reachable[e.catalog.GetCatalogPageID()] = true
+	}
+	for _, tableName := range e.catalog.GetAllTables() {
+		tableID, ok := e.catalog.GetTableID(tableName)
+		if !ok {
+			continue
+		}
+		heap := e.catalog.GetTableHeap(tableID)
+		pages, err := heap.PageStats()
+		if err != nil {
+			return nil, fmt.Errorf("repair walk heap %s: %w", tableName, err)
+		}
+		for _, p := range pages {
+			reachable[p.PageID] = true
+		}
+		overflow, err := heap.OverflowPages()
+		if err != nil {
+			return nil, fmt.Errorf("repair walk overflow pages of %s: %w", tableName, err)
+		}
+		for _, pageID := range overflow {
+			reachable[pageID] = true
+		}
+	}
+	for tableID, trees := range e.indexes {
+		for column, bt := range trees {
+			pages, err := bt.Pages()
 			if err != nil {
-				continue
+				return nil, fmt.Errorf("repair walk index on %s of table %d: %w", column, tableID, err)
 			}
-			val, ok := rowData[colName]
-			if !ok {
-				continue
+			for _, pageID := range pages {
+				reachable[pageID] = true
 			}
-			key := index.EncodeKey(val, 64)
-			rid := index.RID{PageID: t.PageID, SlotNum: t.SlotNum, TableID: tableID}
-			newBtree.Insert(key, rid)
 		}
-
-		e.indexes[tableID] = newBtree
-		e.catalog.SetIndexRoot(tableID, newBtree.GetRootPageID(), colName)
 	}
-
-	// Flush all modified pages
-	if err := e.bufferPool.FlushAllPages(); err != nil {
-		return nil, fmt.Errorf("vacuum flush: %w", err)
+	free, err := e.diskManager.FreePages()
+	if err != nil {
+		return nil, fmt.Errorf("repair walk free list: %w", err)
+	}
+	for _, pageID := range free {
+		reachable[pageID] = true
 	}
 
-	// Clean up committed txn records that are no longer needed
-	e.txnManager.PruneCommittedBefore(globalXmin)
+	result := &RepairResult{PagesScanned: int(e.diskManager.GetNumPages())}
+	for i := 0; i < result.PagesScanned; i++ {
+		pageID := types.PageID(i)
+		if reachable[pageID] {
+			continue
+		}
+		if err := e.bufferPool.FreePage(pageID); err != nil {
+			return nil, fmt.Errorf("repair free page %d: %w", pageID, err)
+		}
+		result.Reclaimed = append(result.Reclaimed, pageID)
+	}
 
+	// Start redo after this point so recovery never replays older records
+	// into a page that has since been reused
+	if err := e.checkpoint(); err != nil {
+		return nil, fmt.Errorf("repair checkpoint: %w", err)
+	}
 	return result, nil
 }
@@ -1,9 +1,25 @@
 package engine
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"minidb/internal/index"
+	"minidb/internal/sql"
+	"minidb/internal/storage"
+	"minidb/internal/wal"
+	"minidb/pkg/types"
+	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func newTestEngine(t *testing.T) *Engine {
@@ -43,6 +59,45 @@ func TestEngineReopenEmpty(t *testing.T) {
 	defer e2.Close()
 }
 
+func TestEngineOpenOldDataDirectory(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.Execute("CREATE TABLE t (id INT, name TEXT)")
+	e.Execute("INSERT INTO t VALUES (1, 'a')")
+	e.Close()
+
+	// Stamp the files with the first release's versions
+	setVersion := func(name string) {
+		t.Helper()
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		defer f.Close()
+		if _, err := f.WriteAt([]byte{1, 0, 0, 0}, 8); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	setVersion("data.db")
+	setVersion("wal.log")
+
+	// The log is refused first, and removing it does not get past the
+	// data file
+	if _, err := New(Config{DataDir: dir, BufferPoolSize: 100}); err == nil || !strings.Contains(err.Error(), "unsupported WAL version 1") {
+		t.Errorf("New() with a version 1 log: error = %v, want the WAL version refused", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "wal.log")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err == nil || !strings.Contains(err.Error(), "unsupported data file version 1") {
+		t.Errorf("New() without a log: error = %v, want the data file version refused", err)
+	}
+}
+
 func TestEngineCreateTable(t *testing.T) {
 	e := newTestEngine(t)
 	defer e.Close()
@@ -79,6 +134,58 @@ func TestEngineInsertAndSelect(t *testing.T) {
 	}
 }
 
+func TestEngineRowsStoredInBinaryFormat(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT, active BOOL, note TEXT)")
+	if r := e.Execute("INSERT INTO users VALUES (1, 'alice', true, NULL)"); r.Error != nil {
+		t.Fatalf("INSERT error = %v", r.Error)
+	}
+	if r := e.Execute("UPDATE users SET name = 'bob' WHERE id = 1"); r.Error != nil {
+		t.Fatalf("UPDATE error = %v", r.Error)
+	}
+
+	schema := e.catalog.GetSchema("users")
+	tableID, _ := e.catalog.GetTableID("users")
+	tuples, err := e.catalog.GetTableHeap(tableID).Scan()
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if len(tuples) != 2 {
+		t.Fatalf("tuples = %d, want the inserted and updated versions", len(tuples))
+	}
+
+	// Both versions hold SerializeRow output, not JSON
+	for i, name := range []string{"alice", "bob"} {
+		row := map[string]types.Value{
+			"id":     {Type: types.ValueTypeInt, IntVal: 1},
+			"name":   {Type: types.ValueTypeString, StrVal: name},
+			"active": {Type: types.ValueTypeBool, BoolVal: true},
+			"note":   {IsNull: true},
+		}
+		want, err := types.SerializeRow(schema, row)
+		if err != nil {
+			t.Fatalf("SerializeRow() error = %v", err)
+		}
+		data := tuples[i].Tuple.Data
+		if !bytes.Equal(data, want) {
+			t.Errorf("version %d Data = %x, want %x", i, data, want)
+		}
+		if json.Valid(data) {
+			t.Errorf("version %d Data %q is JSON", i, data)
+		}
+	}
+
+	r := e.Execute("SELECT name, active, note FROM users")
+	if r.Error != nil || len(r.Rows) != 1 {
+		t.Fatalf("SELECT rows = %v, error = %v", r.Rows, r.Error)
+	}
+	if got := r.Rows[0].Values; got[0].StrVal != "bob" || !got[1].BoolVal || !got[2].IsNull {
+		t.Errorf("row = %v, want [bob true NULL]", got)
+	}
+}
+
 func TestEngineSelectWhere(t *testing.T) {
 	e := newTestEngine(t)
 	defer e.Close()
@@ -122,6 +229,99 @@ func TestEngineUpdate(t *testing.T) {
 	}
 }
 
+func TestEngineUpdateUnchangedRows(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+	e.Execute("INSERT INTO users VALUES (3, 'bob')")
+
+	tableID, _ := e.catalog.GetTableID("users")
+	versions := func() int {
+		tuples, err := e.catalog.GetTableHeap(tableID).Scan()
+		if err != nil {
+			t.Fatalf("Scan() error = %v", err)
+		}
+		return len(tuples)
+	}
+
+	// Nothing changes: no new versions and no UPDATE records
+	before := versions()
+	lsn := e.walWriter.GetCurrentLSN()
+	result := e.Execute("UPDATE users SET name = 'bob' WHERE id > 1")
+	if result.Error != nil {
+		t.Fatalf("UPDATE error = %v", result.Error)
+	}
+	if result.Message != "UPDATE 2 (0 changed)" {
+		t.Errorf("Message = %q, want UPDATE 2 (0 changed)", result.Message)
+	}
+	if got := versions(); got != before {
+		t.Errorf("tuple versions = %d, want %d", got, before)
+	}
+	if records := e.walWriter.GetCurrentLSN() - lsn; records > 2 {
+		t.Errorf("no-op UPDATE wrote %d WAL records, want only BEGIN and COMMIT", records)
+	}
+
+	result = e.Execute("UPDATE users SET name = 'bob'")
+	if result.Message != "UPDATE 3 (1 changed)" {
+		t.Errorf("Message = %q, want UPDATE 3 (1 changed)", result.Message)
+	}
+	if got := versions(); got != before+1 {
+		t.Errorf("tuple versions = %d, want %d", got, before+1)
+	}
+	result = e.Execute("SELECT id FROM users WHERE name = 'bob'")
+	if len(result.Rows) != 3 {
+		t.Errorf("rows named bob = %d, want 3", len(result.Rows))
+	}
+}
+
+func TestEngineRowsAffected(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	r := e.Execute("INSERT INTO users VALUES (1, 'alice'), (2, 'bob'), (3, 'bob'), (4, 'carol')")
+	if r.Error != nil {
+		t.Fatalf("INSERT error = %v", r.Error)
+	}
+	if r.RowsAffected != 4 {
+		t.Errorf("INSERT RowsAffected = %d, want 4", r.RowsAffected)
+	}
+	tableID, _ := e.catalog.GetTableID("users")
+	if r.LastInsert == nil || r.LastInsert.TableID != tableID {
+		t.Fatalf("INSERT LastInsert = %+v, want a row of table %d", r.LastInsert, tableID)
+	}
+	tuple, err := e.catalog.GetTableHeap(tableID).Get(r.LastInsert.PageID, r.LastInsert.SlotNum)
+	if err != nil {
+		t.Fatalf("Get(LastInsert) error = %v", err)
+	}
+	if row, _ := types.DeserializeRow(e.catalog.GetSchema("users"), tuple.Data); row["id"].IntVal != 4 {
+		t.Errorf("LastInsert points at id %d, want 4", row["id"].IntVal)
+	}
+
+	// UPDATE counts the rows it matched, changed or not
+	tests := []struct {
+		sql  string
+		want int
+	}{
+		{"UPDATE users SET name = 'bob' WHERE id > 1", 3},
+		{"UPDATE users SET name = 'dave' WHERE id = 99", 0},
+		{"DELETE FROM users WHERE name = 'bob'", 3},
+		{"SELECT * FROM users", 0},
+	}
+	for _, tt := range tests {
+		r := e.Execute(tt.sql)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", tt.sql, r.Error)
+		}
+		if r.RowsAffected != tt.want || r.LastInsert != nil {
+			t.Errorf("%s: RowsAffected = %d, LastInsert = %+v, want %d and nil", tt.sql, r.RowsAffected, r.LastInsert, tt.want)
+		}
+	}
+}
+
 func TestEngineDelete(t *testing.T) {
 	e := newTestEngine(t)
 	defer e.Close()
@@ -161,6 +361,13 @@ func TestEngineExplicitTransaction(t *testing.T) {
 	e.Execute("INSERT INTO users VALUES (1, 'alice')")
 	e.Execute("INSERT INTO users VALUES (2, 'bob')")
 
+	// The transaction reads its own writes
+	e.Execute("UPDATE users SET name = 'carol' WHERE id = 2")
+	result = e.Execute("SELECT name FROM users WHERE id = 2")
+	if len(result.Rows) != 1 || result.Rows[0].Values[0].StrVal != "carol" {
+		t.Errorf("rows in transaction = %v, want [carol]", result.Rows)
+	}
+
 	result = e.Execute("COMMIT")
 	if result.Error != nil {
 		t.Fatalf("COMMIT error = %v", result.Error)
@@ -190,215 +397,349 @@ func TestEngineRollback(t *testing.T) {
 		t.Fatalf("ROLLBACK error = %v", result.Error)
 	}
 
-	// After rollback, the insert in the transaction should be gone
-	// Note: the heap-based INSERT already happened at the disk level,
-	// but the MVCC visibility should hide it from new transactions
+	// The rolled back row is deleted by its own transaction in the heap,
+	// so only the row inserted before BEGIN is left
 	result = e.Execute("SELECT * FROM users")
 	if result.Error != nil {
 		t.Fatalf("SELECT after ROLLBACK error = %v", result.Error)
 	}
-	// Row inserted before BEGIN should still be visible
-	if len(result.Rows) < 1 {
-		t.Error("pre-txn row should still be visible")
+	if len(result.Rows) != 1 || result.Rows[0].Values[0].IntVal != 1 {
+		t.Errorf("rows after ROLLBACK = %v, want only id 1", result.Rows)
 	}
 }
 
-func TestEngineAutoCommit(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+func TestEngineRollbackRevertsWrites(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
 
-	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	rows := func(e *Engine) string {
+		t.Helper()
+		r := e.Execute("SELECT id, name FROM users ORDER BY id")
+		if r.Error != nil {
+			t.Fatalf("SELECT error = %v", r.Error)
+		}
+		var out []string
+		for _, row := range r.Rows {
+			out = append(out, row.Values[0].String()+":"+row.Values[1].String())
+		}
+		return strings.Join(out, ",")
+	}
+	mustExec := func(e *Engine, sql string) {
+		t.Helper()
+		if r := e.Execute(sql); r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+	}
 
-	// Without BEGIN, each statement auto-commits
-	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	mustExec(e, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
+	mustExec(e, "INSERT INTO users VALUES (1, 'alice'), (2, 'bob'), (3, 'carol')")
 
-	result := e.Execute("SELECT * FROM users")
-	if result.Error != nil {
-		t.Fatalf("SELECT error = %v", result.Error)
-	}
-	if len(result.Rows) != 1 {
-		t.Errorf("rows = %d, want 1", len(result.Rows))
+	mustExec(e, "BEGIN")
+	mustExec(e, "UPDATE users SET name = 'robert' WHERE id = 2")
+	mustExec(e, "DELETE FROM users WHERE id = 3")
+	mustExec(e, "INSERT INTO users VALUES (4, 'dave')")
+	mustExec(e, "UPDATE users SET name = 'bobby' WHERE id = 2")
+	mustExec(e, "ROLLBACK")
+
+	if got := rows(e); got != "1:alice,2:bob,3:carol" {
+		t.Fatalf("rows after ROLLBACK = %s, want 1:alice,2:bob,3:carol", got)
 	}
-}
 
-func TestEnginePersistence(t *testing.T) {
-	dir := t.TempDir()
+	// The restored versions can be written again, and the key index points
+	// at them
+	mustExec(e, "UPDATE users SET name = 'carla' WHERE id = 3")
+	if r := e.Execute("INSERT INTO users VALUES (2, 'dup')"); r.Error == nil {
+		t.Error("duplicate of a row restored by ROLLBACK should be rejected")
+	}
+	mustExec(e, "INSERT INTO users VALUES (4, 'dan')")
 
-	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
-	if err != nil {
-		t.Fatalf("New() error = %v", err)
+	// An autocommit statement failing halfway leaves none of its changes
+	if r := e.Execute("UPDATE users SET id = 4 WHERE id < 3"); r.Error == nil {
+		t.Fatal("UPDATE creating duplicate keys should fail")
+	}
+	if r := e.Execute("INSERT INTO users VALUES (5, 'eve'), (1, 'dup')"); r.Error == nil {
+		t.Fatal("INSERT of a duplicate key should fail")
+	}
+	want := "1:alice,2:bob,3:carla,4:dan"
+	if got := rows(e); got != want {
+		t.Fatalf("rows after failed statements = %s, want %s", got, want)
 	}
 
-	e.Execute("CREATE TABLE users (id INT, name TEXT)")
-	e.Execute("INSERT INTO users VALUES (1, 'alice')")
-	e.Execute("INSERT INTO users VALUES (2, 'bob')")
-	e.Close()
+	// Recovery redoes the reverted state of a rolled back transaction and
+	// undoes an unfinished one
+	mustExec(e, "BEGIN")
+	mustExec(e, "DELETE FROM users WHERE id = 1")
+	mustExec(e, "INSERT INTO users VALUES (6, 'fay')")
+	mustExec(e, "ROLLBACK")
+	mustExec(e, "BEGIN")
+	mustExec(e, "UPDATE users SET name = 'x' WHERE id = 4")
+	e.walWriter.Flush()
+	e.walWriter.Close()
+	e.diskManager.Close()
 
-	// Reopen
 	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
 	if err != nil {
-		t.Fatalf("Reopen error = %v", err)
+		t.Fatalf("Reopen after crash error = %v", err)
 	}
 	defer e2.Close()
-
-	result := e2.Execute("SELECT * FROM users")
-	if result.Error != nil {
-		t.Fatalf("SELECT after reopen error = %v", result.Error)
-	}
-	if len(result.Rows) < 1 {
-		t.Error("data should survive close and reopen")
+	if got := rows(e2); got != want {
+		t.Errorf("rows after recovery = %s, want %s", got, want)
 	}
 }
 
-func TestEngineCheckpoint(t *testing.T) {
+func TestEngineSavepoint(t *testing.T) {
 	e := newTestEngine(t)
 	defer e.Close()
 
-	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
 	e.Execute("INSERT INTO users VALUES (1, 'alice')")
 
-	if err := e.Checkpoint(); err != nil {
-		t.Fatalf("Checkpoint() error = %v", err)
+	ids := func() string {
+		r := e.Execute("SELECT id, name FROM users ORDER BY id")
+		if r.Error != nil {
+			t.Fatalf("SELECT error = %v", r.Error)
+		}
+		var rows []string
+		for _, row := range r.Rows {
+			rows = append(rows, row.Values[0].String()+":"+row.Values[1].String())
+		}
+		return strings.Join(rows, ",")
+	}
+	mustExec := func(sql string) {
+		t.Helper()
+		if r := e.Execute(sql); r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
 	}
 
-	result := e.Execute("SELECT * FROM users")
-	if result.Error != nil {
-		t.Fatalf("SELECT after checkpoint error = %v", result.Error)
+	mustExec("BEGIN")
+	mustExec("INSERT INTO users VALUES (2, 'bob')")
+	mustExec("SAVEPOINT sp1")
+	mustExec("INSERT INTO users VALUES (3, 'carol')")
+	mustExec("UPDATE users SET name = 'robert' WHERE id = 2")
+	mustExec("DELETE FROM users WHERE id = 1")
+	if got := ids(); got != "2:robert,3:carol" {
+		t.Fatalf("rows before ROLLBACK TO = %s", got)
 	}
-	if len(result.Rows) != 1 {
-		t.Errorf("rows = %d, want 1", len(result.Rows))
+
+	mustExec("ROLLBACK TO sp1")
+	if got := ids(); got != "1:alice,2:bob" {
+		t.Errorf("rows after ROLLBACK TO = %s, want 1:alice,2:bob", got)
 	}
-}
 
-func TestEngineCreateIndex(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+	// The savepoint stays after a rollback to it, and the key index points
+	// at the restored versions again
+	mustExec("INSERT INTO users VALUES (4, 'dave')")
+	mustExec("SAVEPOINT sp2")
+	mustExec("DELETE FROM users WHERE id = 2")
+	mustExec("ROLLBACK TO SAVEPOINT sp1")
+	if r := e.Execute("INSERT INTO users VALUES (2, 'dup')"); r.Error == nil {
+		t.Error("duplicate of a row restored by ROLLBACK TO should be rejected")
+	}
+	if r := e.Execute("ROLLBACK TO sp2"); r.Error == nil {
+		t.Error("ROLLBACK TO a savepoint set after the one rolled back to should fail")
+	}
 
-	e.Execute("CREATE TABLE users (id INT, name TEXT)")
-	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	// RELEASE keeps the changes made since the savepoint
+	mustExec("SAVEPOINT sp3")
+	mustExec("INSERT INTO users VALUES (5, 'eve')")
+	mustExec("RELEASE SAVEPOINT sp3")
+	if r := e.Execute("ROLLBACK TO sp3"); r.Error == nil {
+		t.Error("ROLLBACK TO a released savepoint should fail")
+	}
+	mustExec("COMMIT")
 
-	if err := e.CreateIndex("users", "id"); err != nil {
-		t.Fatalf("CreateIndex() error = %v", err)
+	if got := ids(); got != "1:alice,2:bob,5:eve" {
+		t.Errorf("rows after COMMIT = %s, want 1:alice,2:bob,5:eve", got)
+	}
+	if r := e.Execute("SELECT name FROM users WHERE id = 2"); r.Error != nil || len(r.Rows) != 1 || r.Rows[0].Values[0].StrVal != "bob" {
+		t.Errorf("index lookup of restored row = %v, %v", r.Rows, r.Error)
 	}
 
-	// Index should be accessible
-	tableID, _ := e.catalog.GetTableID("users")
-	idx := e.GetIndex(tableID)
-	if idx == nil {
-		t.Error("index should exist after CreateIndex")
+	for _, sql := range []string{"SAVEPOINT sp1", "ROLLBACK TO sp1", "RELEASE sp1"} {
+		if r := e.Execute(sql); r.Error == nil {
+			t.Errorf("%s outside a transaction should fail", sql)
+		}
 	}
 }
 
-func TestEngineDoubleBegin(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
-
-	e.Execute("BEGIN")
-	result := e.Execute("BEGIN")
-	if result.Error == nil {
-		t.Error("double BEGIN should error")
+func TestEngineSavepointRecovery(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
 	}
-}
 
-func TestEngineCommitNoTransaction(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
 
-	result := e.Execute("COMMIT")
-	if result.Error == nil {
-		t.Error("COMMIT without BEGIN should error")
+	// A committed transaction with a partial rollback
+	for _, sql := range []string{
+		"BEGIN",
+		"INSERT INTO users VALUES (2, 'bob')",
+		"SAVEPOINT sp",
+		"INSERT INTO users VALUES (3, 'carol')",
+		"DELETE FROM users WHERE id = 1",
+		"ROLLBACK TO sp",
+		"COMMIT",
+	} {
+		if r := e.Execute(sql); r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
 	}
-}
 
-func TestEngineRollbackNoTransaction(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
-
-	result := e.Execute("ROLLBACK")
-	if result.Error == nil {
-		t.Error("ROLLBACK without BEGIN should error")
+	// An unfinished one that wrote again after its partial rollback
+	for _, sql := range []string{
+		"BEGIN",
+		"INSERT INTO users VALUES (4, 'dave')",
+		"SAVEPOINT sp",
+		"INSERT INTO users VALUES (5, 'eve')",
+		"ROLLBACK TO sp",
+		"INSERT INTO users VALUES (6, 'fay')",
+	} {
+		if r := e.Execute(sql); r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
 	}
-}
 
-func TestEngineSelectNonExistentTable(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+	// Crash without a graceful shutdown
+	e.walWriter.Flush()
+	e.walWriter.Close()
+	e.diskManager.Close()
 
-	result := e.Execute("SELECT * FROM nonexistent")
-	if result.Error == nil {
-		t.Error("SELECT from non-existent table should error")
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen after crash error = %v", err)
+	}
+	defer e2.Close()
+
+	r := e2.Execute("SELECT id FROM users ORDER BY id")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	var ids []string
+	for _, row := range r.Rows {
+		ids = append(ids, row.Values[0].String())
+	}
+	if got := strings.Join(ids, ","); got != "1,2" {
+		t.Errorf("ids after recovery = %s, want 1,2", got)
 	}
 }
 
-func TestEngineInsertColumnCountMismatch(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+func TestEngineMigrationRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
 
 	e.Execute("CREATE TABLE users (id INT, name TEXT)")
-
-	result := e.Execute("INSERT INTO users VALUES (1)")
-	if result.Error == nil {
-		t.Error("INSERT with wrong column count should error")
+	e.Execute("COMMENT ON TABLE users IS 'v1'")
+
+	// A migration that fails halfway
+	for _, sql := range []string{
+		"BEGIN",
+		"CREATE TABLE profiles (user_id INT, bio TEXT)",
+		"INSERT INTO profiles VALUES (1, 'hello')",
+		"COMMENT ON TABLE users IS 'v2'",
+	} {
+		if r := e.Execute(sql); r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+	}
+	if r := e.Execute("CREATE TABLE users (id INT)"); r.Error == nil {
+		t.Fatal("CREATE TABLE of an existing table should error")
+	}
+	if r := e.Execute("ROLLBACK"); r.Error != nil {
+		t.Fatalf("ROLLBACK error = %v", r.Error)
 	}
-}
-
-func TestEngineInsertNonExistentTable(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
 
-	result := e.Execute("INSERT INTO nonexistent VALUES (1)")
-	if result.Error == nil {
-		t.Error("INSERT into non-existent table should error")
+	if r := e.Execute("SELECT * FROM profiles"); r.Error == nil {
+		t.Error("profiles should not exist after ROLLBACK")
+	}
+	if r := e.Execute("DESCRIBE users"); r.Message != "TABLE users: v1" {
+		t.Errorf("DESCRIBE users message = %q, want the old comment", r.Message)
 	}
-}
 
-func TestEngineStats(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+	// A committed migration is kept
+	for _, sql := range []string{
+		"BEGIN",
+		"CREATE TABLE profiles (user_id INT, bio TEXT)",
+		"COMMIT",
+	} {
+		if r := e.Execute(sql); r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+	}
+	e.Close()
 
-	stats := e.Stats()
-	if stats == nil {
-		t.Fatal("Stats() returned nil")
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
 	}
-	if _, ok := stats["tables"]; !ok {
-		t.Error("Stats() should include 'tables'")
+	defer e2.Close()
+	if got := e2.catalog.GetAllTables(); len(got) != 2 {
+		t.Errorf("tables after reopen = %v, want users and profiles", got)
+	}
+	if r := e2.Execute("DESCRIBE users"); r.Message != "TABLE users: v1" {
+		t.Errorf("DESCRIBE users message after reopen = %q, want the old comment", r.Message)
 	}
 }
 
-func TestEngineDefaultBufferPoolSize(t *testing.T) {
+func TestEngineMigrationCrash(t *testing.T) {
 	dir := t.TempDir()
-	e, err := New(Config{DataDir: dir})
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
 	if err != nil {
 		t.Fatalf("New() error = %v", err)
 	}
-	defer e.Close()
-}
 
-func TestEngineCreateIndexNonExistentTable(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("BEGIN")
+	e.Execute("CREATE TABLE profiles (user_id INT, bio TEXT)")
+	e.Execute("INSERT INTO profiles VALUES (1, 'hello')")
 
-	err := e.CreateIndex("nonexistent", "id")
-	if err == nil {
-		t.Error("CreateIndex() on non-existent table should error")
+	// The checkpoint writes every page, but not the unfinished schema change
+	if err := e.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
+	e.walWriter.Close()
+	e.diskManager.Close()
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen after crash error = %v", err)
+	}
+	defer e2.Close()
+	if e2.catalog.GetSchema("profiles") != nil {
+		t.Error("profiles should not exist after a crash mid-migration")
+	}
+	if e2.catalog.GetSchema("users") == nil {
+		t.Error("users should survive the crash")
 	}
 }
 
-func TestEngineCreateDuplicateIndex(t *testing.T) {
+func TestEngineAutoCommit(t *testing.T) {
 	e := newTestEngine(t)
 	defer e.Close()
 
 	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+
+	// Without BEGIN, each statement auto-commits
 	e.Execute("INSERT INTO users VALUES (1, 'alice')")
-	e.CreateIndex("users", "id")
 
-	err := e.CreateIndex("users", "id")
-	if err == nil {
-		t.Error("duplicate CreateIndex() should error")
+	result := e.Execute("SELECT * FROM users")
+	if result.Error != nil {
+		t.Fatalf("SELECT error = %v", result.Error)
+	}
+	if len(result.Rows) != 1 {
+		t.Errorf("rows = %d, want 1", len(result.Rows))
 	}
 }
 
-func TestEngineRecoveryAfterCrash(t *testing.T) {
+func TestEnginePersistence(t *testing.T) {
 	dir := t.TempDir()
 
 	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
@@ -408,359 +749,5747 @@ func TestEngineRecoveryAfterCrash(t *testing.T) {
 
 	e.Execute("CREATE TABLE users (id INT, name TEXT)")
 	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+	e.Close()
 
-	// Simulate a "crash" by closing WAL and disk directly without graceful shutdown
-	e.walWriter.Flush()
-	e.walWriter.Close()
-	e.diskManager.Close()
-
-	// Reopen - should trigger recovery
+	// Reopen
 	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
 	if err != nil {
-		t.Fatalf("Reopen after crash error = %v", err)
+		t.Fatalf("Reopen error = %v", err)
 	}
 	defer e2.Close()
 
 	result := e2.Execute("SELECT * FROM users")
 	if result.Error != nil {
-		t.Fatalf("SELECT after recovery error = %v", result.Error)
+		t.Fatalf("SELECT after reopen error = %v", result.Error)
 	}
-	// Data should still be there after recovery
 	if len(result.Rows) < 1 {
-		t.Error("data should survive crash recovery")
+		t.Error("data should survive close and reopen")
 	}
 }
 
-func TestEngineDataDir(t *testing.T) {
+func TestEngineReadOnly(t *testing.T) {
 	dir := t.TempDir()
-	subdir := filepath.Join(dir, "nested", "db")
+	if _, err := New(Config{DataDir: filepath.Join(dir, "missing"), ReadOnly: true}); err == nil {
+		t.Error("opening a missing database read-only should fail")
+	}
 
-	e, err := New(Config{DataDir: subdir, BufferPoolSize: 10})
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
 	if err != nil {
-		t.Fatalf("New() with nested dir error = %v", err)
+		t.Fatalf("New() error = %v", err)
 	}
-	defer e.Close()
-}
+	e.Execute("CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice'), (2, 'bob')")
+	// Left unfinished by a crash, for recovery to undo
+	e.Execute("BEGIN")
+	e.Execute("INSERT INTO users VALUES (3, 'carol')")
+	e.walWriter.Flush()
+	e.bufferPool.FlushAllPages()
+	e.walWriter.Close()
+	e.diskManager.Close()
 
-func TestEngineGetCatalogAndBufferPool(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+	files := func() map[string][]byte {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string][]byte)
+		for _, entry := range entries {
+			if data, err := os.ReadFile(filepath.Join(dir, entry.Name())); err == nil {
+				out[entry.Name()] = data
+			}
+		}
+		return out
+	}
+	before := files()
 
-	if e.GetCatalog() == nil {
-		t.Error("GetCatalog() returned nil")
+	ro, err := New(Config{DataDir: dir, BufferPoolSize: 100, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("New(ReadOnly) error = %v", err)
 	}
-	if e.GetBufferPool() == nil {
-		t.Error("GetBufferPool() returned nil")
+	r := ro.Execute("SELECT name FROM users WHERE id >= 1 ORDER BY id")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
 	}
-}
-
-func TestEngineInvalidSQL(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
-
-	result := e.Execute("INVALID SQL")
-	if result.Error == nil {
-		t.Error("invalid SQL should error")
+	if len(r.Rows) != 2 || r.Rows[1].Values[0].StrVal != "bob" {
+		t.Errorf("rows = %v, want alice and bob", r.Rows)
 	}
-}
-
-func TestEngineCreateDuplicateTable(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
-
-	e.Execute("CREATE TABLE users (id INT)")
-	result := e.Execute("CREATE TABLE users (id INT)")
-	if result.Error == nil {
-		t.Error("duplicate CREATE TABLE should error")
+	if r := ro.Execute("SELECT name FROM users WHERE id = 2"); r.Error != nil || len(r.Rows) != 1 {
+		t.Errorf("index lookup = %v, %v", r.Rows, r.Error)
 	}
-}
 
-func TestEngineMultipleInsertSelect(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
-
-	e.Execute("CREATE TABLE items (id INT, name TEXT, price INT)")
+	for _, stmt := range []string{
+		"INSERT INTO users VALUES (4, 'dave')",
+		"UPDATE users SET name = 'x' WHERE id = 1",
+		"DELETE FROM users WHERE id = 1",
+		"CREATE TABLE t (a INT)",
+		"TRUNCATE users",
+	} {
+		if r := ro.Execute(stmt); !errors.Is(r.Error, storage.ErrReadOnly) {
+			t.Errorf("%s: error = %v, want ErrReadOnly", stmt, r.Error)
+		}
+	}
+	if err := ro.Checkpoint(); !errors.Is(err, storage.ErrReadOnly) {
+		t.Errorf("Checkpoint() error = %v, want ErrReadOnly", err)
+	}
+	if _, err := ro.Vacuum(); !errors.Is(err, storage.ErrReadOnly) {
+		t.Errorf("Vacuum() error = %v, want ErrReadOnly", err)
+	}
+	if err := ro.CreateIndex("users", "name"); !errors.Is(err, storage.ErrReadOnly) {
+		t.Errorf("CreateIndex() error = %v, want ErrReadOnly", err)
+	}
+	if err := ro.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
 
-	for i := 1; i <= 10; i++ {
-		result := e.Execute("INSERT INTO items VALUES (" + itoa(i) + ", 'item', " + itoa(i*10) + ")")
-		if result.Error != nil {
-			t.Fatalf("INSERT %d error = %v", i, result.Error)
+	after := files()
+	if len(after) != len(before) {
+		t.Errorf("files after a read-only session = %d, want %d", len(after), len(before))
+	}
+	for name, data := range before {
+		if !bytes.Equal(after[name], data) {
+			t.Errorf("%s changed during a read-only session", name)
 		}
 	}
 
-	result := e.Execute("SELECT * FROM items")
-	if result.Error != nil {
-		t.Fatalf("SELECT error = %v", result.Error)
+	// The database still recovers normally afterwards
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
 	}
-	if len(result.Rows) != 10 {
-		t.Errorf("rows = %d, want 10", len(result.Rows))
+	defer e2.Close()
+	if r := e2.Execute("INSERT INTO users VALUES (3, 'carol')"); r.Error != nil {
+		t.Errorf("INSERT after reopening read-write error = %v", r.Error)
 	}
 }
 
-func TestEngineVacuumAfterDelete(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+func TestEngineSeparateCatalog(t *testing.T) {
+	dir := t.TempDir()
 
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100, SeparateCatalog: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
 	e.Execute("CREATE TABLE users (id INT, name TEXT)")
 	e.Execute("INSERT INTO users VALUES (1, 'alice')")
 	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
 
-	e.Execute("DELETE FROM users WHERE id = 1")
+	// No page of data.db holds the catalog
+	dm, err := storage.NewDiskManager(filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatalf("NewDiskManager(data.db) error = %v", err)
+	}
+	for i := uint32(0); i < dm.GetNumPages(); i++ {
+		page, err := dm.ReadPage(types.PageID(i))
+		if err != nil {
+			t.Fatalf("ReadPage(%d) error = %v", i, err)
+		}
+		if page.Type == storage.PageTypeCatalog {
+			t.Errorf("data.db page %d is a catalog page", i)
+		}
+	}
+	dm.Close()
 
-	result, err := e.Vacuum()
+	// Reopening without the option still reads the catalog from catalog.db
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
 	if err != nil {
-		t.Fatalf("Vacuum() error = %v", err)
+		t.Fatalf("Reopen error = %v", err)
 	}
-	if result.TotalRemoved() != 1 {
-		t.Errorf("TotalRemoved = %d, want 1", result.TotalRemoved())
+	if e2.catalogPool == nil {
+		t.Error("reopened engine should use the separate catalog file")
 	}
+	result := e2.Execute("SELECT name FROM users WHERE id = 2")
+	if result.Error != nil {
+		t.Fatalf("SELECT after reopen error = %v", result.Error)
+	}
+	if len(result.Rows) != 1 || result.Rows[0].Values[0].StrVal != "bob" {
+		t.Errorf("rows = %v, want [[bob]]", result.Rows)
+	}
+	e2.Close()
 
-	// Remaining data should be intact
-	sel := e.Execute("SELECT * FROM users")
-	if sel.Error != nil {
-		t.Fatalf("SELECT error = %v", sel.Error)
+	// Removing the catalog file loses the schema, not a corrupt data file
+	if err := os.Remove(filepath.Join(dir, "catalog.db")); err != nil {
+		t.Fatal(err)
 	}
-	if len(sel.Rows) != 1 {
-		t.Errorf("rows after vacuum = %d, want 1", len(sel.Rows))
+	if _, err := New(Config{DataDir: dir, BufferPoolSize: 100, SeparateCatalog: true}); err == nil {
+		t.Error("New() on a database without its catalog file should fail")
 	}
 }
 
-func TestEngineVacuumAfterUpdate(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+func TestEngineSeparateCatalogExistingDatabase(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.Close()
 
-	e.Execute("CREATE TABLE users (id INT, name TEXT)")
-	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	if _, err := New(Config{DataDir: dir, BufferPoolSize: 100, SeparateCatalog: true}); err == nil {
+		t.Error("SeparateCatalog on a database with its catalog in data.db should fail")
+	}
+}
 
-	e.Execute("UPDATE users SET name = 'bob' WHERE id = 1")
+func TestEngineClockEviction(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := New(Config{DataDir: dir, EvictionPolicy: "random"}); err == nil {
+		t.Fatal("New() with an unknown eviction policy should error")
+	}
 
-	result, err := e.Vacuum()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 16, EvictionPolicy: storage.EvictionClock})
 	if err != nil {
-		t.Fatalf("Vacuum() error = %v", err)
-	}
-	// The old version of the updated row should be removed
-	if result.TotalRemoved() != 1 {
-		t.Errorf("TotalRemoved = %d, want 1", result.TotalRemoved())
+		t.Fatalf("New() error = %v", err)
 	}
+	defer e.Close()
 
-	sel := e.Execute("SELECT * FROM users")
-	if sel.Error != nil {
-		t.Fatalf("SELECT error = %v", sel.Error)
+	// More pages than the pool holds, so the clock evicts as the table grows
+	e.Execute("CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
+	for i := 0; i < 500; i++ {
+		if r := e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, '%s')", i, strings.Repeat("x", 100))); r.Error != nil {
+			t.Fatalf("INSERT %d error = %v", i, r.Error)
+		}
 	}
-	if len(sel.Rows) != 1 {
-		t.Errorf("rows after vacuum = %d, want 1", len(sel.Rows))
+	if r := e.Execute("SELECT COUNT(*) FROM users"); r.Error != nil || r.Rows[0].Values[0].IntVal != 500 {
+		t.Errorf("SELECT COUNT(*) = %v, %v; want 500", r.Rows, r.Error)
+	}
+	if r := e.Execute("SELECT name FROM users WHERE id = 250"); r.Error != nil || len(r.Rows) != 1 {
+		t.Errorf("SELECT by key = %v, %v; want 1 row", r.Rows, r.Error)
 	}
 }
 
-func TestEngineVacuumIdempotent(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
-
-	e.Execute("CREATE TABLE users (id INT, name TEXT)")
-	e.Execute("INSERT INTO users VALUES (1, 'alice')")
-	e.Execute("DELETE FROM users WHERE id = 1")
-
-	r1, err := e.Vacuum()
+func TestEngineStrictChecks(t *testing.T) {
+	e, err := New(Config{DataDir: t.TempDir(), BufferPoolSize: 16, StrictChecks: true})
 	if err != nil {
-		t.Fatalf("first Vacuum() error = %v", err)
+		t.Fatalf("New() error = %v", err)
 	}
-	if r1.TotalRemoved() != 1 {
-		t.Errorf("first TotalRemoved = %d, want 1", r1.TotalRemoved())
+	defer e.Close()
+	if !e.bufferPool.StrictChecks() {
+		t.Fatal("StrictChecks not passed to the buffer pool")
 	}
 
-	r2, err := e.Vacuum()
-	if err != nil {
-		t.Fatalf("second Vacuum() error = %v", err)
+	// A workload through every checked path runs clean
+	for _, sql := range []string{
+		"CREATE TABLE users (id INT PRIMARY KEY, name TEXT)",
+		"BEGIN",
+	} {
+		if r := e.Execute(sql); r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
 	}
-	if r2.TotalRemoved() != 0 {
-		t.Errorf("second TotalRemoved = %d, want 0", r2.TotalRemoved())
+	for i := 0; i < 500; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, '%s')", i, strings.Repeat("x", i%50)))
+	}
+	for _, sql := range []string{
+		"COMMIT",
+		"UPDATE users SET name = 'longer name than before' WHERE id < 100",
+		"DELETE FROM users WHERE id >= 400",
+	} {
+		if r := e.Execute(sql); r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+	}
+	if _, err := e.Vacuum(); err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	if r := e.Execute("SELECT COUNT(*) FROM users"); r.Error != nil || r.Rows[0].Values[0].IntVal != 400 {
+		t.Errorf("SELECT COUNT(*) = %v, %v; want 400", r.Rows, r.Error)
 	}
 }
 
-func TestEngineVacuumSkipsAbortedTxn(t *testing.T) {
+func TestEngineCheckpoint(t *testing.T) {
 	e := newTestEngine(t)
 	defer e.Close()
 
 	e.Execute("CREATE TABLE users (id INT, name TEXT)")
 	e.Execute("INSERT INTO users VALUES (1, 'alice')")
 
-	// DELETE inside a transaction that gets rolled back
-	e.Execute("BEGIN")
-	e.Execute("DELETE FROM users WHERE id = 1")
-	e.Execute("ROLLBACK")
+	if err := e.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
 
-	result, err := e.Vacuum()
-	if err != nil {
-		t.Fatalf("Vacuum() error = %v", err)
+	result := e.Execute("SELECT * FROM users")
+	if result.Error != nil {
+		t.Fatalf("SELECT after checkpoint error = %v", result.Error)
 	}
-	// Should NOT remove the tuple because the DELETE was aborted
-	if result.TotalRemoved() != 0 {
-		t.Errorf("TotalRemoved = %d, want 0 (aborted DELETE)", result.TotalRemoved())
+	if len(result.Rows) != 1 {
+		t.Errorf("rows = %d, want 1", len(result.Rows))
 	}
 }
 
-func TestEngineVacuumNoDeadTuples(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+func TestEngineCheckpointTruncatesWAL(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
 
 	e.Execute("CREATE TABLE users (id INT, name TEXT)")
-	e.Execute("INSERT INTO users VALUES (1, 'alice')")
-	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+	for i := 0; i < 200; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, 'user%d')", i, i))
+	}
+	e.walWriter.Flush()
+	before, err := e.walWriter.FileSize()
+	if err != nil {
+		t.Fatalf("FileSize() error = %v", err)
+	}
+	lsn := e.walWriter.GetCurrentLSN()
 
-	result, err := e.Vacuum()
+	if err := e.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
+	after, err := e.walWriter.FileSize()
 	if err != nil {
-		t.Fatalf("Vacuum() error = %v", err)
+		t.Fatalf("FileSize() error = %v", err)
 	}
-	if result.TotalRemoved() != 0 {
-		t.Errorf("TotalRemoved = %d, want 0", result.TotalRemoved())
+	if after >= before {
+		t.Errorf("WAL size after checkpoint = %d, want less than %d", after, before)
+	}
+	if got := e.walWriter.GetCurrentLSN(); got != lsn+1 {
+		t.Errorf("GetCurrentLSN() after checkpoint = %d, want %d", got, lsn+1)
 	}
-}
 
-func TestEngineCreateIndexInvalidColumn(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+	// Writes after the truncation go to the new file
+	e.Execute("INSERT INTO users VALUES (200, 'user200')")
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e2.Close()
+	if r := e2.Execute("SELECT COUNT(*) FROM users"); r.Error != nil || r.Rows[0].Values[0].IntVal != 201 {
+		t.Errorf("SELECT COUNT(*) after reopen = %v, %v; want 201", r.Rows, r.Error)
+	}
+
+	// Transaction IDs keep increasing although their records are gone
+	r := e2.Execute("INSERT INTO users VALUES (201, 'user201')")
+	if r.Error != nil {
+		t.Fatalf("INSERT after reopen error = %v", r.Error)
+	}
+	if r := e2.Execute("SELECT COUNT(*) FROM users"); r.Error != nil || r.Rows[0].Values[0].IntVal != 202 {
+		t.Errorf("SELECT COUNT(*) after insert = %v, %v; want 202", r.Rows, r.Error)
+	}
+}
+
+func TestEngineCheckpointKeepsActiveTxnLog(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
 
 	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	// Uncommitted across the checkpoint and the crash
+	e.Execute("BEGIN")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+	e.Execute("UPDATE users SET name = 'carol' WHERE id = 1")
+	if err := e.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
 
-	err := e.CreateIndex("users", "nonexistent")
-	if err == nil {
-		t.Error("CreateIndex() on non-existent column should error")
+	e.walWriter.Flush()
+	e.walWriter.Close()
+	e.diskManager.Close()
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen after crash error = %v", err)
+	}
+	defer e2.Close()
+
+	r := e2.Execute("SELECT id, name FROM users")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != 1 || r.Rows[0].Values[1].StrVal != "alice" {
+		t.Errorf("rows after recovery = %v, want [(1, alice)]", r.Rows)
 	}
 }
 
-func TestEngineIndexLookup(t *testing.T) {
-	e := newTestEngine(t)
-	defer e.Close()
+func TestEngineVisibilityAfterWALTruncation(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
 
 	e.Execute("CREATE TABLE users (id INT, name TEXT)")
-	e.Execute("INSERT INTO users VALUES (1, 'Alice')")
-	e.Execute("INSERT INTO users VALUES (2, 'Bob')")
-	e.Execute("INSERT INTO users VALUES (3, 'Charlie')")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+	e.Execute("INSERT INTO users VALUES (3, 'carol')")
+	e.Execute("UPDATE users SET id = 10 WHERE name = 'alice'")
+	e.Execute("DELETE FROM users WHERE name = 'bob'")
+	if err := e.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
+	if size, err := e.walWriter.FileSize(); err != nil || size > 256 {
+		t.Fatalf("WAL size after checkpoint = %d, %v; want the records truncated", size, err)
+	}
 
-	if err := e.CreateIndex("users", "id"); err != nil {
-		t.Fatalf("CreateIndex() error = %v", err)
+	// Crash: only the heap pages written by the checkpoint remain
+	e.walWriter.Close()
+	e.diskManager.Close()
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
 	}
+	defer e2.Close()
 
-	result := e.Execute("SELECT * FROM users WHERE id = 2")
-	if result.Error != nil {
-		t.Fatalf("SELECT error = %v", result.Error)
+	rows := func() string {
+		t.Helper()
+		r := e2.Execute("SELECT id, name FROM users ORDER BY id")
+		if r.Error != nil {
+			t.Fatalf("SELECT error = %v", r.Error)
+		}
+		var got []string
+		for _, row := range r.Rows {
+			got = append(got, fmt.Sprintf("%d:%s", row.Values[0].IntVal, row.Values[1].StrVal))
+		}
+		return strings.Join(got, ",")
 	}
-	if len(result.Rows) != 1 {
-		t.Fatalf("rows = %d, want 1", len(result.Rows))
+
+	// The heap still holds the old versions; their XMin/XMax hide them
+	if n := len(rowIDsByName(t, e2, "users")["alice"]); n != 2 {
+		t.Fatalf("alice versions in the heap = %d, want 2", n)
 	}
-	if result.Rows[0].Values[1].StrVal != "Bob" {
-		t.Errorf("name = %q, want Bob", result.Rows[0].Values[1].StrVal)
+	if got := rows(); got != "3:carol,10:alice" {
+		t.Errorf("rows after reopen = %s, want 3:carol,10:alice", got)
+	}
+
+	// New transactions and rows continue past the ones on disk
+	e2.Execute("UPDATE users SET id = 30 WHERE name = 'carol'")
+	e2.Execute("INSERT INTO users VALUES (4, 'dave')")
+	if got := rows(); got != "4:dave,10:alice,30:carol" {
+		t.Errorf("rows after writes = %s, want 4:dave,10:alice,30:carol", got)
+	}
+	ids := rowIDsByName(t, e2, "users")
+	if dave := ids["dave"][0]; dave <= ids["alice"][0] || dave <= ids["bob"][0] || dave <= ids["carol"][0] {
+		t.Errorf("dave RowID = %d, want greater than every earlier row", dave)
 	}
 }
 
-func TestEngineIndexMaintainedOnInsert(t *testing.T) {
+func TestEngineCreateIndex(t *testing.T) {
 	e := newTestEngine(t)
 	defer e.Close()
 
 	e.Execute("CREATE TABLE users (id INT, name TEXT)")
-	e.Execute("INSERT INTO users VALUES (1, 'Alice')")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
 
 	if err := e.CreateIndex("users", "id"); err != nil {
 		t.Fatalf("CreateIndex() error = %v", err)
 	}
 
-	// Insert after index creation
-	e.Execute("INSERT INTO users VALUES (4, 'Dave')")
+	// Index should be accessible
+	tableID, _ := e.catalog.GetTableID("users")
+	idx := e.GetIndex(tableID, "id")
+	if idx == nil {
+		t.Error("index should exist after CreateIndex")
+	}
+}
 
-	result := e.Execute("SELECT * FROM users WHERE id = 4")
-	if result.Error != nil {
-		t.Fatalf("SELECT error = %v", result.Error)
+func TestEngineDoubleBegin(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("BEGIN")
+	result := e.Execute("BEGIN")
+	if result.Error == nil {
+		t.Error("double BEGIN should error")
 	}
-	if len(result.Rows) != 1 {
-		t.Fatalf("rows = %d, want 1", len(result.Rows))
+}
+
+func TestEngineCommitNoTransaction(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	result := e.Execute("COMMIT")
+	if result.Error == nil {
+		t.Error("COMMIT without BEGIN should error")
 	}
-	if result.Rows[0].Values[1].StrVal != "Dave" {
-		t.Errorf("name = %q, want Dave", result.Rows[0].Values[1].StrVal)
+}
+
+func TestEngineRollbackNoTransaction(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	result := e.Execute("ROLLBACK")
+	if result.Error == nil {
+		t.Error("ROLLBACK without BEGIN should error")
 	}
 }
 
-func TestEngineIndexMaintainedOnUpdate(t *testing.T) {
+func TestEngineSelectNonExistentTable(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	result := e.Execute("SELECT * FROM nonexistent")
+	if result.Error == nil {
+		t.Error("SELECT from non-existent table should error")
+	}
+}
+
+func TestEngineInsertColumnCountMismatch(t *testing.T) {
 	e := newTestEngine(t)
 	defer e.Close()
 
 	e.Execute("CREATE TABLE users (id INT, name TEXT)")
-	e.Execute("INSERT INTO users VALUES (1, 'Alice')")
-	e.Execute("INSERT INTO users VALUES (2, 'Bob')")
-	e.Execute("INSERT INTO users VALUES (3, 'Charlie')")
 
-	if err := e.CreateIndex("users", "id"); err != nil {
-		t.Fatalf("CreateIndex() error = %v", err)
+	result := e.Execute("INSERT INTO users VALUES (1)")
+	if result.Error == nil {
+		t.Error("INSERT with wrong column count should error")
 	}
+}
 
-	e.Execute("UPDATE users SET name = 'Eve' WHERE id = 3")
+func TestEngineInsertMultipleRows(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
 
-	result := e.Execute("SELECT * FROM users WHERE id = 3")
-	if result.Error != nil {
-		t.Fatalf("SELECT error = %v", result.Error)
+	e.Execute("CREATE TABLE users (id INT UNIQUE, name TEXT)")
+
+	r := e.Execute("INSERT INTO users (id, name) VALUES (1, 'x'), (2, 'y'), (3, 'z')")
+	if r.Error != nil {
+		t.Fatalf("INSERT error = %v", r.Error)
 	}
-	if len(result.Rows) != 1 {
-		t.Fatalf("rows = %d, want 1", len(result.Rows))
+	if r.Message != "INSERT 3" {
+		t.Errorf("Message = %q, want %q", r.Message, "INSERT 3")
 	}
-	if result.Rows[0].Values[1].StrVal != "Eve" {
-		t.Errorf("name = %q, want Eve", result.Rows[0].Values[1].StrVal)
+	if r := e.Execute("SELECT id FROM users"); len(r.Rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(r.Rows))
+	}
+
+	// A bad tuple anywhere aborts the whole statement
+	for _, sql := range []string{
+		"INSERT INTO users VALUES (4, 'a'), (5), (6, 'c')",
+		"INSERT INTO users VALUES (4, 'a'), (5, 'b'), (4, 'c')",
+		"INSERT INTO users VALUES (4, 'a'), (1, 'b')",
+	} {
+		if r := e.Execute(sql); r.Error == nil {
+			t.Errorf("%s: expected error", sql)
+		}
+	}
+	if r := e.Execute("SELECT id FROM users"); len(r.Rows) != 3 {
+		t.Errorf("got %d rows after failed INSERTs, want 3", len(r.Rows))
+	}
+	if r := e.Execute("INSERT INTO users VALUES (4, 'a'), (5)"); r.Error == nil || !strings.Contains(r.Error.Error(), "row 2") {
+		t.Errorf("error = %v, want it to name row 2", r.Error)
+	}
+
+	// Inside a transaction, only the failed statement is undone
+	e.Execute("BEGIN")
+	e.Execute("INSERT INTO users VALUES (4, 'a')")
+	if r := e.Execute("INSERT INTO users VALUES (5, 'b'), (4, 'c')"); r.Error == nil {
+		t.Error("INSERT of a duplicate in a transaction should error")
+	}
+	e.Execute("COMMIT")
+	if r := e.Execute("SELECT id FROM users WHERE id > 3"); len(r.Rows) != 1 {
+		t.Errorf("got %d rows, want only the row of the successful INSERT", len(r.Rows))
 	}
 }
 
-func TestEngineIndexAfterVacuum(t *testing.T) {
+func TestEngineInsertNonExistentTable(t *testing.T) {
 	e := newTestEngine(t)
 	defer e.Close()
 
-	e.Execute("CREATE TABLE users (id INT, name TEXT)")
-	e.Execute("INSERT INTO users VALUES (1, 'Alice')")
-	e.Execute("INSERT INTO users VALUES (2, 'Bob')")
-	e.Execute("INSERT INTO users VALUES (3, 'Charlie')")
+	result := e.Execute("INSERT INTO nonexistent VALUES (1)")
+	if result.Error == nil {
+		t.Error("INSERT into non-existent table should error")
+	}
+}
 
-	if err := e.CreateIndex("users", "id"); err != nil {
-		t.Fatalf("CreateIndex() error = %v", err)
+func TestEngineStats(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	stats := e.Stats()
+	if stats == nil {
+		t.Fatal("Stats() returned nil")
+	}
+	if _, ok := stats["tables"]; !ok {
+		t.Error("Stats() should include 'tables'")
 	}
+}
 
-	e.Execute("DELETE FROM users WHERE id = 1")
+func TestEngineStatsWALSize(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
 
-	_, err := e.Vacuum()
-	if err != nil {
-		t.Fatalf("Vacuum() error = %v", err)
+	before, ok := e.Stats()["wal_size_bytes"].(int64)
+	if !ok {
+		t.Fatal("Stats() should include 'wal_size_bytes'")
 	}
 
-	// Deleted row should not be found
-	result := e.Execute("SELECT * FROM users WHERE id = 1")
-	if result.Error != nil {
-		t.Fatalf("SELECT error = %v", result.Error)
+	e.Execute("CREATE TABLE users (id INT)")
+	e.Execute("INSERT INTO users VALUES (1)")
+	stats := e.Stats()
+	if after := stats["wal_size_bytes"].(int64); after <= before {
+		t.Errorf("wal_size_bytes = %d after a commit, want > %d", after, before)
 	}
-	if len(result.Rows) != 0 {
-		t.Errorf("rows for deleted id=1 = %d, want 0", len(result.Rows))
+	if n := stats["wal_records_since_checkpoint"].(uint64); n == 0 {
+		t.Error("wal_records_since_checkpoint = 0 after a commit")
 	}
 
-	// Existing row should still be found
-	result = e.Execute("SELECT * FROM users WHERE id = 2")
-	if result.Error != nil {
-		t.Fatalf("SELECT error = %v", result.Error)
+	if err := e.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
 	}
-	if len(result.Rows) != 1 {
-		t.Errorf("rows for id=2 = %d, want 1", len(result.Rows))
+	if n := e.Stats()["wal_records_since_checkpoint"].(uint64); n != 0 {
+		t.Errorf("wal_records_since_checkpoint = %d after checkpoint, want 0", n)
 	}
 }
 
-func itoa(n int) string {
-	if n == 0 {
-		return "0"
-	}
-	s := ""
-	neg := false
-	if n < 0 {
-		neg = true
-		n = -n
+func TestEngineDefaultBufferPoolSize(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
 	}
-	for n > 0 {
-		s = string(rune('0'+n%10)) + s
-		n /= 10
+	defer e.Close()
+}
+
+func TestEngineCreateIndexNonExistentTable(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	err := e.CreateIndex("nonexistent", "id")
+	if err == nil {
+		t.Error("CreateIndex() on non-existent table should error")
 	}
-	if neg {
-		s = "-" + s
+}
+
+func TestEngineCreateDuplicateIndex(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.CreateIndex("users", "id")
+
+	err := e.CreateIndex("users", "id")
+	if err == nil {
+		t.Error("duplicate CreateIndex() should error")
+	}
+}
+
+func TestEngineRecoveryAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+
+	// Simulate a "crash" by closing WAL and disk directly without graceful shutdown
+	e.walWriter.Flush()
+	e.walWriter.Close()
+	e.diskManager.Close()
+
+	// Reopen - should trigger recovery
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen after crash error = %v", err)
+	}
+	defer e2.Close()
+
+	result := e2.Execute("SELECT * FROM users")
+	if result.Error != nil {
+		t.Fatalf("SELECT after recovery error = %v", result.Error)
+	}
+	// Data should still be there after recovery
+	if len(result.Rows) < 1 {
+		t.Error("data should survive crash recovery")
+	}
+}
+
+func TestEngineAsyncCommitDurableWithinInterval(t *testing.T) {
+	dir := t.TempDir()
+	interval := 10 * time.Millisecond
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100, AsyncCommit: true, WALFlushInterval: interval})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	if r := e.Execute("INSERT INTO users VALUES (1, 'alice')"); r.Error != nil {
+		t.Fatalf("INSERT error = %v", r.Error)
+	}
+
+	// Wait for the flusher rather than flushing by hand, then crash
+	commitLSN := e.walWriter.GetCurrentLSN() - 1
+	deadline := time.Now().Add(50 * interval)
+	for e.walWriter.GetFlushedLSN() < commitLSN {
+		if time.Now().After(deadline) {
+			t.Fatalf("commit not flushed within the interval: FlushedLSN = %d, commitLSN = %d", e.walWriter.GetFlushedLSN(), commitLSN)
+		}
+		time.Sleep(interval / 2)
+	}
+	e.walWriter.Close()
+	e.diskManager.Close()
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen after crash error = %v", err)
+	}
+	defer e2.Close()
+	result := e2.Execute("SELECT * FROM users")
+	if result.Error != nil {
+		t.Fatalf("SELECT after recovery error = %v", result.Error)
+	}
+	if len(result.Rows) != 1 {
+		t.Errorf("rows = %d, want 1", len(result.Rows))
+	}
+}
+
+func TestEngineAnalyze(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, age INT)")
+	for i := 0; i < 300; i++ {
+		age := fmt.Sprint(i % 60)
+		if i%10 == 0 {
+			age = "NULL"
+		}
+		e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, %s)", i, age))
+	}
+	e.Execute("DELETE FROM users WHERE id >= 200")
+	if got := e.stats.Changed("users"); got != 400 {
+		t.Errorf("changed rows = %d, want 400", got)
+	}
+
+	r := e.Execute("ANALYZE users")
+	if r.Error != nil {
+		t.Fatalf("ANALYZE error = %v", r.Error)
+	}
+	if r.Message != "ANALYZE users (200 rows)" {
+		t.Errorf("ANALYZE message = %q", r.Message)
+	}
+	stats := e.stats.Get("users")
+	if stats == nil || stats.Rows != 200 || stats.Distinct["id"] != 200 || stats.Nulls["age"] != 20 {
+		t.Fatalf("stats = %+v, want 200 rows, 200 distinct ids, 20 NULL ages", stats)
+	}
+	if e.stats.Changed("users") != 0 {
+		t.Errorf("changed rows after ANALYZE = %d, want 0", e.stats.Changed("users"))
+	}
+
+	// Estimates use the row count and the distinct values instead of the
+	// tuple versions and fixed guesses
+	plans := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM users", "Seq Scan on users  (rows=200)"},
+		{"SELECT * FROM users WHERE id = 7", "Seq Scan on users  (rows=1)"},
+		{"SELECT * FROM users WHERE age IS NULL", "Seq Scan on users  (rows=20)"},
+	}
+	for _, tt := range plans {
+		r := e.Execute("EXPLAIN " + tt.query)
+		if r.Error != nil {
+			t.Fatalf("EXPLAIN %s: error = %v", tt.query, r.Error)
+		}
+		if got := r.Rows[0].Values[0].StrVal; got != tt.want {
+			t.Errorf("EXPLAIN %s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	e.Execute("CREATE TABLE empty (id INT)")
+	if r := e.Execute("ANALYZE"); r.Error != nil || r.Message != "ANALYZE (2 tables)" {
+		t.Errorf("ANALYZE of every table = %q (error %v)", r.Message, r.Error)
+	}
+	if r := e.Execute("ANALYZE missing"); r.Error == nil {
+		t.Error("ANALYZE of a missing table should error")
+	}
+}
+
+func TestEngineAutoAnalyze(t *testing.T) {
+	e, err := New(Config{DataDir: t.TempDir(), BufferPoolSize: 100, AutoAnalyzeThreshold: 0.2})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	waitForRows := func(want int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if stats := e.stats.Get("t"); stats != nil && stats.Rows == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("stats = %+v, want %d rows", e.stats.Get("t"), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// Each batch goes in as one statement, so the analyzer never sees a
+	// batch half loaded
+	path := filepath.Join(t.TempDir(), "batch.csv")
+	insert := func(from, to int) {
+		t.Helper()
+		var b strings.Builder
+		for i := from; i < to; i++ {
+			fmt.Fprintf(&b, "%d\n", i)
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		if r := e.Execute(fmt.Sprintf("COPY t FROM '%s'", path)); r.Error != nil {
+			t.Fatalf("COPY error = %v", r.Error)
+		}
+	}
+
+	e.Execute("CREATE TABLE t (id INT)")
+	insert(0, autoAnalyzeBaseRows)
+	if e.stats.Get("t") != nil {
+		t.Fatal("table analyzed before the base row count changed")
+	}
+	insert(autoAnalyzeBaseRows, 100)
+	waitForRows(100)
+
+	// 50 base rows plus 20% of 100 must change before the next analyze
+	insert(100, 170)
+	if stats := e.stats.Get("t"); stats.Rows != 100 {
+		t.Errorf("re-analyzed after %d changes, want more than 70", 70)
+	}
+	insert(170, 171)
+	waitForRows(171)
+}
+
+func TestEngineBackgroundCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100, CheckpointInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	checkpoints := func() int {
+		t.Helper()
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.walWriter.Flush()
+		records, err := wal.ReadRecords(filepath.Join(dir, "wal.log"))
+		if err != nil {
+			t.Fatalf("ReadRecords() error = %v", err)
+		}
+		n := 0
+		for _, record := range records {
+			if record.Type == types.LogRecordCheckpoint {
+				n++
+			}
+		}
+		return n
+	}
+
+	before := checkpoints()
+	e.Execute("CREATE TABLE t (id INT)")
+	for i := 0; i < 10; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO t VALUES (%d)", i))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for checkpoints() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("no CHECKPOINT record written after the inserts")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if records, _ := e.walWriter.SinceCheckpoint(); records != 0 {
+		t.Errorf("WAL records since checkpoint = %d, want 0", records)
+	}
+
+	// Nothing is checkpointed while nothing is logged
+	idle := checkpoints()
+	time.Sleep(50 * time.Millisecond)
+	if got := checkpoints(); got != idle {
+		t.Errorf("checkpoints while idle = %d, want %d", got-idle, 0)
+	}
+}
+
+// abortedTxns returns the transactions with an ABORT record in the WAL.
+func abortedTxns(t *testing.T, dir string) map[types.TxnID]bool {
+	t.Helper()
+	records, err := wal.ReadRecords(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatalf("ReadRecords() error = %v", err)
+	}
+	aborted := make(map[types.TxnID]bool)
+	for _, record := range records {
+		if record.Type == types.LogRecordAbort {
+			aborted[record.TxnID] = true
+		}
+	}
+	return aborted
+}
+
+func TestEngineConcurrentExecute(t *testing.T) {
+	e, err := New(Config{
+		DataDir:              t.TempDir(),
+		BufferPoolSize:       100,
+		QueryCacheSize:       16,
+		AutoAnalyzeThreshold: 0.1,
+		CheckpointInterval:   5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	if r := e.Execute("CREATE TABLE items (id INT PRIMARY KEY, worker INT)"); r.Error != nil {
+		t.Fatalf("CREATE TABLE error = %v", r.Error)
+	}
+	tableID, _ := e.GetCatalog().GetTableID("items")
+
+	const workers, perWorker = 8, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*2)
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id := w*perWorker + i
+				if i%10 == 0 {
+					if r := e.Execute(fmt.Sprintf("CREATE TABLE extra_%d (a INT PRIMARY KEY)", id)); r.Error != nil {
+						errs <- fmt.Errorf("CREATE TABLE: %w", r.Error)
+						return
+					}
+				}
+				if r := e.Execute(fmt.Sprintf("INSERT INTO items VALUES (%d, %d)", id, w)); r.Error != nil {
+					errs <- fmt.Errorf("INSERT %d: %w", id, r.Error)
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				if r := e.Execute(fmt.Sprintf("SELECT COUNT(*) FROM items WHERE worker = %d", w)); r.Error != nil {
+					errs <- fmt.Errorf("SELECT: %w", r.Error)
+					return
+				}
+				e.Stats()
+				e.GetIndex(tableID, "id")
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	r := e.Execute("SELECT COUNT(*) FROM items")
+	if r.Error != nil {
+		t.Fatalf("SELECT COUNT(*) error = %v", r.Error)
+	}
+	if got := r.Rows[0].Values[0].IntVal; got != workers*perWorker {
+		t.Errorf("COUNT(*) = %d, want %d", got, workers*perWorker)
+	}
+	if got, want := len(e.GetCatalog().GetAllTables()), 1+workers*perWorker/10; got != want {
+		t.Errorf("tables = %d, want %d", got, want)
+	}
+}
+
+func TestEngineShutdownAbortsActiveTxns(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+
+	other := sql.NewExecutor(e.txnManager, e.walWriter)
+	other.SetStorage(e.catalog, e.bufferPool)
+	e.Execute("BEGIN")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	other.Execute("BEGIN")
+	other.Execute("INSERT INTO users VALUES (2, 'bob')")
+	sessionTxn, otherTxn := e.executor.CurrentTxnID(), other.CurrentTxnID()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = e.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want deadline exceeded", err)
+	}
+
+	aborted := abortedTxns(t, dir)
+	if !aborted[sessionTxn] || !aborted[otherTxn] {
+		t.Errorf("ABORT records for txns %d and %d = %v, %v; want both", sessionTxn, otherTxn, aborted[sessionTxn], aborted[otherTxn])
+	}
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e2.Close()
+	if r := e2.Execute("SELECT * FROM users"); r.Error != nil || len(r.Rows) != 0 {
+		t.Errorf("SELECT after shutdown = %d rows (error %v), want 0", len(r.Rows), r.Error)
+	}
+}
+
+func TestEngineShutdownWaitsForTxns(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+
+	other := sql.NewExecutor(e.txnManager, e.walWriter)
+	other.SetStorage(e.catalog, e.bufferPool)
+	other.Execute("BEGIN")
+	other.Execute("INSERT INTO users VALUES (1, 'alice')")
+	txn := e.txnManager.GetTransaction(other.CurrentTxnID())
+
+	// Another goroutine finishes the transaction while Shutdown waits
+	done := make(chan error, 1)
+	go func() {
+		time.Sleep(5 * shutdownPollInterval)
+		done <- e.txnManager.Commit(txn)
+	}()
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if abortedTxns(t, dir)[txn.ID] {
+		t.Errorf("txn %d was aborted, want it to commit", txn.ID)
+	}
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e2.Close()
+	if r := e2.Execute("SELECT * FROM users"); r.Error != nil || len(r.Rows) != 1 {
+		t.Errorf("SELECT after shutdown = %d rows (error %v), want 1", len(r.Rows), r.Error)
+	}
+}
+
+func TestEngineCloseAbortsActiveTxns(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+
+	other := sql.NewExecutor(e.txnManager, e.walWriter)
+	other.SetStorage(e.catalog, e.bufferPool)
+	other.Execute("BEGIN")
+	other.Execute("INSERT INTO users VALUES (1, 'alice')")
+	txnID := other.CurrentTxnID()
+
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !abortedTxns(t, dir)[txnID] {
+		t.Errorf("no ABORT record for txn %d", txnID)
+	}
+}
+
+func TestEngineDataDir(t *testing.T) {
+	dir := t.TempDir()
+	subdir := filepath.Join(dir, "nested", "db")
+
+	e, err := New(Config{DataDir: subdir, BufferPoolSize: 10})
+	if err != nil {
+		t.Fatalf("New() with nested dir error = %v", err)
+	}
+	defer e.Close()
+}
+
+func TestEngineGetCatalogAndBufferPool(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	if e.GetCatalog() == nil {
+		t.Error("GetCatalog() returned nil")
+	}
+	if e.GetBufferPool() == nil {
+		t.Error("GetBufferPool() returned nil")
+	}
+}
+
+func TestEngineInvalidSQL(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	result := e.Execute("INVALID SQL")
+	if result.Error == nil {
+		t.Error("invalid SQL should error")
+	}
+}
+
+func TestEngineCreateDuplicateTable(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT)")
+	result := e.Execute("CREATE TABLE users (id INT)")
+	if result.Error == nil {
+		t.Error("duplicate CREATE TABLE should error")
+	}
+}
+
+func TestEngineMultipleInsertSelect(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE items (id INT, name TEXT, price INT)")
+
+	for i := 1; i <= 10; i++ {
+		result := e.Execute("INSERT INTO items VALUES (" + itoa(i) + ", 'item', " + itoa(i*10) + ")")
+		if result.Error != nil {
+			t.Fatalf("INSERT %d error = %v", i, result.Error)
+		}
+	}
+
+	result := e.Execute("SELECT * FROM items")
+	if result.Error != nil {
+		t.Fatalf("SELECT error = %v", result.Error)
+	}
+	if len(result.Rows) != 10 {
+		t.Errorf("rows = %d, want 10", len(result.Rows))
+	}
+}
+
+func TestEngineScanTable(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	for i := 0; i < 300; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, 'user%d')", i, i))
+	}
+	e.Execute("UPDATE users SET name = 'renamed' WHERE id = 7")
+	e.Execute("DELETE FROM users WHERE id < 5")
+
+	r := e.Execute("SELECT * FROM users")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	var selected []string
+	for _, row := range r.Rows {
+		selected = append(selected, row.Values[0].String()+":"+row.Values[1].String())
+	}
+
+	// Rows inserted by a transaction still in progress are not visible
+	e.Execute("BEGIN")
+	e.Execute("INSERT INTO users VALUES (1000, 'pending')")
+
+	var scanned []string
+	err := e.ScanTable("users", func(rowID uint64, values map[string]types.Value) error {
+		scanned = append(scanned, values["id"].String()+":"+values["name"].String())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanTable() error = %v", err)
+	}
+	e.Execute("ROLLBACK")
+
+	sort.Strings(scanned)
+	sort.Strings(selected)
+	if len(scanned) != 295 || strings.Join(scanned, ",") != strings.Join(selected, ",") {
+		t.Errorf("ScanTable() returned %d rows, SELECT * %d; they differ", len(scanned), len(selected))
+	}
+
+	// An error from fn stops the scan and is returned
+	stop := errors.New("stop")
+	calls := 0
+	err = e.ScanTable("users", func(uint64, map[string]types.Value) error {
+		calls++
+		if calls == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || calls != 3 {
+		t.Errorf("ScanTable() = %v after %d calls, want stop after 3", err, calls)
+	}
+
+	if err := e.ScanTable("missing", func(uint64, map[string]types.Value) error { return nil }); err == nil {
+		t.Error("ScanTable() on a missing table should fail")
+	}
+}
+
+func TestEngineVacuumAfterDelete(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+
+	e.Execute("DELETE FROM users WHERE id = 1")
+
+	result, err := e.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	if result.TotalRemoved() != 1 {
+		t.Errorf("TotalRemoved = %d, want 1", result.TotalRemoved())
+	}
+
+	// Remaining data should be intact
+	sel := e.Execute("SELECT * FROM users")
+	if sel.Error != nil {
+		t.Fatalf("SELECT error = %v", sel.Error)
+	}
+	if len(sel.Rows) != 1 {
+		t.Errorf("rows after vacuum = %d, want 1", len(sel.Rows))
+	}
+}
+
+func TestEngineVacuumAfterUpdate(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+
+	e.Execute("UPDATE users SET name = 'bob' WHERE id = 1")
+
+	result, err := e.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	// The old version of the updated row should be removed
+	if result.TotalRemoved() != 1 {
+		t.Errorf("TotalRemoved = %d, want 1", result.TotalRemoved())
+	}
+
+	sel := e.Execute("SELECT * FROM users")
+	if sel.Error != nil {
+		t.Fatalf("SELECT error = %v", sel.Error)
+	}
+	if len(sel.Rows) != 1 {
+		t.Errorf("rows after vacuum = %d, want 1", len(sel.Rows))
+	}
+}
+
+func TestEngineVacuumIdempotent(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("DELETE FROM users WHERE id = 1")
+
+	r1, err := e.Vacuum()
+	if err != nil {
+		t.Fatalf("first Vacuum() error = %v", err)
+	}
+	if r1.TotalRemoved() != 1 {
+		t.Errorf("first TotalRemoved = %d, want 1", r1.TotalRemoved())
+	}
+
+	r2, err := e.Vacuum()
+	if err != nil {
+		t.Fatalf("second Vacuum() error = %v", err)
+	}
+	if r2.TotalRemoved() != 0 {
+		t.Errorf("second TotalRemoved = %d, want 0", r2.TotalRemoved())
+	}
+}
+
+func TestEngineVacuumSkipsAbortedTxn(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+
+	// DELETE inside a transaction that gets rolled back
+	e.Execute("BEGIN")
+	e.Execute("DELETE FROM users WHERE id = 1")
+	e.Execute("ROLLBACK")
+
+	result, err := e.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	// Should NOT remove the tuple because the DELETE was aborted
+	if result.TotalRemoved() != 0 {
+		t.Errorf("TotalRemoved = %d, want 0 (aborted DELETE)", result.TotalRemoved())
+	}
+}
+
+func TestEngineVacuumNoDeadTuples(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+
+	result, err := e.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	if result.TotalRemoved() != 0 {
+		t.Errorf("TotalRemoved = %d, want 0", result.TotalRemoved())
+	}
+}
+
+func TestEngineVacuumIndexIncremental(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE items (id INT, name TEXT)")
+	for start := 0; start < 3000; start += 500 {
+		var values []string
+		for i := start; i < start+500; i++ {
+			values = append(values, fmt.Sprintf("(%d, 'item%d')", i, i))
+		}
+		if r := e.Execute("INSERT INTO items VALUES " + strings.Join(values, ", ")); r.Error != nil {
+			t.Fatalf("INSERT error = %v", r.Error)
+		}
+	}
+	if err := e.CreateIndex("items", "id"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	// Deleted rows and the old versions of updated rows lose their entries;
+	// the index on a column that is not UNIQUE has one for every version
+	e.Execute("DELETE FROM items WHERE id IN (10, 500, 1000, 1500, 2000, 2500, 2999)")
+	e.Execute("UPDATE items SET id = 5000 WHERE id = 42")
+	e.Execute("UPDATE items SET name = 'renamed' WHERE id = 7")
+	if err := e.flushPages(); err != nil {
+		t.Fatalf("flushPages() error = %v", err)
+	}
+
+	before := e.diskManager.PagesWritten()
+	result, err := e.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	vacuumWrites := e.diskManager.PagesWritten() - before
+	if got := result.Tables[0].IndexEntriesRemoved; got != 9 {
+		t.Errorf("IndexEntriesRemoved = %d, want 9", got)
+	}
+
+	lookups := func(when string) {
+		t.Helper()
+		tests := []struct {
+			id   int
+			want string
+		}{
+			{7, "renamed"}, {11, "item11"}, {1000, ""}, {2999, ""}, {42, ""}, {5000, "item42"},
+		}
+		tableID, _ := e.catalog.GetTableID("items")
+		schema := e.catalog.GetSchema("items")
+		for _, tt := range tests {
+			r := e.Execute(fmt.Sprintf("SELECT name FROM items WHERE id = %d", tt.id))
+			got := ""
+			if len(r.Rows) == 1 {
+				got = r.Rows[0].Values[0].StrVal
+			}
+			if r.Error != nil || got != tt.want {
+				t.Errorf("%s: id = %d gives %q, %v; want %q", when, tt.id, got, r.Error, tt.want)
+			}
+			start, end := index.EntryRange(schema, "id", types.Value{Type: types.ValueTypeInt, IntVal: int64(tt.id)}, 64)
+			want := 0
+			if tt.want != "" {
+				want = 1
+			}
+			if n := len(e.indexes[tableID]["id"].RangeScan(start, end)); n != want {
+				t.Errorf("%s: index has %d entries for key %d, want %d", when, n, tt.id, want)
+			}
+		}
+	}
+	lookups("after VACUUM")
+
+	// Rebuilding writes every page of the index
+	before = e.diskManager.PagesWritten()
+	if err := e.Reindex(); err != nil {
+		t.Fatalf("Reindex() error = %v", err)
+	}
+	rebuildWrites := e.diskManager.PagesWritten() - before
+	if vacuumWrites*2 > rebuildWrites {
+		t.Errorf("VACUUM wrote %d pages, rebuild %d; want the incremental path to write far fewer", vacuumWrites, rebuildWrites)
+	}
+	lookups("after Reindex")
+
+	if result, err := e.Repair(); err != nil || len(result.Reclaimed) != 0 {
+		t.Errorf("Repair() reclaimed %v, err = %v, want nothing left orphaned", result.Reclaimed, err)
+	}
+}
+
+func TestEngineCreateIndexInvalidColumn(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+
+	err := e.CreateIndex("users", "nonexistent")
+	if err == nil {
+		t.Error("CreateIndex() on non-existent column should error")
+	}
+}
+
+func TestEngineIndexLookup(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'Alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'Bob')")
+	e.Execute("INSERT INTO users VALUES (3, 'Charlie')")
+
+	if err := e.CreateIndex("users", "id"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	result := e.Execute("SELECT * FROM users WHERE id = 2")
+	if result.Error != nil {
+		t.Fatalf("SELECT error = %v", result.Error)
+	}
+	if len(result.Rows) != 1 {
+		t.Fatalf("rows = %d, want 1", len(result.Rows))
+	}
+	if result.Rows[0].Values[1].StrVal != "Bob" {
+		t.Errorf("name = %q, want Bob", result.Rows[0].Values[1].StrVal)
+	}
+}
+
+func TestEngineIndexLookupReadsFewerPages(t *testing.T) {
+	e, err := New(Config{DataDir: t.TempDir(), BufferPoolSize: 16})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	e.Execute("CREATE TABLE t (id INT, code INT, body TEXT)")
+	e.Execute("BEGIN")
+	for i := 0; i < 1000; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO t VALUES (%d, %d, '%s')", i, i, strings.Repeat("x", 100)))
+	}
+	e.Execute("COMMIT")
+	if err := e.CreateIndex("t", "id"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	// Both columns hold the same values, but only id is indexed. A scan
+	// reads ahead, so its prefetched pages count as read too
+	reads := func() uint64 {
+		_, misses, _ := e.bufferPool.Stats()
+		return misses + e.bufferPool.Prefetches()
+	}
+	misses := func(sql string) uint64 {
+		t.Helper()
+		before := reads()
+		r := e.Execute(sql)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+		if len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != 700 {
+			t.Fatalf("%s = %v, want the row with id 700", sql, r.Rows)
+		}
+		return reads() - before
+	}
+	indexed := misses("SELECT id FROM t WHERE id = 700")
+	scanned := misses("SELECT id FROM t WHERE code = 700")
+
+	tableID, _ := e.catalog.GetTableID("t")
+	pages, _ := e.catalog.GetTableHeap(tableID).Pages()
+	if scanned < uint64(len(pages))-16 {
+		t.Errorf("unindexed lookup missed %d pages, want at least %d of the %d heap pages", scanned, len(pages)-16, len(pages))
+	}
+	// A root-to-leaf descent plus the one heap page
+	if indexed > 4 {
+		t.Errorf("indexed lookup missed %d pages, want at most 4", indexed)
+	}
+}
+
+func TestEngineIndexLookupOlderVersion(t *testing.T) {
+	e, err := New(Config{DataDir: t.TempDir(), BufferPoolSize: 16})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	mustExec := func(s *Session, query string) *sql.Result {
+		t.Helper()
+		r := s.Execute(query)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", query, r.Error)
+		}
+		return r
+	}
+	s1 := e.NewSession()
+	defer s1.Close()
+	s2 := e.NewSession()
+	defer s2.Close()
+
+	mustExec(s1, "CREATE TABLE t (id INT UNIQUE, code INT, body TEXT)")
+	mustExec(s1, "BEGIN")
+	for i := 0; i < 1000; i++ {
+		mustExec(s1, fmt.Sprintf("INSERT INTO t VALUES (%d, %d, '%s')", i, i, strings.Repeat("x", 100)))
+	}
+	mustExec(s1, "COMMIT")
+
+	mustExec(s2, "BEGIN ISOLATION LEVEL REPEATABLE READ")
+	mustExec(s2, "SELECT id FROM t WHERE id = 0")
+	mustExec(s1, "UPDATE t SET code = -1 WHERE id = 700")
+	mustExec(s1, "UPDATE t SET code = -2 WHERE id = 700")
+	mustExec(s1, "UPDATE t SET id = 2000 WHERE id = 701")
+
+	// The index points at the newest version of id 700; the older snapshot
+	// follows the version chain back instead of scanning the table
+	reads := func() uint64 {
+		_, misses, _ := e.bufferPool.Stats()
+		return misses + e.bufferPool.Prefetches()
+	}
+	before := reads()
+	r := mustExec(s2, "SELECT code FROM t WHERE id = 700")
+	if len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != 700 {
+		t.Errorf("SELECT code WHERE id = 700 in the old snapshot = %v, want 700", r.Rows)
+	}
+	if n := reads() - before; n > 6 {
+		t.Errorf("indexed lookup of an older version missed %d pages, want at most 6", n)
+	}
+
+	// A key the row only has in a newer version is not seen, and the old
+	// key is still found
+	if r := mustExec(s2, "SELECT code FROM t WHERE id = 2000"); len(r.Rows) != 0 {
+		t.Errorf("SELECT WHERE id = 2000 in the old snapshot = %v, want no rows", r.Rows)
+	}
+	if r := mustExec(s2, "SELECT code FROM t WHERE id = 701"); len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != 701 {
+		t.Errorf("SELECT WHERE id = 701 in the old snapshot = %v, want 701", r.Rows)
+	}
+	mustExec(s2, "COMMIT")
+
+	if r := mustExec(s2, "SELECT code FROM t WHERE id = 700"); len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != -2 {
+		t.Errorf("SELECT code WHERE id = 700 after COMMIT = %v, want -2", r.Rows)
+	}
+}
+
+func TestEngineIndexRangeScan(t *testing.T) {
+	e, err := New(Config{DataDir: t.TempDir(), BufferPoolSize: 16})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	// id is indexed as the PRIMARY KEY; code holds the same values
+	e.Execute("CREATE TABLE t (id INT PRIMARY KEY, code INT, body TEXT)")
+	e.Execute("BEGIN")
+	for i := 0; i < 1000; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO t VALUES (%d, %d, '%s')", i, i, strings.Repeat("x", 100)))
+	}
+	e.Execute("COMMIT")
+	e.Execute("DELETE FROM t WHERE id = 995")
+
+	ranges := []struct {
+		where string
+		want  int
+	}{
+		{"id >= 990", 9},
+		{"id > 990", 8},
+		{"id < 10", 10},
+		{"id <= 10", 11},
+		{"990 < id", 8},
+		{"10 >= id", 11},
+		{"id > 5000", 0},
+		{"id < -1", 0},
+	}
+	for _, tt := range ranges {
+		query := "SELECT id FROM t WHERE " + tt.where
+		plan := e.Execute("EXPLAIN " + query)
+		if plan.Error != nil || !strings.HasPrefix(plan.Rows[0].Values[0].StrVal, "Index Scan using t_id") {
+			t.Errorf("EXPLAIN %s = %v, %v; want an index scan", query, plan.Rows, plan.Error)
+		}
+		indexed := e.Execute(query)
+		scanned := e.Execute("SELECT /*+ seqscan */ id FROM t WHERE " + tt.where)
+		if indexed.Error != nil || scanned.Error != nil {
+			t.Fatalf("%s: errors = %v, %v", query, indexed.Error, scanned.Error)
+		}
+		if len(indexed.Rows) != tt.want || len(scanned.Rows) != tt.want {
+			t.Errorf("%s = %d rows via index, %d via scan; want %d", query, len(indexed.Rows), len(scanned.Rows), tt.want)
+		}
+	}
+
+	// Both columns hold the same values, but only id is indexed
+	reads := func(sql string) uint64 {
+		t.Helper()
+		_, before, _ := e.bufferPool.Stats()
+		before += e.bufferPool.Prefetches()
+		r := e.Execute(sql)
+		if r.Error != nil || len(r.Rows) != 9 {
+			t.Fatalf("%s = %d rows, %v; want 9", sql, len(r.Rows), r.Error)
+		}
+		_, after, _ := e.bufferPool.Stats()
+		return after + e.bufferPool.Prefetches() - before
+	}
+	indexed := reads("SELECT id FROM t WHERE id >= 990")
+	scanned := reads("SELECT id FROM t WHERE code >= 990")
+
+	tableID, _ := e.catalog.GetTableID("t")
+	pages, _ := e.catalog.GetTableHeap(tableID).Pages()
+	if scanned < uint64(len(pages))-16 {
+		t.Errorf("unindexed range read %d pages, want at least %d of the %d heap pages", scanned, len(pages)-16, len(pages))
+	}
+	// A root-to-leaf descent, a leaf or two and the last heap pages
+	if indexed > 6 {
+		t.Errorf("indexed range read %d pages, want at most 6", indexed)
+	}
+}
+
+func TestEngineMultipleIndexes(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT, email TEXT, age INT)")
+	for i := 0; i < 100; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, 'user%d@example.com', %d)", i, i, 1000+i))
+	}
+	if err := e.CreateIndex("users", "email"); err != nil {
+		t.Fatalf("CreateIndex(email) error = %v", err)
+	}
+	if err := e.CreateIndex("users", "age"); err != nil {
+		t.Fatalf("CreateIndex(age) error = %v", err)
+	}
+	if err := e.CreateIndex("users", "age"); err == nil {
+		t.Error("CreateIndex() on an already indexed column should error")
+	}
+	e.Execute("INSERT INTO users VALUES (100, 'late@example.com', 2000)")
+	e.Close()
+
+	e, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer e.Close()
+
+	lookups := []struct {
+		query string
+		index string
+		id    int64
+	}{
+		{"SELECT id FROM users WHERE email = 'user42@example.com'", "users_email", 42},
+		{"SELECT id FROM users WHERE age = 1042", "users_age", 42},
+		{"SELECT id FROM users WHERE email = 'late@example.com'", "users_email", 100},
+		{"SELECT id FROM users WHERE age = 2000", "users_age", 100},
+	}
+	for _, tt := range lookups {
+		plan := e.Execute("EXPLAIN " + tt.query)
+		if plan.Error != nil || !strings.Contains(plan.Rows[0].Values[0].StrVal, "Index Scan using "+tt.index) {
+			t.Errorf("EXPLAIN %s = %v, %v; want an index scan using %s", tt.query, plan.Rows, plan.Error, tt.index)
+		}
+		r := e.Execute(tt.query)
+		if r.Error != nil || len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != tt.id {
+			t.Errorf("%s = %v, %v; want id %d", tt.query, r.Rows, r.Error, tt.id)
+		}
+	}
+
+	// Dropping one index leaves the other
+	if err := e.DropIndex("users", "email"); err != nil {
+		t.Fatalf("DropIndex(email) error = %v", err)
+	}
+	tableID, _ := e.catalog.GetTableID("users")
+	if e.GetIndex(tableID, "email") != nil || e.GetIndex(tableID, "age") == nil {
+		t.Error("DropIndex(email) should leave only the index on age")
+	}
+	if r := e.Execute("SELECT /*+ index(users_age) */ id FROM users WHERE age = 1007"); r.Error != nil || len(r.Rows) != 1 {
+		t.Errorf("lookup through the remaining index = %v, %v", r.Rows, r.Error)
+	}
+}
+
+func TestEngineIndexOnDuplicateValues(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	long := strings.Repeat("x", 60)
+	e.Execute("CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
+	for i, name := range []string{"a", "b", "a", "c", "a", long + "1", long + "2"} {
+		e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, '%s')", i+1, name))
+	}
+	if err := e.CreateIndex("users", "name"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	ids := func(query string) string {
+		t.Helper()
+		r := e.Execute(query)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", query, r.Error)
+		}
+		var ids []string
+		for _, row := range r.Rows {
+			ids = append(ids, row.Values[0].String())
+		}
+		return strings.Join(ids, ",")
+	}
+	lookups := func(when string) {
+		t.Helper()
+		plan := e.Execute("EXPLAIN SELECT id FROM users WHERE name = 'a'")
+		if plan.Error != nil || !strings.HasPrefix(plan.Rows[0].Values[0].StrVal, "Index Scan using users_name") {
+			t.Errorf("%s: EXPLAIN = %v, %v; want an index scan", when, plan.Rows, plan.Error)
+		}
+		// Every row holding the value is found, and values longer than the
+		// key that share its prefix are told apart
+		tests := []struct{ name, want string }{
+			{"a", "1,3,5"}, {"b", "2"}, {"z", ""}, {long + "2", "7"},
+		}
+		for _, tt := range tests {
+			query := fmt.Sprintf("SELECT /*+ index(users_name) */ id FROM users WHERE name = '%s' ORDER BY id", tt.name)
+			if got := ids(query); got != tt.want {
+				t.Errorf("%s: WHERE name = '%.8s' = %s, want %s", when, tt.name, got, tt.want)
+			}
+		}
+	}
+	lookups("after CreateIndex")
+
+	// Each version has its own entry, so an older snapshot still finds the
+	// rows as they were
+	e.Execute("BEGIN ISOLATION LEVEL REPEATABLE READ")
+	if got := ids("SELECT id FROM users WHERE name = 'b'"); got != "2" {
+		t.Errorf("WHERE name = 'b' = %s, want 2", got)
+	}
+	other := e.NewSession()
+	if r := other.Execute("UPDATE users SET name = 'b' WHERE id = 3"); r.Error != nil {
+		t.Fatalf("UPDATE error = %v", r.Error)
+	}
+	other.Close()
+	if got := ids("SELECT id FROM users WHERE name = 'a' ORDER BY id"); got != "1,3,5" {
+		t.Errorf("WHERE name = 'a' in the old snapshot = %s, want 1,3,5", got)
+	}
+	e.Execute("COMMIT")
+	e.Execute("UPDATE users SET name = 'a' WHERE id = 3")
+
+	// Entries of reclaimed versions go, and the index survives a reopen
+	if _, err := e.Vacuum(); err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	e.Close()
+	e, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e.Close()
+	lookups("after VACUUM and reopen")
+}
+
+func TestEngineDropIndex(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	for i := 0; i < 200; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, 'user%d')", i, i))
+	}
+	if err := e.CreateIndex("users", "id"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+	tableID, _ := e.catalog.GetTableID("users")
+	indexPages, err := e.GetIndex(tableID, "id").Pages()
+	if err != nil {
+		t.Fatalf("Pages() error = %v", err)
+	}
+
+	if err := e.DropIndex("users", "id"); err != nil {
+		t.Fatalf("DropIndex() error = %v", err)
+	}
+	if e.GetIndex(tableID, "id") != nil {
+		t.Error("GetIndex() after DropIndex should be nil")
+	}
+	if _, ok := e.catalog.GetIndexRoot(tableID, "id"); ok {
+		t.Error("catalog still has an index root after DropIndex")
+	}
+
+	// Lookups fall back to scanning
+	r := e.Execute("SELECT name FROM users WHERE id = 150")
+	if r.Error != nil || len(r.Rows) != 1 || r.Rows[0].Values[0].StrVal != "user150" {
+		t.Errorf("SELECT after DropIndex = %v, %v; want user150", r.Rows, r.Error)
+	}
+	if r := e.Execute("EXPLAIN SELECT name FROM users WHERE id = 150"); r.Error != nil || strings.Contains(fmt.Sprint(r.Rows), "Index") {
+		t.Errorf("EXPLAIN after DropIndex = %v, %v; want a sequential scan", r.Rows, r.Error)
+	}
+
+	// The tree's pages are reused before the file grows
+	numPages := e.diskManager.GetNumPages()
+	if err := e.CreateIndex("users", "id"); err != nil {
+		t.Fatalf("CreateIndex() after DropIndex error = %v", err)
+	}
+	if got := e.diskManager.GetNumPages(); got > numPages {
+		t.Errorf("data file grew from %d to %d pages rebuilding a %d-page index", numPages, got, len(indexPages))
+	}
+
+	if err := e.DropIndex("users", "id"); err != nil {
+		t.Fatalf("DropIndex() error = %v", err)
+	}
+	if err := e.DropIndex("users", "id"); err == nil {
+		t.Error("DropIndex() without an index should error")
+	}
+	if err := e.DropIndex("users", "name"); err == nil {
+		t.Error("DropIndex() on an unindexed column should error")
+	}
+	if err := e.DropIndex("missing", "id"); err == nil {
+		t.Error("DropIndex() on a missing table should error")
+	}
+}
+
+func TestEngineIndexMaintainedOnInsert(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'Alice')")
+
+	if err := e.CreateIndex("users", "id"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	// Insert after index creation
+	e.Execute("INSERT INTO users VALUES (4, 'Dave')")
+
+	result := e.Execute("SELECT * FROM users WHERE id = 4")
+	if result.Error != nil {
+		t.Fatalf("SELECT error = %v", result.Error)
+	}
+	if len(result.Rows) != 1 {
+		t.Fatalf("rows = %d, want 1", len(result.Rows))
+	}
+	if result.Rows[0].Values[1].StrVal != "Dave" {
+		t.Errorf("name = %q, want Dave", result.Rows[0].Values[1].StrVal)
+	}
+}
+
+func TestEngineIndexMaintainedOnUpdate(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'Alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'Bob')")
+	e.Execute("INSERT INTO users VALUES (3, 'Charlie')")
+
+	if err := e.CreateIndex("users", "id"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	e.Execute("UPDATE users SET name = 'Eve' WHERE id = 3")
+
+	result := e.Execute("SELECT * FROM users WHERE id = 3")
+	if result.Error != nil {
+		t.Fatalf("SELECT error = %v", result.Error)
+	}
+	if len(result.Rows) != 1 {
+		t.Fatalf("rows = %d, want 1", len(result.Rows))
+	}
+	if result.Rows[0].Values[1].StrVal != "Eve" {
+		t.Errorf("name = %q, want Eve", result.Rows[0].Values[1].StrVal)
+	}
+}
+
+func TestEngineIndexAfterVacuum(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'Alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'Bob')")
+	e.Execute("INSERT INTO users VALUES (3, 'Charlie')")
+
+	if err := e.CreateIndex("users", "id"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	e.Execute("DELETE FROM users WHERE id = 1")
+
+	_, err := e.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+
+	// Deleted row should not be found
+	result := e.Execute("SELECT * FROM users WHERE id = 1")
+	if result.Error != nil {
+		t.Fatalf("SELECT error = %v", result.Error)
+	}
+	if len(result.Rows) != 0 {
+		t.Errorf("rows for deleted id=1 = %d, want 0", len(result.Rows))
+	}
+
+	// Existing row should still be found
+	result = e.Execute("SELECT * FROM users WHERE id = 2")
+	if result.Error != nil {
+		t.Fatalf("SELECT error = %v", result.Error)
+	}
+	if len(result.Rows) != 1 {
+		t.Errorf("rows for id=2 = %d, want 1", len(result.Rows))
+	}
+}
+
+func itoa(n int) string {
+	if n == 0 {
+		return "0"
+	}
+	s := ""
+	neg := false
+	if n < 0 {
+		neg = true
+		n = -n
+	}
+	for n > 0 {
+		s = string(rune('0'+n%10)) + s
+		n /= 10
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+func TestEngineQueryCacheHit(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100, QueryCacheSize: 16})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+
+	first := e.Execute("SELECT * FROM users WHERE id = 2")
+	if first.Error != nil {
+		t.Fatalf("SELECT error = %v", first.Error)
+	}
+
+	hitsBefore, missesBefore, _ := e.bufferPool.Stats()
+
+	// Same query with different spacing and keyword case
+	second := e.Execute("select *  FROM users where id = 2")
+	if second.Error != nil {
+		t.Fatalf("cached SELECT error = %v", second.Error)
+	}
+
+	hitsAfter, missesAfter, _ := e.bufferPool.Stats()
+	if hitsAfter != hitsBefore || missesAfter != missesBefore {
+		t.Errorf("cached SELECT touched buffer pool: hits %d->%d, misses %d->%d",
+			hitsBefore, hitsAfter, missesBefore, missesAfter)
+	}
+	if len(second.Rows) != 1 || second.Rows[0].Values[1].StrVal != "bob" {
+		t.Errorf("cached rows = %v, want [2 bob]", second.Rows)
+	}
+	if e.Stats()["query_cache_hits"] != uint64(1) {
+		t.Errorf("query_cache_hits = %v, want 1", e.Stats()["query_cache_hits"])
+	}
+}
+
+func TestEngineQueryCacheInvalidatedByWrite(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100, QueryCacheSize: 16})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("CREATE TABLE other (id INT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+
+	if r := e.Execute("SELECT * FROM users"); len(r.Rows) != 1 {
+		t.Fatalf("rows = %d, want 1", len(r.Rows))
+	}
+
+	// A write to an unrelated table leaves the entry in place
+	e.Execute("INSERT INTO other VALUES (1)")
+	e.Execute("SELECT * FROM users")
+	if e.Stats()["query_cache_hits"] != uint64(1) {
+		t.Errorf("query_cache_hits = %v, want 1", e.Stats()["query_cache_hits"])
+	}
+
+	// A transaction that wrote to the table is never served from the cache
+	e.Execute("BEGIN")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+	e.Execute("SELECT * FROM users")
+	if e.Stats()["query_cache_hits"] != uint64(1) {
+		t.Errorf("query_cache_hits inside writing txn = %v, want 1", e.Stats()["query_cache_hits"])
+	}
+	e.Execute("COMMIT")
+
+	r := e.Execute("SELECT * FROM users")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if len(r.Rows) != 2 {
+		t.Errorf("rows after committed write = %d, want 2", len(r.Rows))
+	}
+}
+
+func TestEngineQueryCacheStalePut(t *testing.T) {
+	e, err := New(Config{DataDir: t.TempDir(), BufferPoolSize: 100, QueryCacheSize: 16})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	tableID, _ := e.catalog.GetTableID("users")
+
+	// A result read before a commit whose invalidation has already run
+	// must not be cached, or later SELECTs would keep seeing it
+	seq := e.txnManager.CommitSeq()
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.queryCache.Put("stale", tableID, seq, &sql.Result{Message: "SELECT 0 rows"})
+	if _, ok := e.queryCache.Get("stale"); ok {
+		t.Error("result read before a commit was cached")
+	}
+
+	seq = e.txnManager.CommitSeq()
+	e.queryCache.Put("current", tableID, seq, &sql.Result{Message: "SELECT 1 rows"})
+	if _, ok := e.queryCache.Get("current"); !ok {
+		t.Error("result read at the current commit was not cached")
+	}
+}
+
+func TestEngineSelectWithoutFrom(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	tests := []struct {
+		sql  string
+		want types.Value
+	}{
+		{"SELECT 1 + 1", types.Value{Type: types.ValueTypeInt, IntVal: 2}},
+		{"SELECT (10 - 4) / 3", types.Value{Type: types.ValueTypeInt, IntVal: 2}},
+		{"SELECT -5 * 2", types.Value{Type: types.ValueTypeInt, IntVal: -10}},
+		{"SELECT TRUE AND FALSE", types.Value{Type: types.ValueTypeBool, BoolVal: false}},
+		{"SELECT NOT FALSE", types.Value{Type: types.ValueTypeBool, BoolVal: true}},
+		{"SELECT 3 > 2", types.Value{Type: types.ValueTypeBool, BoolVal: true}},
+		{"SELECT 1 / 0", types.Value{IsNull: true}},
+	}
+
+	for _, tt := range tests {
+		result := e.Execute(tt.sql)
+		if result.Error != nil {
+			t.Errorf("%s: error = %v", tt.sql, result.Error)
+			continue
+		}
+		if len(result.Rows) != 1 || len(result.Rows[0].Values) != 1 {
+			t.Errorf("%s: rows = %v, want a single value", tt.sql, result.Rows)
+			continue
+		}
+		if got := result.Rows[0].Values[0]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %+v, want %+v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestEngineSelectFunctionsWithoutFrom(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	result := e.Execute("SELECT UPPER('hi'), lower('HI'), LENGTH('hello'), ABS(-3), SUBSTR('minidb', 1, 4), COALESCE(NULL, 'x')")
+	if result.Error != nil {
+		t.Fatalf("SELECT error = %v", result.Error)
+	}
+	if len(result.Rows) != 1 {
+		t.Fatalf("rows = %d, want 1", len(result.Rows))
+	}
+
+	got := result.Rows[0].Values
+	want := []string{"HI", "hi", "5", "3", "mini", "x"}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("column %s = %v, want %s", result.Columns[i], got[i], want[i])
+		}
+	}
+	if result.Columns[0] != "UPPER('hi')" {
+		t.Errorf("Columns[0] = %q, want %q", result.Columns[0], "UPPER('hi')")
+	}
+}
+
+func TestEngineIntegerOverflow(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE t (id INT, v INT)")
+	if r := e.Execute("INSERT INTO t VALUES (1, 9223372036854775808)"); r.Error == nil {
+		t.Error("INSERT of a literal beyond INT range should error")
+	}
+	if r := e.Execute("INSERT INTO t VALUES (1, 9223372036854775807), (2, -9223372036854775808), (3, 1)"); r.Error != nil {
+		t.Fatalf("INSERT of the INT bounds error = %v", r.Error)
+	}
+
+	for _, sql := range []string{
+		"SELECT 9223372036854775807 + 1",
+		"SELECT -9223372036854775808 - 1",
+		"SELECT 4611686018427387904 * 2",
+		"SELECT -9223372036854775808 / -1",
+		"SELECT -(-9223372036854775808)",
+		"SELECT v + 1 FROM t WHERE id = 1",
+		"SELECT SUM(v) FROM t WHERE id <> 2",
+		"UPDATE t SET v = v - 1 WHERE id = 2",
+	} {
+		r := e.Execute(sql)
+		if r.Error == nil || !strings.Contains(r.Error.Error(), "integer out of range") {
+			t.Errorf("%s: error = %v, want integer out of range", sql, r.Error)
+		}
+	}
+
+	// The failed UPDATE left the row alone, and in-range results still work
+	r := e.Execute("SELECT v, v - 1 FROM t WHERE id = 1")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if got := r.Rows[0].Values[1].String(); got != "9223372036854775806" {
+		t.Errorf("v - 1 = %s, want 9223372036854775806", got)
+	}
+	if r := e.Execute("SELECT v FROM t WHERE id = 2"); r.Rows[0].Values[0].String() != "-9223372036854775808" {
+		t.Errorf("v = %v after failed UPDATE, want -9223372036854775808", r.Rows[0].Values[0])
+	}
+}
+
+func TestEngineRegisterFunction(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	// domain(email) returns the part after the @
+	domain := func(args []types.Value) (types.Value, error) {
+		if len(args) != 1 {
+			return types.Value{}, fmt.Errorf("want 1 argument, got %d", len(args))
+		}
+		if args[0].IsNull {
+			return types.Value{IsNull: true}, nil
+		}
+		if args[0].Type != types.ValueTypeString {
+			return types.Value{}, fmt.Errorf("want TEXT")
+		}
+		_, host, ok := strings.Cut(args[0].StrVal, "@")
+		if !ok {
+			return types.Value{}, fmt.Errorf("%q is not an email address", args[0].StrVal)
+		}
+		return types.Value{Type: types.ValueTypeString, StrVal: host}, nil
+	}
+
+	if r := e.Execute("SELECT domain('a@example.com')"); r.Error == nil {
+		t.Error("calling an unregistered function should error")
+	}
+	if err := e.RegisterFunction("domain", domain); err != nil {
+		t.Fatalf("RegisterFunction() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT, email TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice@example.com')")
+	e.Execute("INSERT INTO users VALUES (2, 'bob@test.org')")
+	e.Execute("INSERT INTO users VALUES (3, NULL)")
+
+	r := e.Execute("SELECT id, DOMAIN(email) FROM users WHERE domain(email) <> 'test.org' ORDER BY id")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != 1 || r.Rows[0].Values[1].StrVal != "example.com" {
+		t.Errorf("rows = %v, want (1, example.com)", r.Rows)
+	}
+	r = e.Execute("SELECT domain(email) FROM users WHERE id = 3")
+	if r.Error != nil || len(r.Rows) != 1 || !r.Rows[0].Values[0].IsNull {
+		t.Errorf("domain(NULL) rows = %v (error %v), want NULL", r.Rows, r.Error)
+	}
+
+	// Errors from the function fail the statement before anything is written
+	for _, query := range []string{
+		"SELECT domain(id) FROM users",
+		"SELECT domain('a@b', 'c')",
+		"INSERT INTO users VALUES (4, domain('nobody'))",
+		"UPDATE users SET email = domain('nobody') WHERE id = 1",
+		"DELETE FROM users WHERE domain('nobody') = 'x'",
+	} {
+		if r := e.Execute(query); r.Error == nil || !strings.Contains(r.Error.Error(), "DOMAIN: ") {
+			t.Errorf("%s: error = %v, want an error from DOMAIN", query, r.Error)
+		}
+	}
+	r = e.Execute("SELECT email FROM users ORDER BY id")
+	if r.Error != nil || len(r.Rows) != 3 || r.Rows[0].Values[0].StrVal != "alice@example.com" {
+		t.Errorf("rows after failed statements = %v (error %v), want the 3 original rows", r.Rows, r.Error)
+	}
+
+	for _, name := range []string{"upper", "count", "domain", "select", "bad name", ""} {
+		if err := e.RegisterFunction(name, domain); err == nil {
+			t.Errorf("RegisterFunction(%q) should error", name)
+		}
+	}
+}
+
+func TestEngineRegisterFunctionNotCached(t *testing.T) {
+	e, err := New(Config{DataDir: t.TempDir(), BufferPoolSize: 100, QueryCacheSize: 16})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	calls := int64(0)
+	counter := func(args []types.Value) (types.Value, error) {
+		calls++
+		return types.Value{Type: types.ValueTypeInt, IntVal: calls}, nil
+	}
+	if err := e.RegisterFunction("counter", counter); err != nil {
+		t.Fatalf("RegisterFunction() error = %v", err)
+	}
+	e.Execute("CREATE TABLE t (id INT)")
+	e.Execute("INSERT INTO t VALUES (1)")
+
+	var got []int64
+	for i := 0; i < 2; i++ {
+		r := e.Execute("SELECT counter() FROM t")
+		if r.Error != nil || len(r.Rows) != 1 {
+			t.Fatalf("SELECT counter(): rows %v, error %v", r.Rows, r.Error)
+		}
+		got = append(got, r.Rows[0].Values[0].IntVal)
+	}
+	if got[0] == got[1] {
+		t.Errorf("two SELECT counter() returned %v, want the second one evaluated again", got)
+	}
+
+	// Statements with only built-in functions are still cached
+	e.Execute("SELECT UPPER('a') FROM t")
+	e.Execute("SELECT UPPER('a') FROM t")
+	if hits := e.Stats()["query_cache_hits"]; hits != uint64(1) {
+		t.Errorf("query_cache_hits = %v, want 1", hits)
+	}
+}
+
+func TestEngineSelectExpressionsFromTable(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE items (id INT, name TEXT)")
+	e.Execute("INSERT INTO items VALUES (1, 'pen')")
+	e.Execute("INSERT INTO items VALUES (2, 'ink')")
+
+	result := e.Execute("SELECT id * 10, UPPER(name) FROM items WHERE id = 2")
+	if result.Error != nil {
+		t.Fatalf("SELECT error = %v", result.Error)
+	}
+	if len(result.Rows) != 1 {
+		t.Fatalf("rows = %d, want 1", len(result.Rows))
+	}
+	if v := result.Rows[0].Values; v[0].IntVal != 20 || v[1].StrVal != "INK" {
+		t.Errorf("row = %v, want [20 INK]", v)
+	}
+}
+
+func TestEngineSelectComputedColumns(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE items (name TEXT, price INT PRIMARY KEY, qty INT)")
+	e.Execute("INSERT INTO items VALUES ('pen', 150, 2)")
+	e.Execute("INSERT INTO items VALUES ('ink', 40, 0)")
+	e.Execute("INSERT INTO items VALUES ('pad', 90, 3)")
+
+	r := e.Execute("SELECT name, price * qty AS total, price > 100 AS pricey, price / qty FROM items ORDER BY total DESC")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if got := strings.Join(r.Columns, ","); got != "name,total,pricey,price / qty" {
+		t.Errorf("Columns = %s", got)
+	}
+	var got []string
+	for _, row := range r.Rows {
+		got = append(got, fmt.Sprint(row.Values))
+	}
+	// Ordered by the alias; division by zero is NULL
+	want := "[pen 300 true 75] [pad 270 false 30] [ink 0 false NULL]"
+	if strings.Join(got, " ") != want {
+		t.Errorf("rows = %s, want %s", strings.Join(got, " "), want)
+	}
+
+	// An alias names the column SELECT INTO creates
+	if r := e.Execute("SELECT name, price * qty AS total INTO totals FROM items"); r.Error != nil {
+		t.Fatalf("SELECT INTO error = %v", r.Error)
+	}
+	if r := e.Execute("SELECT name FROM totals WHERE total > 100 ORDER BY total"); r.Error != nil || len(r.Rows) != 2 || r.Rows[0].Values[0].StrVal != "pad" {
+		t.Errorf("totals rows = %v (error %v), want pad, pen", r.Rows, r.Error)
+	}
+
+	// An alias shadowing the indexed column (the PRIMARY KEY is indexed at
+	// CREATE TABLE) does not use the index order
+	r = e.Execute("SELECT name, 0 - price AS price FROM items ORDER BY price LIMIT 1")
+	if r.Error != nil || len(r.Rows) != 1 || r.Rows[0].Values[0].StrVal != "pen" {
+		t.Errorf("rows = %v (error %v), want pen", r.Rows, r.Error)
+	}
+}
+
+func TestEngineSerialColumn(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ids := func() []int64 {
+		t.Helper()
+		r := e.Execute("SELECT id FROM users ORDER BY name")
+		if r.Error != nil {
+			t.Fatalf("SELECT error = %v", r.Error)
+		}
+		var ids []int64
+		for _, row := range r.Rows {
+			ids = append(ids, row.Values[0].IntVal)
+		}
+		return ids
+	}
+
+	e.Execute("CREATE TABLE users (id SERIAL PRIMARY KEY, name TEXT)")
+	for _, name := range []string{"a", "b", "c"} {
+		if r := e.Execute(fmt.Sprintf("INSERT INTO users (name) VALUES ('%s')", name)); r.Error != nil {
+			t.Fatalf("INSERT error = %v", r.Error)
+		}
+	}
+	if got := fmt.Sprint(ids()); got != "[1 2 3]" {
+		t.Fatalf("ids = %s, want [1 2 3]", got)
+	}
+
+	// An explicit larger value moves the sequence past it
+	e.Execute("INSERT INTO users (id, name) VALUES (10, 'd')")
+	e.Execute("INSERT INTO users (name) VALUES ('e')")
+	// A smaller one leaves it alone
+	e.Execute("INSERT INTO users (id, name) VALUES (5, 'f')")
+	e.Execute("INSERT INTO users (name) VALUES ('g')")
+	if got := fmt.Sprint(ids()); got != "[1 2 3 10 11 5 12]" {
+		t.Fatalf("ids = %s, want [1 2 3 10 11 5 12]", got)
+	}
+	e.Close()
+
+	// The sequence survives a reopen
+	e, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer e.Close()
+	e.Execute("INSERT INTO users (name) VALUES ('h')")
+	if got := fmt.Sprint(ids()); got != "[1 2 3 10 11 5 12 13]" {
+		t.Errorf("ids after reopen = %s, want [1 2 3 10 11 5 12 13]", got)
+	}
+}
+
+func TestEngineTruncateIdentity(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id SERIAL, name TEXT)")
+	e.Execute("INSERT INTO users (name) VALUES ('alice')")
+	e.Execute("INSERT INTO users (name) VALUES ('bob')")
+
+	// CONTINUE IDENTITY (the default) keeps the sequence
+	if r := e.Execute("TRUNCATE TABLE users"); r.Error != nil {
+		t.Fatalf("TRUNCATE error = %v", r.Error)
+	}
+	if r := e.Execute("SELECT * FROM users"); len(r.Rows) != 0 {
+		t.Fatalf("rows after TRUNCATE = %d, want 0", len(r.Rows))
+	}
+	e.Execute("INSERT INTO users (name) VALUES ('carol')")
+	r := e.Execute("SELECT id FROM users")
+	if len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != 3 {
+		t.Fatalf("id after CONTINUE IDENTITY = %v, want 3", r.Rows)
+	}
+
+	// RESTART IDENTITY resets it
+	if r := e.Execute("TRUNCATE TABLE users RESTART IDENTITY"); r.Error != nil {
+		t.Fatalf("TRUNCATE RESTART IDENTITY error = %v", r.Error)
+	}
+	e.Execute("INSERT INTO users (name) VALUES ('dave')")
+	r = e.Execute("SELECT id, name FROM users")
+	if len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != 1 {
+		t.Fatalf("id after RESTART IDENTITY = %v, want 1", r.Rows)
+	}
+}
+
+func TestEngineTruncate(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
+	e.Execute("BEGIN")
+	for i := 1; i <= 300; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, 'user %d padded out to fill pages')", i, i))
+	}
+	e.Execute("COMMIT")
+	before, err := e.TablePages("users")
+	if err != nil {
+		t.Fatalf("TablePages() error = %v", err)
+	}
+	if len(before) < 2 {
+		t.Fatalf("pages before TRUNCATE = %d, want several", len(before))
+	}
+	count := func() int {
+		t.Helper()
+		r := e.Execute("SELECT * FROM users")
+		if r.Error != nil {
+			t.Fatalf("SELECT error = %v", r.Error)
+		}
+		return len(r.Rows)
+	}
+
+	// A rolled-back TRUNCATE leaves the rows and the index as they were
+	e.Execute("BEGIN")
+	if r := e.Execute("TRUNCATE TABLE users"); r.Error != nil {
+		t.Fatalf("TRUNCATE error = %v", r.Error)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("rows inside the transaction = %d, want 0", n)
+	}
+	e.Execute("ROLLBACK")
+	if n := count(); n != 300 {
+		t.Fatalf("rows after ROLLBACK = %d, want 300", n)
+	}
+	if r := e.Execute("SELECT name FROM users WHERE id = 42"); len(r.Rows) != 1 {
+		t.Errorf("index lookup after ROLLBACK = %v, want one row", r.Rows)
+	}
+	if r := e.Execute("INSERT INTO users VALUES (42, 'again')"); r.Error == nil {
+		t.Error("duplicate key after ROLLBACK should fail")
+	}
+
+	if r := e.Execute("TRUNCATE TABLE users"); r.Error != nil {
+		t.Fatalf("TRUNCATE error = %v", r.Error)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("rows after TRUNCATE = %d, want 0", n)
+	}
+	after, err := e.TablePages("users")
+	if err != nil {
+		t.Fatalf("TablePages() error = %v", err)
+	}
+	if len(after) != 1 {
+		t.Errorf("pages after TRUNCATE = %d, want 1", len(after))
+	}
+	// The emptied index takes the old keys again
+	if r := e.Execute("INSERT INTO users VALUES (42, 'again')"); r.Error != nil {
+		t.Fatalf("INSERT after TRUNCATE error = %v", r.Error)
+	}
+	e.Close()
+
+	e, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer e.Close()
+	r := e.Execute("SELECT name FROM users WHERE id = 42")
+	if r.Error != nil || len(r.Rows) != 1 || r.Rows[0].Values[0].StrVal != "again" {
+		t.Errorf("after reopen = %v (error %v), want the one row inserted after TRUNCATE", r.Rows, r.Error)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("rows after reopen = %d, want 1", n)
+	}
+	// The old heap's pages are no longer reachable
+	repaired, err := e.Repair()
+	if err != nil {
+		t.Fatalf("Repair() error = %v", err)
+	}
+	if len(repaired.Reclaimed) < len(before) {
+		t.Errorf("Repair reclaimed %d pages, want at least the %d of the old heap", len(repaired.Reclaimed), len(before))
+	}
+}
+
+func TestEngineRequireWhereForMutation(t *testing.T) {
+	e, err := New(Config{DataDir: t.TempDir(), BufferPoolSize: 100, RequireWhereForMutation: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+
+	// WHERE-less mutations are rejected
+	for _, stmt := range []string{"UPDATE users SET name = 'x'", "DELETE FROM users"} {
+		r := e.Execute(stmt)
+		if r.Error == nil || !strings.Contains(r.Error.Error(), "without WHERE") {
+			t.Errorf("%s: error = %v, want missing WHERE error", stmt, r.Error)
+		}
+	}
+	if r := e.Execute("SELECT * FROM users WHERE name = 'x'"); len(r.Rows) != 0 {
+		t.Errorf("rejected UPDATE modified %d rows", len(r.Rows))
+	}
+
+	// A WHERE clause, including WHERE TRUE, is allowed
+	if r := e.Execute("UPDATE users SET name = 'carol' WHERE id = 1"); r.Error != nil {
+		t.Errorf("UPDATE with WHERE error = %v", r.Error)
+	}
+	if r := e.Execute("DELETE FROM users WHERE TRUE"); r.Error != nil || r.Message != "DELETE 2" {
+		t.Errorf("DELETE WHERE TRUE = %q, %v; want DELETE 2", r.Message, r.Error)
+	}
+}
+
+func TestEngineWherelessMutationAllowedByDefault(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT)")
+	e.Execute("INSERT INTO users VALUES (1)")
+
+	if r := e.Execute("UPDATE users SET id = 2"); r.Error != nil {
+		t.Errorf("UPDATE without WHERE error = %v", r.Error)
+	}
+	if r := e.Execute("DELETE FROM users"); r.Error != nil || r.Message != "DELETE 1" {
+		t.Errorf("DELETE without WHERE = %q, %v; want DELETE 1", r.Message, r.Error)
+	}
+}
+
+func TestEngineCommentsPersist(t *testing.T) {
+	dir := t.TempDir()
+
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	if r := e.Execute("COMMENT ON TABLE users IS 'app users'"); r.Error != nil {
+		t.Fatalf("COMMENT ON TABLE error = %v", r.Error)
+	}
+	if r := e.Execute("COMMENT ON COLUMN users.id IS 'primary id'"); r.Error != nil {
+		t.Fatalf("COMMENT ON COLUMN error = %v", r.Error)
+	}
+	if r := e.Execute("COMMENT ON COLUMN users.missing IS 'x'"); r.Error == nil {
+		t.Error("COMMENT on a missing column should error")
+	}
+	e.Close()
+
+	// Reopen
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e2.Close()
+
+	r := e2.Execute("DESCRIBE users")
+	if r.Error != nil {
+		t.Fatalf("DESCRIBE error = %v", r.Error)
+	}
+	if r.Message != "TABLE users: app users" {
+		t.Errorf("Message = %q, want table comment", r.Message)
+	}
+	if len(r.Rows) != 2 {
+		t.Fatalf("rows = %d, want 2", len(r.Rows))
+	}
+	if got := r.Rows[0].Values; got[0].StrVal != "id" || got[1].StrVal != "INT" || got[3].StrVal != "primary id" {
+		t.Errorf("id row = %v", got)
+	}
+	if got := r.Rows[1].Values[3]; !got.IsNull {
+		t.Errorf("name comment = %v, want NULL", got)
+	}
+
+	// IS NULL removes a comment
+	e2.Execute("COMMENT ON COLUMN users.id IS NULL")
+	if got := e2.Execute("DESCRIBE users").Rows[0].Values[3]; !got.IsNull {
+		t.Errorf("id comment after IS NULL = %v, want NULL", got)
+	}
+}
+
+func TestEngineInsertPipeline(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	r := e.Execute("CREATE TABLE users (id INT PRIMARY KEY, email TEXT UNIQUE, role TEXT NOT NULL DEFAULT 'member', active BOOL DEFAULT TRUE, note TEXT)")
+	if r.Error != nil {
+		t.Fatalf("CREATE TABLE error = %v", r.Error)
+	}
+
+	// Success: named subset, defaults applied, the rest NULL
+	if r := e.Execute("INSERT INTO users (id, email) VALUES (1, 'a@x')"); r.Error != nil {
+		t.Fatalf("INSERT error = %v", r.Error)
+	}
+	r = e.Execute("SELECT id, email, role, active, note FROM users")
+	if len(r.Rows) != 1 {
+		t.Fatalf("rows = %d, want 1", len(r.Rows))
+	}
+	got := r.Rows[0].Values
+	if got[2].StrVal != "member" || !got[3].BoolVal || !got[4].IsNull {
+		t.Errorf("row = %v, want defaults [member true NULL]", got)
+	}
+
+	failures := []struct {
+		sql  string
+		want string
+	}{
+		{"INSERT INTO users (id, nope) VALUES (2, 'x')", "column nope does not exist"},
+		{"INSERT INTO users (id, role) VALUES (2, NULL)", "column role: NULL value violates NOT NULL constraint"},
+		{"INSERT INTO users (email) VALUES ('b@x')", "column id: NULL value violates NOT NULL constraint"},
+		{"INSERT INTO users (id, active) VALUES (2, 'yes')", "column active: expected BOOL, got TEXT"},
+		{"INSERT INTO users (id, email) VALUES (1, 'b@x')", "column id: duplicate value 1 violates PRIMARY KEY constraint"},
+		{"INSERT INTO users (id, email) VALUES (2, 'a@x')", "column email: duplicate value a@x violates UNIQUE constraint"},
+	}
+	for _, tt := range failures {
+		r := e.Execute(tt.sql)
+		if r.Error == nil || !strings.Contains(r.Error.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.sql, r.Error, tt.want)
+		}
+	}
+
+	// Rejected inserts left nothing behind, and NULLs never collide
+	e.Execute("INSERT INTO users (id) VALUES (2)")
+	if r := e.Execute("INSERT INTO users (id) VALUES (3)"); r.Error != nil {
+		t.Errorf("second NULL email error = %v", r.Error)
+	}
+	if r := e.Execute("SELECT * FROM users"); len(r.Rows) != 3 {
+		t.Errorf("rows = %d, want 3", len(r.Rows))
+	}
+}
+
+func TestEngineColumnTypeChecks(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE t (id INT, name TEXT, active BOOL)")
+	if r := e.Execute("INSERT INTO t VALUES (1, 'a', TRUE)"); r.Error != nil {
+		t.Fatalf("INSERT error = %v", r.Error)
+	}
+
+	failures := []struct {
+		sql  string
+		want string
+	}{
+		{"INSERT INTO t VALUES ('2', 'b', TRUE)", "column id: expected INT, got TEXT"},
+		{"INSERT INTO t VALUES (2, 'b', 1)", "column active: expected BOOL, got INT"},
+		{"INSERT INTO t VALUES (TRUE, 'b', TRUE)", "column id: expected INT, got BOOL"},
+		{"UPDATE t SET id = 'x' WHERE id = 1", "column id: expected INT, got TEXT"},
+		{"UPDATE t SET active = 0", "column active: expected BOOL, got INT"},
+		{"UPDATE t SET name = 5", "column name: expected TEXT, got INT"},
+		{"UPDATE t SET nope = 1", "column nope does not exist"},
+	}
+	for _, tt := range failures {
+		r := e.Execute(tt.sql)
+		if r.Error == nil || !strings.Contains(r.Error.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.sql, r.Error, tt.want)
+		}
+	}
+
+	// The row is unchanged, and well-typed values and NULLs still go in
+	if r := e.Execute("UPDATE t SET name = NULL, active = FALSE WHERE id = 1"); r.Error != nil {
+		t.Fatalf("UPDATE error = %v", r.Error)
+	}
+	r := e.Execute("SELECT id, name, active FROM t")
+	if len(r.Rows) != 1 {
+		t.Fatalf("rows = %d, want 1", len(r.Rows))
+	}
+	if got := r.Rows[0].Values; got[0].IntVal != 1 || !got[1].IsNull || got[2].BoolVal {
+		t.Errorf("row = %v, want [1 NULL false]", got)
+	}
+}
+
+func TestEngineUniqueSeesOwnTransaction(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT UNIQUE)")
+	e.Execute("BEGIN")
+	e.Execute("INSERT INTO users VALUES (1)")
+	if r := e.Execute("INSERT INTO users VALUES (1)"); r.Error == nil {
+		t.Error("duplicate within one transaction should be rejected")
+	}
+	e.Execute("COMMIT")
+
+	// A deleted row no longer blocks its value
+	e.Execute("DELETE FROM users WHERE id = 1")
+	if r := e.Execute("INSERT INTO users VALUES (1)"); r.Error != nil {
+		t.Errorf("INSERT after DELETE error = %v", r.Error)
+	}
+}
+
+func TestEnginePrimaryKeyIndex(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT PRIMARY KEY, email TEXT UNIQUE, name TEXT)")
+	if columns := e.catalog.GetIndexColumns(1); len(columns) != 2 || columns[0] != "email" || columns[1] != "id" {
+		t.Fatalf("index columns = %v; want the PRIMARY KEY id and the UNIQUE email", columns)
+	}
+	e.Execute("INSERT INTO users VALUES (1, 'a@x', 'alice'), (2, 'b@x', 'bob')")
+
+	failures := []struct {
+		sql  string
+		want string
+	}{
+		{"INSERT INTO users VALUES (1, 'c@x', 'carol')", "column id: duplicate value 1 violates PRIMARY KEY constraint"},
+		{"INSERT INTO users VALUES (3, 'a@x', 'carol')", "column email: duplicate value a@x violates UNIQUE constraint"},
+		{"UPDATE users SET id = 1 WHERE id = 2", "column id: duplicate value 1 violates PRIMARY KEY constraint"},
+		{"UPDATE users SET email = 'b@x' WHERE id = 1", "column email: duplicate value b@x violates UNIQUE constraint"},
+	}
+	for _, tt := range failures {
+		r := e.Execute(tt.sql)
+		if r.Error == nil || !strings.Contains(r.Error.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.sql, r.Error, tt.want)
+		}
+	}
+
+	// Non-conflicting changes go through, including updates that keep or
+	// move the key, and a deleted key can be used again
+	for _, sql := range []string{
+		"INSERT INTO users VALUES (3, 'c@x', 'carol')",
+		"UPDATE users SET name = 'Alice' WHERE id = 1",
+		"UPDATE users SET id = 1, email = 'a@x' WHERE id = 1",
+		"UPDATE users SET id = 4 WHERE id = 3",
+		"DELETE FROM users WHERE id = 2",
+		"INSERT INTO users VALUES (2, 'b@x', 'bert')",
+	} {
+		if r := e.Execute(sql); r.Error != nil {
+			t.Errorf("%s: error = %v", sql, r.Error)
+		}
+	}
+	if r := e.Execute("INSERT INTO users VALUES (4, 'd@x', 'dan')"); r.Error == nil {
+		t.Error("INSERT of the key an UPDATE moved a row to should error")
+	}
+	e.Close()
+
+	// The index is reloaded with the table
+	e, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e.Close()
+	if r := e.Execute("INSERT INTO users VALUES (2, 'e@x', 'eve')"); r.Error == nil {
+		t.Error("duplicate INSERT after reopen should error")
+	}
+	r := e.Execute("SELECT id FROM users ORDER BY id")
+	var ids []string
+	for _, row := range r.Rows {
+		ids = append(ids, row.Values[0].String())
+	}
+	if got := strings.Join(ids, ","); got != "1,2,4" {
+		t.Errorf("ids = %s, want 1,2,4", got)
+	}
+}
+
+func TestEnginePrimaryKeyIndexRootSplit(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE t (id INT PRIMARY KEY, v TEXT)")
+	tableID, _ := e.catalog.GetTableID("t")
+	created, _ := e.catalog.GetIndexRoot(tableID, "id")
+	for start := 0; start < 2000; start += 500 {
+		var values []string
+		for i := start; i < start+500; i++ {
+			values = append(values, fmt.Sprintf("(%d, 'v%d')", i, i))
+		}
+		if r := e.Execute("INSERT INTO t VALUES " + strings.Join(values, ", ")); r.Error != nil {
+			t.Fatalf("INSERT error = %v", r.Error)
+		}
+	}
+
+	// The inserts split the root, and the catalog follows it
+	root := e.indexes[tableID]["id"].GetRootPageID()
+	if root == created {
+		t.Fatalf("root page = %d after 2000 inserts, want the root to have split", root)
+	}
+	if got, _ := e.catalog.GetIndexRoot(tableID, "id"); got != root {
+		t.Errorf("catalog index root = %d, want %d", got, root)
+	}
+	e.Close()
+
+	e, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e.Close()
+	if r := e.Execute("SELECT v FROM t WHERE id = 1999"); r.Error != nil || len(r.Rows) != 1 || r.Rows[0].Values[0].StrVal != "v1999" {
+		t.Errorf("SELECT WHERE id = 1999 after reopen = %v, %v; want v1999", r.Rows, r.Error)
+	}
+	if r := e.Execute("INSERT INTO t VALUES (1999, 'dup')"); r.Error == nil {
+		t.Error("duplicate INSERT after reopen should error")
+	}
+}
+
+func TestEnginePrimaryKeyIndexRootCollapse(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE t (id INT PRIMARY KEY, v TEXT)")
+	for start := 0; start < 2000; start += 500 {
+		var values []string
+		for i := start; i < start+500; i++ {
+			values = append(values, fmt.Sprintf("(%d, 'v%d')", i, i))
+		}
+		if r := e.Execute("INSERT INTO t VALUES " + strings.Join(values, ", ")); r.Error != nil {
+			t.Fatalf("INSERT error = %v", r.Error)
+		}
+	}
+	tableID, _ := e.catalog.GetTableID("t")
+	split := e.indexes[tableID]["id"].GetRootPageID()
+
+	// Reclaiming three quarters of the rows merges the leaves until the root
+	// collapses, and the catalog follows it before the old root is freed
+	if r := e.Execute("DELETE FROM t WHERE id >= 500"); r.Error != nil {
+		t.Fatalf("DELETE error = %v", r.Error)
+	}
+	if _, err := e.Vacuum(); err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	root := e.indexes[tableID]["id"].GetRootPageID()
+	if root == split {
+		t.Fatalf("root page = %d after VACUUM, want the root to have collapsed", root)
+	}
+	if got, _ := e.catalog.GetIndexRoot(tableID, "id"); got != root {
+		t.Errorf("catalog index root = %d, want %d", got, root)
+	}
+	e.Close()
+
+	e, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e.Close()
+	if r := e.Execute("SELECT v FROM t WHERE id = 499"); r.Error != nil || len(r.Rows) != 1 || r.Rows[0].Values[0].StrVal != "v499" {
+		t.Errorf("SELECT WHERE id = 499 after reopen = %v, %v; want v499", r.Rows, r.Error)
+	}
+	if r := e.Execute("SELECT v FROM t WHERE id = 1999"); r.Error != nil || len(r.Rows) != 0 {
+		t.Errorf("SELECT WHERE id = 1999 after reopen = %v, %v; want no rows", r.Rows, r.Error)
+	}
+	if r := e.Execute("INSERT INTO t VALUES (499, 'dup')"); r.Error == nil {
+		t.Error("duplicate INSERT after reopen should error")
+	}
+	if r := e.Execute("INSERT INTO t VALUES (1999, 'again')"); r.Error != nil {
+		t.Errorf("INSERT of a reclaimed key after reopen error = %v", r.Error)
+	}
+}
+
+func TestEngineCreateTableDefaultErrors(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	tests := []string{
+		"CREATE TABLE t1 (a INT DEFAULT 'x')",
+		"CREATE TABLE t2 (a INT, b INT DEFAULT a)",
+		"CREATE TABLE t3 (a SERIAL DEFAULT 1)",
+		"CREATE TABLE t4 (a INT PRIMARY KEY, b INT PRIMARY KEY)",
+	}
+	for _, sql := range tests {
+		if r := e.Execute(sql); r.Error == nil {
+			t.Errorf("%s should error", sql)
+		}
+	}
+}
+
+func TestEngineInsertReusesVacuumedSpace(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE docs (id INT, body TEXT)")
+	body := strings.Repeat("x", 200)
+	insertAll := func() {
+		for i := 0; i < 100; i++ {
+			if r := e.Execute(fmt.Sprintf("INSERT INTO docs VALUES (%d, '%s')", i, body)); r.Error != nil {
+				t.Fatalf("INSERT error = %v", r.Error)
+			}
+		}
+	}
+
+	insertAll()
+	tableID, _ := e.GetCatalog().GetTableID("docs")
+	heap := e.GetCatalog().GetTableHeap(tableID)
+	stats, err := heap.PageStats()
+	if err != nil {
+		t.Fatalf("PageStats() error = %v", err)
+	}
+	pages := len(stats)
+	if pages < 2 {
+		t.Fatalf("pages = %d, want the table to span several pages", pages)
+	}
+
+	e.Execute("DELETE FROM docs")
+	if _, err := e.Vacuum(); err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	insertAll()
+
+	stats, err = heap.PageStats()
+	if err != nil {
+		t.Fatalf("PageStats() error = %v", err)
+	}
+	if len(stats) != pages {
+		t.Errorf("pages after reinsert = %d, want %d", len(stats), pages)
+	}
+	if r := e.Execute("SELECT * FROM docs"); len(r.Rows) != 100 {
+		t.Errorf("rows = %d, want 100", len(r.Rows))
+	}
+}
+
+func TestEngineSetShow(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	show := func(name string) string {
+		t.Helper()
+		r := e.Execute("SHOW " + name)
+		if r.Error != nil {
+			t.Fatalf("SHOW %s error = %v", name, r.Error)
+		}
+		return r.Rows[0].Values[0].StrVal
+	}
+
+	if got := show("autocommit"); got != "on" {
+		t.Errorf("default autocommit = %q, want on", got)
+	}
+	if got := show("isolation"); got != "repeatable read" {
+		t.Errorf("default isolation = %q, want repeatable read", got)
+	}
+	if got := show("require_where"); got != "off" {
+		t.Errorf("default require_where = %q, want off", got)
+	}
+
+	for _, stmt := range []string{
+		"SET autocommit = off",
+		"SET isolation = 'read committed'",
+		"SET require_where = on",
+	} {
+		if r := e.Execute(stmt); r.Error != nil {
+			t.Fatalf("%s error = %v", stmt, r.Error)
+		}
+	}
+	if got := show("autocommit"); got != "off" {
+		t.Errorf("autocommit = %q, want off", got)
+	}
+	if got := show("isolation"); got != "read committed" {
+		t.Errorf("isolation = %q, want read committed", got)
+	}
+	if got := show("require_where"); got != "on" {
+		t.Errorf("require_where = %q, want on", got)
+	}
+
+	if r := e.Execute("SET nope = 1"); r.Error == nil {
+		t.Error("SET of unknown setting should error")
+	}
+	if r := e.Execute("SHOW nope"); r.Error == nil {
+		t.Error("SHOW of unknown setting should error")
+	}
+}
+
+func TestEngineAutocommitOff(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT)")
+	e.Execute("SET autocommit = off")
+
+	// The first statement opens a transaction that COMMIT closes
+	e.Execute("INSERT INTO users VALUES (1)")
+	if !e.executor.HasTransaction() {
+		t.Fatal("INSERT with autocommit off should leave a transaction open")
+	}
+	if r := e.Execute("SET autocommit = on"); r.Error == nil {
+		t.Error("changing autocommit inside a transaction should error")
+	}
+	if r := e.Execute("COMMIT"); r.Error != nil {
+		t.Fatalf("COMMIT error = %v", r.Error)
+	}
+	if e.executor.HasTransaction() {
+		t.Error("COMMIT should close the implicit transaction")
+	}
+
+	e.Execute("SELECT * FROM users")
+	if !e.executor.HasTransaction() {
+		t.Error("SELECT with autocommit off should open a transaction")
+	}
+	e.Execute("ROLLBACK")
+
+	e.Execute("SET autocommit = on")
+	e.Execute("INSERT INTO users VALUES (2)")
+	if e.executor.HasTransaction() {
+		t.Error("INSERT with autocommit on should not leave a transaction open")
+	}
+}
+
+func TestEngineIsolationLevels(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT)")
+	e.Execute("INSERT INTO users VALUES (1)")
+
+	// A second session on the same storage
+	other := sql.NewExecutor(e.txnManager, e.walWriter)
+	other.SetStorage(e.catalog, e.bufferPool)
+
+	count := func() int {
+		t.Helper()
+		r := e.Execute("SELECT * FROM users")
+		if r.Error != nil {
+			t.Fatalf("SELECT error = %v", r.Error)
+		}
+		return len(r.Rows)
+	}
+
+	// Repeatable read: the snapshot is fixed at BEGIN
+	e.Execute("BEGIN")
+	count()
+	other.Execute("INSERT INTO users VALUES (2)")
+	if got := count(); got != 1 {
+		t.Errorf("repeatable read rows = %d, want 1", got)
+	}
+	e.Execute("COMMIT")
+
+	// Read committed: each statement sees the latest commits
+	e.Execute("SET isolation = 'read committed'")
+	e.Execute("BEGIN")
+	count()
+	other.Execute("INSERT INTO users VALUES (3)")
+	if got := count(); got != 3 {
+		t.Errorf("read committed rows = %d, want 3", got)
+	}
+	e.Execute("COMMIT")
+
+	// BEGIN overrides the session's level for one transaction
+	e.Execute("BEGIN REPEATABLE READ")
+	count()
+	other.Execute("INSERT INTO users VALUES (4)")
+	if got := count(); got != 3 {
+		t.Errorf("BEGIN REPEATABLE READ rows = %d, want 3", got)
+	}
+	e.Execute("COMMIT")
+
+	e.Execute("SET isolation = 'repeatable read'")
+	if r := e.Execute("BEGIN ISOLATION LEVEL READ COMMITTED"); r.Error != nil {
+		t.Fatalf("BEGIN ISOLATION LEVEL READ COMMITTED error = %v", r.Error)
+	}
+	count()
+	other.Execute("INSERT INTO users VALUES (5)")
+	if got := count(); got != 5 {
+		t.Errorf("BEGIN READ COMMITTED rows = %d, want 5", got)
+	}
+	e.Execute("COMMIT")
+
+	// The next BEGIN falls back to the session's level
+	e.Execute("BEGIN")
+	count()
+	other.Execute("INSERT INTO users VALUES (6)")
+	if got := count(); got != 5 {
+		t.Errorf("repeatable read after override rows = %d, want 5", got)
+	}
+	e.Execute("COMMIT")
+}
+
+func TestEngineWriteConflict(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+
+	other := sql.NewExecutor(e.txnManager, e.walWriter)
+	other.SetStorage(e.catalog, e.bufferPool)
+
+	names := func(ex *sql.Executor) string {
+		t.Helper()
+		r := ex.Execute("SELECT name FROM users ORDER BY id")
+		if r.Error != nil {
+			t.Fatalf("SELECT error = %v", r.Error)
+		}
+		var got []string
+		for _, row := range r.Rows {
+			got = append(got, row.Values[0].StrVal)
+		}
+		return strings.Join(got, ",")
+	}
+
+	e.Execute("BEGIN")
+	other.Execute("BEGIN")
+	if r := e.Execute("UPDATE users SET name = 'alicia' WHERE id = 1"); r.Error != nil {
+		t.Fatalf("first UPDATE error = %v", r.Error)
+	}
+
+	// The second writer of row 1 gets a conflict, whichever statement
+	if r := other.Execute("UPDATE users SET name = 'al' WHERE id = 1"); r.Error == nil ||
+		!strings.Contains(r.Error.Error(), "could not serialize") {
+		t.Errorf("concurrent UPDATE error = %v, want a conflict", r.Error)
+	}
+	if r := other.Execute("DELETE FROM users WHERE id = 1"); r.Error == nil {
+		t.Error("concurrent DELETE should conflict")
+	}
+
+	// A statement that conflicts halfway is undone; bob comes first in the heap
+	if r := other.Execute("UPDATE users SET name = 'x' WHERE id > 0"); r.Error == nil {
+		t.Fatal("UPDATE of both rows should conflict")
+	}
+	if got := names(other); got != "alice,bob" {
+		t.Errorf("rows after the failed statement = %s, want alice,bob", got)
+	}
+	if r := other.Execute("UPDATE users SET name = 'robert' WHERE id = 2"); r.Error != nil {
+		t.Fatalf("UPDATE of an unrelated row error = %v", r.Error)
+	}
+	if r := other.Execute("COMMIT"); r.Error != nil {
+		t.Fatalf("COMMIT error = %v", r.Error)
+	}
+	e.Execute("COMMIT")
+	if got := names(e.executor); got != "alicia,robert" {
+		t.Errorf("rows after both commits = %s, want alicia,robert", got)
+	}
+
+	// A change committed after the snapshot was taken conflicts too
+	other.Execute("BEGIN")
+	names(other)
+	e.Execute("UPDATE users SET name = 'ally' WHERE id = 1")
+	if r := other.Execute("UPDATE users SET name = 'al' WHERE id = 1"); r.Error == nil {
+		t.Error("UPDATE of a row changed after the snapshot should conflict")
+	}
+	other.Execute("ROLLBACK")
+}
+
+func TestEngineSelectForUpdate(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE accounts (id INT, balance INT)")
+	e.Execute("CREATE UNIQUE INDEX ON accounts (id)")
+	e.Execute("INSERT INTO accounts VALUES (1, 100)")
+	e.Execute("INSERT INTO accounts VALUES (2, 50)")
+
+	other := sql.NewExecutor(e.txnManager, e.walWriter)
+	other.SetStorage(e.catalog, e.bufferPool)
+
+	e.Execute("BEGIN")
+	r := e.Execute("SELECT balance FROM accounts WHERE id = 1 FOR UPDATE")
+	if r.Error != nil || len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != 100 {
+		t.Fatalf("SELECT FOR UPDATE = %v, %v; want one row of 100", r.Rows, r.Error)
+	}
+
+	// A concurrent writer of the locked row waits for the transaction
+	done := make(chan *sql.Result, 1)
+	go func() { done <- other.Execute("UPDATE accounts SET balance = 0 WHERE id = 1") }()
+	select {
+	case r := <-done:
+		t.Fatalf("UPDATE of a locked row returned %v without waiting", r.Error)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Rows that were not returned are not locked
+	third := sql.NewExecutor(e.txnManager, e.walWriter)
+	third.SetStorage(e.catalog, e.bufferPool)
+	if r := third.Execute("UPDATE accounts SET balance = 60 WHERE id = 2"); r.Error != nil {
+		t.Fatalf("UPDATE of an unlocked row error = %v", r.Error)
+	}
+
+	if r := e.Execute("UPDATE accounts SET balance = 90 WHERE id = 1"); r.Error != nil {
+		t.Fatalf("UPDATE after FOR UPDATE error = %v", r.Error)
+	}
+	e.Execute("COMMIT")
+
+	// Once the lock is released, the waiter finds the row changed
+	select {
+	case r := <-done:
+		if r.Error == nil || !strings.Contains(r.Error.Error(), "could not serialize") {
+			t.Errorf("waiting UPDATE error = %v, want a conflict", r.Error)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("UPDATE still blocked after COMMIT")
+	}
+	r = e.Execute("SELECT balance FROM accounts ORDER BY id")
+	if len(r.Rows) != 2 || r.Rows[0].Values[0].IntVal != 90 || r.Rows[1].Values[0].IntVal != 60 {
+		t.Errorf("balances = %v, want 90 and 60", r.Rows)
+	}
+
+	// A row another transaction has already changed conflicts at once
+	e.Execute("BEGIN")
+	e.Execute("UPDATE accounts SET balance = 80 WHERE id = 1")
+	if r := other.Execute("SELECT * FROM accounts WHERE id = 1 FOR UPDATE"); r.Error == nil ||
+		!strings.Contains(r.Error.Error(), "could not serialize") {
+		t.Errorf("FOR UPDATE of a changed row error = %v, want a conflict", r.Error)
+	}
+	e.Execute("ROLLBACK")
+
+	if r := e.Execute("SELECT COUNT(*) FROM accounts FOR UPDATE"); r.Error == nil {
+		t.Error("FOR UPDATE with an aggregate should error")
+	}
+	r = e.Execute("EXPLAIN SELECT * FROM accounts WHERE id = 1 FOR UPDATE")
+	if r.Error != nil || !strings.HasPrefix(r.Rows[0].Values[0].StrVal, "LockRows") ||
+		!strings.Contains(r.Rows[1].Values[0].StrVal, "Seq Scan") {
+		t.Errorf("EXPLAIN FOR UPDATE = %v, %v; want LockRows over a Seq Scan", r.Rows, r.Error)
+	}
+}
+
+func TestEngineOrderBy(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE items (name TEXT, price INT)")
+	e.Execute("INSERT INTO items VALUES ('pear', 30)")
+	e.Execute("INSERT INTO items VALUES ('apple', 10)")
+	e.Execute("INSERT INTO items VALUES ('fig', NULL)")
+	e.Execute("INSERT INTO items VALUES ('kiwi', 30)")
+
+	names := func(sql string) string {
+		t.Helper()
+		r := e.Execute(sql)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+		var out []string
+		for _, row := range r.Rows {
+			out = append(out, row.Values[0].StrVal)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT name, price FROM items ORDER BY price", "apple,pear,kiwi,fig"},
+		{"SELECT name, price FROM items ORDER BY price DESC", "fig,pear,kiwi,apple"},
+		{"SELECT name, price FROM items ORDER BY 2, 1", "apple,kiwi,pear,fig"},
+		{"SELECT name, price FROM items ORDER BY 2 DESC, name", "fig,kiwi,pear,apple"},
+		{"SELECT name FROM items ORDER BY price, name DESC", "apple,pear,kiwi,fig"},
+		{"SELECT name, 100 - price FROM items ORDER BY 2, 1", "kiwi,pear,apple,fig"},
+		{"SELECT * FROM items WHERE price > 10 ORDER BY 1", "kiwi,pear"},
+	}
+	for _, tt := range tests {
+		if got := names(tt.sql); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.sql, got, tt.want)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT name, price FROM items ORDER BY 3",
+		"SELECT name FROM items ORDER BY 0",
+		"SELECT * FROM items ORDER BY 3",
+	} {
+		r := e.Execute(sql)
+		if r.Error == nil || !strings.Contains(r.Error.Error(), "not in select list") {
+			t.Errorf("%s: error = %v, want position out of range", sql, r.Error)
+		}
+	}
+}
+
+func TestEngineGroupByExpression(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, email TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'ann@x')")
+	e.Execute("INSERT INTO users VALUES (2, 'bob@x')")
+	e.Execute("INSERT INTO users VALUES (12, 'amy@x')")
+	e.Execute("INSERT INTO users VALUES (15, 'ben@x')")
+	e.Execute("INSERT INTO users VALUES (21, 'cat@x')")
+	e.Execute("INSERT INTO users VALUES (7, 'al@x')")
+	e.Execute("INSERT INTO users VALUES (8, NULL)")
+
+	rows := func(sql string) string {
+		t.Helper()
+		r := e.Execute(sql)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+		var out []string
+		for _, row := range r.Rows {
+			var vals []string
+			for _, v := range row.Values {
+				vals = append(vals, v.String())
+			}
+			out = append(out, strings.Join(vals, ":"))
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		// Grouping by a function result
+		{"SELECT substr(email, 1, 1), COUNT(*) FROM users GROUP BY substr(email, 1, 1) ORDER BY 1",
+			"a:3,b:2,c:1,NULL:1"},
+		// Grouping by an arithmetic expression
+		{"SELECT id / 10, COUNT(*) FROM users GROUP BY id / 10 ORDER BY 1", "0:4,1:2,2:1"},
+		{"SELECT id / 10 * 10, COUNT(email) FROM users GROUP BY id / 10 ORDER BY 2 DESC, 1",
+			"0:3,10:2,20:1"},
+		// Grouped expressions may be used inside larger expressions
+		{"SELECT UPPER(substr(email, 1, 1)), COUNT(*) + 1 FROM users WHERE id <> 8 GROUP BY substr(email, 1, 1) ORDER BY 2 DESC",
+			"A:4,B:3,C:2"},
+		// ORDER BY an aggregate's select-list position
+		{"SELECT substr(email, 1, 1), COUNT(*) FROM users WHERE id < 20 GROUP BY substr(email, 1, 1) ORDER BY 2, 1",
+			"NULL:1,b:2,a:3"},
+		{"SELECT COUNT(*) FROM users", "7"},
+		{"SELECT COUNT(*) FROM users WHERE id > 100", "0"},
+		{"SELECT id FROM users WHERE id > 100 GROUP BY id", ""},
+	}
+	for _, tt := range tests {
+		if got := rows(tt.sql); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.sql, got, tt.want)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT email, COUNT(*) FROM users GROUP BY substr(email, 1, 1)",
+		"SELECT substr(email, 1, 2) FROM users GROUP BY substr(email, 1, 1)",
+		"SELECT id, COUNT(*) FROM users",
+		"SELECT * FROM users GROUP BY id",
+	} {
+		r := e.Execute(sql)
+		if r.Error == nil || !strings.Contains(r.Error.Error(), "must appear in the GROUP BY clause") {
+			t.Errorf("%s: error = %v, want GROUP BY validation error", sql, r.Error)
+		}
+	}
+	for _, sql := range []string{
+		"SELECT id FROM users WHERE COUNT(*) > 1",
+		"SELECT COUNT(*) FROM users GROUP BY COUNT(*)",
+	} {
+		if r := e.Execute(sql); r.Error == nil {
+			t.Errorf("%s: expected error for misplaced aggregate", sql)
+		}
+	}
+}
+
+func TestEngineBoolOrdering(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE flags (name TEXT, active BOOL)")
+	e.Execute("INSERT INTO flags VALUES ('a', true)")
+	e.Execute("INSERT INTO flags VALUES ('b', false)")
+	e.Execute("INSERT INTO flags VALUES ('c', NULL)")
+	e.Execute("INSERT INTO flags VALUES ('d', false)")
+
+	names := func(sql string) string {
+		t.Helper()
+		r := e.Execute(sql)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+		var out []string
+		for _, row := range r.Rows {
+			out = append(out, row.Values[0].StrVal)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT name FROM flags WHERE active < true", "b,d"},
+		{"SELECT name FROM flags WHERE active <= false", "b,d"},
+		{"SELECT name FROM flags WHERE active > false", "a"},
+		{"SELECT name FROM flags WHERE active >= false", "a,b,d"},
+		{"SELECT name FROM flags WHERE active < false", ""},
+		{"SELECT name FROM flags ORDER BY active, name", "b,d,a,c"},
+		{"SELECT name FROM flags ORDER BY active DESC, name", "c,a,b,d"},
+	}
+	for _, tt := range tests {
+		if got := names(tt.sql); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.sql, got, tt.want)
+		}
+	}
+
+	r := e.Execute("SELECT false < true, true < false, true > false")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	got := r.Rows[0].Values
+	if !got[0].BoolVal || got[1].BoolVal || !got[2].BoolVal {
+		t.Errorf("bool comparisons = %v, want [true false true]", got)
+	}
+}
+
+func TestEngineBlobColumn(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if r := e.Execute("CREATE TABLE files (id INT, data BLOB UNIQUE, tag BLOB DEFAULT x'CAFE')"); r.Error != nil {
+		t.Fatalf("CREATE TABLE error = %v", r.Error)
+	}
+	for _, v := range []string{"(1, x'00ff00')", "(2, x'00')", "(3, x'')", "(4, NULL)", "(5, x'0000')"} {
+		if r := e.Execute("INSERT INTO files (id, data) VALUES " + v); r.Error != nil {
+			t.Fatalf("INSERT %s error = %v", v, r.Error)
+		}
+	}
+
+	query := func(sql string) string {
+		t.Helper()
+		r := e.Execute(sql)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+		var out []string
+		for _, row := range r.Rows {
+			var vals []string
+			for _, v := range row.Values {
+				vals = append(vals, v.String())
+			}
+			out = append(out, strings.Join(vals, " "))
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		// NUL bytes are stored and compared, not treated as terminators
+		{"SELECT id, data FROM files WHERE data = x'00FF00'", `1 \x00ff00`},
+		{"SELECT id FROM files WHERE data = x'00'", "2"},
+		{"SELECT id FROM files WHERE data < x'00'", "3"},
+		{"SELECT id FROM files WHERE data > x'00' ORDER BY id", "1,5"},
+		{"SELECT id FROM files ORDER BY data", "3,2,5,1,4"},
+		{"SELECT tag FROM files WHERE id = 1", `\xcafe`},
+	}
+	for _, tt := range tests {
+		if got := query(tt.sql); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.sql, got, tt.want)
+		}
+	}
+
+	// x'00' and x'0000' differ, but a repeat of either is a duplicate
+	if r := e.Execute("INSERT INTO files (id, data) VALUES (6, x'0000')"); r.Error == nil {
+		t.Error("duplicate BLOB should violate UNIQUE")
+	}
+	if err := e.CreateIndex("files", "data"); err == nil {
+		t.Error("CreateIndex on a BLOB column should fail")
+	}
+	if r := e.Execute("INSERT INTO files (id, data) VALUES (7, 'text')"); r.Error == nil {
+		t.Error("TEXT value in a BLOB column should fail")
+	}
+	e.Close()
+
+	e, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer e.Close()
+	if got := query("SELECT data, tag FROM files WHERE id = 1"); got != `\x00ff00 \xcafe` {
+		t.Errorf("after reopen = %s, want \\x00ff00 \\xcafe", got)
+	}
+}
+
+func TestEngineTimestampColumn(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if r := e.Execute("CREATE TABLE events (id INT, at TIMESTAMP UNIQUE, since TIMESTAMP DEFAULT '2000-01-01T00:00:00Z')"); r.Error != nil {
+		t.Fatalf("CREATE TABLE error = %v", r.Error)
+	}
+	for _, v := range []string{
+		"(1, '2024-03-01T09:30:00Z')",
+		"(2, '2023-12-31T23:59:59Z')",
+		"(3, TIMESTAMP '2024-01-01T00:00:00Z')",
+		"(4, '2024-01-02T00:00:00+09:00')", // 2024-01-01T15:00:00Z
+		"(5, NULL)",
+	} {
+		if r := e.Execute("INSERT INTO events (id, at) VALUES " + v); r.Error != nil {
+			t.Fatalf("INSERT %s error = %v", v, r.Error)
+		}
+	}
+
+	query := func(sql string) string {
+		t.Helper()
+		r := e.Execute(sql)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+		var out []string
+		for _, row := range r.Rows {
+			var vals []string
+			for _, v := range row.Values {
+				vals = append(vals, v.String())
+			}
+			out = append(out, strings.Join(vals, " "))
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT id FROM events WHERE at > '2024-01-01T00:00:00Z' ORDER BY id", "1,4"},
+		{"SELECT id FROM events WHERE at >= TIMESTAMP '2024-01-01T00:00:00Z' ORDER BY id", "1,3,4"},
+		{"SELECT id FROM events WHERE '2024-01-01T00:00:00Z' = at", "3"},
+		{"SELECT id FROM events WHERE at BETWEEN '2024-01-01T00:00:00Z' AND '2024-02-01T00:00:00Z' ORDER BY id", "3,4"},
+		{"SELECT id FROM events WHERE at > 'not a time'", ""},
+		{"SELECT id FROM events ORDER BY at", "2,3,4,1,5"},
+		{"SELECT at FROM events WHERE id = 4", "2024-01-01T15:00:00Z"},
+		{"SELECT MIN(at), MAX(at) FROM events", "2023-12-31T23:59:59Z 2024-03-01T09:30:00Z"},
+		{"SELECT since FROM events WHERE id = 1", "2000-01-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		if got := query(tt.sql); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.sql, got, tt.want)
+		}
+	}
+
+	if r := e.Execute("UPDATE events SET at = '2025-01-01T00:00:00Z' WHERE id = 1"); r.Error != nil {
+		t.Fatalf("UPDATE error = %v", r.Error)
+	}
+	if r := e.Execute("INSERT INTO events (id, at) VALUES (6, '2025-01-01T00:00:00Z')"); r.Error == nil {
+		t.Error("duplicate TIMESTAMP should violate UNIQUE")
+	}
+	for _, v := range []string{"(7, '2024-01-02')", "(7, 1704207845)"} {
+		if r := e.Execute("INSERT INTO events (id, at) VALUES " + v); r.Error == nil {
+			t.Errorf("INSERT %s into a TIMESTAMP column should fail", v)
+		}
+	}
+	e.Close()
+
+	e, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer e.Close()
+	if got := query("SELECT at, since FROM events WHERE id = 1"); got != "2025-01-01T00:00:00Z 2000-01-01T00:00:00Z" {
+		t.Errorf("after reopen = %s", got)
+	}
+}
+
+func TestEngineValues(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	r := e.Execute("VALUES (42)")
+	if r.Error != nil {
+		t.Fatalf("VALUES error = %v", r.Error)
+	}
+	if len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != 42 {
+		t.Errorf("single row = %v, want [42]", r.Rows)
+	}
+	if len(r.Columns) != 1 || r.Columns[0] != "column1" {
+		t.Errorf("Columns = %v, want [column1]", r.Columns)
+	}
+
+	r = e.Execute("VALUES (1, 'a', TRUE), (2, 'b', NULL), (1 + 2, UPPER('c'), FALSE)")
+	if r.Error != nil {
+		t.Fatalf("VALUES error = %v", r.Error)
+	}
+	if strings.Join(r.Columns, ",") != "column1,column2,column3" {
+		t.Errorf("Columns = %v, want column1..column3", r.Columns)
+	}
+	if len(r.Rows) != 3 {
+		t.Fatalf("rows = %d, want 3", len(r.Rows))
+	}
+	last := r.Rows[2].Values
+	if last[0].IntVal != 3 || last[1].StrVal != "C" || last[2].Type != types.ValueTypeBool {
+		t.Errorf("row 3 = %v, want [3 C false]", last)
+	}
+	if !r.Rows[1].Values[2].IsNull {
+		t.Errorf("row 2 column3 = %v, want NULL", r.Rows[1].Values[2])
+	}
+
+	// Each column takes the type of its first non-NULL value
+	if r := e.Execute("VALUES (NULL, 1), (NULL, 2)"); r.Error != nil {
+		t.Errorf("all-NULL column error = %v", r.Error)
+	}
+	r = e.Execute("VALUES (1, 'a'), (2, 3)")
+	if r.Error == nil || !strings.Contains(r.Error.Error(), "column2: expected TEXT, got INT") {
+		t.Errorf("mixed types error = %v, want column2 type mismatch", r.Error)
+	}
+	r = e.Execute("VALUES (NULL), ('x'), (1)")
+	if r.Error == nil || !strings.Contains(r.Error.Error(), "column1: expected TEXT, got INT") {
+		t.Errorf("mixed types after NULL error = %v, want column1 type mismatch", r.Error)
+	}
+}
+
+func TestEngineWALBufferSizeValidated(t *testing.T) {
+	_, err := New(Config{DataDir: t.TempDir(), WALBufferSize: 100})
+	if err == nil {
+		t.Error("New() with a WAL buffer below the minimum should error")
+	}
+}
+
+// BenchmarkBulkInsertWALBufferSize measures a bulk insert in one transaction,
+// where the WAL buffer size decides how often the writer flushes and syncs.
+func BenchmarkBulkInsertWALBufferSize(b *testing.B) {
+	for _, size := range []int{4 * 1024, 64 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				e, err := New(Config{DataDir: b.TempDir(), WALBufferSize: size})
+				if err != nil {
+					b.Fatalf("New() error = %v", err)
+				}
+				e.Execute("CREATE TABLE bulk (id INT, body TEXT)")
+				b.StartTimer()
+
+				e.Execute("BEGIN")
+				for j := 0; j < 1000; j++ {
+					e.Execute(fmt.Sprintf("INSERT INTO bulk VALUES (%d, 'row %d')", j, j))
+				}
+				e.Execute("COMMIT")
+
+				b.StopTimer()
+				e.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkUpdateRowsOnOnePage measures an UPDATE touching every row of a
+// single heap page, reporting buffer pool fetches per statement.
+func BenchmarkUpdateRowsOnOnePage(b *testing.B) {
+	var fetches uint64
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		e, err := New(Config{DataDir: b.TempDir(), BufferPoolSize: 100})
+		if err != nil {
+			b.Fatalf("New() error = %v", err)
+		}
+		// Old and new versions of all 30 rows fit on the first page
+		e.Execute("CREATE TABLE hot (id INT, n INT)")
+		for j := 0; j < 30; j++ {
+			e.Execute(fmt.Sprintf("INSERT INTO hot VALUES (%d, 0)", j))
+		}
+		hits, misses, _ := e.bufferPool.Stats()
+		b.StartTimer()
+
+		e.Execute("UPDATE hot SET n = n + 1")
+
+		b.StopTimer()
+		hits2, misses2, _ := e.bufferPool.Stats()
+		fetches += hits2 + misses2 - hits - misses
+		e.Close()
+	}
+	b.ReportMetric(float64(fetches)/float64(b.N), "fetches/op")
+}
+
+func TestEngineRecoveryAfterMultiRowUpdate(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE hot (id INT, n INT)")
+	for i := 0; i < 30; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO hot VALUES (%d, 0)", i))
+	}
+	e.Execute("UPDATE hot SET n = id * 2")
+	e.Execute("DELETE FROM hot WHERE id >= 20")
+	// Left uncommitted at the crash, so recovery must undo it
+	e.Execute("BEGIN")
+	e.Execute("UPDATE hot SET n = 0 - 1")
+
+	e.walWriter.Flush()
+	e.walWriter.Close()
+	e.diskManager.Close()
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen after crash error = %v", err)
+	}
+	defer e2.Close()
+
+	r := e2.Execute("SELECT id, n FROM hot")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if len(r.Rows) != 20 {
+		t.Fatalf("rows after recovery = %d, want 20", len(r.Rows))
+	}
+	for _, row := range r.Rows {
+		id, n := row.Values[0].IntVal, row.Values[1].IntVal
+		if n != id*2 {
+			t.Errorf("row %d: n = %d, want %d", id, n, id*2)
+		}
+	}
+}
+
+func TestEngineScanHints(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE t (id INT, name TEXT)")
+	for i := 1; i <= 20; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO t VALUES (%d, 'n%d')", i, i))
+	}
+	if err := e.CreateIndex("t", "id"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	explain := func(query string) string {
+		t.Helper()
+		r := e.Execute("EXPLAIN " + query)
+		if r.Error != nil {
+			t.Fatalf("EXPLAIN %s: error = %v", query, r.Error)
+		}
+		return r.Rows[0].Values[0].StrVal
+	}
+
+	plans := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM t WHERE id = 5", "Index Scan using t_id on t  (rows=1)"},
+		{"SELECT /*+ seqscan */ * FROM t WHERE id = 5", "Seq Scan on t  (rows=2)"},
+		{"SELECT /*+ indexscan */ * FROM t WHERE id = 5", "Index Scan using t_id on t  (rows=1)"},
+		{"SELECT /*+ index(t_id) */ * FROM t WHERE id = 5", "Index Scan using t_id on t  (rows=1)"},
+		{"SELECT * FROM t WHERE name = 'n5'", "Seq Scan on t  (rows=2)"},
+	}
+	for _, tt := range plans {
+		if got := explain(tt.query); got != tt.want {
+			t.Errorf("EXPLAIN %s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// Both plans return the same rows
+	indexed := e.Execute("SELECT * FROM t WHERE id = 5")
+	scanned := e.Execute("SELECT /*+ seqscan */ * FROM t WHERE id = 5")
+	if indexed.Error != nil || scanned.Error != nil {
+		t.Fatalf("SELECT errors = %v, %v", indexed.Error, scanned.Error)
+	}
+	if len(indexed.Rows) != 1 || len(scanned.Rows) != 1 ||
+		indexed.Rows[0].Values[1].StrVal != scanned.Rows[0].Values[1].StrVal {
+		t.Errorf("index rows = %v, seq scan rows = %v; want the same single row", indexed.Rows, scanned.Rows)
+	}
+
+	// Index hints that cannot be honored are errors
+	for _, query := range []string{
+		"SELECT /*+ index(t_name) */ * FROM t WHERE id = 5",
+		"SELECT /*+ indexscan */ * FROM t WHERE name = 'n5'",
+		"SELECT /*+ indexscan */ * FROM t",
+	} {
+		if r := e.Execute(query); r.Error == nil {
+			t.Errorf("%s should error", query)
+		}
+	}
+
+	e.Execute("CREATE TABLE plain (id INT)")
+	if r := e.Execute("SELECT /*+ indexscan */ * FROM plain WHERE id = 1"); r.Error == nil {
+		t.Error("index hint on a table without an index should error")
+	}
+}
+
+func TestEngineExplain(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE items (name TEXT, price INT)")
+
+	r := e.Execute("EXPLAIN SELECT name FROM items WHERE price > 10 ORDER BY price DESC -- most expensive first")
+	if r.Error != nil {
+		t.Fatalf("EXPLAIN error = %v", r.Error)
+	}
+	var lines []string
+	for _, row := range r.Rows {
+		lines = append(lines, row.Values[0].StrVal)
+	}
+	want := "Sort (price DESC)  (rows=0)|  -> Seq Scan on items  (rows=0)|       Filter: price > 10"
+	if got := strings.Join(lines, "|"); got != want {
+		t.Errorf("plan = %q, want %q", got, want)
+	}
+
+	r = e.Execute("EXPLAIN SELECT price / 10, COUNT(*) FROM items GROUP BY price / 10 ORDER BY 2")
+	if r.Error != nil {
+		t.Fatalf("EXPLAIN error = %v", r.Error)
+	}
+	lines = nil
+	for _, row := range r.Rows {
+		lines = append(lines, row.Values[0].StrVal)
+	}
+	want = "Sort (2)  (rows=1)|  -> HashAggregate  (rows=1)|       Group Key: price / 10|       -> Seq Scan on items  (rows=0)"
+	if got := strings.Join(lines, "|"); got != want {
+		t.Errorf("plan = %q, want %q", got, want)
+	}
+
+	if r := e.Execute("EXPLAIN SELECT * FROM missing"); r.Error == nil {
+		t.Error("EXPLAIN of a missing table should error")
+	}
+}
+
+func TestEngineExplainEstimates(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, age INT)")
+	for i := 0; i < 300; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, %d)", i, i%60))
+	}
+
+	plans := []struct {
+		query string
+		want  []string
+	}{
+		{"SELECT * FROM users", []string{"Seq Scan on users  (rows=300)"}},
+		{"SELECT * FROM users WHERE age > 20 AND id <> 3", []string{
+			"Seq Scan on users  (rows=90)", "  Filter: (age > 20) AND (id != 3)",
+		}},
+		{"SELECT * FROM users WHERE age IN (1, 2, 3) OR age IS NULL", []string{
+			"Seq Scan on users  (rows=111)", "  Filter: age IN (1, 2, 3) OR age IS NULL",
+		}},
+		{"SELECT COUNT(*) FROM users WHERE age = 1", []string{
+			"Aggregate  (rows=1)", "  -> Seq Scan on users  (rows=30)", "       Filter: age = 1",
+		}},
+		{"SELECT age, COUNT(*) FROM users WHERE age > 20 GROUP BY age ORDER BY 2 LIMIT 3", []string{
+			"Limit  (rows=3)", "  Count: 3",
+			"  -> Sort (2)  (rows=10)",
+			"       -> HashAggregate  (rows=10)", "            Group Key: age",
+			"            -> Seq Scan on users  (rows=100)", "                 Filter: age > 20",
+		}},
+	}
+	for _, tt := range plans {
+		r := e.Execute("EXPLAIN " + tt.query)
+		if r.Error != nil {
+			t.Fatalf("EXPLAIN %s: error = %v", tt.query, r.Error)
+		}
+		var got []string
+		for _, row := range r.Rows {
+			got = append(got, row.Values[0].StrVal)
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("EXPLAIN %s =\n%s\nwant\n%s", tt.query, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+// rowIDsByName returns the logical RowIDs of every stored version, keyed by
+// the row's name column.
+func rowIDsByName(t *testing.T, e *Engine, table string) map[string][]uint64 {
+	t.Helper()
+	tableID, _ := e.catalog.GetTableID(table)
+	schema := e.catalog.GetSchema(table)
+	tuples, err := e.catalog.GetTableHeap(tableID).Scan()
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	ids := make(map[string][]uint64)
+	for _, tw := range tuples {
+		row, err := types.DeserializeRow(schema, tw.Tuple.Data)
+		if err != nil {
+			t.Fatalf("DeserializeRow() error = %v", err)
+		}
+		name := row["name"].StrVal
+		ids[name] = append(ids[name], tw.Tuple.RowID)
+	}
+	return ids
+}
+
+func TestEngineLogicalRowIDAcrossUpdates(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+	e.Execute("UPDATE users SET id = 10 WHERE name = 'alice'")
+	e.Execute("UPDATE users SET id = 20 WHERE name = 'alice'")
+
+	ids := rowIDsByName(t, e, "users")
+	if len(ids["alice"]) != 3 {
+		t.Fatalf("alice versions = %d, want 3", len(ids["alice"]))
+	}
+	for _, id := range ids["alice"] {
+		if id != ids["alice"][0] {
+			t.Errorf("alice version RowIDs = %v, want one id for every version", ids["alice"])
+			break
+		}
+	}
+	if ids["alice"][0] == ids["bob"][0] {
+		t.Errorf("alice and bob share RowID %d", ids["bob"][0])
+	}
+	e.Close()
+
+	// Allocation continues after reopening instead of reusing ids
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e2.Close()
+	e2.Execute("INSERT INTO users VALUES (3, 'carol')")
+	ids = rowIDsByName(t, e2, "users")
+	if carol := ids["carol"][0]; carol <= ids["alice"][0] || carol <= ids["bob"][0] {
+		t.Errorf("carol RowID = %d, want greater than alice (%d) and bob (%d)", carol, ids["alice"][0], ids["bob"][0])
+	}
+}
+
+func TestEngineRecoveryRedoesUpdate(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("UPDATE users SET id = 2 WHERE name = 'alice'")
+
+	// Crash without a graceful shutdown
+	e.walWriter.Flush()
+	e.walWriter.Close()
+	e.diskManager.Close()
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen after crash error = %v", err)
+	}
+	defer e2.Close()
+
+	r := e2.Execute("SELECT id FROM users")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if len(r.Rows) != 1 || r.Rows[0].Values[0].IntVal != 2 {
+		t.Errorf("rows after recovery = %v, want [[2]]", r.Rows)
+	}
+	ids := rowIDsByName(t, e2, "users")["alice"]
+	if len(ids) != 2 || ids[0] != ids[1] {
+		t.Errorf("alice version RowIDs after recovery = %v, want two versions of one row", ids)
+	}
+}
+
+func TestEngineVersionChainOlderSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	old := e.txnManager.Begin()
+	e.Execute("UPDATE users SET id = 2 WHERE name = 'alice'")
+	e.Execute("UPDATE users SET id = 3 WHERE name = 'alice'")
+
+	tableID, _ := e.catalog.GetTableID("users")
+	schema := e.catalog.GetSchema("users")
+
+	// findFrom walks the chain from the newest version of alice.
+	findFrom := func(e *Engine, visible func(*types.Tuple) bool) int64 {
+		t.Helper()
+		heap := e.catalog.GetTableHeap(tableID)
+		tuples, err := heap.Scan()
+		if err != nil {
+			t.Fatalf("Scan() error = %v", err)
+		}
+		var head *storage.TupleWithRID
+		for _, tw := range tuples {
+			if !tw.Tuple.IsDeleted() {
+				head = tw
+			}
+		}
+		if head == nil {
+			t.Fatal("no live version of alice")
+		}
+		found, err := heap.FindVersion(head.PageID, head.SlotNum, visible)
+		if err != nil {
+			t.Fatalf("FindVersion() error = %v", err)
+		}
+		if found == nil {
+			return -1
+		}
+		row, err := types.DeserializeRow(schema, found.Tuple.Data)
+		if err != nil {
+			t.Fatalf("DeserializeRow() error = %v", err)
+		}
+		return row["id"].IntVal
+	}
+
+	if got := findFrom(e, old.Snapshot.IsVisible); got != 1 {
+		t.Errorf("version visible to older snapshot: id = %d, want 1", got)
+	}
+	current := e.txnManager.Begin()
+	if got := findFrom(e, current.Snapshot.IsVisible); got != 3 {
+		t.Errorf("version visible to current snapshot: id = %d, want 3", got)
+	}
+	e.txnManager.Commit(current)
+	if got := findFrom(e, func(*types.Tuple) bool { return false }); got != -1 {
+		t.Errorf("no visible version: id = %d, want -1", got)
+	}
+
+	// Crash and check that recovery restores the chain pointers
+	e.walWriter.Flush()
+	e.walWriter.Close()
+	e.diskManager.Close()
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen after crash error = %v", err)
+	}
+	defer e2.Close()
+	first := func(tuple *types.Tuple) bool { return !tuple.HasPrevVersion() }
+	if got := findFrom(e2, first); got != 1 {
+		t.Errorf("oldest version after recovery: id = %d, want 1", got)
+	}
+}
+
+func TestEngineRepairReclaimsOrphanedPages(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	for i := 0; i < 10; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, 'user%d')", i, i))
+	}
+
+	// A page allocated but never linked into any structure, as left by a
+	// crash between allocation and linking
+	orphan, err := e.bufferPool.NewPage(storage.PageTypeData)
+	if err != nil {
+		t.Fatalf("NewPage() error = %v", err)
+	}
+	e.bufferPool.UnpinPage(orphan.ID, true)
+
+	result, err := e.Repair()
+	if err != nil {
+		t.Fatalf("Repair() error = %v", err)
+	}
+	if len(result.Reclaimed) != 1 || result.Reclaimed[0] != orphan.ID {
+		t.Fatalf("Reclaimed = %v, want [%d]", result.Reclaimed, orphan.ID)
+	}
+
+	again, err := e.Repair()
+	if err != nil {
+		t.Fatalf("second Repair() error = %v", err)
+	}
+	if len(again.Reclaimed) != 0 {
+		t.Errorf("second Repair() reclaimed %v, want none", again.Reclaimed)
+	}
+
+	// The next allocation reuses the reclaimed page
+	e.Execute("CREATE TABLE other (id INT)")
+	e.Execute("INSERT INTO other VALUES (1)")
+	free, err := e.diskManager.FreePages()
+	if err != nil {
+		t.Fatalf("FreePages() error = %v", err)
+	}
+	if len(free) != 0 {
+		t.Errorf("free list after allocation = %v, want empty", free)
+	}
+	e.Close()
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e2.Close()
+
+	if r := e2.Execute("SELECT * FROM users"); r.Error != nil || len(r.Rows) != 10 {
+		t.Errorf("users after repair: rows = %d, err = %v, want 10 rows", len(r.Rows), r.Error)
+	}
+	if r := e2.Execute("SELECT * FROM other"); r.Error != nil || len(r.Rows) != 1 {
+		t.Errorf("other after repair: rows = %d, err = %v, want 1 row", len(r.Rows), r.Error)
+	}
+}
+
+func TestEngineRepairRefusesWithActiveTransaction(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT)")
+	e.Execute("BEGIN")
+	e.Execute("INSERT INTO users VALUES (1)")
+
+	if _, err := e.Repair(); err == nil {
+		t.Error("Repair() with an open transaction should fail")
+	}
+}
+
+func TestEnginePointInTimeRecovery(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+	target := e.walWriter.GetCurrentLSN()
+	e.Execute("INSERT INTO users VALUES (3, 'carol')")
+	e.Execute("INSERT INTO users VALUES (4, 'dave')")
+	e.Execute("UPDATE users SET name = 'alicia' WHERE id = 1")
+	e.Execute("DELETE FROM users WHERE id = 2")
+	e.Close()
+
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100, RecoveryTargetLSN: target})
+	if err != nil {
+		t.Fatalf("Reopen with recovery target error = %v", err)
+	}
+	want := map[int64]string{1: "alice", 2: "bob"}
+	checkUsers := func(e *Engine) {
+		t.Helper()
+		r := e.Execute("SELECT id, name FROM users")
+		if r.Error != nil {
+			t.Fatalf("SELECT error = %v", r.Error)
+		}
+		if len(r.Rows) != len(want) {
+			t.Fatalf("rows = %d, want %d", len(r.Rows), len(want))
+		}
+		for _, row := range r.Rows {
+			id, name := row.Values[0].IntVal, row.Values[1].StrVal
+			if want[id] != name {
+				t.Errorf("row %d: name = %q, want %q", id, name, want[id])
+			}
+		}
+	}
+	checkUsers(e2)
+	e2.Close()
+
+	// A plain restart must not bring back the rolled-back transactions
+	e3, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e3.Close()
+	checkUsers(e3)
+}
+
+func TestEngineVacuumRetention(t *testing.T) {
+	e, err := New(Config{
+		DataDir:         t.TempDir(),
+		BufferPoolSize:  100,
+		VacuumRetention: VacuumRetention{Txns: 3},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("UPDATE users SET name = 'bob' WHERE id = 1")
+
+	// The old version was deleted by the latest transaction
+	result, err := e.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	if result.TotalRemoved() != 0 {
+		t.Errorf("TotalRemoved within retention = %d, want 0", result.TotalRemoved())
+	}
+
+	// Three more transactions push the update out of the window
+	for i := 2; i <= 4; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, 'user%d')", i, i))
+	}
+	result, err = e.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	if result.TotalRemoved() != 1 {
+		t.Errorf("TotalRemoved after retention = %d, want 1", result.TotalRemoved())
+	}
+}
+
+func TestEngineVacuumRetentionDuration(t *testing.T) {
+	e, err := New(Config{
+		DataDir:         t.TempDir(),
+		BufferPoolSize:  100,
+		VacuumRetention: VacuumRetention{Duration: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	e.Execute("DELETE FROM users WHERE id = 1")
+	e.Execute("INSERT INTO users VALUES (2, 'bob')")
+
+	result, err := e.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	if result.TotalRemoved() != 0 {
+		t.Errorf("TotalRemoved within retention = %d, want 0", result.TotalRemoved())
+	}
+
+	// Once the window no longer covers the delete, the tuple goes
+	e.retention.Duration = time.Nanosecond
+	result, err = e.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	if result.TotalRemoved() != 1 {
+		t.Errorf("TotalRemoved after retention = %d, want 1", result.TotalRemoved())
+	}
+}
+
+func TestEngineAggregates(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE items (id INT, name TEXT, price INT)")
+
+	// An aggregate over no rows still yields one row
+	r := e.Execute("SELECT COUNT(*), COUNT(price), SUM(price), AVG(price), MIN(name), MAX(price) FROM items")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if len(r.Rows) != 1 {
+		t.Fatalf("rows on empty table = %d, want 1", len(r.Rows))
+	}
+	vals := r.Rows[0].Values
+	if vals[0].IntVal != 0 || vals[1].IntVal != 0 {
+		t.Errorf("COUNT on empty table = %v, %v, want 0, 0", vals[0], vals[1])
+	}
+	for i := 2; i < len(vals); i++ {
+		if !vals[i].IsNull {
+			t.Errorf("%s on empty table = %v, want NULL", r.Columns[i], vals[i])
+		}
+	}
+
+	e.Execute("INSERT INTO items VALUES (1, 'pear', 30)")
+	e.Execute("INSERT INTO items VALUES (2, 'apple', 10)")
+	e.Execute("INSERT INTO items VALUES (3, 'fig', 25)")
+	e.Execute("INSERT INTO items VALUES (4, 'kiwi', NULL)")
+	e.Execute("INSERT INTO items VALUES (5, 'plum', 10)")
+	e.Execute("DELETE FROM items WHERE id = 5")
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT COUNT(*) FROM items", "4"},
+		{"SELECT COUNT(price) FROM items", "3"},
+		{"SELECT SUM(price) FROM items", "65"},
+		// 65 / 3 truncates
+		{"SELECT AVG(price) FROM items", "21"},
+		{"SELECT MIN(price), MAX(price) FROM items", "10:30"},
+		{"SELECT MIN(name), MAX(name) FROM items", "apple:pear"},
+		{"SELECT SUM(price) FROM items WHERE price IS NULL", "NULL"},
+		{"SELECT MAX(price) - MIN(price) FROM items", "20"},
+	}
+	for _, tt := range tests {
+		r := e.Execute(tt.sql)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", tt.sql, r.Error)
+		}
+		if len(r.Rows) != 1 {
+			t.Fatalf("%s: rows = %d, want 1", tt.sql, len(r.Rows))
+		}
+		var vals []string
+		for _, v := range r.Rows[0].Values {
+			vals = append(vals, v.String())
+		}
+		if got := strings.Join(vals, ":"); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestEngineGroupByTextColumn(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE emp (name TEXT, dept TEXT, salary INT)")
+	e.Execute("INSERT INTO emp VALUES ('ann', 'eng', 100)")
+	e.Execute("INSERT INTO emp VALUES ('bob', 'sales', 60)")
+	e.Execute("INSERT INTO emp VALUES ('cat', 'eng', 120)")
+	e.Execute("INSERT INTO emp VALUES ('dan', 'ops', 80)")
+	e.Execute("INSERT INTO emp VALUES ('eve', 'eng', 90)")
+	e.Execute("INSERT INTO emp VALUES ('fay', 'sales', 70)")
+
+	r := e.Execute("SELECT dept, COUNT(*), SUM(salary) FROM emp GROUP BY dept ORDER BY dept")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if strings.Join(r.Columns, ",") != "dept,COUNT(*),SUM(salary)" {
+		t.Errorf("Columns = %v", r.Columns)
+	}
+	want := []struct {
+		dept  string
+		count int64
+		sum   int64
+	}{
+		{"eng", 3, 310},
+		{"ops", 1, 80},
+		{"sales", 2, 130},
+	}
+	if len(r.Rows) != len(want) {
+		t.Fatalf("groups = %d, want %d", len(r.Rows), len(want))
+	}
+	for i, w := range want {
+		vals := r.Rows[i].Values
+		if vals[0].StrVal != w.dept || vals[1].IntVal != w.count || vals[2].IntVal != w.sum {
+			t.Errorf("group %d = %v, want %s:%d:%d", i, vals, w.dept, w.count, w.sum)
+		}
+	}
+
+	// A column that is neither grouped nor aggregated is rejected
+	r = e.Execute("SELECT dept, name, COUNT(*) FROM emp GROUP BY dept")
+	if r.Error == nil {
+		t.Fatal("ungrouped column should be an error")
+	}
+	if !strings.Contains(r.Error.Error(), "column name must appear in the GROUP BY clause") {
+		t.Errorf("error = %v", r.Error)
+	}
+}
+
+func TestEngineGroupByHaving(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE emp (name TEXT, dept TEXT, salary INT)")
+	e.Execute(`INSERT INTO emp VALUES ('ann', 'eng', 100), ('bob', 'sales', 60), ('cat', 'eng', 120),
+		('dan', 'ops', 80), ('eve', 'eng', 90), ('fay', 'sales', 70), ('gus', 'sales', 50)`)
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT dept, COUNT(*) FROM emp GROUP BY dept HAVING COUNT(*) > 2 ORDER BY dept", "eng:3,sales:3"},
+		{"SELECT dept FROM emp GROUP BY dept HAVING COUNT(*) = 1", "ops"},
+		// The HAVING aggregate need not appear in the select list
+		{"SELECT dept, COUNT(*) FROM emp GROUP BY dept HAVING SUM(salary) >= 180 ORDER BY dept", "eng:3,sales:3"},
+		{"SELECT dept FROM emp GROUP BY dept HAVING MAX(salary) > 100 AND dept <> 'ops'", "eng"},
+		// WHERE filters rows before grouping, HAVING filters the groups
+		{"SELECT dept, COUNT(*) FROM emp WHERE salary > 55 GROUP BY dept HAVING COUNT(*) >= 2 ORDER BY dept", "eng:3,sales:2"},
+		{"SELECT dept FROM emp GROUP BY dept HAVING COUNT(*) > 10", ""},
+		// Without GROUP BY the whole table is one group
+		{"SELECT COUNT(*) FROM emp HAVING COUNT(*) > 5", "7"},
+		{"SELECT COUNT(*) FROM emp HAVING COUNT(*) > 50", ""},
+	}
+	for _, tt := range tests {
+		r := e.Execute(tt.sql)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", tt.sql, r.Error)
+		}
+		var rows []string
+		for _, row := range r.Rows {
+			var vals []string
+			for _, v := range row.Values {
+				vals = append(vals, v.String())
+			}
+			rows = append(rows, strings.Join(vals, ":"))
+		}
+		if got := strings.Join(rows, ","); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+
+	// HAVING sees columns only through the grouping, like the select list
+	r := e.Execute("SELECT dept FROM emp GROUP BY dept HAVING salary > 50")
+	if r.Error == nil || !strings.Contains(r.Error.Error(), "column salary must appear in the GROUP BY clause") {
+		t.Errorf("ungrouped column in HAVING: error = %v", r.Error)
+	}
+
+	r = e.Execute("EXPLAIN SELECT dept FROM emp GROUP BY dept HAVING COUNT(*) > 2")
+	if r.Error != nil {
+		t.Fatalf("EXPLAIN error = %v", r.Error)
+	}
+	if !strings.Contains(r.Message+fmt.Sprint(r.Rows), "Filter: COUNT(*) > 2") {
+		t.Errorf("EXPLAIN = %v, want the HAVING filter on the aggregate", r.Rows)
+	}
+}
+
+func TestEngineCopyRoundTrip(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+	path := filepath.Join(t.TempDir(), "users.csv")
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT, active BOOL)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice', TRUE)")
+	e.Execute("INSERT INTO users VALUES (2, 'bob, jr.', FALSE)")
+	e.Execute("INSERT INTO users VALUES (3, NULL, NULL)")
+
+	r := e.Execute(fmt.Sprintf("COPY users TO '%s' WITH (FORMAT csv, HEADER true)", path))
+	if r.Error != nil {
+		t.Fatalf("COPY TO error = %v", r.Error)
+	}
+	if r.Message != "COPY 3" {
+		t.Errorf("COPY TO message = %q, want COPY 3", r.Message)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	want := "id,name,active\n1,alice,true\n2,\"bob, jr.\",false\n3,,\n"
+	if string(data) != want {
+		t.Errorf("file = %q, want %q", data, want)
+	}
+
+	e.Execute("CREATE TABLE imported (id INT, name TEXT, active BOOL)")
+	r = e.Execute(fmt.Sprintf("COPY imported FROM '%s' WITH (HEADER true)", path))
+	if r.Error != nil {
+		t.Fatalf("COPY FROM error = %v", r.Error)
+	}
+	if r.Message != "COPY 3" {
+		t.Errorf("COPY FROM message = %q, want COPY 3", r.Message)
+	}
+	got := e.Execute("SELECT id, name, active FROM imported ORDER BY id")
+	if len(got.Rows) != 3 {
+		t.Fatalf("rows after COPY FROM = %d, want 3", len(got.Rows))
+	}
+	if v := got.Rows[1].Values[1]; v.StrVal != "bob, jr." {
+		t.Errorf("name = %v, want bob, jr.", v)
+	}
+	if v := got.Rows[2].Values; !v[1].IsNull || !v[2].IsNull {
+		t.Errorf("row 3 = %v, want NULLs", v)
+	}
+}
+
+func TestEngineCopyFromErrors(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+	dir := t.TempDir()
+
+	e.Execute("CREATE TABLE users (id INT NOT NULL, name TEXT)")
+
+	if r := e.Execute(fmt.Sprintf("COPY users FROM '%s'", filepath.Join(dir, "missing.csv"))); r.Error == nil {
+		t.Error("COPY FROM a missing file should fail")
+	}
+	if r := e.Execute(fmt.Sprintf("COPY users TO '%s'", filepath.Join(dir, "no/such/dir.csv"))); r.Error == nil {
+		t.Error("COPY TO an unwritable path should fail")
+	}
+	if r := e.Execute(fmt.Sprintf("COPY nosuch FROM '%s'", filepath.Join(dir, "missing.csv"))); r.Error == nil {
+		t.Error("COPY on a missing table should fail")
+	}
+
+	// A bad line rolls back the rows loaded before it
+	path := filepath.Join(dir, "bad.csv")
+	os.WriteFile(path, []byte("1,alice\n2,bob\nthree,carol\n"), 0644)
+	r := e.Execute(fmt.Sprintf("COPY users FROM '%s'", path))
+	if r.Error == nil || !strings.Contains(r.Error.Error(), "line 3") {
+		t.Errorf("COPY FROM bad INT error = %v, want a line 3 error", r.Error)
+	}
+	os.WriteFile(path, []byte("1,alice\n,bob\n"), 0644)
+	if r := e.Execute(fmt.Sprintf("COPY users FROM '%s'", path)); r.Error == nil {
+		t.Error("COPY FROM with a NULL in a NOT NULL column should fail")
+	}
+	os.WriteFile(path, []byte("1,alice,extra\n"), 0644)
+	if r := e.Execute(fmt.Sprintf("COPY users FROM '%s'", path)); r.Error == nil {
+		t.Error("COPY FROM with too many fields should fail")
+	}
+	if r := e.Execute("SELECT * FROM users"); len(r.Rows) != 0 {
+		t.Errorf("rows after failed loads = %d, want 0", len(r.Rows))
+	}
+	if e.executor.HasTransaction() {
+		t.Error("failed COPY FROM left a transaction open")
+	}
+}
+
+func TestEngineImportCSV(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+	dir := t.TempDir()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT, active BOOL)")
+
+	path := filepath.Join(dir, "users.csv")
+	os.WriteFile(path, []byte("id,name,active\n1,alice,true\n2,\"bob, jr.\",false\n3,,\n"), 0644)
+	n, err := e.ImportCSV("users", path)
+	if err != nil {
+		t.Fatalf("ImportCSV() error = %v", err)
+	}
+	if n != 3 {
+		t.Errorf("ImportCSV() = %d, want 3", n)
+	}
+	r := e.Execute("SELECT id, name, active FROM users ORDER BY id")
+	if len(r.Rows) != 3 {
+		t.Fatalf("rows after import = %d, want 3", len(r.Rows))
+	}
+	want := []string{"1 alice true", "2 bob, jr. false", "3 NULL NULL"}
+	for i, row := range r.Rows {
+		got := fmt.Sprintf("%v %v %v", row.Values[0], row.Values[1], row.Values[2])
+		if got != want[i] {
+			t.Errorf("row %d = %q, want %q", i, got, want[i])
+		}
+	}
+
+	// A field that does not parse aborts the whole import
+	os.WriteFile(path, []byte("id,name,active\n4,dave,true\nfive,eve,false\n"), 0644)
+	if _, err := e.ImportCSV("users", path); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("ImportCSV() bad INT error = %v, want a line 3 error", err)
+	}
+	os.WriteFile(path, []byte("id,name,active\n4,dave,maybe\n"), 0644)
+	if _, err := e.ImportCSV("users", path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("ImportCSV() bad BOOL error = %v, want a line 2 error", err)
+	}
+	// The header must name the columns in order
+	os.WriteFile(path, []byte("id,active,name\n4,true,dave\n"), 0644)
+	if _, err := e.ImportCSV("users", path); err == nil || !strings.Contains(err.Error(), "header") {
+		t.Errorf("ImportCSV() wrong header error = %v, want a header error", err)
+	}
+	if _, err := e.ImportCSV("nosuch", path); err == nil {
+		t.Error("ImportCSV() into a missing table should fail")
+	}
+	if r := e.Execute("SELECT * FROM users"); len(r.Rows) != 3 {
+		t.Errorf("rows after failed imports = %d, want 3", len(r.Rows))
+	}
+}
+
+func TestEngineImportCSVInTransaction(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+	dir := t.TempDir()
+
+	e.Execute("CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
+
+	// A row that fails undoes the earlier rows of the import, but not
+	// what the transaction did before it
+	path := filepath.Join(dir, "users.csv")
+	os.WriteFile(path, []byte("id,name\n2,bob\n3,carol\n1,again\n"), 0644)
+	e.Execute("BEGIN")
+	e.Execute("INSERT INTO users VALUES (1, 'alice')")
+	if _, err := e.ImportCSV("users", path); err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("ImportCSV() duplicate key error = %v, want a line 4 error", err)
+	}
+	if !e.executor.HasTransaction() {
+		t.Fatal("failed ImportCSV() ended the transaction")
+	}
+	if r := e.Execute("SELECT id FROM users"); len(r.Rows) != 1 {
+		t.Errorf("rows after failed import = %d, want 1", len(r.Rows))
+	}
+
+	os.WriteFile(path, []byte("id,name\n2,bob\n3,carol\n"), 0644)
+	if n, err := e.ImportCSV("users", path); err != nil || n != 2 {
+		t.Errorf("ImportCSV() = %d, %v, want 2, nil", n, err)
+	}
+	if r := e.Execute("COMMIT"); r.Error != nil {
+		t.Fatalf("COMMIT error = %v", r.Error)
+	}
+	r := e.Execute("SELECT id FROM users ORDER BY id")
+	if len(r.Rows) != 3 {
+		t.Fatalf("rows after commit = %d, want 3", len(r.Rows))
+	}
+	for i, row := range r.Rows {
+		if got := row.Values[0].IntVal; got != int64(i+1) {
+			t.Errorf("row %d id = %d, want %d", i, got, i+1)
+		}
+	}
+}
+
+func TestEngineLimitOffset(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE t (n INT, name TEXT)")
+	for i := 1; i <= 10; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO t VALUES (%d, 'n%d')", 11-i, i))
+	}
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"SELECT n FROM t ORDER BY n LIMIT 3", []int64{1, 2, 3}},
+		{"SELECT n FROM t ORDER BY n LIMIT 3 OFFSET 8", []int64{9, 10}},
+		{"SELECT n FROM t ORDER BY n DESC OFFSET 7", []int64{3, 2, 1}},
+		{"SELECT n FROM t ORDER BY n LIMIT 0", nil},
+		{"SELECT n FROM t ORDER BY n OFFSET 10", nil},
+		{"SELECT COUNT(*) FROM t LIMIT 1", []int64{10}},
+		{"SELECT 42 OFFSET 1", nil},
+	}
+	for _, tt := range tests {
+		r := e.Execute(tt.query)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", tt.query, r.Error)
+		}
+		var got []int64
+		for _, row := range r.Rows {
+			got = append(got, row.Values[0].IntVal)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestEngineLimitOffsetIndexScan(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE t (id INT PRIMARY KEY, name TEXT)")
+	for _, i := range rand.New(rand.NewSource(1)).Perm(500) {
+		e.Execute(fmt.Sprintf("INSERT INTO t VALUES (%d, 'n%d')", i, i))
+	}
+	// The PRIMARY KEY is indexed at CREATE TABLE. Dead and key-changed
+	// entries are skipped without shifting the page
+	e.Execute("DELETE FROM t WHERE id < 10")
+	e.Execute("UPDATE t SET id = id + 1000 WHERE id >= 490")
+
+	plans := []struct {
+		query string
+		want  []string
+	}{
+		{"SELECT * FROM t ORDER BY id LIMIT 5 OFFSET 100", []string{
+			"Limit  (rows=5)", "  Count: 5", "  Offset: 100",
+			"  -> Index Scan using t_id on t  (rows=510)", "       Order: id",
+		}},
+		{"SELECT * FROM t ORDER BY id DESC OFFSET 3", []string{
+			"Limit  (rows=507)", "  Offset: 3",
+			"  -> Index Scan Backward using t_id on t  (rows=510)", "       Order: id",
+		}},
+		{"SELECT /*+ seqscan */ * FROM t ORDER BY id LIMIT 5", []string{
+			"Limit  (rows=5)", "  Count: 5",
+			"  -> Sort (id)  (rows=510)", "       -> Seq Scan on t  (rows=510)", "Hint: seqscan",
+		}},
+		{"SELECT * FROM t WHERE id > 5 ORDER BY id LIMIT 5", []string{
+			"Limit  (rows=5)", "  Count: 5",
+			"  -> Sort (id)  (rows=170)", "       -> Index Scan using t_id on t  (rows=170)", "            Index Cond: id > 5",
+		}},
+	}
+	for _, tt := range plans {
+		r := e.Execute("EXPLAIN " + tt.query)
+		if r.Error != nil {
+			t.Fatalf("EXPLAIN %s: error = %v", tt.query, r.Error)
+		}
+		var got []string
+		for _, row := range r.Rows {
+			got = append(got, row.Values[0].StrVal)
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("EXPLAIN %s =\n%s\nwant\n%s", tt.query, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+
+	// The index path returns the same pages as sorting the whole table
+	for _, q := range []string{
+		"ORDER BY id LIMIT 10",
+		"ORDER BY id LIMIT 7 OFFSET 123",
+		"ORDER BY id OFFSET 470",
+		"ORDER BY id DESC LIMIT 4 OFFSET 2",
+		"ORDER BY id LIMIT 5 OFFSET 1000",
+	} {
+		indexed := e.Execute("SELECT * FROM t " + q)
+		sorted := e.Execute("SELECT /*+ seqscan */ * FROM t " + q)
+		if indexed.Error != nil || sorted.Error != nil {
+			t.Fatalf("%s: errors = %v, %v", q, indexed.Error, sorted.Error)
+		}
+		if fmt.Sprint(indexed.Rows) != fmt.Sprint(sorted.Rows) {
+			t.Errorf("%s: index rows = %v, sorted rows = %v", q, indexed.Rows, sorted.Rows)
+		}
+	}
+
+	r := e.Execute("SELECT id FROM t ORDER BY id LIMIT 2 OFFSET 480")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if len(r.Rows) != 2 || r.Rows[0].Values[0].IntVal != 1490 || r.Rows[1].Values[0].IntVal != 1491 {
+		t.Errorf("rows = %v, want ids 1490 and 1491", r.Rows)
+	}
+
+	// A snapshot that predates an update still sees the old version
+	e.Execute("BEGIN")
+	before := e.Execute("SELECT * FROM t ORDER BY id LIMIT 3 OFFSET 50")
+	other := sql.NewExecutor(e.txnManager, e.walWriter)
+	other.SetStorage(e.catalog, e.bufferPool)
+	other.SetIndexes(e.indexes)
+	other.Execute("UPDATE t SET name = 'changed' WHERE id = 61")
+	after := e.Execute("SELECT * FROM t ORDER BY id LIMIT 3 OFFSET 50")
+	e.Execute("COMMIT")
+	if before.Error != nil || after.Error != nil {
+		t.Fatalf("SELECT errors = %v, %v", before.Error, after.Error)
+	}
+	if fmt.Sprint(before.Rows) != fmt.Sprint(after.Rows) {
+		t.Errorf("rows inside the transaction changed from %v to %v", before.Rows, after.Rows)
+	}
+}
+
+// BenchmarkDeepPagination fetches one page of rows far into a table,
+// comparing the ordered index scan with sorting the whole table.
+func BenchmarkDeepPagination(b *testing.B) {
+	e, err := New(Config{DataDir: b.TempDir()})
+	if err != nil {
+		b.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	e.Execute("CREATE TABLE t (id INT PRIMARY KEY, body TEXT)")
+	e.Execute("BEGIN")
+	for i := 0; i < 5000; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO t VALUES (%d, 'row %d')", i, i))
+	}
+	e.Execute("COMMIT")
+	if err := e.CreateIndex("t", "id"); err != nil {
+		b.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	for _, bm := range []struct {
+		name  string
+		query string
+	}{
+		{"IndexScan", "SELECT * FROM t ORDER BY id LIMIT 20 OFFSET 4000"},
+		{"SortAll", "SELECT /*+ seqscan */ * FROM t ORDER BY id LIMIT 20 OFFSET 4000"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if r := e.Execute(bm.query); r.Error != nil || len(r.Rows) != 20 {
+					b.Fatalf("%s: rows = %d, error = %v", bm.query, len(r.Rows), r.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestEngineSelectInto(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id SERIAL PRIMARY KEY, name TEXT NOT NULL, active BOOL)")
+	e.Execute("INSERT INTO users (name, active) VALUES ('alice', true)")
+	e.Execute("INSERT INTO users (name, active) VALUES ('bob', false)")
+	e.Execute("INSERT INTO users (name, active) VALUES ('carol', false)")
+
+	r := e.Execute("SELECT * INTO archive FROM users WHERE active = false")
+	if r.Error != nil {
+		t.Fatalf("SELECT INTO error = %v", r.Error)
+	}
+	if r.Message != "SELECT 2" {
+		t.Errorf("Message = %q, want SELECT 2", r.Message)
+	}
+
+	// Names and types come from the source; constraints do not
+	schema := e.catalog.GetSchema("archive")
+	if schema == nil {
+		t.Fatal("archive was not created")
+	}
+	wantCols := []types.Column{
+		{Name: "id", Type: types.ValueTypeInt, Nullable: true},
+		{Name: "name", Type: types.ValueTypeString, Nullable: true},
+		{Name: "active", Type: types.ValueTypeBool, Nullable: true},
+	}
+	if fmt.Sprint(schema.Columns) != fmt.Sprint(wantCols) {
+		t.Errorf("archive columns = %+v, want %+v", schema.Columns, wantCols)
+	}
+	r = e.Execute("SELECT id, name FROM archive ORDER BY id")
+	if r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if fmt.Sprint(r.Rows) != "[{[2 bob]} {[3 carol]}]" {
+		t.Errorf("archive rows = %v, want bob and carol", r.Rows)
+	}
+
+	// Expressions are named by position and typed from the expression
+	r = e.Execute("SELECT name, UPPER(name), id * 10, id > 1, COUNT(*) INTO stats FROM users WHERE id = 0 GROUP BY name, id")
+	if r.Error != nil {
+		t.Fatalf("SELECT INTO error = %v", r.Error)
+	}
+	wantCols = []types.Column{
+		{Name: "name", Type: types.ValueTypeString, Nullable: true},
+		{Name: "column2", Type: types.ValueTypeString, Nullable: true},
+		{Name: "column3", Type: types.ValueTypeInt, Nullable: true},
+		{Name: "column4", Type: types.ValueTypeBool, Nullable: true},
+		{Name: "column5", Type: types.ValueTypeInt, Nullable: true},
+	}
+	if got := e.catalog.GetSchema("stats").Columns; fmt.Sprint(got) != fmt.Sprint(wantCols) {
+		t.Errorf("stats columns = %+v, want %+v", got, wantCols)
+	}
+
+	errs := []string{
+		"SELECT * INTO archive FROM users",        // target exists
+		"SELECT * INTO other FROM missing",        // source missing
+		"SELECT NULL INTO nothing",                // no type
+		"SELECT name, name INTO twice FROM users", // duplicate name
+		"SELECT COALESCE(NULL, NULL) INTO nulls",  // no type
+	}
+	for _, query := range errs {
+		if r := e.Execute(query); r.Error == nil {
+			t.Errorf("%s should error", query)
+		}
+	}
+	for _, table := range []string{"other", "nothing", "twice", "nulls"} {
+		if e.catalog.GetSchema(table) != nil {
+			t.Errorf("failed SELECT INTO created table %s", table)
+		}
+	}
+}
+
+func TestEngineIsNull(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT, city TEXT)")
+	e.Execute("INSERT INTO users VALUES (1, 'alice', 'Paris')")
+	if r := e.Execute("INSERT INTO users (id, city) VALUES (2, 'Berlin')"); r.Error != nil {
+		t.Fatalf("INSERT error = %v", r.Error)
+	}
+	e.Execute("INSERT INTO users (id) VALUES (3)")
+
+	tests := []struct {
+		where string
+		want  []int64
+	}{
+		{"name IS NULL", []int64{2, 3}},
+		{"name IS NOT NULL", []int64{1}},
+		{"name IS NULL AND city IS NOT NULL", []int64{2}},
+		{"name IS NOT NULL OR city IS NULL", []int64{1, 3}},
+		{"NOT (name IS NULL)", []int64{1}},
+		{"name = NULL", nil},
+		// A comparison with NULL is UNKNOWN: OR with a TRUE operand keeps the
+		// row, and NOT leaves it UNKNOWN rather than flipping it to TRUE
+		{"name = 'alice' OR 1 = 1", []int64{1, 2, 3}},
+		{"NOT (name = 'alice')", nil},
+		{"NOT (name = 'bob') AND city IS NOT NULL", []int64{1}},
+	}
+	for _, tt := range tests {
+		result := e.Execute("SELECT id FROM users WHERE " + tt.where + " ORDER BY id")
+		if result.Error != nil {
+			t.Fatalf("WHERE %s error = %v", tt.where, result.Error)
+		}
+		var got []int64
+		for _, row := range result.Rows {
+			got = append(got, row.Values[0].IntVal)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("WHERE %s = %v, want %v", tt.where, got, tt.want)
+		}
+	}
+
+	if r := e.Execute("DELETE FROM users WHERE name IS NULL"); r.Error != nil {
+		t.Fatalf("DELETE error = %v", r.Error)
+	}
+	if r := e.Execute("SELECT id FROM users"); len(r.Rows) != 1 {
+		t.Errorf("rows after DELETE WHERE name IS NULL = %d, want 1", len(r.Rows))
+	}
+}
+
+func TestEngineTempTable(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	other := sql.NewExecutor(e.txnManager, e.walWriter)
+	other.SetStorage(e.catalog, e.bufferPool)
+
+	if r := e.Execute("CREATE TEMP TABLE tmp (id INT)"); r.Error == nil {
+		t.Error("CREATE TEMP TABLE outside a transaction should fail")
+	}
+
+	e.Execute("BEGIN")
+	if r := e.Execute("CREATE TEMP TABLE tmp (id INT, name TEXT)"); r.Error != nil {
+		t.Fatalf("CREATE TEMP TABLE error = %v", r.Error)
+	}
+	lsn := e.walWriter.GetCurrentLSN()
+	e.Execute("INSERT INTO tmp VALUES (1, 'a')")
+	e.Execute("INSERT INTO tmp VALUES (2, 'b')")
+	e.Execute("UPDATE tmp SET name = 'c' WHERE id = 2")
+	e.Execute("DELETE FROM tmp WHERE id = 1")
+	if got := e.walWriter.GetCurrentLSN(); got != lsn {
+		t.Errorf("temp table writes logged %d WAL records, want 0", got-lsn)
+	}
+	result := e.Execute("SELECT name FROM tmp")
+	if result.Error != nil || len(result.Rows) != 1 || result.Rows[0].Values[0].StrVal != "c" {
+		t.Errorf("SELECT from temp table = %+v, want [c]", result)
+	}
+
+	// Another session neither sees it nor conflicts with the name
+	if r := other.Execute("SELECT * FROM tmp"); r.Error == nil {
+		t.Error("temp table should be invisible to another session")
+	}
+	other.Execute("BEGIN")
+	if r := other.Execute("CREATE TEMP TABLE tmp (x INT)"); r.Error != nil {
+		t.Errorf("another session's CREATE TEMP TABLE tmp error = %v", r.Error)
+	}
+	other.Execute("ROLLBACK")
+
+	e.Execute("COMMIT")
+	if r := e.Execute("SELECT * FROM tmp"); r.Error == nil {
+		t.Error("temp table should be dropped at COMMIT")
+	}
+
+	// Dropped at ROLLBACK too, and it hides a regular table of the same name
+	e.Execute("CREATE TABLE users (id INT)")
+	e.Execute("INSERT INTO users VALUES (1)")
+	e.Execute("BEGIN")
+	e.Execute("CREATE TEMP TABLE users (id INT)")
+	if r := e.Execute("SELECT * FROM users"); len(r.Rows) != 0 {
+		t.Errorf("temp users rows = %d, want 0", len(r.Rows))
+	}
+	e.Execute("ROLLBACK")
+	if r := e.Execute("SELECT * FROM users"); len(r.Rows) != 1 {
+		t.Errorf("users rows after ROLLBACK = %d, want 1", len(r.Rows))
+	}
+	if tables := e.catalog.GetAllTables(); len(tables) != 1 {
+		t.Errorf("tables after ROLLBACK = %v, want [users]", tables)
+	}
+}
+
+func TestEngineDroppedPagesReused(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	fill := func(table string) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if r := e.Execute(fmt.Sprintf("INSERT INTO %s VALUES (%d, '%s')", table, i, strings.Repeat("x", 100))); r.Error != nil {
+				t.Fatalf("INSERT INTO %s error = %v", table, r.Error)
+			}
+		}
+	}
+
+	// A dropped temp table's pages go back to the free list
+	e.Execute("BEGIN")
+	e.Execute("CREATE TEMP TABLE scratch (id INT, pad TEXT)")
+	fill("scratch")
+	e.Execute("COMMIT")
+	numPages := e.diskManager.GetNumPages()
+	free, _ := e.diskManager.FreePages()
+	if len(free) < 3 {
+		t.Fatalf("free pages after dropping the temp table = %v, want its heap pages", free)
+	}
+
+	e.Execute("CREATE TABLE kept (id INT, pad TEXT)")
+	fill("kept")
+	if got := e.diskManager.GetNumPages(); got != numPages {
+		t.Errorf("NumPages after reusing the temp table's pages = %d, want %d", got, numPages)
+	}
+
+	// VACUUM frees the index pages its deletes merge away
+	if err := e.CreateIndex("kept", "id"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+	e.Execute("DELETE FROM kept WHERE id < 50")
+	if _, err := e.Vacuum(); err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	numPages = e.diskManager.GetNumPages()
+	for i := 0; i < 3; i++ {
+		if _, err := e.Vacuum(); err != nil {
+			t.Fatalf("Vacuum() error = %v", err)
+		}
+	}
+	if got := e.diskManager.GetNumPages(); got != numPages {
+		t.Errorf("NumPages after repeated VACUUM = %d, want %d", got, numPages)
+	}
+	if r := e.Execute("SELECT pad FROM kept WHERE id = 70"); r.Error != nil || len(r.Rows) != 1 {
+		t.Errorf("indexed lookup after VACUUM = %d rows, err = %v, want 1 row", len(r.Rows), r.Error)
+	}
+	if result, err := e.Repair(); err != nil || len(result.Reclaimed) != 0 {
+		t.Errorf("Repair() reclaimed %v, err = %v, want nothing left orphaned", result.Reclaimed, err)
+	}
+}
+
+func TestEngineInList(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	for _, v := range []string{"(1, 'alice')", "(2, 'bob')", "(3, 'carol')", "(4, NULL)"} {
+		e.Execute("INSERT INTO users VALUES " + v)
+	}
+
+	tests := []struct {
+		where string
+		want  []int64
+	}{
+		{"id IN (1, 3, 5)", []int64{1, 3}},
+		{"id NOT IN (1, 3, 5)", []int64{2, 4}},
+		{"id IN ()", nil},
+		{"id NOT IN ()", []int64{1, 2, 3, 4}},
+		{"name IN ('bob', 'dave') OR id IN (4)", []int64{2, 4}},
+		{"name NOT IN ('alice')", []int64{2, 3}},
+		{"id NOT IN (1, NULL)", nil},
+	}
+	for _, tt := range tests {
+		result := e.Execute("SELECT id FROM users WHERE " + tt.where + " ORDER BY id")
+		if result.Error != nil {
+			t.Fatalf("WHERE %s error = %v", tt.where, result.Error)
+		}
+		var got []int64
+		for _, row := range result.Rows {
+			got = append(got, row.Values[0].IntVal)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("WHERE %s = %v, want %v", tt.where, got, tt.want)
+		}
+	}
+}
+
+func TestEngineInListNulls(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	// A NULL in the list makes a non-match UNKNOWN, not FALSE, and UNKNOWN
+	// stays UNKNOWN under NOT and beside AND / OR operands that don't settle it
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"1 IN (1, NULL)", "true"},
+		{"2 IN (1, NULL)", "NULL"},
+		{"1 NOT IN (1, NULL)", "false"},
+		{"2 NOT IN (1, NULL)", "NULL"},
+		{"2 NOT IN (1, 3)", "true"},
+		{"NULL IN (1, 2)", "NULL"},
+		{"NULL NOT IN ()", "true"},
+		{"NOT (2 IN (1, NULL))", "NULL"},
+		{"NOT (2 IN (1, NULL) OR FALSE)", "NULL"},
+		{"NOT (2 IN (1, NULL) AND TRUE)", "NULL"},
+		{"2 IN (1, NULL) OR TRUE", "true"},
+		{"2 IN (1, NULL) AND FALSE", "false"},
+		{"NOT (2 IN (1, NULL) AND FALSE)", "true"},
+	}
+	for _, tt := range tests {
+		r := e.Execute("SELECT " + tt.expr)
+		if r.Error != nil {
+			t.Fatalf("SELECT %s error = %v", tt.expr, r.Error)
+		}
+		if got := r.Rows[0].Values[0].String(); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+
+	// In WHERE only TRUE keeps a row
+	e.Execute("CREATE TABLE t (id INT)")
+	e.Execute("INSERT INTO t VALUES (1), (2), (NULL)")
+	for where, want := range map[string]int{
+		"id IN (1, NULL)":           1,
+		"NOT (id IN (1, NULL))":     0,
+		"id NOT IN (1, NULL)":       0,
+		"NOT (id NOT IN (1, NULL))": 1,
+		"NOT (id IN (3) OR id = 1)": 1,
+	} {
+		if r := e.Execute("SELECT id FROM t WHERE " + where); len(r.Rows) != want {
+			t.Errorf("WHERE %s: %d rows, want %d", where, len(r.Rows), want)
+		}
+	}
+}
+
+func TestEngineBetween(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	e.Execute("CREATE TABLE users (id INT, name TEXT)")
+	for _, v := range []string{"(1, 'alice')", "(2, 'bob')", "(3, 'carol')", "(4, 'dave')", "(5, NULL)"} {
+		e.Execute("INSERT INTO users VALUES " + v)
+	}
+
+	tests := []struct {
+		where string
+		want  []int64
+	}{
+		{"id BETWEEN 2 AND 4", []int64{2, 3, 4}},
+		{"id BETWEEN 3 AND 3", []int64{3}},
+		{"id BETWEEN 4 AND 2", nil},
+		{"id NOT BETWEEN 2 AND 4", []int64{1, 5}},
+		{"name BETWEEN 'bob' AND 'carol'", []int64{2, 3}},
+		{"name NOT BETWEEN 'b' AND 'z'", []int64{1}},
+		{"id BETWEEN 1 AND 3 AND name BETWEEN 'b' AND 'z'", []int64{2, 3}},
+		{"id BETWEEN 1 AND 1 OR id BETWEEN 5 AND 9", []int64{1, 5}},
+		{"id BETWEEN 'a' AND 'z'", nil},
+	}
+	for _, tt := range tests {
+		result := e.Execute("SELECT id FROM users WHERE " + tt.where + " ORDER BY id")
+		if result.Error != nil {
+			t.Fatalf("WHERE %s error = %v", tt.where, result.Error)
+		}
+		var got []int64
+		for _, row := range result.Rows {
+			got = append(got, row.Values[0].IntVal)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("WHERE %s = %v, want %v", tt.where, got, tt.want)
+		}
+	}
+}
+
+func TestEngineCollation(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if r := e.Execute("CREATE TABLE users (name TEXT COLLATE NOCASE UNIQUE, city TEXT)"); r.Error != nil {
+		t.Fatalf("CREATE TABLE error = %v", r.Error)
+	}
+	for _, v := range []string{"('alice', 'Paris')", "('Bob', 'berlin')", "('carol', 'Athens')", "('dave', 'PARIS')"} {
+		if r := e.Execute("INSERT INTO users VALUES " + v); r.Error != nil {
+			t.Fatalf("INSERT %s error = %v", v, r.Error)
+		}
+	}
+
+	column := func(e *Engine, sql string) string {
+		t.Helper()
+		r := e.Execute(sql)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+		var out []string
+		for _, row := range r.Rows {
+			out = append(out, row.Values[0].String())
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		// The column's collation applies to comparisons and ordering
+		{"SELECT name FROM users WHERE name = 'ALICE'", "alice"},
+		{"SELECT name FROM users WHERE name > 'B' ORDER BY name", "Bob,carol,dave"},
+		{"SELECT name FROM users ORDER BY name", "alice,Bob,carol,dave"},
+		{"SELECT name FROM users ORDER BY name DESC", "dave,carol,Bob,alice"},
+		{"SELECT name FROM users ORDER BY name COLLATE BINARY", "Bob,alice,carol,dave"},
+		{"SELECT name FROM users WHERE name COLLATE BINARY = 'ALICE'", ""},
+		// A BINARY column can be compared case-insensitively per query
+		{"SELECT city FROM users ORDER BY city", "Athens,PARIS,Paris,berlin"},
+		{"SELECT city FROM users ORDER BY city COLLATE NOCASE", "Athens,berlin,Paris,PARIS"},
+		{"SELECT name FROM users WHERE city = 'paris'", ""},
+		{"SELECT name FROM users WHERE city = 'paris' COLLATE NOCASE ORDER BY 1", "alice,dave"},
+		{"SELECT MAX(city) FROM users", "berlin"},
+		{"SELECT MAX(city COLLATE NOCASE) FROM users", "Paris"},
+		{"SELECT COUNT(*) FROM users GROUP BY city COLLATE NOCASE ORDER BY 1", "1,1,2"},
+	}
+	for _, tt := range tests {
+		if got := column(e, tt.sql); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.sql, got, tt.want)
+		}
+	}
+	// UNIQUE is checked under the collation too
+	if r := e.Execute("INSERT INTO users VALUES ('ALICE', 'Rome')"); r.Error == nil || !strings.Contains(r.Error.Error(), "UNIQUE") {
+		t.Errorf("INSERT ALICE error = %v, want UNIQUE violation", r.Error)
+	}
+
+	// The UNIQUE column is indexed at CREATE TABLE. Index keys are folded,
+	// so the index finds rows in any case
+	if err := e.CreateIndex("users", "name"); err == nil {
+		t.Fatal("CreateIndex() on the already indexed UNIQUE column should error")
+	}
+	e.Execute("INSERT INTO users VALUES ('Eve', 'Oslo')")
+	if got := column(e, "EXPLAIN SELECT * FROM users WHERE name = 'CAROL'"); !strings.Contains(got, "Index Scan") {
+		t.Errorf("plan = %s, want an index scan", got)
+	}
+	for sql, want := range map[string]string{
+		"SELECT name FROM users WHERE name = 'CAROL'": "carol",
+		"SELECT name FROM users WHERE name = 'eve'":   "Eve",
+		"SELECT name FROM users WHERE name = 'zed'":   "",
+	} {
+		if got := column(e, sql); got != want {
+			t.Errorf("%s = %s, want %s", sql, got, want)
+		}
+	}
+	e.Close()
+
+	// The collation is persisted in the catalog
+	e2, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e2.Close()
+	if got := column(e2, "SELECT name FROM users WHERE name = 'BOB'"); got != "Bob" {
+		t.Errorf("after reopen, name = 'BOB' -> %s, want Bob", got)
+	}
+	r := e2.Execute("DESCRIBE users")
+	if r.Error != nil {
+		t.Fatalf("DESCRIBE error = %v", r.Error)
+	}
+	if got := r.Rows[0].Values[1].StrVal; got != "TEXT COLLATE NOCASE" {
+		t.Errorf("name type = %q, want TEXT COLLATE NOCASE", got)
+	}
+	if got := r.Rows[1].Values[1].StrVal; got != "TEXT" {
+		t.Errorf("city type = %q, want TEXT", got)
+	}
+}
+
+func TestEngineMetadataPagesSurviveScan(t *testing.T) {
+	e, err := New(Config{DataDir: t.TempDir(), BufferPoolSize: 16})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	e.Execute("CREATE TABLE t (id INT, body TEXT)")
+	e.Execute("BEGIN")
+	for i := 0; i < 1000; i++ {
+		e.Execute(fmt.Sprintf("INSERT INTO t VALUES (%d, '%s')", i, strings.Repeat("x", 100)))
+	}
+	e.Execute("COMMIT")
+	if err := e.CreateIndex("t", "id"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+	tableID, _ := e.catalog.GetTableID("t")
+	stats, _ := e.catalog.GetTableHeap(tableID).PageStats()
+	if len(stats) <= 16 {
+		t.Fatalf("heap has %d pages, want more than the buffer pool holds", len(stats))
+	}
+
+	// A full scan cycles every heap page through the pool
+	if r := e.Execute("SELECT * FROM t"); r.Error != nil {
+		t.Fatalf("SELECT error = %v", r.Error)
+	}
+	if e.bufferPool.GetPage(e.catalog.GetCatalogPageID()) == nil {
+		t.Error("catalog page evicted by the scan")
+	}
+	if e.bufferPool.GetPage(e.indexes[tableID]["id"].GetRootPageID()) == nil {
+		t.Error("index root page evicted by the scan")
+	}
+	if e.bufferPool.GetPage(stats[0].PageID) != nil {
+		t.Error("first heap page still cached; the scan did not fill the pool")
+	}
+}
+
+// BenchmarkMetadataRereadsAfterScan reads the catalog page and an index root
+// after a full scan of a table larger than the buffer pool, reporting how
+// many of those reads go to disk with and without PinPermanent.
+func BenchmarkMetadataRereadsAfterScan(b *testing.B) {
+	for _, permanent := range []bool{true, false} {
+		name := "Permanent"
+		if !permanent {
+			name = "LRU"
+		}
+		b.Run(name, func(b *testing.B) {
+			e, err := New(Config{DataDir: b.TempDir(), BufferPoolSize: 16})
+			if err != nil {
+				b.Fatalf("New() error = %v", err)
+			}
+			defer e.Close()
+
+			e.Execute("CREATE TABLE t (id INT, body TEXT)")
+			e.Execute("BEGIN")
+			for i := 0; i < 1000; i++ {
+				e.Execute(fmt.Sprintf("INSERT INTO t VALUES (%d, '%s')", i, strings.Repeat("x", 100)))
+			}
+			e.Execute("COMMIT")
+			e.CreateIndex("t", "id")
+			tableID, _ := e.catalog.GetTableID("t")
+			metadata := []types.PageID{e.catalog.GetCatalogPageID(), e.indexes[tableID]["id"].GetRootPageID()}
+			if !permanent {
+				for _, pageID := range metadata {
+					e.bufferPool.UnpinPermanent(pageID)
+				}
+			}
+
+			var rereads uint64
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				e.Execute("SELECT * FROM t")
+				_, misses, _ := e.bufferPool.Stats()
+				for _, pageID := range metadata {
+					if _, err := e.bufferPool.FetchPage(pageID); err != nil {
+						b.Fatalf("FetchPage(%d) error = %v", pageID, err)
+					}
+					e.bufferPool.UnpinPage(pageID, false)
+				}
+				_, misses2, _ := e.bufferPool.Stats()
+				rereads += misses2 - misses
+			}
+			b.ReportMetric(float64(rereads)/float64(b.N), "rereads/op")
+		})
+	}
+}
+
+// BenchmarkScanEvictBatch repeatedly scans a table larger than the buffer
+// pool, so that every page read evicts, with one-at-a-time and batched
+// eviction.
+func BenchmarkScanEvictBatch(b *testing.B) {
+	for _, batch := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("Batch%d", batch), func(b *testing.B) {
+			e, err := New(Config{DataDir: b.TempDir(), BufferPoolSize: 64, EvictBatchSize: batch})
+			if err != nil {
+				b.Fatalf("New() error = %v", err)
+			}
+			defer e.Close()
+
+			e.Execute("CREATE TABLE t (id INT, body TEXT)")
+			e.Execute("BEGIN")
+			for i := 0; i < 5000; i++ {
+				e.Execute(fmt.Sprintf("INSERT INTO t VALUES (%d, '%s')", i, strings.Repeat("x", 100)))
+			}
+			e.Execute("COMMIT")
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if r := e.Execute("SELECT COUNT(*) FROM t"); r.Error != nil {
+					b.Fatalf("SELECT error = %v", r.Error)
+				}
+			}
+		})
+	}
+}
+
+var testSchema = []string{
+	"CREATE TABLE users (id SERIAL PRIMARY KEY, name TEXT)",
+	"CREATE TABLE posts (id INT, user_id INT, title TEXT)",
+	"CREATE INDEX ON posts(user_id)",
+}
+
+func TestEngineEnsureSchema(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := e.EnsureSchema(testSchema); err != nil {
+		t.Fatalf("EnsureSchema() first run error = %v", err)
+	}
+	for _, table := range []string{"users", "posts"} {
+		if e.catalog.GetSchema(table) == nil {
+			t.Errorf("table %s should exist after EnsureSchema", table)
+		}
+	}
+	tableID, _ := e.catalog.GetTableID("posts")
+	if e.GetIndex(tableID, "user_id") == nil {
+		t.Error("index on posts should exist after EnsureSchema")
+	}
+	if r := e.Execute("INSERT INTO users (name) VALUES ('alice')"); r.Error != nil {
+		t.Fatalf("INSERT error = %v", r.Error)
+	}
+	e.Close()
+
+	// The second run, as at the next startup, changes nothing
+	e, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen error = %v", err)
+	}
+	defer e.Close()
+	if err := e.EnsureSchema(testSchema); err != nil {
+		t.Fatalf("EnsureSchema() second run error = %v", err)
+	}
+	result := e.Execute("SELECT name FROM users")
+	if result.Error != nil {
+		t.Fatalf("SELECT error = %v", result.Error)
+	}
+	if len(result.Rows) != 1 || result.Rows[0].Values[0].StrVal != "alice" {
+		t.Errorf("rows = %v, want [[alice]]", result.Rows)
+	}
+}
+
+func TestEngineEnsureSchemaRollsBackCreatedTables(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	err := e.EnsureSchema([]string{
+		"CREATE TABLE users (id INT, name TEXT)",
+		"CREATE TABLE bad (a SERIAL, b SERIAL)",
+	})
+	if err == nil {
+		t.Fatal("EnsureSchema() should fail on a table with two SERIAL columns")
+	}
+	if e.catalog.GetSchema("users") != nil {
+		t.Error("users should be dropped when a later statement fails")
+	}
+
+	err = e.EnsureSchema([]string{
+		"CREATE TABLE users (id INT, name TEXT)",
+		"CREATE INDEX ON users(email)",
+	})
+	if err == nil {
+		t.Fatal("EnsureSchema() should fail on an index over a missing column")
+	}
+	if e.catalog.GetSchema("users") != nil {
+		t.Error("users should be dropped when an index check fails")
+	}
+}
+
+func TestEngineEnsureSchemaRejectsOtherStatements(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	err := e.EnsureSchema([]string{
+		"CREATE TABLE users (id INT, name TEXT)",
+		"INSERT INTO users VALUES (1, 'alice')",
+	})
+	if err == nil || !strings.Contains(err.Error(), "statement 2") {
+		t.Fatalf("EnsureSchema() error = %v, want error for statement 2", err)
+	}
+	if e.catalog.GetSchema("users") != nil {
+		t.Error("no table should be created when a statement is rejected")
+	}
+
+	e.EnsureSchema([]string{"CREATE TABLE users (id INT, name TEXT)", "CREATE INDEX ON users(id)"})
+	if err := e.EnsureSchema([]string{"CREATE INDEX ON users(name)"}); err != nil {
+		t.Fatalf("EnsureSchema() adding a second index error = %v", err)
+	}
+	tableID, _ := e.catalog.GetTableID("users")
+	if e.GetIndex(tableID, "id") == nil || e.GetIndex(tableID, "name") == nil {
+		t.Error("users should have indexes on both id and name")
+	}
+}
+
+func TestEngineSessions(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	mustExec := func(s *Session, sql string) *sql.Result {
+		t.Helper()
+		r := s.Execute(sql)
+		if r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+		return r
+	}
+	ids := func(s *Session) string {
+		t.Helper()
+		var out []string
+		for _, row := range mustExec(s, "SELECT id FROM users ORDER BY id").Rows {
+			out = append(out, row.Values[0].String())
+		}
+		return strings.Join(out, ",")
+	}
+
+	s1 := e.NewSession()
+	defer s1.Close()
+	s2 := e.NewSession()
+	defer s2.Close()
+	mustExec(s1, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
+
+	// Both sessions have a transaction open at once
+	mustExec(s1, "BEGIN")
+	mustExec(s2, "BEGIN")
+	mustExec(s1, "INSERT INTO users VALUES (1, 'alice')")
+	mustExec(s2, "INSERT INTO users VALUES (2, 'bob')")
+	if got := ids(s1); got != "1" {
+		t.Errorf("s1 sees %s, want only its own row 1", got)
+	}
+	if got := ids(s2); got != "2" {
+		t.Errorf("s2 sees %s, want only its own row 2", got)
+	}
+	if r := e.Execute("SELECT id FROM users"); r.Error != nil || len(r.Rows) != 0 {
+		t.Errorf("engine session sees %v, %v before either commits", r.Rows, r.Error)
+	}
+
+	mustExec(s1, "COMMIT")
+	mustExec(s2, "ROLLBACK")
+	if got := ids(s2); got != "1" {
+		t.Errorf("after s1 commits and s2 rolls back, s2 sees %s, want 1", got)
+	}
+
+	// A writer waiting for another session's row lock does not stop that
+	// session from committing
+	mustExec(s1, "BEGIN")
+	mustExec(s1, "SELECT * FROM users WHERE id = 1 FOR UPDATE")
+	done := make(chan *sql.Result, 1)
+	go func() { done <- s2.Execute("UPDATE users SET name = 'alicia' WHERE id = 1") }()
+	select {
+	case r := <-done:
+		t.Fatalf("UPDATE of a locked row returned %v without waiting", r.Error)
+	case <-time.After(50 * time.Millisecond):
+	}
+	mustExec(s1, "COMMIT")
+	select {
+	case r := <-done:
+		if r.Error != nil {
+			t.Errorf("UPDATE after the lock was released error = %v", r.Error)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("UPDATE still blocked after the other session committed")
+	}
+
+	// Session settings are per session, and Close rolls back
+	mustExec(s2, "SET autocommit = off")
+	mustExec(s2, "INSERT INTO users VALUES (3, 'carol')")
+	mustExec(s1, "INSERT INTO users VALUES (4, 'dave')")
+	s2.Close()
+	if r := e.Execute("SELECT name FROM users ORDER BY id"); r.Error != nil || len(r.Rows) != 2 ||
+		r.Rows[0].Values[0].StrVal != "alicia" || r.Rows[1].Values[0].StrVal != "dave" {
+		t.Errorf("rows = %v, %v; want alicia and dave", r.Rows, r.Error)
+	}
+}
+
+func TestEngineSessionsUniqueKey(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	mustExec := func(s *Session, sql string) {
+		t.Helper()
+		if r := s.Execute(sql); r.Error != nil {
+			t.Fatalf("%s: error = %v", sql, r.Error)
+		}
+	}
+	s1 := e.NewSession()
+	defer s1.Close()
+	s2 := e.NewSession()
+	defer s2.Close()
+	mustExec(s1, "CREATE TABLE users (id INT PRIMARY KEY, name TEXT UNIQUE)")
+
+	// The key of a running transaction's insert is taken
+	mustExec(s1, "BEGIN")
+	mustExec(s2, "BEGIN")
+	mustExec(s1, "INSERT INTO users VALUES (1, 'a')")
+	r := s2.Execute("INSERT INTO users VALUES (1, 'b')")
+	if r.Error == nil || !strings.Contains(r.Error.Error(), "could not serialize access") {
+		t.Fatalf("second INSERT of id 1 error = %v, want a serialization conflict", r.Error)
+	}
+	mustExec(s1, "COMMIT")
+	mustExec(s2, "ROLLBACK")
+
+	// So is one committed after the snapshot was taken, and a key an open
+	// UPDATE moves a row to
+	mustExec(s2, "BEGIN ISOLATION LEVEL REPEATABLE READ")
+	mustExec(s2, "SELECT * FROM users")
+	mustExec(s1, "INSERT INTO users VALUES (2, 'c')")
+	if r := s2.Execute("INSERT INTO users VALUES (2, 'd')"); r.Error == nil {
+		t.Error("INSERT of a key committed after the snapshot succeeded")
+	}
+	mustExec(s2, "ROLLBACK")
+	mustExec(s1, "BEGIN")
+	mustExec(s1, "UPDATE users SET name = 'z' WHERE id = 2")
+	if r := s2.Execute("INSERT INTO users VALUES (3, 'z')"); r.Error == nil {
+		t.Error("INSERT of a name another transaction's UPDATE wrote succeeded")
+	}
+
+	// A rolled-back insert frees its key
+	mustExec(s1, "ROLLBACK")
+	mustExec(s1, "BEGIN")
+	mustExec(s1, "INSERT INTO users VALUES (4, 'e')")
+	mustExec(s1, "ROLLBACK")
+	mustExec(s2, "INSERT INTO users VALUES (4, 'e')")
+	mustExec(s2, "INSERT INTO users VALUES (3, 'z')")
+
+	r = e.Execute("SELECT id, name FROM users ORDER BY id")
+	var got []string
+	for _, row := range r.Rows {
+		got = append(got, row.Values[0].String()+row.Values[1].String())
+	}
+	if r.Error != nil || strings.Join(got, ",") != "1a,2c,3z,4e" {
+		t.Errorf("rows = %v, %v; want 1a,2c,3z,4e", got, r.Error)
+	}
+}
+
+func TestEngineExecuteScript(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+	if r := e.Execute("CREATE TABLE users (id INT PRIMARY KEY, name TEXT)"); r.Error != nil {
+		t.Fatalf("CREATE TABLE error = %v", r.Error)
+	}
+
+	results := e.ExecuteScript(`INSERT INTO users VALUES (1, 'a;b');
+		INSERT INTO users VALUES (2, 'bob'); -- second row
+		SELECT name FROM users ORDER BY id;`)
+	if len(results) != 3 {
+		t.Fatalf("ExecuteScript() returned %d results, want 3", len(results))
+	}
+	for i, r := range results[:2] {
+		if r.Error != nil || r.RowsAffected != 1 {
+			t.Errorf("statement %d = %+v, want 1 row inserted", i+1, r)
+		}
+	}
+	sel := results[2]
+	if sel.Error != nil || len(sel.Rows) != 2 || sel.Rows[0].Values[0].String() != "a;b" || sel.Rows[1].Values[0].String() != "bob" {
+		t.Errorf("SELECT = %+v, want rows a;b and bob", sel)
+	}
+
+	// A transaction begun in the script spans its statements
+	results = e.ExecuteScript("BEGIN; INSERT INTO users VALUES (3, 'carol'); ROLLBACK; SELECT COUNT(*) FROM users")
+	if len(results) != 4 {
+		t.Fatalf("ExecuteScript() returned %d results, want 4", len(results))
+	}
+	if r := results[3]; r.Error != nil || r.Rows[0].Values[0].String() != "2" {
+		t.Errorf("COUNT(*) after the rolled back insert = %+v, want 2", r)
+	}
+
+	// By default the script stops at the first failure
+	results = e.ExecuteScript("INSERT INTO users VALUES (1, 'dup'); INSERT INTO users VALUES (4, 'dave')")
+	if len(results) != 1 || results[0].Error == nil {
+		t.Fatalf("ExecuteScript() = %+v, want only the failing INSERT", results)
+	}
+	if r := e.Execute("SELECT id FROM users WHERE id = 4"); r.Error != nil || len(r.Rows) != 0 {
+		t.Errorf("row 4 = %v, %v; want the statement after the failure not run", r.Rows, r.Error)
+	}
+}
+
+func TestEngineExecuteScriptContinueOnError(t *testing.T) {
+	e, err := New(Config{DataDir: t.TempDir(), BufferPoolSize: 100, ContinueScriptOnError: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	results := e.ExecuteScript("CREATE TABLE t (id INT PRIMARY KEY); INSERT INTO t VALUES (1); INSERT INTO t VALUES (1); INSERT INTO t VALUES (2)")
+	if len(results) != 4 {
+		t.Fatalf("ExecuteScript() returned %d results, want 4", len(results))
+	}
+	for i, r := range results {
+		if failed := r.Error != nil; failed != (i == 2) {
+			t.Errorf("statement %d error = %v", i+1, r.Error)
+		}
+	}
+	if r := e.Execute("SELECT COUNT(*) FROM t"); r.Error != nil || r.Rows[0].Values[0].String() != "2" {
+		t.Errorf("COUNT(*) = %+v, want 2", r)
+	}
+}
+
+func TestEngineQueryCursor(t *testing.T) {
+	e := newTestEngine(t)
+	defer e.Close()
+
+	if r := e.Execute("CREATE TABLE items (id INT PRIMARY KEY, body TEXT)"); r.Error != nil {
+		t.Fatalf("CREATE TABLE error = %v", r.Error)
+	}
+	body := strings.Repeat("x", 200)
+	const total = 300
+	for i := 1; i <= total; i++ {
+		if r := e.Execute(fmt.Sprintf("INSERT INTO items VALUES (%d, '%s')", i, body)); r.Error != nil {
+			t.Fatalf("INSERT %d error = %v", i, r.Error)
+		}
+	}
+	pages, err := e.TablePages("items")
+	if err != nil || len(pages) < 5 {
+		t.Fatalf("TablePages() = %d pages, %v; want several", len(pages), err)
+	}
+	pinnedPages := func() int {
+		n := 0
+		for _, p := range pages {
+			if page := e.bufferPool.GetPage(p.PageID); page != nil && page.PinCount > 0 {
+				n++
+			}
+		}
+		return n
+	}
+
+	cur, err := e.Query(fmt.Sprintf("SELECT id FROM items WHERE id > %d", total/2))
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if cols := cur.Columns(); len(cols) != 1 || cols[0] != "id" {
+		t.Errorf("Columns() = %v, want [id]", cols)
+	}
+
+	// Count without keeping the rows; a row committed after Query is not
+	// in the cursor's snapshot
+	if r := e.Execute(fmt.Sprintf("INSERT INTO items VALUES (%d, 'late')", total+1)); r.Error != nil {
+		t.Fatalf("INSERT error = %v", r.Error)
+	}
+	count, sum := 0, int64(0)
+	for {
+		row, err := cur.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if row == nil {
+			break
+		}
+		count++
+		sum += row.Values[0].IntVal
+		if n := pinnedPages(); n > 1 {
+			t.Fatalf("%d heap pages pinned after row %d, want at most 1", n, count)
+		}
+	}
+	if want := int64((total/2 + 1 + total) * total / 4); count != total/2 || sum != want {
+		t.Errorf("cursor returned %d rows summing to %d, want %d rows", count, sum, total/2)
+	}
+	if err := cur.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if n := pinnedPages(); n != 0 {
+		t.Errorf("%d heap pages pinned after Close, want 0", n)
+	}
+
+	// Closing partway through releases the page being read
+	cur, err = e.Query("SELECT * FROM items")
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if row, err := cur.Next(); err != nil || row == nil || len(row.Values) != 2 {
+		t.Fatalf("Next() = %v, %v; want a row of two columns", row, err)
+	}
+	if n := pinnedPages(); n != 1 {
+		t.Errorf("%d heap pages pinned while reading, want 1", n)
+	}
+	cur.Close()
+	if n := pinnedPages(); n != 0 {
+		t.Errorf("%d heap pages pinned after closing partway, want 0", n)
+	}
+	if _, err := cur.Next(); err == nil {
+		t.Error("Next() after Close succeeded, want an error")
+	}
+
+	if _, err := e.Query("DELETE FROM items"); err == nil {
+		t.Error("Query(DELETE) succeeded, want an error")
+	}
+	if _, err := e.Query("SELECT * FROM missing"); err == nil {
+		t.Error("Query() on a missing table succeeded, want an error")
+	}
+}
+
+func TestEngineLargeText(t *testing.T) {
+	dir := t.TempDir()
+	e, err := New(Config{DataDir: dir, BufferPoolSize: 100, StrictChecks: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	long := strings.Repeat("0123456789", 1024) // 10KB
+	for _, sql := range []string{
+		"CREATE TABLE docs (id INT PRIMARY KEY, body TEXT)",
+		"INSERT INTO docs VALUES (1, '" + long + "')",
+		"INSERT INTO docs VALUES (2, 'short')",
+	} {
+		if r := e.Execute(sql); r.Error != nil {
+			t.Fatalf("%.40s...: error = %v", sql, r.Error)
+		}
+	}
+	body := func(e *Engine, id int) string {
+		t.Helper()
+		r := e.Execute(fmt.Sprintf("SELECT body FROM docs WHERE id = %d", id))
+		if r.Error != nil || len(r.Rows) != 1 {
+			t.Fatalf("SELECT id = %d: rows %v, error %v", id, r.Rows, r.Error)
+		}
+		return r.Rows[0].Values[0].StrVal
+	}
+	if got := body(e, 1); got != long {
+		t.Errorf("body read back is %d bytes, want the %d inserted", len(got), len(long))
+	}
+
+	// A new version of the row gets a chain of its own
+	if r := e.Execute("UPDATE docs SET body = '" + long + "!' WHERE id = 1"); r.Error != nil {
+		t.Fatalf("UPDATE error = %v", r.Error)
+	}
+	if got := body(e, 1); got != long+"!" {
+		t.Errorf("body after UPDATE is %d bytes, want %d", len(got), len(long)+1)
+	}
+
+	// VACUUM frees the old version's chain, which the next insert reuses
+	if _, err := e.Vacuum(); err != nil {
+		t.Fatalf("Vacuum() error = %v", err)
+	}
+	free, err := e.diskManager.FreePages()
+	if err != nil || len(free) != 3 {
+		t.Fatalf("free pages after VACUUM = %v, %v; want the old chain", free, err)
+	}
+	if r := e.Execute("INSERT INTO docs VALUES (3, '" + long + "')"); r.Error != nil {
+		t.Fatalf("INSERT error = %v", r.Error)
+	}
+	if free, _ := e.diskManager.FreePages(); len(free) != 0 {
+		t.Errorf("free pages after INSERT = %v, want the chain reused", free)
+	}
+
+	// The data survives a crash: the overflow pages are on disk before the
+	// insert is logged
+	e.walWriter.Flush()
+	e.walWriter.Close()
+	e.diskManager.Close()
+	e, err = New(Config{DataDir: dir, BufferPoolSize: 100})
+	if err != nil {
+		t.Fatalf("Reopen after crash error = %v", err)
+	}
+	defer e.Close()
+	if got := body(e, 1); got != long+"!" {
+		t.Errorf("body after recovery is %d bytes, want %d", len(got), len(long)+1)
+	}
+	if got := body(e, 3); got != long {
+		t.Errorf("body in reused pages is %d bytes, want %d", len(got), len(long))
+	}
+
+	if res, err := e.Repair(); err != nil || len(res.Reclaimed) != 0 {
+		t.Errorf("Repair() reclaimed %v, %v; want the live chains left alone", res.Reclaimed, err)
+	}
+	if got := body(e, 3); got != long {
+		t.Errorf("body after Repair is %d bytes, want %d", len(got), len(long))
 	}
-	return s
 }
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"fmt"
+	"minidb/internal/sql"
+	"strings"
+)
+
+// schemaStep is one statement given to EnsureSchema.
+type schemaStep struct {
+	sql    string // CREATE TABLE text; empty for an index
+	table  string
+	column string // Indexed column; empty for a table
+}
+
+// EnsureSchema creates the tables and indexes an application needs, skipping
+// those that already exist, so that it can run at every startup. Each
+// statement is either CREATE TABLE or CREATE INDEX ON table(column); a table
+// that exists is kept as it is, without comparing its columns.
+//
+// Index builds are not transactional, so rather than running in one
+// transaction EnsureSchema parses every statement before changing anything,
+// creates the tables, checks the indexes against the resulting schema and
+// only then builds them. If a CREATE TABLE or an index check fails, the
+// tables created by this call are dropped again and nothing else has
+// changed.
+func (e *Engine) EnsureSchema(statements []string) error {
+	steps := make([]schemaStep, len(statements))
+	for i, stmt := range statements {
+		step, err := parseSchemaStep(stmt)
+		if err != nil {
+			return fmt.Errorf("ensure schema: statement %d: %w", i+1, err)
+		}
+		steps[i] = step
+	}
+
+	var created []string
+	fail := func(i int, err error) error {
+		for j := len(created) - 1; j >= 0; j-- {
+			e.catalog.DropTable(created[j])
+		}
+		return fmt.Errorf("ensure schema: statement %d: %w", i+1, err)
+	}
+
+	for i, step := range steps {
+		if step.column != "" || e.catalog.GetSchema(step.table) != nil {
+			continue
+		}
+		if r := e.Execute(step.sql); r.Error != nil {
+			return fail(i, r.Error)
+		}
+		created = append(created, step.table)
+	}
+
+	var build []schemaStep
+	for i, step := range steps {
+		if step.column == "" {
+			continue
+		}
+		exists, err := e.checkSchemaIndex(step.table, step.column)
+		if err != nil {
+			return fail(i, err)
+		}
+		if !exists {
+			build = append(build, step)
+		}
+	}
+
+	for _, step := range build {
+		if err := e.CreateIndex(step.table, step.column); err != nil {
+			return fmt.Errorf("ensure schema: index on %s(%s): %w", step.table, step.column, err)
+		}
+	}
+	return nil
+}
+
+// parseSchemaStep accepts CREATE TABLE, and CREATE INDEX ON table(column) in
+// the form the REPL takes for CreateIndex.
+func parseSchemaStep(stmt string) (schemaStep, error) {
+	fields := strings.Fields(stmt)
+	if len(fields) >= 2 && strings.EqualFold(fields[0], "CREATE") && strings.EqualFold(fields[1], "INDEX") {
+		rest := strings.TrimSuffix(strings.TrimSpace(strings.Join(fields[2:], " ")), ";")
+		if len(rest) < 2 || !strings.EqualFold(rest[:2], "ON") {
+			return schemaStep{}, fmt.Errorf("expected CREATE INDEX ON table(column)")
+		}
+		rest = strings.TrimSpace(rest[2:])
+		open := strings.Index(rest, "(")
+		if open < 0 || !strings.HasSuffix(rest, ")") {
+			return schemaStep{}, fmt.Errorf("expected CREATE INDEX ON table(column)")
+		}
+		table := strings.TrimSpace(rest[:open])
+		column := strings.TrimSpace(rest[open+1 : len(rest)-1])
+		if table == "" || column == "" {
+			return schemaStep{}, fmt.Errorf("expected CREATE INDEX ON table(column)")
+		}
+		return schemaStep{table: table, column: column}, nil
+	}
+
+	parsed, err := sql.NewParser(stmt).Parse()
+	if err != nil {
+		return schemaStep{}, err
+	}
+	create, ok := parsed.(*sql.CreateTableStmt)
+	if !ok {
+		return schemaStep{}, fmt.Errorf("only CREATE TABLE and CREATE INDEX are allowed")
+	}
+	return schemaStep{sql: stmt, table: create.TableName}, nil
+}
+
+// checkSchemaIndex reports whether the table already has the index, and
+// fails if it cannot be built.
+func (e *Engine) checkSchemaIndex(tableName, columnName string) (bool, error) {
+	tableID, ok := e.catalog.GetTableID(tableName)
+	if !ok {
+		return false, fmt.Errorf("table %s not found", tableName)
+	}
+	if e.indexes[tableID][columnName] != nil {
+		return true, nil
+	}
+	for _, col := range e.catalog.GetSchema(tableName).Columns {
+		if col.Name == columnName {
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("column %s not found in table %s", columnName, tableName)
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"minidb/internal/sql"
+	"minidb/pkg/types"
+)
+
+// Session is one client's connection to the engine. It has its own
+// transaction, temp tables and SET settings, and shares the tables,
+// indexes, statistics and query cache with the other sessions. Sessions
+// may be used from different goroutines, but one session must not run two
+// statements at once.
+//
+// Statements of all sessions run one at a time under the engine's lock. A
+// statement that waits for a row lock held by another session's open
+// transaction gives up the engine's lock while it waits, so that the other
+// session can run its COMMIT or ROLLBACK.
+type Session struct {
+	engine   *Engine
+	executor *sql.Executor
+}
+
+// registeredFunc is a function given to RegisterFunction, which every new
+// session registers too.
+type registeredFunc struct {
+	name string
+	fn   func(args []types.Value) (types.Value, error)
+}
+
+// NewSession opens a session with no transaction in progress and default
+// settings, apart from Config.RequireWhereForMutation. Close it when done.
+func (e *Engine) NewSession() *Session {
+	ex := sql.NewExecutor(e.txnManager, e.walWriter)
+	ex.SetStorage(e.catalog, e.bufferPool)
+	ex.SetIndexes(e.indexes)
+	ex.SetStatistics(e.stats)
+	if e.queryCache != nil {
+		ex.SetQueryCache(e.queryCache)
+	}
+	ex.SetRequireWhere(e.requireWhere)
+	ex.SetReadOnly(e.readOnly)
+	ex.SetStatementLock(&e.mu)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, f := range e.funcs {
+		ex.RegisterFunction(f.name, f.fn)
+	}
+	s := &Session{engine: e, executor: ex}
+	e.sessions[s] = struct{}{}
+	return s
+}
+
+// Execute runs a SQL statement in the session. Without BEGIN it runs in a
+// transaction of its own, unless the session has set autocommit off.
+func (s *Session) Execute(sqlStr string) *sql.Result {
+	s.engine.mu.Lock()
+	result := s.executor.Execute(sqlStr)
+	s.engine.mu.Unlock()
+	s.engine.triggerAutoAnalyze()
+	return result
+}
+
+// ExecuteScript runs the semicolon-separated statements of a script in
+// order and returns their results. Each runs as if passed to Execute on its
+// own, so a transaction begun by one statement carries on into the next.
+// The script stops after the first statement that fails, whose result is
+// the last one returned, unless Config.ContinueScriptOnError is set.
+func (s *Session) ExecuteScript(script string) []*sql.Result {
+	var results []*sql.Result
+	for _, stmt := range sql.SplitStatements(script) {
+		result := s.Execute(stmt)
+		results = append(results, result)
+		if result.Error != nil && !s.engine.scriptErrors {
+			break
+		}
+	}
+	return results
+}
+
+// Close rolls back the session's open transaction, if any, and drops its
+// temp tables. The session cannot be used afterwards.
+func (s *Session) Close() {
+	s.engine.mu.Lock()
+	defer s.engine.mu.Unlock()
+	s.executor.Close()
+	delete(s.engine.sessions, s)
+}
@@ -4,18 +4,19 @@ package index
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"minidb/internal/storage"
 	"minidb/pkg/types"
 )
 
 // EncodeKey encodes a Value into a byte slice that preserves sort order under bytes.Compare.
-// INT: sign-bit flip + big-endian so that -1 < 0 < 1 in byte order.
+// INT, TIMESTAMP: sign-bit flip + big-endian so that -1 < 0 < 1 in byte order.
 // TEXT: raw bytes, zero-padded to keySize.
 // BOOL: single byte 0x00/0x01.
 func EncodeKey(val types.Value, keySize int) []byte {
 	key := make([]byte, keySize)
 	switch val.Type {
-	case types.ValueTypeInt:
+	case types.ValueTypeInt, types.ValueTypeTimestamp:
 		// XOR sign bit so negative values sort before positive
 		u := uint64(val.IntVal) ^ (1 << 63)
 		binary.BigEndian.PutUint64(key[0:8], u)
@@ -29,13 +30,85 @@ func EncodeKey(val types.Value, keySize int) []byte {
 	return key
 }
 
+// EncodeColumnKey encodes a value of a table's indexed column. TEXT is first
+// mapped through the column's collation, so that values the collation treats
+// as equal share a key.
+func EncodeColumnKey(schema *types.Schema, column string, val types.Value, keySize int) []byte {
+	for _, col := range schema.Columns {
+		if col.Name == column {
+			val = col.Collation.Key(val)
+			break
+		}
+	}
+	return EncodeKey(val, keySize)
+}
+
+// entryRIDSize is the length of the page and slot that end the entry keys
+// of an index on a column that is not UNIQUE.
+const entryRIDSize = 6
+
+// UniqueKeys reports whether the index on a table's column holds a single
+// entry per value, keyed by the value alone. That is so for a UNIQUE or
+// PRIMARY KEY column; any other column may hold a value in many rows.
+func UniqueKeys(schema *types.Schema, column string) bool {
+	for _, col := range schema.Columns {
+		if col.Name == column {
+			return col.Unique || col.PrimaryKey
+		}
+	}
+	return false
+}
+
+// EntryKey returns the key of the entry for the row version at rid in the
+// index on a table's column. The tree keeps one RID per key, so unless the
+// column's values are unique (see UniqueKeys) the encoded value is cut short
+// by entryRIDSize bytes and followed by the version's page and slot, in
+// big-endian order, to give each version its own entry. Values sharing that
+// shortened prefix still get separate entries, next to each other.
+func EntryKey(schema *types.Schema, column string, val types.Value, rid RID, keySize int) []byte {
+	if UniqueKeys(schema, column) {
+		return EncodeColumnKey(schema, column, val, keySize)
+	}
+	key := make([]byte, keySize)
+	copy(key, EncodeColumnKey(schema, column, val, keySize-entryRIDSize))
+	binary.BigEndian.PutUint32(key[keySize-entryRIDSize:], uint32(rid.PageID))
+	binary.BigEndian.PutUint16(key[keySize-2:], rid.SlotNum)
+	return key
+}
+
+// EntryRange returns the first and last keys EntryKey can give the entries
+// for val in the index on a column whose values are not unique, for finding
+// them all with RangeScan. The range also covers values that share the
+// shortened prefix, so the caller still compares the values.
+func EntryRange(schema *types.Schema, column string, val types.Value, keySize int) (start, end []byte) {
+	prefix := EncodeColumnKey(schema, column, val, keySize-entryRIDSize)
+	start = append(append([]byte{}, prefix...), make([]byte, entryRIDSize)...)
+	end = append(append([]byte{}, prefix...), bytes.Repeat([]byte{0xFF}, entryRIDSize)...)
+	return start, end
+}
+
+// MinKey returns a key that sorts before every encoded key, for the open
+// lower end of a RangeScan.
+func MinKey(keySize int) []byte {
+	return make([]byte, keySize)
+}
+
+// MaxKey returns a key that sorts after every encoded key, for the open
+// upper end of a RangeScan.
+func MaxKey(keySize int) []byte {
+	return bytes.Repeat([]byte{0xFF}, keySize)
+}
+
 const (
 	// B-Tree node layout:
-	// Header: IsLeaf(1) + KeyCount(2) + Reserved(1) = 4 bytes
+	// Header: IsLeaf(1) + KeyCount(2) + Reserved(1) + NextLeaf(4) + PrevLeaf(4) = 12 bytes
 	// For leaf nodes: [Key1][RID1][Key2][RID2]...
 	// For internal nodes: [Child0][Key1][Child1][Key2][Child2]...
+	//
+	// NextLeaf/PrevLeaf link the leaves into a doubly-linked list in key order
+	// (InvalidPageID at either end, and always InvalidPageID for internal nodes).
 	
-	btreeHeaderSize = 4
+	btreeHeaderSize = 12
 	maxKeySize      = 64  // Maximum key size
 	ridSize         = 12  // PageID(4) + SlotNum(4) + TableID(4)
 	pageIDSize      = 4
@@ -74,7 +147,10 @@ type BTree struct {
 	bufferPool *storage.BufferPool
 	rootPageID types.PageID
 	keySize    int
-	order      int // Maximum number of children
+	order      int                // Maximum number of children
+	strict     bool               // Validate nodes after each change (BufferPool.SetStrictChecks)
+	unlinked   []types.PageID     // Pages Delete dropped from the tree, see TakeUnlinked
+	onRoot     func(types.PageID) // Called when the root moves, see OnRootChange
 }
 
 // BTreeNode represents a node in the B-Tree.
@@ -85,6 +161,8 @@ type BTreeNode struct {
 	keys     [][]byte
 	children []types.PageID // For internal nodes
 	values   []RID          // For leaf nodes
+	next     types.PageID   // Right sibling leaf (leaf nodes only)
+	prev     types.PageID   // Left sibling leaf (leaf nodes only)
 }
 
 // NewBTree creates a new B-Tree index.
@@ -92,6 +170,7 @@ func NewBTree(bufferPool *storage.BufferPool, keySize int) (*BTree, error) {
 	bt := &BTree{
 		bufferPool: bufferPool,
 		keySize:    keySize,
+		strict:     bufferPool.StrictChecks(),
 	}
 	
 	// Calculate order
@@ -108,12 +187,15 @@ func NewBTree(bufferPool *storage.BufferPool, keySize int) (*BTree, error) {
 		return nil, err
 	}
 	
-	bt.rootPageID = rootPage.ID
+	bt.rootPageID = types.InvalidPageID
+	bt.setRoot(rootPage.ID)
 	
 	// Initialize as empty leaf
 	node := &BTreeNode{
 		page:   rootPage,
 		isLeaf: true,
+		next:   types.InvalidPageID,
+		prev:   types.InvalidPageID,
 	}
 	node.serialize()
 	bufferPool.UnpinPage(rootPage.ID, true)
@@ -125,9 +207,11 @@ func NewBTree(bufferPool *storage.BufferPool, keySize int) (*BTree, error) {
 func LoadBTree(bufferPool *storage.BufferPool, rootPageID types.PageID, keySize int) *BTree {
 	bt := &BTree{
 		bufferPool: bufferPool,
-		rootPageID: rootPageID,
+		rootPageID: types.InvalidPageID,
 		keySize:    keySize,
+		strict:     bufferPool.StrictChecks(),
 	}
+	bt.setRoot(rootPageID)
 	
 	usableSpace := storage.PageSize - storage.PageHeaderSize - btreeHeaderSize
 	leafEntrySize := keySize + ridSize
@@ -139,6 +223,27 @@ func LoadBTree(bufferPool *storage.BufferPool, rootPageID types.PageID, keySize
 	return bt
 }
 
+// OnRootChange registers fn to be called with the new root page whenever a
+// split or a collapse moves the root, so that the owner can record it where
+// LoadBTree will find it.
+func (bt *BTree) OnRootChange(fn func(types.PageID)) {
+	bt.onRoot = fn
+}
+
+// setRoot makes pageID the root, moving the buffer pool's PinPermanent mark
+// from the old root so that every lookup's first page stays cached.
+func (bt *BTree) setRoot(pageID types.PageID) {
+	moved := bt.rootPageID != types.InvalidPageID
+	if moved {
+		bt.bufferPool.UnpinPermanent(bt.rootPageID)
+	}
+	bt.rootPageID = pageID
+	bt.bufferPool.PinPermanent(pageID)
+	if moved && bt.onRoot != nil {
+		bt.onRoot(pageID)
+	}
+}
+
 // Insert inserts a key-value pair into the B-Tree.
 func (bt *BTree) Insert(key []byte, rid RID) error {
 	// Pad or truncate key to fixed size
@@ -200,7 +305,13 @@ func (bt *BTree) Search(key []byte) (RID, bool) {
 	return RID{}, false
 }
 
-// Delete removes a key from the B-Tree.
+// Delete removes a key from the B-Tree. A node left with fewer than
+// minKeys keys borrows a key from a sibling under the same parent, or is
+// merged with it when neither can spare one (see rebalance). Pages emptied
+// by a merge, and a collapsed root, are no longer referenced by the tree;
+// they are not freed here, since the tree's pages on disk may still name
+// them. TakeUnlinked hands them to the owner, and Repair reclaims any that
+// are never freed.
 func (bt *BTree) Delete(key []byte) bool {
 	k := bt.normalizeKey(key)
 	
@@ -214,8 +325,8 @@ func (bt *BTree) Delete(key []byte) bool {
 	for i := 0; i < leafNode.keyCount; i++ {
 		if bytes.Equal(leafNode.keys[i], k) {
 			// Remove by shifting
-			copy(leafNode.keys[i:], leafNode.keys[i+1:])
-			copy(leafNode.values[i:], leafNode.values[i+1:])
+			leafNode.keys = append(leafNode.keys[:i], leafNode.keys[i+1:]...)
+			leafNode.values = append(leafNode.values[:i], leafNode.values[i+1:]...)
 			leafNode.keyCount--
 			found = true
 			break
@@ -224,6 +335,8 @@ func (bt *BTree) Delete(key []byte) bool {
 	
 	if found {
 		leafNode.serialize()
+		bt.checkNode(leafNode, "delete")
+		bt.rebalance(leafNode, path)
 	}
 	
 	// Unpin pages
@@ -235,6 +348,169 @@ func (bt *BTree) Delete(key []byte) bool {
 	return found
 }
 
+// minKeys returns the fewest keys a node other than the root may hold,
+// ceil(order/2)-1.
+func (bt *BTree) minKeys() int {
+	return (bt.order+1)/2 - 1
+}
+
+// rebalance restores the minimum fill of node, whose ancestors are path,
+// after a key was removed from it. It borrows a key from the left sibling
+// under the same parent (the right one for a first child) if the sibling
+// has more than minKeys, and otherwise merges the two, which removes a
+// separator from the parent and may leave it underfull in turn. The root
+// may hold any number of keys; an internal root left with a single child
+// is replaced by that child. The caller keeps node pinned.
+func (bt *BTree) rebalance(node *BTreeNode, path []types.PageID) {
+	if len(path) == 0 {
+		if !node.isLeaf && node.keyCount == 0 {
+			bt.setRoot(node.children[0])
+			bt.unlinked = append(bt.unlinked, node.page.ID)
+		}
+		return
+	}
+	if node.keyCount >= bt.minKeys() {
+		return
+	}
+	
+	parentPage, err := bt.bufferPool.FetchPage(path[len(path)-1])
+	if err != nil {
+		return
+	}
+	defer bt.bufferPool.UnpinPage(parentPage.ID, true)
+	parent := bt.deserializeNode(parentPage)
+	
+	idx := 0
+	for idx < len(parent.children) && parent.children[idx] != node.page.ID {
+		idx++
+	}
+	if idx == len(parent.children) || parent.keyCount == 0 {
+		return
+	}
+	
+	// Pair node with a sibling: left holds the keys below separator sep
+	// and right those at or above it
+	sep := idx - 1
+	if idx == 0 {
+		sep = 0
+	}
+	siblingID := parent.children[sep]
+	if idx == 0 {
+		siblingID = parent.children[1]
+	}
+	siblingPage, err := bt.bufferPool.FetchPage(siblingID)
+	if err != nil {
+		return
+	}
+	defer bt.bufferPool.UnpinPage(siblingID, true)
+	sibling := bt.deserializeNode(siblingPage)
+	left, right := sibling, node
+	if idx == 0 {
+		left, right = node, sibling
+	}
+	
+	switch {
+	case sibling.keyCount > bt.minKeys() && idx > 0:
+		bt.borrowFromLeft(left, right, parent, sep)
+	case sibling.keyCount > bt.minKeys():
+		bt.borrowFromRight(left, right, parent, sep)
+	default:
+		bt.merge(left, right, parent, sep)
+	}
+	
+	left.serialize()
+	right.serialize()
+	parent.serialize()
+	bt.checkNode(left, "rebalance")
+	bt.checkNode(right, "rebalance")
+	bt.checkNode(parent, "rebalance")
+	
+	bt.rebalance(parent, path[:len(path)-1])
+}
+
+// borrowFromLeft moves the last entry of left to the front of its right
+// sibling right, whose separator in parent is keys[sep].
+func (bt *BTree) borrowFromLeft(left, right, parent *BTreeNode, sep int) {
+	last := left.keyCount - 1
+	if right.isLeaf {
+		right.keys = append([][]byte{left.keys[last]}, right.keys...)
+		right.values = append([]RID{left.values[last]}, right.values...)
+		left.values = left.values[:last]
+		parent.keys[sep] = right.keys[0]
+	} else {
+		// Rotate through the parent: its separator comes down and left's
+		// last key goes up with left's last child moving across
+		right.keys = append([][]byte{parent.keys[sep]}, right.keys...)
+		right.children = append([]types.PageID{left.children[last+1]}, right.children...)
+		left.children = left.children[:last+1]
+		parent.keys[sep] = left.keys[last]
+	}
+	left.keys = left.keys[:last]
+	left.keyCount--
+	right.keyCount++
+}
+
+// borrowFromRight moves the first entry of right to the end of its left
+// sibling left, whose separator in parent is keys[sep].
+func (bt *BTree) borrowFromRight(left, right, parent *BTreeNode, sep int) {
+	if left.isLeaf {
+		left.keys = append(left.keys, right.keys[0])
+		left.values = append(left.values, right.values[0])
+		right.keys = right.keys[1:]
+		right.values = right.values[1:]
+		parent.keys[sep] = right.keys[0]
+	} else {
+		left.keys = append(left.keys, parent.keys[sep])
+		left.children = append(left.children, right.children[0])
+		parent.keys[sep] = right.keys[0]
+		right.keys = right.keys[1:]
+		right.children = right.children[1:]
+	}
+	left.keyCount++
+	right.keyCount--
+}
+
+// merge moves every entry of right into its left sibling left and removes
+// right and its separator keys[sep] from parent. An internal merge brings
+// the separator down between the two nodes' keys. right is left empty and
+// unreferenced.
+func (bt *BTree) merge(left, right, parent *BTreeNode, sep int) {
+	if left.isLeaf {
+		left.keys = append(left.keys, right.keys...)
+		left.values = append(left.values, right.values...)
+		
+		// Unlink right from the leaf chain
+		left.next = right.next
+		if right.next != types.InvalidPageID {
+			bt.setPrevLeaf(right.next, left.page.ID)
+		}
+		right.next, right.prev = types.InvalidPageID, types.InvalidPageID
+		right.values = nil
+	} else {
+		left.keys = append(append(left.keys, parent.keys[sep]), right.keys...)
+		left.children = append(left.children, right.children...)
+		right.children = nil
+	}
+	left.keyCount = len(left.keys)
+	right.keys = nil
+	right.keyCount = 0
+	
+	parent.keys = append(parent.keys[:sep], parent.keys[sep+1:]...)
+	parent.children = append(parent.children[:sep+1], parent.children[sep+2:]...)
+	parent.keyCount--
+	bt.unlinked = append(bt.unlinked, right.page.ID)
+}
+
+// TakeUnlinked returns the pages Delete has dropped from the tree since the
+// last call, and forgets them. The owner may free them once the tree's
+// pages and the root it records for the tree are on disk, so that nothing
+// names them any more.
+func (bt *BTree) TakeUnlinked() []types.PageID {
+	pages := bt.unlinked
+	bt.unlinked = nil
+	return pages
+}
+
 // RangeScan returns all RIDs in the given key range.
 func (bt *BTree) RangeScan(startKey, endKey []byte) []RID {
 	start := bt.normalizeKey(startKey)
@@ -247,26 +523,35 @@ func (bt *BTree) RangeScan(startKey, endKey []byte) []RID {
 		return results
 	}
 	
-	// Scan through leaf nodes
-	for {
-		for i := 0; i < leafNode.keyCount; i++ {
-			if bytes.Compare(leafNode.keys[i], start) >= 0 &&
-				bytes.Compare(leafNode.keys[i], end) <= 0 {
-				results = append(results, leafNode.values[i])
-			}
-		}
-		
-		// TODO: Follow sibling pointers for full range scan
-		break
-	}
-	
 	// Unpin pages
 	for _, pageID := range path {
 		bt.bufferPool.UnpinPage(pageID, false)
 	}
 	bt.bufferPool.UnpinPage(leafNode.page.ID, false)
 	
-	return results
+	// Scan through leaf nodes, following the right sibling pointers until
+	// a key passes the end of the range
+	node := leafNode
+	for {
+		for i := 0; i < node.keyCount; i++ {
+			if bytes.Compare(node.keys[i], end) > 0 {
+				return results
+			}
+			if bytes.Compare(node.keys[i], start) >= 0 {
+				results = append(results, node.values[i])
+			}
+		}
+		
+		if node.next == types.InvalidPageID {
+			return results
+		}
+		page, err := bt.bufferPool.FetchPage(node.next)
+		if err != nil {
+			return results
+		}
+		node = bt.deserializeNode(page)
+		bt.bufferPool.UnpinPage(page.ID, false)
+	}
 }
 
 // ScanAll returns all RIDs in the index.
@@ -276,6 +561,85 @@ func (bt *BTree) ScanAll() []RID {
 	return results
 }
 
+// ScanOrdered calls fn for each entry in key order, ascending or descending,
+// walking the leaf chain through its sibling pointers. The scan stops early
+// when fn returns false.
+func (bt *BTree) ScanOrdered(desc bool, fn func(key []byte, rid RID) bool) error {
+	pageID := bt.rootPageID
+	for {
+		page, err := bt.bufferPool.FetchPage(pageID)
+		if err != nil {
+			return err
+		}
+		node := bt.deserializeNode(page)
+		bt.bufferPool.UnpinPage(pageID, false)
+		
+		if node.isLeaf {
+			break
+		}
+		if desc {
+			pageID = node.children[node.keyCount]
+		} else {
+			pageID = node.children[0]
+		}
+	}
+	
+	for pageID != types.InvalidPageID {
+		page, err := bt.bufferPool.FetchPage(pageID)
+		if err != nil {
+			return err
+		}
+		node := bt.deserializeNode(page)
+		bt.bufferPool.UnpinPage(pageID, false)
+		
+		for i := 0; i < node.keyCount; i++ {
+			j := i
+			if desc {
+				j = node.keyCount - 1 - i
+			}
+			if !fn(node.keys[j], node.values[j]) {
+				return nil
+			}
+		}
+		
+		if desc {
+			pageID = node.prev
+		} else {
+			pageID = node.next
+		}
+	}
+	return nil
+}
+
+// Pages returns the IDs of every page in the tree, root first.
+func (bt *BTree) Pages() ([]types.PageID, error) {
+	var pages []types.PageID
+	if err := bt.collectPages(bt.rootPageID, &pages); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+func (bt *BTree) collectPages(pageID types.PageID, pages *[]types.PageID) error {
+	page, err := bt.bufferPool.FetchPage(pageID)
+	if err != nil {
+		return err
+	}
+	node := bt.deserializeNode(page)
+	bt.bufferPool.UnpinPage(pageID, false)
+	
+	*pages = append(*pages, pageID)
+	if node.isLeaf {
+		return nil
+	}
+	for i := 0; i <= node.keyCount && i < len(node.children); i++ {
+		if err := bt.collectPages(node.children[i], pages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (bt *BTree) scanNode(pageID types.PageID, results *[]RID) {
 	page, err := bt.bufferPool.FetchPage(pageID)
 	if err != nil {
@@ -368,6 +732,7 @@ func (bt *BTree) insertIntoLeaf(node *BTreeNode, key []byte, rid RID) bool {
 	node.keyCount++
 	
 	node.serialize()
+	bt.checkNode(node, "insert")
 	return true
 }
 
@@ -382,6 +747,8 @@ func (bt *BTree) splitLeaf(node *BTreeNode, path []types.PageID) {
 	newNode := &BTreeNode{
 		page:   newPage,
 		isLeaf: true,
+		next:   node.next,
+		prev:   node.page.ID,
 	}
 	
 	// Split keys
@@ -397,9 +764,16 @@ func (bt *BTree) splitLeaf(node *BTreeNode, path []types.PageID) {
 	node.values = node.values[:mid]
 	node.keyCount = mid
 	
+	// Link the new leaf between node and its old right sibling
+	if node.next != types.InvalidPageID {
+		bt.setPrevLeaf(node.next, newPage.ID)
+	}
+	node.next = newPage.ID
+	
 	// Serialize both
 	node.serialize()
 	newNode.serialize()
+	bt.checkSplit(node, newNode, newNode.keys[0])
 	
 	// Insert into parent
 	splitKey := newNode.keys[0]
@@ -423,10 +797,12 @@ func (bt *BTree) insertIntoParent(path []types.PageID, leftChild types.PageID, k
 			keyCount: 1,
 			keys:     [][]byte{key},
 			children: []types.PageID{leftChild, rightChild},
+			next:     types.InvalidPageID,
+			prev:     types.InvalidPageID,
 		}
 		rootNode.serialize()
 		
-		bt.rootPageID = newRoot.ID
+		bt.setRoot(newRoot.ID)
 		bt.bufferPool.UnpinPage(newRoot.ID, true)
 		return
 	}
@@ -460,6 +836,7 @@ func (bt *BTree) insertIntoParent(path []types.PageID, leftChild types.PageID, k
 	parentNode.keyCount++
 	
 	parentNode.serialize()
+	bt.checkNode(parentNode, "insert into parent")
 	
 	// Check if parent needs split
 	if parentNode.keyCount > bt.order-1 {
@@ -480,6 +857,8 @@ func (bt *BTree) splitInternal(node *BTreeNode, path []types.PageID) {
 	newNode := &BTreeNode{
 		page:   newPage,
 		isLeaf: false,
+		next:   types.InvalidPageID,
+		prev:   types.InvalidPageID,
 	}
 	
 	// Split
@@ -499,12 +878,70 @@ func (bt *BTree) splitInternal(node *BTreeNode, path []types.PageID) {
 	
 	node.serialize()
 	newNode.serialize()
+	bt.checkSplit(node, newNode, promoteKey)
 	
 	bt.insertIntoParent(path, node.page.ID, promoteKey, newPage.ID)
 	
 	bt.bufferPool.UnpinPage(newPage.ID, true)
 }
 
+// setPrevLeaf rewrites the PrevLeaf pointer of the leaf stored on pageID.
+func (bt *BTree) setPrevLeaf(pageID types.PageID, prev types.PageID) {
+	page, err := bt.bufferPool.FetchPage(pageID)
+	if err != nil {
+		return
+	}
+	node := bt.deserializeNode(page)
+	node.prev = prev
+	node.serialize()
+	bt.bufferPool.UnpinPage(pageID, true)
+}
+
+// checkNode panics if strict checks are on and node, as stored on its page,
+// is malformed: keys out of order, or entry counts that do not fit the node.
+func (bt *BTree) checkNode(node *BTreeNode, op string) {
+	if !bt.strict {
+		return
+	}
+	if err := bt.validateNode(node.page); err != nil {
+		panic(fmt.Sprintf("strict check: B-Tree node %d after %s: %v", node.page.ID, op, err))
+	}
+}
+
+// checkSplit panics if strict checks are on and a split left a malformed
+// node, or separator does not divide left's keys from right's.
+func (bt *BTree) checkSplit(left, right *BTreeNode, separator []byte) {
+	if !bt.strict {
+		return
+	}
+	bt.checkNode(left, "split")
+	bt.checkNode(right, "split")
+	if left.keyCount > 0 && bytes.Compare(left.keys[left.keyCount-1], separator) >= 0 {
+		panic(fmt.Sprintf("strict check: B-Tree split of node %d: left key %x not below separator %x",
+			left.page.ID, left.keys[left.keyCount-1], separator))
+	}
+	if right.keyCount > 0 && bytes.Compare(right.keys[0], separator) < 0 {
+		panic(fmt.Sprintf("strict check: B-Tree split of node %d: right key %x below separator %x",
+			left.page.ID, right.keys[0], separator))
+	}
+}
+
+// validateNode reads a node back from its page and checks that its keys
+// are strictly ascending and that it holds no more entries than a node can
+// before it is split.
+func (bt *BTree) validateNode(page *storage.Page) error {
+	node := bt.deserializeNode(page)
+	if node.keyCount > bt.order {
+		return fmt.Errorf("%d keys, order is %d", node.keyCount, bt.order)
+	}
+	for i := 1; i < node.keyCount; i++ {
+		if bytes.Compare(node.keys[i-1], node.keys[i]) >= 0 {
+			return fmt.Errorf("key %d (%x) not below key %d (%x)", i-1, node.keys[i-1], i, node.keys[i])
+		}
+	}
+	return nil
+}
+
 // normalizeKey pads or truncates key to fixed size.
 func (bt *BTree) normalizeKey(key []byte) []byte {
 	k := make([]byte, bt.keySize)
@@ -522,6 +959,8 @@ func (bt *BTree) deserializeNode(page *storage.Page) *BTreeNode {
 
 	node.isLeaf = page.Data[storage.PageHeaderSize] == 1
 	node.keyCount = int(binary.LittleEndian.Uint16(page.Data[storage.PageHeaderSize+1 : storage.PageHeaderSize+3]))
+	node.next = types.PageID(binary.LittleEndian.Uint32(page.Data[storage.PageHeaderSize+4 : storage.PageHeaderSize+8]))
+	node.prev = types.PageID(binary.LittleEndian.Uint32(page.Data[storage.PageHeaderSize+8 : storage.PageHeaderSize+12]))
 
 	offset := storage.PageHeaderSize + btreeHeaderSize
 	
@@ -569,6 +1008,8 @@ func (node *BTreeNode) serialize() {
 		page.Data[storage.PageHeaderSize] = 0
 	}
 	binary.LittleEndian.PutUint16(page.Data[storage.PageHeaderSize+1:storage.PageHeaderSize+3], uint16(node.keyCount))
+	binary.LittleEndian.PutUint32(page.Data[storage.PageHeaderSize+4:storage.PageHeaderSize+8], uint32(node.next))
+	binary.LittleEndian.PutUint32(page.Data[storage.PageHeaderSize+8:storage.PageHeaderSize+12], uint32(node.prev))
 
 	offset := storage.PageHeaderSize + btreeHeaderSize
 	
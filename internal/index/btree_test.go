@@ -3,9 +3,12 @@ package index
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
 	"minidb/internal/storage"
 	"minidb/pkg/types"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -239,6 +242,152 @@ func TestEncodeKeyStringOrdering(t *testing.T) {
 	}
 }
 
+func TestEncodeKeyBoolOrdering(t *testing.T) {
+	f := EncodeKey(types.Value{Type: types.ValueTypeBool, BoolVal: false}, 64)
+	tr := EncodeKey(types.Value{Type: types.ValueTypeBool, BoolVal: true}, 64)
+	if bytes.Compare(f, tr) >= 0 {
+		t.Error("EncodeKey(false) should sort before EncodeKey(true)")
+	}
+}
+
+func TestEncodeKeyTimestampOrdering(t *testing.T) {
+	// 1969-12-31, the epoch, 2024-01-01, 2024-01-02
+	vals := []int64{-86400, 0, 1704067200, 1704153600}
+	var prev []byte
+	for _, v := range vals {
+		key := EncodeKey(types.Value{Type: types.ValueTypeTimestamp, IntVal: v}, 64)
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			t.Errorf("EncodeKey(%s) should sort after the previous timestamp", types.FormatTimestamp(v))
+		}
+		prev = key
+	}
+}
+
+func TestRangeScanBoolKeys(t *testing.T) {
+	bt := newTestBTree(t, 64)
+	f := EncodeKey(types.Value{Type: types.ValueTypeBool, BoolVal: false}, 64)
+	tr := EncodeKey(types.Value{Type: types.ValueTypeBool, BoolVal: true}, 64)
+	bt.Insert(tr, RID{PageID: 1, SlotNum: 1, TableID: 1})
+	bt.Insert(f, RID{PageID: 1, SlotNum: 0, TableID: 1})
+
+	tests := []struct {
+		name       string
+		start, end []byte
+		want       []uint16
+	}{
+		{"false only", f, f, []uint16{0}},
+		{"true only", tr, tr, []uint16{1}},
+		{"false..true", f, tr, []uint16{0, 1}},
+		{"empty when reversed", tr, f, nil},
+	}
+	for _, tt := range tests {
+		var got []uint16
+		for _, rid := range bt.RangeScan(tt.start, tt.end) {
+			got = append(got, rid.SlotNum)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: slots = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEntryKeyDuplicateValues(t *testing.T) {
+	schema := &types.Schema{TableName: "t", Columns: []types.Column{
+		{Name: "id", Type: types.ValueTypeInt, PrimaryKey: true, Unique: true},
+		{Name: "age", Type: types.ValueTypeInt},
+	}}
+	bt := newTestBTree(t, 64)
+	age := func(v int64) types.Value { return types.Value{Type: types.ValueTypeInt, IntVal: v} }
+	rows := []struct {
+		age int64
+		rid RID
+	}{
+		{30, RID{PageID: 2, SlotNum: 1, TableID: 1}},
+		{31, RID{PageID: 1, SlotNum: 0, TableID: 1}},
+		{30, RID{PageID: 1, SlotNum: 7, TableID: 1}},
+		{30, RID{PageID: 300, SlotNum: 0, TableID: 1}},
+	}
+	for _, r := range rows {
+		bt.Insert(EntryKey(schema, "age", age(r.age), r.rid, 64), r.rid)
+	}
+
+	// Every row sharing the value keeps an entry, in RID order
+	start, end := EntryRange(schema, "age", age(30), 64)
+	var got []string
+	for _, rid := range bt.RangeScan(start, end) {
+		got = append(got, fmt.Sprintf("%d/%d", rid.PageID, rid.SlotNum))
+	}
+	if want := "1/7 2/1 300/0"; strings.Join(got, " ") != want {
+		t.Errorf("RangeScan(EntryRange(30)) = %v, want %s", got, want)
+	}
+
+	// A UNIQUE column keys its entries by the value alone
+	rid := RID{PageID: 9, SlotNum: 3, TableID: 1}
+	if !bytes.Equal(EntryKey(schema, "id", age(5), rid, 64), EncodeColumnKey(schema, "id", age(5), 64)) {
+		t.Error("EntryKey() on a PRIMARY KEY column should be the encoded value")
+	}
+}
+
+func TestRangeScanOpenEnded(t *testing.T) {
+	bt := newTestBTree(t, 64)
+	for i := -50; i < 50; i++ {
+		key := EncodeKey(types.Value{Type: types.ValueTypeInt, IntVal: int64(i)}, 64)
+		bt.Insert(key, RID{PageID: types.PageID(i + 50), TableID: 1})
+	}
+	zero := EncodeKey(types.Value{Type: types.ValueTypeInt, IntVal: 0}, 64)
+
+	if got := len(bt.RangeScan(MinKey(64), zero)); got != 51 {
+		t.Errorf("RangeScan(MinKey, 0) = %d RIDs, want 51 (-50..0)", got)
+	}
+	if got := len(bt.RangeScan(zero, MaxKey(64))); got != 50 {
+		t.Errorf("RangeScan(0, MaxKey) = %d RIDs, want 50 (0..49)", got)
+	}
+	if got := len(bt.RangeScan(MinKey(64), MaxKey(64))); got != 100 {
+		t.Errorf("RangeScan(MinKey, MaxKey) = %d RIDs, want all 100", got)
+	}
+}
+
+func TestRangeScanAcrossLeaves(t *testing.T) {
+	bt := newTestBTree(t, 8)
+
+	// Enough keys for several leaf splits, inserted out of order
+	count := 1000
+	for _, i := range rand.New(rand.NewSource(2)).Perm(count) {
+		key := EncodeKey(types.Value{Type: types.ValueTypeInt, IntVal: int64(i)}, 8)
+		if err := bt.Insert(key, RID{PageID: types.PageID(i), TableID: 1}); err != nil {
+			t.Fatalf("Insert(%d) error = %v", i, err)
+		}
+	}
+	if pages, _ := bt.Pages(); len(pages) < 4 {
+		t.Fatalf("tree has %d pages, want several leaves", len(pages))
+	}
+
+	tests := []struct{ start, end int }{
+		{100, 899},
+		{0, count - 1},
+		{500, 500},
+		{990, 2000},
+	}
+	for _, tt := range tests {
+		start := EncodeKey(types.Value{Type: types.ValueTypeInt, IntVal: int64(tt.start)}, 8)
+		end := EncodeKey(types.Value{Type: types.ValueTypeInt, IntVal: int64(tt.end)}, 8)
+		rids := bt.RangeScan(start, end)
+
+		last := tt.end
+		if last >= count {
+			last = count - 1
+		}
+		if want := last - tt.start + 1; len(rids) != want {
+			t.Fatalf("RangeScan(%d, %d) = %d RIDs, want %d", tt.start, tt.end, len(rids), want)
+		}
+		for i, rid := range rids {
+			if int(rid.PageID) != tt.start+i {
+				t.Fatalf("RangeScan(%d, %d)[%d] = %d, want %d", tt.start, tt.end, i, rid.PageID, tt.start+i)
+			}
+		}
+	}
+}
+
 func TestNormalizeKey(t *testing.T) {
 	bt := newTestBTree(t, 8)
 
@@ -257,3 +406,290 @@ func TestNormalizeKey(t *testing.T) {
 		t.Errorf("truncated key = %q, want %q", long, "this is ")
 	}
 }
+
+// leafChainKeys walks the leaf sibling chain from the leftmost leaf forward
+// and from the rightmost leaf backward, returning the keys seen in each walk.
+func leafChainKeys(t *testing.T, bt *BTree) (forward, backward []string) {
+	t.Helper()
+
+	edgeLeaf := func(rightmost bool) types.PageID {
+		pageID := bt.rootPageID
+		for {
+			page, err := bt.bufferPool.FetchPage(pageID)
+			if err != nil {
+				t.Fatalf("FetchPage(%d) error = %v", pageID, err)
+			}
+			node := bt.deserializeNode(page)
+			bt.bufferPool.UnpinPage(pageID, false)
+			if node.isLeaf {
+				return pageID
+			}
+			if rightmost {
+				pageID = node.children[len(node.children)-1]
+			} else {
+				pageID = node.children[0]
+			}
+		}
+	}
+
+	walk := func(start types.PageID, reverse bool) []string {
+		var keys []string
+		prev := types.InvalidPageID
+		for pageID := start; pageID != types.InvalidPageID; {
+			page, err := bt.bufferPool.FetchPage(pageID)
+			if err != nil {
+				t.Fatalf("FetchPage(%d) error = %v", pageID, err)
+			}
+			node := bt.deserializeNode(page)
+			bt.bufferPool.UnpinPage(pageID, false)
+
+			// Each step must be mirrored by the opposite pointer
+			back := node.prev
+			if reverse {
+				back = node.next
+			}
+			if back != prev {
+				t.Fatalf("leaf %d back pointer = %d, want %d", pageID, back, prev)
+			}
+
+			if reverse {
+				for i := node.keyCount - 1; i >= 0; i-- {
+					keys = append(keys, string(node.keys[i]))
+				}
+				prev, pageID = pageID, node.prev
+			} else {
+				for i := 0; i < node.keyCount; i++ {
+					keys = append(keys, string(node.keys[i]))
+				}
+				prev, pageID = pageID, node.next
+			}
+		}
+		return keys
+	}
+
+	return walk(edgeLeaf(false), false), walk(edgeLeaf(true), true)
+}
+
+func TestLeafChainInvariant(t *testing.T) {
+	bt := newTestBTree(t, 8)
+	rng := rand.New(rand.NewSource(42))
+
+	live := make(map[string]bool)
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("k%07d", rng.Intn(5000))
+		if live[key] && rng.Intn(3) == 0 {
+			if !bt.Delete([]byte(key)) {
+				t.Fatalf("Delete(%s) = false, want true", key)
+			}
+			delete(live, key)
+			continue
+		}
+		if err := bt.Insert([]byte(key), RID{PageID: types.PageID(i), TableID: 1}); err != nil {
+			t.Fatalf("Insert(%s) error = %v", key, err)
+		}
+		live[key] = true
+	}
+
+	want := make([]string, 0, len(live))
+	for k := range live {
+		want = append(want, k)
+	}
+	sort.Strings(want)
+
+	forward, backward := leafChainKeys(t, bt)
+	if len(forward) != len(want) {
+		t.Fatalf("forward walk = %d keys, want %d", len(forward), len(want))
+	}
+	if len(backward) != len(want) {
+		t.Fatalf("backward walk = %d keys, want %d", len(backward), len(want))
+	}
+	for i := range want {
+		if forward[i] != want[i] {
+			t.Fatalf("forward[%d] = %q, want %q", i, forward[i], want[i])
+		}
+		if backward[len(backward)-1-i] != want[i] {
+			t.Fatalf("backward[%d] = %q, want %q", len(backward)-1-i, backward[len(backward)-1-i], want[i])
+		}
+	}
+}
+
+// treeShape walks the tree and returns its height, failing if a node other
+// than the root holds fewer than minKeys keys or the leaves are at
+// different depths.
+func treeShape(t *testing.T, bt *BTree) int {
+	t.Helper()
+	leafDepth := 0
+	var walk func(pageID types.PageID, depth int)
+	walk = func(pageID types.PageID, depth int) {
+		page, err := bt.bufferPool.FetchPage(pageID)
+		if err != nil {
+			t.Fatalf("FetchPage(%d) error = %v", pageID, err)
+		}
+		node := bt.deserializeNode(page)
+		bt.bufferPool.UnpinPage(pageID, false)
+
+		if pageID != bt.rootPageID && node.keyCount < bt.minKeys() {
+			t.Fatalf("node %d has %d keys, want at least %d", pageID, node.keyCount, bt.minKeys())
+		}
+		if node.isLeaf {
+			if leafDepth == 0 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				t.Fatalf("leaf %d at depth %d, others at %d", pageID, depth, leafDepth)
+			}
+			return
+		}
+		for _, child := range node.children {
+			walk(child, depth+1)
+		}
+	}
+	walk(bt.rootPageID, 1)
+	return leafDepth
+}
+
+func TestDeleteRebalances(t *testing.T) {
+	bt := newTestBTree(t, 64)
+	bt.strict = true
+
+	count := 5000
+	keyOf := func(i int) []byte {
+		return EncodeKey(types.Value{Type: types.ValueTypeInt, IntVal: int64(i)}, 64)
+	}
+	for _, i := range rand.New(rand.NewSource(3)).Perm(count) {
+		if err := bt.Insert(keyOf(i), RID{PageID: types.PageID(i), TableID: 1}); err != nil {
+			t.Fatalf("Insert(%d) error = %v", i, err)
+		}
+	}
+	height := treeShape(t, bt)
+	if height < 3 {
+		t.Fatalf("tree height = %d, want at least 3", height)
+	}
+	pagesBefore, _ := bt.Pages()
+
+	// Delete all but every 50th key, in random order
+	for _, i := range rand.New(rand.NewSource(4)).Perm(count) {
+		if i%50 == 0 {
+			continue
+		}
+		if !bt.Delete(keyOf(i)) {
+			t.Fatalf("Delete(%d) = false, want true", i)
+		}
+	}
+
+	if got := treeShape(t, bt); got >= height {
+		t.Errorf("tree height after deletes = %d, want below %d", got, height)
+	}
+	if pages, _ := bt.Pages(); len(pages) >= len(pagesBefore)/10 {
+		t.Errorf("tree has %d pages after deleting 98%% of keys, had %d", len(pages), len(pagesBefore))
+	}
+	for i := 0; i < count; i++ {
+		rid, found := bt.Search(keyOf(i))
+		if want := i%50 == 0; found != want || (found && rid.PageID != types.PageID(i)) {
+			t.Fatalf("Search(%d) = %v, %v; want found=%v", i, rid, found, want)
+		}
+	}
+	forward, backward := leafChainKeys(t, bt)
+	if len(forward) != count/50 || len(backward) != count/50 {
+		t.Errorf("leaf chain holds %d keys forward, %d backward; want %d", len(forward), len(backward), count/50)
+	}
+
+	// Emptying the tree collapses it to an empty root leaf
+	for i := 0; i < count; i += 50 {
+		if !bt.Delete(keyOf(i)) {
+			t.Fatalf("Delete(%d) = false, want true", i)
+		}
+	}
+	if got := treeShape(t, bt); got != 1 {
+		t.Errorf("height of the emptied tree = %d, want 1", got)
+	}
+	if rids := bt.ScanAll(); len(rids) != 0 {
+		t.Errorf("ScanAll() on the emptied tree = %d RIDs, want 0", len(rids))
+	}
+	if err := bt.Insert(keyOf(7), RID{PageID: 7, TableID: 1}); err != nil {
+		t.Fatalf("Insert() into the emptied tree error = %v", err)
+	}
+	if _, found := bt.Search(keyOf(7)); !found {
+		t.Error("key inserted into the emptied tree not found")
+	}
+}
+
+func TestBTreeStrictChecks(t *testing.T) {
+	dm, err := storage.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewDiskManager() error = %v", err)
+	}
+	bp := storage.NewBufferPool(dm, 200)
+	bp.SetStrictChecks(true)
+
+	// Inserts that split leaves and internal nodes pass
+	bt, _ := NewBTree(bp, 8)
+	rng := rand.New(rand.NewSource(7))
+	for i := 0; i < 3000; i++ {
+		bt.Insert([]byte(fmt.Sprintf("k%07d", rng.Intn(100000))), RID{PageID: types.PageID(i), TableID: 1})
+	}
+
+	// A leaf whose keys are out of order is caught by the next insert
+	bt, _ = NewBTree(bp, 8)
+	for _, key := range []string{"a", "b", "c"} {
+		bt.Insert([]byte(key), RID{TableID: 1})
+	}
+	page, _ := bp.FetchPage(bt.GetRootPageID())
+	node := bt.deserializeNode(page)
+	node.keys[0], node.keys[2] = node.keys[2], node.keys[0]
+	node.serialize()
+	bp.UnpinPage(page.ID, true)
+
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.HasPrefix(msg, "strict check: B-Tree node") || !strings.Contains(msg, "not below") {
+			t.Errorf("panic = %q, want a strict check on key order", msg)
+		}
+	}()
+	bt.Insert([]byte("d"), RID{TableID: 1})
+}
+
+func TestScanOrdered(t *testing.T) {
+	bt := newTestBTree(t, 8)
+
+	// Enough keys for several leaves, inserted out of order
+	count := 1000
+	for _, i := range rand.New(rand.NewSource(1)).Perm(count) {
+		key := EncodeKey(types.Value{Type: types.ValueTypeInt, IntVal: int64(i)}, 8)
+		if err := bt.Insert(key, RID{PageID: types.PageID(i), TableID: 1}); err != nil {
+			t.Fatalf("Insert(%d) error = %v", i, err)
+		}
+	}
+
+	for _, desc := range []bool{false, true} {
+		var got []int
+		err := bt.ScanOrdered(desc, func(key []byte, rid RID) bool {
+			got = append(got, int(rid.PageID))
+			return true
+		})
+		if err != nil {
+			t.Fatalf("ScanOrdered(%t) error = %v", desc, err)
+		}
+		if len(got) != count {
+			t.Fatalf("ScanOrdered(%t) visited %d entries, want %d", desc, len(got), count)
+		}
+		for i, v := range got {
+			want := i
+			if desc {
+				want = count - 1 - i
+			}
+			if v != want {
+				t.Fatalf("ScanOrdered(%t) entry %d = %d, want %d", desc, i, v, want)
+			}
+		}
+	}
+
+	// Returning false stops the scan
+	visited := 0
+	bt.ScanOrdered(false, func(key []byte, rid RID) bool {
+		visited++
+		return visited < 5
+	})
+	if visited != 5 {
+		t.Errorf("visited = %d after stopping at 5", visited)
+	}
+}
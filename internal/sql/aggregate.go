@@ -0,0 +1,297 @@
+package sql
+
+import (
+	"fmt"
+	"minidb/pkg/types"
+	"strconv"
+	"strings"
+)
+
+// aggregateFuncs lists the upper-cased names parsed as aggregate calls
+// rather than scalar functions.
+var aggregateFuncs = map[string]bool{
+	"COUNT": true,
+	"SUM":   true,
+	"AVG":   true,
+	"MIN":   true,
+	"MAX":   true,
+}
+
+// containsAggregate reports whether expr calls an aggregate anywhere.
+func containsAggregate(expr Expr) bool {
+	switch ex := expr.(type) {
+	case *AggregateExpr:
+		return true
+	case *BinaryExpr:
+		return containsAggregate(ex.Left) || containsAggregate(ex.Right)
+	case *UnaryExpr:
+		return containsAggregate(ex.Operand)
+	case *CollateExpr:
+		return containsAggregate(ex.Expr)
+	case *IsNullExpr:
+		return containsAggregate(ex.Expr)
+	case *InExpr:
+		if containsAggregate(ex.Expr) {
+			return true
+		}
+		for _, v := range ex.Values {
+			if containsAggregate(v) {
+				return true
+			}
+		}
+	case *BetweenExpr:
+		return containsAggregate(ex.Expr) || containsAggregate(ex.Low) || containsAggregate(ex.High)
+	case *FuncCallExpr:
+		for _, arg := range ex.Args {
+			if containsAggregate(arg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collectAggregates adds every aggregate call in expr to aggs, keyed by its
+// SQL text so that repeated calls are computed once.
+func collectAggregates(expr Expr, aggs map[string]*AggregateExpr) {
+	switch ex := expr.(type) {
+	case *AggregateExpr:
+		aggs[exprString(ex)] = ex
+	case *BinaryExpr:
+		collectAggregates(ex.Left, aggs)
+		collectAggregates(ex.Right, aggs)
+	case *UnaryExpr:
+		collectAggregates(ex.Operand, aggs)
+	case *CollateExpr:
+		collectAggregates(ex.Expr, aggs)
+	case *IsNullExpr:
+		collectAggregates(ex.Expr, aggs)
+	case *InExpr:
+		collectAggregates(ex.Expr, aggs)
+		for _, v := range ex.Values {
+			collectAggregates(v, aggs)
+		}
+	case *BetweenExpr:
+		collectAggregates(ex.Expr, aggs)
+		collectAggregates(ex.Low, aggs)
+		collectAggregates(ex.High, aggs)
+	case *FuncCallExpr:
+		for _, arg := range ex.Args {
+			collectAggregates(arg, aggs)
+		}
+	}
+}
+
+// checkGrouped verifies that expr reads columns only through a GROUP BY
+// expression or inside an aggregate. groupKeys holds the SQL text of the
+// GROUP BY expressions.
+func checkGrouped(expr Expr, groupKeys map[string]bool) error {
+	if groupKeys[exprString(expr)] {
+		return nil
+	}
+	switch ex := expr.(type) {
+	case *ColumnExpr:
+		return fmt.Errorf("column %s must appear in the GROUP BY clause or be used in an aggregate function", ex.Name)
+	case *BinaryExpr:
+		if err := checkGrouped(ex.Left, groupKeys); err != nil {
+			return err
+		}
+		return checkGrouped(ex.Right, groupKeys)
+	case *UnaryExpr:
+		return checkGrouped(ex.Operand, groupKeys)
+	case *CollateExpr:
+		return checkGrouped(ex.Expr, groupKeys)
+	case *IsNullExpr:
+		return checkGrouped(ex.Expr, groupKeys)
+	case *InExpr:
+		if err := checkGrouped(ex.Expr, groupKeys); err != nil {
+			return err
+		}
+		for _, v := range ex.Values {
+			if err := checkGrouped(v, groupKeys); err != nil {
+				return err
+			}
+		}
+	case *BetweenExpr:
+		for _, sub := range []Expr{ex.Expr, ex.Low, ex.High} {
+			if err := checkGrouped(sub, groupKeys); err != nil {
+				return err
+			}
+		}
+	case *FuncCallExpr:
+		for _, arg := range ex.Args {
+			if err := checkGrouped(arg, groupKeys); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// planAggregation decides whether a SELECT groups its rows and returns the
+// aggregates to compute per group. A query groups when it has GROUP BY or
+// HAVING or uses an aggregate in its select list or ORDER BY.
+func planAggregation(stmt *SelectStmt, exprs []Expr, orderKeys []orderKey) (bool, []*AggregateExpr, error) {
+	if stmt.Where != nil && containsAggregate(stmt.Where) {
+		return false, nil, fmt.Errorf("aggregate functions are not allowed in WHERE")
+	}
+	for _, expr := range stmt.GroupBy {
+		if containsAggregate(expr) {
+			return false, nil, fmt.Errorf("aggregate functions are not allowed in GROUP BY")
+		}
+	}
+
+	outputs := append([]Expr(nil), exprs...)
+	for _, key := range orderKeys {
+		outputs = append(outputs, key.expr)
+	}
+	if stmt.Having != nil {
+		outputs = append(outputs, stmt.Having)
+	}
+
+	found := make(map[string]*AggregateExpr)
+	for _, expr := range outputs {
+		collectAggregates(expr, found)
+	}
+	if len(stmt.GroupBy) == 0 && len(found) == 0 && stmt.Having == nil {
+		return false, nil, nil
+	}
+
+	groupKeys := make(map[string]bool, len(stmt.GroupBy))
+	for _, expr := range stmt.GroupBy {
+		groupKeys[exprString(expr)] = true
+	}
+	for _, expr := range outputs {
+		if err := checkGrouped(expr, groupKeys); err != nil {
+			return false, nil, err
+		}
+	}
+
+	aggs := make([]*AggregateExpr, 0, len(found))
+	for _, agg := range found {
+		aggs = append(aggs, agg)
+	}
+	return true, aggs, nil
+}
+
+// groupRows partitions rows by the GROUP BY expressions and returns one row
+// per group, in order of first appearance. Each group row is a copy of the
+// group's first row with the aggregate results added under their SQL text,
+// which is where evaluateExpr looks them up. Without GROUP BY every row
+// falls in a single group, which exists even when there are no rows.
+func (e *Executor) groupRows(groupBy []Expr, aggs []*AggregateExpr, rows []map[string]types.Value) []map[string]types.Value {
+	var order []string
+	groups := make(map[string][]map[string]types.Value)
+	if len(groupBy) == 0 {
+		order = append(order, "")
+		groups[""] = nil
+	}
+
+	keyValues := make([]types.Value, len(groupBy))
+	for _, rowData := range rows {
+		for i, expr := range groupBy {
+			keyValues[i] = e.exprCollation(expr).Key(e.evaluateExpr(expr, rowData))
+		}
+		key := groupKey(keyValues)
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], rowData)
+	}
+
+	result := make([]map[string]types.Value, 0, len(order))
+	for _, key := range order {
+		members := groups[key]
+		groupRow := make(map[string]types.Value)
+		if len(members) > 0 {
+			for name, val := range members[0] {
+				groupRow[name] = val
+			}
+		}
+		for _, agg := range aggs {
+			groupRow[exprString(agg)] = e.evaluateAggregate(agg, members)
+		}
+		result = append(result, groupRow)
+	}
+	return result
+}
+
+// evaluateAggregate computes an aggregate over the rows of one group. Apart
+// from COUNT(*), aggregates skip NULLs and yield NULL when nothing is left;
+// SUM and AVG also skip non-INT values, as arithmetic does, and fail like
+// it on overflow. AVG truncates toward zero, since INT is the only numeric
+// type.
+func (e *Executor) evaluateAggregate(agg *AggregateExpr, rows []map[string]types.Value) types.Value {
+	if agg.Name == "COUNT" && agg.Arg == nil {
+		return types.Value{Type: types.ValueTypeInt, IntVal: int64(len(rows))}
+	}
+
+	var n, sum int64
+	var best types.Value
+	coll := e.exprCollation(agg.Arg)
+	for _, rowData := range rows {
+		val := e.evaluateExpr(agg.Arg, rowData)
+		if val.IsNull {
+			continue
+		}
+		switch agg.Name {
+		case "COUNT":
+			n++
+		case "SUM", "AVG":
+			if val.Type == types.ValueTypeInt {
+				var ok bool
+				if sum, ok = addInt64(sum, val.IntVal); !ok {
+					return e.intOverflow()
+				}
+				n++
+			}
+		case "MIN":
+			if n == 0 || e.compareLess(coll.Key(val), coll.Key(best)) {
+				best = val
+			}
+			n++
+		case "MAX":
+			if n == 0 || e.compareLess(coll.Key(best), coll.Key(val)) {
+				best = val
+			}
+			n++
+		}
+	}
+
+	switch {
+	case agg.Name == "COUNT":
+		return types.Value{Type: types.ValueTypeInt, IntVal: n}
+	case n == 0:
+		return nullValue
+	case agg.Name == "SUM":
+		return types.Value{Type: types.ValueTypeInt, IntVal: sum}
+	case agg.Name == "AVG":
+		return types.Value{Type: types.ValueTypeInt, IntVal: sum / n}
+	default:
+		return best
+	}
+}
+
+// groupKey encodes grouping values as a map key. Values are tagged with their
+// type so that, e.g., 1 and '1' fall in different groups; NULLs group
+// together.
+func groupKey(values []types.Value) string {
+	var sb strings.Builder
+	for _, v := range values {
+		switch {
+		case v.IsNull:
+			sb.WriteString("N|")
+		case v.Type == types.ValueTypeInt:
+			sb.WriteString("I" + strconv.FormatInt(v.IntVal, 10) + "|")
+		case v.Type == types.ValueTypeString:
+			sb.WriteString("S" + strconv.Itoa(len(v.StrVal)) + ":" + v.StrVal + "|")
+		case v.Type == types.ValueTypeBool:
+			sb.WriteString("B" + strconv.FormatBool(v.BoolVal) + "|")
+		case v.Type == types.ValueTypeBytes:
+			sb.WriteString("X" + types.FormatBytes(v.BytesVal) + "|")
+		case v.Type == types.ValueTypeTimestamp:
+			sb.WriteString("T" + strconv.FormatInt(v.IntVal, 10) + "|")
+		}
+	}
+	return sb.String()
+}
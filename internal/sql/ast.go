@@ -0,0 +1,292 @@
+package sql
+
+import (
+	"fmt"
+	"minidb/pkg/types"
+	"sort"
+	"strings"
+)
+
+// ParseDebug parses sqlText and also returns the statement tree rendered by
+// ASTString. Nothing is executed.
+func ParseDebug(sqlText string) (Statement, string, error) {
+	stmt, err := NewParser(sqlText).Parse()
+	if err != nil {
+		return nil, "", err
+	}
+	return stmt, ASTString(stmt), nil
+}
+
+// ASTString renders a parsed statement as an indented tree, one node per
+// line, with two spaces of indentation per level. Nodes are labelled with
+// their Go type name followed by their scalar fields.
+func ASTString(stmt Statement) string {
+	w := &astWriter{}
+	w.statement(stmt, 0)
+	return strings.TrimSuffix(w.sb.String(), "\n")
+}
+
+// astWriter accumulates the lines of an AST dump.
+type astWriter struct {
+	sb strings.Builder
+}
+
+func (w *astWriter) line(depth int, format string, args ...interface{}) {
+	w.sb.WriteString(strings.Repeat("  ", depth))
+	fmt.Fprintf(&w.sb, format, args...)
+	w.sb.WriteByte('\n')
+}
+
+// exprs writes a labelled list of expressions, omitted when empty.
+func (w *astWriter) exprs(depth int, label string, exprs []Expr) {
+	if len(exprs) == 0 {
+		return
+	}
+	w.line(depth, "%s", label)
+	for _, expr := range exprs {
+		w.expr(expr, depth+1)
+	}
+}
+
+func (w *astWriter) statement(stmt Statement, depth int) {
+	switch s := stmt.(type) {
+	case *SelectStmt:
+		w.selectStmt(s, depth)
+	case *ExplainStmt:
+		w.line(depth, "ExplainStmt")
+		w.selectStmt(s.Select, depth+1)
+	case *ValuesStmt:
+		w.line(depth, "ValuesStmt")
+		for _, row := range s.Rows {
+			w.exprs(depth+1, "Row", row)
+		}
+	case *InsertStmt:
+		w.line(depth, "InsertStmt")
+		w.line(depth+1, "Table: %s", s.TableName)
+		if len(s.Columns) > 0 {
+			w.line(depth+1, "Columns: %s", strings.Join(s.Columns, ", "))
+		}
+		for _, values := range s.Values {
+			w.exprs(depth+1, "Values", values)
+		}
+	case *UpdateStmt:
+		w.line(depth, "UpdateStmt")
+		w.line(depth+1, "Table: %s", s.TableName)
+		names := make([]string, 0, len(s.Set))
+		for name := range s.Set {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w.line(depth+1, "Set")
+		for _, name := range names {
+			w.line(depth+2, "%s =", name)
+			w.expr(s.Set[name], depth+3)
+		}
+		w.where(s.Where, depth+1)
+	case *DeleteStmt:
+		w.line(depth, "DeleteStmt")
+		w.line(depth+1, "Table: %s", s.TableName)
+		w.where(s.Where, depth+1)
+	case *BeginStmt:
+		w.line(depth, "BeginStmt")
+		if s.Isolation != nil {
+			w.line(depth+1, "Isolation: %s", s.Isolation)
+		}
+	case *CommitStmt:
+		w.line(depth, "CommitStmt")
+	case *RollbackStmt:
+		w.line(depth, "RollbackStmt")
+	case *SavepointStmt:
+		w.line(depth, "SavepointStmt")
+		w.line(depth+1, "Name: %s", s.Name)
+	case *RollbackToStmt:
+		w.line(depth, "RollbackToStmt")
+		w.line(depth+1, "Name: %s", s.Name)
+	case *ReleaseStmt:
+		w.line(depth, "ReleaseStmt")
+		w.line(depth+1, "Name: %s", s.Name)
+	case *CreateTableStmt:
+		w.line(depth, "CreateTableStmt")
+		w.line(depth+1, "Table: %s", s.TableName)
+		for _, col := range s.Columns {
+			w.columnDef(col, depth+1)
+		}
+	case *TruncateStmt:
+		w.line(depth, "TruncateStmt")
+		w.line(depth+1, "Table: %s", s.TableName)
+		w.line(depth+1, "RestartIdentity: %t", s.RestartIdentity)
+	case *AnalyzeStmt:
+		w.line(depth, "AnalyzeStmt")
+		if s.TableName != "" {
+			w.line(depth+1, "Table: %s", s.TableName)
+		}
+	case *CommentStmt:
+		w.line(depth, "CommentStmt")
+		w.line(depth+1, "Table: %s", s.TableName)
+		if s.ColumnName != "" {
+			w.line(depth+1, "Column: %s", s.ColumnName)
+		}
+		w.line(depth+1, "Text: %q", s.Text)
+	case *DescribeStmt:
+		w.line(depth, "DescribeStmt")
+		w.line(depth+1, "Table: %s", s.TableName)
+	case *SetStmt:
+		w.line(depth, "SetStmt")
+		w.line(depth+1, "Name: %s", s.Name)
+		w.line(depth+1, "Value: %s", s.Value)
+	case *CopyStmt:
+		w.line(depth, "CopyStmt")
+		w.line(depth+1, "Table: %s", s.TableName)
+		if s.To {
+			w.line(depth+1, "To: %q", s.Path)
+		} else {
+			w.line(depth+1, "From: %q", s.Path)
+		}
+		w.line(depth+1, "Header: %t", s.Header)
+	case *ShowStmt:
+		w.line(depth, "ShowStmt")
+		w.line(depth+1, "Name: %s", s.Name)
+	default:
+		w.line(depth, "%T", stmt)
+	}
+}
+
+func (w *astWriter) selectStmt(s *SelectStmt, depth int) {
+	w.line(depth, "SelectStmt")
+	if s.Hint != nil {
+		w.line(depth+1, "Hint: %s", s.Hint)
+	}
+	if len(s.Exprs) == 0 {
+		w.line(depth+1, "Columns: *")
+	} else {
+		w.exprs(depth+1, "Columns", s.Exprs)
+	}
+	if aliases := strings.Join(s.Aliases, ""); aliases != "" {
+		w.line(depth+1, "Aliases: %s", strings.Join(s.Aliases, ", "))
+	}
+	if s.Into != "" {
+		w.line(depth+1, "Into: %s", s.Into)
+	}
+	if s.TableName != "" {
+		w.line(depth+1, "From: %s", s.TableName)
+	}
+	w.where(s.Where, depth+1)
+	w.exprs(depth+1, "GroupBy", s.GroupBy)
+	if s.Having != nil {
+		w.line(depth+1, "Having")
+		w.expr(s.Having, depth+2)
+	}
+	if len(s.OrderBy) > 0 {
+		w.line(depth+1, "OrderBy")
+		for _, item := range s.OrderBy {
+			dir := "ASC"
+			if item.Desc {
+				dir = "DESC"
+			}
+			w.line(depth+2, "OrderByItem %s", dir)
+			w.expr(item.Expr, depth+3)
+		}
+	}
+	if s.Limit != nil {
+		w.line(depth+1, "Limit: %d", *s.Limit)
+	}
+	if s.Offset > 0 {
+		w.line(depth+1, "Offset: %d", s.Offset)
+	}
+	if s.ForUpdate {
+		w.line(depth+1, "ForUpdate")
+	}
+}
+
+func (w *astWriter) where(expr Expr, depth int) {
+	if expr == nil {
+		return
+	}
+	w.line(depth, "Where")
+	w.expr(expr, depth+1)
+}
+
+func (w *astWriter) columnDef(col ColumnDef, depth int) {
+	typeName := valueTypeName(col.Type)
+	if col.Serial {
+		typeName = "SERIAL"
+	}
+	var attrs []string
+	if !col.Nullable {
+		attrs = append(attrs, "NOT NULL")
+	}
+	if col.Default != nil {
+		attrs = append(attrs, "DEFAULT "+exprString(col.Default))
+	}
+	if col.Unique {
+		attrs = append(attrs, "UNIQUE")
+	}
+	if col.PrimaryKey {
+		attrs = append(attrs, "PRIMARY KEY")
+	}
+	if col.Collation != types.CollationBinary {
+		attrs = append(attrs, "COLLATE "+col.Collation.String())
+	}
+	w.line(depth, "ColumnDef %s", strings.Join(append([]string{col.Name, typeName}, attrs...), " "))
+}
+
+func (w *astWriter) expr(expr Expr, depth int) {
+	switch ex := expr.(type) {
+	case nil:
+		w.line(depth, "<nil>")
+	case *LiteralExpr:
+		w.line(depth, "LiteralExpr %s", exprString(ex))
+	case *ColumnExpr:
+		w.line(depth, "ColumnExpr %s", ex.Name)
+	case *BinaryExpr:
+		w.line(depth, "BinaryExpr %s", ex.Op)
+		w.expr(ex.Left, depth+1)
+		w.expr(ex.Right, depth+1)
+	case *UnaryExpr:
+		w.line(depth, "UnaryExpr %s", ex.Op)
+		w.expr(ex.Operand, depth+1)
+	case *CollateExpr:
+		w.line(depth, "CollateExpr %s", ex.Collation)
+		w.expr(ex.Expr, depth+1)
+	case *IsNullExpr:
+		if ex.Not {
+			w.line(depth, "IsNullExpr NOT")
+		} else {
+			w.line(depth, "IsNullExpr")
+		}
+		w.expr(ex.Expr, depth+1)
+	case *InExpr:
+		if ex.Not {
+			w.line(depth, "InExpr NOT")
+		} else {
+			w.line(depth, "InExpr")
+		}
+		w.expr(ex.Expr, depth+1)
+		for _, v := range ex.Values {
+			w.expr(v, depth+1)
+		}
+	case *BetweenExpr:
+		if ex.Not {
+			w.line(depth, "BetweenExpr NOT")
+		} else {
+			w.line(depth, "BetweenExpr")
+		}
+		w.expr(ex.Expr, depth+1)
+		w.expr(ex.Low, depth+1)
+		w.expr(ex.High, depth+1)
+	case *FuncCallExpr:
+		w.line(depth, "FuncCallExpr %s", ex.Name)
+		for _, arg := range ex.Args {
+			w.expr(arg, depth+1)
+		}
+	case *AggregateExpr:
+		if ex.Arg == nil {
+			w.line(depth, "AggregateExpr %s(*)", ex.Name)
+			return
+		}
+		w.line(depth, "AggregateExpr %s", ex.Name)
+		w.expr(ex.Arg, depth+1)
+	default:
+		w.line(depth, "%T", expr)
+	}
+}
@@ -0,0 +1,178 @@
+package sql
+
+import (
+	"container/list"
+	"fmt"
+	"minidb/internal/txn"
+	"minidb/pkg/types"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// QueryCache is an LRU cache of SELECT results keyed by normalized SQL text
+// and snapshot fingerprint. Entries are dropped when a transaction that wrote
+// to one of the tables they read from commits.
+type QueryCache struct {
+	mu        sync.Mutex
+	capacity  int
+	lruList   *list.List
+	entries   map[string]*list.Element
+	commitSeq func() uint64 // the number of commits so far
+
+	// Statistics
+	hits   uint64
+	misses uint64
+}
+
+// queryCacheEntry is a single cached result.
+type queryCacheEntry struct {
+	key     string
+	tableID uint32
+	result  *Result
+}
+
+// NewQueryCache creates a query cache holding up to capacity results.
+// commitSeq reports how many transactions have committed; it is bumped
+// before the commit invalidates the tables it wrote.
+func NewQueryCache(capacity int, commitSeq func() uint64) *QueryCache {
+	return &QueryCache{
+		capacity:  capacity,
+		lruList:   list.New(),
+		entries:   make(map[string]*list.Element),
+		commitSeq: commitSeq,
+	}
+}
+
+// Get returns a copy of the cached result for key, if present.
+func (c *QueryCache) Get(key string) (*Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lruList.MoveToFront(e)
+	return e.Value.(*queryCacheEntry).result.clone(), true
+}
+
+// Put stores a copy of result under key, evicting the least recently used
+// entry if the cache is full. seq is the commit sequence the result was
+// read at; if a transaction has committed since, its invalidation may
+// already have run, so the result is dropped instead.
+func (c *QueryCache) Put(key string, tableID uint32, seq uint64, result *Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.commitSeq() != seq {
+		return
+	}
+
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*queryCacheEntry).result = result.clone()
+		c.lruList.MoveToFront(e)
+		return
+	}
+
+	if c.lruList.Len() >= c.capacity {
+		if back := c.lruList.Back(); back != nil {
+			c.removeLocked(back)
+		}
+	}
+
+	entry := &queryCacheEntry{key: key, tableID: tableID, result: result.clone()}
+	c.entries[key] = c.lruList.PushFront(entry)
+}
+
+// InvalidateTables drops every entry that reads from one of the given tables.
+func (c *QueryCache) InvalidateTables(tableIDs []uint32) {
+	if len(tableIDs) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	touched := make(map[uint32]bool, len(tableIDs))
+	for _, id := range tableIDs {
+		touched[id] = true
+	}
+	for e := c.lruList.Front(); e != nil; {
+		next := e.Next()
+		if touched[e.Value.(*queryCacheEntry).tableID] {
+			c.removeLocked(e)
+		}
+		e = next
+	}
+}
+
+// removeLocked removes an entry. Must be called with lock held.
+func (c *QueryCache) removeLocked(e *list.Element) {
+	delete(c.entries, e.Value.(*queryCacheEntry).key)
+	c.lruList.Remove(e)
+}
+
+// Stats returns cache statistics.
+func (c *QueryCache) Stats() (hits, misses uint64, cached int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.lruList.Len()
+}
+
+// queryCacheKey builds the cache key for a SELECT run under snap.
+// Transactions that were active when the snapshot was taken are part of the
+// key, since their rows become visible (or vanish) once they finish.
+func queryCacheKey(sqlText string, snap *txn.Snapshot, self types.TxnID) string {
+	active := make([]types.TxnID, 0, len(snap.ActiveTxns))
+	for id := range snap.ActiveTxns {
+		if id != self {
+			active = append(active, id)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i] < active[j] })
+
+	var sb strings.Builder
+	sb.WriteString(normalizeSQL(sqlText))
+	sb.WriteString(" @")
+	for _, id := range active {
+		fmt.Fprintf(&sb, " %d", id)
+	}
+	return sb.String()
+}
+
+// normalizeSQL re-renders a statement from its tokens so that differences in
+// whitespace and keyword case map to the same cache key.
+func normalizeSQL(sqlText string) string {
+	var parts []string
+	for _, tok := range Tokenize(sqlText) {
+		switch tok.Type {
+		case TokenEOF, TokenSemicolon:
+			continue
+		case TokenString:
+			parts = append(parts, "'"+tok.Literal+"'")
+		case TokenHint:
+			parts = append(parts, "/*+ "+tok.Literal+" */")
+		default:
+			parts = append(parts, tok.Literal)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// clone returns a copy of the result that shares no row storage.
+func (r *Result) clone() *Result {
+	c := &Result{
+		Columns: append([]string(nil), r.Columns...),
+		Message: r.Message,
+		Error:   r.Error,
+	}
+	if r.Rows != nil {
+		c.Rows = make([]types.Row, len(r.Rows))
+		for i, row := range r.Rows {
+			c.Rows[i] = types.Row{Values: append([]types.Value(nil), row.Values...)}
+		}
+	}
+	return c
+}
@@ -0,0 +1,247 @@
+package sql
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"minidb/internal/storage"
+	"minidb/pkg/types"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// executeCopy runs COPY TO or COPY FROM against a CSV file. An empty
+// field stands for NULL in both directions.
+func (e *Executor) executeCopy(stmt *CopyStmt) *Result {
+	if e.catalog == nil {
+		return &Result{Error: fmt.Errorf("storage not initialized")}
+	}
+	schema := e.catalog.GetSchema(stmt.TableName)
+	if schema == nil {
+		return &Result{Error: fmt.Errorf("table %s does not exist", stmt.TableName)}
+	}
+	if stmt.To {
+		return e.copyTo(stmt)
+	}
+	return e.copyFrom(stmt, schema)
+}
+
+// copyTo writes the rows visible to the current snapshot to a CSV file.
+func (e *Executor) copyTo(stmt *CopyStmt) *Result {
+	rows := e.executeSelect(&SelectStmt{TableName: stmt.TableName, Columns: []string{"*"}}, "")
+	if rows.Error != nil {
+		return rows
+	}
+
+	f, err := os.Create(stmt.Path)
+	if err != nil {
+		return &Result{Error: fmt.Errorf("COPY TO: %w", err)}
+	}
+	if err := rows.writeCSV(f, stmt.Header); err != nil {
+		f.Close()
+		return &Result{Error: fmt.Errorf("COPY TO: %w", err)}
+	}
+	if err := f.Close(); err != nil {
+		return &Result{Error: fmt.Errorf("COPY TO: %w", err)}
+	}
+	return &Result{Message: fmt.Sprintf("COPY %d", len(rows.Rows))}
+}
+
+// csvHeader says what to do with the first line of a CSV file being loaded.
+type csvHeader int
+
+const (
+	csvNoHeader    csvHeader = iota // every line is a row
+	csvSkipHeader                   // the first line is ignored
+	csvMatchHeader                  // the first line must name the columns in order
+)
+
+// copyFrom inserts every line of a CSV file, in schema column order.
+func (e *Executor) copyFrom(stmt *CopyStmt, schema *types.Schema) *Result {
+	f, err := os.Open(stmt.Path)
+	if err != nil {
+		return &Result{Error: fmt.Errorf("COPY FROM: %w", err)}
+	}
+	defer f.Close()
+
+	header := csvNoHeader
+	if stmt.Header {
+		header = csvSkipHeader
+	}
+	n, err := e.loadCSV(f, stmt.TableName, schema, header)
+	if err != nil {
+		return &Result{Error: fmt.Errorf("COPY FROM: %w", err)}
+	}
+	return &Result{Message: fmt.Sprintf("COPY %d", n), RowsAffected: n}
+}
+
+// ImportCSV inserts the rows of a CSV file into a table and returns how
+// many it inserted. The first line must name the table's columns in
+// order; fields are converted as in COPY FROM. The rows commit together,
+// or join the open transaction if there is one; a failed import inserts
+// nothing either way.
+func (e *Executor) ImportCSV(tableName, path string) (int, error) {
+	if e.catalog == nil {
+		return 0, fmt.Errorf("storage not initialized")
+	}
+	if e.readOnly {
+		return 0, storage.ErrReadOnly
+	}
+	schema := e.catalog.GetSchema(tableName)
+	if schema == nil {
+		return 0, fmt.Errorf("table %s does not exist", tableName)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("import %s: %w", tableName, err)
+	}
+	defer f.Close()
+
+	n, err := e.loadCSV(f, tableName, schema, csvMatchHeader)
+	if err != nil {
+		return 0, fmt.Errorf("import %s: %w", tableName, err)
+	}
+	return n, nil
+}
+
+// loadCSV inserts every line of CSV input, in schema column order. The
+// whole input is parsed and checked before the first row goes in; the rows
+// then go in through INSERT, so constraints and indexes apply, and commit
+// together unless an explicit transaction is open. A row that fails undoes
+// the ones before it in either case.
+func (e *Executor) loadCSV(in io.Reader, tableName string, schema *types.Schema, header csvHeader) (int, error) {
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = len(schema.Columns)
+	if header != csvNoHeader {
+		names, err := r.Read()
+		if err != nil && err != io.EOF {
+			return 0, fmt.Errorf("header: %w", err)
+		}
+		if header == csvMatchHeader {
+			if err := checkCSVHeader(names, schema); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	var inserts []*InsertStmt
+	var lines []int
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		line, _ := r.FieldPos(0)
+
+		var values []Expr
+		for i, col := range schema.Columns {
+			val, err := parseCSVField(record[i], col.Type)
+			if err != nil {
+				return 0, fmt.Errorf("line %d, column %s: %w", line, col.Name, err)
+			}
+			if val.IsNull && !col.Nullable && !col.Serial {
+				return 0, fmt.Errorf("line %d: column %s: NULL value violates NOT NULL constraint", line, col.Name)
+			}
+			values = append(values, &LiteralExpr{Value: val})
+		}
+		inserts = append(inserts, &InsertStmt{TableName: tableName, Values: [][]Expr{values}})
+		lines = append(lines, line)
+	}
+
+	if i, err := e.insertAll(inserts); err != nil {
+		if i == len(inserts) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("line %d: %w", lines[i], err)
+	}
+	return len(inserts), nil
+}
+
+// checkCSVHeader checks that a header line names the schema's columns in
+// order, ignoring case. Empty input has no header and fails the check.
+func checkCSVHeader(names []string, schema *types.Schema) error {
+	want := make([]string, len(schema.Columns))
+	for i, col := range schema.Columns {
+		want[i] = col.Name
+	}
+	match := len(names) == len(want)
+	for i := 0; match && i < len(names); i++ {
+		match = strings.EqualFold(strings.TrimSpace(names[i]), want[i])
+	}
+	if !match {
+		return fmt.Errorf("header (%s) does not match columns (%s)", strings.Join(names, ", "), strings.Join(want, ", "))
+	}
+	return nil
+}
+
+// ToCSV writes the result as CSV: a header row of Columns, then one
+// record per row. Fields containing commas, quotes or newlines are quoted,
+// and NULL is written as an empty field, as in COPY TO.
+func (r *Result) ToCSV(w io.Writer) error {
+	return r.writeCSV(w, true)
+}
+
+func (r *Result) writeCSV(w io.Writer, header bool) error {
+	cw := csv.NewWriter(w)
+	if header {
+		cw.Write(r.Columns)
+	}
+	record := make([]string, len(r.Columns))
+	for _, row := range r.Rows {
+		for i, val := range row.Values {
+			record[i] = csvField(val)
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvField renders a value as a CSV field.
+func csvField(val types.Value) string {
+	if val.IsNull {
+		return ""
+	}
+	return val.String()
+}
+
+// parseCSVField converts a CSV field to a value of the column's type.
+func parseCSVField(field string, typ types.ValueType) (types.Value, error) {
+	if field == "" {
+		return nullValue, nil
+	}
+	switch typ {
+	case types.ValueTypeInt:
+		n, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nullValue, fmt.Errorf("invalid INT %q", field)
+		}
+		return types.Value{Type: types.ValueTypeInt, IntVal: n}, nil
+	case types.ValueTypeBool:
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "true", "t":
+			return boolValue(true), nil
+		case "false", "f":
+			return boolValue(false), nil
+		}
+		return nullValue, fmt.Errorf("invalid BOOL %q", field)
+	case types.ValueTypeBytes:
+		b, err := types.ParseBytes(strings.TrimSpace(field))
+		if err != nil {
+			return nullValue, fmt.Errorf("invalid BLOB %q", field)
+		}
+		return types.Value{Type: types.ValueTypeBytes, BytesVal: b}, nil
+	case types.ValueTypeTimestamp:
+		sec, err := types.ParseTimestamp(strings.TrimSpace(field))
+		if err != nil {
+			return nullValue, err
+		}
+		return types.Value{Type: types.ValueTypeTimestamp, IntVal: sec}, nil
+	default:
+		return types.Value{Type: types.ValueTypeString, StrVal: field}, nil
+	}
+}
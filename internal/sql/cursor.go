@@ -0,0 +1,128 @@
+package sql
+
+import (
+	"fmt"
+	"minidb/internal/txn"
+	"minidb/pkg/types"
+)
+
+// Cursor returns the rows of a SELECT one at a time, as the caller asks for
+// them. A sequential scan reads the table as the cursor advances, holding
+// one page pinned, so that a result of any size takes bounded memory.
+// Index scans, ORDER BY and GROUP BY still gather their input when the
+// first row is asked for.
+//
+// The cursor runs in the executor's transaction, or in one of its own that
+// Close commits. Rows the transaction writes while the cursor is open may
+// or may not be returned.
+type Cursor struct {
+	e          *Executor
+	op         operator
+	txn        *txn.Transaction
+	autoCommit bool
+	columns    []string
+	collations map[string]types.Collation // The statement's, restored for each Next
+	closed     bool
+}
+
+// Query starts a SELECT and returns a cursor over its rows. The caller must
+// Close it. SELECT ... INTO and FOR UPDATE are not supported; run them with
+// Execute.
+func (e *Executor) Query(sqlStr string) (*Cursor, error) {
+	e.funcErr = nil
+	e.collations = nil
+	parser := NewParser(sqlStr)
+	parser.funcs = e.funcs
+	stmt, err := parser.Parse()
+	if err != nil {
+		return nil, err
+	}
+	e.resolveTempTables(stmt)
+
+	sel, ok := stmt.(*SelectStmt)
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("a cursor can only run SELECT; use Execute for other statements")
+	case sel.Into != "":
+		return nil, fmt.Errorf("a cursor cannot run SELECT ... INTO; use Execute")
+	case sel.ForUpdate:
+		return nil, fmt.Errorf("a cursor cannot run SELECT ... FOR UPDATE; use Execute")
+	}
+
+	c := &Cursor{e: e}
+	var sp *selectPlan
+	if sel.TableName == "" {
+		sp = &selectPlan{columns: sel.Columns, exprs: sel.Exprs}
+		if sp.orderKeys, err = resolveOrderBy(sel.OrderBy, sel.Exprs, sel.Aliases); err != nil {
+			return nil, err
+		}
+		if sp.grouped, sp.aggs, err = planAggregation(sel, sel.Exprs, sp.orderKeys); err != nil {
+			return nil, err
+		}
+	} else {
+		if sp, err = e.planSelect(sel); err != nil {
+			return nil, err
+		}
+		c.txn, c.autoCommit = e.getTransaction()
+	}
+	c.columns, c.collations = sp.columns, e.collations
+
+	c.op = e.selectOperator(sel, sp.exprs, sp.orderKeys, sp.grouped, sp.aggs, sp.scan, c.txn)
+	if err := c.op.Open(); err != nil {
+		c.op.Close()
+		c.finish()
+		return nil, err
+	}
+	if e.cursors == nil {
+		e.cursors = make(map[*Cursor]struct{})
+	}
+	e.cursors[c] = struct{}{}
+	return c, nil
+}
+
+// Columns returns the names of the cursor's output columns.
+func (c *Cursor) Columns() []string {
+	return c.columns
+}
+
+// Next returns the next row, or nil once there are no more. An error from
+// evaluating the row, such as a registered function failing, is returned
+// with a nil row; the cursor should then be closed.
+func (c *Cursor) Next() (*types.Row, error) {
+	if c.closed {
+		return nil, fmt.Errorf("cursor is closed")
+	}
+	e := c.e
+	saved := e.collations
+	e.collations, e.funcErr = c.collations, nil
+	defer func() { e.collations = saved }()
+
+	row, err := c.op.Next()
+	if err == nil {
+		err = e.takeFuncErr()
+	}
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return &row.out, nil
+}
+
+// Close releases the pages the cursor holds and commits its transaction if
+// it has one of its own. Closing a closed cursor does nothing.
+func (c *Cursor) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.op.Close()
+	delete(c.e.cursors, c)
+	return c.finish()
+}
+
+// finish marks the cursor closed and ends its own transaction.
+func (c *Cursor) finish() error {
+	c.closed = true
+	if c.autoCommit {
+		return c.e.txnManager.Commit(c.txn)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package sql
+
+// deferDDL reports whether a catalog change joins a transaction instead of
+// taking effect at once, as it does inside BEGIN or with autocommit off. It
+// then opens the transaction if need be and holds back catalog writes until
+// the transaction ends, so that a crash or ROLLBACK leaves the schema as it
+// was. The caller records how to revert the change with undoOnRollback.
+func (e *Executor) deferDDL() bool {
+	if e.currentTxn == nil && e.session.Autocommit {
+		return false
+	}
+	e.getTransaction()
+	e.catalog.DeferWrites()
+	return true
+}
+
+// undoOnRollback records how to revert a catalog change made in the current
+// transaction.
+func (e *Executor) undoOnRollback(undo func()) {
+	e.ddlUndo = append(e.ddlUndo, undo)
+}
+
+// endDDL ends the current transaction's catalog changes. Unless it
+// committed they are reverted, newest first. The catalog page is then
+// written, which also saves changes that are not transactional, such as
+// SERIAL sequences advanced in the meantime.
+func (e *Executor) endDDL(committed bool) error {
+	if e.catalog == nil {
+		return nil
+	}
+	if !committed {
+		for i := len(e.ddlUndo) - 1; i >= 0; i-- {
+			e.ddlUndo[i]()
+		}
+	}
+	e.ddlUndo = nil
+	return e.catalog.WritePending()
+}
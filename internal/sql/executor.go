@@ -1,12 +1,17 @@
 package sql
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 	"minidb/internal/index"
 	"minidb/internal/storage"
 	"minidb/internal/txn"
 	"minidb/internal/wal"
 	"minidb/pkg/types"
+	"sort"
+	"strings"
+	"sync"
 )
 
 // Executor executes SQL statements.
@@ -19,10 +24,39 @@ type Executor struct {
 	bufferPool *storage.BufferPool
 
 	// Indexes
-	indexes map[uint32]*index.BTree // tableID -> index
+	indexes map[uint32]map[string]*index.BTree // tableID -> column -> index
+
+	// Optional SELECT result cache
+	queryCache *QueryCache
+	// Planner statistics shared by the sessions, if enabled
+	stats *Statistics
+
+	// Settings changed with SET
+	session Session
+	// Reject statements that write (SetReadOnly)
+	readOnly bool
+	// Held by the caller around each statement, if set; released while
+	// waiting for a row lock (SetStatementLock)
+	stmtLock sync.Locker
 
 	// Current transaction (for REPL mode)
 	currentTxn *txn.Transaction
+	// Temp tables created in the current transaction: name -> catalog name
+	tempTables map[string]string
+	// Reverts the catalog changes made in the current transaction, in the
+	// order they were made
+	ddlUndo []func()
+
+	// Functions registered with RegisterFunction, by upper-cased name
+	funcs map[string]Func
+	// First error raised while evaluating the current statement's
+	// expressions: a registered function failing, or integer overflow
+	funcErr error
+	// Collations of the current statement's table, by column name; columns
+	// with the default BINARY collation are absent
+	collations map[string]types.Collation
+	// Cursors returned by Query and not yet closed
+	cursors map[*Cursor]struct{}
 }
 
 // Result represents the result of a query.
@@ -31,6 +65,13 @@ type Result struct {
 	Rows    []types.Row
 	Message string
 	Error   error
+
+	// RowsAffected is the number of rows written by INSERT, COPY FROM and
+	// SELECT INTO, matched by UPDATE or deleted by DELETE
+	RowsAffected int
+	// LastInsert is where an INSERT put its last row; nil for other
+	// statements
+	LastInsert *index.RID
 }
 
 // NewExecutor creates a new SQL executor.
@@ -38,6 +79,7 @@ func NewExecutor(txnManager *txn.Manager, walWriter *wal.Writer) *Executor {
 	return &Executor{
 		txnManager: txnManager,
 		walWriter:  walWriter,
+		session:    DefaultSession(),
 	}
 }
 
@@ -48,45 +90,181 @@ func (e *Executor) SetStorage(catalog *storage.Catalog, bufferPool *storage.Buff
 }
 
 // SetIndexes sets the index references from the engine.
-func (e *Executor) SetIndexes(indexes map[uint32]*index.BTree) {
+func (e *Executor) SetIndexes(indexes map[uint32]map[string]*index.BTree) {
 	e.indexes = indexes
 }
 
+// SetQueryCache enables caching of SELECT results.
+func (e *Executor) SetQueryCache(cache *QueryCache) {
+	e.queryCache = cache
+}
+
+// SetStatistics enables ANALYZE and counting changed rows in stats.
+func (e *Executor) SetStatistics(stats *Statistics) {
+	e.stats = stats
+}
+
+// SetRequireWhere controls whether UPDATE and DELETE must have a WHERE clause.
+func (e *Executor) SetRequireWhere(require bool) {
+	e.session.RequireWhere = require
+}
+
+// SetReadOnly makes statements that would change the database fail with
+// storage.ErrReadOnly. Queries, transactions and session settings still
+// work.
+func (e *Executor) SetReadOnly(readOnly bool) {
+	e.readOnly = readOnly
+}
+
+// SetStatementLock tells the executor that its caller holds l while a
+// statement runs, as the engine does to run one statement at a time. A
+// statement waiting for a row lock unlocks l for the wait, since the
+// transaction it waits for needs l to commit.
+func (e *Executor) SetStatementLock(l sync.Locker) {
+	e.stmtLock = l
+}
+
+// RegisterFunction makes a Go function callable from SQL under name, which
+// is matched case-insensitively. Built-in functions and aggregates cannot be
+// replaced. The function need not depend only on its arguments, e.g. it may
+// read the clock: a SELECT that calls a registered function bypasses the
+// query cache.
+func (e *Executor) RegisterFunction(name string, fn Func) error {
+	if fn == nil {
+		return fmt.Errorf("function %s: nil implementation", name)
+	}
+	// The name must lex as a plain identifier to be callable
+	if tokens := Tokenize(name); len(tokens) != 2 || tokens[0].Type != TokenIdent {
+		return fmt.Errorf("invalid function name %q", name)
+	}
+	upper := strings.ToUpper(name)
+	if _, ok := builtinFuncs[upper]; ok || aggregateFuncs[upper] {
+		return fmt.Errorf("function %s is built in", upper)
+	}
+	if _, ok := e.funcs[upper]; ok {
+		return fmt.Errorf("function %s already registered", upper)
+	}
+	if e.funcs == nil {
+		e.funcs = make(map[string]Func)
+	}
+	e.funcs[upper] = fn
+	return nil
+}
+
+// takeFuncErr returns the first evaluation error (see funcErr) since the
+// last call, and clears it.
+func (e *Executor) takeFuncErr() error {
+	err := e.funcErr
+	e.funcErr = nil
+	return err
+}
+
+// Session returns the executor's session settings.
+func (e *Executor) Session() Session {
+	return e.session
+}
+
 // Execute executes a SQL statement.
 func (e *Executor) Execute(sqlStr string) *Result {
+	e.funcErr = nil
+	e.collations = nil
 	parser := NewParser(sqlStr)
+	parser.funcs = e.funcs
 	stmt, err := parser.Parse()
 	if err != nil {
 		return &Result{Error: err}
 	}
+	e.resolveTempTables(stmt)
+	if e.readOnly && writes(stmt) {
+		return &Result{Error: storage.ErrReadOnly}
+	}
 
 	switch s := stmt.(type) {
 	case *BeginStmt:
-		return e.executeBegin()
+		return e.executeBegin(s)
 	case *CommitStmt:
 		return e.executeCommit()
 	case *RollbackStmt:
 		return e.executeRollback()
+	case *SavepointStmt:
+		return e.executeSavepoint(s)
+	case *RollbackToStmt:
+		return e.executeRollbackTo(s)
+	case *ReleaseStmt:
+		return e.executeRelease(s)
 	case *CreateTableStmt:
 		return e.executeCreateTable(s)
 	case *InsertStmt:
 		return e.executeInsert(s)
 	case *SelectStmt:
-		return e.executeSelect(s)
+		if s.Into != "" {
+			return e.executeSelectInto(s)
+		}
+		if parser.callsFuncs {
+			// A registered function may return something else next time
+			return e.executeSelect(s, "")
+		}
+		return e.executeSelect(s, sqlStr)
+	case *ValuesStmt:
+		return e.executeValues(s)
+	case *ExplainStmt:
+		return e.executeExplain(s)
 	case *UpdateStmt:
+		if e.session.RequireWhere && s.Where == nil {
+			return &Result{Error: errMissingWhere("UPDATE", s.TableName)}
+		}
 		return e.executeUpdate(s)
 	case *DeleteStmt:
+		if e.session.RequireWhere && s.Where == nil {
+			return &Result{Error: errMissingWhere("DELETE", s.TableName)}
+		}
 		return e.executeDelete(s)
+	case *TruncateStmt:
+		return e.executeTruncate(s)
+	case *CommentStmt:
+		return e.executeComment(s)
+	case *DescribeStmt:
+		return e.executeDescribe(s)
+	case *SetStmt:
+		return e.executeSet(s)
+	case *ShowStmt:
+		return e.executeShow(s)
+	case *CopyStmt:
+		return e.executeCopy(s)
+	case *AnalyzeStmt:
+		return e.executeAnalyze(s)
 	default:
 		return &Result{Error: fmt.Errorf("unknown statement type")}
 	}
 }
 
-func (e *Executor) executeBegin() *Result {
+// writes reports whether a statement changes tables or the catalog.
+func writes(stmt Statement) bool {
+	switch s := stmt.(type) {
+	case *CreateTableStmt, *InsertStmt, *UpdateStmt, *DeleteStmt, *TruncateStmt, *CommentStmt:
+		return true
+	case *SelectStmt:
+		return s.Into != ""
+	case *CopyStmt:
+		return !s.To
+	}
+	return false
+}
+
+// errMissingWhere reports a WHERE-less UPDATE or DELETE rejected by the
+// RequireWhere setting.
+func errMissingWhere(verb, tableName string) error {
+	return fmt.Errorf("%s on %s without WHERE is not allowed; add WHERE TRUE to affect every row", verb, tableName)
+}
+
+func (e *Executor) executeBegin(stmt *BeginStmt) *Result {
 	if e.currentTxn != nil {
 		return &Result{Error: fmt.Errorf("transaction already in progress")}
 	}
-	e.currentTxn = e.txnManager.Begin()
+	e.currentTxn = e.beginTxn()
+	if stmt.Isolation != nil {
+		e.currentTxn.Isolation = *stmt.Isolation
+	}
 	return &Result{Message: fmt.Sprintf("BEGIN (txn %d)", e.currentTxn.ID)}
 }
 
@@ -101,10 +279,15 @@ func (e *Executor) executeCommit() *Result {
 		return &Result{Error: err}
 	}
 
+	e.dropTempTables()
+	err := e.endDDL(true)
 	if e.bufferPool != nil {
 		e.bufferPool.FlushAllPages()
 	}
 	e.currentTxn = nil
+	if err != nil {
+		return &Result{Error: fmt.Errorf("COMMIT (txn %d): write catalog: %w", txnID, err)}
+	}
 	return &Result{Message: fmt.Sprintf("COMMIT (txn %d)", txnID)}
 }
 
@@ -114,10 +297,15 @@ func (e *Executor) executeRollback() *Result {
 	}
 	txnID := e.currentTxn.ID
 
-	if err := e.txnManager.Rollback(e.currentTxn); err != nil {
+	if err := e.abortTxn(e.currentTxn); err != nil {
 		return &Result{Error: err}
 	}
+	e.dropTempTables()
+	err := e.endDDL(false)
 	e.currentTxn = nil
+	if err != nil {
+		return &Result{Error: fmt.Errorf("ROLLBACK (txn %d): write catalog: %w", txnID, err)}
+	}
 	return &Result{Message: fmt.Sprintf("ROLLBACK (txn %d)", txnID)}
 }
 
@@ -131,27 +319,162 @@ func (e *Executor) executeCreateTable(stmt *CreateTableStmt) *Result {
 		Columns:   make([]types.Column, len(stmt.Columns)),
 	}
 
+	serialCols, primaryKeys := 0, 0
 	for i, col := range stmt.Columns {
 		schema.Columns[i] = types.Column{
-			Name:     col.Name,
-			Type:     col.Type,
-			Nullable: col.Nullable,
+			Name:       col.Name,
+			Type:       col.Type,
+			Nullable:   col.Nullable,
+			Serial:     col.Serial,
+			Unique:     col.Unique,
+			PrimaryKey: col.PrimaryKey,
+			Collation:  col.Collation,
+		}
+		if col.Serial {
+			serialCols++
+		}
+		if col.PrimaryKey {
+			primaryKeys++
+		}
+
+		if col.Default != nil {
+			def, err := e.evaluateDefault(col)
+			if err != nil {
+				return &Result{Error: err}
+			}
+			schema.Columns[i].Default = def
 		}
 	}
+	if serialCols > 1 {
+		return &Result{Error: fmt.Errorf("table %s has more than one SERIAL column", stmt.TableName)}
+	}
+	if primaryKeys > 1 {
+		return &Result{Error: fmt.Errorf("table %s has more than one PRIMARY KEY column", stmt.TableName)}
+	}
+	if stmt.Temp {
+		return e.createTempTable(schema)
+	}
 
+	inTxn := e.deferDDL()
 	tableID, err := e.catalog.CreateTable(schema)
 	if err != nil {
 		return &Result{Error: err}
 	}
+	if inTxn {
+		// The table's pages are left for Repair to reclaim
+		e.undoOnRollback(func() {
+			delete(e.indexes, tableID)
+			e.catalog.DropTable(schema.TableName)
+		})
+	}
+	if err := e.createKeyIndex(tableID, schema); err != nil {
+		return &Result{Error: err}
+	}
 
 	// Flush catalog page
-	if e.bufferPool != nil {
+	if e.bufferPool != nil && !inTxn {
 		e.bufferPool.FlushAllPages()
 	}
 
 	return &Result{Message: fmt.Sprintf("CREATE TABLE %s (id=%d)", stmt.TableName, tableID)}
 }
 
+// createKeyIndex gives a new table an index on each PRIMARY KEY and UNIQUE
+// column, so that checkUnique can probe them. BLOB columns are not indexed
+// and their uniqueness is checked by a scan.
+func (e *Executor) createKeyIndex(tableID uint32, schema *types.Schema) error {
+	if e.indexes == nil || e.bufferPool == nil {
+		return nil
+	}
+	for _, col := range schema.Columns {
+		if (!col.Unique && !col.PrimaryKey) || col.Type == types.ValueTypeBytes {
+			continue
+		}
+		bt, err := index.NewBTree(e.bufferPool, 64)
+		if err != nil {
+			return fmt.Errorf("create index on %s(%s): %w", schema.TableName, col.Name, err)
+		}
+		e.setIndex(tableID, col.Name, bt)
+	}
+	return nil
+}
+
+// setIndex makes bt the index on a table's column and records its root in
+// the catalog, now and whenever a split or a collapse moves it.
+func (e *Executor) setIndex(tableID uint32, column string, bt *index.BTree) {
+	if e.indexes[tableID] == nil {
+		e.indexes[tableID] = make(map[string]*index.BTree)
+	}
+	e.indexes[tableID][column] = bt
+	e.catalog.SetIndexRoot(tableID, bt.GetRootPageID(), column)
+	bt.OnRootChange(func(root types.PageID) {
+		if e.indexes[tableID][column] == bt {
+			e.catalog.SetIndexRoot(tableID, root, column)
+		}
+	})
+}
+
+// evaluateDefault evaluates a column's DEFAULT expression at CREATE TABLE
+// time. It returns nil for DEFAULT NULL.
+func (e *Executor) evaluateDefault(col ColumnDef) (*types.Value, error) {
+	if col.Serial {
+		return nil, fmt.Errorf("column %s: SERIAL columns cannot have a DEFAULT", col.Name)
+	}
+	if !isConstantExpr(col.Default) {
+		return nil, fmt.Errorf("column %s: DEFAULT must be a constant expression", col.Name)
+	}
+
+	val := e.evaluateExpr(col.Default, nil)
+	if err := e.takeFuncErr(); err != nil {
+		return nil, fmt.Errorf("column %s: DEFAULT: %w", col.Name, err)
+	}
+	if val.IsNull {
+		return nil, nil
+	}
+	val, err := asColumnType(val, col.Type)
+	if err != nil {
+		return nil, fmt.Errorf("column %s: DEFAULT: %w", col.Name, err)
+	}
+	if val.Type != col.Type {
+		return nil, fmt.Errorf("column %s: DEFAULT has type %s, want %s", col.Name, valueTypeName(val.Type), valueTypeName(col.Type))
+	}
+	return &val, nil
+}
+
+// isConstantExpr returns true if the expression references no columns.
+func isConstantExpr(expr Expr) bool {
+	switch ex := expr.(type) {
+	case *LiteralExpr:
+		return true
+	case *BinaryExpr:
+		return isConstantExpr(ex.Left) && isConstantExpr(ex.Right)
+	case *UnaryExpr:
+		return isConstantExpr(ex.Operand)
+	case *CollateExpr:
+		return isConstantExpr(ex.Expr)
+	case *IsNullExpr:
+		return isConstantExpr(ex.Expr)
+	case *InExpr:
+		for _, v := range ex.Values {
+			if !isConstantExpr(v) {
+				return false
+			}
+		}
+		return isConstantExpr(ex.Expr)
+	case *BetweenExpr:
+		return isConstantExpr(ex.Expr) && isConstantExpr(ex.Low) && isConstantExpr(ex.High)
+	case *FuncCallExpr:
+		for _, arg := range ex.Args {
+			if !isConstantExpr(arg) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
 func (e *Executor) executeInsert(stmt *InsertStmt) *Result {
 	if e.catalog == nil {
 		return &Result{Error: fmt.Errorf("storage not initialized")}
@@ -165,54 +488,103 @@ func (e *Executor) executeInsert(stmt *InsertStmt) *Result {
 	tableID, _ := e.catalog.GetTableID(stmt.TableName)
 	heap := e.catalog.GetTableHeap(tableID)
 
+	// Build every row and check NOT NULL and type constraints before the
+	// first one is written
+	rows := make([]map[string]types.Value, len(stmt.Values))
+	for i, values := range stmt.Values {
+		rowData, err := e.buildInsertRow(tableID, schema, stmt, values)
+		if err == nil {
+			err = checkRowConstraints(schema, rowData)
+		}
+		if err != nil {
+			if len(stmt.Values) > 1 {
+				err = fmt.Errorf("row %d: %w", i+1, err)
+			}
+			return &Result{Error: err}
+		}
+		rows[i] = rowData
+	}
+
 	// Get or create transaction
 	txn, autoCommit := e.getTransaction()
 	cid := txn.NextCommandID()
+	txn.RecordWrite(tableID)
 
-	// Build row data
-	rowData := make(map[string]types.Value)
-	columns := stmt.Columns
-	if len(columns) == 0 {
-		for _, col := range schema.Columns {
-			columns = append(columns, col.Name)
+	e.beginStatement(txn, autoCommit)
+	var last *storage.TupleWithRID
+	for i, rowData := range rows {
+		t, err := e.insertRow(tableID, schema, heap, txn, cid, rowData)
+		if err != nil {
+			if len(rows) > 1 {
+				err = fmt.Errorf("row %d: %w", i+1, err)
+			}
+			return e.failStatement(txn, autoCommit, err)
 		}
+		last = t
 	}
+	e.endStatement(txn, autoCommit)
+
+	e.recordChanges(tableID, stmt.TableName, len(rows))
 
-	if len(columns) != len(stmt.Values) {
-		return &Result{Error: fmt.Errorf("column count mismatch: %d columns, %d values", len(columns), len(stmt.Values))}
+	if autoCommit {
+		e.txnManager.Commit(txn)
+		if e.bufferPool != nil {
+			e.bufferPool.FlushAllPages()
+		}
 	}
 
-	for i, colName := range columns {
-		val := e.evaluateExpr(stmt.Values[i], nil)
-		rowData[colName] = val
+	result := &Result{
+		Message:      fmt.Sprintf("INSERT %d", len(rows)),
+		RowsAffected: len(rows),
+		LastInsert:   &index.RID{PageID: last.PageID, SlotNum: last.SlotNum, TableID: tableID},
+	}
+	if len(rows) == 1 {
+		result.Message = fmt.Sprintf("INSERT 1 (page=%d, slot=%d)", last.PageID, last.SlotNum)
+	}
+	return result
+}
+
+// insertRow writes one new row as command cid of txn: it checks uniqueness
+// against the rows txn can see (including rows the same statement wrote
+// before it), stores the tuple, logs it and adds it to the index.
+func (e *Executor) insertRow(tableID uint32, schema *types.Schema, heap *storage.TableHeap, txn *txn.Transaction, cid types.CommandID, rowData map[string]types.Value) (*storage.TupleWithRID, error) {
+	if err := e.checkUnique(tableID, schema, heap, txn, rowData, 0); err != nil {
+		return nil, err
 	}
 
 	// Serialize row data
 	data, err := types.SerializeRow(schema, rowData)
 	if err != nil {
-		return &Result{Error: fmt.Errorf("serialize failed: %w", err)}
+		return nil, fmt.Errorf("serialize failed: %w", err)
+	}
+
+	// Logical row ID, carried by every later version of the row
+	rowID, err := heap.NextRowID()
+	if err != nil {
+		return nil, fmt.Errorf("allocate row ID: %w", err)
 	}
 
 	// Create tuple with MVCC info
 	tuple := &types.Tuple{
-		XMin:    txn.ID,
-		XMax:    types.InvalidTxnID,
-		Cid:     cid,
-		TableID: tableID,
-		Data:    data,
+		XMin:       txn.ID,
+		XMax:       types.InvalidTxnID,
+		Cid:        cid,
+		TableID:    tableID,
+		RowID:      rowID,
+		PrevPageID: types.InvalidPageID,
+		Data:       data,
 	}
 
 	// Insert into heap (disk)
 	pageID, slotNum, err := heap.Insert(tuple)
 	if err != nil {
-		return &Result{Error: fmt.Errorf("insert failed: %w", err)}
+		return nil, fmt.Errorf("insert failed: %w", err)
 	}
 
-	tuple.RowID = uint64(pageID)<<16 | uint64(slotNum)
-
-	// Log to WAL
-	if e.walWriter != nil {
-		lsn := e.walWriter.LogInsert(txn.ID, tableID, tuple.RowID, pageID, slotNum, tuple.Serialize())
+	// Log to WAL; temp tables need not survive a crash
+	lsn := types.InvalidLSN
+	if e.walWriter != nil && !e.catalog.IsTemp(tableID) {
+		lsn = e.walWriter.LogInsert(txn.ID, tableID, tuple.RowID, pageID, slotNum, tuple.Serialize())
 		// Set page LSN
 		if e.bufferPool != nil {
 			if p, err := e.bufferPool.FetchPage(pageID); err == nil {
@@ -221,301 +593,830 @@ func (e *Executor) executeInsert(stmt *InsertStmt) *Result {
 			}
 		}
 	}
+	txn.RecordInsert(tableID, pageID, slotNum, lsn)
 
-	// Update index if exists
-	if bt, ok := e.indexes[tableID]; ok {
-		if colName, ok := e.catalog.GetIndexColumn(tableID); ok {
-			if val, ok := rowData[colName]; ok {
-				key := index.EncodeKey(val, 64)
-				rid := index.RID{PageID: pageID, SlotNum: slotNum, TableID: tableID}
-				bt.Insert(key, rid)
-			}
+	// Update the table's indexes
+	for colName, bt := range e.indexes[tableID] {
+		if val, ok := rowData[colName]; ok {
+			rid := index.RID{PageID: pageID, SlotNum: slotNum, TableID: tableID}
+			bt.Insert(index.EntryKey(schema, colName, val, rid, 64), rid)
 		}
 	}
 
-	if autoCommit {
-		e.txnManager.Commit(txn)
-		if e.bufferPool != nil {
-			e.bufferPool.FlushAllPages()
+	return &storage.TupleWithRID{Tuple: tuple, PageID: pageID, SlotNum: slotNum}, nil
+}
+
+// insertAll runs the INSERTs in order. When no transaction is open and
+// autocommit is on they run in a transaction of their own and commit
+// together, or are rolled back together on the first failure. In a
+// transaction block they run as one statement: a failure undoes the rows
+// already inserted and leaves the transaction open. It returns the index
+// of the INSERT that failed along with its error.
+func (e *Executor) insertAll(inserts []*InsertStmt) (int, error) {
+	ownTxn := e.currentTxn == nil && e.session.Autocommit
+	if ownTxn {
+		e.currentTxn = e.beginTxn()
+	}
+	t, _ := e.getTransaction()
+	e.beginStatement(t, ownTxn)
+	for i, insert := range inserts {
+		if res := e.executeInsert(insert); res.Error != nil {
+			if ownTxn {
+				e.executeRollback()
+			} else if e.currentTxn == t {
+				// A deadlock victim has already been rolled back whole
+				res = e.failStatement(t, false, res.Error)
+			}
+			return i, res.Error
 		}
 	}
-
-	return &Result{Message: fmt.Sprintf("INSERT 1 (page=%d, slot=%d)", pageID, slotNum)}
+	if ownTxn {
+		if res := e.executeCommit(); res.Error != nil {
+			return len(inserts), res.Error
+		}
+	} else {
+		e.endStatement(t, false)
+	}
+	return len(inserts), nil
 }
 
-func (e *Executor) executeSelect(stmt *SelectStmt) *Result {
-	if e.catalog == nil {
-		return &Result{Error: fmt.Errorf("storage not initialized")}
+// buildInsertRow assembles one row of an INSERT from one VALUES tuple:
+// named columns take their values, omitted columns take their DEFAULT (or
+// the next SERIAL value), and anything else is NULL.
+func (e *Executor) buildInsertRow(tableID uint32, schema *types.Schema, stmt *InsertStmt, values []Expr) (map[string]types.Value, error) {
+	columns := stmt.Columns
+	if len(columns) == 0 {
+		for _, col := range schema.Columns {
+			columns = append(columns, col.Name)
+		}
 	}
 
-	schema := e.catalog.GetSchema(stmt.TableName)
-	if schema == nil {
-		return &Result{Error: fmt.Errorf("table %s does not exist", stmt.TableName)}
+	if len(columns) != len(values) {
+		return nil, fmt.Errorf("column count mismatch: %d columns, %d values", len(columns), len(values))
 	}
 
-	tableID, _ := e.catalog.GetTableID(stmt.TableName)
-	heap := e.catalog.GetTableHeap(tableID)
-
-	// Get or create transaction
-	txn, autoCommit := e.getTransaction()
-
-	result := &Result{}
-
-	// Determine columns
-	if len(stmt.Columns) == 1 && stmt.Columns[0] == "*" {
-		for _, col := range schema.Columns {
-			result.Columns = append(result.Columns, col.Name)
-		}
-	} else {
-		result.Columns = stmt.Columns
-	}
-
-	// Try index lookup for WHERE column = literal
-	indexUsed := false
-	if stmt.Where != nil {
-		if rows, ok := e.tryIndexLookup(tableID, schema, heap, stmt.Where, txn); ok {
-			for _, rowData := range rows {
-				row := types.Row{Values: make([]types.Value, len(result.Columns))}
-				for i, colName := range result.Columns {
-					if val, ok := rowData[colName]; ok {
-						row.Values[i] = val
-					} else {
-						row.Values[i] = types.Value{IsNull: true}
-					}
-				}
-				result.Rows = append(result.Rows, row)
-			}
-			indexUsed = true
+	rowData := make(map[string]types.Value)
+	for i, colName := range columns {
+		if schemaColumn(schema, colName) == nil {
+			return nil, fmt.Errorf("column %s does not exist in table %s", colName, stmt.TableName)
 		}
+		rowData[colName] = e.evaluateExpr(values[i], nil)
+	}
+	if err := e.takeFuncErr(); err != nil {
+		return nil, err
 	}
 
-	// Fall back to full scan
-	if !indexUsed {
-		tuples, err := heap.Scan()
-		if err != nil {
-			return &Result{Error: fmt.Errorf("scan failed: %w", err)}
+	for _, col := range schema.Columns {
+		val, ok := rowData[col.Name]
+		switch {
+		case col.Serial && (!ok || val.IsNull):
+			rowData[col.Name] = types.Value{Type: types.ValueTypeInt, IntVal: e.catalog.NextSequenceValue(tableID)}
+		case col.Serial && val.Type == types.ValueTypeInt:
+			e.catalog.AdvanceSequence(tableID, val.IntVal)
+		case !ok && col.Default != nil:
+			rowData[col.Name] = *col.Default
+		case !ok:
+			rowData[col.Name] = types.Value{IsNull: true}
 		}
+	}
 
-		for _, t := range tuples {
-			if !txn.Snapshot.IsVisible(t.Tuple) {
-				continue
-			}
-
-			rowData, err := types.DeserializeRow(schema, t.Tuple.Data)
-			if err != nil {
-				continue
-			}
-
-			if stmt.Where != nil {
-				if !e.evaluateCondition(stmt.Where, rowData) {
-					continue
-				}
-			}
+	return rowData, nil
+}
 
-			row := types.Row{Values: make([]types.Value, len(result.Columns))}
-			for i, colName := range result.Columns {
-				if val, ok := rowData[colName]; ok {
-					row.Values[i] = val
-				} else {
-					row.Values[i] = types.Value{IsNull: true}
-				}
+// checkRowConstraints verifies NOT NULL and column types, reporting the first
+// offending column in schema order. TEXT given for a TIMESTAMP column is
+// converted in place.
+func checkRowConstraints(schema *types.Schema, rowData map[string]types.Value) error {
+	for _, col := range schema.Columns {
+		val := rowData[col.Name]
+		if val.IsNull {
+			if !col.Nullable {
+				return fmt.Errorf("column %s: NULL value violates NOT NULL constraint", col.Name)
 			}
-			result.Rows = append(result.Rows, row)
+			continue
+		}
+		val, err := asColumnType(val, col.Type)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", col.Name, err)
+		}
+		rowData[col.Name] = val
+		if val.Type != col.Type {
+			return fmt.Errorf("column %s: expected %s, got %s", col.Name, valueTypeName(col.Type), valueTypeName(val.Type))
 		}
 	}
+	return nil
+}
 
-	if autoCommit {
-		e.txnManager.Commit(txn)
+// asColumnType converts a TEXT value to a TIMESTAMP when typ is TIMESTAMP,
+// so that '2024-01-02T15:04:05Z' can be stored in or compared with one.
+// Other values are returned unchanged.
+func asColumnType(val types.Value, typ types.ValueType) (types.Value, error) {
+	if typ != types.ValueTypeTimestamp || val.Type != types.ValueTypeString || val.IsNull {
+		return val, nil
 	}
-
-	result.Message = fmt.Sprintf("SELECT %d rows", len(result.Rows))
-	return result
+	sec, err := types.ParseTimestamp(val.StrVal)
+	if err != nil {
+		return val, err
+	}
+	return types.Value{Type: types.ValueTypeTimestamp, IntVal: sec}, nil
 }
 
-func (e *Executor) executeUpdate(stmt *UpdateStmt) *Result {
-	if e.catalog == nil {
-		return &Result{Error: fmt.Errorf("storage not initialized")}
+// checkUnique fails if a row visible to txn (including its own inserts)
+// already holds the new row's value in a UNIQUE or PRIMARY KEY column.
+// Versions of the row self (a RowID; 0 for a new row) are not compared, so
+// an UPDATE does not collide with the version it replaces. An indexed key
+// column is checked through the index when that settles it.
+//
+// A version txn's snapshot does not see can still hold the value: another
+// session's transaction may have written it and be running, or have
+// committed after the snapshot was taken. Both would end with two live rows
+// sharing the key, so such a version fails the check as a serialization
+// conflict. It stops counting once its transaction rolls back, which
+// deletes it.
+func (e *Executor) checkUnique(tableID uint32, schema *types.Schema, heap *storage.TableHeap, txn *txn.Transaction, rowData map[string]types.Value, self uint64) error {
+	var uniqueCols []types.Column
+	for _, col := range schema.Columns {
+		if !col.Unique || rowData[col.Name].IsNull {
+			continue
+		}
+		duplicate, settled := e.probeUnique(tableID, schema, heap, txn, col, rowData[col.Name], self)
+		if duplicate {
+			return uniqueViolation(col, rowData[col.Name])
+		}
+		if !settled {
+			uniqueCols = append(uniqueCols, col)
+		}
 	}
-
-	schema := e.catalog.GetSchema(stmt.TableName)
-	if schema == nil {
-		return &Result{Error: fmt.Errorf("table %s does not exist", stmt.TableName)}
+	if len(uniqueCols) == 0 {
+		return nil
 	}
 
-	tableID, _ := e.catalog.GetTableID(stmt.TableName)
-	heap := e.catalog.GetTableHeap(tableID)
-
-	// Get or create transaction
-	txn, autoCommit := e.getTransaction()
-	cid := txn.NextCommandID()
-
-	// Scan heap
 	tuples, err := heap.Scan()
 	if err != nil {
-		return &Result{Error: fmt.Errorf("scan failed: %w", err)}
+		return fmt.Errorf("scan failed: %w", err)
 	}
 
-	updated := 0
 	for _, t := range tuples {
-		// Check MVCC visibility
-		if !txn.Snapshot.IsVisible(t.Tuple) {
+		if self != 0 && t.Tuple.RowID == self {
 			continue
 		}
-
-		rowData, err := types.DeserializeRow(schema, t.Tuple.Data)
-		if err != nil {
+		visible := visibleToTxn(txn, t.Tuple)
+		if !visible && !e.writtenConcurrently(txn, t.Tuple) {
 			continue
 		}
-
-		// Apply WHERE filter
-		if stmt.Where != nil {
-			if !e.evaluateCondition(stmt.Where, rowData) {
-				continue
-			}
-		}
-
-		// Save old tuple for WAL
-		oldTupleData := t.Tuple.Serialize()
-
-		// Apply updates
-		for colName, expr := range stmt.Set {
-			rowData[colName] = e.evaluateExpr(expr, rowData)
-		}
-
-		// Mark old version as deleted
-		t.Tuple.XMax = txn.ID
-
-		// Write back old tuple's XMax to disk
-		heap.Update(t.PageID, t.SlotNum, t.Tuple)
-
-		// Create new version
-		newData, err := types.SerializeRow(schema, rowData)
-		if err != nil {
-			if autoCommit {
-				e.txnManager.Rollback(txn)
-			}
-			return &Result{Error: fmt.Errorf("serialize failed: %w", err)}
-		}
-		newTuple := &types.Tuple{
-			XMin:    txn.ID,
-			XMax:    types.InvalidTxnID,
-			Cid:     cid,
-			TableID: tableID,
-			RowID:   t.Tuple.RowID,
-			Data:    newData,
-		}
-
-		// Update on disk (insert new version)
-		newPageID, newSlotNum, err := heap.Insert(newTuple)
+		existing, err := types.DeserializeRow(schema, t.Tuple.Data)
 		if err != nil {
-			if autoCommit {
-				e.txnManager.Rollback(txn)
-			}
-			return &Result{Error: fmt.Errorf("update failed: %w", err)}
+			continue
 		}
-
-		newTuple.RowID = uint64(newPageID)<<16 | uint64(newSlotNum)
-
-		// Log to WAL
-		if e.walWriter != nil {
-			lsn := e.walWriter.LogUpdate(txn.ID, tableID, t.Tuple.RowID, newPageID, newSlotNum, oldTupleData, newTuple.Serialize())
-			// Set page LSN on new page
-			if e.bufferPool != nil {
-				if p, err := e.bufferPool.FetchPage(newPageID); err == nil {
-					p.SetLSN(lsn)
-					e.bufferPool.UnpinPage(newPageID, true)
-				}
+		for _, col := range uniqueCols {
+			if !e.valuesEqual(col.Collation.Key(existing[col.Name]), col.Collation.Key(rowData[col.Name])) {
+				continue
 			}
-		}
-
-		// Update index if exists
-		if bt, ok := e.indexes[tableID]; ok {
-			if colName, ok := e.catalog.GetIndexColumn(tableID); ok {
-				if val, ok := rowData[colName]; ok {
-					key := index.EncodeKey(val, 64)
-					rid := index.RID{PageID: newPageID, SlotNum: newSlotNum, TableID: tableID}
-					bt.Insert(key, rid)
-				}
+			if visible {
+				return uniqueViolation(col, rowData[col.Name])
 			}
+			return fmt.Errorf("could not serialize access: column %s: value %s was concurrently written by transaction %d", col.Name, rowData[col.Name], t.Tuple.XMin)
 		}
-
-		updated++
 	}
+	return nil
+}
 
-	if autoCommit {
-		e.txnManager.Commit(txn)
-		if e.bufferPool != nil {
-			e.bufferPool.FlushAllPages()
-		}
+// writtenConcurrently reports whether a version t does not see is live for
+// another transaction: its writer is running or committed, and nothing has
+// deleted it. A rolled-back writer deletes its versions itself.
+func (e *Executor) writtenConcurrently(t *txn.Transaction, tuple *types.Tuple) bool {
+	if tuple.XMin == t.ID || tuple.XMax != types.InvalidTxnID {
+		return false
 	}
-
-	return &Result{Message: fmt.Sprintf("UPDATE %d", updated)}
+	return e.txnManager.GetTransaction(tuple.XMin) != nil || e.txnManager.IsTxnCommitted(tuple.XMin)
 }
 
-func (e *Executor) executeDelete(stmt *DeleteStmt) *Result {
-	if e.catalog == nil {
-		return &Result{Error: fmt.Errorf("storage not initialized")}
+// probeUnique looks a UNIQUE column's value up in the column's index, if it
+// has one. Every version ever written is indexed under its
+// key, the latest one last, so no entry means no duplicate, and a visible
+// latest version holding the value is one. Otherwise (no index, or the
+// latest version is not visible to txn) an older version may still be, and
+// the result is not settled.
+func (e *Executor) probeUnique(tableID uint32, schema *types.Schema, heap *storage.TableHeap, txn *txn.Transaction, col types.Column, val types.Value, self uint64) (duplicate, settled bool) {
+	bt, ok := e.indexes[tableID][col.Name]
+	if !ok {
+		return false, false
 	}
 
-	schema := e.catalog.GetSchema(stmt.TableName)
-	if schema == nil {
-		return &Result{Error: fmt.Errorf("table %s does not exist", stmt.TableName)}
+	rid, found := bt.Search(index.EncodeColumnKey(schema, col.Name, val, 64))
+	if !found {
+		return false, true
 	}
+	tuple, err := heap.Get(rid.PageID, rid.SlotNum)
+	if err != nil || !visibleToTxn(txn, tuple) {
+		return false, false
+	}
+	if self != 0 && tuple.RowID == self {
+		return false, true
+	}
+	existing, err := types.DeserializeRow(schema, tuple.Data)
+	if err != nil {
+		return false, false
+	}
+	// Long keys are truncated in the index, so compare the values too
+	if !e.valuesEqual(col.Collation.Key(existing[col.Name]), col.Collation.Key(val)) {
+		return false, false
+	}
+	return true, true
+}
+
+// uniqueViolation reports a duplicate value in a UNIQUE or PRIMARY KEY column.
+func uniqueViolation(col types.Column, val types.Value) error {
+	constraint := "UNIQUE"
+	if col.PrimaryKey {
+		constraint = "PRIMARY KEY"
+	}
+	return fmt.Errorf("column %s: duplicate value %s violates %s constraint", col.Name, val, constraint)
+}
+
+// visibleToTxn reports whether a tuple is live for the transaction: visible
+// in its snapshot or inserted by it, and not deleted by it.
+func visibleToTxn(t *txn.Transaction, tuple *types.Tuple) bool {
+	if tuple.XMax == t.ID {
+		return false
+	}
+	return tuple.XMin == t.ID || t.Snapshot.IsVisible(tuple)
+}
+
+// lockForWrite checks that row can be changed by t and takes an exclusive
+// lock on it, held until t ends. A version another transaction already
+// changed fails at once, as before; the lock only makes two writers racing
+// for the same row queue up. Once the lock is granted the version is read
+// again, since the previous holder may have changed it and committed.
+func (e *Executor) lockForWrite(t *txn.Transaction, tableID uint32, heap *storage.TableHeap, row *storage.TupleWithRID) error {
+	if err := checkWriteConflict(t, row.Tuple); err != nil {
+		return err
+	}
+	if err := e.txnManager.LockRowReleasing(t, txn.RowKey{TableID: tableID, RowID: row.Tuple.RowID}, txn.LockExclusive, e.stmtLock); err != nil {
+		return err
+	}
+	current, err := heap.Get(row.PageID, row.SlotNum)
+	if err != nil {
+		return err
+	}
+	return checkWriteConflict(t, current)
+}
+
+// checkWriteConflict fails if another transaction deleted or updated a
+// version t is about to change, and t's snapshot does not see that change:
+// the other transaction is still running or committed after the snapshot
+// was taken. Writing the version anyway would overwrite its change.
+func checkWriteConflict(t *txn.Transaction, tuple *types.Tuple) error {
+	if _, conflict := t.Snapshot.IsVisibleForUpdate(tuple, t.ID); conflict != types.InvalidTxnID {
+		return fmt.Errorf("could not serialize access: row %d was concurrently changed by transaction %d", tuple.RowID, conflict)
+	}
+	return nil
+}
+
+// valueTypeName returns the SQL name of a value type.
+func valueTypeName(vt types.ValueType) string {
+	if vt == types.ValueTypeNull {
+		return "NULL"
+	}
+	return columnTypeName(types.Column{Type: vt})
+}
+
+// executeSelect runs a SELECT. sqlText is the original statement text, used
+// as the result cache key; pass "" to bypass the cache.
+func (e *Executor) executeSelect(stmt *SelectStmt, sqlText string) *Result {
+	if stmt.TableName == "" {
+		return e.executeSelectWithoutTable(stmt)
+	}
+	sp, err := e.planSelect(stmt)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	tableID := sp.tableID
 
-	tableID, _ := e.catalog.GetTableID(stmt.TableName)
-	heap := e.catalog.GetTableHeap(tableID)
-
 	// Get or create transaction
 	txn, autoCommit := e.getTransaction()
 
-	// Scan heap
-	tuples, err := heap.Scan()
+	// Serve from the result cache when the snapshot sees every committed
+	// change and the transaction has no uncommitted writes to the table
+	cacheKey := ""
+	if e.queryCache != nil && sqlText != "" && !stmt.ForUpdate && !txn.HasWritten(tableID) &&
+		e.txnManager.IsSnapshotCurrent(txn.Snapshot) {
+		// The snapshot's commit sequence is the current one, so the scan
+		// below reads as of it; Put drops the result if it has moved on
+		cacheKey = queryCacheKey(sqlText, txn.Snapshot, txn.ID)
+		if cached, ok := e.queryCache.Get(cacheKey); ok {
+			if autoCommit {
+				e.txnManager.Commit(txn)
+			}
+			return cached
+		}
+	}
+
+	rows, err := drain(e.selectOperator(stmt, sp.exprs, sp.orderKeys, sp.grouped, sp.aggs, sp.scan, txn))
+	if err == nil {
+		err = e.takeFuncErr()
+	}
 	if err != nil {
-		return &Result{Error: fmt.Errorf("scan failed: %w", err)}
+		if autoCommit {
+			e.txnManager.Commit(txn)
+		}
+		return &Result{Error: err}
+	}
+	if stmt.ForUpdate {
+		e.beginStatement(txn, autoCommit)
+		if err := e.lockRows(txn, tableID, rows); err != nil {
+			return e.failStatement(txn, autoCommit, err)
+		}
+		e.endStatement(txn, autoCommit)
 	}
 
-	deleted := 0
-	for _, t := range tuples {
-		// Check MVCC visibility
-		if !txn.Snapshot.IsVisible(t.Tuple) {
+	result := &Result{Columns: sp.columns}
+	for _, row := range rows {
+		result.Rows = append(result.Rows, row.out)
+	}
+	result.Message = fmt.Sprintf("SELECT %d rows", len(result.Rows))
+
+	if cacheKey != "" {
+		e.queryCache.Put(cacheKey, tableID, txn.Snapshot.CommitSeq, result)
+	}
+
+	if autoCommit {
+		e.txnManager.Commit(txn)
+	}
+
+	return result
+}
+
+// selectPlan is what running a SELECT from a table needs beyond the
+// statement itself.
+type selectPlan struct {
+	tableID   uint32
+	columns   []string // Output column names, with * expanded
+	exprs     []Expr   // Select-list expressions, with * expanded
+	orderKeys []orderKey
+	grouped   bool
+	aggs      []*AggregateExpr
+	scan      scanPlan
+}
+
+// planSelect resolves a SELECT against its table's schema and picks how to
+// scan the table. It also sets the statement's collations.
+func (e *Executor) planSelect(stmt *SelectStmt) (*selectPlan, error) {
+	if e.catalog == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+
+	schema := e.catalog.GetSchema(stmt.TableName)
+	if schema == nil {
+		return nil, fmt.Errorf("table %s does not exist", stmt.TableName)
+	}
+
+	sp := &selectPlan{}
+	sp.tableID, _ = e.catalog.GetTableID(stmt.TableName)
+	e.useCollations(schema)
+
+	// Determine columns
+	sp.columns, sp.exprs = stmt.Columns, stmt.Exprs
+	if len(stmt.Columns) == 1 && stmt.Columns[0] == "*" {
+		sp.columns, sp.exprs = nil, nil
+		for _, col := range schema.Columns {
+			sp.columns = append(sp.columns, col.Name)
+			sp.exprs = append(sp.exprs, &ColumnExpr{Name: col.Name})
+		}
+	}
+
+	var err error
+	if sp.orderKeys, err = resolveOrderBy(stmt.OrderBy, sp.exprs, stmt.Aliases); err != nil {
+		return nil, err
+	}
+	if sp.grouped, sp.aggs, err = planAggregation(stmt, sp.exprs, sp.orderKeys); err != nil {
+		return nil, err
+	}
+	if sp.scan, err = e.planScan(stmt, sp.tableID, sp.grouped); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// lockRows takes exclusive locks on the rows a SELECT ... FOR UPDATE
+// returns, checking each as UPDATE would. The locks are held until t ends,
+// so a later UPDATE of the rows in the same transaction cannot lose to
+// another writer.
+func (e *Executor) lockRows(t *txn.Transaction, tableID uint32, rows []*execRow) error {
+	heap := e.catalog.GetTableHeap(tableID)
+	for _, row := range rows {
+		if err := e.lockForWrite(t, tableID, heap, row.tuple); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeSelectWithoutTable evaluates the select list of a FROM-less SELECT
+// once against an empty row.
+func (e *Executor) executeSelectWithoutTable(stmt *SelectStmt) *Result {
+	orderKeys, err := resolveOrderBy(stmt.OrderBy, stmt.Exprs, stmt.Aliases)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	grouped, aggs, err := planAggregation(stmt, stmt.Exprs, orderKeys)
+	if err != nil {
+		return &Result{Error: err}
+	}
+
+	rows, err := drain(e.selectOperator(stmt, stmt.Exprs, orderKeys, grouped, aggs, scanPlan{}, nil))
+	if err == nil {
+		err = e.takeFuncErr()
+	}
+	if err != nil {
+		return &Result{Error: err}
+	}
+
+	result := &Result{Columns: stmt.Columns}
+	for _, row := range rows {
+		result.Rows = append(result.Rows, row.out)
+	}
+
+	result.Message = fmt.Sprintf("SELECT %d rows", len(result.Rows))
+	return result
+}
+
+// executeValues evaluates a standalone VALUES list. Columns are named
+// column1, column2, ... and each takes the type of its first non-NULL value;
+// every other non-NULL value in the column must have the same type.
+func (e *Executor) executeValues(stmt *ValuesStmt) *Result {
+	width := len(stmt.Rows[0])
+	result := &Result{Columns: make([]string, width)}
+	for i := range result.Columns {
+		result.Columns[i] = fmt.Sprintf("column%d", i+1)
+	}
+
+	colTypes := make([]types.ValueType, width)
+	for i := range colTypes {
+		colTypes[i] = types.ValueTypeNull
+	}
+
+	rowData := make(map[string]types.Value)
+	for _, exprs := range stmt.Rows {
+		row := e.projectRow(exprs, rowData)
+		if err := e.takeFuncErr(); err != nil {
+			return &Result{Error: err}
+		}
+		for i, v := range row.Values {
+			if v.IsNull {
+				continue
+			}
+			if colTypes[i] == types.ValueTypeNull {
+				colTypes[i] = v.Type
+			} else if v.Type != colTypes[i] {
+				return &Result{Error: fmt.Errorf("VALUES %s: expected %s, got %s",
+					result.Columns[i], valueTypeName(colTypes[i]), valueTypeName(v.Type))}
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	result.Message = fmt.Sprintf("VALUES %d rows", len(result.Rows))
+	return result
+}
+
+// orderKey is a resolved ORDER BY item.
+type orderKey struct {
+	expr Expr
+	desc bool
+}
+
+// resolveOrderBy replaces integer ordinals and AS aliases in ORDER BY with
+// the select-list expressions they refer to. An alias takes precedence over
+// a table column of the same name.
+func resolveOrderBy(items []OrderByItem, exprs []Expr, aliases []string) ([]orderKey, error) {
+	keys := make([]orderKey, len(items))
+	for i, item := range items {
+		keys[i] = orderKey{expr: item.Expr, desc: item.Desc}
+		if j := aliasIndex(item.Expr, aliases); j >= 0 {
+			keys[i].expr = exprs[j]
 			continue
 		}
+		lit, ok := item.Expr.(*LiteralExpr)
+		if !ok || lit.Value.Type != types.ValueTypeInt || lit.Value.IsNull {
+			continue
+		}
+		pos := lit.Value.IntVal
+		if pos < 1 || pos > int64(len(exprs)) {
+			return nil, fmt.Errorf("ORDER BY position %d is not in select list (1..%d)", pos, len(exprs))
+		}
+		keys[i].expr = exprs[pos-1]
+	}
+	return keys, nil
+}
+
+// aliasIndex returns the position of the select-list entry whose AS alias
+// expr names, or -1.
+func aliasIndex(expr Expr, aliases []string) int {
+	col, ok := expr.(*ColumnExpr)
+	if !ok {
+		return -1
+	}
+	for i, alias := range aliases {
+		if alias == col.Name {
+			return i
+		}
+	}
+	return -1
+}
+
+// sortRows stably sorts rows by the ORDER BY keys. NULLs sort after every
+// other value in ascending order and before them in descending order.
+func (e *Executor) sortRows(rows []*execRow, keys []orderKey) {
+	if len(keys) == 0 {
+		return
+	}
+
+	// Evaluate each key once per row, in the form its collation orders
+	colls := make([]types.Collation, len(keys))
+	for k, key := range keys {
+		colls[k] = e.exprCollation(key.expr)
+	}
+	values := make([][]types.Value, len(rows))
+	for i, row := range rows {
+		values[i] = make([]types.Value, len(keys))
+		for k, key := range keys {
+			values[i][k] = colls[k].Key(e.evaluateExpr(key.expr, row.values))
+		}
+	}
+
+	idx := make([]int, len(rows))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		for k, key := range keys {
+			c := e.compareForSort(values[idx[a]][k], values[idx[b]][k])
+			if c == 0 {
+				continue
+			}
+			if key.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+
+	sorted := make([]*execRow, len(rows))
+	for i, j := range idx {
+		sorted[i] = rows[j]
+	}
+	copy(rows, sorted)
+}
+
+// compareForSort orders two values, treating NULL as larger than anything.
+// Values of different types are ordered by type.
+func (e *Executor) compareForSort(a, b types.Value) int {
+	switch {
+	case a.IsNull && b.IsNull:
+		return 0
+	case a.IsNull:
+		return 1
+	case b.IsNull:
+		return -1
+	case a.Type != b.Type:
+		if a.Type < b.Type {
+			return -1
+		}
+		return 1
+	case e.valuesEqual(a, b):
+		return 0
+	case e.compareLess(a, b):
+		return -1
+	default:
+		return 1
+	}
+}
+
+func (e *Executor) projectRow(exprs []Expr, rowData map[string]types.Value) types.Row {
+	row := types.Row{Values: make([]types.Value, len(exprs))}
+	for i, expr := range exprs {
+		row.Values[i] = e.evaluateExpr(expr, rowData)
+	}
+	return row
+}
+
+func (e *Executor) executeUpdate(stmt *UpdateStmt) *Result {
+	if e.catalog == nil {
+		return &Result{Error: fmt.Errorf("storage not initialized")}
+	}
+
+	schema := e.catalog.GetSchema(stmt.TableName)
+	if schema == nil {
+		return &Result{Error: fmt.Errorf("table %s does not exist", stmt.TableName)}
+	}
+
+	tableID, _ := e.catalog.GetTableID(stmt.TableName)
+	heap := e.catalog.GetTableHeap(tableID)
+	e.useCollations(schema)
+
+	for colName := range stmt.Set {
+		if schemaColumn(schema, colName) == nil {
+			return &Result{Error: fmt.Errorf("column %s does not exist in table %s", colName, stmt.TableName)}
+		}
+	}
+
+	// Get or create transaction
+	txn, autoCommit := e.getTransaction()
+	cid := txn.NextCommandID()
+	txn.RecordWrite(tableID)
+	e.beginStatement(txn, autoCommit)
+
+	// Visible rows matching WHERE
+	scan := e.scanOperator(heap, schema, txn, stmt.Where, false)
+	if err := scan.Open(); err != nil {
+		return e.failStatement(txn, autoCommit, err)
+	}
+	defer scan.Close()
+
+	// Keep the pages being modified pinned for the whole statement
+	batch := heap.NewWriteBatch()
+	defer batch.Release()
+
+	matched, updated := 0, 0
+	for {
+		row, err := scan.Next()
+		if err != nil {
+			return e.failStatement(txn, autoCommit, err)
+		}
+		if row == nil {
+			break
+		}
+		t, rowData := row.tuple, row.values
+
+		// Save old tuple for WAL
+		oldTupleData := t.Tuple.Serialize()
 
-		rowData, err := types.DeserializeRow(schema, t.Tuple.Data)
+		// Apply updates, noting whether a UNIQUE value changes
+		keyChanged := false
+		for colName, expr := range stmt.Set {
+			val := e.evaluateExpr(expr, rowData)
+			if col := schemaColumn(schema, colName); col.Unique && !e.valuesEqual(col.Collation.Key(val), col.Collation.Key(rowData[colName])) {
+				keyChanged = true
+			}
+			rowData[colName] = val
+		}
+		if err := e.takeFuncErr(); err != nil {
+			return e.failStatement(txn, autoCommit, err)
+		}
+		if err := checkRowConstraints(schema, rowData); err != nil {
+			return e.failStatement(txn, autoCommit, err)
+		}
+		if keyChanged {
+			if err := e.checkUnique(tableID, schema, heap, txn, rowData, t.Tuple.RowID); err != nil {
+				return e.failStatement(txn, autoCommit, err)
+			}
+		}
+		matched++
+
+		newData, err := types.SerializeRow(schema, rowData)
 		if err != nil {
+			return e.failStatement(txn, autoCommit, fmt.Errorf("serialize failed: %w", err))
+		}
+
+		// A row set to the values it already has needs no new version
+		if bytes.Equal(newData, t.Tuple.Data) {
 			continue
 		}
 
-		// Apply WHERE filter
-		if stmt.Where != nil {
-			if !e.evaluateCondition(stmt.Where, rowData) {
-				continue
+		if err := e.lockForWrite(txn, tableID, heap, t); err != nil {
+			return e.failStatement(txn, autoCommit, err)
+		}
+
+		// Mark old version as deleted
+		t.Tuple.XMax = txn.ID
+
+		// Write back old tuple's XMax to disk
+		batch.Update(t.PageID, t.SlotNum, t.Tuple)
+
+		// Create new version
+		newTuple := &types.Tuple{
+			XMin:        txn.ID,
+			XMax:        types.InvalidTxnID,
+			Cid:         cid,
+			TableID:     tableID,
+			RowID:       t.Tuple.RowID,
+			PrevPageID:  t.PageID,
+			PrevSlotNum: t.SlotNum,
+			Data:        newData,
+		}
+
+		// Update on disk (insert new version)
+		newPageID, newSlotNum, err := heap.Insert(newTuple)
+		if err != nil {
+			return e.failStatement(txn, autoCommit, fmt.Errorf("update failed: %w", err))
+		}
+
+		// Log to WAL
+		lsn := types.InvalidLSN
+		if e.walWriter != nil && !e.catalog.IsTemp(tableID) {
+			lsn = e.walWriter.LogUpdate(txn.ID, tableID, newTuple.RowID, t.PageID, t.SlotNum, newPageID, newSlotNum, oldTupleData, newTuple.Serialize())
+			// Set page LSN on both versions' pages
+			batch.SetLSN(t.PageID, lsn)
+			batch.SetLSN(newPageID, lsn)
+		}
+		txn.RecordUpdate(tableID, t.PageID, t.SlotNum, newPageID, newSlotNum, lsn)
+
+		// Update the table's indexes
+		for colName, bt := range e.indexes[tableID] {
+			if val, ok := rowData[colName]; ok {
+				rid := index.RID{PageID: newPageID, SlotNum: newSlotNum, TableID: tableID}
+				bt.Insert(index.EntryKey(schema, colName, val, rid, 64), rid)
 			}
 		}
 
+		updated++
+	}
+	batch.Release()
+	e.endStatement(txn, autoCommit)
+	e.recordChanges(tableID, stmt.TableName, updated)
+
+	if autoCommit {
+		e.txnManager.Commit(txn)
+		if e.bufferPool != nil {
+			e.bufferPool.FlushAllPages()
+		}
+	}
+
+	if updated == matched {
+		return &Result{Message: fmt.Sprintf("UPDATE %d", updated), RowsAffected: matched}
+	}
+	return &Result{Message: fmt.Sprintf("UPDATE %d (%d changed)", matched, updated), RowsAffected: matched}
+}
+
+func (e *Executor) executeDelete(stmt *DeleteStmt) *Result {
+	if e.catalog == nil {
+		return &Result{Error: fmt.Errorf("storage not initialized")}
+	}
+
+	schema := e.catalog.GetSchema(stmt.TableName)
+	if schema == nil {
+		return &Result{Error: fmt.Errorf("table %s does not exist", stmt.TableName)}
+	}
+
+	tableID, _ := e.catalog.GetTableID(stmt.TableName)
+	heap := e.catalog.GetTableHeap(tableID)
+	e.useCollations(schema)
+
+	// Get or create transaction
+	txn, autoCommit := e.getTransaction()
+	txn.RecordWrite(tableID)
+	e.beginStatement(txn, autoCommit)
+
+	// Visible rows matching WHERE
+	scan := e.scanOperator(heap, schema, txn, stmt.Where, false)
+	if err := scan.Open(); err != nil {
+		return e.failStatement(txn, autoCommit, err)
+	}
+	defer scan.Close()
+
+	// Keep the pages being modified pinned for the whole statement
+	batch := heap.NewWriteBatch()
+	defer batch.Release()
+
+	deleted := 0
+	for {
+		row, err := scan.Next()
+		if err != nil {
+			return e.failStatement(txn, autoCommit, err)
+		}
+		if row == nil {
+			break
+		}
+		t := row.tuple
+
 		// Save old tuple for WAL
 		oldTupleData := t.Tuple.Serialize()
 
+		if err := e.lockForWrite(txn, tableID, heap, t); err != nil {
+			return e.failStatement(txn, autoCommit, err)
+		}
+
 		// Mark as deleted (MVCC style)
 		t.Tuple.XMax = txn.ID
 
 		// Update on disk
-		heap.Update(t.PageID, t.SlotNum, t.Tuple)
+		batch.Update(t.PageID, t.SlotNum, t.Tuple)
 
 		// Log to WAL
-		if e.walWriter != nil {
-			lsn := e.walWriter.LogDelete(txn.ID, tableID, t.Tuple.RowID, t.PageID, t.SlotNum, oldTupleData)
-			// Set page LSN
-			if e.bufferPool != nil {
-				if p, err := e.bufferPool.FetchPage(t.PageID); err == nil {
-					p.SetLSN(lsn)
-					e.bufferPool.UnpinPage(t.PageID, true)
-				}
-			}
+		lsn := types.InvalidLSN
+		if e.walWriter != nil && !e.catalog.IsTemp(tableID) {
+			lsn = e.walWriter.LogDelete(txn.ID, tableID, t.Tuple.RowID, t.PageID, t.SlotNum, oldTupleData)
+			batch.SetLSN(t.PageID, lsn)
 		}
+		txn.RecordDelete(tableID, t.PageID, t.SlotNum, lsn)
 
 		deleted++
 	}
+	batch.Release()
+	e.endStatement(txn, autoCommit)
+	e.recordChanges(tableID, stmt.TableName, deleted)
 
 	if autoCommit {
 		e.txnManager.Commit(txn)
@@ -524,14 +1425,247 @@ func (e *Executor) executeDelete(stmt *DeleteStmt) *Result {
 		}
 	}
 
-	return &Result{Message: fmt.Sprintf("DELETE %d", deleted)}
+	return &Result{Message: fmt.Sprintf("DELETE %d", deleted), RowsAffected: deleted}
+}
+
+// executeTruncate empties a table at once instead of deleting row by row:
+// the table gets a new, empty heap and each of its indexes a new, empty
+// B-Tree. Inside a transaction the swap is undone on ROLLBACK. With RESTART
+// IDENTITY the table's SERIAL sequence is reset as well.
+//
+// The old heap and trees are no longer reachable from the catalog
+// afterwards. When the TRUNCATE commits on its own, the old index pages and
+// a temp table's heap pages go straight back to the free list; other
+// tables' heap pages hold logged records, so they are left for Repair as a
+// dropped table's are, and so is everything a transaction block replaced.
+func (e *Executor) executeTruncate(stmt *TruncateStmt) *Result {
+	if e.catalog == nil {
+		return &Result{Error: fmt.Errorf("storage not initialized")}
+	}
+
+	schema := e.catalog.GetSchema(stmt.TableName)
+	if schema == nil {
+		return &Result{Error: fmt.Errorf("table %s does not exist", stmt.TableName)}
+	}
+	tableID, _ := e.catalog.GetTableID(stmt.TableName)
+
+	inTxn := e.deferDDL()
+	txn, autoCommit := e.getTransaction()
+	txn.RecordWrite(tableID)
+
+	oldHeap, err := e.catalog.ReplaceTableHeap(tableID)
+	if err != nil {
+		if autoCommit {
+			e.abortTxn(txn)
+		}
+		return &Result{Error: fmt.Errorf("truncate %s: %w", stmt.TableName, err)}
+	}
+	oldIndexes, err := e.emptyIndexes(tableID)
+	if err != nil {
+		e.restoreIndexes(tableID, oldIndexes)
+		e.catalog.SetTableHeap(tableID, oldHeap)
+		if autoCommit {
+			e.abortTxn(txn)
+		}
+		return &Result{Error: fmt.Errorf("truncate %s: %w", stmt.TableName, err)}
+	}
+	if inTxn {
+		e.undoOnRollback(func() {
+			e.restoreIndexes(tableID, oldIndexes)
+			e.catalog.SetTableHeap(tableID, oldHeap)
+		})
+	}
+
+	if stmt.RestartIdentity {
+		e.catalog.ResetSequence(tableID)
+	}
+	// Every row is gone, so the statistics are as stale as they can be
+	if stats := e.tableStats(stmt.TableName); stats != nil {
+		e.recordChanges(tableID, stmt.TableName, int(stats.Rows))
+	}
+
+	if autoCommit {
+		e.txnManager.Commit(txn)
+		if e.bufferPool != nil {
+			// The pages can be reused once the catalog on disk no longer
+			// names them
+			e.bufferPool.FlushAllPages()
+			if e.catalog.IsTemp(tableID) {
+				oldHeap.Free()
+			}
+			for _, bt := range oldIndexes {
+				freeIndexPages(e.bufferPool, bt)
+			}
+		}
+	}
+
+	return &Result{Message: fmt.Sprintf("TRUNCATE TABLE %s", stmt.TableName)}
+}
+
+// emptyIndexes gives each index of a table a new, empty B-Tree and returns
+// the old trees by column, for restoreIndexes. On error the trees replaced
+// so far are returned along with it.
+func (e *Executor) emptyIndexes(tableID uint32) (map[string]*index.BTree, error) {
+	old := make(map[string]*index.BTree)
+	if e.indexes == nil || e.bufferPool == nil {
+		return old, nil
+	}
+	for column, bt := range e.indexes[tableID] {
+		empty, err := index.NewBTree(e.bufferPool, 64)
+		if err != nil {
+			return old, fmt.Errorf("empty index on %s: %w", column, err)
+		}
+		e.bufferPool.UnpinPermanent(bt.GetRootPageID())
+		old[column] = bt
+		e.setIndex(tableID, column, empty)
+	}
+	return old, nil
+}
+
+// freeIndexPages puts the pages of a B-Tree that is no longer used on the
+// free list.
+func freeIndexPages(bp *storage.BufferPool, bt *index.BTree) error {
+	pages, err := bt.Pages()
+	if err != nil {
+		return err
+	}
+	for _, pageID := range pages {
+		if err := bp.FreePage(pageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreIndexes puts back the trees returned by emptyIndexes.
+func (e *Executor) restoreIndexes(tableID uint32, old map[string]*index.BTree) {
+	for column, bt := range old {
+		e.bufferPool.UnpinPermanent(e.indexes[tableID][column].GetRootPageID())
+		e.bufferPool.PinPermanent(bt.GetRootPageID())
+		e.setIndex(tableID, column, bt)
+	}
+}
+
+func (e *Executor) executeComment(stmt *CommentStmt) *Result {
+	if e.catalog == nil {
+		return &Result{Error: fmt.Errorf("storage not initialized")}
+	}
+
+	schema := e.catalog.GetSchema(stmt.TableName)
+	if schema == nil {
+		return &Result{Error: fmt.Errorf("table %s does not exist", stmt.TableName)}
+	}
+	if stmt.ColumnName != "" && schemaColumn(schema, stmt.ColumnName) == nil {
+		return &Result{Error: fmt.Errorf("column %s does not exist in table %s", stmt.ColumnName, stmt.TableName)}
+	}
+
+	tableID, _ := e.catalog.GetTableID(stmt.TableName)
+	old, _ := e.catalog.GetComment(tableID, stmt.ColumnName)
+	inTxn := e.deferDDL()
+	if err := e.catalog.SetComment(tableID, stmt.ColumnName, stmt.Text); err != nil {
+		return &Result{Error: err}
+	}
+	if inTxn {
+		e.undoOnRollback(func() { e.catalog.SetComment(tableID, stmt.ColumnName, old) })
+	}
+
+	if e.bufferPool != nil && !inTxn {
+		e.bufferPool.FlushAllPages()
+	}
+
+	return &Result{Message: "COMMENT"}
 }
 
+// executeDescribe lists a table's columns with their types, nullability and
+// comments. The table comment, if any, is reported in the message.
+func (e *Executor) executeDescribe(stmt *DescribeStmt) *Result {
+	if e.catalog == nil {
+		return &Result{Error: fmt.Errorf("storage not initialized")}
+	}
+
+	schema := e.catalog.GetSchema(stmt.TableName)
+	if schema == nil {
+		return &Result{Error: fmt.Errorf("table %s does not exist", stmt.TableName)}
+	}
+	tableID, _ := e.catalog.GetTableID(stmt.TableName)
+
+	result := &Result{Columns: []string{"column", "type", "nullable", "comment"}}
+	for _, col := range schema.Columns {
+		comment := types.Value{IsNull: true}
+		if text, ok := e.catalog.GetComment(tableID, col.Name); ok {
+			comment = types.Value{Type: types.ValueTypeString, StrVal: text}
+		}
+		typeName := columnTypeName(col)
+		if col.Collation != types.CollationBinary {
+			typeName += " COLLATE " + col.Collation.String()
+		}
+		result.Rows = append(result.Rows, types.Row{Values: []types.Value{
+			{Type: types.ValueTypeString, StrVal: col.Name},
+			{Type: types.ValueTypeString, StrVal: typeName},
+			boolValue(col.Nullable),
+			comment,
+		}})
+	}
+
+	result.Message = fmt.Sprintf("TABLE %s", stmt.TableName)
+	if text, ok := e.catalog.GetComment(tableID, ""); ok {
+		result.Message += ": " + text
+	}
+	return result
+}
+
+// schemaColumn returns the named column of a schema, or nil.
+func schemaColumn(schema *types.Schema, name string) *types.Column {
+	for i := range schema.Columns {
+		if schema.Columns[i].Name == name {
+			return &schema.Columns[i]
+		}
+	}
+	return nil
+}
+
+// columnTypeName returns the SQL type name of a column.
+func columnTypeName(col types.Column) string {
+	switch {
+	case col.Serial:
+		return "SERIAL"
+	case col.Type == types.ValueTypeInt:
+		return "INT"
+	case col.Type == types.ValueTypeString:
+		return "TEXT"
+	case col.Type == types.ValueTypeBool:
+		return "BOOL"
+	case col.Type == types.ValueTypeBytes:
+		return "BLOB"
+	case col.Type == types.ValueTypeTimestamp:
+		return "TIMESTAMP"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// getTransaction returns the transaction a statement runs in and whether the
+// statement must commit it. With autocommit off, a statement outside BEGIN
+// opens a transaction that stays open until COMMIT or ROLLBACK.
 func (e *Executor) getTransaction() (*txn.Transaction, bool) {
 	if e.currentTxn != nil {
+		if e.currentTxn.Isolation == txn.ReadCommitted {
+			e.txnManager.RefreshSnapshot(e.currentTxn)
+		}
 		return e.currentTxn, false
 	}
-	return e.txnManager.Begin(), true
+	if !e.session.Autocommit {
+		e.currentTxn = e.beginTxn()
+		return e.currentTxn, false
+	}
+	return e.beginTxn(), true
+}
+
+// beginTxn starts a transaction at the session's isolation level.
+func (e *Executor) beginTxn() *txn.Transaction {
+	t := e.txnManager.Begin()
+	t.Isolation = e.session.Isolation
+	return t
 }
 
 func (e *Executor) evaluateExpr(expr Expr, rowData map[string]types.Value) types.Value {
@@ -545,11 +1679,230 @@ func (e *Executor) evaluateExpr(expr Expr, rowData map[string]types.Value) types
 			}
 		}
 		return types.Value{IsNull: true}
+	case *BinaryExpr:
+		return e.evaluateBinary(ex, rowData)
+	case *UnaryExpr:
+		return e.evaluateUnary(ex, rowData)
+	case *CollateExpr:
+		return e.evaluateExpr(ex.Expr, rowData)
+	case *IsNullExpr:
+		return boolValue(e.evaluateCondition(ex, rowData))
+	case *InExpr:
+		return e.evaluateIn(ex, rowData)
+	case *BetweenExpr:
+		return e.evaluateBetween(ex, rowData)
+	case *FuncCallExpr:
+		args := make([]types.Value, len(ex.Args))
+		for i, arg := range ex.Args {
+			args[i] = e.evaluateExpr(arg, rowData)
+		}
+		if fn, ok := builtinFuncs[ex.Name]; ok {
+			return fn.eval(args)
+		}
+		val, err := e.funcs[ex.Name](args)
+		if err != nil {
+			if e.funcErr == nil {
+				e.funcErr = fmt.Errorf("%s: %w", ex.Name, err)
+			}
+			return types.Value{IsNull: true}
+		}
+		return val
+	case *AggregateExpr:
+		// Computed per group by groupRows
+		if val, ok := rowData[exprString(ex)]; ok {
+			return val
+		}
+		return types.Value{IsNull: true}
+	default:
+		return types.Value{IsNull: true}
+	}
+}
+
+// evaluateBinary evaluates a binary expression as a value. Comparisons yield
+// BOOL, arithmetic yields INT, and a NULL operand yields NULL. AND and OR
+// follow three-valued logic instead (see evaluateLogical).
+func (e *Executor) evaluateBinary(ex *BinaryExpr, rowData map[string]types.Value) types.Value {
+	if ex.Op == TokenAnd || ex.Op == TokenOr {
+		return e.evaluateLogical(ex, rowData)
+	}
+
+	left := e.evaluateExpr(ex.Left, rowData)
+	right := e.evaluateExpr(ex.Right, rowData)
+	if left.IsNull || right.IsNull {
+		return types.Value{IsNull: true}
+	}
+
+	switch ex.Op {
+	case TokenEq, TokenNe, TokenLt, TokenLe, TokenGt, TokenGe:
+		return boolValue(e.compare(left, right, ex.Op, e.comparisonCollation(ex)))
+	}
+
+	if left.Type != types.ValueTypeInt || right.Type != types.ValueTypeInt {
+		return types.Value{IsNull: true}
+	}
+	var n int64
+	var ok bool
+	switch ex.Op {
+	case TokenPlus:
+		n, ok = addInt64(left.IntVal, right.IntVal)
+	case TokenMinus:
+		n, ok = subInt64(left.IntVal, right.IntVal)
+	case TokenStar:
+		n, ok = mulInt64(left.IntVal, right.IntVal)
+	case TokenSlash:
+		if right.IntVal == 0 {
+			return types.Value{IsNull: true}
+		}
+		n, ok = divInt64(left.IntVal, right.IntVal)
 	default:
 		return types.Value{IsNull: true}
 	}
+	if !ok {
+		return e.intOverflow()
+	}
+	return types.Value{Type: types.ValueTypeInt, IntVal: n}
 }
 
+// evaluateLogical evaluates AND or OR with NULL as UNKNOWN: FALSE AND x is
+// FALSE and TRUE OR x is TRUE whatever x is; otherwise an UNKNOWN operand
+// makes the result UNKNOWN. A non-BOOL operand counts as FALSE, as it does
+// in a WHERE clause. The distinction matters under NOT, where UNKNOWN stays
+// UNKNOWN rather than becoming TRUE.
+func (e *Executor) evaluateLogical(ex *BinaryExpr, rowData map[string]types.Value) types.Value {
+	decisive := ex.Op == TokenOr // The value that settles the result alone
+	unknown := false
+	for _, operand := range []Expr{ex.Left, ex.Right} {
+		val := e.evaluateExpr(operand, rowData)
+		switch {
+		case val.IsNull:
+			unknown = true
+		case (val.Type == types.ValueTypeBool && val.BoolVal) == decisive:
+			return boolValue(decisive)
+		}
+	}
+	if unknown {
+		return types.Value{IsNull: true}
+	}
+	return boolValue(!decisive)
+}
+
+// intOverflow records an integer overflow as the statement's evaluation
+// error and yields NULL in place of the wrapped result.
+func (e *Executor) intOverflow() types.Value {
+	if e.funcErr == nil {
+		e.funcErr = fmt.Errorf("integer out of range")
+	}
+	return types.Value{IsNull: true}
+}
+
+// addInt64, subInt64, mulInt64 and divInt64 do INT arithmetic, reporting
+// false instead of wrapping around when the result does not fit in 64 bits.
+func addInt64(a, b int64) (int64, bool) {
+	n := a + b
+	return n, (n > a) == (b > 0)
+}
+
+func subInt64(a, b int64) (int64, bool) {
+	n := a - b
+	return n, (n < a) == (b > 0)
+}
+
+func mulInt64(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	n := a * b
+	if n/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	return n, true
+}
+
+func divInt64(a, b int64) (int64, bool) {
+	if a == math.MinInt64 && b == -1 {
+		return 0, false
+	}
+	return a / b, true
+}
+
+func (e *Executor) evaluateUnary(ex *UnaryExpr, rowData map[string]types.Value) types.Value {
+	operand := e.evaluateExpr(ex.Operand, rowData)
+	if operand.IsNull {
+		return operand
+	}
+
+	switch {
+	case ex.Op == TokenNot && operand.Type == types.ValueTypeBool:
+		return boolValue(!operand.BoolVal)
+	case ex.Op == TokenMinus && operand.Type == types.ValueTypeInt:
+		n, ok := subInt64(0, operand.IntVal)
+		if !ok {
+			return e.intOverflow()
+		}
+		return types.Value{Type: types.ValueTypeInt, IntVal: n}
+	default:
+		return types.Value{IsNull: true}
+	}
+}
+
+// evaluateIn evaluates x [NOT] IN (...). An empty list is false (true for
+// NOT IN). Otherwise, as with a chain of = and OR, the result is NULL rather
+// than false when x is NULL, or when no member matches and one is NULL.
+func (e *Executor) evaluateIn(ex *InExpr, rowData map[string]types.Value) types.Value {
+	if len(ex.Values) == 0 {
+		return boolValue(ex.Not)
+	}
+	left := e.evaluateExpr(ex.Expr, rowData)
+	if left.IsNull {
+		return types.Value{IsNull: true}
+	}
+	coll := e.exprCollation(ex.Expr)
+	sawNull := false
+	for _, item := range ex.Values {
+		val := e.evaluateExpr(item, rowData)
+		if val.IsNull {
+			sawNull = true
+			continue
+		}
+		if e.compare(left, val, TokenEq, coll) {
+			return boolValue(!ex.Not)
+		}
+	}
+	if sawNull {
+		return types.Value{IsNull: true}
+	}
+	return boolValue(ex.Not)
+}
+
+// evaluateBetween evaluates x [NOT] BETWEEN low AND high, inclusive at both
+// ends, under x's collation. It is NULL when any operand is NULL; x is never
+// within bounds of another type, except that TEXT bounds of a TIMESTAMP are
+// read as timestamps.
+func (e *Executor) evaluateBetween(ex *BetweenExpr, rowData map[string]types.Value) types.Value {
+	val := e.evaluateExpr(ex.Expr, rowData)
+	low := e.evaluateExpr(ex.Low, rowData)
+	high := e.evaluateExpr(ex.High, rowData)
+	if val.IsNull || low.IsNull || high.IsNull {
+		return types.Value{IsNull: true}
+	}
+	low, _ = asColumnType(low, val.Type)
+	high, _ = asColumnType(high, val.Type)
+	in := false
+	if val.Type == low.Type && val.Type == high.Type {
+		coll := e.exprCollation(ex.Expr)
+		in = e.compare(low, val, TokenLe, coll) && e.compare(val, high, TokenLe, coll)
+	}
+	return boolValue(in != ex.Not)
+}
+
+func boolValue(b bool) types.Value {
+	return types.Value{Type: types.ValueTypeBool, BoolVal: b}
+}
+
+// evaluateCondition reports whether expr is TRUE for a row. FALSE and
+// UNKNOWN both reject it, so AND and OR at this level can combine plain
+// booleans; NOT and anything nested inside another operator go through
+// evaluateExpr, which keeps UNKNOWN apart.
 func (e *Executor) evaluateCondition(expr Expr, rowData map[string]types.Value) bool {
 	switch ex := expr.(type) {
 	case *BinaryExpr:
@@ -561,19 +1914,80 @@ func (e *Executor) evaluateCondition(expr Expr, rowData map[string]types.Value)
 		default:
 			left := e.evaluateExpr(ex.Left, rowData)
 			right := e.evaluateExpr(ex.Right, rowData)
-			return e.compare(left, right, ex.Op)
+			return e.compare(left, right, ex.Op, e.comparisonCollation(ex))
 		}
+	case *IsNullExpr:
+		return e.evaluateExpr(ex.Expr, rowData).IsNull != ex.Not
+	case *InExpr:
+		val := e.evaluateIn(ex, rowData)
+		return !val.IsNull && val.BoolVal
+	case *BetweenExpr:
+		val := e.evaluateBetween(ex, rowData)
+		return !val.IsNull && val.BoolVal
 	case *LiteralExpr:
 		return ex.Value.BoolVal
 	default:
-		return false
+		val := e.evaluateExpr(expr, rowData)
+		return !val.IsNull && val.Type == types.ValueTypeBool && val.BoolVal
+	}
+}
+
+// useCollations makes column references in the current statement compare
+// under the collations of schema's columns.
+func (e *Executor) useCollations(schema *types.Schema) {
+	e.collations = nil
+	for _, col := range schema.Columns {
+		if col.Collation == types.CollationBinary {
+			continue
+		}
+		if e.collations == nil {
+			e.collations = make(map[string]types.Collation)
+		}
+		e.collations[col.Name] = col.Collation
+	}
+}
+
+// exprCollation returns the collation an expression's value compares under:
+// that of an explicit COLLATE, or of the column it references.
+func (e *Executor) exprCollation(expr Expr) types.Collation {
+	switch ex := expr.(type) {
+	case *CollateExpr:
+		return ex.Collation
+	case *ColumnExpr:
+		return e.collations[ex.Name]
+	}
+	return types.CollationBinary
+}
+
+// comparisonCollation returns the collation a comparison uses. An explicit
+// COLLATE on either operand wins over a column's collation.
+func (e *Executor) comparisonCollation(ex *BinaryExpr) types.Collation {
+	if c, ok := ex.Left.(*CollateExpr); ok {
+		return c.Collation
 	}
+	if c, ok := ex.Right.(*CollateExpr); ok {
+		return c.Collation
+	}
+	if c := e.exprCollation(ex.Left); c != types.CollationBinary {
+		return c
+	}
+	return e.exprCollation(ex.Right)
 }
 
-func (e *Executor) compare(left, right types.Value, op TokenType) bool {
+// compare applies a comparison operator to two values under a collation.
+func (e *Executor) compare(left, right types.Value, op TokenType, coll types.Collation) bool {
 	if left.IsNull || right.IsNull {
 		return false
 	}
+	// A TIMESTAMP compares with TEXT by reading the text as a timestamp; text
+	// that is not one matches nothing
+	var errL, errR error
+	left, errL = asColumnType(left, right.Type)
+	right, errR = asColumnType(right, left.Type)
+	if errL != nil || errR != nil {
+		return false
+	}
+	left, right = coll.Key(left), coll.Key(right)
 
 	switch op {
 	case TokenEq:
@@ -598,81 +2012,39 @@ func (e *Executor) valuesEqual(left, right types.Value) bool {
 		return false
 	}
 	switch left.Type {
-	case types.ValueTypeInt:
+	case types.ValueTypeInt, types.ValueTypeTimestamp:
 		return left.IntVal == right.IntVal
 	case types.ValueTypeString:
 		return left.StrVal == right.StrVal
 	case types.ValueTypeBool:
 		return left.BoolVal == right.BoolVal
+	case types.ValueTypeBytes:
+		return bytes.Equal(left.BytesVal, right.BytesVal)
 	default:
 		return false
 	}
 }
 
+// compareLess orders two values of the same type. BOOL orders false < true,
+// BLOB compares bytewise and TIMESTAMP chronologically.
 func (e *Executor) compareLess(left, right types.Value) bool {
 	if left.Type != right.Type {
 		return false
 	}
 	switch left.Type {
-	case types.ValueTypeInt:
+	case types.ValueTypeInt, types.ValueTypeTimestamp:
 		return left.IntVal < right.IntVal
 	case types.ValueTypeString:
 		return left.StrVal < right.StrVal
+	case types.ValueTypeBool:
+		return !left.BoolVal && right.BoolVal
+	case types.ValueTypeBytes:
+		return bytes.Compare(left.BytesVal, right.BytesVal) < 0
 	default:
 		return false
 	}
 }
 
-// tryIndexLookup attempts to use an index for a WHERE column = literal expression.
-// Returns the matching rows and true if the index was used, or nil and false otherwise.
-func (e *Executor) tryIndexLookup(tableID uint32, schema *types.Schema, heap *storage.TableHeap, where Expr, txn *txn.Transaction) ([]map[string]types.Value, bool) {
-	bt, ok := e.indexes[tableID]
-	if !ok {
-		return nil, false
-	}
-
-	colName, ok := e.catalog.GetIndexColumn(tableID)
-	if !ok {
-		return nil, false
-	}
-
-	// Check if WHERE is column = literal
-	binExpr, ok := where.(*BinaryExpr)
-	if !ok || binExpr.Op != TokenEq {
-		return nil, false
-	}
-
-	colExpr, okCol := binExpr.Left.(*ColumnExpr)
-	litExpr, okLit := binExpr.Right.(*LiteralExpr)
-	if !okCol || !okLit || colExpr.Name != colName {
-		return nil, false
-	}
-
-	key := index.EncodeKey(litExpr.Value, 64)
-	rid, found := bt.Search(key)
-	if !found {
-		return nil, true // index used, no results
-	}
-
-	// Fetch tuple by RID
-	tuple, err := heap.Get(rid.PageID, rid.SlotNum)
-	if err != nil {
-		return nil, false // fallback to scan
-	}
-
-	// MVCC visibility check
-	if !txn.Snapshot.IsVisible(tuple) {
-		return nil, false // stale index entry, fallback to scan
-	}
-
-	rowData, err := types.DeserializeRow(schema, tuple.Data)
-	if err != nil {
-		return nil, false
-	}
-
-	return []map[string]types.Value{rowData}, true
-}
-
 // HasTransaction returns true if there's an active transaction.
 func (e *Executor) HasTransaction() bool {
 	return e.currentTxn != nil
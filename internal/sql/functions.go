@@ -0,0 +1,118 @@
+package sql
+
+import (
+	"fmt"
+	"minidb/pkg/types"
+	"strings"
+)
+
+// builtinFunc describes a scalar function callable from SQL.
+type builtinFunc struct {
+	minArgs int
+	maxArgs int // -1 for variadic
+	eval    func(args []types.Value) types.Value
+}
+
+// builtinFuncs maps upper-cased function names to their implementations.
+var builtinFuncs = map[string]builtinFunc{
+	"UPPER":    {1, 1, funcUpper},
+	"LOWER":    {1, 1, funcLower},
+	"LENGTH":   {1, 1, funcLength},
+	"ABS":      {1, 1, funcAbs},
+	"SUBSTR":   {2, 3, funcSubstr},
+	"COALESCE": {1, -1, funcCoalesce},
+}
+
+// Func is a scalar function registered from Go with RegisterFunction. It
+// receives the evaluated arguments and checks their number and types itself;
+// an error fails the statement that called it.
+type Func func(args []types.Value) (types.Value, error)
+
+// checkFuncCall verifies that a call names a known function with a valid
+// number of arguments. Built-ins take precedence over registered functions,
+// whose arguments are left for the function to check.
+func checkFuncCall(call *FuncCallExpr, funcs map[string]Func) error {
+	fn, ok := builtinFuncs[call.Name]
+	if !ok {
+		if _, ok := funcs[call.Name]; ok {
+			return nil
+		}
+		return fmt.Errorf("unknown function %s", call.Name)
+	}
+	if len(call.Args) < fn.minArgs || (fn.maxArgs >= 0 && len(call.Args) > fn.maxArgs) {
+		return fmt.Errorf("wrong number of arguments to %s: %d", call.Name, len(call.Args))
+	}
+	return nil
+}
+
+var nullValue = types.Value{Type: types.ValueTypeNull, IsNull: true}
+
+func funcUpper(args []types.Value) types.Value {
+	if args[0].IsNull || args[0].Type != types.ValueTypeString {
+		return nullValue
+	}
+	return types.Value{Type: types.ValueTypeString, StrVal: strings.ToUpper(args[0].StrVal)}
+}
+
+func funcLower(args []types.Value) types.Value {
+	if args[0].IsNull || args[0].Type != types.ValueTypeString {
+		return nullValue
+	}
+	return types.Value{Type: types.ValueTypeString, StrVal: strings.ToLower(args[0].StrVal)}
+}
+
+func funcLength(args []types.Value) types.Value {
+	if args[0].IsNull || args[0].Type != types.ValueTypeString {
+		return nullValue
+	}
+	return types.Value{Type: types.ValueTypeInt, IntVal: int64(len([]rune(args[0].StrVal)))}
+}
+
+func funcAbs(args []types.Value) types.Value {
+	if args[0].IsNull || args[0].Type != types.ValueTypeInt {
+		return nullValue
+	}
+	v := args[0].IntVal
+	if v < 0 {
+		v = -v
+	}
+	return types.Value{Type: types.ValueTypeInt, IntVal: v}
+}
+
+// funcSubstr implements SUBSTR(s, start [, length]) with a 1-based start.
+func funcSubstr(args []types.Value) types.Value {
+	for _, arg := range args {
+		if arg.IsNull {
+			return nullValue
+		}
+	}
+	if args[0].Type != types.ValueTypeString || args[1].Type != types.ValueTypeInt {
+		return nullValue
+	}
+
+	runes := []rune(args[0].StrVal)
+	start := args[1].IntVal - 1
+	end := int64(len(runes))
+	if len(args) == 3 {
+		if args[2].Type != types.ValueTypeInt {
+			return nullValue
+		}
+		end = start + args[2].IntVal
+	}
+
+	start = max(start, 0)
+	end = min(end, int64(len(runes)))
+	if start >= end {
+		return types.Value{Type: types.ValueTypeString}
+	}
+	return types.Value{Type: types.ValueTypeString, StrVal: string(runes[start:end])}
+}
+
+func funcCoalesce(args []types.Value) types.Value {
+	for _, arg := range args {
+		if !arg.IsNull {
+			return arg
+		}
+	}
+	return nullValue
+}
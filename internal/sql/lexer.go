@@ -2,6 +2,7 @@
 package sql
 
 import (
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"unicode"
@@ -36,11 +37,43 @@ const (
 	TokenInt
 	TokenText
 	TokenBool
+	TokenBlob
+	TokenTimestamp
+	TokenSerial
+	TokenTruncate
+	TokenComment
+	TokenOn
+	TokenIs
+	TokenDescribe
+	TokenDefault
+	TokenUnique
+	TokenPrimary
+	TokenShow
+	TokenOrder
+	TokenBy
+	TokenAs
+	TokenAsc
+	TokenDesc
+	TokenExplain
+	TokenGroup
+	TokenHaving
+	TokenCopy
+	TokenLimit
+	TokenOffset
+	TokenCollate
+	TokenIn
+	TokenBetween
+	TokenAnalyze
+	TokenSavepoint
+	TokenRelease
+	TokenFor
 	
 	// Literals
 	TokenIdent
+	TokenHint // /*+ ... */ optimizer hint
 	TokenNumber
 	TokenString
+	TokenHex // x'00FF' BLOB literal
 	TokenTrue
 	TokenFalse
 	
@@ -51,6 +84,9 @@ const (
 	TokenLe        // <=
 	TokenGt        // >
 	TokenGe        // >=
+	TokenPlus      // +
+	TokenMinus     // -
+	TokenSlash     // /
 	
 	// Punctuation
 	TokenComma     // ,
@@ -58,6 +94,7 @@ const (
 	TokenRParen    // )
 	TokenStar      // *
 	TokenSemicolon // ;
+	TokenDot       // .
 )
 
 var tokenNames = map[TokenType]string{
@@ -84,9 +121,41 @@ var tokenNames = map[TokenType]string{
 	TokenInt:       "INT",
 	TokenText:      "TEXT",
 	TokenBool:      "BOOL",
+	TokenBlob:      "BLOB",
+	TokenTimestamp: "TIMESTAMP",
+	TokenSerial:    "SERIAL",
+	TokenTruncate:  "TRUNCATE",
+	TokenComment:   "COMMENT",
+	TokenOn:        "ON",
+	TokenIs:        "IS",
+	TokenDescribe:  "DESCRIBE",
+	TokenDefault:   "DEFAULT",
+	TokenUnique:    "UNIQUE",
+	TokenPrimary:   "PRIMARY",
+	TokenShow:      "SHOW",
+	TokenOrder:     "ORDER",
+	TokenBy:        "BY",
+	TokenAs:        "AS",
+	TokenAsc:       "ASC",
+	TokenDesc:      "DESC",
+	TokenExplain:   "EXPLAIN",
+	TokenGroup:     "GROUP",
+	TokenHaving:    "HAVING",
+	TokenCopy:      "COPY",
+	TokenLimit:     "LIMIT",
+	TokenOffset:    "OFFSET",
+	TokenCollate:   "COLLATE",
+	TokenIn:        "IN",
+	TokenBetween:   "BETWEEN",
+	TokenAnalyze:   "ANALYZE",
+	TokenSavepoint: "SAVEPOINT",
+	TokenRelease:   "RELEASE",
+	TokenFor:       "FOR",
 	TokenIdent:     "IDENT",
+	TokenHint:      "HINT",
 	TokenNumber:    "NUMBER",
 	TokenString:    "STRING",
+	TokenHex:       "HEX",
 	TokenTrue:      "TRUE",
 	TokenFalse:     "FALSE",
 	TokenEq:        "=",
@@ -95,11 +164,15 @@ var tokenNames = map[TokenType]string{
 	TokenLe:        "<=",
 	TokenGt:        ">",
 	TokenGe:        ">=",
+	TokenPlus:      "+",
+	TokenMinus:     "-",
+	TokenSlash:     "/",
 	TokenComma:     ",",
 	TokenLParen:    "(",
 	TokenRParen:    ")",
 	TokenStar:      "*",
 	TokenSemicolon: ";",
+	TokenDot:       ".",
 }
 
 func (t TokenType) String() string {
@@ -143,6 +216,36 @@ var keywords = map[string]TokenType{
 	"INT":      TokenInt,
 	"TEXT":     TokenText,
 	"BOOL":     TokenBool,
+	"BLOB":     TokenBlob,
+	"TIMESTAMP": TokenTimestamp,
+	"SERIAL":   TokenSerial,
+	"TRUNCATE": TokenTruncate,
+	"COMMENT":  TokenComment,
+	"ON":       TokenOn,
+	"IS":       TokenIs,
+	"DESCRIBE": TokenDescribe,
+	"DEFAULT":  TokenDefault,
+	"UNIQUE":   TokenUnique,
+	"PRIMARY":  TokenPrimary,
+	"SHOW":     TokenShow,
+	"ORDER":    TokenOrder,
+	"BY":       TokenBy,
+	"AS":       TokenAs,
+	"ASC":      TokenAsc,
+	"DESC":     TokenDesc,
+	"EXPLAIN":  TokenExplain,
+	"GROUP":    TokenGroup,
+	"HAVING":   TokenHaving,
+	"COPY":     TokenCopy,
+	"LIMIT":    TokenLimit,
+	"OFFSET":   TokenOffset,
+	"COLLATE":  TokenCollate,
+	"IN":       TokenIn,
+	"BETWEEN":  TokenBetween,
+	"ANALYZE":  TokenAnalyze,
+	"SAVEPOINT": TokenSavepoint,
+	"RELEASE":  TokenRelease,
+	"FOR":      TokenFor,
 	"TRUE":     TokenTrue,
 	"FALSE":    TokenFalse,
 }
@@ -177,15 +280,74 @@ func (l *Lexer) peek() byte {
 	return l.input[l.pos]
 }
 
+// peekAt returns the character n positions after the current one.
+func (l *Lexer) peekAt(n int) byte {
+	if l.pos+n-1 >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+n-1]
+}
+
 func (l *Lexer) skipWhitespace() {
 	for l.ch != 0 && unicode.IsSpace(rune(l.ch)) {
 		l.advance()
 	}
 }
 
+func (l *Lexer) skipLineComment() {
+	for l.ch != 0 && l.ch != '\n' {
+		l.advance()
+	}
+}
+
+// readBlockComment consumes a /* ... */ comment. It returns a token and true
+// for a hint comment or an unterminated comment, and false for a plain
+// comment that should be skipped.
+func (l *Lexer) readBlockComment() (Token, bool) {
+	startPos := l.pos - 1
+	l.advance() // skip '/'
+	l.advance() // skip '*'
+	
+	hint := l.ch == '+'
+	if hint {
+		l.advance()
+	}
+	
+	start := l.pos - 1
+	for l.ch != 0 && !(l.ch == '*' && l.peek() == '/') {
+		l.advance()
+	}
+	if l.ch == 0 {
+		return Token{Type: TokenError, Literal: "unterminated comment", Pos: startPos}, true
+	}
+	text := l.input[start : l.pos-1]
+	l.advance() // skip '*'
+	l.advance() // skip '/'
+	
+	if !hint {
+		return Token{}, false
+	}
+	return Token{Type: TokenHint, Literal: strings.TrimSpace(text), Pos: startPos}, true
+}
+
 // NextToken returns the next token from the input.
 func (l *Lexer) NextToken() Token {
-	l.skipWhitespace()
+	// Comments: "-- ..." to end of line and "/* ... */" are skipped;
+	// "/*+ ... */" is an optimizer hint and becomes a token
+	for {
+		l.skipWhitespace()
+		if l.ch == '-' && l.peek() == '-' {
+			l.skipLineComment()
+			continue
+		}
+		if l.ch == '/' && l.peek() == '*' {
+			if tok, ok := l.readBlockComment(); ok {
+				return tok
+			}
+			continue
+		}
+		break
+	}
 	
 	startPos := l.pos - 1
 	
@@ -210,6 +372,9 @@ func (l *Lexer) NextToken() Token {
 	case ';':
 		l.advance()
 		return Token{Type: TokenSemicolon, Literal: ";", Pos: startPos}
+	case '.':
+		l.advance()
+		return Token{Type: TokenDot, Literal: ".", Pos: startPos}
 	case '=':
 		l.advance()
 		return Token{Type: TokenEq, Literal: "=", Pos: startPos}
@@ -238,14 +403,31 @@ func (l *Lexer) NextToken() Token {
 			return Token{Type: TokenNe, Literal: "!=", Pos: startPos}
 		}
 		return Token{Type: TokenError, Literal: "!", Pos: startPos}
+	case '+':
+		l.advance()
+		return Token{Type: TokenPlus, Literal: "+", Pos: startPos}
+	case '/':
+		l.advance()
+		return Token{Type: TokenSlash, Literal: "/", Pos: startPos}
 	case '\'':
 		return l.readString()
+	case '"':
+		return l.readQuotedIdentifier()
 	}
 	
 	// Numbers
 	if unicode.IsDigit(rune(l.ch)) || (l.ch == '-' && unicode.IsDigit(rune(l.peek()))) {
 		return l.readNumber()
 	}
+	if l.ch == '-' {
+		l.advance()
+		return Token{Type: TokenMinus, Literal: "-", Pos: startPos}
+	}
+	
+	// Hex strings: x'00FF'
+	if (l.ch == 'x' || l.ch == 'X') && l.peek() == '\'' {
+		return l.readHex()
+	}
 	
 	// Identifiers and keywords
 	if unicode.IsLetter(rune(l.ch)) || l.ch == '_' {
@@ -275,6 +457,53 @@ func (l *Lexer) readString() Token {
 	return Token{Type: TokenString, Literal: literal, Pos: startPos}
 }
 
+// readQuotedIdentifier reads a "..." identifier. It is an identifier even
+// when it spells a keyword, keeps its case, and "" inside stands for one
+// double quote.
+func (l *Lexer) readQuotedIdentifier() Token {
+	startPos := l.pos - 1
+	l.advance() // skip opening quote
+
+	var sb strings.Builder
+	for {
+		if l.ch == 0 {
+			return Token{Type: TokenError, Literal: "unterminated quoted identifier", Pos: startPos}
+		}
+		if l.ch == '"' {
+			if l.peek() != '"' {
+				break
+			}
+			l.advance() // first of the doubled quote
+		}
+		sb.WriteByte(l.ch)
+		l.advance()
+	}
+	l.advance() // skip closing quote
+
+	if sb.Len() == 0 {
+		return Token{Type: TokenError, Literal: "zero-length quoted identifier", Pos: startPos}
+	}
+	return Token{Type: TokenIdent, Literal: sb.String(), Pos: startPos}
+}
+
+// readHex reads a x'...' BLOB literal. Its literal is the hex digits; an
+// odd number of digits, or anything but a hex digit, is an error token.
+func (l *Lexer) readHex() Token {
+	startPos := l.pos - 1
+	l.advance() // skip 'x'
+	tok := l.readString()
+	tok.Pos = startPos
+	if _, err := hex.DecodeString(tok.Literal); err != nil {
+		return Token{Type: TokenError, Literal: "malformed hex string x'" + tok.Literal + "'", Pos: startPos}
+	}
+	tok.Type = TokenHex
+	return tok
+}
+
+// readNumber reads an integer or decimal number: an optional '-', digits,
+// an optional fraction and an optional exponent ("12", "-1.5", "2e10"). A
+// number running straight into letters, digits or another '.' ("123abc",
+// "1.2.3", "1e") is an error token naming the malformed text.
 func (l *Lexer) readNumber() Token {
 	startPos := l.pos - 1
 	start := l.pos - 1
@@ -282,14 +511,43 @@ func (l *Lexer) readNumber() Token {
 	if l.ch == '-' {
 		l.advance()
 	}
+	l.readDigits()
 	
-	for unicode.IsDigit(rune(l.ch)) {
+	if l.ch == '.' && unicode.IsDigit(rune(l.peek())) {
 		l.advance()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peek()
+		if unicode.IsDigit(rune(next)) || ((next == '+' || next == '-') && unicode.IsDigit(rune(l.peekAt(2)))) {
+			l.advance()
+			if l.ch == '+' || l.ch == '-' {
+				l.advance()
+			}
+			l.readDigits()
+		}
 	}
 	
+	if isNumberTail(l.ch) {
+		for isNumberTail(l.ch) {
+			l.advance()
+		}
+		return Token{Type: TokenError, Literal: "malformed number " + l.input[start:l.pos-1], Pos: startPos}
+	}
 	return Token{Type: TokenNumber, Literal: l.input[start : l.pos-1], Pos: startPos}
 }
 
+func (l *Lexer) readDigits() {
+	for unicode.IsDigit(rune(l.ch)) {
+		l.advance()
+	}
+}
+
+// isNumberTail reports whether ch may not directly follow a number.
+func isNumberTail(ch byte) bool {
+	return unicode.IsLetter(rune(ch)) || unicode.IsDigit(rune(ch)) || ch == '_' || ch == '.'
+}
+
 func (l *Lexer) readIdentifier() Token {
 	startPos := l.pos - 1
 	start := l.pos - 1
@@ -323,3 +581,34 @@ func Tokenize(input string) []Token {
 	
 	return tokens
 }
+
+// SplitStatements splits a script at the semicolons that end its
+// statements and returns their texts in order, trimmed. A semicolon inside
+// a string literal, quoted identifier or comment does not split, and
+// pieces holding nothing but whitespace and comments are left out. Input
+// the lexer rejects is kept whole from the statement it occurs in, so that
+// parsing that statement reports the error.
+func SplitStatements(script string) []string {
+	var stmts []string
+	lexer := NewLexer(script)
+	start := 0
+	empty := true
+	for {
+		tok := lexer.NextToken()
+		switch tok.Type {
+		case TokenSemicolon:
+			if !empty {
+				stmts = append(stmts, strings.TrimSpace(script[start:tok.Pos]))
+			}
+			start = tok.Pos + 1
+			empty = true
+			continue
+		case TokenEOF, TokenError:
+			if !empty || tok.Type == TokenError {
+				stmts = append(stmts, strings.TrimSpace(script[start:]))
+			}
+			return stmts
+		}
+		empty = false
+	}
+}
@@ -0,0 +1,426 @@
+package sql
+
+import (
+	"fmt"
+	"minidb/internal/storage"
+	"minidb/internal/txn"
+	"minidb/pkg/types"
+)
+
+// execRow is a row flowing through an operator tree.
+type execRow struct {
+	values map[string]types.Value // Column values by name; aggregate results by SQL text after hashAggregateOp
+	tuple  *storage.TupleWithRID  // Stored version the row was read from; nil once rows are grouped
+	out    types.Row              // Select-list values, set by projectOp
+}
+
+// operator is a node of a query's execution tree in the iterator (Volcano)
+// model. Open prepares it, each Next returns the next row or nil once the
+// input is exhausted, and Close releases it. A parent pulls rows from its
+// children one at a time, so an operator that needs no more input, such as
+// limitOp, stops reading its child early. Blocking operators (sortOp,
+// hashAggregateOp) read their whole input in Open.
+type operator interface {
+	Open() error
+	Next() (*execRow, error)
+	Close()
+}
+
+// drain runs an operator tree to completion and returns its rows.
+func drain(op operator) ([]*execRow, error) {
+	if err := op.Open(); err != nil {
+		return nil, err
+	}
+	defer op.Close()
+
+	var rows []*execRow
+	for {
+		row, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+}
+
+// seqScanOp returns the versions of a table's rows visible to a transaction.
+// By default the table is scanned when the operator opens, so rows the
+// statement itself writes later are not seen. A streaming scan, for
+// statements that only read, instead walks the heap as rows are asked for,
+// holding one page pinned at a time.
+type seqScanOp struct {
+	heap   *storage.TableHeap
+	schema *types.Schema
+	txn    *txn.Transaction
+	stream bool
+	tuples []*storage.TupleWithRID
+	pos    int
+	iter   *storage.HeapIterator // Streaming scans only
+}
+
+func (s *seqScanOp) Open() error {
+	if s.stream {
+		s.iter = s.heap.Iterator()
+		return nil
+	}
+	tuples, err := s.heap.Scan()
+	if err != nil {
+		return fmt.Errorf("scan failed: %w", err)
+	}
+	s.tuples, s.pos = tuples, 0
+	return nil
+}
+
+func (s *seqScanOp) Next() (*execRow, error) {
+	for {
+		t, err := s.nextTuple()
+		if t == nil || err != nil {
+			return nil, err
+		}
+		if !visibleToTxn(s.txn, t.Tuple) {
+			continue
+		}
+		rowData, err := types.DeserializeRow(s.schema, t.Tuple.Data)
+		if err != nil {
+			continue
+		}
+		return &execRow{values: rowData, tuple: t}, nil
+	}
+}
+
+// nextTuple returns the next stored version, visible or not, or nil at the
+// end of the table.
+func (s *seqScanOp) nextTuple() (*storage.TupleWithRID, error) {
+	if s.iter != nil {
+		t, err := s.iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		return t, nil
+	}
+	if s.pos >= len(s.tuples) {
+		return nil, nil
+	}
+	s.pos++
+	return s.tuples[s.pos-1], nil
+}
+
+func (s *seqScanOp) Close() {
+	if s.iter != nil {
+		s.iter.Close()
+		s.iter = nil
+	}
+	s.tuples = nil
+}
+
+// indexScanOp reads a table through its index: the row with an equality
+// key, the rows in a key range (see indexRangeScan), or a page of rows in
+// key order (see indexOrderedScan). When the index cannot answer for this
+// snapshot, the fallback tree runs instead; it must produce the same rows,
+// so for an ordered scan it sorts and applies the page bounds itself.
+type indexScanOp struct {
+	e        *Executor
+	tableID  uint32
+	schema   *types.Schema
+	heap     *storage.TableHeap
+	txn      *txn.Transaction
+	plan     scanPlan
+	offset   int64
+	limit    int64 // Ordered scans only; negative for no limit
+	fallback operator
+
+	rows        []map[string]types.Value
+	pos         int
+	useFallback bool
+}
+
+func (s *indexScanOp) Open() error {
+	var used bool
+	switch s.plan.path {
+	case pathIndexOrderScan:
+		s.rows, used = s.e.indexOrderedScan(s.tableID, s.plan.column, s.schema, s.heap, s.plan.desc, s.offset, s.limit, s.txn)
+	case pathIndexRangeScan:
+		s.rows, used = s.e.indexRangeScan(s.tableID, s.plan.column, s.schema, s.heap, s.plan.start, s.plan.end, s.txn)
+	default:
+		s.rows, used = s.e.indexLookup(s.tableID, s.plan.column, s.schema, s.heap, s.plan.key, s.txn)
+	}
+	s.pos, s.useFallback = 0, !used
+	if s.useFallback {
+		return s.fallback.Open()
+	}
+	return nil
+}
+
+func (s *indexScanOp) Next() (*execRow, error) {
+	if s.useFallback {
+		return s.fallback.Next()
+	}
+	if s.pos >= len(s.rows) {
+		return nil, nil
+	}
+	s.pos++
+	return &execRow{values: s.rows[s.pos-1]}, nil
+}
+
+func (s *indexScanOp) Close() {
+	if s.useFallback {
+		s.fallback.Close()
+	}
+	s.rows = nil
+}
+
+// resultOp produces the single empty row a FROM-less SELECT is evaluated
+// against.
+type resultOp struct {
+	done bool
+}
+
+func (r *resultOp) Open() error {
+	r.done = false
+	return nil
+}
+
+func (r *resultOp) Next() (*execRow, error) {
+	if r.done {
+		return nil, nil
+	}
+	r.done = true
+	return &execRow{values: make(map[string]types.Value)}, nil
+}
+
+func (r *resultOp) Close() {}
+
+// filterOp passes on the rows for which cond is true.
+type filterOp struct {
+	e     *Executor
+	child operator
+	cond  Expr
+}
+
+func (f *filterOp) Open() error {
+	return f.child.Open()
+}
+
+func (f *filterOp) Next() (*execRow, error) {
+	for {
+		row, err := f.child.Next()
+		if row == nil || err != nil {
+			return nil, err
+		}
+		match := f.e.evaluateCondition(f.cond, row.values)
+		if err := f.e.takeFuncErr(); err != nil {
+			return nil, err
+		}
+		if match {
+			return row, nil
+		}
+	}
+}
+
+func (f *filterOp) Close() {
+	f.child.Close()
+}
+
+// projectOp evaluates the select list for each row.
+type projectOp struct {
+	e     *Executor
+	child operator
+	exprs []Expr
+}
+
+func (p *projectOp) Open() error {
+	return p.child.Open()
+}
+
+func (p *projectOp) Next() (*execRow, error) {
+	row, err := p.child.Next()
+	if row == nil || err != nil {
+		return nil, err
+	}
+	row.out = p.e.projectRow(p.exprs, row.values)
+	if err := p.e.takeFuncErr(); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+func (p *projectOp) Close() {
+	p.child.Close()
+}
+
+// sortOp reads all of its input and returns it ordered by the ORDER BY keys.
+type sortOp struct {
+	e     *Executor
+	child operator
+	keys  []orderKey
+	rows  []*execRow
+	pos   int
+}
+
+func (s *sortOp) Open() error {
+	rows, err := drain(s.child)
+	if err != nil {
+		return err
+	}
+	s.e.sortRows(rows, s.keys)
+	s.rows, s.pos = rows, 0
+	return nil
+}
+
+func (s *sortOp) Next() (*execRow, error) {
+	if s.pos >= len(s.rows) {
+		return nil, nil
+	}
+	s.pos++
+	return s.rows[s.pos-1], nil
+}
+
+func (s *sortOp) Close() {
+	s.rows = nil
+}
+
+// limitOp skips the first offset rows and returns at most limit rows (all
+// remaining rows when limit is negative). Once the limit is reached it stops
+// pulling from its child.
+type limitOp struct {
+	child    operator
+	offset   int64
+	limit    int64
+	skipped  int64
+	returned int64
+}
+
+func (l *limitOp) Open() error {
+	l.skipped, l.returned = 0, 0
+	return l.child.Open()
+}
+
+func (l *limitOp) Next() (*execRow, error) {
+	if l.limit >= 0 && l.returned >= l.limit {
+		return nil, nil
+	}
+	for l.skipped < l.offset {
+		row, err := l.child.Next()
+		if row == nil || err != nil {
+			return nil, err
+		}
+		l.skipped++
+	}
+	row, err := l.child.Next()
+	if row == nil || err != nil {
+		return nil, err
+	}
+	l.returned++
+	return row, nil
+}
+
+func (l *limitOp) Close() {
+	l.child.Close()
+}
+
+// hashAggregateOp reads all of its input and returns one row per group (see
+// groupRows).
+type hashAggregateOp struct {
+	e       *Executor
+	child   operator
+	groupBy []Expr
+	aggs    []*AggregateExpr
+	rows    []map[string]types.Value
+	pos     int
+}
+
+func (h *hashAggregateOp) Open() error {
+	input, err := drain(h.child)
+	if err != nil {
+		return err
+	}
+	values := make([]map[string]types.Value, len(input))
+	for i, row := range input {
+		values[i] = row.values
+	}
+	h.rows, h.pos = h.e.groupRows(h.groupBy, h.aggs, values), 0
+	return nil
+}
+
+func (h *hashAggregateOp) Next() (*execRow, error) {
+	if h.pos >= len(h.rows) {
+		return nil, nil
+	}
+	h.pos++
+	return &execRow{values: h.rows[h.pos-1]}, nil
+}
+
+func (h *hashAggregateOp) Close() {
+	h.rows = nil
+}
+
+// nestedLoopJoinOp joins every outer row with every inner row for which
+// cond is true (all of them when cond is nil), reopening the inner tree for
+// each outer row. Column names are not qualified, so the joined row holds
+// the inner row's value for a name both sides have.
+type nestedLoopJoinOp struct {
+	e     *Executor
+	outer operator
+	inner operator
+	cond  Expr
+	cur   *execRow // Outer row being joined; nil between outer rows
+}
+
+func (j *nestedLoopJoinOp) Open() error {
+	j.cur = nil
+	return j.outer.Open()
+}
+
+func (j *nestedLoopJoinOp) Next() (*execRow, error) {
+	for {
+		if j.cur == nil {
+			outer, err := j.outer.Next()
+			if outer == nil || err != nil {
+				return nil, err
+			}
+			if err := j.inner.Open(); err != nil {
+				return nil, err
+			}
+			j.cur = outer
+		}
+
+		inner, err := j.inner.Next()
+		if err != nil {
+			return nil, err
+		}
+		if inner == nil {
+			j.inner.Close()
+			j.cur = nil
+			continue
+		}
+
+		joined := make(map[string]types.Value, len(j.cur.values)+len(inner.values))
+		for name, val := range j.cur.values {
+			joined[name] = val
+		}
+		for name, val := range inner.values {
+			joined[name] = val
+		}
+		if j.cond == nil {
+			return &execRow{values: joined}, nil
+		}
+		match := j.e.evaluateCondition(j.cond, joined)
+		if err := j.e.takeFuncErr(); err != nil {
+			return nil, err
+		}
+		if match {
+			return &execRow{values: joined}, nil
+		}
+	}
+}
+
+func (j *nestedLoopJoinOp) Close() {
+	if j.cur != nil {
+		j.inner.Close()
+		j.cur = nil
+	}
+	j.outer.Close()
+}
@@ -1,9 +1,11 @@
 package sql
 
 import (
+	"encoding/hex"
 	"fmt"
 	"minidb/pkg/types"
 	"strconv"
+	"strings"
 )
 
 // Statement represents a parsed SQL statement.
@@ -13,18 +15,63 @@ type Statement interface {
 
 // SelectStmt represents a SELECT statement.
 type SelectStmt struct {
-	Columns   []string // Column names or "*"
-	TableName string
+	Columns   []string // Output column names or "*"
+	Exprs     []Expr   // Select-list expressions (nil for "*")
+	Aliases   []string // Per select-list expression, "" unless named with AS
+	TableName string   // Empty when FROM is omitted
+	Into      string   // Table created from the result by SELECT ... INTO
 	Where     Expr
+	GroupBy   []Expr
+	Having    Expr // Filters the groups; may call aggregates
+	OrderBy   []OrderByItem
+	Limit     *int64    // Nil without LIMIT
+	Offset    int64     // Rows skipped before the first one returned
+	Hint      *ScanHint // From a /*+ ... */ comment after SELECT
+	ForUpdate bool      // FOR UPDATE: lock the returned rows until the transaction ends
 }
 
 func (s *SelectStmt) statementNode() {}
 
+// ScanHintKind is the access path a hint forces.
+type ScanHintKind int
+
+const (
+	HintSeqScan   ScanHintKind = iota // /*+ seqscan */
+	HintIndexScan                     // /*+ indexscan */ or /*+ index(name) */
+)
+
+// ScanHint overrides the planner's choice of access path.
+type ScanHint struct {
+	Kind  ScanHintKind
+	Index string // Index name for index(name); empty for any index
+}
+
+// ExplainStmt represents EXPLAIN SELECT ...
+type ExplainStmt struct {
+	Select *SelectStmt
+}
+
+func (s *ExplainStmt) statementNode() {}
+
+// OrderByItem is one ORDER BY key. An integer literal refers to a select-list
+// position (1-based) instead of a constant.
+type OrderByItem struct {
+	Expr Expr
+	Desc bool
+}
+
+// ValuesStmt represents a standalone VALUES list of literal rows.
+type ValuesStmt struct {
+	Rows [][]Expr
+}
+
+func (s *ValuesStmt) statementNode() {}
+
 // InsertStmt represents an INSERT statement.
 type InsertStmt struct {
 	TableName string
 	Columns   []string
-	Values    []Expr
+	Values    [][]Expr // One tuple per row
 }
 
 func (s *InsertStmt) statementNode() {}
@@ -47,7 +94,9 @@ type DeleteStmt struct {
 func (s *DeleteStmt) statementNode() {}
 
 // BeginStmt represents a BEGIN statement.
-type BeginStmt struct{}
+type BeginStmt struct {
+	Isolation *IsolationLevel // nil uses the session's level
+}
 
 func (s *BeginStmt) statementNode() {}
 
@@ -61,19 +110,102 @@ type RollbackStmt struct{}
 
 func (s *RollbackStmt) statementNode() {}
 
+// SavepointStmt represents a SAVEPOINT statement.
+type SavepointStmt struct {
+	Name string
+}
+
+func (s *SavepointStmt) statementNode() {}
+
+// RollbackToStmt represents a ROLLBACK TO [SAVEPOINT] statement.
+type RollbackToStmt struct {
+	Name string
+}
+
+func (s *RollbackToStmt) statementNode() {}
+
+// ReleaseStmt represents a RELEASE [SAVEPOINT] statement.
+type ReleaseStmt struct {
+	Name string
+}
+
+func (s *ReleaseStmt) statementNode() {}
+
 // CreateTableStmt represents a CREATE TABLE statement.
 type CreateTableStmt struct {
 	TableName string
 	Columns   []ColumnDef
+	Temp      bool // CREATE TEMP TABLE: dropped when the transaction ends
 }
 
 func (s *CreateTableStmt) statementNode() {}
 
+// TruncateStmt represents a TRUNCATE statement.
+type TruncateStmt struct {
+	TableName       string
+	RestartIdentity bool // RESTART IDENTITY resets SERIAL sequences
+}
+
+func (s *TruncateStmt) statementNode() {}
+
+// AnalyzeStmt represents ANALYZE, which gathers planner statistics.
+type AnalyzeStmt struct {
+	TableName string // Empty analyzes every table
+}
+
+func (s *AnalyzeStmt) statementNode() {}
+
+// CommentStmt represents COMMENT ON TABLE / COMMENT ON COLUMN.
+type CommentStmt struct {
+	TableName  string
+	ColumnName string // Empty for a table comment
+	Text       string // Empty removes the comment (IS NULL)
+}
+
+func (s *CommentStmt) statementNode() {}
+
+// DescribeStmt represents a DESCRIBE statement.
+type DescribeStmt struct {
+	TableName string
+}
+
+func (s *DescribeStmt) statementNode() {}
+
+// SetStmt represents SET name = value for a session setting.
+type SetStmt struct {
+	Name  string
+	Value string
+}
+
+func (s *SetStmt) statementNode() {}
+
+// CopyStmt represents COPY table TO|FROM 'file' [WITH (options)].
+type CopyStmt struct {
+	TableName string
+	Path      string
+	To        bool // COPY TO exports; COPY FROM imports
+	Header    bool // HEADER true: the file's first line names the columns
+}
+
+func (s *CopyStmt) statementNode() {}
+
+// ShowStmt represents SHOW name for a session setting.
+type ShowStmt struct {
+	Name string
+}
+
+func (s *ShowStmt) statementNode() {}
+
 // ColumnDef represents a column definition.
 type ColumnDef struct {
-	Name     string
-	Type     types.ValueType
-	Nullable bool
+	Name       string
+	Type       types.ValueType
+	Nullable   bool
+	Serial     bool
+	Default    Expr // Constant expression, nil if none
+	Unique     bool
+	PrimaryKey bool
+	Collation  types.Collation
 }
 
 // Expr represents an expression.
@@ -104,12 +236,77 @@ type BinaryExpr struct {
 
 func (e *BinaryExpr) exprNode() {}
 
+// UnaryExpr represents a prefix operator (e.g., -x, NOT x).
+type UnaryExpr struct {
+	Op      TokenType
+	Operand Expr
+}
+
+func (e *UnaryExpr) exprNode() {}
+
+// IsNullExpr tests whether an expression is NULL (x IS NULL, or x IS NOT
+// NULL when Not is set). Unlike a comparison it is never NULL itself.
+type IsNullExpr struct {
+	Expr Expr
+	Not  bool
+}
+
+func (e *IsNullExpr) exprNode() {}
+
+// InExpr tests an expression against a list of values (x IN (1, 2), or
+// x NOT IN (...) when Not is set).
+type InExpr struct {
+	Expr   Expr
+	Values []Expr
+	Not    bool
+}
+
+func (e *InExpr) exprNode() {}
+
+// BetweenExpr tests low <= x <= high (x BETWEEN low AND high, or x NOT
+// BETWEEN ... when Not is set).
+type BetweenExpr struct {
+	Expr Expr
+	Low  Expr
+	High Expr
+	Not  bool
+}
+
+func (e *BetweenExpr) exprNode() {}
+
+// CollateExpr applies a collation to an expression (e.g., name COLLATE NOCASE),
+// overriding the column's own for comparisons and ordering.
+type CollateExpr struct {
+	Expr      Expr
+	Collation types.Collation
+}
+
+func (e *CollateExpr) exprNode() {}
+
+// FuncCallExpr represents a call to a built-in function (e.g., UPPER(name)).
+type FuncCallExpr struct {
+	Name string // Upper-cased function name
+	Args []Expr
+}
+
+func (e *FuncCallExpr) exprNode() {}
+
+// AggregateExpr represents an aggregate function call (e.g., COUNT(*)).
+type AggregateExpr struct {
+	Name string // Upper-cased function name
+	Arg  Expr   // nil for COUNT(*)
+}
+
+func (e *AggregateExpr) exprNode() {}
+
 // Parser parses SQL statements.
 type Parser struct {
 	lexer   *Lexer
 	current Token
 	peek    Token
 	errors  []string
+	funcs   map[string]Func // Registered functions callable besides the built-ins
+	callsFuncs bool         // A registered function was called
 }
 
 // NewParser creates a new parser.
@@ -160,16 +357,41 @@ func (p *Parser) Parse() (Statement, error) {
 	case TokenDelete:
 		stmt = p.parseDelete()
 	case TokenBegin:
-		stmt = &BeginStmt{}
-		p.nextToken()
+		stmt = p.parseBegin()
 	case TokenCommit:
 		stmt = &CommitStmt{}
 		p.nextToken()
 	case TokenRollback:
-		stmt = &RollbackStmt{}
+		stmt = p.parseRollback()
+	case TokenSavepoint:
+		p.nextToken()
+		stmt = &SavepointStmt{Name: p.parseSavepointName()}
+	case TokenRelease:
 		p.nextToken()
+		if p.current.Type == TokenSavepoint {
+			p.nextToken()
+		}
+		stmt = &ReleaseStmt{Name: p.parseSavepointName()}
 	case TokenCreate:
 		stmt = p.parseCreateTable()
+	case TokenTruncate:
+		stmt = p.parseTruncate()
+	case TokenComment:
+		stmt = p.parseComment()
+	case TokenDescribe:
+		stmt = p.parseDescribe()
+	case TokenExplain:
+		stmt = p.parseExplain()
+	case TokenValues:
+		stmt = p.parseValues()
+	case TokenSet:
+		stmt = p.parseSet()
+	case TokenShow:
+		stmt = p.parseShow()
+	case TokenCopy:
+		stmt = p.parseCopy()
+	case TokenAnalyze:
+		stmt = p.parseAnalyze()
 	default:
 		return nil, fmt.Errorf("unexpected token: %s", p.current.Type)
 	}
@@ -185,21 +407,48 @@ func (p *Parser) parseSelect() *SelectStmt {
 	stmt := &SelectStmt{}
 	p.nextToken() // skip SELECT
 	
-	// Parse columns
-	stmt.Columns = p.parseColumnList()
+	// Optional planner hint
+	if p.current.Type == TokenHint {
+		hint, err := parseScanHint(p.current.Literal)
+		if err != nil {
+			p.errors = append(p.errors, err.Error())
+			return nil
+		}
+		stmt.Hint = hint
+		p.nextToken()
+	}
 	
-	// Expect FROM
-	if !p.expect(TokenFrom) {
+	// Parse select list
+	nerrs := len(p.errors)
+	stmt.Columns, stmt.Exprs, stmt.Aliases = p.parseSelectList()
+	if len(p.errors) > nerrs {
 		return nil
 	}
 	
-	// Parse table name
-	if p.current.Type != TokenIdent {
-		p.errors = append(p.errors, "expected table name")
+	// Optional INTO: create a table from the result
+	if p.current.Type == TokenInto {
+		p.nextToken()
+		if p.current.Type != TokenIdent {
+			p.errors = append(p.errors, "expected table name after INTO")
+			return nil
+		}
+		stmt.Into = p.current.Literal
+		p.nextToken()
+	}
+	
+	// Optional FROM; without it the select list is evaluated once
+	if p.current.Type == TokenFrom {
+		p.nextToken()
+		if p.current.Type != TokenIdent {
+			p.errors = append(p.errors, "expected table name")
+			return nil
+		}
+		stmt.TableName = p.current.Literal
+		p.nextToken()
+	} else if len(stmt.Columns) == 1 && stmt.Columns[0] == "*" {
+		p.errors = append(p.errors, "SELECT * requires FROM")
 		return nil
 	}
-	stmt.TableName = p.current.Literal
-	p.nextToken()
 	
 	// Optional WHERE
 	if p.current.Type == TokenWhere {
@@ -207,9 +456,162 @@ func (p *Parser) parseSelect() *SelectStmt {
 		stmt.Where = p.parseExpr()
 	}
 	
+	// Optional GROUP BY
+	if p.current.Type == TokenGroup {
+		p.nextToken()
+		if !p.expect(TokenBy) {
+			return nil
+		}
+		stmt.GroupBy = p.parseExprList()
+	}
+	
+	// Optional HAVING
+	if p.current.Type == TokenHaving {
+		p.nextToken()
+		stmt.Having = p.parseExpr()
+	}
+	
+	// Optional ORDER BY
+	if p.current.Type == TokenOrder {
+		p.nextToken()
+		if !p.expect(TokenBy) {
+			return nil
+		}
+		stmt.OrderBy = p.parseOrderBy()
+	}
+	
+	// Optional LIMIT and OFFSET, in either order
+	seen := make(map[TokenType]bool)
+	for p.current.Type == TokenLimit || p.current.Type == TokenOffset {
+		clause := p.current.Type
+		if seen[clause] {
+			p.errors = append(p.errors, fmt.Sprintf("%s specified more than once", clause))
+			return nil
+		}
+		seen[clause] = true
+		p.nextToken()
+		n, ok := p.parseRowCount(clause)
+		if !ok {
+			return nil
+		}
+		if clause == TokenLimit {
+			stmt.Limit = &n
+		} else {
+			stmt.Offset = n
+		}
+	}
+	
+	// Optional FOR UPDATE: lock the rows returned
+	if p.current.Type == TokenFor {
+		p.nextToken()
+		if !p.expect(TokenUpdate) {
+			return nil
+		}
+		if stmt.TableName == "" || stmt.Into != "" {
+			p.errors = append(p.errors, "FOR UPDATE requires FROM and cannot be used with INTO")
+			return nil
+		}
+		stmt.ForUpdate = true
+	}
+	
 	return stmt
 }
 
+// parseRowCount parses the non-negative integer after LIMIT or OFFSET.
+func (p *Parser) parseRowCount(clause TokenType) (int64, bool) {
+	if p.current.Type != TokenNumber {
+		p.errors = append(p.errors, fmt.Sprintf("%s expects a non-negative integer, got %s", clause, p.current.Type))
+		return 0, false
+	}
+	n, err := strconv.ParseInt(p.current.Literal, 10, 64)
+	if err != nil || n < 0 {
+		p.errors = append(p.errors, fmt.Sprintf("%s expects a non-negative integer, got %s", clause, p.current.Literal))
+		return 0, false
+	}
+	p.nextToken()
+	return n, true
+}
+
+// parseScanHint parses the text of a /*+ ... */ hint: seqscan, indexscan or
+// index(name).
+func parseScanHint(text string) (*ScanHint, error) {
+	hint := strings.ToLower(strings.Join(strings.Fields(text), ""))
+	switch {
+	case hint == "seqscan":
+		return &ScanHint{Kind: HintSeqScan}, nil
+	case hint == "indexscan":
+		return &ScanHint{Kind: HintIndexScan}, nil
+	case strings.HasPrefix(hint, "index(") && strings.HasSuffix(hint, ")"):
+		name := hint[len("index(") : len(hint)-1]
+		if name == "" {
+			return nil, fmt.Errorf("hint index() needs an index name")
+		}
+		return &ScanHint{Kind: HintIndexScan, Index: name}, nil
+	default:
+		return nil, fmt.Errorf("unknown hint %q", text)
+	}
+}
+
+func (p *Parser) parseExplain() *ExplainStmt {
+	p.nextToken() // skip EXPLAIN
+	
+	if p.current.Type != TokenSelect {
+		p.errors = append(p.errors, "EXPLAIN supports only SELECT")
+		return nil
+	}
+	sel := p.parseSelect()
+	if sel == nil {
+		return nil
+	}
+	if sel.Into != "" {
+		p.errors = append(p.errors, "EXPLAIN does not support SELECT INTO")
+		return nil
+	}
+	return &ExplainStmt{Select: sel}
+}
+
+// parseOrderBy parses a comma-separated list of "expr [ASC|DESC]".
+func (p *Parser) parseOrderBy() []OrderByItem {
+	var items []OrderByItem
+	for {
+		expr := p.parseExpr()
+		if expr == nil {
+			return items
+		}
+		item := OrderByItem{Expr: expr}
+		switch p.current.Type {
+		case TokenAsc:
+			p.nextToken()
+		case TokenDesc:
+			item.Desc = true
+			p.nextToken()
+		}
+		items = append(items, item)
+		
+		if p.current.Type != TokenComma {
+			return items
+		}
+		p.nextToken()
+	}
+}
+
+// parseExprList parses a comma-separated list of expressions.
+func (p *Parser) parseExprList() []Expr {
+	var exprs []Expr
+	for {
+		expr := p.parseExpr()
+		if expr == nil {
+			return exprs
+		}
+		exprs = append(exprs, expr)
+		
+		if p.current.Type != TokenComma {
+			return exprs
+		}
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseInsert() *InsertStmt {
 	stmt := &InsertStmt{}
 	p.nextToken() // skip INSERT
@@ -247,24 +649,65 @@ func (p *Parser) parseInsert() *InsertStmt {
 		return nil
 	}
 	
-	// Parse values
+	// Parse one or more comma-separated tuples
+	for {
+		values, ok := p.parseValueRow()
+		if !ok {
+			return nil
+		}
+		stmt.Values = append(stmt.Values, values)
+		
+		if p.current.Type != TokenComma {
+			return stmt
+		}
+		p.nextToken()
+	}
+}
+
+// parseValueRow parses a parenthesized, comma-separated list of expressions.
+func (p *Parser) parseValueRow() ([]Expr, bool) {
 	if !p.expect(TokenLParen) {
-		return nil
+		return nil, false
 	}
 	
+	var values []Expr
 	for p.current.Type != TokenRParen && p.current.Type != TokenEOF {
 		expr := p.parseExpr()
 		if expr != nil {
-			stmt.Values = append(stmt.Values, expr)
+			values = append(values, expr)
 		}
 		if p.current.Type == TokenComma {
 			p.nextToken()
 		}
 	}
 	
-	p.expect(TokenRParen)
+	return values, p.expect(TokenRParen)
+}
+
+func (p *Parser) parseValues() *ValuesStmt {
+	stmt := &ValuesStmt{}
+	p.nextToken() // skip VALUES
 	
-	return stmt
+	for {
+		row, ok := p.parseValueRow()
+		if !ok {
+			return nil
+		}
+		if len(row) == 0 {
+			p.errors = append(p.errors, "VALUES row must not be empty")
+			return nil
+		}
+		if len(stmt.Rows) > 0 && len(row) != len(stmt.Rows[0]) {
+			p.errors = append(p.errors, fmt.Sprintf("VALUES rows must all have %d values, got %d", len(stmt.Rows[0]), len(row)))
+			return nil
+		}
+		stmt.Rows = append(stmt.Rows, row)
+		
+		if p.current.Type != TokenComma {
+			return stmt
+		}
+		p.nextToken()
+	}
 }
 
 func (p *Parser) parseUpdate() *UpdateStmt {
@@ -346,6 +789,15 @@ func (p *Parser) parseCreateTable() *CreateTableStmt {
 	stmt := &CreateTableStmt{}
 	p.nextToken() // skip CREATE
 	
+	// Optional TEMP | TEMPORARY
+	if p.current.Type == TokenIdent {
+		switch strings.ToUpper(p.current.Literal) {
+		case "TEMP", "TEMPORARY":
+			stmt.Temp = true
+			p.nextToken()
+		}
+	}
+	
 	// Expect TABLE
 	if !p.expect(TokenTable) {
 		return nil
@@ -367,9 +819,10 @@ func (p *Parser) parseCreateTable() *CreateTableStmt {
 	// Parse column definitions
 	for p.current.Type != TokenRParen && p.current.Type != TokenEOF {
 		colDef := p.parseColumnDef()
-		if colDef != nil {
-			stmt.Columns = append(stmt.Columns, *colDef)
+		if colDef == nil {
+			return nil // Error recorded; the tokens may not have advanced
 		}
+		stmt.Columns = append(stmt.Columns, *colDef)
 		
 		if p.current.Type == TokenComma {
 			p.nextToken()
@@ -381,120 +834,643 @@ func (p *Parser) parseCreateTable() *CreateTableStmt {
 	return stmt
 }
 
-func (p *Parser) parseColumnDef() *ColumnDef {
-	if p.current.Type != TokenIdent {
-		p.errors = append(p.errors, "expected column name")
-		return nil
-	}
+// parseAnalyze parses ANALYZE [table].
+func (p *Parser) parseAnalyze() *AnalyzeStmt {
+	stmt := &AnalyzeStmt{}
+	p.nextToken() // skip ANALYZE
 	
-	col := &ColumnDef{
-		Name:     p.current.Literal,
-		Nullable: true,
+	if p.current.Type == TokenIdent {
+		stmt.TableName = p.current.Literal
+		p.nextToken()
 	}
-	p.nextToken()
-	
-	// Parse type
-	switch p.current.Type {
-	case TokenInt:
-		col.Type = types.ValueTypeInt
-	case TokenText:
-		col.Type = types.ValueTypeString
-	case TokenBool:
-		col.Type = types.ValueTypeBool
-	default:
-		p.errors = append(p.errors, fmt.Sprintf("expected type, got %s", p.current.Type))
+	if p.current.Type != TokenEOF && p.current.Type != TokenSemicolon {
+		p.errors = append(p.errors, fmt.Sprintf("unexpected %s after ANALYZE", p.current.Type))
 		return nil
 	}
-	p.nextToken()
-	
-	// Optional NOT NULL
-	if p.current.Type == TokenNot {
-		p.nextToken()
-		if p.current.Type == TokenNull {
-			col.Nullable = false
-			p.nextToken()
-		}
-	}
 	
-	return col
+	return stmt
 }
 
-func (p *Parser) parseColumnList() []string {
-	var columns []string
+// parseRollback parses ROLLBACK and ROLLBACK TO [SAVEPOINT] name.
+// parseBegin parses
+// BEGIN [TRANSACTION | WORK] [ISOLATION LEVEL] {READ COMMITTED | REPEATABLE READ}.
+func (p *Parser) parseBegin() *BeginStmt {
+	stmt := &BeginStmt{}
+	p.nextToken() // skip BEGIN
 	
-	if p.current.Type == TokenStar {
-		columns = append(columns, "*")
+	// None of these words are keywords
+	if p.isWord("TRANSACTION") || p.isWord("WORK") {
 		p.nextToken()
-		return columns
 	}
-	
-	for p.current.Type == TokenIdent {
-		columns = append(columns, p.current.Literal)
+	levelRequired := false
+	if p.isWord("ISOLATION") {
 		p.nextToken()
-		
-		if p.current.Type == TokenComma {
-			p.nextToken()
-		} else {
-			break
+		if !p.isWord("LEVEL") {
+			p.errors = append(p.errors, fmt.Sprintf("expected LEVEL after ISOLATION, got %s", p.current.Type))
+			return stmt
 		}
+		p.nextToken()
+		levelRequired = true
 	}
-	
-	return columns
+	if !p.isWord("READ") && !p.isWord("REPEATABLE") {
+		if levelRequired {
+			p.errors = append(p.errors, fmt.Sprintf("expected isolation level, got %s", p.current.Type))
+		}
+		return stmt
+	}
+	words := p.current.Literal
+	p.nextToken()
+	if p.current.Type == TokenIdent {
+		words += " " + p.current.Literal
+		p.nextToken()
+	}
+	level, ok := parseIsolationLevel(words)
+	if !ok {
+		p.errors = append(p.errors, fmt.Sprintf("unsupported isolation level %s (want READ COMMITTED or REPEATABLE READ)", strings.ToUpper(words)))
+		return stmt
+	}
+	stmt.Isolation = &level
+	return stmt
 }
 
-func (p *Parser) parseExpr() Expr {
-	return p.parseOrExpr()
+// isWord reports whether the current token is the identifier word,
+// ignoring case.
+func (p *Parser) isWord(word string) bool {
+	return p.current.Type == TokenIdent && strings.ToUpper(p.current.Literal) == word
 }
 
-func (p *Parser) parseOrExpr() Expr {
-	left := p.parseAndExpr()
+func (p *Parser) parseRollback() Statement {
+	p.nextToken() // skip ROLLBACK
 	
-	for p.current.Type == TokenOr {
-		op := p.current.Type
-		p.nextToken()
-		right := p.parseAndExpr()
-		left = &BinaryExpr{Left: left, Op: op, Right: right}
+	// TO is not a keyword
+	if p.current.Type != TokenIdent || strings.ToUpper(p.current.Literal) != "TO" {
+		return &RollbackStmt{}
+	}
+	p.nextToken()
+	if p.current.Type == TokenSavepoint {
+		p.nextToken()
+	}
+	return &RollbackToStmt{Name: p.parseSavepointName()}
+}
+
+// parseSavepointName parses the name in SAVEPOINT, ROLLBACK TO and RELEASE.
+func (p *Parser) parseSavepointName() string {
+	if p.current.Type != TokenIdent {
+		p.errors = append(p.errors, fmt.Sprintf("expected savepoint name, got %s", p.current.Type))
+		return ""
+	}
+	name := p.current.Literal
+	p.nextToken()
+	return name
+}
+
+func (p *Parser) parseTruncate() *TruncateStmt {
+	stmt := &TruncateStmt{}
+	p.nextToken() // skip TRUNCATE
+	
+	// Optional TABLE
+	if p.current.Type == TokenTable {
+		p.nextToken()
+	}
+	
+	// Parse table name
+	if p.current.Type != TokenIdent {
+		p.errors = append(p.errors, "expected table name")
+		return nil
+	}
+	stmt.TableName = p.current.Literal
+	p.nextToken()
+	
+	// Optional RESTART IDENTITY | CONTINUE IDENTITY
+	if p.current.Type == TokenIdent {
+		switch strings.ToUpper(p.current.Literal) {
+		case "RESTART":
+			stmt.RestartIdentity = true
+		case "CONTINUE":
+		default:
+			p.errors = append(p.errors, fmt.Sprintf("unexpected %q after table name", p.current.Literal))
+			return nil
+		}
+		p.nextToken()
+		if p.current.Type != TokenIdent || strings.ToUpper(p.current.Literal) != "IDENTITY" {
+			p.errors = append(p.errors, "expected IDENTITY")
+			return nil
+		}
+		p.nextToken()
+	}
+	
+	return stmt
+}
+
+func (p *Parser) parseComment() *CommentStmt {
+	stmt := &CommentStmt{}
+	p.nextToken() // skip COMMENT
+	
+	// Expect ON
+	if !p.expect(TokenOn) {
+		return nil
+	}
+	
+	// TABLE name | COLUMN table.column
+	switch {
+	case p.current.Type == TokenTable:
+		p.nextToken()
+		if p.current.Type != TokenIdent {
+			p.errors = append(p.errors, "expected table name")
+			return nil
+		}
+		stmt.TableName = p.current.Literal
+		p.nextToken()
+	case p.current.Type == TokenIdent && strings.ToUpper(p.current.Literal) == "COLUMN":
+		p.nextToken()
+		if p.current.Type != TokenIdent || p.peek.Type != TokenDot {
+			p.errors = append(p.errors, "expected table.column")
+			return nil
+		}
+		stmt.TableName = p.current.Literal
+		p.nextToken()
+		p.nextToken() // skip .
+		if p.current.Type != TokenIdent {
+			p.errors = append(p.errors, "expected column name")
+			return nil
+		}
+		stmt.ColumnName = p.current.Literal
+		p.nextToken()
+	default:
+		p.errors = append(p.errors, fmt.Sprintf("expected TABLE or COLUMN, got %s", p.current.Type))
+		return nil
+	}
+	
+	// Expect IS 'text' | NULL
+	if !p.expect(TokenIs) {
+		return nil
+	}
+	switch p.current.Type {
+	case TokenString:
+		stmt.Text = p.current.Literal
+	case TokenNull:
+	default:
+		p.errors = append(p.errors, fmt.Sprintf("expected string or NULL, got %s", p.current.Type))
+		return nil
+	}
+	p.nextToken()
+	
+	return stmt
+}
+
+func (p *Parser) parseDescribe() *DescribeStmt {
+	p.nextToken() // skip DESCRIBE
+	
+	if p.current.Type != TokenIdent {
+		p.errors = append(p.errors, "expected table name")
+		return nil
+	}
+	stmt := &DescribeStmt{TableName: p.current.Literal}
+	p.nextToken()
+	
+	return stmt
+}
+
+func (p *Parser) parseSet() *SetStmt {
+	p.nextToken() // skip SET
+	
+	if p.current.Type != TokenIdent {
+		p.errors = append(p.errors, "expected setting name")
+		return nil
+	}
+	stmt := &SetStmt{Name: strings.ToLower(p.current.Literal)}
+	p.nextToken()
+	
+	if !p.expect(TokenEq) {
+		return nil
+	}
+	
+	// Value: ON/OFF, TRUE/FALSE, a bare word, a number, or a string
+	switch p.current.Type {
+	case TokenOn, TokenTrue, TokenFalse, TokenIdent, TokenNumber, TokenString:
+		stmt.Value = p.current.Literal
+	default:
+		p.errors = append(p.errors, fmt.Sprintf("expected value for %s, got %s", stmt.Name, p.current.Type))
+		return nil
+	}
+	p.nextToken()
+	
+	return stmt
+}
+
+func (p *Parser) parseShow() *ShowStmt {
+	p.nextToken() // skip SHOW
+	
+	if p.current.Type != TokenIdent {
+		p.errors = append(p.errors, "expected setting name")
+		return nil
+	}
+	stmt := &ShowStmt{Name: strings.ToLower(p.current.Literal)}
+	p.nextToken()
+	
+	return stmt
+}
+
+func (p *Parser) parseCopy() *CopyStmt {
+	p.nextToken() // skip COPY
+	
+	if p.current.Type != TokenIdent {
+		p.errors = append(p.errors, "expected table name")
+		return nil
+	}
+	stmt := &CopyStmt{TableName: p.current.Literal}
+	p.nextToken()
+	
+	// TO is not a keyword
+	switch {
+	case p.current.Type == TokenFrom:
+	case p.current.Type == TokenIdent && strings.ToUpper(p.current.Literal) == "TO":
+		stmt.To = true
+	default:
+		p.errors = append(p.errors, fmt.Sprintf("expected TO or FROM, got %s", p.current.Type))
+		return nil
+	}
+	p.nextToken()
+	
+	if p.current.Type != TokenString {
+		p.errors = append(p.errors, "expected file name string")
+		return nil
+	}
+	stmt.Path = p.current.Literal
+	p.nextToken()
+	
+	// Optional WITH (FORMAT csv, HEADER [true|false])
+	if p.current.Type != TokenIdent || strings.ToUpper(p.current.Literal) != "WITH" {
+		return stmt
+	}
+	p.nextToken()
+	if !p.expect(TokenLParen) {
+		return nil
+	}
+	for {
+		if p.current.Type != TokenIdent {
+			p.errors = append(p.errors, fmt.Sprintf("expected COPY option, got %s", p.current.Type))
+			return nil
+		}
+		option := strings.ToUpper(p.current.Literal)
+		p.nextToken()
+		switch option {
+		case "FORMAT":
+			if p.current.Type != TokenIdent || strings.ToUpper(p.current.Literal) != "CSV" {
+				p.errors = append(p.errors, fmt.Sprintf("unsupported COPY format %q", p.current.Literal))
+				return nil
+			}
+			p.nextToken()
+		case "HEADER":
+			stmt.Header = true
+			switch p.current.Type {
+			case TokenTrue:
+				p.nextToken()
+			case TokenFalse:
+				stmt.Header = false
+				p.nextToken()
+			}
+		default:
+			p.errors = append(p.errors, fmt.Sprintf("unknown COPY option %q", option))
+			return nil
+		}
+		if p.current.Type != TokenComma {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expect(TokenRParen) {
+		return nil
+	}
+	
+	return stmt
+}
+
+func (p *Parser) parseColumnDef() *ColumnDef {
+	if p.current.Type != TokenIdent {
+		p.errors = append(p.errors, "expected column name")
+		return nil
+	}
+	
+	col := &ColumnDef{
+		Name:     p.current.Literal,
+		Nullable: true,
+	}
+	p.nextToken()
+	
+	// Parse type
+	switch p.current.Type {
+	case TokenInt:
+		col.Type = types.ValueTypeInt
+	case TokenText:
+		col.Type = types.ValueTypeString
+	case TokenBool:
+		col.Type = types.ValueTypeBool
+	case TokenBlob:
+		col.Type = types.ValueTypeBytes
+	case TokenTimestamp:
+		col.Type = types.ValueTypeTimestamp
+	case TokenSerial:
+		col.Type = types.ValueTypeInt
+		col.Serial = true
+		col.Nullable = false
+	default:
+		p.errors = append(p.errors, fmt.Sprintf("expected type, got %s", p.current.Type))
+		return nil
+	}
+	p.nextToken()
+	
+	// Column constraints, in any order
+	for {
+		switch p.current.Type {
+		case TokenNot:
+			p.nextToken()
+			if p.current.Type == TokenNull {
+				col.Nullable = false
+				p.nextToken()
+			}
+		case TokenNull:
+			p.nextToken()
+		case TokenDefault:
+			p.nextToken()
+			col.Default = p.parseExpr()
+			if col.Default == nil {
+				return nil
+			}
+		case TokenUnique:
+			col.Unique = true
+			p.nextToken()
+		case TokenPrimary:
+			p.nextToken()
+			if p.current.Type != TokenIdent || strings.ToUpper(p.current.Literal) != "KEY" {
+				p.errors = append(p.errors, "expected KEY after PRIMARY")
+				return nil
+			}
+			p.nextToken()
+			col.PrimaryKey = true
+			col.Unique = true
+			col.Nullable = false
+		case TokenCollate:
+			if col.Type != types.ValueTypeString {
+				p.errors = append(p.errors, fmt.Sprintf("column %s: COLLATE is only allowed on TEXT columns", col.Name))
+				return nil
+			}
+			p.nextToken()
+			coll, ok := p.parseCollationName()
+			if !ok {
+				return nil
+			}
+			col.Collation = coll
+		default:
+			return col
+		}
+	}
+}
+
+// parseSelectList parses "*" or a comma-separated list of expressions,
+// returning the output column names alongside the expressions.
+func (p *Parser) parseSelectList() ([]string, []Expr, []string) {
+	if p.current.Type == TokenStar {
+		p.nextToken()
+		return []string{"*"}, nil, nil
+	}
+
+	var columns, aliases []string
+	var exprs []Expr
+	for {
+		expr := p.parseExpr()
+		if expr == nil {
+			return columns, exprs, aliases
+		}
+		name, alias := exprString(expr), ""
+		if p.current.Type == TokenAs {
+			p.nextToken()
+			if p.current.Type != TokenIdent {
+				p.errors = append(p.errors, "expected column alias after AS")
+				return columns, exprs, aliases
+			}
+			name, alias = p.current.Literal, p.current.Literal
+			p.nextToken()
+		}
+		columns = append(columns, name)
+		exprs = append(exprs, expr)
+		aliases = append(aliases, alias)
+
+		if p.current.Type != TokenComma {
+			return columns, exprs, aliases
+		}
+		p.nextToken()
+	}
+}
+
+func (p *Parser) parseExpr() Expr {
+	return p.parseOrExpr()
+}
+
+func (p *Parser) parseOrExpr() Expr {
+	left := p.parseAndExpr()
+	
+	for p.current.Type == TokenOr {
+		op := p.current.Type
+		p.nextToken()
+		right := p.parseAndExpr()
+		left = &BinaryExpr{Left: left, Op: op, Right: right}
 	}
 	
 	return left
 }
 
 func (p *Parser) parseAndExpr() Expr {
-	left := p.parseCompareExpr()
+	left := p.parseNotExpr()
 	
 	for p.current.Type == TokenAnd {
 		op := p.current.Type
 		p.nextToken()
-		right := p.parseCompareExpr()
+		right := p.parseNotExpr()
 		left = &BinaryExpr{Left: left, Op: op, Right: right}
 	}
 	
 	return left
 }
 
+func (p *Parser) parseNotExpr() Expr {
+	if p.current.Type == TokenNot {
+		p.nextToken()
+		return &UnaryExpr{Op: TokenNot, Operand: p.parseNotExpr()}
+	}
+	return p.parseCompareExpr()
+}
+
 func (p *Parser) parseCompareExpr() Expr {
-	left := p.parsePrimaryExpr()
+	left := p.parseAdditiveExpr()
 	
 	switch p.current.Type {
 	case TokenEq, TokenNe, TokenLt, TokenLe, TokenGt, TokenGe:
 		op := p.current.Type
 		p.nextToken()
-		right := p.parsePrimaryExpr()
+		right := p.parseAdditiveExpr()
 		return &BinaryExpr{Left: left, Op: op, Right: right}
+	case TokenIs:
+		p.nextToken()
+		expr := &IsNullExpr{Expr: left}
+		if p.current.Type == TokenNot {
+			expr.Not = true
+			p.nextToken()
+		}
+		if !p.expect(TokenNull) {
+			return left
+		}
+		return expr
+	case TokenIn:
+		return p.parseInList(left, false)
+	case TokenBetween:
+		return p.parseBetween(left, false)
+	case TokenNot:
+		switch p.peek.Type {
+		case TokenIn:
+			p.nextToken() // skip NOT
+			return p.parseInList(left, true)
+		case TokenBetween:
+			p.nextToken() // skip NOT
+			return p.parseBetween(left, true)
+		}
 	}
 	
 	return left
 }
 
+// parseBetween parses "BETWEEN low AND high" after left. The bounds are
+// additive expressions, so the AND is not taken for a logical AND.
+func (p *Parser) parseBetween(left Expr, not bool) Expr {
+	p.nextToken() // skip BETWEEN
+	expr := &BetweenExpr{Expr: left, Not: not}
+	expr.Low = p.parseAdditiveExpr()
+	if !p.expect(TokenAnd) {
+		return left
+	}
+	expr.High = p.parseAdditiveExpr()
+	return expr
+}
+
+// parseInList parses "IN (expr, ...)" after left; an empty list is allowed.
+func (p *Parser) parseInList(left Expr, not bool) Expr {
+	p.nextToken() // skip IN
+	expr := &InExpr{Expr: left, Not: not}
+	if !p.expect(TokenLParen) {
+		return left
+	}
+	for p.current.Type != TokenRParen {
+		expr.Values = append(expr.Values, p.parseAdditiveExpr())
+		if p.current.Type != TokenComma {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expect(TokenRParen) {
+		return left
+	}
+	return expr
+}
+
+func (p *Parser) parseAdditiveExpr() Expr {
+	left := p.parseMultiplicativeExpr()
+
+	for {
+		switch {
+		case p.current.Type == TokenPlus || p.current.Type == TokenMinus:
+			op := p.current.Type
+			p.nextToken()
+			right := p.parseMultiplicativeExpr()
+			left = &BinaryExpr{Left: left, Op: op, Right: right}
+		case p.current.Type == TokenNumber && strings.HasPrefix(p.current.Literal, "-"):
+			// The lexer reads "a -1" as an identifier and a negative number;
+			// after an operand the sign is really a subtraction.
+			p.current.Literal = p.current.Literal[1:]
+			right := p.parseMultiplicativeExpr()
+			left = &BinaryExpr{Left: left, Op: TokenMinus, Right: right}
+		default:
+			return left
+		}
+	}
+}
+
+func (p *Parser) parseMultiplicativeExpr() Expr {
+	left := p.parseUnaryExpr()
+
+	for p.current.Type == TokenStar || p.current.Type == TokenSlash {
+		op := p.current.Type
+		p.nextToken()
+		right := p.parseUnaryExpr()
+		left = &BinaryExpr{Left: left, Op: op, Right: right}
+	}
+
+	return left
+}
+
+func (p *Parser) parseUnaryExpr() Expr {
+	if p.current.Type == TokenMinus {
+		p.nextToken()
+		return &UnaryExpr{Op: TokenMinus, Operand: p.parseUnaryExpr()}
+	}
+	return p.parseCollateExpr()
+}
+
+// parseCollateExpr parses a primary expression followed by any number of
+// COLLATE clauses; the last one applies.
+func (p *Parser) parseCollateExpr() Expr {
+	expr := p.parsePrimaryExpr()
+	for expr != nil && p.current.Type == TokenCollate {
+		p.nextToken()
+		coll, ok := p.parseCollationName()
+		if !ok {
+			return nil
+		}
+		if inner, ok := expr.(*CollateExpr); ok {
+			expr = inner.Expr
+		}
+		expr = &CollateExpr{Expr: expr, Collation: coll}
+	}
+	return expr
+}
+
+// parseCollationName parses the collation name after COLLATE.
+func (p *Parser) parseCollationName() (types.Collation, bool) {
+	if p.current.Type != TokenIdent {
+		p.errors = append(p.errors, "expected collation name after COLLATE")
+		return types.CollationBinary, false
+	}
+	coll, ok := types.ParseCollation(p.current.Literal)
+	if !ok {
+		p.errors = append(p.errors, fmt.Sprintf("unknown collation %s (have BINARY, NOCASE)", p.current.Literal))
+		return types.CollationBinary, false
+	}
+	p.nextToken()
+	return coll, true
+}
+
 func (p *Parser) parsePrimaryExpr() Expr {
 	switch p.current.Type {
 	case TokenIdent:
+		if p.peek.Type == TokenLParen {
+			return p.parseFuncCall()
+		}
 		expr := &ColumnExpr{Name: p.current.Literal}
 		p.nextToken()
 		return expr
 		
 	case TokenNumber:
-		val, _ := strconv.ParseInt(p.current.Literal, 10, 64)
+		if strings.ContainsAny(p.current.Literal, ".eE") {
+			p.errors = append(p.errors, fmt.Sprintf("decimal number %s not supported: INT is the only numeric type", p.current.Literal))
+			p.nextToken()
+			return nil
+		}
+		val, err := strconv.ParseInt(p.current.Literal, 10, 64)
+		if err != nil {
+			// INT is 64-bit; anything wider is rejected rather than clamped
+			p.errors = append(p.errors, fmt.Sprintf("integer literal %s out of range", p.current.Literal))
+			p.nextToken()
+			return nil
+		}
 		expr := &LiteralExpr{Value: types.Value{Type: types.ValueTypeInt, IntVal: val}}
 		p.nextToken()
 		return expr
@@ -504,6 +1480,28 @@ func (p *Parser) parsePrimaryExpr() Expr {
 		p.nextToken()
 		return expr
 		
+	case TokenHex:
+		b, _ := hex.DecodeString(p.current.Literal) // Checked by the lexer
+		expr := &LiteralExpr{Value: types.Value{Type: types.ValueTypeBytes, BytesVal: b}}
+		p.nextToken()
+		return expr
+		
+	case TokenTimestamp:
+		// TIMESTAMP '2024-01-02T15:04:05Z'
+		p.nextToken()
+		if p.current.Type != TokenString {
+			p.errors = append(p.errors, fmt.Sprintf("expected string after TIMESTAMP, got %s", p.current.Type))
+			return nil
+		}
+		sec, err := types.ParseTimestamp(p.current.Literal)
+		if err != nil {
+			p.errors = append(p.errors, err.Error())
+			return nil
+		}
+		expr := &LiteralExpr{Value: types.Value{Type: types.ValueTypeTimestamp, IntVal: sec}}
+		p.nextToken()
+		return expr
+		
 	case TokenTrue:
 		expr := &LiteralExpr{Value: types.Value{Type: types.ValueTypeBool, BoolVal: true}}
 		p.nextToken()
@@ -526,10 +1524,147 @@ func (p *Parser) parsePrimaryExpr() Expr {
 		return expr
 	}
 	
+	if p.current.Type == TokenError {
+		// The lexer's error tokens carry the offending text
+		p.errors = append(p.errors, fmt.Sprintf("invalid input: %s", p.current.Literal))
+		return nil
+	}
 	p.errors = append(p.errors, fmt.Sprintf("unexpected token in expression: %s", p.current.Type))
 	return nil
 }
 
+func (p *Parser) parseFuncCall() Expr {
+	name := strings.ToUpper(p.current.Literal)
+	if aggregateFuncs[name] {
+		return p.parseAggregate(name)
+	}
+
+	expr := &FuncCallExpr{Name: name}
+	p.nextToken() // skip name
+	p.nextToken() // skip (
+
+	for p.current.Type != TokenRParen && p.current.Type != TokenEOF {
+		arg := p.parseExpr()
+		if arg == nil {
+			return nil
+		}
+		expr.Args = append(expr.Args, arg)
+		if p.current.Type != TokenComma {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expect(TokenRParen) {
+		return nil
+	}
+
+	if err := checkFuncCall(expr, p.funcs); err != nil {
+		p.errors = append(p.errors, err.Error())
+		return nil
+	}
+	if _, ok := builtinFuncs[expr.Name]; !ok {
+		p.callsFuncs = true
+	}
+	return expr
+}
+
+// parseAggregate parses the argument of an aggregate call: "*" for COUNT,
+// otherwise a single expression.
+func (p *Parser) parseAggregate(name string) Expr {
+	expr := &AggregateExpr{Name: name}
+	p.nextToken() // skip name
+	p.nextToken() // skip (
+
+	if p.current.Type == TokenStar {
+		if name != "COUNT" {
+			p.errors = append(p.errors, fmt.Sprintf("%s(*) is not supported", name))
+			return nil
+		}
+		p.nextToken()
+	} else {
+		expr.Arg = p.parseExpr()
+		if expr.Arg == nil {
+			return nil
+		}
+	}
+	if !p.expect(TokenRParen) {
+		return nil
+	}
+	return expr
+}
+
+// exprString renders an expression as SQL text. It is used to name
+// select-list columns that are not plain column references.
+func exprString(expr Expr) string {
+	switch ex := expr.(type) {
+	case *LiteralExpr:
+		if ex.Value.Type == types.ValueTypeString && !ex.Value.IsNull {
+			return "'" + ex.Value.StrVal + "'"
+		}
+		if ex.Value.Type == types.ValueTypeBytes && !ex.Value.IsNull {
+			return "X'" + strings.ToUpper(hex.EncodeToString(ex.Value.BytesVal)) + "'"
+		}
+		if ex.Value.Type == types.ValueTypeTimestamp && !ex.Value.IsNull {
+			return "TIMESTAMP '" + ex.Value.String() + "'"
+		}
+		return strings.ToUpper(ex.Value.String())
+	case *ColumnExpr:
+		return ex.Name
+	case *BinaryExpr:
+		return operandString(ex.Left) + " " + ex.Op.String() + " " + operandString(ex.Right)
+	case *UnaryExpr:
+		if ex.Op == TokenNot {
+			return "NOT " + operandString(ex.Operand)
+		}
+		return ex.Op.String() + operandString(ex.Operand)
+	case *CollateExpr:
+		return operandString(ex.Expr) + " COLLATE " + ex.Collation.String()
+	case *IsNullExpr:
+		if ex.Not {
+			return operandString(ex.Expr) + " IS NOT NULL"
+		}
+		return operandString(ex.Expr) + " IS NULL"
+	case *InExpr:
+		values := make([]string, len(ex.Values))
+		for i, v := range ex.Values {
+			values[i] = exprString(v)
+		}
+		op := " IN ("
+		if ex.Not {
+			op = " NOT IN ("
+		}
+		return operandString(ex.Expr) + op + strings.Join(values, ", ") + ")"
+	case *BetweenExpr:
+		op := " BETWEEN "
+		if ex.Not {
+			op = " NOT BETWEEN "
+		}
+		return operandString(ex.Expr) + op + operandString(ex.Low) + " AND " + operandString(ex.High)
+	case *FuncCallExpr:
+		args := make([]string, len(ex.Args))
+		for i, arg := range ex.Args {
+			args[i] = exprString(arg)
+		}
+		return ex.Name + "(" + strings.Join(args, ", ") + ")"
+	case *AggregateExpr:
+		if ex.Arg == nil {
+			return ex.Name + "(*)"
+		}
+		return ex.Name + "(" + exprString(ex.Arg) + ")"
+	default:
+		return "?"
+	}
+}
+
+// operandString renders a sub-expression, parenthesizing compound operands.
+func operandString(expr Expr) string {
+	switch expr.(type) {
+	case *BinaryExpr, *UnaryExpr:
+		return "(" + exprString(expr) + ")"
+	}
+	return exprString(expr)
+}
+
 // Errors returns parse errors.
 func (p *Parser) Errors() []string {
 	return p.errors
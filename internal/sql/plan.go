@@ -0,0 +1,697 @@
+package sql
+
+import (
+	"bytes"
+	"fmt"
+	"minidb/internal/index"
+	"minidb/internal/storage"
+	"minidb/internal/txn"
+	"minidb/pkg/types"
+	"slices"
+	"strings"
+)
+
+// accessPath is how a SELECT reads its table.
+type accessPath int
+
+const (
+	pathSeqScan accessPath = iota
+	pathIndexScan
+	pathIndexOrderScan
+	pathIndexRangeScan
+)
+
+// scanPlan is the planner's choice of access path for a single-table SELECT.
+type scanPlan struct {
+	path      accessPath
+	indexName string      // Index scans only
+	column    string      // Index scans only: the indexed column
+	key       types.Value // Equality key for index scans
+	desc      bool        // Ordered index scans only: walk the keys backwards
+	start     []byte      // Range scans only: the encoded bounds, inclusive
+	end       []byte
+}
+
+// indexName returns the name a table's index goes by in hints and EXPLAIN
+// output. Indexes are unnamed, so the name is derived as <table>_<column>.
+func indexName(tableName, column string) string {
+	return tableName + "_" + column
+}
+
+// String renders the hint as it is written inside /*+ ... */.
+func (h *ScanHint) String() string {
+	switch {
+	case h.Kind == HintSeqScan:
+		return "seqscan"
+	case h.Index != "":
+		return "index(" + h.Index + ")"
+	default:
+		return "indexscan"
+	}
+}
+
+// planScan picks the access path for a SELECT. An index is used for
+// WHERE column = literal on its column, for a comparison of the column
+// with a literal (see indexRange), and for a LIMIT or OFFSET query ordered
+// by its column (see indexOrderable), unless a hint forces a sequential
+// scan. A hint forcing an index scan that cannot be satisfied
+// is an error rather than being silently ignored.
+//
+// SELECT ... FOR UPDATE always scans sequentially: locking needs the
+// stored version of each row, which only the sequential scan keeps, as for
+// UPDATE and DELETE.
+func (e *Executor) planScan(stmt *SelectStmt, tableID uint32, grouped bool) (scanPlan, error) {
+	if stmt.ForUpdate {
+		if grouped {
+			return scanPlan{}, fmt.Errorf("FOR UPDATE is not allowed with GROUP BY or aggregate functions")
+		}
+		return scanPlan{path: pathSeqScan}, nil
+	}
+	hint := stmt.Hint
+	if hint != nil && hint.Kind == HintSeqScan {
+		return scanPlan{path: pathSeqScan}, nil
+	}
+
+	var columns, names []string
+	for _, col := range e.catalog.GetIndexColumns(tableID) {
+		if _, ok := e.indexes[tableID][col]; ok {
+			columns = append(columns, col)
+			names = append(names, indexName(stmt.TableName, col))
+		}
+	}
+	if hint != nil && len(columns) == 0 {
+		return scanPlan{}, fmt.Errorf("hint %s: table %s has no index", hint, stmt.TableName)
+	}
+	if hint != nil && hint.Index != "" {
+		i := slices.Index(names, hint.Index)
+		if i < 0 {
+			return scanPlan{}, fmt.Errorf("hint %s: no index %s on table %s (have %s)", hint, hint.Index, stmt.TableName, strings.Join(names, ", "))
+		}
+		columns, names = columns[i:i+1], names[i:i+1]
+	}
+
+	plan := scanPlan{path: pathSeqScan}
+	schema := e.catalog.GetSchema(stmt.TableName)
+	for i, col := range columns {
+		if key, ok := indexEqualityKey(stmt.Where, col); ok {
+			plan = scanPlan{path: pathIndexScan, indexName: names[i], column: col, key: key}
+			break
+		}
+		if start, end, ok := indexRange(stmt.Where, schema, col); ok {
+			plan = scanPlan{path: pathIndexRangeScan, indexName: names[i], column: col, start: start, end: end}
+			break
+		}
+		if !grouped && indexOrderable(stmt, schema, col) {
+			plan = scanPlan{path: pathIndexOrderScan, indexName: names[i], column: col, desc: stmt.OrderBy[0].Desc}
+			break
+		}
+	}
+
+	if hint != nil && plan.path == pathSeqScan {
+		if len(columns) == 1 {
+			return scanPlan{}, fmt.Errorf("hint %s: index %s needs WHERE %s = <literal>", hint, names[0], columns[0])
+		}
+		return scanPlan{}, fmt.Errorf("hint %s: indexes %s need WHERE <column> = <literal>", hint, strings.Join(names, ", "))
+	}
+	return plan, nil
+}
+
+// indexOrderable reports whether a LIMIT or OFFSET query can page through
+// the index in key order instead of sorting the whole table: it must have
+// no WHERE and be ordered by the indexed column alone, and that column must
+// be a UNIQUE (or PRIMARY KEY) INT that never holds NULL. The index keeps a
+// single entry per key, so only such a column has one entry for every row.
+func indexOrderable(stmt *SelectStmt, schema *types.Schema, column string) bool {
+	if stmt.Limit == nil && stmt.Offset == 0 {
+		return false
+	}
+	if stmt.Where != nil || len(stmt.OrderBy) != 1 {
+		return false
+	}
+	colExpr, ok := stmt.OrderBy[0].Expr.(*ColumnExpr)
+	if !ok || colExpr.Name != column || schema == nil || aliasIndex(colExpr, stmt.Aliases) >= 0 {
+		return false
+	}
+	for _, col := range schema.Columns {
+		if col.Name == column {
+			return col.Type == types.ValueTypeInt &&
+				(col.Unique || col.PrimaryKey) && (!col.Nullable || col.PrimaryKey)
+		}
+	}
+	return false
+}
+
+// indexRange returns the encoded key range for a WHERE clause comparing the
+// given column with a literal using <, <=, > or >=, either way round. The
+// missing end of the range is the lowest or highest possible key. The
+// bounds are inclusive, so the rows still go through the WHERE clause. As
+// for indexOrderable, the column must be a UNIQUE (or PRIMARY KEY) INT, so
+// that every row with a value has its own entry.
+func indexRange(where Expr, schema *types.Schema, column string) (start, end []byte, ok bool) {
+	binExpr, ok := where.(*BinaryExpr)
+	if !ok || schema == nil {
+		return nil, nil, false
+	}
+	op := binExpr.Op
+	colExpr, okCol := binExpr.Left.(*ColumnExpr)
+	litExpr, okLit := binExpr.Right.(*LiteralExpr)
+	if !okCol || !okLit {
+		// 5 < id is id > 5
+		colExpr, okCol = binExpr.Right.(*ColumnExpr)
+		litExpr, okLit = binExpr.Left.(*LiteralExpr)
+		switch op {
+		case TokenLt:
+			op = TokenGt
+		case TokenLe:
+			op = TokenGe
+		case TokenGt:
+			op = TokenLt
+		case TokenGe:
+			op = TokenLe
+		}
+	}
+	if !okCol || !okLit || colExpr.Name != column || litExpr.Value.Type != types.ValueTypeInt {
+		return nil, nil, false
+	}
+	indexable := false
+	for _, col := range schema.Columns {
+		if col.Name == column {
+			indexable = col.Type == types.ValueTypeInt && (col.Unique || col.PrimaryKey)
+		}
+	}
+	if !indexable {
+		return nil, nil, false
+	}
+
+	key := index.EncodeColumnKey(schema, column, litExpr.Value, 64)
+	switch op {
+	case TokenLt, TokenLe:
+		return index.MinKey(64), key, true
+	case TokenGt, TokenGe:
+		return key, index.MaxKey(64), true
+	}
+	return nil, nil, false
+}
+
+// indexEqualityKey returns the literal in a WHERE column = literal clause on
+// the given column.
+func indexEqualityKey(where Expr, column string) (types.Value, bool) {
+	binExpr, ok := where.(*BinaryExpr)
+	if !ok || binExpr.Op != TokenEq {
+		return types.Value{}, false
+	}
+
+	colExpr, okCol := binExpr.Left.(*ColumnExpr)
+	litExpr, okLit := binExpr.Right.(*LiteralExpr)
+	if !okCol || !okLit || colExpr.Name != column {
+		return types.Value{}, false
+	}
+	return litExpr.Value, true
+}
+
+// indexLookup fetches the row with the given key through the index on a
+// column of the table. The index points at the newest version, so when that
+// is not visible the row's version chain is walked back to the one the
+// snapshot sees. It returns false when the index entry cannot be used
+// (missing tuple, no visible version, or a visible version with another key,
+// in which case a different row may have held the key) and the caller must
+// fall back to a scan.
+func (e *Executor) indexLookup(tableID uint32, column string, schema *types.Schema, heap *storage.TableHeap, key types.Value, txn *txn.Transaction) ([]map[string]types.Value, bool) {
+	if !index.UniqueKeys(schema, column) {
+		return e.indexLookupAll(tableID, column, schema, heap, key, txn)
+	}
+	encoded := index.EncodeColumnKey(schema, column, key, 64)
+	rid, found := e.indexes[tableID][column].Search(encoded)
+	if !found {
+		return nil, true // index used, no results
+	}
+
+	version, err := heap.FindVersion(rid.PageID, rid.SlotNum, func(t *types.Tuple) bool {
+		return visibleToTxn(txn, t)
+	})
+	if err != nil || version == nil {
+		return nil, false // fallback to scan
+	}
+
+	rowData, err := types.DeserializeRow(schema, version.Tuple.Data)
+	if err != nil {
+		return nil, false
+	}
+	if !bytes.Equal(index.EncodeColumnKey(schema, column, rowData[column], 64), encoded) {
+		return nil, false
+	}
+
+	return []map[string]types.Value{rowData}, true
+}
+
+// indexLookupAll fetches the rows with the given key through the index on a
+// column that is not UNIQUE. Such an index has an entry for every version
+// of every row (see index.EntryKey), so each entry in the key's range is
+// checked for visibility on its own, without walking version chains. It
+// returns false when an entry points at a tuple that cannot be read.
+func (e *Executor) indexLookupAll(tableID uint32, column string, schema *types.Schema, heap *storage.TableHeap, key types.Value, txn *txn.Transaction) ([]map[string]types.Value, bool) {
+	if key.Type == types.ValueTypeNull {
+		return nil, true // column = NULL is never true
+	}
+	start, end := index.EntryRange(schema, column, key, 64)
+	encoded := index.EncodeColumnKey(schema, column, key, 64)
+	var rows []map[string]types.Value
+	for _, rid := range e.indexes[tableID][column].RangeScan(start, end) {
+		tuple, err := heap.Get(rid.PageID, rid.SlotNum)
+		if err != nil {
+			return nil, false
+		}
+		if !visibleToTxn(txn, tuple) {
+			continue
+		}
+		rowData, err := types.DeserializeRow(schema, tuple.Data)
+		if err != nil {
+			return nil, false
+		}
+		// A longer value may share the key's shortened prefix, and NULL
+		// encodes like the empty string
+		if val := rowData[column]; val.Type != types.ValueTypeNull && bytes.Equal(index.EncodeColumnKey(schema, column, val, 64), encoded) {
+			rows = append(rows, rowData)
+		}
+	}
+	return rows, true
+}
+
+// indexRangeScan returns the rows whose key in the index on column lies
+// between start and end, inclusive, in key order. Like indexOrderedScan, it
+// returns false when an entry points at a version that is not visible but
+// replaced an older one, and the caller must fall back to a scan.
+func (e *Executor) indexRangeScan(tableID uint32, column string, schema *types.Schema, heap *storage.TableHeap, start, end []byte, txn *txn.Transaction) ([]map[string]types.Value, bool) {
+	var rows []map[string]types.Value
+	for _, rid := range e.indexes[tableID][column].RangeScan(start, end) {
+		tuple, err := heap.Get(rid.PageID, rid.SlotNum)
+		if err != nil {
+			return nil, false
+		}
+		if !visibleToTxn(txn, tuple) {
+			if tuple.HasPrevVersion() {
+				return nil, false
+			}
+			continue // deleted, or inserted after the snapshot
+		}
+		rowData, err := types.DeserializeRow(schema, tuple.Data)
+		if err != nil {
+			return nil, false
+		}
+		rows = append(rows, rowData)
+	}
+	return rows, true
+}
+
+// indexOrderedScan pages through the table in the key order of the index
+// on column, skipping the first offset rows and returning at most limit
+// rows (all remaining rows when limit is negative). Skipped entries only
+// have their tuple header checked for visibility; only returned rows are
+// decoded. It returns false when an entry points at a version that is not
+// visible but replaced an older one, since the older version may be the one
+// this snapshot sees, and the caller must fall back to a scan.
+func (e *Executor) indexOrderedScan(tableID uint32, column string, schema *types.Schema, heap *storage.TableHeap, desc bool, offset, limit int64, txn *txn.Transaction) ([]map[string]types.Value, bool) {
+	var rows []map[string]types.Value
+	usable := true
+	err := e.indexes[tableID][column].ScanOrdered(desc, func(key []byte, rid index.RID) bool {
+		if limit >= 0 && int64(len(rows)) >= limit {
+			return false
+		}
+
+		tuple, err := heap.Get(rid.PageID, rid.SlotNum)
+		if err != nil {
+			usable = false
+			return false
+		}
+		if !visibleToTxn(txn, tuple) {
+			if tuple.HasPrevVersion() {
+				usable = false
+				return false
+			}
+			return true // deleted, or inserted after the snapshot
+		}
+		if offset > 0 {
+			offset--
+			return true
+		}
+
+		rowData, err := types.DeserializeRow(schema, tuple.Data)
+		if err != nil {
+			usable = false
+			return false
+		}
+		rows = append(rows, rowData)
+		return true
+	})
+	if err != nil || !usable {
+		return nil, false
+	}
+	return rows, true
+}
+
+// scanOperator reads the rows of a table visible to txn that satisfy where
+// (all of them when where is nil). A statement that writes to the table
+// must not stream, so that it does not see its own writes (see seqScanOp).
+func (e *Executor) scanOperator(heap *storage.TableHeap, schema *types.Schema, txn *txn.Transaction, where Expr, stream bool) operator {
+	var op operator = &seqScanOp{heap: heap, schema: schema, txn: txn, stream: stream}
+	if where != nil {
+		op = &filterOp{e: e, child: op, cond: where}
+	}
+	return op
+}
+
+// selectOperator builds the operator tree that runs a SELECT, from the scan
+// up: scan and filter, aggregation, sort, limit and finally the projection.
+func (e *Executor) selectOperator(stmt *SelectStmt, exprs []Expr, orderKeys []orderKey, grouped bool, aggs []*AggregateExpr, plan scanPlan, txn *txn.Transaction) operator {
+	var op operator
+	if stmt.TableName == "" {
+		// A single row needs no sort
+		op = &resultOp{}
+		if stmt.Where != nil {
+			op = &filterOp{e: e, child: op, cond: stmt.Where}
+		}
+		if grouped {
+			op = e.aggregateOperator(op, stmt, aggs)
+		}
+		return &projectOp{e: e, child: e.limitOperator(op, stmt), exprs: exprs}
+	}
+
+	tableID, _ := e.catalog.GetTableID(stmt.TableName)
+	schema := e.catalog.GetSchema(stmt.TableName)
+	heap := e.catalog.GetTableHeap(tableID)
+	op = e.scanOperator(heap, schema, txn, stmt.Where, true)
+
+	switch plan.path {
+	case pathIndexScan:
+		op = &indexScanOp{e: e, tableID: tableID, schema: schema, heap: heap, txn: txn, plan: plan, fallback: op}
+	case pathIndexRangeScan:
+		// The range includes its bounds, so the WHERE clause still applies
+		op = &indexScanOp{e: e, tableID: tableID, schema: schema, heap: heap, txn: txn, plan: plan,
+			fallback: e.scanOperator(heap, schema, txn, nil, true)}
+		op = &filterOp{e: e, child: op, cond: stmt.Where}
+	case pathIndexOrderScan:
+		// The index returns the page already ordered and bounded
+		limit := int64(-1)
+		if stmt.Limit != nil {
+			limit = *stmt.Limit
+		}
+		fallback := e.limitOperator(&sortOp{e: e, child: op, keys: orderKeys}, stmt)
+		op = &indexScanOp{e: e, tableID: tableID, schema: schema, heap: heap, txn: txn, plan: plan,
+			offset: stmt.Offset, limit: limit, fallback: fallback}
+		return &projectOp{e: e, child: op, exprs: exprs}
+	}
+
+	if grouped {
+		op = e.aggregateOperator(op, stmt, aggs)
+	}
+	if len(orderKeys) > 0 {
+		op = &sortOp{e: e, child: op, keys: orderKeys}
+	}
+	return &projectOp{e: e, child: e.limitOperator(op, stmt), exprs: exprs}
+}
+
+// aggregateOperator groups the rows of op and drops the groups that fail
+// the SELECT's HAVING clause.
+func (e *Executor) aggregateOperator(op operator, stmt *SelectStmt, aggs []*AggregateExpr) operator {
+	op = &hashAggregateOp{e: e, child: op, groupBy: stmt.GroupBy, aggs: aggs}
+	if stmt.Having != nil {
+		op = &filterOp{e: e, child: op, cond: stmt.Having}
+	}
+	return op
+}
+
+// limitOperator applies a SELECT's OFFSET and LIMIT, if it has either.
+func (e *Executor) limitOperator(op operator, stmt *SelectStmt) operator {
+	if stmt.Limit == nil && stmt.Offset == 0 {
+		return op
+	}
+	limit := int64(-1)
+	if stmt.Limit != nil {
+		limit = *stmt.Limit
+	}
+	return &limitOp{child: op, offset: stmt.Offset, limit: limit}
+}
+
+// executeExplain describes how a SELECT would run without running it.
+func (e *Executor) executeExplain(stmt *ExplainStmt) *Result {
+	sel := stmt.Select
+	root, err := e.buildPlanTree(sel)
+	if err != nil {
+		return &Result{Error: err}
+	}
+
+	var lines []string
+	root.render(&lines, "")
+	if sel.Hint != nil {
+		lines = append(lines, "Hint: "+sel.Hint.String())
+	}
+	return explainResult(lines)
+}
+
+// planNode is one operator of an EXPLAIN tree with its estimated output.
+type planNode struct {
+	name    string
+	details []string
+	rows    int64 // Estimated rows produced
+	child   *planNode
+}
+
+// render appends the node and its subtree, each child indented under an
+// arrow beneath its parent.
+func (n *planNode) render(lines *[]string, indent string) {
+	*lines = append(*lines, fmt.Sprintf("%s%s  (rows=%d)", indent, n.name, n.rows))
+	pad := strings.Repeat(" ", len(indent))
+	for _, d := range n.details {
+		*lines = append(*lines, pad+"  "+d)
+	}
+	if n.child != nil {
+		n.child.render(lines, pad+"  -> ")
+	}
+}
+
+// buildPlanTree builds the operator tree a SELECT runs as, from the scan up.
+func (e *Executor) buildPlanTree(sel *SelectStmt) (*planNode, error) {
+	var orderKeys []orderKey
+	for _, item := range sel.OrderBy {
+		orderKeys = append(orderKeys, orderKey{expr: item.Expr, desc: item.Desc})
+	}
+	grouped, _, err := planAggregation(sel, sel.Exprs, orderKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	var node *planNode
+	var plan scanPlan
+	if sel.TableName == "" {
+		node = &planNode{name: "Result", rows: 1}
+		if sel.Where != nil {
+			node.details = append(node.details, "Filter: "+exprString(sel.Where))
+		}
+	} else {
+		if e.catalog == nil {
+			return nil, fmt.Errorf("storage not initialized")
+		}
+		tableID, ok := e.catalog.GetTableID(sel.TableName)
+		if !ok {
+			return nil, fmt.Errorf("table %s does not exist", sel.TableName)
+		}
+		plan, err = e.planScan(sel, tableID, grouped)
+		if err != nil {
+			return nil, err
+		}
+		node = e.scanNode(sel, tableID, plan)
+	}
+
+	if grouped {
+		if len(sel.GroupBy) == 0 {
+			node = &planNode{name: "Aggregate", rows: 1, child: node}
+		} else {
+			keys := make([]string, len(sel.GroupBy))
+			for i, expr := range sel.GroupBy {
+				keys[i] = exprString(expr)
+			}
+			node = &planNode{
+				name:    "HashAggregate",
+				details: []string{"Group Key: " + strings.Join(keys, ", ")},
+				rows:    max(node.rows/10, 1),
+				child:   node,
+			}
+		}
+		if sel.Having != nil {
+			node.details = append(node.details, "Filter: "+exprString(sel.Having))
+		}
+	}
+
+	// An ordered index scan returns rows already sorted
+	if len(sel.OrderBy) > 0 && plan.path != pathIndexOrderScan {
+		keys := make([]string, len(sel.OrderBy))
+		for i, item := range sel.OrderBy {
+			keys[i] = exprString(item.Expr)
+			if item.Desc {
+				keys[i] += " DESC"
+			}
+		}
+		node = &planNode{name: "Sort (" + strings.Join(keys, ", ") + ")", rows: node.rows, child: node}
+	}
+
+	if sel.Limit != nil || sel.Offset > 0 {
+		limit := &planNode{name: "Limit", rows: max(node.rows-sel.Offset, 0), child: node}
+		if sel.Limit != nil {
+			limit.details = append(limit.details, fmt.Sprintf("Count: %d", *sel.Limit))
+			limit.rows = min(limit.rows, *sel.Limit)
+		}
+		if sel.Offset > 0 {
+			limit.details = append(limit.details, fmt.Sprintf("Offset: %d", sel.Offset))
+		}
+		node = limit
+	}
+	if sel.ForUpdate {
+		node = &planNode{name: "LockRows", rows: node.rows, child: node}
+	}
+	return node, nil
+}
+
+// scanNode describes the access path chosen for the SELECT's table.
+func (e *Executor) scanNode(sel *SelectStmt, tableID uint32, plan scanPlan) *planNode {
+	stats := e.tableStats(sel.TableName)
+	tableRows := e.estimateTableRows(tableID, stats)
+	switch plan.path {
+	case pathIndexScan:
+		return &planNode{
+			name:    fmt.Sprintf("Index Scan using %s on %s", plan.indexName, sel.TableName),
+			details: []string{"Index Cond: " + exprString(sel.Where)},
+			rows:    min(tableRows, 1),
+		}
+	case pathIndexRangeScan:
+		node := &planNode{
+			name:    fmt.Sprintf("Index Scan using %s on %s", plan.indexName, sel.TableName),
+			details: []string{"Index Cond: " + exprString(sel.Where)},
+			rows:    int64(float64(tableRows)*selectivity(sel.Where, stats) + 0.5),
+		}
+		if tableRows > 0 {
+			node.rows = max(node.rows, 1)
+		}
+		return node
+	case pathIndexOrderScan:
+		scan := "Index Scan"
+		if plan.desc {
+			scan = "Index Scan Backward"
+		}
+		return &planNode{
+			name:    fmt.Sprintf("%s using %s on %s", scan, plan.indexName, sel.TableName),
+			details: []string{"Order: " + exprString(sel.OrderBy[0].Expr)},
+			rows:    tableRows,
+		}
+	default:
+		node := &planNode{name: "Seq Scan on " + sel.TableName, rows: tableRows}
+		if sel.Where != nil {
+			node.details = append(node.details, "Filter: "+exprString(sel.Where))
+			node.rows = int64(float64(tableRows)*selectivity(sel.Where, stats) + 0.5)
+			if tableRows > 0 {
+				node.rows = max(node.rows, 1)
+			}
+		}
+		return node
+	}
+}
+
+// estimateTableRows returns the row count from the table's last ANALYZE.
+// Without one it counts the tuple versions in the table's pages from their
+// headers, without decoding any rows; dead versions are included until
+// VACUUM removes them.
+func (e *Executor) estimateTableRows(tableID uint32, stats *TableStats) int64 {
+	if stats != nil {
+		return stats.Rows
+	}
+	pages, err := e.catalog.GetTableHeap(tableID).PageStats()
+	if err != nil {
+		return 0
+	}
+	var rows int64
+	for _, page := range pages {
+		rows += int64(page.LiveTuples)
+	}
+	return rows
+}
+
+// selectivity guesses the fraction of rows a condition keeps. With ANALYZE
+// statistics, a column compared for equality keeps one of its distinct
+// values and IS NULL keeps the column's NULLs; otherwise the guesses are
+// fixed per operator.
+func selectivity(expr Expr, stats *TableStats) float64 {
+	switch ex := expr.(type) {
+	case *BinaryExpr:
+		switch ex.Op {
+		case TokenAnd:
+			return selectivity(ex.Left, stats) * selectivity(ex.Right, stats)
+		case TokenOr:
+			l, r := selectivity(ex.Left, stats), selectivity(ex.Right, stats)
+			return l + r - l*r
+		case TokenEq:
+			if n := distinctValues(ex, stats); n > 0 {
+				return 1 / float64(n)
+			}
+			return 0.1
+		case TokenNe:
+			if n := distinctValues(ex, stats); n > 0 {
+				return 1 - 1/float64(n)
+			}
+			return 0.9
+		default:
+			return 1.0 / 3
+		}
+	case *UnaryExpr:
+		if ex.Op == TokenNot {
+			return 1 - selectivity(ex.Operand, stats)
+		}
+	case *IsNullExpr:
+		s := 0.1
+		if col, ok := ex.Expr.(*ColumnExpr); ok && stats != nil && stats.Rows > 0 {
+			s = float64(stats.Nulls[col.Name]) / float64(stats.Rows)
+		}
+		if ex.Not {
+			return 1 - s
+		}
+		return s
+	case *InExpr:
+		s := min(0.1*float64(len(ex.Values)), 1)
+		if ex.Not {
+			return 1 - s
+		}
+		return s
+	case *BetweenExpr:
+		if ex.Not {
+			return 0.75
+		}
+		return 0.25
+	case *LiteralExpr:
+		if ex.Value.Type == types.ValueTypeBool && ex.Value.BoolVal {
+			return 1
+		}
+		return 0
+	}
+	return 0.5
+}
+
+// distinctValues returns the analyzed number of distinct values of the
+// column a comparison tests, or 0 if unknown.
+func distinctValues(ex *BinaryExpr, stats *TableStats) int64 {
+	if stats == nil {
+		return 0
+	}
+	col, ok := ex.Left.(*ColumnExpr)
+	if !ok {
+		if col, ok = ex.Right.(*ColumnExpr); !ok {
+			return 0
+		}
+	}
+	return stats.Distinct[col.Name]
+}
+
+func explainResult(lines []string) *Result {
+	result := &Result{Columns: []string{"plan"}, Message: "EXPLAIN"}
+	for _, line := range lines {
+		result.Rows = append(result.Rows, types.Row{Values: []types.Value{{Type: types.ValueTypeString, StrVal: line}}})
+	}
+	return result
+}
@@ -0,0 +1,242 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"minidb/internal/index"
+	"minidb/internal/storage"
+	"minidb/internal/txn"
+	"minidb/pkg/types"
+)
+
+// statementSavepoint names the savepoint INSERT, UPDATE and DELETE set
+// inside a transaction block, so that a statement failing halfway can be
+// undone on its own. The parser never yields an empty savepoint name.
+const statementSavepoint = ""
+
+// executeSavepoint sets a savepoint in the current transaction. With
+// autocommit off it starts the transaction, like any other statement.
+func (e *Executor) executeSavepoint(stmt *SavepointStmt) *Result {
+	if e.currentTxn == nil && e.session.Autocommit {
+		return &Result{Error: fmt.Errorf("SAVEPOINT can only be used in transaction blocks")}
+	}
+	t, _ := e.getTransaction()
+	e.setSavepoint(t, stmt.Name)
+	return &Result{Message: "SAVEPOINT"}
+}
+
+// setSavepoint sets a savepoint after t's last WAL record.
+func (e *Executor) setSavepoint(t *txn.Transaction, name string) {
+	lsn := types.InvalidLSN
+	if e.walWriter != nil {
+		lsn = e.walWriter.GetTxnLastLSN(t.ID)
+	}
+	t.SetSavepoint(name, lsn)
+}
+
+// executeRelease forgets a savepoint, keeping the changes made since.
+func (e *Executor) executeRelease(stmt *ReleaseStmt) *Result {
+	if e.currentTxn == nil {
+		return &Result{Error: fmt.Errorf("RELEASE SAVEPOINT can only be used in transaction blocks")}
+	}
+	if err := e.currentTxn.ReleaseSavepoint(stmt.Name); err != nil {
+		return &Result{Error: err}
+	}
+	return &Result{Message: "RELEASE"}
+}
+
+// executeRollbackTo reverts every row change the transaction made since a
+// savepoint.
+func (e *Executor) executeRollbackTo(stmt *RollbackToStmt) *Result {
+	if e.currentTxn == nil {
+		return &Result{Error: fmt.Errorf("ROLLBACK TO SAVEPOINT can only be used in transaction blocks")}
+	}
+	if err := e.rollbackToSavepoint(e.currentTxn, stmt.Name); err != nil {
+		return &Result{Error: err}
+	}
+	return &Result{Message: "ROLLBACK"}
+}
+
+// beginStatement sets the statement savepoint for a statement that runs in
+// a transaction block. An autocommit statement has its own transaction,
+// which failStatement rolls back as a whole.
+func (e *Executor) beginStatement(t *txn.Transaction, autoCommit bool) {
+	if !autoCommit {
+		e.setSavepoint(t, statementSavepoint)
+	}
+}
+
+// endStatement releases the savepoint set by beginStatement once the
+// statement has succeeded.
+func (e *Executor) endStatement(t *txn.Transaction, autoCommit bool) {
+	if !autoCommit {
+		t.ReleaseSavepoint(statementSavepoint)
+	}
+}
+
+// failStatement undoes the writes of a statement that failed with err and
+// returns its result: the autocommit transaction is rolled back, and in a
+// transaction block the rows the statement already changed are restored
+// while the transaction stays open. A deadlock victim is rolled back
+// whole, since the others wait until it gives up its locks.
+func (e *Executor) failStatement(t *txn.Transaction, autoCommit bool, err error) *Result {
+	if autoCommit {
+		if undoErr := e.abortTxn(t); undoErr != nil {
+			err = fmt.Errorf("%w (rolling back also failed: %v)", err, undoErr)
+		}
+		return &Result{Error: err}
+	}
+	if undoErr := e.rollbackToSavepoint(t, statementSavepoint); undoErr != nil {
+		err = fmt.Errorf("%w (undoing the statement also failed: %v)", err, undoErr)
+	}
+	t.ReleaseSavepoint(statementSavepoint)
+	if errors.Is(err, txn.ErrDeadlock) {
+		if res := e.executeRollback(); res.Error != nil {
+			err = fmt.Errorf("%w (rolling back also failed: %v)", err, res.Error)
+		}
+	}
+	return &Result{Error: err}
+}
+
+// rollbackToSavepoint reverts every row change t made since a savepoint.
+// Tables created or dropped since the savepoint are not affected.
+func (e *Executor) rollbackToSavepoint(t *txn.Transaction, name string) error {
+	sp, undo, err := t.RollbackToSavepoint(name)
+	if err != nil {
+		return err
+	}
+	if err := e.undoWrites(t, undo, sp.LSN); err != nil {
+		return fmt.Errorf("rollback to savepoint %s: %w", sp.Name, err)
+	}
+	return nil
+}
+
+// abortTxn rolls t back. The heap is the only record of which versions
+// exist, and visibility takes every finished transaction other than an
+// active one as committed, so the row changes t made are reverted first;
+// the manager then logs the abort and releases t's locks.
+func (e *Executor) abortTxn(t *txn.Transaction) error {
+	undoErr := e.undoWrites(t, t.TakeUndo(), t.FirstLSN)
+	if err := e.txnManager.Rollback(t); err != nil {
+		return err
+	}
+	if undoErr != nil {
+		return fmt.Errorf("rollback (txn %d): %w", t.ID, undoErr)
+	}
+	return nil
+}
+
+// undoWrites reverts writes t made, newest first. since is t's last log
+// record before the first of them. A version the transaction wrote is
+// deleted by the transaction itself, which hides it from everyone for good,
+// and a version it deleted gets its XMax cleared again. Each change is
+// logged as a CLR whose UndoNextLSN points past the reverted records, so
+// that crash recovery neither redoes the reverted state away nor undoes it
+// twice.
+func (e *Executor) undoWrites(t *txn.Transaction, undo []txn.UndoRecord, since types.LSN) error {
+	// The log record before each write, which a CLR for it skips back to
+	undoNext := make([]types.LSN, len(undo))
+	prev := since
+	for i, rec := range undo {
+		undoNext[i] = prev
+		if rec.LSN != types.InvalidLSN {
+			prev = rec.LSN
+		}
+	}
+
+	for i := len(undo) - 1; i >= 0; i-- {
+		if err := e.undoWrite(t, undo[i], undoNext[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undoWrite reverts one write recorded by t. undoNext is the log record
+// preceding the write.
+func (e *Executor) undoWrite(t *txn.Transaction, rec txn.UndoRecord, undoNext types.LSN) error {
+	heap := e.catalog.GetTableHeap(rec.TableID)
+	if heap == nil {
+		return nil // Dropped since
+	}
+
+	switch rec.Kind {
+	case txn.UndoInsert:
+		return e.setXMax(t, heap, rec, rec.PageID, rec.SlotNum, t.ID, undoNext)
+	case txn.UndoDelete:
+		if err := e.setXMax(t, heap, rec, rec.PageID, rec.SlotNum, types.InvalidTxnID, undoNext); err != nil {
+			return err
+		}
+		return e.restoreIndexEntry(heap, rec.TableID, rec.PageID, rec.SlotNum)
+	case txn.UndoUpdate:
+		// Until the old version is restored too, recovery must undo the
+		// whole update itself
+		if err := e.setXMax(t, heap, rec, rec.PageID, rec.SlotNum, t.ID, rec.LSN); err != nil {
+			return err
+		}
+		if err := e.setXMax(t, heap, rec, rec.OldPageID, rec.OldSlotNum, types.InvalidTxnID, undoNext); err != nil {
+			return err
+		}
+		return e.restoreIndexEntry(heap, rec.TableID, rec.OldPageID, rec.OldSlotNum)
+	}
+	return nil
+}
+
+// setXMax sets the XMax of the version at (pageID, slotNum) and logs the
+// resulting tuple as a CLR for the write rec, unless rec was not logged.
+func (e *Executor) setXMax(t *txn.Transaction, heap *storage.TableHeap, rec txn.UndoRecord, pageID types.PageID, slotNum uint16, xmax types.TxnID, undoNext types.LSN) error {
+	tuple, err := heap.Get(pageID, slotNum)
+	if err != nil {
+		return fmt.Errorf("read version at (page=%d, slot=%d): %w", pageID, slotNum, err)
+	}
+	tuple.XMax = xmax
+	if err := heap.Update(pageID, slotNum, tuple); err != nil {
+		return fmt.Errorf("write version at (page=%d, slot=%d): %w", pageID, slotNum, err)
+	}
+
+	if e.walWriter == nil || rec.LSN == types.InvalidLSN {
+		return nil
+	}
+	lsn := e.walWriter.LogCLR(t.ID, rec.TableID, tuple.RowID, pageID, slotNum, undoNext, tuple.Serialize())
+	if e.bufferPool != nil {
+		if p, err := e.bufferPool.FetchPage(pageID); err == nil {
+			p.SetLSN(lsn)
+			e.bufferPool.UnpinPage(pageID, true)
+		}
+	}
+	return nil
+}
+
+// restoreIndexEntry points the table's indexes back at a version that is
+// live again; a later write may have moved the entries for its keys.
+func (e *Executor) restoreIndexEntry(heap *storage.TableHeap, tableID uint32, pageID types.PageID, slotNum uint16) error {
+	if len(e.indexes[tableID]) == 0 {
+		return nil
+	}
+	var schema *types.Schema
+	for _, name := range e.catalog.GetAllTables() {
+		if id, _ := e.catalog.GetTableID(name); id == tableID {
+			schema = e.catalog.GetSchema(name)
+			break
+		}
+	}
+	if schema == nil {
+		return nil
+	}
+
+	tuple, err := heap.Get(pageID, slotNum)
+	if err != nil {
+		return fmt.Errorf("read version at (page=%d, slot=%d): %w", pageID, slotNum, err)
+	}
+	rowData, err := types.DeserializeRow(schema, tuple.Data)
+	if err != nil {
+		return err
+	}
+	for colName, bt := range e.indexes[tableID] {
+		if val, ok := rowData[colName]; ok {
+			rid := index.RID{PageID: pageID, SlotNum: slotNum, TableID: tableID}
+			bt.Insert(index.EntryKey(schema, colName, val, rid, 64), rid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package sql
+
+import (
+	"fmt"
+	"minidb/pkg/types"
+)
+
+// executeSelectInto runs SELECT ... INTO: it creates the target table with
+// one nullable column per select-list entry and inserts the result rows.
+// Constraints, defaults and SERIAL are not carried over from the source.
+func (e *Executor) executeSelectInto(stmt *SelectStmt) *Result {
+	if e.catalog == nil {
+		return &Result{Error: fmt.Errorf("storage not initialized")}
+	}
+	if e.catalog.GetSchema(stmt.Into) != nil {
+		return &Result{Error: fmt.Errorf("table %s already exists", stmt.Into)}
+	}
+
+	sel := *stmt
+	sel.Into = ""
+	rows := e.executeSelect(&sel, "")
+	if rows.Error != nil {
+		return rows
+	}
+
+	var source *types.Schema
+	if stmt.TableName != "" {
+		source = e.catalog.GetSchema(stmt.TableName)
+	}
+	columns, err := selectIntoColumns(stmt, source, rows)
+	if err != nil {
+		return &Result{Error: fmt.Errorf("SELECT INTO %s: %w", stmt.Into, err)}
+	}
+	if res := e.executeCreateTable(&CreateTableStmt{TableName: stmt.Into, Columns: columns}); res.Error != nil {
+		return res
+	}
+
+	inserts := make([]*InsertStmt, len(rows.Rows))
+	for i, row := range rows.Rows {
+		values := make([]Expr, len(row.Values))
+		for j, val := range row.Values {
+			values[j] = &LiteralExpr{Value: val}
+		}
+		inserts[i] = &InsertStmt{TableName: stmt.Into, Values: [][]Expr{values}}
+	}
+	if _, err := e.insertAll(inserts); err != nil {
+		return &Result{Error: fmt.Errorf("SELECT INTO %s: %w", stmt.Into, err)}
+	}
+	return &Result{Message: fmt.Sprintf("SELECT %d", len(inserts)), RowsAffected: len(inserts)}
+}
+
+// selectIntoColumns derives the target table's columns from the select
+// list. An entry is named by its AS alias; otherwise a column reference keeps
+// its name and any other expression is named column<N> after its position,
+// as in VALUES. A column reference keeps its type; any other expression is
+// typed by exprType or, failing that, by its first non-NULL result value.
+// Every result value must have its column's type, so the table is only
+// created if the rows fit.
+func selectIntoColumns(stmt *SelectStmt, source *types.Schema, rows *Result) ([]ColumnDef, error) {
+	exprs := stmt.Exprs
+	if len(stmt.Columns) == 1 && stmt.Columns[0] == "*" {
+		exprs = nil
+		for _, col := range source.Columns {
+			exprs = append(exprs, &ColumnExpr{Name: col.Name})
+		}
+	}
+
+	columns := make([]ColumnDef, len(exprs))
+	seen := make(map[string]bool)
+	for i, expr := range exprs {
+		name := fmt.Sprintf("column%d", i+1)
+		if col, ok := expr.(*ColumnExpr); ok {
+			name = col.Name
+		}
+		if i < len(stmt.Aliases) && stmt.Aliases[i] != "" {
+			name = stmt.Aliases[i]
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("column %s specified more than once", name)
+		}
+		seen[name] = true
+
+		typ, ok := exprType(expr, source)
+		for _, row := range rows.Rows {
+			if ok {
+				break
+			}
+			if val := row.Values[i]; !val.IsNull {
+				typ, ok = val.Type, true
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("cannot determine the type of %s", exprString(expr))
+		}
+		for _, row := range rows.Rows {
+			if val := row.Values[i]; !val.IsNull && val.Type != typ {
+				return nil, fmt.Errorf("column %s: expected %s, got %s", name, valueTypeName(typ), valueTypeName(val.Type))
+			}
+		}
+		columns[i] = ColumnDef{Name: name, Type: typ, Nullable: true}
+	}
+	return columns, nil
+}
+
+// exprType returns the type an expression evaluates to, when it can be told
+// without evaluating it: not for NULL or calls to registered functions.
+func exprType(expr Expr, schema *types.Schema) (types.ValueType, bool) {
+	switch ex := expr.(type) {
+	case *LiteralExpr:
+		return ex.Value.Type, !ex.Value.IsNull
+	case *ColumnExpr:
+		if schema == nil {
+			return types.ValueTypeNull, false
+		}
+		if col := schemaColumn(schema, ex.Name); col != nil {
+			return col.Type, true
+		}
+	case *BinaryExpr:
+		switch ex.Op {
+		case TokenPlus, TokenMinus, TokenStar, TokenSlash:
+			return types.ValueTypeInt, true
+		default:
+			return types.ValueTypeBool, true
+		}
+	case *UnaryExpr:
+		if ex.Op == TokenNot {
+			return types.ValueTypeBool, true
+		}
+		return types.ValueTypeInt, true
+	case *CollateExpr:
+		return exprType(ex.Expr, schema)
+	case *IsNullExpr, *InExpr, *BetweenExpr:
+		return types.ValueTypeBool, true
+	case *FuncCallExpr:
+		switch ex.Name {
+		case "UPPER", "LOWER", "SUBSTR":
+			return types.ValueTypeString, true
+		case "LENGTH", "ABS":
+			return types.ValueTypeInt, true
+		case "COALESCE":
+			for _, arg := range ex.Args {
+				if typ, ok := exprType(arg, schema); ok {
+					return typ, true
+				}
+			}
+		}
+	case *AggregateExpr:
+		if ex.Name == "MIN" || ex.Name == "MAX" {
+			return exprType(ex.Arg, schema)
+		}
+		return types.ValueTypeInt, true
+	}
+	return types.ValueTypeNull, false
+}
@@ -0,0 +1,120 @@
+package sql
+
+import (
+	"fmt"
+	"minidb/internal/txn"
+	"minidb/pkg/types"
+	"strings"
+)
+
+// IsolationLevel selects when a transaction's snapshot is taken.
+type IsolationLevel = txn.IsolationLevel
+
+const (
+	RepeatableRead = txn.RepeatableRead
+	ReadCommitted  = txn.ReadCommitted
+)
+
+// Session holds the per-session settings changed with SET and read with SHOW.
+type Session struct {
+	// Autocommit runs each statement outside BEGIN in its own transaction.
+	// When off, the first statement opens a transaction that stays open
+	// until COMMIT or ROLLBACK.
+	Autocommit bool
+	// Isolation is the isolation level for new transactions. BEGIN can
+	// override it for one transaction.
+	Isolation IsolationLevel
+	// RequireWhere rejects UPDATE and DELETE without a WHERE clause.
+	RequireWhere bool
+}
+
+// DefaultSession returns the settings a new session starts with.
+func DefaultSession() Session {
+	return Session{Autocommit: true, Isolation: RepeatableRead}
+}
+
+// Set changes a setting from its SQL text form.
+func (s *Session) Set(name, value string) error {
+	switch name {
+	case "autocommit":
+		return setBoolSetting(&s.Autocommit, name, value)
+	case "require_where":
+		return setBoolSetting(&s.RequireWhere, name, value)
+	case "isolation":
+		level, ok := parseIsolationLevel(value)
+		if !ok {
+			return fmt.Errorf("invalid value for isolation: %q (want 'read committed' or 'repeatable read')", value)
+		}
+		s.Isolation = level
+		return nil
+	default:
+		return fmt.Errorf("unknown setting %s", name)
+	}
+}
+
+// Get returns a setting in its SQL text form.
+func (s *Session) Get(name string) (string, error) {
+	switch name {
+	case "autocommit":
+		return onOff(s.Autocommit), nil
+	case "require_where":
+		return onOff(s.RequireWhere), nil
+	case "isolation":
+		return s.Isolation.String(), nil
+	default:
+		return "", fmt.Errorf("unknown setting %s", name)
+	}
+}
+
+// parseIsolationLevel parses a level name such as "read committed",
+// ignoring case and extra spaces.
+func parseIsolationLevel(value string) (IsolationLevel, bool) {
+	switch strings.ToLower(strings.Join(strings.Fields(value), " ")) {
+	case "repeatable read":
+		return RepeatableRead, true
+	case "read committed":
+		return ReadCommitted, true
+	}
+	return RepeatableRead, false
+}
+
+func setBoolSetting(dst *bool, name, value string) error {
+	switch strings.ToLower(value) {
+	case "on", "true", "1":
+		*dst = true
+	case "off", "false", "0":
+		*dst = false
+	default:
+		return fmt.Errorf("invalid value for %s: %q (want on or off)", name, value)
+	}
+	return nil
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func (e *Executor) executeSet(stmt *SetStmt) *Result {
+	if stmt.Name == "autocommit" && e.currentTxn != nil {
+		return &Result{Error: fmt.Errorf("cannot change autocommit inside a transaction")}
+	}
+	if err := e.session.Set(stmt.Name, stmt.Value); err != nil {
+		return &Result{Error: err}
+	}
+	return &Result{Message: "SET"}
+}
+
+func (e *Executor) executeShow(stmt *ShowStmt) *Result {
+	value, err := e.session.Get(stmt.Name)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	return &Result{
+		Columns: []string{stmt.Name},
+		Rows:    []types.Row{{Values: []types.Value{{Type: types.ValueTypeString, StrVal: value}}}},
+		Message: "SHOW",
+	}
+}
@@ -1,7 +1,10 @@
 package sql
 
 import (
+	"fmt"
 	"minidb/pkg/types"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -53,6 +56,27 @@ func TestLexerIdentifiers(t *testing.T) {
 	}
 }
 
+func TestLexerQuotedIdentifiers(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Token
+	}{
+		{`"order"`, Token{Type: TokenIdent, Literal: "order"}},
+		{`"SELECT"`, Token{Type: TokenIdent, Literal: "SELECT"}},
+		{`"MixedCase"`, Token{Type: TokenIdent, Literal: "MixedCase"}},
+		{`"with space"`, Token{Type: TokenIdent, Literal: "with space"}},
+		{`"say ""hi"""`, Token{Type: TokenIdent, Literal: `say "hi"`}},
+		{`"open`, Token{Type: TokenError, Literal: "unterminated quoted identifier"}},
+		{`""`, Token{Type: TokenError, Literal: "zero-length quoted identifier"}},
+	}
+	for _, tt := range tests {
+		tok := Tokenize(tt.input)[0]
+		if tok.Type != tt.want.Type || tok.Literal != tt.want.Literal {
+			t.Errorf("Tokenize(%q)[0] = %v, want %v", tt.input, tok, tt.want)
+		}
+	}
+}
+
 func TestLexerNumbers(t *testing.T) {
 	tokens := Tokenize("42 -7 0")
 	if tokens[0].Type != TokenNumber || tokens[0].Literal != "42" {
@@ -76,6 +100,25 @@ func TestLexerStrings(t *testing.T) {
 	}
 }
 
+func TestLexerHexStrings(t *testing.T) {
+	tokens := Tokenize("x'00FF' X'' xy")
+	if tokens[0].Type != TokenHex || tokens[0].Literal != "00FF" {
+		t.Errorf("token[0] = %v, want HEX 00FF", tokens[0])
+	}
+	if tokens[1].Type != TokenHex || tokens[1].Literal != "" {
+		t.Errorf("token[1] = %v, want empty HEX", tokens[1])
+	}
+	if tokens[2].Type != TokenIdent || tokens[2].Literal != "xy" {
+		t.Errorf("token[2] = %v, want identifier xy", tokens[2])
+	}
+
+	for _, in := range []string{"x'0'", "x'0G'"} {
+		if tokens := Tokenize(in); tokens[0].Type != TokenError {
+			t.Errorf("Tokenize(%q)[0] = %v, want ERROR", in, tokens[0])
+		}
+	}
+}
+
 func TestLexerOperators(t *testing.T) {
 	tests := []struct {
 		input string
@@ -114,6 +157,59 @@ func TestLexerBangError(t *testing.T) {
 	}
 }
 
+func TestLexerNumberForms(t *testing.T) {
+	valid := []string{"0", "42", "-7", "1.5", "-0.25", "2e10", "1.5E-3", "3e+2"}
+	for _, in := range valid {
+		tokens := Tokenize(in)
+		if tokens[0].Type != TokenNumber || tokens[0].Literal != in || tokens[1].Type != TokenEOF {
+			t.Errorf("Tokenize(%q) = %v, want one NUMBER %s", in, tokens, in)
+		}
+	}
+
+	malformed := []string{"1.2.3", "123abc", "1e", "1.", "12_3", "-4x", "1.5.2", "2e10q"}
+	for _, in := range malformed {
+		tokens := Tokenize(in)
+		if tokens[0].Type != TokenError || tokens[0].Literal != "malformed number "+in {
+			t.Errorf("Tokenize(%q)[0] = %s %q, want ERROR for the whole input", in, tokens[0].Type, tokens[0].Literal)
+		}
+	}
+
+	// "--" starts a comment, so "--5" is no number at all
+	if tokens := Tokenize("--5"); tokens[0].Type != TokenEOF {
+		t.Errorf("Tokenize(--5)[0] = %s, want EOF", tokens[0].Type)
+	}
+	// A number still ends at an operator or punctuation
+	tokens := Tokenize("1+2,3)")
+	want := []TokenType{TokenNumber, TokenPlus, TokenNumber, TokenComma, TokenNumber, TokenRParen, TokenEOF}
+	for i, tt := range want {
+		if tokens[i].Type != tt {
+			t.Errorf("token %d = %s, want %s", i, tokens[i].Type, tt)
+		}
+	}
+}
+
+func TestParseMalformedNumbers(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT 123abc", "malformed number 123abc"},
+		{"SELECT * FROM t WHERE id = 1.2.3", "malformed number 1.2.3"},
+		{"INSERT INTO t VALUES (1.5)", "decimal number 1.5 not supported"},
+		{"SELECT id - 2e3 FROM t", "decimal number 2e3 not supported"},
+	}
+	for _, tt := range tests {
+		_, err := NewParser(tt.sql).Parse()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.sql, err, tt.want)
+		}
+		// Only the bad number is reported
+		if err != nil && strings.Contains(err.Error(), "requires FROM") {
+			t.Errorf("Parse(%q) error = %v, want no FROM error", tt.sql, err)
+		}
+	}
+}
+
 // --- Parser tests ---
 
 func TestParseSelectStar(t *testing.T) {
@@ -223,8 +319,8 @@ func TestParseInsertWithColumns(t *testing.T) {
 	if len(ins.Columns) != 2 {
 		t.Errorf("Columns count = %d, want 2", len(ins.Columns))
 	}
-	if len(ins.Values) != 2 {
-		t.Errorf("Values count = %d, want 2", len(ins.Values))
+	if len(ins.Values) != 1 || len(ins.Values[0]) != 2 {
+		t.Errorf("Values = %v, want one tuple of 2", ins.Values)
 	}
 }
 
@@ -239,8 +335,61 @@ func TestParseInsertWithoutColumns(t *testing.T) {
 	if len(ins.Columns) != 0 {
 		t.Errorf("Columns = %v, want empty", ins.Columns)
 	}
-	if len(ins.Values) != 2 {
-		t.Errorf("Values count = %d, want 2", len(ins.Values))
+	if len(ins.Values) != 1 || len(ins.Values[0]) != 2 {
+		t.Errorf("Values = %v, want one tuple of 2", ins.Values)
+	}
+}
+
+func TestParseInsertMultipleRows(t *testing.T) {
+	p := NewParser("INSERT INTO users (id, name) VALUES (1, 'x'), (2, 'y'), (3, 'z')")
+	stmt, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	ins := stmt.(*InsertStmt)
+	if len(ins.Values) != 3 {
+		t.Fatalf("Values = %v, want 3 tuples", ins.Values)
+	}
+	for i, want := range []string{"x", "y", "z"} {
+		if len(ins.Values[i]) != 2 {
+			t.Fatalf("Values[%d] = %v, want 2 values", i, ins.Values[i])
+		}
+		id := ins.Values[i][0].(*LiteralExpr).Value
+		name := ins.Values[i][1].(*LiteralExpr).Value
+		if id.IntVal != int64(i+1) || name.StrVal != want {
+			t.Errorf("Values[%d] = (%v, %v), want (%d, %q)", i, id, name, i+1, want)
+		}
+	}
+
+	// A trailing comma needs another tuple
+	if _, err := NewParser("INSERT INTO users VALUES (1, 'x'),").Parse(); err == nil {
+		t.Error("Parse() with a trailing comma should error")
+	}
+}
+
+func TestParseIntegerLiteralRange(t *testing.T) {
+	for _, lit := range []string{"9223372036854775807", "-9223372036854775808"} {
+		stmt, err := NewParser("SELECT " + lit).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%s) error = %v", lit, err)
+		}
+		got := stmt.(*SelectStmt).Exprs[0].(*LiteralExpr).Value.String()
+		if got != lit {
+			t.Errorf("literal = %s, want %s", got, lit)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT 9223372036854775808",
+		"SELECT -9223372036854775809",
+		"INSERT INTO t VALUES (1, 99999999999999999999)",
+		"SELECT * FROM t WHERE id = 18446744073709551616",
+	} {
+		_, err := NewParser(sql).Parse()
+		if err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("Parse(%q) error = %v, want out of range", sql, err)
+		}
 	}
 }
 
@@ -334,6 +483,76 @@ func TestParseBeginCommitRollback(t *testing.T) {
 	}
 }
 
+func TestParseBeginIsolation(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string // "" means no level given
+	}{
+		{"BEGIN", ""},
+		{"BEGIN TRANSACTION", ""},
+		{"BEGIN READ COMMITTED", "read committed"},
+		{"BEGIN ISOLATION LEVEL REPEATABLE READ", "repeatable read"},
+		{"begin transaction isolation level read committed", "read committed"},
+		{"BEGIN WORK REPEATABLE READ", "repeatable read"},
+	}
+	for _, tt := range tests {
+		stmt, err := NewParser(tt.sql).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.sql, err)
+		}
+		begin, ok := stmt.(*BeginStmt)
+		if !ok {
+			t.Fatalf("Parse(%q) = %T, want *BeginStmt", tt.sql, stmt)
+		}
+		got := ""
+		if begin.Isolation != nil {
+			got = begin.Isolation.String()
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) isolation = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+
+	for _, sql := range []string{
+		"BEGIN ISOLATION LEVEL",
+		"BEGIN ISOLATION READ COMMITTED",
+		"BEGIN ISOLATION LEVEL SERIALIZABLE",
+		"BEGIN READ UNCOMMITTED",
+	} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestParseSavepoints(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want Statement
+	}{
+		{"SAVEPOINT sp1", &SavepointStmt{Name: "sp1"}},
+		{"ROLLBACK TO sp1", &RollbackToStmt{Name: "sp1"}},
+		{"ROLLBACK TO SAVEPOINT sp1", &RollbackToStmt{Name: "sp1"}},
+		{"RELEASE sp1", &ReleaseStmt{Name: "sp1"}},
+		{"RELEASE SAVEPOINT sp1", &ReleaseStmt{Name: "sp1"}},
+	}
+	for _, tt := range tests {
+		stmt, err := NewParser(tt.sql).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.sql, err)
+		}
+		if fmt.Sprintf("%#v", stmt) != fmt.Sprintf("%#v", tt.want) {
+			t.Errorf("Parse(%q) = %#v, want %#v", tt.sql, stmt, tt.want)
+		}
+	}
+
+	for _, sql := range []string{"SAVEPOINT", "ROLLBACK TO", "RELEASE SAVEPOINT 1"} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should fail without a savepoint name", sql)
+		}
+	}
+}
+
 func TestParseCreateTable(t *testing.T) {
 	p := NewParser("CREATE TABLE users (id INT NOT NULL, name TEXT, active BOOL)")
 	stmt, err := p.Parse()
@@ -366,6 +585,53 @@ func TestParseCreateTable(t *testing.T) {
 	}
 }
 
+func TestParseQuotedIdentifiers(t *testing.T) {
+	stmt, err := NewParser(`CREATE TABLE "Order" (id INT, "table" TEXT)`).Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	ct := stmt.(*CreateTableStmt)
+	if ct.TableName != "Order" || len(ct.Columns) != 2 || ct.Columns[1].Name != "table" {
+		t.Errorf("Parse() = %+v, want table Order with columns id and table", ct)
+	}
+
+	stmt, err = NewParser(`SELECT "table" FROM "Order" WHERE "table" = 'x'`).Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	sel := stmt.(*SelectStmt)
+	if sel.TableName != "Order" || len(sel.Columns) != 1 || sel.Columns[0] != "table" {
+		t.Errorf("Parse() = %+v, want SELECT table FROM Order", sel)
+	}
+
+	stmt, err = NewParser(`INSERT INTO "Order" (id, "table") VALUES (1, 'x')`).Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if ins := stmt.(*InsertStmt); len(ins.Columns) != 2 || ins.Columns[1] != "table" {
+		t.Errorf("Parse() columns = %v, want [id table]", ins.Columns)
+	}
+}
+
+func TestParseCreateTempTable(t *testing.T) {
+	for _, sql := range []string{
+		"CREATE TEMP TABLE tmp (id INT)",
+		"create temporary table tmp (id INT)",
+	} {
+		stmt, err := NewParser(sql).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", sql, err)
+		}
+		if ct := stmt.(*CreateTableStmt); !ct.Temp || ct.TableName != "tmp" {
+			t.Errorf("Parse(%q) = %+v, want temp table tmp", sql, ct)
+		}
+	}
+	stmt, _ := NewParser("CREATE TABLE temp (id INT)").Parse()
+	if ct := stmt.(*CreateTableStmt); ct.Temp || ct.TableName != "temp" {
+		t.Errorf("CREATE TABLE temp = %+v, want regular table named temp", ct)
+	}
+}
+
 func TestParseComparisonOperators(t *testing.T) {
 	ops := []struct {
 		sql string
@@ -416,36 +682,36 @@ func TestParseInsertValueTypes(t *testing.T) {
 	}
 
 	ins := stmt.(*InsertStmt)
-	if len(ins.Values) != 5 {
-		t.Fatalf("Values count = %d, want 5", len(ins.Values))
+	if len(ins.Values) != 1 || len(ins.Values[0]) != 5 {
+		t.Fatalf("Values = %v, want one tuple of 5", ins.Values)
 	}
 
 	// Check integer
-	lit0 := ins.Values[0].(*LiteralExpr)
+	lit0 := ins.Values[0][0].(*LiteralExpr)
 	if lit0.Value.Type != types.ValueTypeInt || lit0.Value.IntVal != 42 {
 		t.Errorf("Values[0] = %v, want Int 42", lit0.Value)
 	}
 
 	// Check string
-	lit1 := ins.Values[1].(*LiteralExpr)
+	lit1 := ins.Values[0][1].(*LiteralExpr)
 	if lit1.Value.Type != types.ValueTypeString || lit1.Value.StrVal != "hello" {
 		t.Errorf("Values[1] = %v, want String 'hello'", lit1.Value)
 	}
 
 	// Check true
-	lit2 := ins.Values[2].(*LiteralExpr)
+	lit2 := ins.Values[0][2].(*LiteralExpr)
 	if lit2.Value.Type != types.ValueTypeBool || !lit2.Value.BoolVal {
 		t.Errorf("Values[2] = %v, want Bool true", lit2.Value)
 	}
 
 	// Check false
-	lit3 := ins.Values[3].(*LiteralExpr)
+	lit3 := ins.Values[0][3].(*LiteralExpr)
 	if lit3.Value.Type != types.ValueTypeBool || lit3.Value.BoolVal {
 		t.Errorf("Values[3] = %v, want Bool false", lit3.Value)
 	}
 
 	// Check null
-	lit4 := ins.Values[4].(*LiteralExpr)
+	lit4 := ins.Values[0][4].(*LiteralExpr)
 	if !lit4.Value.IsNull {
 		t.Errorf("Values[4] = %v, want NULL", lit4.Value)
 	}
@@ -491,3 +757,1198 @@ func TestParserErrors(t *testing.T) {
 		t.Fatal("incomplete SELECT should error")
 	}
 }
+
+func TestLexerArithmeticOperators(t *testing.T) {
+	tokens := Tokenize("a + b - c / d * e")
+	expected := []TokenType{
+		TokenIdent, TokenPlus, TokenIdent, TokenMinus, TokenIdent,
+		TokenSlash, TokenIdent, TokenStar, TokenIdent, TokenEOF,
+	}
+	for i, tok := range tokens {
+		if tok.Type != expected[i] {
+			t.Errorf("token[%d].Type = %s, want %s", i, tok.Type, expected[i])
+		}
+	}
+}
+
+func TestParseSelectWithoutFrom(t *testing.T) {
+	p := NewParser("SELECT 1 + 2 * 3, UPPER('hi')")
+	stmt, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	sel := stmt.(*SelectStmt)
+	if sel.TableName != "" {
+		t.Errorf("TableName = %q, want empty", sel.TableName)
+	}
+	if len(sel.Exprs) != 2 {
+		t.Fatalf("Exprs count = %d, want 2", len(sel.Exprs))
+	}
+
+	// Multiplication binds tighter than addition
+	add, ok := sel.Exprs[0].(*BinaryExpr)
+	if !ok || add.Op != TokenPlus {
+		t.Fatalf("Exprs[0] = %T, want BinaryExpr(+)", sel.Exprs[0])
+	}
+	if mul, ok := add.Right.(*BinaryExpr); !ok || mul.Op != TokenStar {
+		t.Errorf("Exprs[0].Right = %T, want BinaryExpr(*)", add.Right)
+	}
+
+	call, ok := sel.Exprs[1].(*FuncCallExpr)
+	if !ok || call.Name != "UPPER" || len(call.Args) != 1 {
+		t.Errorf("Exprs[1] = %+v, want UPPER with 1 arg", sel.Exprs[1])
+	}
+
+	if sel.Columns[0] != "1 + (2 * 3)" || sel.Columns[1] != "UPPER('hi')" {
+		t.Errorf("Columns = %v", sel.Columns)
+	}
+}
+
+func TestParseSelectAliases(t *testing.T) {
+	stmt, err := NewParser("SELECT name, price * 2 AS double, price > 100 AS pricey FROM items").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	sel := stmt.(*SelectStmt)
+	if got := strings.Join(sel.Columns, ","); got != "name,double,pricey" {
+		t.Errorf("Columns = %s, want name,double,pricey", got)
+	}
+	if got := strings.Join(sel.Aliases, ","); got != ",double,pricey" {
+		t.Errorf("Aliases = %q, want only the AS names", sel.Aliases)
+	}
+	if cmp, ok := sel.Exprs[2].(*BinaryExpr); !ok || cmp.Op != TokenGt {
+		t.Errorf("Exprs[2] = %+v, want price > 100", sel.Exprs[2])
+	}
+
+	for _, sql := range []string{
+		"SELECT price AS FROM items",
+		"SELECT price AS 'p' FROM items",
+	} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestParseSubtractNegativeLiteral(t *testing.T) {
+	// "-1" is lexed as a number but follows an operand, so it is a subtraction
+	p := NewParser("SELECT a -1 FROM t")
+	stmt, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	sel := stmt.(*SelectStmt)
+	bin, ok := sel.Exprs[0].(*BinaryExpr)
+	if !ok || bin.Op != TokenMinus {
+		t.Fatalf("Exprs[0] = %T, want BinaryExpr(-)", sel.Exprs[0])
+	}
+	if lit := bin.Right.(*LiteralExpr); lit.Value.IntVal != 1 {
+		t.Errorf("Right = %d, want 1", lit.Value.IntVal)
+	}
+}
+
+func TestParseFunctionErrors(t *testing.T) {
+	tests := []string{
+		"SELECT NOSUCHFUNC(1)",
+		"SELECT UPPER('a', 'b')",
+		"SELECT *",
+	}
+
+	for _, sql := range tests {
+		p := NewParser(sql)
+		if _, err := p.Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestParseTruncate(t *testing.T) {
+	tests := []struct {
+		input   string
+		restart bool
+	}{
+		{"TRUNCATE TABLE users", false},
+		{"TRUNCATE users", false},
+		{"TRUNCATE TABLE users CONTINUE IDENTITY", false},
+		{"TRUNCATE TABLE users RESTART IDENTITY", true},
+		{"truncate users restart identity", true},
+	}
+
+	for _, tt := range tests {
+		p := NewParser(tt.input)
+		stmt, err := p.Parse()
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.input, err)
+			continue
+		}
+		tr, ok := stmt.(*TruncateStmt)
+		if !ok {
+			t.Errorf("Parse(%q) = %T, want *TruncateStmt", tt.input, stmt)
+			continue
+		}
+		if tr.TableName != "users" || tr.RestartIdentity != tt.restart {
+			t.Errorf("Parse(%q) = %+v", tt.input, tr)
+		}
+	}
+
+	p := NewParser("TRUNCATE TABLE users RESTART")
+	if _, err := p.Parse(); err == nil {
+		t.Error("RESTART without IDENTITY should error")
+	}
+}
+
+func TestParseAnalyze(t *testing.T) {
+	tests := []struct {
+		input string
+		table string
+	}{
+		{"ANALYZE", ""},
+		{"ANALYZE users", "users"},
+		{"analyze users;", "users"},
+	}
+
+	for _, tt := range tests {
+		stmt, err := NewParser(tt.input).Parse()
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.input, err)
+			continue
+		}
+		an, ok := stmt.(*AnalyzeStmt)
+		if !ok {
+			t.Errorf("Parse(%q) = %T, want *AnalyzeStmt", tt.input, stmt)
+			continue
+		}
+		if an.TableName != tt.table {
+			t.Errorf("Parse(%q) table = %q, want %q", tt.input, an.TableName, tt.table)
+		}
+	}
+
+	if _, err := NewParser("ANALYZE users orders").Parse(); err == nil {
+		t.Error("ANALYZE of two tables should error")
+	}
+}
+
+func TestParseCreateTableSerial(t *testing.T) {
+	p := NewParser("CREATE TABLE users (id SERIAL, name TEXT)")
+	stmt, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	ct := stmt.(*CreateTableStmt)
+	col := ct.Columns[0]
+	if !col.Serial || col.Type != types.ValueTypeInt || col.Nullable {
+		t.Errorf("Column[0] = %+v, want non-null serial INT", col)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	p := NewParser("CREATE TABLE events (id INT, at TIMESTAMP NOT NULL)")
+	stmt, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if col := stmt.(*CreateTableStmt).Columns[1]; col.Type != types.ValueTypeTimestamp || col.Nullable {
+		t.Errorf("Column[1] = %+v, want non-null TIMESTAMP", col)
+	}
+
+	p = NewParser("SELECT TIMESTAMP '2024-01-02T15:04:05Z'")
+	stmt, err = p.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	sel := stmt.(*SelectStmt)
+	lit, ok := sel.Exprs[0].(*LiteralExpr)
+	if !ok || lit.Value.Type != types.ValueTypeTimestamp || lit.Value.IntVal != 1704207845 {
+		t.Errorf("Exprs[0] = %+v, want the TIMESTAMP 1704207845", sel.Exprs[0])
+	}
+	if sel.Columns[0] != "TIMESTAMP '2024-01-02T15:04:05Z'" {
+		t.Errorf("Columns[0] = %q", sel.Columns[0])
+	}
+
+	for _, input := range []string{"SELECT TIMESTAMP '2024-13-01T00:00:00Z'", "SELECT TIMESTAMP 5"} {
+		if _, err := NewParser(input).Parse(); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", input)
+		}
+	}
+}
+
+func TestParseComment(t *testing.T) {
+	tests := []struct {
+		input string
+		want  CommentStmt
+	}{
+		{"COMMENT ON TABLE users IS 'app users'", CommentStmt{TableName: "users", Text: "app users"}},
+		{"COMMENT ON COLUMN users.id IS 'primary id'", CommentStmt{TableName: "users", ColumnName: "id", Text: "primary id"}},
+		{"COMMENT ON TABLE users IS NULL", CommentStmt{TableName: "users"}},
+	}
+
+	for _, tt := range tests {
+		p := NewParser(tt.input)
+		stmt, err := p.Parse()
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.input, err)
+			continue
+		}
+		if got := stmt.(*CommentStmt); *got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.input, *got, tt.want)
+		}
+	}
+
+	for _, input := range []string{"COMMENT ON users IS 'x'", "COMMENT ON COLUMN id IS 'x'", "COMMENT ON TABLE users 'x'"} {
+		p := NewParser(input)
+		if _, err := p.Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", input)
+		}
+	}
+}
+
+func TestParseDescribe(t *testing.T) {
+	p := NewParser("DESCRIBE users")
+	stmt, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if d := stmt.(*DescribeStmt); d.TableName != "users" {
+		t.Errorf("TableName = %q, want users", d.TableName)
+	}
+}
+
+func TestParseColumnConstraints(t *testing.T) {
+	p := NewParser("CREATE TABLE t (id INT PRIMARY KEY, email TEXT UNIQUE NOT NULL, n INT DEFAULT 1 + 1, s TEXT NULL)")
+	stmt, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	cols := stmt.(*CreateTableStmt).Columns
+	if len(cols) != 4 {
+		t.Fatalf("Columns count = %d, want 4", len(cols))
+	}
+	if !cols[0].PrimaryKey || !cols[0].Unique || cols[0].Nullable {
+		t.Errorf("id = %+v, want PRIMARY KEY", cols[0])
+	}
+	if !cols[1].Unique || cols[1].Nullable {
+		t.Errorf("email = %+v, want UNIQUE NOT NULL", cols[1])
+	}
+	if _, ok := cols[2].Default.(*BinaryExpr); !ok {
+		t.Errorf("n.Default = %T, want *BinaryExpr", cols[2].Default)
+	}
+	if !cols[3].Nullable {
+		t.Errorf("s = %+v, want nullable", cols[3])
+	}
+
+	p = NewParser("CREATE TABLE t (id INT PRIMARY)")
+	if _, err := p.Parse(); err == nil {
+		t.Error("PRIMARY without KEY should error")
+	}
+}
+
+func TestParseCollate(t *testing.T) {
+	p := NewParser("CREATE TABLE t (name TEXT COLLATE nocase NOT NULL, code TEXT COLLATE BINARY)")
+	stmt, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	cols := stmt.(*CreateTableStmt).Columns
+	if cols[0].Collation != types.CollationNoCase || cols[0].Nullable {
+		t.Errorf("name = %+v, want NOCASE NOT NULL", cols[0])
+	}
+	if cols[1].Collation != types.CollationBinary {
+		t.Errorf("code = %+v, want BINARY", cols[1])
+	}
+
+	stmt, err = NewParser("SELECT name FROM t WHERE name COLLATE NOCASE = 'a' ORDER BY UPPER(name) COLLATE BINARY DESC").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	sel := stmt.(*SelectStmt)
+	where := sel.Where.(*BinaryExpr)
+	if c, ok := where.Left.(*CollateExpr); !ok || c.Collation != types.CollationNoCase {
+		t.Errorf("Where.Left = %+v, want COLLATE NOCASE", where.Left)
+	}
+	if got := exprString(sel.Where); got != "name COLLATE NOCASE = 'a'" {
+		t.Errorf("exprString(Where) = %q", got)
+	}
+	if c, ok := sel.OrderBy[0].Expr.(*CollateExpr); !ok || c.Collation != types.CollationBinary || !sel.OrderBy[0].Desc {
+		t.Errorf("OrderBy[0] = %+v, want UPPER(name) COLLATE BINARY DESC", sel.OrderBy[0])
+	}
+
+	for _, sql := range []string{
+		"CREATE TABLE t (id INT COLLATE NOCASE)",
+		"CREATE TABLE t (name TEXT COLLATE german)",
+		"CREATE TABLE t (name TEXT COLLATE)",
+		"SELECT * FROM t WHERE name = 'a' COLLATE",
+		"SELECT * FROM t ORDER BY name COLLATE 'NOCASE'",
+	} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestParseIsNull(t *testing.T) {
+	tests := []struct {
+		sql  string
+		not  bool
+		text string
+	}{
+		{"SELECT * FROM t WHERE name IS NULL", false, "name IS NULL"},
+		{"SELECT * FROM t WHERE name is not null", true, "name IS NOT NULL"},
+		{"SELECT * FROM t WHERE id + 1 IS NULL", false, "(id + 1) IS NULL"},
+	}
+	for _, tt := range tests {
+		stmt, err := NewParser(tt.sql).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.sql, err)
+		}
+		where, ok := stmt.(*SelectStmt).Where.(*IsNullExpr)
+		if !ok || where.Not != tt.not {
+			t.Errorf("Parse(%q) Where = %+v, want IsNullExpr Not=%v", tt.sql, stmt.(*SelectStmt).Where, tt.not)
+			continue
+		}
+		if got := exprString(where); got != tt.text {
+			t.Errorf("exprString(%q) = %q, want %q", tt.sql, got, tt.text)
+		}
+	}
+
+	stmt, err := NewParser("SELECT * FROM t WHERE name IS NULL AND id = 1 OR city IS NOT NULL").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	or := stmt.(*SelectStmt).Where.(*BinaryExpr)
+	and, ok := or.Left.(*BinaryExpr)
+	if or.Op != TokenOr || !ok || and.Op != TokenAnd {
+		t.Fatalf("Where = %s, want (... AND ...) OR ...", exprString(or))
+	}
+	if _, ok := and.Left.(*IsNullExpr); !ok {
+		t.Errorf("AND left = %T, want *IsNullExpr", and.Left)
+	}
+	if _, ok := or.Right.(*IsNullExpr); !ok {
+		t.Errorf("OR right = %T, want *IsNullExpr", or.Right)
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM t WHERE name IS",
+		"SELECT * FROM t WHERE name IS NOT",
+		"SELECT * FROM t WHERE name IS 1",
+	} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestParseIn(t *testing.T) {
+	tests := []struct {
+		sql    string
+		not    bool
+		values int
+		text   string
+	}{
+		{"SELECT * FROM t WHERE id IN (1, 2, 3)", false, 3, "id IN (1, 2, 3)"},
+		{"SELECT * FROM t WHERE name NOT IN ('a')", true, 1, "name NOT IN ('a')"},
+		{"SELECT * FROM t WHERE id in ()", false, 0, "id IN ()"},
+		{"SELECT * FROM t WHERE id IN (1 + 1, -2)", false, 2, "id IN (1 + 1, -2)"},
+	}
+	for _, tt := range tests {
+		stmt, err := NewParser(tt.sql).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.sql, err)
+		}
+		in, ok := stmt.(*SelectStmt).Where.(*InExpr)
+		if !ok || in.Not != tt.not || len(in.Values) != tt.values {
+			t.Errorf("Parse(%q) Where = %+v, want InExpr Not=%v with %d values", tt.sql, stmt.(*SelectStmt).Where, tt.not, tt.values)
+			continue
+		}
+		if col, ok := in.Expr.(*ColumnExpr); !ok || col.Name == "" {
+			t.Errorf("Parse(%q) InExpr.Expr = %+v, want a column", tt.sql, in.Expr)
+		}
+		if got := exprString(in); got != tt.text {
+			t.Errorf("exprString(%q) = %q, want %q", tt.sql, got, tt.text)
+		}
+	}
+
+	stmt, err := NewParser("SELECT * FROM t WHERE id IN (1, 2) AND NOT name IN ('a')").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	and := stmt.(*SelectStmt).Where.(*BinaryExpr)
+	if _, ok := and.Left.(*InExpr); !ok || and.Op != TokenAnd {
+		t.Errorf("Where = %s, want IN ... AND NOT ...", exprString(and))
+	}
+	if not, ok := and.Right.(*UnaryExpr); !ok || not.Op != TokenNot {
+		t.Errorf("AND right = %T, want NOT", and.Right)
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM t WHERE id IN 1, 2",
+		"SELECT * FROM t WHERE id IN (1, 2",
+	} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestParseBetween(t *testing.T) {
+	stmt, err := NewParser("SELECT * FROM t WHERE id BETWEEN 1 AND 10 AND name NOT BETWEEN 'a' AND 'm'").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	and, ok := stmt.(*SelectStmt).Where.(*BinaryExpr)
+	if !ok || and.Op != TokenAnd {
+		t.Fatalf("Where = %+v, want BETWEEN ... AND NOT BETWEEN ...", stmt.(*SelectStmt).Where)
+	}
+	between, ok := and.Left.(*BetweenExpr)
+	if !ok || between.Not {
+		t.Fatalf("AND left = %+v, want BetweenExpr", and.Left)
+	}
+	if low, ok := between.Low.(*LiteralExpr); !ok || low.Value.IntVal != 1 {
+		t.Errorf("Low = %+v, want 1", between.Low)
+	}
+	if high, ok := between.High.(*LiteralExpr); !ok || high.Value.IntVal != 10 {
+		t.Errorf("High = %+v, want 10", between.High)
+	}
+	if notBetween, ok := and.Right.(*BetweenExpr); !ok || !notBetween.Not {
+		t.Errorf("AND right = %+v, want NOT BetweenExpr", and.Right)
+	}
+	if got := exprString(stmt.(*SelectStmt).Where); got != "id BETWEEN 1 AND 10 AND name NOT BETWEEN 'a' AND 'm'" {
+		t.Errorf("exprString(Where) = %q", got)
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM t WHERE id BETWEEN 1",
+		"SELECT * FROM t WHERE id BETWEEN 1 OR 2",
+	} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestParseSetShow(t *testing.T) {
+	tests := []struct {
+		sql   string
+		name  string
+		value string
+	}{
+		{"SET autocommit = off", "autocommit", "off"},
+		{"SET Require_Where = ON", "require_where", "ON"},
+		{"SET isolation = 'repeatable read'", "isolation", "repeatable read"},
+	}
+	for _, tt := range tests {
+		stmt, err := NewParser(tt.sql).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.sql, err)
+		}
+		s := stmt.(*SetStmt)
+		if s.Name != tt.name || s.Value != tt.value {
+			t.Errorf("Parse(%q) = %+v, want %s = %s", tt.sql, s, tt.name, tt.value)
+		}
+	}
+
+	stmt, err := NewParser("SHOW autocommit").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if s := stmt.(*ShowStmt); s.Name != "autocommit" {
+		t.Errorf("Name = %q, want autocommit", s.Name)
+	}
+
+	for _, sql := range []string{"SET = 1", "SET autocommit off", "SET autocommit =", "SHOW"} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestSessionSetGet(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"autocommit", "off", "off"},
+		{"autocommit", "TRUE", "on"},
+		{"require_where", "on", "on"},
+		{"require_where", "0", "off"},
+		{"isolation", "read committed", "read committed"},
+		{"isolation", "REPEATABLE  READ", "repeatable read"},
+	}
+	s := DefaultSession()
+	for _, tt := range tests {
+		if err := s.Set(tt.name, tt.value); err != nil {
+			t.Fatalf("Set(%s, %q) error = %v", tt.name, tt.value, err)
+		}
+		if got, _ := s.Get(tt.name); got != tt.want {
+			t.Errorf("Get(%s) after Set(%q) = %q, want %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+
+	if err := s.Set("autocommit", "maybe"); err == nil {
+		t.Error("invalid boolean should error")
+	}
+	if err := s.Set("isolation", "serializable"); err == nil {
+		t.Error("unsupported isolation level should error")
+	}
+	if _, err := s.Get("nope"); err == nil {
+		t.Error("unknown setting should error")
+	}
+}
+
+func TestParseOrderBy(t *testing.T) {
+	p := NewParser("SELECT name, price FROM items WHERE price > 0 ORDER BY 2 DESC, name ASC, price * 2")
+	stmt, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	sel := stmt.(*SelectStmt)
+	if sel.Where == nil {
+		t.Error("Where should be set")
+	}
+	if len(sel.OrderBy) != 3 {
+		t.Fatalf("OrderBy count = %d, want 3", len(sel.OrderBy))
+	}
+	if lit, ok := sel.OrderBy[0].Expr.(*LiteralExpr); !ok || lit.Value.IntVal != 2 || !sel.OrderBy[0].Desc {
+		t.Errorf("OrderBy[0] = %+v, want 2 DESC", sel.OrderBy[0])
+	}
+	if col, ok := sel.OrderBy[1].Expr.(*ColumnExpr); !ok || col.Name != "name" || sel.OrderBy[1].Desc {
+		t.Errorf("OrderBy[1] = %+v, want name ASC", sel.OrderBy[1])
+	}
+	if _, ok := sel.OrderBy[2].Expr.(*BinaryExpr); !ok {
+		t.Errorf("OrderBy[2] = %+v, want an expression", sel.OrderBy[2])
+	}
+
+	if _, err := NewParser("SELECT * FROM items ORDER name").Parse(); err == nil {
+		t.Error("ORDER without BY should error")
+	}
+}
+
+func TestParseLimitOffset(t *testing.T) {
+	tests := []struct {
+		sql    string
+		limit  int64 // -1 for no LIMIT
+		offset int64
+	}{
+		{"SELECT * FROM t LIMIT 10", 10, 0},
+		{"SELECT * FROM t ORDER BY id LIMIT 10 OFFSET 20", 10, 20},
+		{"SELECT * FROM t ORDER BY id OFFSET 20 LIMIT 10", 10, 20},
+		{"SELECT * FROM t OFFSET 5", -1, 5},
+		{"SELECT * FROM t LIMIT 0", 0, 0},
+	}
+	for _, tt := range tests {
+		stmt, err := NewParser(tt.sql).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.sql, err)
+		}
+		sel := stmt.(*SelectStmt)
+		limit := int64(-1)
+		if sel.Limit != nil {
+			limit = *sel.Limit
+		}
+		if limit != tt.limit || sel.Offset != tt.offset {
+			t.Errorf("Parse(%q) limit, offset = %d, %d; want %d, %d", tt.sql, limit, sel.Offset, tt.limit, tt.offset)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM t LIMIT",
+		"SELECT * FROM t LIMIT -1",
+		"SELECT * FROM t LIMIT 'ten'",
+		"SELECT * FROM t OFFSET 1 OFFSET 2",
+		"SELECT * FROM t LIMIT 1 LIMIT 2",
+	} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestParseForUpdate(t *testing.T) {
+	stmt, err := NewParser("SELECT * FROM t WHERE id = 1 ORDER BY id LIMIT 1 FOR UPDATE").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if sel := stmt.(*SelectStmt); !sel.ForUpdate || sel.Limit == nil {
+		t.Errorf("SelectStmt = %+v, want LIMIT 1 FOR UPDATE", sel)
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM t FOR",
+		"SELECT * FROM t FOR SHARE",
+		"SELECT 1 FOR UPDATE",
+		"SELECT id INTO copy FROM t FOR UPDATE",
+	} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestParseSelectInto(t *testing.T) {
+	stmt, err := NewParser("SELECT id, name INTO archive FROM users WHERE active = false").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	sel := stmt.(*SelectStmt)
+	if sel.Into != "archive" || sel.TableName != "users" || len(sel.Exprs) != 2 || sel.Where == nil {
+		t.Errorf("SelectStmt = %+v, want id, name INTO archive FROM users WHERE ...", sel)
+	}
+
+	for _, sql := range []string{
+		"SELECT * INTO FROM users",
+		"SELECT * INTO 'archive' FROM users",
+		"EXPLAIN SELECT * INTO archive FROM users",
+	} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	p := NewParser("SELECT substr(email, 1, 1), COUNT(*) FROM users WHERE id > 0 GROUP BY substr(email, 1, 1), id / 10 ORDER BY 2")
+	stmt, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	sel := stmt.(*SelectStmt)
+	if len(sel.GroupBy) != 2 {
+		t.Fatalf("GroupBy count = %d, want 2", len(sel.GroupBy))
+	}
+	if fn, ok := sel.GroupBy[0].(*FuncCallExpr); !ok || fn.Name != "SUBSTR" {
+		t.Errorf("GroupBy[0] = %+v, want SUBSTR call", sel.GroupBy[0])
+	}
+	if _, ok := sel.GroupBy[1].(*BinaryExpr); !ok {
+		t.Errorf("GroupBy[1] = %+v, want an expression", sel.GroupBy[1])
+	}
+	if agg, ok := sel.Exprs[1].(*AggregateExpr); !ok || agg.Name != "COUNT" || agg.Arg != nil {
+		t.Errorf("Exprs[1] = %+v, want COUNT(*)", sel.Exprs[1])
+	}
+	if sel.Columns[1] != "COUNT(*)" {
+		t.Errorf("Columns[1] = %q, want COUNT(*)", sel.Columns[1])
+	}
+	if len(sel.OrderBy) != 1 {
+		t.Errorf("OrderBy count = %d, want 1", len(sel.OrderBy))
+	}
+
+	stmt, err = NewParser("SELECT count(name) FROM users").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if agg, ok := stmt.(*SelectStmt).Exprs[0].(*AggregateExpr); !ok || agg.Arg == nil {
+		t.Errorf("Exprs[0] = %+v, want COUNT(name)", stmt.(*SelectStmt).Exprs[0])
+	}
+
+	if _, err := NewParser("SELECT id FROM users GROUP id").Parse(); err == nil {
+		t.Error("GROUP without BY should error")
+	}
+}
+
+func TestParseHaving(t *testing.T) {
+	stmt, err := NewParser("SELECT dept, COUNT(*) FROM emp GROUP BY dept HAVING COUNT(*) > 2 ORDER BY dept").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	sel := stmt.(*SelectStmt)
+	cond, ok := sel.Having.(*BinaryExpr)
+	if !ok || cond.Op != TokenGt {
+		t.Fatalf("Having = %+v, want COUNT(*) > 2", sel.Having)
+	}
+	if agg, ok := cond.Left.(*AggregateExpr); !ok || agg.Name != "COUNT" {
+		t.Errorf("Having.Left = %+v, want COUNT(*)", cond.Left)
+	}
+	if len(sel.OrderBy) != 1 {
+		t.Errorf("OrderBy count = %d, want 1", len(sel.OrderBy))
+	}
+
+	if _, err := NewParser("SELECT dept FROM emp GROUP BY dept HAVING").Parse(); err == nil {
+		t.Error("HAVING without a condition should error")
+	}
+}
+
+func TestParseAggregates(t *testing.T) {
+	stmt, err := NewParser("SELECT COUNT(*), sum(price), AVG(price), MIN(name), MAX(price * 2) FROM items").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	sel := stmt.(*SelectStmt)
+	wantNames := []string{"COUNT", "SUM", "AVG", "MIN", "MAX"}
+	for i, name := range wantNames {
+		agg, ok := sel.Exprs[i].(*AggregateExpr)
+		if !ok || agg.Name != name {
+			t.Errorf("Exprs[%d] = %+v, want %s aggregate", i, sel.Exprs[i], name)
+		}
+	}
+	if got := strings.Join(sel.Columns, ","); got != "COUNT(*),SUM(price),AVG(price),MIN(name),MAX(price * 2)" {
+		t.Errorf("Columns = %s", got)
+	}
+
+	if _, err := NewParser("SELECT SUM(*) FROM items").Parse(); err == nil {
+		t.Error("SUM(*) should error")
+	}
+}
+
+func TestParseCopy(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want CopyStmt
+	}{
+		{"COPY users TO '/tmp/u.csv'", CopyStmt{TableName: "users", Path: "/tmp/u.csv", To: true}},
+		{"COPY users FROM '/tmp/u.csv'", CopyStmt{TableName: "users", Path: "/tmp/u.csv"}},
+		{"copy users to 'u.csv' with (format csv, header true)", CopyStmt{TableName: "users", Path: "u.csv", To: true, Header: true}},
+		{"COPY users FROM 'u.csv' WITH (HEADER)", CopyStmt{TableName: "users", Path: "u.csv", Header: true}},
+		{"COPY users FROM 'u.csv' WITH (HEADER false, FORMAT CSV)", CopyStmt{TableName: "users", Path: "u.csv"}},
+	}
+	for _, tt := range tests {
+		stmt, err := NewParser(tt.sql).Parse()
+		if err != nil {
+			t.Fatalf("%s: Parse() error = %v", tt.sql, err)
+		}
+		if got := *stmt.(*CopyStmt); got != tt.want {
+			t.Errorf("%s = %+v, want %+v", tt.sql, got, tt.want)
+		}
+	}
+
+	for _, sql := range []string{
+		"COPY users INTO 'u.csv'",
+		"COPY users TO u.csv",
+		"COPY users TO 'u.csv' WITH (FORMAT binary)",
+		"COPY users TO 'u.csv' WITH (DELIMITER ';')",
+		"COPY users TO 'u.csv' WITH (HEADER true",
+	} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("%s: expected parse error", sql)
+		}
+	}
+}
+
+func TestResultToCSV(t *testing.T) {
+	str := func(s string) types.Value { return types.Value{Type: types.ValueTypeString, StrVal: s} }
+	r := &Result{
+		Columns: []string{"id", "name", "active"},
+		Rows: []types.Row{
+			{Values: []types.Value{{Type: types.ValueTypeInt, IntVal: 1}, str("Smith, Alice"), {Type: types.ValueTypeBool, BoolVal: true}}},
+			{Values: []types.Value{{Type: types.ValueTypeInt, IntVal: 2}, str(`say "hi"`), {IsNull: true}}},
+			{Values: []types.Value{{Type: types.ValueTypeInt, IntVal: 3}, str("two\nlines"), {Type: types.ValueTypeBool}}},
+		},
+	}
+
+	var buf strings.Builder
+	if err := r.ToCSV(&buf); err != nil {
+		t.Fatalf("ToCSV() error = %v", err)
+	}
+	want := "id,name,active\n" +
+		"1,\"Smith, Alice\",true\n" +
+		"2,\"say \"\"hi\"\"\",\n" +
+		"3,\"two\nlines\",false\n"
+	if buf.String() != want {
+		t.Errorf("ToCSV() =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	stmt, err := NewParser("VALUES (1, 'a'), (2, 'b'), (3 + 1, NULL)").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	v := stmt.(*ValuesStmt)
+	if len(v.Rows) != 3 {
+		t.Fatalf("Rows count = %d, want 3", len(v.Rows))
+	}
+	for i, row := range v.Rows {
+		if len(row) != 2 {
+			t.Errorf("Rows[%d] has %d values, want 2", i, len(row))
+		}
+	}
+
+	for _, sql := range []string{"VALUES", "VALUES ()", "VALUES (1), (2, 3)", "VALUES (1"} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestLexerComments(t *testing.T) {
+	tokens := Tokenize("SELECT /* plain */ 1 -- trailing\n + /*+ seqscan */ 2")
+	want := []TokenType{TokenSelect, TokenNumber, TokenPlus, TokenHint, TokenNumber, TokenEOF}
+	if len(tokens) != len(want) {
+		t.Fatalf("tokens = %v, want %d tokens", tokens, len(want))
+	}
+	for i, tt := range want {
+		if tokens[i].Type != tt {
+			t.Errorf("token %d = %s, want %s", i, tokens[i].Type, tt)
+		}
+	}
+	if tokens[3].Literal != "seqscan" {
+		t.Errorf("hint literal = %q, want seqscan", tokens[3].Literal)
+	}
+
+	// A negative number is still lexed as one token
+	if tokens := Tokenize("-5"); tokens[0].Type != TokenNumber {
+		t.Errorf("-5 lexed as %s, want NUMBER", tokens[0].Type)
+	}
+	if tokens := Tokenize("/* open"); tokens[0].Type != TokenError {
+		t.Errorf("unterminated comment lexed as %s, want ERROR", tokens[0].Type)
+	}
+}
+
+func TestLexerCommentsAcrossLinesAndAtEnd(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []TokenType
+	}{
+		{"SELECT /* spans\n two\n lines */ 1", []TokenType{TokenSelect, TokenNumber, TokenEOF}},
+		{"SELECT 1 -- no newline after this", []TokenType{TokenSelect, TokenNumber, TokenEOF}},
+		{"SELECT 1 /* at the end */", []TokenType{TokenSelect, TokenNumber, TokenEOF}},
+		{"-- only a comment", []TokenType{TokenEOF}},
+		{"/* a */ /* b */ -- c\n-- d\nSELECT", []TokenType{TokenSelect, TokenEOF}},
+		{"SELECT 'a -- b /* c */'", []TokenType{TokenSelect, TokenString, TokenEOF}},
+	}
+	for _, tt := range tests {
+		tokens := Tokenize(tt.input)
+		if len(tokens) != len(tt.want) {
+			t.Errorf("Tokenize(%q) = %v, want %d tokens", tt.input, tokens, len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			if tokens[i].Type != want {
+				t.Errorf("Tokenize(%q) token %d = %s, want %s", tt.input, i, tokens[i].Type, want)
+			}
+		}
+	}
+}
+
+func TestParseAnnotatedScript(t *testing.T) {
+	script := `-- Users of the app
+CREATE TABLE users (
+    id INT PRIMARY KEY, -- surrogate key
+    /* shown in the UI,
+       not unique */
+    name TEXT
+) -- end of table`
+	stmt, err := NewParser(script).Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	create, ok := stmt.(*CreateTableStmt)
+	if !ok || len(create.Columns) != 2 || create.Columns[1].Name != "name" {
+		t.Errorf("Parse() = %+v, want CREATE TABLE users with id and name", stmt)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		script string
+		want   []string
+	}{
+		{"SELECT 1", []string{"SELECT 1"}},
+		{"SELECT 1; SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"INSERT INTO t VALUES ('a;b'); SELECT \"x;y\" FROM t", []string{"INSERT INTO t VALUES ('a;b')", "SELECT \"x;y\" FROM t"}},
+		{"SELECT 1; -- done; really\n/* ; */ ;; SELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"  ;  -- nothing\n", nil},
+		{"SELECT 1; SELECT 'open", []string{"SELECT 1", "SELECT 'open"}},
+		{"SELECT 1; SELECT #; SELECT 2", []string{"SELECT 1", "SELECT #; SELECT 2"}},
+	}
+	for _, tt := range tests {
+		got := SplitStatements(tt.script)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitStatements(%q) = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestParseScanHint(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want ScanHint
+	}{
+		{"SELECT /*+ seqscan */ * FROM t WHERE id = 5", ScanHint{Kind: HintSeqScan}},
+		{"SELECT /*+ IndexScan */ * FROM t WHERE id = 5", ScanHint{Kind: HintIndexScan}},
+		{"SELECT /*+ index( t_id ) */ * FROM t WHERE id = 5", ScanHint{Kind: HintIndexScan, Index: "t_id"}},
+	}
+	for _, tt := range tests {
+		stmt, err := NewParser(tt.sql).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.sql, err)
+		}
+		sel := stmt.(*SelectStmt)
+		if sel.Hint == nil || *sel.Hint != tt.want {
+			t.Errorf("Parse(%q) hint = %+v, want %+v", tt.sql, sel.Hint, tt.want)
+		}
+	}
+
+	// A plain comment is not a hint
+	stmt, _ := NewParser("SELECT /* seqscan */ * FROM t").Parse()
+	if stmt.(*SelectStmt).Hint != nil {
+		t.Error("plain comment should not set a hint")
+	}
+
+	for _, sql := range []string{
+		"SELECT /*+ fullscan */ * FROM t",
+		"SELECT /*+ index() */ * FROM t",
+	} {
+		if _, err := NewParser(sql).Parse(); err == nil {
+			t.Errorf("Parse(%q) should error", sql)
+		}
+	}
+}
+
+func TestParseExplain(t *testing.T) {
+	stmt, err := NewParser("EXPLAIN SELECT * FROM t WHERE id = 1").Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if ex := stmt.(*ExplainStmt); ex.Select.TableName != "t" {
+		t.Errorf("Select.TableName = %q, want t", ex.Select.TableName)
+	}
+	if _, err := NewParser("EXPLAIN DELETE FROM t").Parse(); err == nil {
+		t.Error("EXPLAIN of a non-SELECT should error")
+	}
+}
+
+func TestASTString(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{
+			"SELECT name, age FROM users WHERE active = true AND age > 20",
+			`SelectStmt
+  Columns
+    ColumnExpr name
+    ColumnExpr age
+  From: users
+  Where
+    BinaryExpr AND
+      BinaryExpr =
+        ColumnExpr active
+        LiteralExpr TRUE
+      BinaryExpr >
+        ColumnExpr age
+        LiteralExpr 20`,
+		},
+		{
+			// Precedence: * binds tighter than +, NOT applies to the comparison
+			"SELECT /*+ seqscan */ * FROM t WHERE NOT a + b * 2 = -c OR UPPER(s) = 'X' ORDER BY 1 DESC",
+			`SelectStmt
+  Hint: seqscan
+  Columns: *
+  From: t
+  Where
+    BinaryExpr OR
+      UnaryExpr NOT
+        BinaryExpr =
+          BinaryExpr +
+            ColumnExpr a
+            BinaryExpr *
+              ColumnExpr b
+              LiteralExpr 2
+          UnaryExpr -
+            ColumnExpr c
+      BinaryExpr =
+        FuncCallExpr UPPER
+          ColumnExpr s
+        LiteralExpr 'X'
+  OrderBy
+    OrderByItem DESC
+      LiteralExpr 1`,
+		},
+		{
+			"SELECT id / 10, COUNT(*) FROM users GROUP BY id / 10",
+			`SelectStmt
+  Columns
+    BinaryExpr /
+      ColumnExpr id
+      LiteralExpr 10
+    AggregateExpr COUNT(*)
+  From: users
+  GroupBy
+    BinaryExpr /
+      ColumnExpr id
+      LiteralExpr 10`,
+		},
+		{
+			"UPDATE users SET name = 'bob', age = age + 1 WHERE id = 3",
+			`UpdateStmt
+  Table: users
+  Set
+    age =
+      BinaryExpr +
+        ColumnExpr age
+        LiteralExpr 1
+    name =
+      LiteralExpr 'bob'
+  Where
+    BinaryExpr =
+      ColumnExpr id
+      LiteralExpr 3`,
+		},
+		{
+			"CREATE TABLE users (id SERIAL PRIMARY KEY, name TEXT NOT NULL DEFAULT 'x')",
+			`CreateTableStmt
+  Table: users
+  ColumnDef id SERIAL NOT NULL UNIQUE PRIMARY KEY
+  ColumnDef name TEXT NOT NULL DEFAULT 'x'`,
+		},
+		{"BEGIN", "BeginStmt"},
+		{"BEGIN READ COMMITTED", "BeginStmt\n  Isolation: read committed"},
+	}
+
+	for _, tt := range tests {
+		stmt, dump, err := ParseDebug(tt.sql)
+		if err != nil {
+			t.Fatalf("ParseDebug(%q) error = %v", tt.sql, err)
+		}
+		if dump != tt.want {
+			t.Errorf("ParseDebug(%q) dump =\n%s\nwant\n%s", tt.sql, dump, tt.want)
+		}
+		if got := ASTString(stmt); got != dump {
+			t.Errorf("ASTString(%q) differs from ParseDebug dump", tt.sql)
+		}
+	}
+
+	if _, _, err := ParseDebug("SELECT FROM"); err == nil {
+		t.Error("ParseDebug of invalid SQL should error")
+	}
+}
+
+// --- Operator tests ---
+
+// valuesOp returns a fixed list of rows and counts how many were pulled.
+type valuesOp struct {
+	rows   []map[string]types.Value
+	pos    int
+	pulled int
+	opens  int
+}
+
+func (v *valuesOp) Open() error {
+	v.pos = 0
+	v.opens++
+	return nil
+}
+
+func (v *valuesOp) Next() (*execRow, error) {
+	if v.pos >= len(v.rows) {
+		return nil, nil
+	}
+	v.pos++
+	v.pulled++
+	return &execRow{values: v.rows[v.pos-1]}, nil
+}
+
+func (v *valuesOp) Close() {}
+
+func intRows(column string, values ...int64) []map[string]types.Value {
+	rows := make([]map[string]types.Value, len(values))
+	for i, n := range values {
+		rows[i] = map[string]types.Value{column: {Type: types.ValueTypeInt, IntVal: n}}
+	}
+	return rows
+}
+
+func TestOperatorPipeline(t *testing.T) {
+	e := NewExecutor(nil, nil)
+	input := &valuesOp{rows: intRows("n", 5, 3, 8, 1, 9, 2)}
+	col := &ColumnExpr{Name: "n"}
+
+	// SELECT n * 10 FROM ... WHERE n > 1 ORDER BY n DESC LIMIT 2 OFFSET 1
+	var op operator = &filterOp{e: e, child: input,
+		cond: &BinaryExpr{Left: col, Op: TokenGt, Right: &LiteralExpr{Value: types.Value{Type: types.ValueTypeInt, IntVal: 1}}}}
+	op = &sortOp{e: e, child: op, keys: []orderKey{{expr: col, desc: true}}}
+	op = &limitOp{child: op, offset: 1, limit: 2}
+	op = &projectOp{e: e, child: op,
+		exprs: []Expr{&BinaryExpr{Left: col, Op: TokenStar, Right: &LiteralExpr{Value: types.Value{Type: types.ValueTypeInt, IntVal: 10}}}}}
+
+	rows, err := drain(op)
+	if err != nil {
+		t.Fatalf("drain() error = %v", err)
+	}
+	var got []int64
+	for _, row := range rows {
+		got = append(got, row.out.Values[0].IntVal)
+	}
+	if len(got) != 2 || got[0] != 80 || got[1] != 50 {
+		t.Errorf("rows = %v, want [80 50]", got)
+	}
+}
+
+func TestLimitOperatorStopsEarly(t *testing.T) {
+	input := &valuesOp{rows: intRows("n", 1, 2, 3, 4, 5, 6, 7, 8)}
+	rows, err := drain(&limitOp{child: input, offset: 2, limit: 3})
+	if err != nil {
+		t.Fatalf("drain() error = %v", err)
+	}
+	if len(rows) != 3 || rows[0].values["n"].IntVal != 3 {
+		t.Errorf("rows = %d starting at %v, want 3 starting at 3", len(rows), rows[0].values["n"])
+	}
+	if input.pulled != 5 {
+		t.Errorf("pulled %d rows from the input, want 5", input.pulled)
+	}
+
+	input = &valuesOp{rows: intRows("n", 1, 2)}
+	if rows, _ := drain(&limitOp{child: input, offset: 5, limit: -1}); len(rows) != 0 {
+		t.Errorf("OFFSET past the end returned %d rows", len(rows))
+	}
+}
+
+func TestHashAggregateOperator(t *testing.T) {
+	e := NewExecutor(nil, nil)
+	count := &AggregateExpr{Name: "COUNT"}
+	op := &hashAggregateOp{e: e, child: &valuesOp{rows: intRows("n", 1, 2, 1, 1)},
+		groupBy: []Expr{&ColumnExpr{Name: "n"}}, aggs: []*AggregateExpr{count}}
+
+	rows, err := drain(op)
+	if err != nil {
+		t.Fatalf("drain() error = %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("groups = %d, want 2", len(rows))
+	}
+	if n, c := rows[0].values["n"].IntVal, rows[0].values[exprString(count)].IntVal; n != 1 || c != 3 {
+		t.Errorf("first group = (%d, %d), want (1, 3)", n, c)
+	}
+
+	// Without GROUP BY there is one group even for no input
+	op = &hashAggregateOp{e: e, child: &valuesOp{}, aggs: []*AggregateExpr{count}}
+	if rows, _ := drain(op); len(rows) != 1 || rows[0].values[exprString(count)].IntVal != 0 {
+		t.Errorf("aggregate over no rows = %d rows, want one row with COUNT 0", len(rows))
+	}
+}
+
+func TestNestedLoopJoinOperator(t *testing.T) {
+	e := NewExecutor(nil, nil)
+	outer := &valuesOp{rows: intRows("a", 1, 2, 3)}
+	inner := &valuesOp{rows: intRows("b", 2, 3, 4)}
+
+	// a < b
+	op := &nestedLoopJoinOp{e: e, outer: outer, inner: inner,
+		cond: &BinaryExpr{Left: &ColumnExpr{Name: "a"}, Op: TokenLt, Right: &ColumnExpr{Name: "b"}}}
+	rows, err := drain(op)
+	if err != nil {
+		t.Fatalf("drain() error = %v", err)
+	}
+	var got []string
+	for _, row := range rows {
+		got = append(got, fmt.Sprintf("%d-%d", row.values["a"].IntVal, row.values["b"].IntVal))
+	}
+	want := "1-2 1-3 1-4 2-3 2-4 3-4"
+	if strings.Join(got, " ") != want {
+		t.Errorf("joined = %v, want %s", got, want)
+	}
+	if inner.opens != 3 {
+		t.Errorf("inner opened %d times, want once per outer row", inner.opens)
+	}
+
+	// Without a condition every pair is returned
+	op = &nestedLoopJoinOp{e: e, outer: &valuesOp{rows: intRows("a", 1, 2)}, inner: &valuesOp{rows: intRows("b", 1, 2, 3)}}
+	if rows, _ := drain(op); len(rows) != 6 {
+		t.Errorf("cross join = %d rows, want 6", len(rows))
+	}
+}
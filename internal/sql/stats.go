@@ -0,0 +1,204 @@
+package sql
+
+import (
+	"fmt"
+	"minidb/pkg/types"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TableStats summarizes a table's visible rows as of its last ANALYZE.
+type TableStats struct {
+	Rows     int64
+	Distinct map[string]int64 // Distinct non-NULL values per column
+	Nulls    map[string]int64 // NULLs per column
+	Analyzed time.Time
+}
+
+// Statistics holds the planner statistics of each table, and counts the
+// rows changed in each table since it was last analyzed. One Statistics is
+// shared by all sessions of a database; it is safe for concurrent use.
+type Statistics struct {
+	mu      sync.Mutex
+	tables  map[string]*TableStats
+	changed map[string]int64
+}
+
+// NewStatistics creates an empty statistics store.
+func NewStatistics() *Statistics {
+	return &Statistics{
+		tables:  make(map[string]*TableStats),
+		changed: make(map[string]int64),
+	}
+}
+
+// Get returns the statistics of a table, or nil if it has not been
+// analyzed. The result must not be modified.
+func (s *Statistics) Get(table string) *TableStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tables[table]
+}
+
+// Changed returns the number of rows inserted, updated or deleted in a
+// table since it was last analyzed.
+func (s *Statistics) Changed(table string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed[table]
+}
+
+// Due returns the tables whose changes since their last ANALYZE exceed
+// baseRows plus fraction of their analyzed row count, in name order. A
+// table never analyzed counts as empty.
+func (s *Statistics) Due(fraction float64, baseRows int64) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []string
+	for table, n := range s.changed {
+		var rows int64
+		if stats, ok := s.tables[table]; ok {
+			rows = stats.Rows
+		}
+		if float64(n) > float64(baseRows)+fraction*float64(rows) {
+			due = append(due, table)
+		}
+	}
+	sort.Strings(due)
+	return due
+}
+
+// Forget drops a table's statistics and change count.
+func (s *Statistics) Forget(table string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tables, table)
+	delete(s.changed, table)
+}
+
+// recordChanges counts rows a statement changed. Rows changed by a
+// transaction that later rolls back are counted too.
+func (s *Statistics) recordChanges(table string, n int) {
+	if s == nil || n == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed[table] += int64(n)
+}
+
+// set stores a table's fresh statistics and resets its change count.
+func (s *Statistics) set(table string, stats *TableStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[table] = stats
+	delete(s.changed, table)
+}
+
+// recordChanges counts rows changed in a table for auto-analyze. Temp
+// tables are not tracked.
+func (e *Executor) recordChanges(tableID uint32, table string, n int) {
+	if !e.catalog.IsTemp(tableID) {
+		e.stats.recordChanges(table, n)
+	}
+}
+
+// tableStats returns the statistics of a table, or nil if there are none.
+func (e *Executor) tableStats(table string) *TableStats {
+	if e.stats == nil {
+		return nil
+	}
+	return e.stats.Get(table)
+}
+
+// executeAnalyze gathers the statistics of one table, or of every table but
+// the temp tables.
+func (e *Executor) executeAnalyze(stmt *AnalyzeStmt) *Result {
+	if e.catalog == nil {
+		return &Result{Error: fmt.Errorf("storage not initialized")}
+	}
+	if e.stats == nil {
+		return &Result{Error: fmt.Errorf("ANALYZE: statistics not enabled")}
+	}
+
+	tables := []string{stmt.TableName}
+	if stmt.TableName == "" {
+		tables = nil
+		for _, name := range e.catalog.GetAllTables() {
+			if tableID, ok := e.catalog.GetTableID(name); ok && !e.catalog.IsTemp(tableID) {
+				tables = append(tables, name)
+			}
+		}
+		sort.Strings(tables)
+	} else if e.catalog.GetSchema(stmt.TableName) == nil {
+		return &Result{Error: fmt.Errorf("table %s does not exist", stmt.TableName)}
+	}
+
+	txn, autoCommit := e.getTransaction()
+	var last *TableStats
+	for _, table := range tables {
+		tableID, _ := e.catalog.GetTableID(table)
+		schema := e.catalog.GetSchema(table)
+		stats, err := analyzeRows(e.scanOperator(e.catalog.GetTableHeap(tableID), schema, txn, nil, true), schema)
+		if err != nil {
+			if autoCommit {
+				e.txnManager.Commit(txn)
+			}
+			return &Result{Error: fmt.Errorf("ANALYZE %s: %w", table, err)}
+		}
+		e.stats.set(table, stats)
+		last = stats
+	}
+	if autoCommit {
+		e.txnManager.Commit(txn)
+	}
+
+	if stmt.TableName != "" {
+		return &Result{Message: fmt.Sprintf("ANALYZE %s (%d rows)", stmt.TableName, last.Rows)}
+	}
+	return &Result{Message: fmt.Sprintf("ANALYZE (%d tables)", len(tables))}
+}
+
+// analyzeRows counts the rows a scan returns and the distinct and NULL
+// values of each column.
+func analyzeRows(scan operator, schema *types.Schema) (*TableStats, error) {
+	stats := &TableStats{
+		Distinct: make(map[string]int64, len(schema.Columns)),
+		Nulls:    make(map[string]int64, len(schema.Columns)),
+	}
+	seen := make(map[string]map[string]bool, len(schema.Columns))
+	for _, col := range schema.Columns {
+		seen[col.Name] = make(map[string]bool)
+	}
+
+	if err := scan.Open(); err != nil {
+		return nil, err
+	}
+	defer scan.Close()
+	for {
+		row, err := scan.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		stats.Rows++
+		for _, col := range schema.Columns {
+			val := row.values[col.Name]
+			if val.IsNull {
+				stats.Nulls[col.Name]++
+				continue
+			}
+			seen[col.Name][groupKey([]types.Value{val})] = true
+		}
+	}
+
+	for name, values := range seen {
+		stats.Distinct[name] = int64(len(values))
+	}
+	stats.Analyzed = time.Now()
+	return stats, nil
+}
@@ -0,0 +1,90 @@
+package sql
+
+import (
+	"fmt"
+	"minidb/pkg/types"
+)
+
+// createTempTable creates a table visible only to this session and dropped
+// when the current transaction ends. It is stored in the catalog under a
+// name qualified by the transaction ID, so that sessions can each have a
+// temp table of the same name, and resolveTempTables maps the name back.
+func (e *Executor) createTempTable(schema *types.Schema) *Result {
+	name := schema.TableName
+	if e.currentTxn == nil && e.session.Autocommit {
+		return &Result{Error: fmt.Errorf("CREATE TEMP TABLE %s: temporary tables need a transaction block (BEGIN)", name)}
+	}
+	if _, exists := e.tempTables[name]; exists {
+		return &Result{Error: fmt.Errorf("table %s already exists", name)}
+	}
+
+	txn, _ := e.getTransaction()
+	schema.TableName = fmt.Sprintf("%s@%d", name, txn.ID)
+	tableID, err := e.catalog.CreateTempTable(schema, txn.ID)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	if e.tempTables == nil {
+		e.tempTables = make(map[string]string)
+	}
+	e.tempTables[name] = schema.TableName
+
+	return &Result{Message: fmt.Sprintf("CREATE TEMP TABLE %s (id=%d)", name, tableID)}
+}
+
+// resolveTempTables points a statement's table at this session's temp table
+// of that name, which hides a regular table with the same name.
+func (e *Executor) resolveTempTables(stmt Statement) {
+	if len(e.tempTables) == 0 {
+		return
+	}
+	resolve := func(name *string) {
+		if catalogName, ok := e.tempTables[*name]; ok {
+			*name = catalogName
+		}
+	}
+	switch s := stmt.(type) {
+	case *SelectStmt:
+		resolve(&s.TableName)
+	case *ExplainStmt:
+		resolve(&s.Select.TableName)
+	case *InsertStmt:
+		resolve(&s.TableName)
+	case *UpdateStmt:
+		resolve(&s.TableName)
+	case *DeleteStmt:
+		resolve(&s.TableName)
+	case *TruncateStmt:
+		resolve(&s.TableName)
+	case *CommentStmt:
+		resolve(&s.TableName)
+	case *DescribeStmt:
+		resolve(&s.TableName)
+	case *CopyStmt:
+		resolve(&s.TableName)
+	case *AnalyzeStmt:
+		resolve(&s.TableName)
+	}
+}
+
+// dropTempTables drops the temp tables of the transaction that just ended.
+func (e *Executor) dropTempTables() {
+	for _, catalogName := range e.tempTables {
+		if tableID, ok := e.catalog.GetTableID(catalogName); ok {
+			delete(e.indexes, tableID)
+		}
+		e.catalog.DropTable(catalogName)
+	}
+	e.tempTables = nil
+}
+
+// Close ends the session: its open cursors are closed, an open
+// transaction is rolled back and its temp tables are dropped.
+func (e *Executor) Close() {
+	for c := range e.cursors {
+		c.Close()
+	}
+	if e.currentTxn != nil {
+		e.executeRollback()
+	}
+}
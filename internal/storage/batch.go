@@ -0,0 +1,82 @@
+package storage
+
+import "minidb/pkg/types"
+
+// maxBatchPages bounds how many pages a WriteBatch keeps pinned at once.
+const maxBatchPages = 4
+
+// WriteBatch keeps the heap pages a statement modifies pinned until Release,
+// so consecutive row changes on the same page skip the buffer pool's
+// fetch/unpin round trip and mark the page dirty only once. At most
+// maxBatchPages stay pinned; the least recently used one is released when
+// another page is needed.
+type WriteBatch struct {
+	heap  *TableHeap
+	pages []*Page // Pinned pages, least recently used first
+}
+
+// NewWriteBatch starts a batch of modifications to the heap.
+func (th *TableHeap) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{heap: th}
+}
+
+// page returns the pinned page, fetching it if the batch does not hold it.
+func (b *WriteBatch) page(pageID types.PageID) (*Page, error) {
+	for i, p := range b.pages {
+		if p.ID == pageID {
+			copy(b.pages[i:], b.pages[i+1:])
+			b.pages[len(b.pages)-1] = p
+			return p, nil
+		}
+	}
+
+	if len(b.pages) >= maxBatchPages {
+		b.heap.bufferPool.UnpinPage(b.pages[0].ID, false)
+		b.pages = b.pages[1:]
+	}
+	p, err := b.heap.bufferPool.FetchPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+	// Dirty once up front so a concurrent checkpoint sees the page
+	b.heap.bufferPool.MarkDirty(pageID)
+	b.pages = append(b.pages, p)
+	return p, nil
+}
+
+// Update overwrites the tuple at the given RID.
+func (b *WriteBatch) Update(pageID types.PageID, slotNum uint16, tuple *types.Tuple) error {
+	p, err := b.page(pageID)
+	if err != nil {
+		return err
+	}
+	data, err := b.heap.encode(tuple)
+	if err != nil {
+		return err
+	}
+	err = p.UpdateTuple(slotNum, data)
+	b.heap.noteFreeSpace(p)
+	if err == nil {
+		checkTupleRoundTrip(p, slotNum, tuple)
+	}
+	return err
+}
+
+// SetLSN records the LSN of the latest change to a page.
+func (b *WriteBatch) SetLSN(pageID types.PageID, lsn types.LSN) error {
+	p, err := b.page(pageID)
+	if err != nil {
+		return err
+	}
+	p.SetLSN(lsn)
+	return nil
+}
+
+// Release unpins every page held by the batch. It is safe to call more
+// than once.
+func (b *WriteBatch) Release() {
+	for _, p := range b.pages {
+		b.heap.bufferPool.UnpinPage(p.ID, true)
+	}
+	b.pages = nil
+}
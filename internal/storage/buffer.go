@@ -1,91 +1,277 @@
 package storage
 
 import (
-	"container/list"
 	"fmt"
 	"minidb/pkg/types"
 	"sync"
 )
 
-// BufferPool manages page caching with LRU eviction.
+const (
+	// maxBufferShards bounds the number of shards NewBufferPool creates.
+	maxBufferShards = 16
+	// minShardPages is the smallest share of the capacity NewBufferPool
+	// gives a shard, so that small pools keep one exact LRU order.
+	minShardPages = 64
+)
+
+// BufferPool manages page caching with LRU or clock eviction (see
+// SetEvictionPolicy). The cache is split
+// into shards by page ID, each with its own lock, replacer and share of the
+// capacity, so that goroutines working on different pages rarely wait for
+// each other. Eviction happens within the shard a page maps to.
 type BufferPool struct {
-	mu          sync.Mutex
-	diskManager *DiskManager
+	store  PageStore
+	shards []*bufferShard
+}
+
+// bufferShard is one partition of a BufferPool.
+type bufferShard struct {
+	mu sync.Mutex
 	
 	// Page cache
 	pages    map[types.PageID]*Page
 	capacity int
 	
-	// LRU tracking
-	lruList  *list.List
-	lruMap   map[types.PageID]*list.Element
+	// Eviction order
+	replacer replacer
+	
+	// Pages marked with PinPermanent, evicted only as a last resort
+	permanent map[types.PageID]bool
+	
+	// Pages Prefetch is reading; the channel closes when the read is done
+	prefetching map[types.PageID]chan struct{}
+	
+	// Pages evicted at once when the shard is full
+	evictBatch int
+	
+	// Validate pages after each change (SetStrictChecks)
+	strict bool
 	
 	// Statistics
-	hits   uint64
-	misses uint64
+	hits       uint64
+	misses     uint64
+	prefetches uint64 // Pages Prefetch read from the store
+}
+
+// NewBufferPool creates a new buffer pool, with one shard per minShardPages
+// pages of capacity, up to maxBufferShards.
+func NewBufferPool(store PageStore, capacity int) *BufferPool {
+	return NewBufferPoolShards(store, capacity, min(capacity/minShardPages, maxBufferShards))
+}
+
+// NewBufferPoolShards creates a buffer pool split into the given number of
+// shards (at least 1, and at most one per page of capacity). The capacity
+// is divided evenly among them.
+func NewBufferPoolShards(store PageStore, capacity, shards int) *BufferPool {
+	shards = max(min(shards, capacity), 1)
+	bp := &BufferPool{
+		store:  store,
+		shards: make([]*bufferShard, shards),
+	}
+	for i := range bp.shards {
+		shardCapacity := capacity / shards
+		if i < capacity%shards {
+			shardCapacity++
+		}
+		bp.shards[i] = &bufferShard{
+			pages:       make(map[types.PageID]*Page),
+			capacity:    shardCapacity,
+			replacer:    newLRUReplacer(),
+			permanent:   make(map[types.PageID]bool),
+			prefetching: make(map[types.PageID]chan struct{}),
+			evictBatch:  1,
+		}
+	}
+	return bp
+}
+
+// shard returns the shard that caches pageID.
+func (bp *BufferPool) shard(pageID types.PageID) *bufferShard {
+	return bp.shards[uint32(pageID)%uint32(len(bp.shards))]
+}
+
+// SetEvictBatch sets how many pages are evicted at once when a page has to
+// be brought into a full shard (n < 1 means 1). Evicting several pages per
+// LRU scan, with their dirty pages written in one WritePages call, spreads
+// the cost of eviction over the misses that follow.
+func (bp *BufferPool) SetEvictBatch(n int) {
+	if n < 1 {
+		n = 1
+	}
+	for _, s := range bp.shards {
+		s.mu.Lock()
+		s.evictBatch = n
+		s.mu.Unlock()
+	}
+}
+
+// SetEvictionPolicy selects how each shard picks the pages to evict:
+// EvictionLRU (the default, also "") or EvictionClock. LRU evicts in exact
+// access order but moves a list element on every hit and walks past
+// pinned pages from the tail; clock only sets a bit per access and sweeps
+// a fixed ring of frames. Cached pages are kept, but their access history
+// is not carried over.
+func (bp *BufferPool) SetEvictionPolicy(policy string) error {
+	for _, s := range bp.shards {
+		r, err := newReplacer(policy, s.capacity)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		for pageID := range s.pages {
+			r.add(pageID)
+		}
+		s.replacer = r
+		s.mu.Unlock()
+	}
+	return nil
 }
 
-// NewBufferPool creates a new buffer pool.
-func NewBufferPool(diskManager *DiskManager, capacity int) *BufferPool {
-	return &BufferPool{
-		diskManager: diskManager,
-		pages:       make(map[types.PageID]*Page),
-		capacity:    capacity,
-		lruList:     list.New(),
-		lruMap:      make(map[types.PageID]*list.Element),
+// SetStrictChecks turns on validation of storage invariants for the pages
+// the pool hands out from now on, and for the B-Trees built on it: a page
+// is checked after each change to its slots, and a tuple after it is
+// written. A violation panics. Off by default; it is meant for development.
+func (bp *BufferPool) SetStrictChecks(on bool) {
+	for _, s := range bp.shards {
+		s.mu.Lock()
+		s.strict = on
+		for _, page := range s.pages {
+			page.strict = on
+		}
+		s.mu.Unlock()
 	}
 }
 
-// FetchPage retrieves a page, reading from disk if necessary.
+// StrictChecks reports whether SetStrictChecks is on.
+func (bp *BufferPool) StrictChecks() bool {
+	s := bp.shards[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.strict
+}
+
+// FetchPage retrieves a page, reading from disk if necessary. A page that
+// Prefetch is reading is waited for rather than read again.
 func (bp *BufferPool) FetchPage(pageID types.PageID) (*Page, error) {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waitPrefetch(pageID)
 	
 	// Check cache
-	if page, ok := bp.pages[pageID]; ok {
-		bp.hits++
-		bp.touchLRU(pageID)
+	if page, ok := s.pages[pageID]; ok {
+		s.hits++
+		s.replacer.touch(pageID)
 		page.PinCount++
 		return page, nil
 	}
 	
-	bp.misses++
+	s.misses++
 	
 	// Read from disk
-	page, err := bp.diskManager.ReadPage(pageID)
+	page, err := bp.store.ReadPage(pageID)
 	if err != nil {
 		return nil, err
 	}
 	
 	// Make room if needed
-	if len(bp.pages) >= bp.capacity {
-		if err := bp.evictOne(); err != nil {
+	if len(s.pages) >= s.capacity {
+		if err := s.evictN(bp.store, s.evictBatch); err != nil {
 			return nil, fmt.Errorf("eviction failed: %w", err)
 		}
 	}
 	
 	// Add to cache
-	bp.pages[pageID] = page
-	bp.addToLRU(pageID)
+	page.strict = s.strict
+	s.pages[pageID] = page
+	s.replacer.add(pageID)
 	page.PinCount = 1
 	
 	return page, nil
 }
 
+// Prefetch reads pages into the cache in the background, so that fetching
+// them soon after finds them there. Pages already cached or being read are
+// skipped. A prefetched page is cached unpinned, and only in place of an
+// unpinned page not marked with PinPermanent; if its shard has none, or the
+// read fails, the page is dropped and FetchPage reads it as usual.
+// Prefetching counts as neither a hit nor a miss.
+func (bp *BufferPool) Prefetch(pageIDs []types.PageID) {
+	for _, pageID := range pageIDs {
+		s := bp.shard(pageID)
+		s.mu.Lock()
+		_, cached := s.pages[pageID]
+		_, reading := s.prefetching[pageID]
+		if cached || reading {
+			s.mu.Unlock()
+			continue
+		}
+		done := make(chan struct{})
+		s.prefetching[pageID] = done
+		s.mu.Unlock()
+		
+		go func() {
+			page, err := bp.store.ReadPage(pageID)
+			
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.prefetching, pageID)
+			defer close(done)
+			if err != nil {
+				return
+			}
+			s.prefetches++
+			if len(s.pages) >= s.capacity {
+				victims := s.replacer.victims(1, func(pageID types.PageID) bool {
+					return s.pages[pageID].PinCount == 0 && !s.permanent[pageID]
+				})
+				if len(victims) == 0 || s.evict(bp.store, victims) != nil {
+					return
+				}
+			}
+			page.strict = s.strict
+			s.pages[pageID] = page
+			s.replacer.add(pageID)
+		}()
+	}
+}
+
+// waitPrefetch waits until Prefetch is no longer reading pageID.
+// Must be called with lock held; it is released while waiting.
+func (s *bufferShard) waitPrefetch(pageID types.PageID) {
+	for {
+		done, ok := s.prefetching[pageID]
+		if !ok {
+			return
+		}
+		s.mu.Unlock()
+		<-done
+		s.mu.Lock()
+	}
+}
+
 // NewPage creates a new page and adds it to the buffer pool.
 func (bp *BufferPool) NewPage(pageType uint8) (*Page, error) {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
-	
 	// Allocate on disk
-	pageID, err := bp.diskManager.AllocatePage()
+	pageID, err := bp.store.AllocatePage()
 	if err != nil {
 		return nil, err
 	}
 	
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	// A reused page may have been prefetched while it was free
+	s.waitPrefetch(pageID)
+	if _, ok := s.pages[pageID]; ok {
+		delete(s.pages, pageID)
+		s.replacer.remove(pageID)
+	}
+	
 	// Make room if needed
-	if len(bp.pages) >= bp.capacity {
-		if err := bp.evictOne(); err != nil {
+	if len(s.pages) >= s.capacity {
+		if err := s.evictN(bp.store, s.evictBatch); err != nil {
 			return nil, fmt.Errorf("eviction failed: %w", err)
 		}
 	}
@@ -94,19 +280,41 @@ func (bp *BufferPool) NewPage(pageType uint8) (*Page, error) {
 	page := NewPage(pageID, pageType)
 	page.IsDirty = true
 	page.PinCount = 1
+	page.strict = s.strict
 	
-	bp.pages[pageID] = page
-	bp.addToLRU(pageID)
+	s.pages[pageID] = page
+	s.replacer.add(pageID)
 	
 	return page, nil
 }
 
+// FreePage drops a page from the cache and returns it to the disk manager's
+// free list. The page must not be pinned.
+func (bp *BufferPool) FreePage(pageID types.PageID) error {
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waitPrefetch(pageID)
+	
+	if page, ok := s.pages[pageID]; ok {
+		if page.PinCount > 0 {
+			return fmt.Errorf("page %d is pinned", pageID)
+		}
+		delete(s.pages, pageID)
+		s.replacer.remove(pageID)
+	}
+	delete(s.permanent, pageID)
+	
+	return bp.store.FreePage(pageID)
+}
+
 // UnpinPage decrements the pin count for a page.
 func (bp *BufferPool) UnpinPage(pageID types.PageID, isDirty bool) {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
-	if page, ok := bp.pages[pageID]; ok {
+	if page, ok := s.pages[pageID]; ok {
 		if isDirty {
 			page.IsDirty = true
 		}
@@ -116,18 +324,40 @@ func (bp *BufferPool) UnpinPage(pageID types.PageID, isDirty bool) {
 	}
 }
 
+// PinPermanent marks a page as eviction-resistant: it stays cached while
+// any other unpinned page of its shard can be evicted instead. Unlike a pin
+// it does not stop eviction outright, does not count towards PinCount, and
+// applies whether or not the page is currently cached. Used for metadata
+// pages such as the catalog and B-Tree roots that every statement reads.
+func (bp *BufferPool) PinPermanent(pageID types.PageID) {
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.permanent[pageID] = true
+}
+
+// UnpinPermanent clears a PinPermanent mark, returning the page to plain
+// LRU eviction.
+func (bp *BufferPool) UnpinPermanent(pageID types.PageID) {
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.permanent, pageID)
+}
+
 // FlushPage writes a page to disk.
 func (bp *BufferPool) FlushPage(pageID types.PageID) error {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
-	page, ok := bp.pages[pageID]
+	page, ok := s.pages[pageID]
 	if !ok {
 		return nil // Not in buffer pool
 	}
 	
 	if page.IsDirty {
-		if err := bp.diskManager.WritePage(page); err != nil {
+		if err := bp.store.WritePage(page); err != nil {
 			return err
 		}
 		page.IsDirty = false
@@ -136,108 +366,143 @@ func (bp *BufferPool) FlushPage(pageID types.PageID) error {
 	return nil
 }
 
-// FlushAllPages writes all dirty pages to disk.
+// FlushAllPages writes all dirty pages of every shard to disk.
 func (bp *BufferPool) FlushAllPages() error {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
+	for _, s := range bp.shards {
+		if err := s.flushAll(bp.store); err != nil {
+			return err
+		}
+	}
+	
+	return bp.store.Sync()
+}
+
+// flushAll writes the shard's dirty pages to store.
+func (s *bufferShard) flushAll(store PageStore) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
-	for _, page := range bp.pages {
+	for _, page := range s.pages {
 		if page.IsDirty {
-			if err := bp.diskManager.WritePage(page); err != nil {
+			if err := store.WritePage(page); err != nil {
 				return err
 			}
 			page.IsDirty = false
 		}
 	}
-	
-	return bp.diskManager.Sync()
+	return nil
 }
 
-// evictOne evicts one page from the buffer pool.
+// evictN evicts up to count of the shard's unpinned pages in the order
+// its replacer picks them, preferring pages not marked with PinPermanent: a
+// permanent page is evicted only when no other page can be, and then just
+// the one. Dirty victims are written with a single WritePages call. It
+// fails if no page can be evicted.
 // Must be called with lock held.
-func (bp *BufferPool) evictOne() error {
-	// Find LRU page that's not pinned
-	for e := bp.lruList.Back(); e != nil; e = e.Prev() {
-		pageID := e.Value.(types.PageID)
-		page := bp.pages[pageID]
-		
-		if page.PinCount == 0 {
-			// Flush if dirty
-			if page.IsDirty {
-				if err := bp.diskManager.WritePage(page); err != nil {
-					return err
-				}
-			}
-			
-			// Remove from cache
-			delete(bp.pages, pageID)
-			bp.lruList.Remove(e)
-			delete(bp.lruMap, pageID)
-			
-			return nil
-		}
+func (s *bufferShard) evictN(store PageStore, count int) error {
+	victims := s.replacer.victims(count, func(pageID types.PageID) bool {
+		return s.pages[pageID].PinCount == 0 && !s.permanent[pageID]
+	})
+	if len(victims) == 0 {
+		victims = s.replacer.victims(1, func(pageID types.PageID) bool {
+			return s.pages[pageID].PinCount == 0
+		})
 	}
-	
-	return fmt.Errorf("all pages are pinned, cannot evict")
-}
-
-// addToLRU adds a page to the LRU list (most recently used).
-func (bp *BufferPool) addToLRU(pageID types.PageID) {
-	e := bp.lruList.PushFront(pageID)
-	bp.lruMap[pageID] = e
+	if len(victims) == 0 {
+		return fmt.Errorf("all pages are pinned, cannot evict")
+	}
+	return s.evict(store, victims)
 }
 
-// touchLRU moves a page to the front (most recently used).
-func (bp *BufferPool) touchLRU(pageID types.PageID) {
-	if e, ok := bp.lruMap[pageID]; ok {
-		bp.lruList.MoveToFront(e)
+// evict writes the dirty pages among victims with a single WritePages call
+// and drops all of them from the shard.
+// Must be called with lock held.
+func (s *bufferShard) evict(store PageStore, victims []types.PageID) error {
+	var dirty []*Page
+	for _, pageID := range victims {
+		if page := s.pages[pageID]; page.IsDirty {
+			dirty = append(dirty, page)
+		}
+	}
+	if len(dirty) > 0 {
+		if err := store.WritePages(dirty); err != nil {
+			return err
+		}
 	}
+	
+	for _, pageID := range victims {
+		delete(s.pages, pageID)
+		s.replacer.remove(pageID)
+	}
+	
+	return nil
 }
 
 // GetPage returns a page without pinning (for read-only access).
 func (bp *BufferPool) GetPage(pageID types.PageID) *Page {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
-	return bp.pages[pageID]
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pages[pageID]
 }
 
 // GetDirtyPages returns all dirty pages for checkpointing.
 func (bp *BufferPool) GetDirtyPages() map[types.PageID]types.LSN {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
-	
 	dirty := make(map[types.PageID]types.LSN)
-	for pageID, page := range bp.pages {
-		if page.IsDirty {
-			dirty[pageID] = page.LSN
+	for _, s := range bp.shards {
+		s.mu.Lock()
+		for pageID, page := range s.pages {
+			if page.IsDirty {
+				dirty[pageID] = page.LSN
+			}
 		}
+		s.mu.Unlock()
 	}
 	return dirty
 }
 
-// Stats returns buffer pool statistics.
+// Stats returns buffer pool statistics, summed over the shards.
 func (bp *BufferPool) Stats() (hits, misses uint64, cached int) {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
-	return bp.hits, bp.misses, len(bp.pages)
+	for _, s := range bp.shards {
+		s.mu.Lock()
+		hits += s.hits
+		misses += s.misses
+		cached += len(s.pages)
+		s.mu.Unlock()
+	}
+	return hits, misses, cached
+}
+
+// Prefetches returns how many pages Prefetch has read from disk. With the
+// misses, it counts every page read into the pool.
+func (bp *BufferPool) Prefetches() uint64 {
+	var n uint64
+	for _, s := range bp.shards {
+		s.mu.Lock()
+		n += s.prefetches
+		s.mu.Unlock()
+	}
+	return n
 }
 
 // MarkDirty marks a page as dirty.
 func (bp *BufferPool) MarkDirty(pageID types.PageID) {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
-	if page, ok := bp.pages[pageID]; ok {
+	if page, ok := s.pages[pageID]; ok {
 		page.IsDirty = true
 	}
 }
 
 // SetPageLSN sets the LSN for a page.
 func (bp *BufferPool) SetPageLSN(pageID types.PageID, lsn types.LSN) {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
-	if page, ok := bp.pages[pageID]; ok {
+	if page, ok := s.pages[pageID]; ok {
 		page.SetLSN(lsn)
 		page.IsDirty = true
 	}
@@ -245,10 +510,11 @@ func (bp *BufferPool) SetPageLSN(pageID types.PageID, lsn types.LSN) {
 
 // GetPageLSN returns the LSN for a page.
 func (bp *BufferPool) GetPageLSN(pageID types.PageID) types.LSN {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
-	if page, ok := bp.pages[pageID]; ok {
+	if page, ok := s.pages[pageID]; ok {
 		return page.GetLSN()
 	}
 	return types.InvalidLSN
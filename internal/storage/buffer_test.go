@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"math/rand"
 	"minidb/pkg/types"
 	"path/filepath"
+	"sync"
 	"testing"
 )
 
@@ -215,6 +217,49 @@ func TestBufferPoolEvictionDirtyPageFlushed(t *testing.T) {
 	}
 }
 
+func TestBufferPoolEvictBatch(t *testing.T) {
+	dir := t.TempDir()
+	dm, _ := NewDiskManager(filepath.Join(dir, "test.db"))
+	bp := NewBufferPool(dm, 4)
+	bp.SetEvictBatch(3)
+
+	var ids []types.PageID
+	for i := 0; i < 4; i++ {
+		p, _ := bp.NewPage(PageTypeData)
+		p.InsertTuple([]byte("dirty data"))
+		ids = append(ids, p.ID)
+		bp.UnpinPage(p.ID, true)
+	}
+	// The least recently used page is pinned and stays
+	bp.FetchPage(ids[0])
+
+	p5, err := bp.NewPage(PageTypeData)
+	if err != nil {
+		t.Fatalf("NewPage(5th) error = %v", err)
+	}
+	bp.UnpinPage(p5.ID, true)
+
+	// One miss evicted every other page, writing the dirty ones
+	if _, _, cached := bp.Stats(); cached != 2 {
+		t.Errorf("cached = %d, want 2", cached)
+	}
+	if bp.GetPage(ids[0]) == nil {
+		t.Error("pinned page was evicted")
+	}
+	for _, id := range ids[1:] {
+		if bp.GetPage(id) != nil {
+			t.Errorf("page %d not evicted", id)
+		}
+		page, err := dm.ReadPage(id)
+		if err != nil {
+			t.Fatalf("ReadPage(%d) error = %v", id, err)
+		}
+		if data, _ := page.GetTuple(0); string(data) != "dirty data" {
+			t.Errorf("evicted page %d data = %q, want %q", id, data, "dirty data")
+		}
+	}
+}
+
 func TestBufferPoolLRUOrder(t *testing.T) {
 	bp := newTestBufferPool(t, 3)
 
@@ -247,6 +292,177 @@ func TestBufferPoolLRUOrder(t *testing.T) {
 	}
 }
 
+func TestBufferPoolClockSecondChance(t *testing.T) {
+	bp := newTestBufferPool(t, 3)
+	if err := bp.SetEvictionPolicy(EvictionClock); err != nil {
+		t.Fatalf("SetEvictionPolicy() error = %v", err)
+	}
+
+	// p1 stays pinned, p2 is referenced again, p3 is not
+	p1, _ := bp.NewPage(PageTypeData)
+	p2, _ := bp.NewPage(PageTypeData)
+	bp.UnpinPage(p2.ID, true)
+	p3, _ := bp.NewPage(PageTypeData)
+	bp.UnpinPage(p3.ID, true)
+	bp.FetchPage(p2.ID)
+	bp.UnpinPage(p2.ID, false)
+
+	p4, err := bp.NewPage(PageTypeData)
+	if err != nil {
+		t.Fatalf("NewPage(4th) error = %v", err)
+	}
+	bp.UnpinPage(p4.ID, true)
+	if bp.GetPage(p3.ID) != nil {
+		t.Error("unreferenced page was not evicted")
+	}
+	if bp.GetPage(p1.ID) == nil {
+		t.Error("pinned page was evicted")
+	}
+	if bp.GetPage(p2.ID) == nil {
+		t.Error("referenced page was evicted instead of getting a second chance")
+	}
+
+	// The sweep cleared p2's bit, so it goes next
+	p5, err := bp.NewPage(PageTypeData)
+	if err != nil {
+		t.Fatalf("NewPage(5th) error = %v", err)
+	}
+	bp.UnpinPage(p5.ID, true)
+	if bp.GetPage(p2.ID) != nil {
+		t.Error("page whose second chance was used up was not evicted")
+	}
+	if bp.GetPage(p4.ID) == nil {
+		t.Error("page after the hand was evicted before p2")
+	}
+}
+
+func TestBufferPoolClockAllPinned(t *testing.T) {
+	bp := newTestBufferPool(t, 2)
+	bp.SetEvictionPolicy(EvictionClock)
+
+	bp.NewPage(PageTypeData)
+	bp.NewPage(PageTypeData)
+	if _, err := bp.NewPage(PageTypeData); err == nil {
+		t.Error("NewPage() with every page pinned should error")
+	}
+}
+
+func TestBufferPoolUnknownEvictionPolicy(t *testing.T) {
+	bp := newTestBufferPool(t, 3)
+	if err := bp.SetEvictionPolicy("random"); err == nil {
+		t.Error("SetEvictionPolicy(random) should error")
+	}
+}
+
+func TestBufferPoolPrefetch(t *testing.T) {
+	dm, err := NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewDiskManager() error = %v", err)
+	}
+	setup := NewBufferPool(dm, 3)
+	var ids []types.PageID
+	for i := 0; i < 3; i++ {
+		p, _ := setup.NewPage(PageTypeData)
+		ids = append(ids, p.ID)
+		setup.UnpinPage(p.ID, true)
+	}
+	setup.FlushAllPages()
+
+	bp := NewBufferPool(dm, 2)
+	p1, _ := bp.FetchPage(ids[0])
+	bp.FetchPage(ids[1])
+
+	// No unpinned page to replace, so the prefetched page is dropped
+	bp.Prefetch([]types.PageID{ids[2]})
+	if _, err := bp.FetchPage(ids[2]); err == nil {
+		t.Fatal("FetchPage() with every page pinned should error")
+	}
+	if bp.GetPage(ids[0]) != p1 || bp.GetPage(ids[1]) == nil {
+		t.Error("Prefetch evicted a pinned page")
+	}
+
+	// Prefetching a page twice reads it once, and the fetch that follows
+	// is a hit
+	bp.UnpinPage(ids[1], false)
+	bp.Prefetch([]types.PageID{ids[2], ids[2], ids[0]})
+	_, misses, _ := bp.Stats()
+	if _, err := bp.FetchPage(ids[2]); err != nil {
+		t.Fatalf("FetchPage() error = %v", err)
+	}
+	if _, misses2, _ := bp.Stats(); misses2 != misses {
+		t.Errorf("misses after fetching a prefetched page = %d, want %d", misses2, misses)
+	}
+	if got := bp.Prefetches(); got != 2 {
+		t.Errorf("Prefetches() = %d, want 2 (one dropped, one cached, cached pages skipped)", got)
+	}
+}
+
+func TestBufferPoolPinPermanent(t *testing.T) {
+	bp := newTestBufferPool(t, 3)
+
+	// p1 is the least recently used page but marked permanent
+	p1, _ := bp.NewPage(PageTypeData)
+	bp.UnpinPage(p1.ID, true)
+	bp.PinPermanent(p1.ID)
+	if p1.PinCount != 0 {
+		t.Errorf("PinCount after PinPermanent = %d, want 0", p1.PinCount)
+	}
+	p2, _ := bp.NewPage(PageTypeData)
+	bp.UnpinPage(p2.ID, true)
+	p3, _ := bp.NewPage(PageTypeData)
+	bp.UnpinPage(p3.ID, true)
+
+	// Other unpinned pages go first
+	p4, err := bp.NewPage(PageTypeData)
+	if err != nil {
+		t.Fatalf("NewPage(4th) error = %v", err)
+	}
+	bp.UnpinPage(p4.ID, true)
+	if bp.GetPage(p1.ID) == nil {
+		t.Error("permanent page evicted while other pages were evictable")
+	}
+	if bp.GetPage(p2.ID) != nil {
+		t.Error("LRU non-permanent page was not evicted")
+	}
+
+	// With every other page pinned, the permanent page is evicted
+	bp.FetchPage(p3.ID)
+	bp.FetchPage(p4.ID)
+	p5, err := bp.NewPage(PageTypeData)
+	if err != nil {
+		t.Fatalf("NewPage(5th) error = %v", err)
+	}
+	if bp.GetPage(p1.ID) != nil {
+		t.Error("permanent page should be evicted when it is the only candidate")
+	}
+	bp.UnpinPage(p3.ID, false)
+	bp.UnpinPage(p4.ID, false)
+	bp.UnpinPage(p5.ID, true)
+
+	// The mark outlives eviction and applies when the page is read back
+	if _, err := bp.FetchPage(p1.ID); err != nil {
+		t.Fatalf("FetchPage() error = %v", err)
+	}
+	bp.UnpinPage(p1.ID, false)
+	for i := 0; i < 3; i++ {
+		p, _ := bp.NewPage(PageTypeData)
+		bp.UnpinPage(p.ID, true)
+	}
+	if bp.GetPage(p1.ID) == nil {
+		t.Error("reloaded permanent page was evicted")
+	}
+
+	// UnpinPermanent returns it to plain LRU order
+	bp.UnpinPermanent(p1.ID)
+	for i := 0; i < 3; i++ {
+		p, _ := bp.NewPage(PageTypeData)
+		bp.UnpinPage(p.ID, true)
+	}
+	if bp.GetPage(p1.ID) != nil {
+		t.Error("page still kept after UnpinPermanent")
+	}
+}
+
 func TestBufferPoolFlushPage(t *testing.T) {
 	bp := newTestBufferPool(t, 10)
 
@@ -328,3 +544,105 @@ func TestBufferPoolMarkDirty(t *testing.T) {
 		t.Error("page should be dirty after MarkDirty")
 	}
 }
+
+func TestBufferPoolShards(t *testing.T) {
+	dm, err := NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewDiskManager() error = %v", err)
+	}
+	bp := NewBufferPoolShards(dm, 10, 4)
+	if len(bp.shards) != 4 {
+		t.Fatalf("shards = %d, want 4", len(bp.shards))
+	}
+	total := 0
+	for _, s := range bp.shards {
+		total += s.capacity
+	}
+	if total != 10 {
+		t.Errorf("capacity over the shards = %d, want 10", total)
+	}
+	if n := len(NewBufferPool(dm, 10).shards); n != 1 {
+		t.Errorf("shards of a small pool = %d, want 1", n)
+	}
+
+	// Dirty pages are found and flushed in every shard
+	for i := 0; i < 8; i++ {
+		p, err := bp.NewPage(PageTypeData)
+		if err != nil {
+			t.Fatalf("NewPage() error = %v", err)
+		}
+		bp.UnpinPage(p.ID, true)
+	}
+	if dirty := bp.GetDirtyPages(); len(dirty) != 8 {
+		t.Errorf("GetDirtyPages() = %d pages, want 8", len(dirty))
+	}
+	if err := bp.FlushAllPages(); err != nil {
+		t.Fatalf("FlushAllPages() error = %v", err)
+	}
+	if dirty := bp.GetDirtyPages(); len(dirty) != 0 {
+		t.Errorf("GetDirtyPages() after FlushAllPages = %d pages, want 0", len(dirty))
+	}
+}
+
+func TestBufferPoolConcurrentFetch(t *testing.T) {
+	dm, err := NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewDiskManager() error = %v", err)
+	}
+	bp := NewBufferPoolShards(dm, 64, 8)
+
+	var pageIDs []types.PageID
+	for i := 0; i < 128; i++ {
+		p, err := bp.NewPage(PageTypeData)
+		if err != nil {
+			t.Fatalf("NewPage() error = %v", err)
+		}
+		pageIDs = append(pageIDs, p.ID)
+		bp.UnpinPage(p.ID, true)
+	}
+	hits, misses, _ := bp.Stats()
+
+	// One page pinned per goroutine, so a shard never runs out of victims
+	const goroutines, fetches = 8, 2000
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < fetches; i++ {
+				pageID := pageIDs[rng.Intn(len(pageIDs))]
+				p, err := bp.FetchPage(pageID)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if p.ID != pageID {
+					t.Errorf("FetchPage(%d) returned page %d", pageID, p.ID)
+				}
+				bp.UnpinPage(pageID, i%2 == 0)
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("FetchPage() error = %v", err)
+	}
+
+	hits2, misses2, cached := bp.Stats()
+	if got := (hits2 - hits) + (misses2 - misses); got != goroutines*fetches {
+		t.Errorf("hits+misses = %d, want %d", got, goroutines*fetches)
+	}
+	if cached > 64 {
+		t.Errorf("cached = %d, want at most the capacity 64", cached)
+	}
+	for _, s := range bp.shards {
+		for _, p := range s.pages {
+			if p.PinCount != 0 {
+				t.Errorf("page %d PinCount = %d after all unpins, want 0", p.ID, p.PinCount)
+			}
+		}
+	}
+}
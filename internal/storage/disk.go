@@ -3,29 +3,67 @@ package storage
 import (
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"minidb/pkg/types"
 	"os"
+	"sort"
 	"sync"
 )
 
+// PageStore is the page-granular file a BufferPool caches. DiskManager is
+// the file-backed implementation.
+type PageStore interface {
+	ReadPage(pageID types.PageID) (*Page, error)
+	WritePage(page *Page) error
+	WritePages(pages []*Page) error
+	AllocatePage() (types.PageID, error)
+	FreePage(pageID types.PageID) error
+	Sync() error
+	GetNumPages() uint32
+	Close() error
+}
+
+var _ PageStore = (*DiskManager)(nil)
+
 // DiskManager handles reading and writing pages to disk.
 type DiskManager struct {
 	mu       sync.Mutex
 	file     *os.File
 	filePath string
 	numPages uint32
+	freeHead types.PageID // First page of the free list, InvalidPageID if empty
+
+	checksums bool // Store a checksum in each page written
+
+	pagesWritten uint64 // Pages written since the file was opened
+
+	// Set by NewReadOnlyDiskManager: pages written and allocated are kept
+	// here instead of in the file
+	overlay map[types.PageID][]byte
 }
 
 const (
-	diskHeaderSize = 16 // Magic(8) + Version(4) + NumPages(4)
+	diskHeaderSize = 20 // Magic(8) + Version(4) + NumPages(4) + FreeListHead(4)
 	diskMagic      = uint64(0x4D494E4944425044) // "MINIDBPD"
-	diskVersion    = uint32(1)
+	diskVersion    = uint32(5) // 5: index entries on non-unique columns end with the RID
+	
+	// diskVersion covers the layout of every page in the file: the page
+	// header, tuples and B-Tree nodes alike. Each change to one of them
+	// bumps it, and a file of any other version is refused rather than
+	// misread. Files of version 1 may hold B-Tree nodes with either a 4-byte
+	// header or the 12-byte one with the previous-leaf pointer.
+
+	// Page header fields owned by the disk manager
+	pageFlagsOffset    = 6
+	pageChecksumOffset = 28
+	pageFlagChecksum   = 0x01 // The page carries a checksum
 )
 
 // NewDiskManager creates or opens a database file.
 func NewDiskManager(path string) (*DiskManager, error) {
 	dm := &DiskManager{
 		filePath: path,
+		freeHead: types.InvalidPageID,
 	}
 
 	// Check if file exists
@@ -61,11 +99,35 @@ func NewDiskManager(path string) (*DiskManager, error) {
 	return dm, nil
 }
 
+// NewReadOnlyDiskManager opens an existing database file without write
+// access. Pages written through it, such as those recovery rebuilds, are
+// kept in memory and read back from there, and new pages extend the file
+// only in memory; nothing reaches the file. FreePage fails with
+// ErrReadOnly.
+func NewReadOnlyDiskManager(path string) (*DiskManager, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data file: %w", err)
+	}
+	dm := &DiskManager{
+		file:     file,
+		filePath: path,
+		freeHead: types.InvalidPageID,
+		overlay:  make(map[types.PageID][]byte),
+	}
+	if err := dm.readHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return dm, nil
+}
+
 func (dm *DiskManager) writeHeader() error {
 	header := make([]byte, diskHeaderSize)
 	binary.LittleEndian.PutUint64(header[0:8], diskMagic)
 	binary.LittleEndian.PutUint32(header[8:12], diskVersion)
 	binary.LittleEndian.PutUint32(header[12:16], dm.numPages)
+	binary.LittleEndian.PutUint32(header[16:20], uint32(dm.freeHead))
 
 	_, err := dm.file.WriteAt(header, 0)
 	if err != nil {
@@ -88,11 +150,20 @@ func (dm *DiskManager) readHeader() error {
 	}
 
 	version := binary.LittleEndian.Uint32(header[8:12])
+	if version > diskVersion {
+		return fmt.Errorf("unsupported data file version %d in %s (this build reads version %d): "+
+			"it was written by a newer release", version, dm.filePath, diskVersion)
+	}
 	if version != diskVersion {
-		return fmt.Errorf("unsupported data file version: %d", version)
+		// Pages are not converted in place; the tuples and index nodes of an
+		// older file would be decoded with the wrong layout
+		return fmt.Errorf("unsupported data file version %d in %s (this build reads version %d): "+
+			"the page layout has changed; export the tables with the release that wrote the file and load them into a new database",
+			version, dm.filePath, diskVersion)
 	}
 
 	dm.numPages = binary.LittleEndian.Uint32(header[12:16])
+	dm.freeHead = types.PageID(binary.LittleEndian.Uint32(header[16:20]))
 	return nil
 }
 
@@ -103,6 +174,13 @@ func (dm *DiskManager) updateNumPages() error {
 	return err
 }
 
+func (dm *DiskManager) updateFreeHead() error {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(dm.freeHead))
+	_, err := dm.file.WriteAt(buf, 16)
+	return err
+}
+
 // pageOffset returns the file offset for a page.
 func (dm *DiskManager) pageOffset(pageID types.PageID) int64 {
 	return int64(diskHeaderSize) + int64(pageID)*int64(PageSize)
@@ -112,17 +190,27 @@ func (dm *DiskManager) pageOffset(pageID types.PageID) int64 {
 func (dm *DiskManager) ReadPage(pageID types.PageID) (*Page, error) {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
+	return dm.readPage(pageID)
+}
 
+// readPage reads a page. Must be called with lock held.
+func (dm *DiskManager) readPage(pageID types.PageID) (*Page, error) {
 	if uint32(pageID) >= dm.numPages {
 		return nil, fmt.Errorf("page %d does not exist", pageID)
 	}
 
-	data := make([]byte, PageSize)
-	offset := dm.pageOffset(pageID)
+	data, ok := dm.overlay[pageID]
+	if !ok {
+		data = make([]byte, PageSize)
+		n, err := dm.file.ReadAt(data, dm.pageOffset(pageID))
+		if err != nil || n != PageSize {
+			return nil, fmt.Errorf("failed to read page %d: %w", pageID, err)
+		}
+	}
 
-	n, err := dm.file.ReadAt(data, offset)
-	if err != nil || n != PageSize {
-		return nil, fmt.Errorf("failed to read page %d: %w", pageID, err)
+	if data[pageFlagsOffset]&pageFlagChecksum != 0 &&
+		binary.LittleEndian.Uint32(data[pageChecksumOffset:]) != pageChecksum(data) {
+		return nil, fmt.Errorf("read page %d: %w", pageID, ErrPageCorrupt)
 	}
 
 	page := &Page{}
@@ -130,27 +218,111 @@ func (dm *DiskManager) ReadPage(pageID types.PageID) (*Page, error) {
 	return page, nil
 }
 
+// SetChecksums turns page checksums on or off for later writes. With them
+// on, each page written carries a CRC32 of its contents that ReadPage
+// verifies, failing with ErrPageCorrupt on a mismatch. Pages written with
+// them off carry none and are read unchecked, whatever the setting, so a
+// database can turn them on and pick them up page by page.
+func (dm *DiskManager) SetChecksums(on bool) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.checksums = on
+}
+
+// encodePage serializes a page for writing, stamping its checksum flag and
+// field. Must be called with lock held.
+func (dm *DiskManager) encodePage(page *Page) []byte {
+	data := page.Serialize()
+	if !dm.checksums {
+		data[pageFlagsOffset] &^= pageFlagChecksum
+		binary.LittleEndian.PutUint32(data[pageChecksumOffset:], 0)
+		return data
+	}
+	data[pageFlagsOffset] |= pageFlagChecksum
+	binary.LittleEndian.PutUint32(data[pageChecksumOffset:], pageChecksum(data))
+	return data
+}
+
+// pageChecksum returns the CRC32 of a serialized page, leaving out the
+// checksum field itself.
+func pageChecksum(data []byte) uint32 {
+	crc := crc32.ChecksumIEEE(data[:pageChecksumOffset])
+	return crc32.Update(crc, crc32.IEEETable, data[pageChecksumOffset+4:])
+}
+
 // WritePage writes a page to disk.
 func (dm *DiskManager) WritePage(page *Page) error {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 
 	offset := dm.pageOffset(page.ID)
-	data := page.Serialize()
+	data := dm.encodePage(page)
+	if dm.overlay != nil {
+		dm.overlay[page.ID] = data
+		return nil
+	}
 
 	n, err := dm.file.WriteAt(data, offset)
 	if err != nil || n != PageSize {
 		return fmt.Errorf("failed to write page %d: %w", page.ID, err)
 	}
+	dm.pagesWritten++
+
+	return nil
+}
+
+// WritePages writes several pages to disk, sorted by ID, with one write per
+// run of consecutive pages.
+func (dm *DiskManager) WritePages(pages []*Page) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if dm.overlay != nil {
+		for _, page := range pages {
+			dm.overlay[page.ID] = dm.encodePage(page)
+		}
+		return nil
+	}
+
+	sorted := append([]*Page(nil), pages...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	for start := 0; start < len(sorted); {
+		end := start + 1
+		for end < len(sorted) && sorted[end].ID == sorted[end-1].ID+1 {
+			end++
+		}
+		data := make([]byte, 0, (end-start)*PageSize)
+		for _, page := range sorted[start:end] {
+			data = append(data, dm.encodePage(page)...)
+		}
+		n, err := dm.file.WriteAt(data, dm.pageOffset(sorted[start].ID))
+		if err != nil || n != len(data) {
+			return fmt.Errorf("failed to write pages %d-%d: %w", sorted[start].ID, sorted[end-1].ID, err)
+		}
+		dm.pagesWritten += uint64(end - start)
+		start = end
+	}
 
 	return nil
 }
 
-// AllocatePage allocates a new page and returns its ID.
+// AllocatePage allocates a new page and returns its ID. Pages on the free
+// list are reused before the file is extended.
 func (dm *DiskManager) AllocatePage() (types.PageID, error) {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 
+	if dm.overlay != nil {
+		pageID := types.PageID(dm.numPages)
+		dm.numPages++
+		dm.overlay[pageID] = dm.encodePage(NewPage(pageID, PageTypeData))
+		return pageID, nil
+	}
+	if dm.freeHead != types.InvalidPageID {
+		return dm.reuseFreePage()
+	}
+
 	pageID := types.PageID(dm.numPages)
 	dm.numPages++
 
@@ -164,7 +336,7 @@ func (dm *DiskManager) AllocatePage() (types.PageID, error) {
 	page := NewPage(pageID, PageTypeData)
 	offset := dm.pageOffset(pageID)
 
-	_, err := dm.file.WriteAt(page.Serialize(), offset)
+	_, err := dm.file.WriteAt(dm.encodePage(page), offset)
 	if err != nil {
 		dm.numPages--
 		dm.updateNumPages()
@@ -174,10 +346,93 @@ func (dm *DiskManager) AllocatePage() (types.PageID, error) {
 	return pageID, nil
 }
 
+// reuseFreePage pops the head of the free list and reinitializes it as an
+// empty page. Must be called with lock held.
+func (dm *DiskManager) reuseFreePage() (types.PageID, error) {
+	free, err := dm.readPage(dm.freeHead)
+	if err != nil {
+		return 0, fmt.Errorf("read free page: %w", err)
+	}
+
+	page := NewPage(free.ID, PageTypeData)
+	if _, err := dm.file.WriteAt(dm.encodePage(page), dm.pageOffset(page.ID)); err != nil {
+		return 0, err
+	}
+
+	dm.freeHead = free.NextPageID
+	if err := dm.updateFreeHead(); err != nil {
+		dm.freeHead = free.ID
+		return 0, err
+	}
+	return page.ID, nil
+}
+
+// FreePage puts a page on the free list so that a later AllocatePage
+// reuses it. The caller must make sure nothing references the page.
+func (dm *DiskManager) FreePage(pageID types.PageID) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if dm.overlay != nil {
+		return ErrReadOnly
+	}
+	if uint32(pageID) >= dm.numPages {
+		return fmt.Errorf("page %d does not exist", pageID)
+	}
+	if old, err := dm.readPage(pageID); err == nil && old.Type == PageTypeFree {
+		return fmt.Errorf("page %d is already free", pageID)
+	}
+
+	page := NewPage(pageID, PageTypeFree)
+	page.SetNextPageID(dm.freeHead)
+	if _, err := dm.file.WriteAt(dm.encodePage(page), dm.pageOffset(pageID)); err != nil {
+		return fmt.Errorf("failed to write free page %d: %w", pageID, err)
+	}
+
+	prev := dm.freeHead
+	dm.freeHead = pageID
+	if err := dm.updateFreeHead(); err != nil {
+		dm.freeHead = prev
+		return err
+	}
+	return nil
+}
+
+// FreePages returns the pages on the free list, most recently freed first.
+func (dm *DiskManager) FreePages() ([]types.PageID, error) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	var pages []types.PageID
+	for pageID := dm.freeHead; pageID != types.InvalidPageID; {
+		if len(pages) >= int(dm.numPages) {
+			return nil, fmt.Errorf("free list has a cycle")
+		}
+		page, err := dm.readPage(pageID)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, pageID)
+		pageID = page.NextPageID
+	}
+	return pages, nil
+}
+
+// PagesWritten returns how many pages have been written since the file was
+// opened.
+func (dm *DiskManager) PagesWritten() uint64 {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return dm.pagesWritten
+}
+
 // Sync flushes all pending writes to disk.
 func (dm *DiskManager) Sync() error {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
+	if dm.overlay != nil {
+		return nil
+	}
 	return dm.file.Sync()
 }
 
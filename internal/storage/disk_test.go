@@ -1,9 +1,14 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"minidb/pkg/types"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -49,6 +54,35 @@ func TestDiskManagerInvalidMagic(t *testing.T) {
 	}
 }
 
+func TestDiskManagerOtherVersion(t *testing.T) {
+	for _, tt := range []struct {
+		version uint32
+		want    string
+	}{
+		{1, "export the tables with the release that wrote the file"},
+		{diskVersion - 1, "the page layout has changed"},
+		{diskVersion + 1, "written by a newer release"},
+	} {
+		path := filepath.Join(t.TempDir(), "old.db")
+		header := make([]byte, diskHeaderSize)
+		binary.LittleEndian.PutUint64(header[0:8], diskMagic)
+		binary.LittleEndian.PutUint32(header[8:12], tt.version)
+		os.WriteFile(path, header, 0644)
+
+		_, err := NewDiskManager(path)
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("unsupported data file version %d", tt.version)) ||
+			!strings.Contains(err.Error(), tt.want) {
+			t.Errorf("NewDiskManager() on version %d: error = %v, want one saying %q", tt.version, err, tt.want)
+		}
+		if _, err := NewReadOnlyDiskManager(path); err == nil {
+			t.Errorf("NewReadOnlyDiskManager() on version %d should error", tt.version)
+		}
+		if data, _ := os.ReadFile(path); !bytes.Equal(data, header) {
+			t.Errorf("file of version %d was modified", tt.version)
+		}
+	}
+}
+
 func TestAllocatePage(t *testing.T) {
 	dm, _ := newTestDiskManager(t)
 	defer dm.Close()
@@ -95,6 +129,37 @@ func TestWriteReadPageRoundTrip(t *testing.T) {
 	}
 }
 
+func TestWritePages(t *testing.T) {
+	dm, _ := newTestDiskManager(t)
+	defer dm.Close()
+
+	// Two runs of consecutive pages, given out of order
+	var pages []*Page
+	for i := 0; i < 5; i++ {
+		id, _ := dm.AllocatePage()
+		if i == 2 {
+			continue
+		}
+		page := NewPage(id, PageTypeData)
+		page.InsertTuple([]byte(fmt.Sprintf("page %d", id)))
+		pages = append([]*Page{page}, pages...)
+	}
+
+	if err := dm.WritePages(pages); err != nil {
+		t.Fatalf("WritePages() error = %v", err)
+	}
+	for _, want := range pages {
+		got, err := dm.ReadPage(want.ID)
+		if err != nil {
+			t.Fatalf("ReadPage(%d) error = %v", want.ID, err)
+		}
+		data, _ := got.GetTuple(0)
+		if string(data) != fmt.Sprintf("page %d", want.ID) {
+			t.Errorf("page %d tuple = %q", want.ID, data)
+		}
+	}
+}
+
 func TestReadPageOutOfRange(t *testing.T) {
 	dm, _ := newTestDiskManager(t)
 	defer dm.Close()
@@ -150,3 +215,188 @@ func TestCloseReopenPersistence(t *testing.T) {
 		t.Errorf("data = %q, want %q", data, "persistent")
 	}
 }
+
+func TestFreeListHoldsEveryFreedPage(t *testing.T) {
+	dm, _ := newTestDiskManager(t)
+	defer dm.Close()
+
+	for i := 0; i < 4; i++ {
+		dm.AllocatePage()
+	}
+	for _, id := range []types.PageID{1, 3, 2} {
+		if err := dm.FreePage(id); err != nil {
+			t.Fatalf("FreePage(%d) error = %v", id, err)
+		}
+	}
+	free, err := dm.FreePages()
+	if err != nil {
+		t.Fatalf("FreePages() error = %v", err)
+	}
+	if fmt.Sprint(free) != "[2 3 1]" {
+		t.Errorf("FreePages() = %v, want [2 3 1]", free)
+	}
+
+	for _, want := range []types.PageID{2, 3, 1} {
+		if id, _ := dm.AllocatePage(); id != want {
+			t.Errorf("AllocatePage() = %d, want reused page %d", id, want)
+		}
+	}
+	if dm.GetNumPages() != 4 {
+		t.Errorf("NumPages = %d, want 4", dm.GetNumPages())
+	}
+}
+
+func TestPageChecksums(t *testing.T) {
+	dm, path := newTestDiskManager(t)
+	dm.SetChecksums(true)
+
+	checked, _ := dm.AllocatePage()
+	unchecked, _ := dm.AllocatePage()
+	page := NewPage(checked, PageTypeData)
+	page.InsertTuple([]byte("checked"))
+	if err := dm.WritePage(page); err != nil {
+		t.Fatalf("WritePage() error = %v", err)
+	}
+	if _, err := dm.ReadPage(checked); err != nil {
+		t.Fatalf("ReadPage() of an intact page error = %v", err)
+	}
+	dm.SetChecksums(false)
+	page = NewPage(unchecked, PageTypeData)
+	page.InsertTuple([]byte("unchecked"))
+	dm.WritePage(page)
+	dm.Close()
+
+	// Flip a byte in the tuple data of each page
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	for _, id := range []types.PageID{checked, unchecked} {
+		b := make([]byte, 1)
+		off := int64(diskHeaderSize) + int64(id)*PageSize + PageSize - 2
+		f.ReadAt(b, off)
+		b[0] ^= 0x40
+		f.WriteAt(b, off)
+	}
+	f.Close()
+
+	// A page with a checksum is verified even with checksums off; one
+	// without is read as is even with them on
+	dm, err = NewDiskManager(path)
+	if err != nil {
+		t.Fatalf("Reopen NewDiskManager() error = %v", err)
+	}
+	defer dm.Close()
+	dm.SetChecksums(true)
+	if _, err := dm.ReadPage(checked); !errors.Is(err, ErrPageCorrupt) {
+		t.Errorf("ReadPage() of a corrupted page error = %v, want ErrPageCorrupt", err)
+	}
+	if _, err := dm.ReadPage(unchecked); err != nil {
+		t.Errorf("ReadPage() of a page without a checksum error = %v", err)
+	}
+	dm.SetChecksums(false)
+	if _, err := dm.ReadPage(checked); !errors.Is(err, ErrPageCorrupt) {
+		t.Errorf("ReadPage() with checksums off error = %v, want ErrPageCorrupt", err)
+	}
+}
+
+func TestFreePageReusedAcrossReopen(t *testing.T) {
+	dm, path := newTestDiskManager(t)
+
+	var ids []types.PageID
+	for i := 0; i < 3; i++ {
+		id, err := dm.AllocatePage()
+		if err != nil {
+			t.Fatalf("AllocatePage() error = %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := dm.FreePage(ids[1]); err != nil {
+		t.Fatalf("FreePage() error = %v", err)
+	}
+	if err := dm.FreePage(ids[1]); err == nil {
+		t.Error("FreePage() twice should fail")
+	}
+	dm.Close()
+
+	dm2, err := NewDiskManager(path)
+	if err != nil {
+		t.Fatalf("Reopen NewDiskManager() error = %v", err)
+	}
+	defer dm2.Close()
+
+	free, err := dm2.FreePages()
+	if err != nil {
+		t.Fatalf("FreePages() error = %v", err)
+	}
+	if len(free) != 1 || free[0] != ids[1] {
+		t.Fatalf("FreePages() = %v, want [%d]", free, ids[1])
+	}
+
+	id, err := dm2.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage() error = %v", err)
+	}
+	if id != ids[1] {
+		t.Errorf("AllocatePage() = %d, want reused page %d", id, ids[1])
+	}
+	if dm2.GetNumPages() != 3 {
+		t.Errorf("NumPages = %d, want 3", dm2.GetNumPages())
+	}
+	page, err := dm2.ReadPage(id)
+	if err != nil {
+		t.Fatalf("ReadPage() error = %v", err)
+	}
+	if page.Type != PageTypeData || page.GetSlotCount() != 0 {
+		t.Errorf("reused page type = %d, slots = %d, want empty data page", page.Type, page.GetSlotCount())
+	}
+}
+
+func TestReadOnlyDiskManager(t *testing.T) {
+	dm, path := newTestDiskManager(t)
+	id, _ := dm.AllocatePage()
+	page := NewPage(id, PageTypeData)
+	page.InsertTuple([]byte("on disk"))
+	dm.WritePage(page)
+	dm.Close()
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := NewReadOnlyDiskManager(path)
+	if err != nil {
+		t.Fatalf("NewReadOnlyDiskManager() error = %v", err)
+	}
+	changed := NewPage(id, PageTypeData)
+	changed.InsertTuple([]byte("in memory"))
+	changed.InsertTuple([]byte("only"))
+	if err := ro.WritePage(changed); err != nil {
+		t.Fatalf("WritePage() error = %v", err)
+	}
+	added, err := ro.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage() error = %v", err)
+	}
+	if got, err := ro.ReadPage(id); err != nil || got.GetSlotCount() != 2 {
+		t.Errorf("ReadPage() after a write = %v slots, %v; want the written page", got.GetSlotCount(), err)
+	}
+	if _, err := ro.ReadPage(added); err != nil {
+		t.Errorf("ReadPage(%d) of an allocated page error = %v", added, err)
+	}
+	if err := ro.FreePage(id); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("FreePage() error = %v, want ErrReadOnly", err)
+	}
+	if err := ro.Sync(); err != nil {
+		t.Errorf("Sync() error = %v", err)
+	}
+	ro.Close()
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("read-only disk manager changed the file")
+	}
+}
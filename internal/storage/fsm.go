@@ -0,0 +1,46 @@
+package storage
+
+import "minidb/pkg/types"
+
+// FreeSpaceMap tracks the free-space class of each page in a table heap so
+// that inserts can find a page with room without fetching every page.
+// It lives in memory and is rebuilt from the page headers when the heap is
+// first written after opening.
+type FreeSpaceMap struct {
+	pages   []types.PageID // Heap pages in chain order
+	classes map[types.PageID]uint8
+}
+
+// NewFreeSpaceMap creates an empty free-space map.
+func NewFreeSpaceMap() *FreeSpaceMap {
+	return &FreeSpaceMap{classes: make(map[types.PageID]uint8)}
+}
+
+// Update records the free-space class of a page, adding the page if new.
+func (m *FreeSpaceMap) Update(pageID types.PageID, class uint8) {
+	if _, ok := m.classes[pageID]; !ok {
+		m.pages = append(m.pages, pageID)
+	}
+	m.classes[pageID] = class
+}
+
+// FindPage returns the first page whose free-space class guarantees room
+// for a tuple of the given size.
+func (m *FreeSpaceMap) FindPage(size int) (types.PageID, bool) {
+	need := (size + FreeSpaceClassSize - 1) / FreeSpaceClassSize
+	if need >= FreeSpaceClasses {
+		return types.InvalidPageID, false
+	}
+	for _, pageID := range m.pages {
+		if int(m.classes[pageID]) >= need {
+			return pageID, true
+		}
+	}
+	return types.InvalidPageID, false
+}
+
+// Class returns the recorded free-space class of a page.
+func (m *FreeSpaceMap) Class(pageID types.PageID) (uint8, bool) {
+	class, ok := m.classes[pageID]
+	return class, ok
+}
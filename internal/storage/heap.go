@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"minidb/pkg/types"
+	"sort"
+	"sync"
 )
 
 // TableHeap manages storage for a single table as a collection of pages.
@@ -12,6 +14,9 @@ type TableHeap struct {
 	tableID    uint32
 	firstPage  types.PageID
 	lastPage   types.PageID
+	fsm        *FreeSpaceMap  // Built lazily on first insert
+	nextRowID  uint64         // Next logical row ID; 0 until recovered
+	unlinked   []types.PageID // Overflow pages of tuples Delete removed, not yet freed
 }
 
 // TableHeapMeta contains metadata for a table heap.
@@ -53,9 +58,38 @@ func LoadTableHeap(bufferPool *BufferPool, tableID uint32, firstPage, lastPage t
 }
 
 // Insert inserts a tuple into the table.
-// Returns the RID (page ID and slot number).
+// Returns the RID (page ID and slot number). The free-space map picks the
+// first page with room, falling back to the last page and then a new page.
+// A tuple larger than MaxTupleSize has its data moved to overflow pages and
+// tuple.Overflow set, so that its Serialize gives what the page holds.
 func (th *TableHeap) Insert(tuple *types.Tuple) (types.PageID, uint16, error) {
-	data := tuple.Serialize()
+	data, err := th.encode(tuple)
+	if err != nil {
+		return 0, 0, err
+	}
+	
+	if th.fsm == nil {
+		if err := th.rebuildFreeSpaceMap(); err != nil {
+			return 0, 0, err
+		}
+	}
+	
+	// Try a page the free-space map says has room
+	if pageID, ok := th.fsm.FindPage(len(data)); ok && pageID != th.lastPage {
+		page, err := th.bufferPool.FetchPage(pageID)
+		if err != nil {
+			return 0, 0, err
+		}
+		slotNum, err := page.InsertTuple(data)
+		th.fsm.Update(pageID, page.GetFreeSpaceClass())
+		if err == nil {
+			checkTupleRoundTrip(page, slotNum, tuple)
+		}
+		th.bufferPool.UnpinPage(pageID, err == nil)
+		if err == nil {
+			return pageID, slotNum, nil
+		}
+	}
 	
 	// Try to insert into last page
 	page, err := th.bufferPool.FetchPage(th.lastPage)
@@ -64,7 +98,9 @@ func (th *TableHeap) Insert(tuple *types.Tuple) (types.PageID, uint16, error) {
 	}
 	
 	slotNum, err := page.InsertTuple(data)
+	th.fsm.Update(page.ID, page.GetFreeSpaceClass())
 	if err == nil {
+		checkTupleRoundTrip(page, slotNum, tuple)
 		th.bufferPool.UnpinPage(page.ID, true)
 		return page.ID, slotNum, nil
 	}
@@ -83,29 +119,101 @@ func (th *TableHeap) Insert(tuple *types.Tuple) (types.PageID, uint16, error) {
 	th.lastPage = newPage.ID
 	
 	slotNum, err = newPage.InsertTuple(data)
+	th.fsm.Update(newPage.ID, newPage.GetFreeSpaceClass())
 	if err != nil {
 		th.bufferPool.UnpinPage(newPage.ID, true)
 		return 0, 0, err
 	}
+	checkTupleRoundTrip(newPage, slotNum, tuple)
 	
 	th.bufferPool.UnpinPage(newPage.ID, true)
 	return newPage.ID, slotNum, nil
 }
 
+// NextRowID allocates a logical row ID. A row gets its ID at first insert
+// and every later version carries it, independent of where the version is
+// stored. The counter is recovered from the largest RowID in the heap the
+// first time it is needed after opening.
+func (th *TableHeap) NextRowID() (uint64, error) {
+	if th.nextRowID == 0 {
+		tuples, err := th.Scan()
+		if err != nil {
+			return 0, err
+		}
+		th.nextRowID = 1
+		for _, t := range tuples {
+			if t.Tuple.RowID >= th.nextRowID {
+				th.nextRowID = t.Tuple.RowID + 1
+			}
+		}
+	}
+	
+	rowID := th.nextRowID
+	th.nextRowID++
+	return rowID, nil
+}
+
+// rebuildFreeSpaceMap reads every page header in the heap to rebuild the
+// free-space map.
+func (th *TableHeap) rebuildFreeSpaceMap() error {
+	stats, err := th.PageStats()
+	if err != nil {
+		return err
+	}
+	fsm := NewFreeSpaceMap()
+	for _, s := range stats {
+		fsm.Update(s.PageID, s.FreeSpaceClass)
+	}
+	th.fsm = fsm
+	return nil
+}
+
+// noteFreeSpace records a page's current free-space class after a mutation.
+func (th *TableHeap) noteFreeSpace(page *Page) {
+	if th.fsm != nil {
+		th.fsm.Update(page.ID, page.GetFreeSpaceClass())
+	}
+}
+
 // Get retrieves a tuple by RID.
 func (th *TableHeap) Get(pageID types.PageID, slotNum uint16) (*types.Tuple, error) {
 	page, err := th.bufferPool.FetchPage(pageID)
 	if err != nil {
 		return nil, err
 	}
-	defer th.bufferPool.UnpinPage(pageID, false)
-	
 	data, err := page.GetTuple(slotNum)
+	th.bufferPool.UnpinPage(pageID, false)
 	if err != nil {
 		return nil, err
 	}
 	
-	return types.DeserializeTuple(data)
+	return th.decode(data)
+}
+
+// FindVersion walks a row's version chain backwards from the given RID and
+// returns the first version accepted by visible, or nil if none is. The walk
+// stops early when the chain leads to a version that has been vacuumed away.
+func (th *TableHeap) FindVersion(pageID types.PageID, slotNum uint16, visible func(*types.Tuple) bool) (*TupleWithRID, error) {
+	tuple, err := th.Get(pageID, slotNum)
+	if err != nil {
+		return nil, err
+	}
+	rowID := tuple.RowID
+
+	for {
+		if visible(tuple) {
+			return &TupleWithRID{Tuple: tuple, PageID: pageID, SlotNum: slotNum}, nil
+		}
+		if !tuple.HasPrevVersion() {
+			return nil, nil
+		}
+		pageID, slotNum = tuple.PrevPageID, tuple.PrevSlotNum
+		tuple, err = th.Get(pageID, slotNum)
+		if err != nil || tuple.RowID != rowID {
+			// Older versions were reclaimed by VACUUM
+			return nil, nil
+		}
+	}
 }
 
 // Update updates a tuple at the given RID.
@@ -116,11 +224,20 @@ func (th *TableHeap) Update(pageID types.PageID, slotNum uint16, tuple *types.Tu
 	}
 	defer th.bufferPool.UnpinPage(pageID, true)
 	
-	data := tuple.Serialize()
-	return page.UpdateTuple(slotNum, data)
+	data, err := th.encode(tuple)
+	if err != nil {
+		return err
+	}
+	err = page.UpdateTuple(slotNum, data)
+	th.noteFreeSpace(page)
+	if err == nil {
+		checkTupleRoundTrip(page, slotNum, tuple)
+	}
+	return err
 }
 
-// Delete marks a tuple as deleted.
+// Delete marks a tuple as deleted. The overflow pages of the tuple, if
+// any, are left for TakeUnlinked.
 func (th *TableHeap) Delete(pageID types.PageID, slotNum uint16) error {
 	page, err := th.bufferPool.FetchPage(pageID)
 	if err != nil {
@@ -128,13 +245,106 @@ func (th *TableHeap) Delete(pageID types.PageID, slotNum uint16) error {
 	}
 	defer th.bufferPool.UnpinPage(pageID, true)
 	
-	return page.DeleteTuple(slotNum)
+	var chain []types.PageID
+	if data, err := page.GetTuple(slotNum); err == nil {
+		if tuple, err := types.DeserializeTuple(data); err == nil && tuple.Overflow != nil {
+			if chain, err = overflowChain(th.bufferPool, tuple.Overflow.FirstPage); err != nil {
+				return fmt.Errorf("read overflow chain of (page=%d, slot=%d): %w", pageID, slotNum, err)
+			}
+		}
+	}
+	err = page.DeleteTuple(slotNum)
+	th.noteFreeSpace(page)
+	if err == nil {
+		th.unlinked = append(th.unlinked, chain...)
+	}
+	return err
+}
+
+// TakeUnlinked returns the overflow pages of the tuples Delete has removed
+// since the last call, and forgets them. The owner may free them once the
+// heap pages are on disk, so that no stored tuple names them any more.
+func (th *TableHeap) TakeUnlinked() []types.PageID {
+	pages := th.unlinked
+	th.unlinked = nil
+	return pages
+}
+
+// OverflowPages returns the overflow pages of every tuple in the heap.
+func (th *TableHeap) OverflowPages() ([]types.PageID, error) {
+	var pages []types.PageID
+	for pageID := th.firstPage; pageID != types.InvalidPageID; {
+		page, err := th.bufferPool.FetchPage(pageID)
+		if err != nil {
+			return nil, err
+		}
+		var refs []*types.OverflowRef
+		for _, t := range page.GetAllTuples() {
+			if tuple, err := types.DeserializeTuple(t.Data); err == nil && tuple.Overflow != nil {
+				refs = append(refs, tuple.Overflow)
+			}
+		}
+		nextPageID := page.GetNextPageID()
+		th.bufferPool.UnpinPage(pageID, false)
+
+		for _, ref := range refs {
+			chain, err := overflowChain(th.bufferPool, ref.FirstPage)
+			if err != nil {
+				return nil, fmt.Errorf("overflow chain at page %d: %w", ref.FirstPage, err)
+			}
+			pages = append(pages, chain...)
+		}
+		pageID = nextPageID
+	}
+	return pages, nil
+}
+
+// encode serializes a tuple for a heap page, first moving data too large
+// for a page to a new overflow chain.
+func (th *TableHeap) encode(tuple *types.Tuple) ([]byte, error) {
+	data := tuple.Serialize()
+	if len(data) <= MaxTupleSize {
+		return data, nil
+	}
+	ref, err := writeOverflow(th.bufferPool, tuple.Data)
+	if err != nil {
+		return nil, err
+	}
+	tuple.Overflow = ref
+	return tuple.Serialize(), nil
+}
+
+// decode deserializes a tuple read from a heap page, reading its data back
+// from its overflow chain if it has one. The returned tuple keeps its
+// Overflow reference, so that writing it back does not copy the data.
+func (th *TableHeap) decode(data []byte) (*types.Tuple, error) {
+	tuple, err := types.DeserializeTuple(data)
+	if err != nil || tuple.Overflow == nil {
+		return tuple, err
+	}
+	if tuple.Data, err = readOverflow(th.bufferPool, tuple.Overflow); err != nil {
+		return nil, err
+	}
+	return tuple, nil
 }
 
 // Scan iterates over all tuples in the table.
 func (th *TableHeap) Scan() ([]*TupleWithRID, error) {
 	var results []*TupleWithRID
-	
+	err := th.ForEach(func(t *TupleWithRID) error {
+		results = append(results, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ForEach calls fn for every tuple in the table, one page at a time, and
+// stops at the first error fn returns. The page is unpinned before fn runs
+// on its tuples, and the next page in the chain is prefetched meanwhile.
+func (th *TableHeap) ForEach(fn func(*TupleWithRID) error) error {
 	currentPageID := th.firstPage
 	
 	for currentPageID != types.InvalidPageID {
@@ -144,16 +354,16 @@ func (th *TableHeap) Scan() ([]*TupleWithRID, error) {
 				// Page doesn't exist yet, stop scanning
 				break
 			}
-			return nil, err
+			return err
 		}
 		
-		tuples := page.GetAllTuples()
-		for _, t := range tuples {
-			tuple, err := types.DeserializeTuple(t.Data)
+		var tuples []*TupleWithRID
+		for _, t := range page.GetAllTuples() {
+			tuple, err := th.decode(t.Data)
 			if err != nil {
 				continue
 			}
-			results = append(results, &TupleWithRID{
+			tuples = append(tuples, &TupleWithRID{
 				Tuple:   tuple,
 				PageID:  currentPageID,
 				SlotNum: t.SlotNum,
@@ -161,13 +371,159 @@ func (th *TableHeap) Scan() ([]*TupleWithRID, error) {
 		}
 		
 		nextPageID := page.GetNextPageID()
+		if nextPageID != types.InvalidPageID {
+			th.bufferPool.Prefetch([]types.PageID{nextPageID})
+		}
 		th.bufferPool.UnpinPage(currentPageID, false)
+		
+		for _, t := range tuples {
+			if err := fn(t); err != nil {
+				return err
+			}
+		}
 
 		// Move to next page via linked list
 		currentPageID = nextPageID
 	}
 	
-	return results, nil
+	return nil
+}
+
+// HeapIterator walks the tuples of a table heap one at a time, in the same
+// order as ForEach. Unlike ForEach it keeps the page it is reading pinned
+// and reads the slots as it goes, so a caller can stop between any two
+// tuples while holding at most one page of the table. Close unpins it.
+type HeapIterator struct {
+	heap   *TableHeap
+	pageID types.PageID // Page being read, or the next one to fetch when page is nil
+	page   *Page        // Pinned; nil between pages and once exhausted
+	slot   uint16       // Next slot of page to read
+}
+
+// Iterator returns an iterator positioned before the heap's first tuple.
+func (th *TableHeap) Iterator() *HeapIterator {
+	return &HeapIterator{heap: th, pageID: th.firstPage}
+}
+
+// Next returns the next tuple, or nil once the heap is exhausted. Deleted
+// slots and tuples that fail to decode are skipped, as in ForEach.
+func (it *HeapIterator) Next() (*TupleWithRID, error) {
+	for it.pageID != types.InvalidPageID {
+		if it.page == nil {
+			page, err := it.heap.bufferPool.FetchPage(it.pageID)
+			if err != nil {
+				if it.pageID != it.heap.firstPage {
+					// Page doesn't exist yet, stop scanning
+					it.pageID = types.InvalidPageID
+					return nil, nil
+				}
+				return nil, err
+			}
+			if next := page.GetNextPageID(); next != types.InvalidPageID {
+				it.heap.bufferPool.Prefetch([]types.PageID{next})
+			}
+			it.page, it.slot = page, 0
+		}
+
+		for it.slot < it.page.GetSlotCount() {
+			slot := it.slot
+			it.slot++
+			data, err := it.page.GetTuple(slot)
+			if err != nil {
+				continue // Deleted slot
+			}
+			tuple, err := it.heap.decode(data)
+			if err != nil {
+				continue
+			}
+			return &TupleWithRID{Tuple: tuple, PageID: it.pageID, SlotNum: slot}, nil
+		}
+
+		nextPageID := it.page.GetNextPageID()
+		it.heap.bufferPool.UnpinPage(it.pageID, false)
+		it.page, it.pageID = nil, nextPageID
+	}
+	return nil, nil
+}
+
+// Close unpins the page the iterator is on. Next returns nil afterwards.
+func (it *HeapIterator) Close() {
+	if it.page != nil {
+		it.heap.bufferPool.UnpinPage(it.pageID, false)
+		it.page = nil
+	}
+	it.pageID = types.InvalidPageID
+}
+
+// Pages returns the IDs of the heap's pages in chain order.
+func (th *TableHeap) Pages() ([]types.PageID, error) {
+	var pages []types.PageID
+
+	for pageID := th.firstPage; pageID != types.InvalidPageID; {
+		page, err := th.bufferPool.FetchPage(pageID)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, pageID)
+		nextPageID := page.GetNextPageID()
+		th.bufferPool.UnpinPage(pageID, false)
+		pageID = nextPageID
+	}
+
+	return pages, nil
+}
+
+// Free returns every page of the heap, and the overflow pages of its
+// tuples, to the free list. The heap must not be used afterwards.
+func (th *TableHeap) Free() error {
+	overflow, err := th.OverflowPages()
+	if err != nil {
+		return err
+	}
+	pages, err := th.Pages()
+	if err != nil {
+		return err
+	}
+	pages = append(pages, overflow...)
+	pages = append(pages, th.TakeUnlinked()...)
+	for _, pageID := range pages {
+		if err := th.bufferPool.FreePage(pageID); err != nil {
+			return fmt.Errorf("free heap page %d: %w", pageID, err)
+		}
+	}
+	return nil
+}
+
+// HeapPageStats summarizes a heap page from its header.
+type HeapPageStats struct {
+	PageID         types.PageID
+	LiveTuples     uint16
+	FreeSpace      int // Bytes available for one more tuple, as Page.FreeSpace
+	FreeSpaceClass uint8
+}
+
+// PageStats returns the header statistics of every page in the heap, in
+// chain order.
+func (th *TableHeap) PageStats() ([]HeapPageStats, error) {
+	var stats []HeapPageStats
+
+	for pageID := th.firstPage; pageID != types.InvalidPageID; {
+		page, err := th.bufferPool.FetchPage(pageID)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, HeapPageStats{
+			PageID:         pageID,
+			LiveTuples:     page.GetLiveTupleCount(),
+			FreeSpace:      page.FreeSpace(),
+			FreeSpaceClass: page.GetFreeSpaceClass(),
+		})
+		nextPageID := page.GetNextPageID()
+		th.bufferPool.UnpinPage(pageID, false)
+		pageID = nextPageID
+	}
+
+	return stats, nil
 }
 
 // TupleWithRID wraps a tuple with its location.
@@ -201,16 +557,30 @@ func (th *TableHeap) SetLastPage(pageID types.PageID) {
 	th.lastPage = pageID
 }
 
-// Catalog manages database schema and table metadata.
+// Catalog manages database schema and table metadata. It is safe for
+// concurrent use: the maps are guarded by mu, which a change holds until
+// the catalog page is written.
 type Catalog struct {
-	bufferPool   *BufferPool
+	mu           sync.RWMutex
+	bufferPool   *BufferPool // Table heaps
+	pagePool     *BufferPool // The catalog page; bufferPool unless the catalog has its own file
 	catalogPage  types.PageID
 	schemas      map[string]*types.Schema
 	tableHeaps   map[uint32]*TableHeap
 	tableIDs     map[string]uint32
 	nextTableID  uint32
-	indexRoots   map[uint32]types.PageID // tableID -> B-Tree root
-	indexColumns map[uint32]string       // tableID -> column name
+	indexRoots   map[uint32]map[string]types.PageID // tableID -> column -> B-Tree root
+	sequences    map[uint32]int64                   // tableID -> last SERIAL value issued
+	comments     map[commentKey]string              // COMMENT ON text
+	temps        map[uint32]types.TxnID             // tableID -> transaction owning a temp table
+	deferred     bool                               // Changes are held back until WritePending
+	pending      bool                               // A change was held back
+}
+
+// commentKey identifies a table comment (empty column) or a column comment.
+type commentKey struct {
+	tableID uint32
+	column  string
 }
 
 // CatalogEntry represents a serialized catalog entry.
@@ -225,47 +595,64 @@ type CatalogEntry struct {
 
 // NewCatalog creates a new catalog.
 func NewCatalog(bufferPool *BufferPool) (*Catalog, error) {
+	return NewSeparateCatalog(bufferPool, bufferPool)
+}
+
+// NewSeparateCatalog creates a catalog whose page lives in catalogPool while
+// the table heaps it creates live in bufferPool.
+func NewSeparateCatalog(catalogPool, bufferPool *BufferPool) (*Catalog, error) {
 	// Allocate catalog page
-	page, err := bufferPool.NewPage(PageTypeCatalog)
+	page, err := catalogPool.NewPage(PageTypeCatalog)
 	if err != nil {
 		return nil, err
 	}
 	
 	c := &Catalog{
 		bufferPool:   bufferPool,
+		pagePool:     catalogPool,
 		catalogPage:  page.ID,
 		schemas:      make(map[string]*types.Schema),
 		tableHeaps:   make(map[uint32]*TableHeap),
 		tableIDs:     make(map[string]uint32),
 		nextTableID:  1,
-		indexRoots:   make(map[uint32]types.PageID),
-		indexColumns: make(map[uint32]string),
+		indexRoots:   make(map[uint32]map[string]types.PageID),
+		sequences:    make(map[uint32]int64),
+		comments:     make(map[commentKey]string),
+		temps:        make(map[uint32]types.TxnID),
 	}
 
-	bufferPool.UnpinPage(page.ID, true)
+	catalogPool.UnpinPage(page.ID, true)
 
 	return c, nil
 }
 
 // LoadCatalog loads the catalog from disk.
 func LoadCatalog(bufferPool *BufferPool, catalogPageID types.PageID) (*Catalog, error) {
+	return LoadSeparateCatalog(bufferPool, bufferPool, catalogPageID)
+}
+
+// LoadSeparateCatalog loads a catalog created by NewSeparateCatalog.
+func LoadSeparateCatalog(catalogPool, bufferPool *BufferPool, catalogPageID types.PageID) (*Catalog, error) {
 	c := &Catalog{
 		bufferPool:   bufferPool,
+		pagePool:     catalogPool,
 		catalogPage:  catalogPageID,
 		schemas:      make(map[string]*types.Schema),
 		tableHeaps:   make(map[uint32]*TableHeap),
 		tableIDs:     make(map[string]uint32),
 		nextTableID:  1,
-		indexRoots:   make(map[uint32]types.PageID),
-		indexColumns: make(map[uint32]string),
+		indexRoots:   make(map[uint32]map[string]types.PageID),
+		sequences:    make(map[uint32]int64),
+		comments:     make(map[commentKey]string),
+		temps:        make(map[uint32]types.TxnID),
 	}
 	
 	// Read catalog page
-	page, err := bufferPool.FetchPage(catalogPageID)
+	page, err := catalogPool.FetchPage(catalogPageID)
 	if err != nil {
 		return nil, err
 	}
-	defer bufferPool.UnpinPage(catalogPageID, false)
+	defer catalogPool.UnpinPage(catalogPageID, false)
 	
 	// Parse catalog entries
 	c.deserialize(page)
@@ -275,6 +662,13 @@ func LoadCatalog(bufferPool *BufferPool, catalogPageID types.PageID) (*Catalog,
 
 // CreateTable creates a new table.
 func (c *Catalog) CreateTable(schema *types.Schema) (uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.createTable(schema)
+}
+
+// createTable creates a new table (must hold c.mu).
+func (c *Catalog) createTable(schema *types.Schema) (uint32, error) {
 	if _, exists := c.tableIDs[schema.TableName]; exists {
 		return 0, fmt.Errorf("table %s already exists", schema.TableName)
 	}
@@ -293,140 +687,440 @@ func (c *Catalog) CreateTable(schema *types.Schema) (uint32, error) {
 	c.tableIDs[schema.TableName] = tableID
 	
 	// Save catalog
-	c.serialize()
+	if err := c.serialize(); err != nil {
+		delete(c.schemas, schema.TableName)
+		delete(c.tableHeaps, tableID)
+		delete(c.tableIDs, schema.TableName)
+		return 0, err
+	}
 	
 	return tableID, nil
 }
 
+// CreateTempTable creates a table that lives only as long as transaction
+// owner. The caller drops it when the transaction ends; one left behind by a
+// crash is dropped when the catalog is next loaded.
+func (c *Catalog) CreateTempTable(schema *types.Schema, owner types.TxnID) (uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tableID, err := c.createTable(schema)
+	if err != nil {
+		return 0, err
+	}
+	c.temps[tableID] = owner
+	if err := c.serialize(); err != nil {
+		delete(c.temps, tableID)
+		c.dropTable(schema.TableName)
+		return 0, err
+	}
+	return tableID, nil
+}
+
+// IsTemp reports whether a table was created by CreateTempTable.
+func (c *Catalog) IsTemp(tableID uint32) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.temps[tableID]
+	return ok
+}
+
+// DropTable removes a table, its index entry, sequence and comments from the
+// catalog. A temp table's heap pages go back to the free list: its writes
+// are not logged, so recovery never replays a record into them once they
+// are reused. Other tables' heap pages, and index pages, are left for
+// Repair, which checkpoints after reclaiming them.
+func (c *Catalog) DropTable(tableName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropTable(tableName)
+}
+
+// dropTable removes a table (must hold c.mu).
+func (c *Catalog) dropTable(tableName string) error {
+	tableID, exists := c.tableIDs[tableName]
+	if !exists {
+		return fmt.Errorf("table %s does not exist", tableName)
+	}
+	heap := c.tableHeaps[tableID]
+	_, temp := c.temps[tableID]
+	c.removeTable(tableName, tableID)
+	
+	// A smaller catalog always fits in its page
+	if err := c.serialize(); err != nil {
+		return err
+	}
+	if temp {
+		return heap.Free()
+	}
+	return nil
+}
+
+// removeTable removes a table's entries from the in-memory catalog.
+func (c *Catalog) removeTable(tableName string, tableID uint32) {
+	delete(c.schemas, tableName)
+	delete(c.tableHeaps, tableID)
+	delete(c.tableIDs, tableName)
+	delete(c.indexRoots, tableID)
+	delete(c.sequences, tableID)
+	delete(c.temps, tableID)
+	for key := range c.comments {
+		if key.tableID == tableID {
+			delete(c.comments, key)
+		}
+	}
+}
+
 // GetSchema returns the schema for a table.
 func (c *Catalog) GetSchema(tableName string) *types.Schema {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.schemas[tableName]
 }
 
 // GetTableID returns the table ID for a table name.
 func (c *Catalog) GetTableID(tableName string) (uint32, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	id, ok := c.tableIDs[tableName]
 	return id, ok
 }
 
 // GetTableHeap returns the table heap for a table ID.
 func (c *Catalog) GetTableHeap(tableID uint32) *TableHeap {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.tableHeaps[tableID]
 }
 
-// SetIndexRoot sets the B-Tree root and indexed column for a table.
+// ReplaceTableHeap gives a table a new, empty heap and returns the old one,
+// for TRUNCATE. The old heap's pages are left alone so that SetTableHeap
+// can put it back; once it is no longer needed they are reclaimed like a
+// dropped table's.
+func (c *Catalog) ReplaceTableHeap(tableID uint32) (*TableHeap, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old, ok := c.tableHeaps[tableID]
+	if !ok {
+		return nil, fmt.Errorf("table %d does not exist", tableID)
+	}
+	heap, err := NewTableHeap(c.bufferPool, tableID)
+	if err != nil {
+		return nil, err
+	}
+	c.tableHeaps[tableID] = heap
+	c.serialize()
+	return old, nil
+}
+
+// SetTableHeap makes heap, returned earlier by ReplaceTableHeap, the table's
+// heap again.
+func (c *Catalog) SetTableHeap(tableID uint32, heap *TableHeap) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tableHeaps[tableID] = heap
+	c.serialize()
+}
+
+// SetIndexRoot sets the B-Tree root of the index on a table's column,
+// adding the index if the column has none.
 func (c *Catalog) SetIndexRoot(tableID uint32, rootPageID types.PageID, columnName string) {
-	c.indexRoots[tableID] = rootPageID
-	c.indexColumns[tableID] = columnName
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.indexRoots[tableID] == nil {
+		c.indexRoots[tableID] = make(map[string]types.PageID)
+	}
+	c.indexRoots[tableID][columnName] = rootPageID
+	c.serialize()
+}
+
+// ClearIndexRoot forgets the index on a table's column.
+func (c *Catalog) ClearIndexRoot(tableID uint32, columnName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.indexRoots[tableID], columnName)
+	if len(c.indexRoots[tableID]) == 0 {
+		delete(c.indexRoots, tableID)
+	}
 	c.serialize()
 }
 
-// GetIndexColumn returns the indexed column name for a table.
-func (c *Catalog) GetIndexColumn(tableID uint32) (string, bool) {
-	col, ok := c.indexColumns[tableID]
-	return col, ok
+// GetIndexColumns returns the indexed columns of a table, sorted by name.
+func (c *Catalog) GetIndexColumns(tableID uint32) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.indexColumns(tableID)
 }
 
-// GetIndexRoot returns the B-Tree root for a table.
-func (c *Catalog) GetIndexRoot(tableID uint32) (types.PageID, bool) {
-	root, ok := c.indexRoots[tableID]
+// indexColumns returns the indexed columns of a table (must hold c.mu).
+func (c *Catalog) indexColumns(tableID uint32) []string {
+	columns := make([]string, 0, len(c.indexRoots[tableID]))
+	for col := range c.indexRoots[tableID] {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// GetIndexRoot returns the B-Tree root of the index on a table's column.
+func (c *Catalog) GetIndexRoot(tableID uint32, columnName string) (types.PageID, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	root, ok := c.indexRoots[tableID][columnName]
 	return root, ok
 }
 
+// NextSequenceValue advances the table's SERIAL sequence and returns the new
+// value. Sequences start at 1 and are not rolled back with the transaction.
+func (c *Catalog) NextSequenceValue(tableID uint32) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sequences[tableID]++
+	c.serialize()
+	return c.sequences[tableID]
+}
+
+// AdvanceSequence moves the table's SERIAL sequence up to value, if it is
+// behind, so that a value inserted explicitly is not issued again.
+func (c *Catalog) AdvanceSequence(tableID uint32, value int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value > c.sequences[tableID] {
+		c.sequences[tableID] = value
+		c.serialize()
+	}
+}
+
+// ResetSequence restarts the table's SERIAL sequence so the next value is 1.
+func (c *Catalog) ResetSequence(tableID uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sequences, tableID)
+	c.serialize()
+}
+
+// SetComment sets the comment on a table (column == "") or one of its
+// columns. An empty text removes the comment.
+func (c *Catalog) SetComment(tableID uint32, column, text string) error {
+	if len(text) > maxCommentLen {
+		return fmt.Errorf("comment too long: %d bytes (max %d)", len(text), maxCommentLen)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := commentKey{tableID, column}
+	old, hadOld := c.comments[key]
+	if text == "" {
+		delete(c.comments, key)
+	} else {
+		c.comments[key] = text
+	}
+
+	if err := c.serialize(); err != nil {
+		if hadOld {
+			c.comments[key] = old
+		} else {
+			delete(c.comments, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetComment returns the comment on a table (column == "") or column.
+func (c *Catalog) GetComment(tableID uint32, column string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	text, ok := c.comments[commentKey{tableID, column}]
+	return text, ok
+}
+
 // GetCatalogPageID returns the catalog page ID.
 func (c *Catalog) GetCatalogPageID() types.PageID {
 	return c.catalogPage
 }
 
-// serialize saves the catalog to disk.
-func (c *Catalog) serialize() {
-	page, err := c.bufferPool.FetchPage(c.catalogPage)
-	if err != nil {
-		return
+// DeferWrites holds back writing catalog changes to the catalog page until
+// WritePending, so that a transaction's schema changes reach disk only when
+// it ends. Changes are still checked against the page size as they are made.
+func (c *Catalog) DeferWrites() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deferred = true
+}
+
+// WritePending ends DeferWrites and writes the changes held back since.
+func (c *Catalog) WritePending() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deferred = false
+	if !c.pending {
+		return nil
 	}
-	defer c.bufferPool.UnpinPage(c.catalogPage, true)
-	
-	// Clear page
-	for i := PageHeaderSize; i < PageSize; i++ {
-		page.Data[i] = 0
+	c.pending = false
+	return c.serialize()
+}
+
+// serialize saves the catalog to disk (must hold c.mu). It fails without modifying the page
+// if the encoded catalog does not fit in a single page.
+func (c *Catalog) serialize() error {
+	data := c.encode()
+	if len(data) > PageSize-PageHeaderSize {
+		return fmt.Errorf("catalog too large: %d bytes, page holds %d", len(data), PageSize-PageHeaderSize)
 	}
-	
-	offset := PageHeaderSize
-	
-	// Write number of tables
-	binary.LittleEndian.PutUint32(page.Data[offset:], uint32(len(c.schemas)))
-	offset += 4
-	
-	// Write next table ID
-	binary.LittleEndian.PutUint32(page.Data[offset:], c.nextTableID)
-	offset += 4
-	
-	// Write each table entry
+	if c.deferred {
+		c.pending = true
+		return nil
+	}
+
+	page, err := c.pagePool.FetchPage(c.catalogPage)
+	if err != nil {
+		return err
+	}
+	defer c.pagePool.UnpinPage(c.catalogPage, true)
+
+	n := copy(page.Data[PageHeaderSize:], data)
+	clear(page.Data[PageHeaderSize+n:])
+	page.IsDirty = true
+	return nil
+}
+
+// encode returns the catalog's on-page representation.
+func (c *Catalog) encode() []byte {
+	le := binary.LittleEndian
+	buf := make([]byte, 0, PageSize-PageHeaderSize)
+
+	// Number of tables, next table ID
+	buf = le.AppendUint32(buf, uint32(len(c.schemas)))
+	buf = le.AppendUint32(buf, c.nextTableID)
+
+	// Write each table entry. The entry holds the table's first index (by
+	// column name); any others go in the index trailer.
 	for tableName, schema := range c.schemas {
 		tableID := c.tableIDs[tableName]
 		heap := c.tableHeaps[tableID]
-		indexRoot, ok := c.indexRoots[tableID]
-		if !ok {
-			indexRoot = types.InvalidPageID
+		indexRoot, indexCol := types.InvalidPageID, ""
+		if columns := c.indexColumns(tableID); len(columns) > 0 {
+			indexCol = columns[0]
+			indexRoot = c.indexRoots[tableID][indexCol]
 		}
-		
-		// Table ID
-		binary.LittleEndian.PutUint32(page.Data[offset:], tableID)
-		offset += 4
-		
-		// Table name length + name
-		nameBytes := []byte(tableName)
-		binary.LittleEndian.PutUint16(page.Data[offset:], uint16(len(nameBytes)))
-		offset += 2
-		copy(page.Data[offset:], nameBytes)
-		offset += len(nameBytes)
-		
+
+		buf = le.AppendUint32(buf, tableID)
+		buf = appendString(buf, tableName)
+
 		// First/Last page
-		binary.LittleEndian.PutUint32(page.Data[offset:], uint32(heap.GetFirstPage()))
-		offset += 4
-		binary.LittleEndian.PutUint32(page.Data[offset:], uint32(heap.GetLastPage()))
-		offset += 4
-		
-		// Index root
-		binary.LittleEndian.PutUint32(page.Data[offset:], uint32(indexRoot))
-		offset += 4
+		buf = le.AppendUint32(buf, uint32(heap.GetFirstPage()))
+		buf = le.AppendUint32(buf, uint32(heap.GetLastPage()))
 
-		// Index column name
-		indexCol := c.indexColumns[tableID]
-		indexColBytes := []byte(indexCol)
-		binary.LittleEndian.PutUint16(page.Data[offset:], uint16(len(indexColBytes)))
-		offset += 2
-		copy(page.Data[offset:], indexColBytes)
-		offset += len(indexColBytes)
+		// Index root and column name
+		buf = le.AppendUint32(buf, uint32(indexRoot))
+		buf = appendString(buf, indexCol)
 
-		// Number of columns
-		binary.LittleEndian.PutUint16(page.Data[offset:], uint16(len(schema.Columns)))
-		offset += 2
-		
-		// Each column
+		// Columns
+		buf = le.AppendUint16(buf, uint16(len(schema.Columns)))
 		for _, col := range schema.Columns {
-			// Name
-			colNameBytes := []byte(col.Name)
-			binary.LittleEndian.PutUint16(page.Data[offset:], uint16(len(colNameBytes)))
-			offset += 2
-			copy(page.Data[offset:], colNameBytes)
-			offset += len(colNameBytes)
-			
-			// Type
-			page.Data[offset] = byte(col.Type)
-			offset++
-			
-			// Nullable
-			if col.Nullable {
-				page.Data[offset] = 1
-			} else {
-				page.Data[offset] = 0
+			buf = appendString(buf, col.Name)
+			buf = append(buf, byte(col.Type), columnFlags(col))
+			if col.Default != nil {
+				buf = appendValue(buf, *col.Default)
+			}
+			if col.Collation != types.CollationBinary {
+				buf = append(buf, byte(col.Collation))
 			}
-			offset++
 		}
 	}
-	
-	page.IsDirty = true
+
+	// Sequence trailer: count + (tableID, last value) pairs
+	buf = le.AppendUint32(buf, uint32(len(c.sequences)))
+	for tableID, value := range c.sequences {
+		buf = le.AppendUint32(buf, tableID)
+		buf = le.AppendUint64(buf, uint64(value))
+	}
+
+	// Comment trailer: count + (tableID, column, text); an empty column
+	// name is the table's own comment
+	buf = le.AppendUint32(buf, uint32(len(c.comments)))
+	for key, text := range c.comments {
+		buf = le.AppendUint32(buf, key.tableID)
+		buf = appendString(buf, key.column)
+		buf = appendString(buf, text)
+	}
+
+	// Temp table trailer: count + (tableID, owning TxnID)
+	buf = le.AppendUint32(buf, uint32(len(c.temps)))
+	for tableID, owner := range c.temps {
+		buf = le.AppendUint32(buf, tableID)
+		buf = le.AppendUint64(buf, uint64(owner))
+	}
+
+	// Index trailer: count + (tableID, column, root) for each index but
+	// the first of its table
+	var extra []byte
+	numExtra := 0
+	for tableID := range c.indexRoots {
+		for _, col := range c.indexColumns(tableID)[1:] {
+			extra = le.AppendUint32(extra, tableID)
+			extra = appendString(extra, col)
+			extra = le.AppendUint32(extra, uint32(c.indexRoots[tableID][col]))
+			numExtra++
+		}
+	}
+	buf = le.AppendUint32(buf, uint32(numExtra))
+	buf = append(buf, extra...)
+
+	return buf
+}
+
+// appendValue appends a non-NULL value as its type byte followed by the
+// same encoding SerializeRow uses.
+func appendValue(buf []byte, v types.Value) []byte {
+	buf = append(buf, byte(v.Type))
+	switch v.Type {
+	case types.ValueTypeInt, types.ValueTypeTimestamp:
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(v.IntVal))
+	case types.ValueTypeString:
+		buf = appendString(buf, v.StrVal)
+	case types.ValueTypeBool:
+		if v.BoolVal {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	case types.ValueTypeBytes:
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v.BytesVal)))
+		buf = append(buf, v.BytesVal...)
+	}
+	return buf
+}
+
+// readValue decodes a value written by appendValue, returning it and the
+// number of bytes consumed.
+func readValue(data []byte) (types.Value, int) {
+	v := types.Value{Type: types.ValueType(data[0])}
+	switch v.Type {
+	case types.ValueTypeInt, types.ValueTypeTimestamp:
+		v.IntVal = int64(binary.LittleEndian.Uint64(data[1:]))
+		return v, 9
+	case types.ValueTypeString:
+		n := int(binary.LittleEndian.Uint16(data[1:]))
+		v.StrVal = string(data[3 : 3+n])
+		return v, 3 + n
+	case types.ValueTypeBool:
+		v.BoolVal = data[1] == 1
+		return v, 2
+	case types.ValueTypeBytes:
+		n := int(binary.LittleEndian.Uint32(data[1:]))
+		v.BytesVal = append([]byte(nil), data[5:5+n]...)
+		return v, 5 + n
+	default:
+		v.IsNull = true
+		return v, 1
+	}
+}
+
+// appendString appends a uint16 length-prefixed string.
+func appendString(buf []byte, s string) []byte {
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
 }
 
 // deserialize loads the catalog from a page.
@@ -486,14 +1180,30 @@ func (c *Catalog) deserialize(page *Page) {
 			colType := types.ValueType(page.Data[offset])
 			offset++
 			
-			// Nullable
-			nullable := page.Data[offset] == 1
+			// Column flags
+			flags := page.Data[offset]
 			offset++
 			
 			columns[j] = types.Column{
-				Name:     colName,
-				Type:     colType,
-				Nullable: nullable,
+				Name:       colName,
+				Type:       colType,
+				Nullable:   flags&columnFlagNullable != 0,
+				Serial:     flags&columnFlagSerial != 0,
+				Unique:     flags&columnFlagUnique != 0,
+				PrimaryKey: flags&columnFlagPrimaryKey != 0,
+			}
+			
+			// Default value follows the flags when present
+			if flags&columnFlagDefault != 0 {
+				def, n := readValue(page.Data[offset:])
+				columns[j].Default = &def
+				offset += n
+			}
+
+			// Then the collation, when not the default
+			if flags&columnFlagCollation != 0 {
+				columns[j].Collation = types.Collation(page.Data[offset])
+				offset++
 			}
 		}
 		
@@ -510,14 +1220,111 @@ func (c *Catalog) deserialize(page *Page) {
 		c.tableHeaps[tableID] = heap
 		c.tableIDs[tableName] = tableID
 		if indexRoot != types.InvalidPageID {
-			c.indexRoots[tableID] = indexRoot
-			c.indexColumns[tableID] = indexCol
+			c.indexRoots[tableID] = map[string]types.PageID{indexCol: indexRoot}
+		}
+	}
+
+	// Sequence trailer (absent, i.e. zero, in catalogs written before SERIAL)
+	numSeqs := binary.LittleEndian.Uint32(page.Data[offset:])
+	offset += 4
+	for i := uint32(0); i < numSeqs; i++ {
+		tableID := binary.LittleEndian.Uint32(page.Data[offset:])
+		offset += 4
+		c.sequences[tableID] = int64(binary.LittleEndian.Uint64(page.Data[offset:]))
+		offset += 8
+	}
+
+	// Comment trailer (absent in catalogs written before COMMENT ON)
+	numComments := binary.LittleEndian.Uint32(page.Data[offset:])
+	offset += 4
+	for i := uint32(0); i < numComments; i++ {
+		tableID := binary.LittleEndian.Uint32(page.Data[offset:])
+		offset += 4
+		colLen := int(binary.LittleEndian.Uint16(page.Data[offset:]))
+		offset += 2
+		column := string(page.Data[offset : offset+colLen])
+		offset += colLen
+		textLen := int(binary.LittleEndian.Uint16(page.Data[offset:]))
+		offset += 2
+		c.comments[commentKey{tableID, column}] = string(page.Data[offset : offset+textLen])
+		offset += textLen
+	}
+
+	// Temp table trailer (absent in catalogs written before TEMP tables).
+	// Their transactions did not survive the restart, so drop them now;
+	// the catalog page is rewritten by the next serialize.
+	numTemps := binary.LittleEndian.Uint32(page.Data[offset:])
+	offset += 4
+	for i := uint32(0); i < numTemps; i++ {
+		tableID := binary.LittleEndian.Uint32(page.Data[offset:])
+		offset += 12
+		for name, id := range c.tableIDs {
+			if id == tableID {
+				c.removeTable(name, tableID)
+			}
 		}
 	}
+
+	// Index trailer (absent in catalogs written before a table could have
+	// more than one index)
+	numExtra := binary.LittleEndian.Uint32(page.Data[offset:])
+	offset += 4
+	for i := uint32(0); i < numExtra; i++ {
+		tableID := binary.LittleEndian.Uint32(page.Data[offset:])
+		offset += 4
+		colLen := int(binary.LittleEndian.Uint16(page.Data[offset:]))
+		offset += 2
+		column := string(page.Data[offset : offset+colLen])
+		offset += colLen
+		root := types.PageID(binary.LittleEndian.Uint32(page.Data[offset:]))
+		offset += 4
+		if c.indexRoots[tableID] != nil { // Not a dropped temp table
+			c.indexRoots[tableID][column] = root
+		}
+	}
+}
+
+// maxCommentLen bounds a single comment; the whole catalog must still fit
+// in one page.
+const maxCommentLen = 1024
+
+// Column flag bits stored in the catalog.
+const (
+	columnFlagNullable   = 1 << 0
+	columnFlagSerial     = 1 << 1
+	columnFlagDefault    = 1 << 2
+	columnFlagUnique     = 1 << 3
+	columnFlagPrimaryKey = 1 << 4
+	columnFlagCollation  = 1 << 5
+)
+
+func columnFlags(col types.Column) byte {
+	var flags byte
+	if col.Nullable {
+		flags |= columnFlagNullable
+	}
+	if col.Serial {
+		flags |= columnFlagSerial
+	}
+	if col.Default != nil {
+		flags |= columnFlagDefault
+	}
+	if col.Unique {
+		flags |= columnFlagUnique
+	}
+	if col.PrimaryKey {
+		flags |= columnFlagPrimaryKey
+	}
+	if col.Collation != types.CollationBinary {
+		flags |= columnFlagCollation
+	}
+	return flags
 }
 
 // GetAllTables returns all table names.
 func (c *Catalog) GetAllTables() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	tables := make([]string, 0, len(c.schemas))
 	for name := range c.schemas {
 		tables = append(tables, name)
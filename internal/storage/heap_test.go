@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"minidb/pkg/types"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -177,6 +179,338 @@ func TestTableHeapMultiPageScan(t *testing.T) {
 	}
 }
 
+func TestTableHeapScanInterleavedPages(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	users, _ := NewTableHeap(bp, 1)
+	orders, _ := NewTableHeap(bp, 2)
+
+	// Alternate inserts between the tables, with a B-Tree page allocated
+	// every few rows as an index split would, so neither heap's pages are
+	// contiguous
+	data := bytes.Repeat([]byte("x"), 300)
+	count := 40
+	for i := 0; i < count; i++ {
+		for _, th := range []*TableHeap{users, orders} {
+			tuple := &types.Tuple{
+				XMin: 1, XMax: types.InvalidTxnID, TableID: th.tableID, RowID: uint64(i + 1),
+				Data: data,
+			}
+			if _, _, err := th.Insert(tuple); err != nil {
+				t.Fatalf("Insert() table %d row %d error = %v", th.tableID, i, err)
+			}
+		}
+		if i%5 == 0 {
+			page, err := bp.NewPage(PageTypeBTree)
+			if err != nil {
+				t.Fatalf("NewPage() error = %v", err)
+			}
+			bp.UnpinPage(page.ID, true)
+		}
+	}
+
+	for _, th := range []*TableHeap{users, orders} {
+		stats, err := th.PageStats()
+		if err != nil {
+			t.Fatalf("PageStats() error = %v", err)
+		}
+		contiguous := true
+		for i := 1; i < len(stats); i++ {
+			if stats[i].PageID != stats[i-1].PageID+1 {
+				contiguous = false
+			}
+		}
+		if len(stats) < 2 || contiguous {
+			t.Fatalf("table %d pages = %v, want several non-contiguous pages", th.tableID, stats)
+		}
+
+		results, err := th.Scan()
+		if err != nil {
+			t.Fatalf("Scan() error = %v", err)
+		}
+		if len(results) != count {
+			t.Errorf("table %d Scan() = %d tuples, want %d", th.tableID, len(results), count)
+		}
+		for _, r := range results {
+			if r.Tuple.TableID != th.tableID {
+				t.Errorf("table %d Scan() returned a tuple of table %d from page %d", th.tableID, r.Tuple.TableID, r.PageID)
+				break
+			}
+		}
+	}
+}
+
+func TestTableHeapPageStats(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	th, _ := NewTableHeap(bp, 1)
+
+	var rids [][2]uint32
+	for i := 0; i < 100; i++ {
+		tuple := &types.Tuple{XMin: 1, TableID: 1, Data: bytes.Repeat([]byte{'x'}, 100)}
+		pageID, slot, err := th.Insert(tuple)
+		if err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+		rids = append(rids, [2]uint32{uint32(pageID), uint32(slot)})
+	}
+	for _, rid := range rids[:10] {
+		th.Delete(types.PageID(rid[0]), uint16(rid[1]))
+	}
+
+	stats, err := th.PageStats()
+	if err != nil {
+		t.Fatalf("PageStats() error = %v", err)
+	}
+	if len(stats) < 2 {
+		t.Fatalf("pages = %d, want a multi-page heap", len(stats))
+	}
+
+	total := 0
+	for _, s := range stats {
+		total += int(s.LiveTuples)
+	}
+	if total != 90 {
+		t.Errorf("live tuples = %d, want 90", total)
+	}
+
+	// The deletes were all on the first page and freed its space; the
+	// other full pages have none
+	if stats[0].FreeSpaceClass == 0 {
+		t.Errorf("page %d class = 0 after deletes, want > 0", stats[0].PageID)
+	}
+	for _, s := range stats[1 : len(stats)-1] {
+		if s.FreeSpaceClass != 0 {
+			t.Errorf("page %d class = %d, want 0 for a full page", s.PageID, s.FreeSpaceClass)
+		}
+	}
+}
+
+func TestTableHeapPages(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	th, _ := NewTableHeap(bp, 1)
+
+	// Each tuple takes a fixed amount of page space, so the number of pages
+	// follows from how many fit on one
+	data := bytes.Repeat([]byte{'x'}, 200)
+	perPage := (PageSize - PageHeaderSize) / (types.TupleHeaderSize + len(data) + slotSize)
+	const n = 100
+	for i := 0; i < n; i++ {
+		if _, _, err := th.Insert(&types.Tuple{XMin: 1, TableID: 1, Data: data}); err != nil {
+			t.Fatalf("Insert(%d) error = %v", i, err)
+		}
+	}
+
+	pages, err := th.Pages()
+	if err != nil {
+		t.Fatalf("Pages() error = %v", err)
+	}
+	want := (n + perPage - 1) / perPage
+	if len(pages) != want {
+		t.Fatalf("Pages() = %d pages, want %d (%d tuples per page)", len(pages), want, perPage)
+	}
+
+	meta := th.GetMeta()
+	if pages[0] != meta.FirstPage || pages[len(pages)-1] != meta.LastPage {
+		t.Errorf("Pages() = %v, want it to run from %d to %d", pages, meta.FirstPage, meta.LastPage)
+	}
+	stats, _ := th.PageStats()
+	for i, s := range stats {
+		if s.PageID != pages[i] {
+			t.Errorf("PageStats()[%d].PageID = %d, want %d", i, s.PageID, pages[i])
+		}
+	}
+}
+
+func TestTableHeapScanPrefetches(t *testing.T) {
+	bp, dm := newTestHeapSetup(t)
+	th, _ := NewTableHeap(bp, 1)
+
+	data := bytes.Repeat([]byte{'x'}, 500)
+	for i := 0; i < 100; i++ {
+		if _, _, err := th.Insert(&types.Tuple{XMin: 1, TableID: 1, RowID: uint64(i + 1), Data: data}); err != nil {
+			t.Fatalf("Insert(%d) error = %v", i, err)
+		}
+	}
+	if err := bp.FlushAllPages(); err != nil {
+		t.Fatalf("FlushAllPages() error = %v", err)
+	}
+	meta := th.GetMeta()
+
+	// coldMisses runs walk over the heap in a fresh pool
+	coldMisses := func(walk func(*TableHeap) error) (misses, prefetches uint64) {
+		t.Helper()
+		cold := NewBufferPool(dm, 100)
+		if err := walk(LoadTableHeap(cold, 1, meta.FirstPage, meta.LastPage)); err != nil {
+			t.Fatalf("walk error = %v", err)
+		}
+		_, misses, _ = cold.Stats()
+		return misses, cold.Prefetches()
+	}
+
+	// Pages follows the chain without prefetching: a miss per page
+	var pages []types.PageID
+	without, _ := coldMisses(func(h *TableHeap) (err error) {
+		pages, err = h.Pages()
+		return err
+	})
+	if len(pages) < 10 || without != uint64(len(pages)) {
+		t.Fatalf("misses without prefetch = %d over %d pages, want one per page", without, len(pages))
+	}
+
+	// Scan finds every page after the first already read
+	var n int
+	with, prefetched := coldMisses(func(h *TableHeap) error {
+		return h.ForEach(func(*TupleWithRID) error {
+			n++
+			return nil
+		})
+	})
+	if n != 100 {
+		t.Errorf("ForEach() visited %d tuples, want 100", n)
+	}
+	if with != 1 || prefetched != uint64(len(pages)-1) {
+		t.Errorf("misses with prefetch = %d (%d prefetched), want 1 (%d prefetched)", with, prefetched, len(pages)-1)
+	}
+}
+
+func TestTableHeapIterator(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	th, _ := NewTableHeap(bp, 1)
+
+	data := bytes.Repeat([]byte("a"), 300)
+	for i := 0; i < 30; i++ {
+		th.Insert(&types.Tuple{XMin: 1, TableID: 1, RowID: uint64(i + 1), Data: data})
+	}
+	first, _ := th.Scan()
+	th.Delete(first[3].PageID, first[3].SlotNum)
+	pages, _ := th.Pages()
+	if len(pages) < 3 {
+		t.Fatalf("heap has %d pages, want several", len(pages))
+	}
+	pinned := func(pageID types.PageID) int {
+		if p := bp.GetPage(pageID); p != nil {
+			return p.PinCount
+		}
+		return 0
+	}
+
+	it := th.Iterator()
+	var prev *TupleWithRID
+	n := 0
+	for {
+		tup, err := it.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if tup == nil {
+			break
+		}
+		n++
+		// Only the page being read is pinned
+		if got := pinned(tup.PageID); got != 1 {
+			t.Fatalf("page %d pin count = %d while iterating it, want 1", tup.PageID, got)
+		}
+		if prev != nil && prev.PageID != tup.PageID && pinned(prev.PageID) != 0 {
+			t.Fatalf("page %d still pinned after moving to page %d", prev.PageID, tup.PageID)
+		}
+		prev = tup
+	}
+	if n != 29 {
+		t.Errorf("iterator returned %d tuples, want 29", n)
+	}
+	for _, pageID := range pages {
+		if got := pinned(pageID); got != 0 {
+			t.Errorf("page %d pin count = %d after the iterator finished, want 0", pageID, got)
+		}
+	}
+
+	// Closing partway releases the current page
+	it = th.Iterator()
+	tup, _ := it.Next()
+	it.Close()
+	if got := pinned(tup.PageID); got != 0 {
+		t.Errorf("page %d pin count = %d after Close, want 0", tup.PageID, got)
+	}
+	if tup, err := it.Next(); tup != nil || err != nil {
+		t.Errorf("Next() after Close = %v, %v; want nil", tup, err)
+	}
+}
+
+func TestTableHeapOverflow(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	bp.SetStrictChecks(true)
+	th, _ := NewTableHeap(bp, 1)
+
+	data := make([]byte, 10*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	small := &types.Tuple{XMin: 1, TableID: 1, RowID: 1, PrevPageID: types.InvalidPageID, Data: []byte("small")}
+	big := &types.Tuple{XMin: 1, TableID: 1, RowID: 2, PrevPageID: types.InvalidPageID, Data: data}
+	th.Insert(small)
+	pageID, slotNum, err := th.Insert(big)
+	if err != nil {
+		t.Fatalf("Insert(10KB) error = %v", err)
+	}
+	if big.Overflow == nil || big.Overflow.Length != uint32(len(data)) {
+		t.Fatalf("Overflow = %+v, want a reference to %d bytes", big.Overflow, len(data))
+	}
+	if len(big.Serialize()) > MaxTupleSize {
+		t.Errorf("stored tuple is %d bytes, want at most %d", len(big.Serialize()), MaxTupleSize)
+	}
+	if small.Overflow != nil {
+		t.Errorf("small tuple has Overflow = %+v, want nil", small.Overflow)
+	}
+
+	got, err := th.Get(pageID, slotNum)
+	if err != nil || !bytes.Equal(got.Data, data) {
+		t.Fatalf("Get() = %d bytes, %v; want the 10KB data intact", len(got.Data), err)
+	}
+	tuples, err := th.Scan()
+	if err != nil || len(tuples) != 2 || !bytes.Equal(tuples[1].Tuple.Data, data) {
+		t.Fatalf("Scan() = %d tuples, %v; want the big one intact", len(tuples), err)
+	}
+	it := th.Iterator()
+	it.Next()
+	if tup, err := it.Next(); err != nil || tup == nil || !bytes.Equal(tup.Tuple.Data, data) {
+		t.Errorf("Iterator().Next() did not return the big tuple intact (%v)", err)
+	}
+	it.Close()
+
+	chain, err := th.OverflowPages()
+	if err != nil || len(chain) != 3 {
+		t.Fatalf("OverflowPages() = %v, %v; want 3 pages", chain, err)
+	}
+	for _, id := range chain {
+		if page, _ := bp.FetchPage(id); page.Type != PageTypeOverflow {
+			t.Errorf("page %d type = %d, want PageTypeOverflow", id, page.Type)
+		}
+		bp.UnpinPage(id, false)
+	}
+
+	// Setting XMax rewrites the stub and keeps the chain
+	got.XMax = 5
+	if err := th.Update(pageID, slotNum, got); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if again, _ := th.OverflowPages(); !reflect.DeepEqual(again, chain) {
+		t.Errorf("OverflowPages() after Update = %v, want %v", again, chain)
+	}
+	if got, err := th.Get(pageID, slotNum); err != nil || got.XMax != 5 || !bytes.Equal(got.Data, data) {
+		t.Errorf("Get() after Update = XMax %d, %v", got.XMax, err)
+	}
+
+	// Delete leaves the chain for the owner to free
+	if err := th.Delete(pageID, slotNum); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if unlinked := th.TakeUnlinked(); !reflect.DeepEqual(unlinked, chain) {
+		t.Errorf("TakeUnlinked() = %v, want %v", unlinked, chain)
+	}
+	if again := th.TakeUnlinked(); len(again) != 0 {
+		t.Errorf("second TakeUnlinked() = %v, want none", again)
+	}
+}
+
 // --- Catalog tests ---
 
 func TestCatalogCreateTable(t *testing.T) {
@@ -261,6 +595,128 @@ func TestCatalogSerializeDeserialize(t *testing.T) {
 	}
 }
 
+func TestCatalogSequencePersistence(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	catalog, _ := NewCatalog(bp)
+
+	schema := &types.Schema{
+		TableName: "users",
+		Columns:   []types.Column{{Name: "id", Type: types.ValueTypeInt, Serial: true}},
+	}
+	tableID, _ := catalog.CreateTable(schema)
+
+	for want := int64(1); want <= 3; want++ {
+		if got := catalog.NextSequenceValue(tableID); got != want {
+			t.Errorf("NextSequenceValue() = %d, want %d", got, want)
+		}
+	}
+
+	catalog2, err := LoadCatalog(bp, catalog.GetCatalogPageID())
+	if err != nil {
+		t.Fatalf("LoadCatalog() error = %v", err)
+	}
+	if !catalog2.GetSchema("users").Columns[0].Serial {
+		t.Error("Serial flag lost after load")
+	}
+	if got := catalog2.NextSequenceValue(tableID); got != 4 {
+		t.Errorf("NextSequenceValue() after load = %d, want 4", got)
+	}
+
+	catalog2.ResetSequence(tableID)
+	if got := catalog2.NextSequenceValue(tableID); got != 1 {
+		t.Errorf("NextSequenceValue() after reset = %d, want 1", got)
+	}
+}
+
+func TestCatalogTempTablesDroppedOnLoad(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	catalog, _ := NewCatalog(bp)
+
+	columns := []types.Column{{Name: "id", Type: types.ValueTypeInt}}
+	catalog.CreateTable(&types.Schema{TableName: "users", Columns: columns})
+	tableID, err := catalog.CreateTempTable(&types.Schema{TableName: "tmp@7", Columns: columns}, 7)
+	if err != nil {
+		t.Fatalf("CreateTempTable() error = %v", err)
+	}
+	if !catalog.IsTemp(tableID) {
+		t.Error("IsTemp() = false for a temp table")
+	}
+	if usersID, _ := catalog.GetTableID("users"); catalog.IsTemp(usersID) {
+		t.Error("IsTemp() = true for a regular table")
+	}
+
+	// As after a crash with the owning transaction still open
+	catalog2, err := LoadCatalog(bp, catalog.GetCatalogPageID())
+	if err != nil {
+		t.Fatalf("LoadCatalog() error = %v", err)
+	}
+	if catalog2.GetSchema("tmp@7") != nil || catalog2.IsTemp(tableID) {
+		t.Error("temp table should be dropped on load")
+	}
+	if catalog2.GetSchema("users") == nil {
+		t.Error("regular table lost on load")
+	}
+}
+
+func TestCatalogColumnConstraintsPersist(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	catalog, _ := NewCatalog(bp)
+
+	def := types.Value{Type: types.ValueTypeString, StrVal: "member"}
+	schema := &types.Schema{
+		TableName: "users",
+		Columns: []types.Column{
+			{Name: "id", Type: types.ValueTypeInt, Unique: true, PrimaryKey: true},
+			{Name: "role", Type: types.ValueTypeString, Nullable: true, Default: &def, Collation: types.CollationNoCase},
+		},
+	}
+	catalog.CreateTable(schema)
+
+	catalog2, err := LoadCatalog(bp, catalog.GetCatalogPageID())
+	if err != nil {
+		t.Fatalf("LoadCatalog() error = %v", err)
+	}
+	cols := catalog2.GetSchema("users").Columns
+	if !cols[0].PrimaryKey || !cols[0].Unique || cols[0].Default != nil {
+		t.Errorf("id = %+v, want PRIMARY KEY without default", cols[0])
+	}
+	if cols[1].Default == nil || !reflect.DeepEqual(*cols[1].Default, def) || !cols[1].Nullable || cols[1].Collation != types.CollationNoCase {
+		t.Errorf("role = %+v, want nullable NOCASE with default 'member'", cols[1])
+	}
+	if cols[0].Collation != types.CollationBinary {
+		t.Errorf("id collation = %v, want BINARY", cols[0].Collation)
+	}
+}
+
+func TestCatalogComments(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	catalog, _ := NewCatalog(bp)
+
+	schema := &types.Schema{TableName: "t", Columns: []types.Column{{Name: "id", Type: types.ValueTypeInt}}}
+	tableID, _ := catalog.CreateTable(schema)
+
+	if err := catalog.SetComment(tableID, "", "a table"); err != nil {
+		t.Fatalf("SetComment() error = %v", err)
+	}
+	if err := catalog.SetComment(tableID, "id", "a column"); err != nil {
+		t.Fatalf("SetComment() error = %v", err)
+	}
+	if err := catalog.SetComment(tableID, "id", strings.Repeat("x", 2000)); err == nil {
+		t.Error("oversized comment should error")
+	}
+
+	catalog2, err := LoadCatalog(bp, catalog.GetCatalogPageID())
+	if err != nil {
+		t.Fatalf("LoadCatalog() error = %v", err)
+	}
+	if text, _ := catalog2.GetComment(tableID, ""); text != "a table" {
+		t.Errorf("table comment = %q, want %q", text, "a table")
+	}
+	if text, _ := catalog2.GetComment(tableID, "id"); text != "a column" {
+		t.Errorf("column comment = %q, want %q", text, "a column")
+	}
+}
+
 func TestCatalogIndexRoot(t *testing.T) {
 	bp, _ := newTestHeapSetup(t)
 	catalog, _ := NewCatalog(bp)
@@ -269,7 +725,7 @@ func TestCatalogIndexRoot(t *testing.T) {
 	tableID, _ := catalog.CreateTable(schema)
 
 	// Initially no index
-	_, ok := catalog.GetIndexRoot(tableID)
+	_, ok := catalog.GetIndexRoot(tableID, "id")
 	if ok {
 		t.Error("expected no index root initially")
 	}
@@ -277,7 +733,7 @@ func TestCatalogIndexRoot(t *testing.T) {
 	// Set index root
 	catalog.SetIndexRoot(tableID, types.PageID(42), "id")
 
-	root, ok := catalog.GetIndexRoot(tableID)
+	root, ok := catalog.GetIndexRoot(tableID, "id")
 	if !ok {
 		t.Fatal("index root not found")
 	}
@@ -286,6 +742,38 @@ func TestCatalogIndexRoot(t *testing.T) {
 	}
 }
 
+func TestCatalogMultipleIndexes(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	catalog, _ := NewCatalog(bp)
+
+	schema := &types.Schema{TableName: "t", Columns: []types.Column{
+		{Name: "id", Type: types.ValueTypeInt},
+		{Name: "email", Type: types.ValueTypeString},
+		{Name: "age", Type: types.ValueTypeInt},
+	}}
+	tableID, _ := catalog.CreateTable(schema)
+	catalog.SetIndexRoot(tableID, types.PageID(42), "id")
+	catalog.SetIndexRoot(tableID, types.PageID(43), "email")
+	catalog.SetIndexRoot(tableID, types.PageID(44), "age")
+	catalog.ClearIndexRoot(tableID, "id")
+
+	catalog2, err := LoadCatalog(bp, catalog.GetCatalogPageID())
+	if err != nil {
+		t.Fatalf("LoadCatalog() error = %v", err)
+	}
+	if got := catalog2.GetIndexColumns(tableID); len(got) != 2 || got[0] != "age" || got[1] != "email" {
+		t.Errorf("GetIndexColumns() after reload = %v, want [age email]", got)
+	}
+	for col, want := range map[string]types.PageID{"email": 43, "age": 44} {
+		if root, ok := catalog2.GetIndexRoot(tableID, col); !ok || root != want {
+			t.Errorf("GetIndexRoot(%s) = %d, %v; want %d", col, root, ok, want)
+		}
+	}
+	if _, ok := catalog2.GetIndexRoot(tableID, "id"); ok {
+		t.Error("cleared index on id is still in the catalog")
+	}
+}
+
 func TestCatalogGetAllTables(t *testing.T) {
 	bp, _ := newTestHeapSetup(t)
 	catalog, _ := NewCatalog(bp)
@@ -316,3 +804,144 @@ func TestTableHeapGetMeta(t *testing.T) {
 		t.Error("FirstPage should be valid")
 	}
 }
+
+func TestFreeSpaceMapFindPage(t *testing.T) {
+	fsm := NewFreeSpaceMap()
+	fsm.Update(1, 0)
+	fsm.Update(2, 3)
+	fsm.Update(3, 15)
+
+	tests := []struct {
+		size int
+		want types.PageID
+		ok   bool
+	}{
+		{100, 2, true},
+		{768, 2, true},
+		{769, 3, true},
+		{FreeSpaceClasses * FreeSpaceClassSize, types.InvalidPageID, false},
+	}
+	for _, tt := range tests {
+		got, ok := fsm.FindPage(tt.size)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("FindPage(%d) = %d, %v, want %d, %v", tt.size, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// Updating an existing page keeps its position in the chain
+	fsm.Update(1, 4)
+	if got, _ := fsm.FindPage(100); got != 1 {
+		t.Errorf("FindPage(100) after update = %d, want 1", got)
+	}
+}
+
+func TestWriteBatchKeepsPagesPinned(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	th, _ := NewTableHeap(bp, 1)
+
+	var slots []uint16
+	for i := 0; i < 30; i++ {
+		tuple := &types.Tuple{XMin: 1, TableID: 1, RowID: uint64(i + 1), Data: []byte("row")}
+		_, slot, err := th.Insert(tuple)
+		if err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+		slots = append(slots, slot)
+	}
+	pageID := th.GetFirstPage()
+
+	hits, misses, _ := bp.Stats()
+	batch := th.NewWriteBatch()
+	for i, slot := range slots {
+		tuple := &types.Tuple{XMin: 1, XMax: 2, TableID: 1, RowID: uint64(i + 1), Data: []byte("row")}
+		if err := batch.Update(pageID, slot, tuple); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if err := batch.SetLSN(pageID, types.LSN(i+1)); err != nil {
+			t.Fatalf("SetLSN() error = %v", err)
+		}
+	}
+	hits2, misses2, _ := bp.Stats()
+	if fetches := hits2 + misses2 - hits - misses; fetches != 1 {
+		t.Errorf("buffer pool fetches = %d, want 1 for a single page", fetches)
+	}
+	if pin := bp.GetPage(pageID).PinCount; pin != 1 {
+		t.Errorf("PinCount during batch = %d, want 1", pin)
+	}
+
+	batch.Release()
+	batch.Release()
+	page := bp.GetPage(pageID)
+	if page.PinCount != 0 || !page.IsDirty {
+		t.Errorf("after Release PinCount = %d, IsDirty = %v; want 0, true", page.PinCount, page.IsDirty)
+	}
+	if page.GetLSN() != 30 {
+		t.Errorf("page LSN = %d, want 30", page.GetLSN())
+	}
+	for _, slot := range slots {
+		got, err := th.Get(pageID, slot)
+		if err != nil || got.XMax != 2 {
+			t.Fatalf("Get(%d) = %v, %v; want XMax 2", slot, got, err)
+		}
+	}
+}
+
+func TestWriteBatchBoundsPinnedPages(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	th, _ := NewTableHeap(bp, 1)
+
+	var pages []types.PageID
+	for len(pages) < maxBatchPages+2 {
+		page, err := bp.NewPage(PageTypeData)
+		if err != nil {
+			t.Fatalf("NewPage() error = %v", err)
+		}
+		bp.UnpinPage(page.ID, true)
+		pages = append(pages, page.ID)
+	}
+
+	batch := th.NewWriteBatch()
+	for i, pageID := range pages {
+		if err := batch.SetLSN(pageID, types.LSN(i+1)); err != nil {
+			t.Fatalf("SetLSN() error = %v", err)
+		}
+	}
+	pinned := 0
+	for _, pageID := range pages {
+		pinned += bp.GetPage(pageID).PinCount
+	}
+	if pinned != maxBatchPages {
+		t.Errorf("pinned pages = %d, want %d", pinned, maxBatchPages)
+	}
+	batch.Release()
+	for _, pageID := range pages {
+		if pin := bp.GetPage(pageID).PinCount; pin != 0 {
+			t.Errorf("page %d PinCount after Release = %d, want 0", pageID, pin)
+		}
+	}
+}
+
+func TestTableHeapStrictChecks(t *testing.T) {
+	bp, _ := newTestHeapSetup(t)
+	bp.SetStrictChecks(true)
+	th, _ := NewTableHeap(bp, 1)
+
+	// Inserts and updates read their tuples back without complaint
+	tuple := &types.Tuple{XMin: 1, TableID: 1, RowID: 1, PrevPageID: types.InvalidPageID, Data: []byte("hello")}
+	pageID, slotNum, err := th.Insert(tuple)
+	if err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	tuple.XMax = 2
+	if err := th.Update(pageID, slotNum, tuple); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	// A tuple that does not match what the slot holds is caught
+	page, _ := bp.FetchPage(pageID)
+	defer bp.UnpinPage(pageID, false)
+	other := *tuple
+	other.Data = []byte("world")
+	expectStrictPanic(t, "read back", func() { checkTupleRoundTrip(page, slotNum, &other) })
+	expectStrictPanic(t, "read back tuple", func() { checkTupleRoundTrip(page, slotNum+1, tuple) })
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"fmt"
+	"minidb/pkg/types"
+)
+
+// MaxTupleSize is the largest serialized tuple a heap page can hold. The
+// heap moves the data of a larger tuple to a chain of overflow pages and
+// stores the tuple with a types.OverflowRef in its place.
+const MaxTupleSize = PageSize - PageHeaderSize - slotSize
+
+// An overflow page holds one chunk of the data in its only slot, so the
+// page layout and its checks are those of a heap page. NextPageID links
+// the chain. Overflow pages are written once, when the tuple is inserted,
+// and are not logged: writeOverflow puts them on disk before the tuple that
+// refers to them can be logged, and they are never changed afterwards.
+
+// writeOverflow stores data in a new chain of overflow pages and returns a
+// reference to it. The chain is built from its end, so that each page is
+// complete, with its successor, when it is written.
+func writeOverflow(bp *BufferPool, data []byte) (*types.OverflowRef, error) {
+	var written []types.PageID
+	next := types.PageID(types.InvalidPageID)
+	for end := len(data); end > 0; {
+		start := (end - 1) / MaxTupleSize * MaxTupleSize
+		page, err := bp.NewPage(PageTypeOverflow)
+		if err != nil {
+			freePages(bp, written)
+			return nil, fmt.Errorf("allocate overflow page: %w", err)
+		}
+		page.SetNextPageID(next)
+		if _, err := page.InsertTuple(data[start:end]); err != nil {
+			bp.UnpinPage(page.ID, true)
+			freePages(bp, append(written, page.ID))
+			return nil, fmt.Errorf("fill overflow page %d: %w", page.ID, err)
+		}
+		bp.UnpinPage(page.ID, true)
+		written = append(written, page.ID)
+		if err := bp.FlushPage(page.ID); err != nil {
+			freePages(bp, written)
+			return nil, fmt.Errorf("write overflow page %d: %w", page.ID, err)
+		}
+		next, end = page.ID, start
+	}
+	return &types.OverflowRef{FirstPage: next, Length: uint32(len(data))}, nil
+}
+
+// readOverflow reads back the data of an overflow chain.
+func readOverflow(bp *BufferPool, ref *types.OverflowRef) ([]byte, error) {
+	data := make([]byte, 0, ref.Length)
+	for pageID := ref.FirstPage; pageID != types.InvalidPageID; {
+		page, err := bp.FetchPage(pageID)
+		if err != nil {
+			return nil, fmt.Errorf("read overflow page %d: %w", pageID, err)
+		}
+		if page.Type != PageTypeOverflow {
+			bp.UnpinPage(pageID, false)
+			return nil, fmt.Errorf("page %d is not an overflow page", pageID)
+		}
+		chunk, err := page.GetTuple(0)
+		next := page.GetNextPageID()
+		bp.UnpinPage(pageID, false)
+		if err != nil {
+			return nil, fmt.Errorf("read overflow page %d: %w", pageID, err)
+		}
+		data = append(data, chunk...)
+		pageID = next
+	}
+	if len(data) != int(ref.Length) {
+		return nil, fmt.Errorf("overflow chain at page %d holds %d bytes, want %d", ref.FirstPage, len(data), ref.Length)
+	}
+	return data, nil
+}
+
+// overflowChain returns the pages of an overflow chain in order.
+func overflowChain(bp *BufferPool, first types.PageID) ([]types.PageID, error) {
+	var pages []types.PageID
+	for pageID := first; pageID != types.InvalidPageID; {
+		page, err := bp.FetchPage(pageID)
+		if err != nil {
+			return nil, err
+		}
+		next := page.GetNextPageID()
+		bp.UnpinPage(pageID, false)
+		pages = append(pages, pageID)
+		pageID = next
+	}
+	return pages, nil
+}
+
+// freePages returns pages to the free list, ignoring failures; it cleans up
+// after an overflow chain that could not be completed.
+func freePages(bp *BufferPool, pages []types.PageID) {
+	for _, pageID := range pages {
+		bp.FreePage(pageID)
+	}
+}
@@ -9,24 +9,28 @@ import (
 
 const (
 	PageSize       = 4096
-	PageHeaderSize = 28
+	PageHeaderSize = 32
 
 	// Page types
-	PageTypeData    = 1
-	PageTypeBTree   = 2
-	PageTypeCatalog = 3
+	PageTypeData     = 1
+	PageTypeBTree    = 2
+	PageTypeCatalog  = 3
+	PageTypeFree     = 4 // On the disk manager's free list
+	PageTypeOverflow = 5 // Part of a tuple's data too large for its heap page
 )
 
 var (
 	ErrPageFull     = errors.New("page is full")
 	ErrSlotNotFound = errors.New("slot not found")
+	ErrPageCorrupt  = errors.New("page checksum mismatch")
+	ErrReadOnly     = errors.New("database is read-only")
 )
 
 // Page represents a fixed-size disk page.
 //
 // Layout (slotted page):
 // +-------------------+
-// | Header (28 bytes) |
+// | Header (32 bytes) |
 // +-------------------+
 // | Slot Array →      |
 // +-------------------+
@@ -40,8 +44,12 @@ var (
 // FreeSpaceOffset = end of slot array, FreeSpaceEnd = start of tuple data.
 //
 // Header format:
-//   PageID (4) + PageType (1) + Reserved (3) + LSN (8) +
-//   SlotCount (2) + FreeSpaceOffset (2) + FreeSpaceEnd (2) + NextPageID (4) + Reserved (2)
+//   PageID (4) + PageType (1) + FreeSpaceClass (1) + Flags (1) + Reserved (1) + LSN (8) +
+//   SlotCount (2) + FreeSpaceOffset (2) + FreeSpaceEnd (2) + NextPageID (4) + LiveTupleCount (2) +
+//   Checksum (4)
+//
+// Flags and Checksum belong to the disk manager: they are filled in as the
+// page is written and checked as it is read (DiskManager.SetChecksums).
 type Page struct {
 	ID         types.PageID
 	Type       uint8
@@ -50,6 +58,7 @@ type Page struct {
 	IsDirty    bool
 	PinCount   int
 	Data       [PageSize]byte
+	strict     bool // Validate the layout after each change (BufferPool.SetStrictChecks)
 }
 
 // NewPage creates a new empty page.
@@ -85,6 +94,8 @@ func (p *Page) init() {
 	// NextPageID = InvalidPageID
 	p.NextPageID = types.InvalidPageID
 	binary.LittleEndian.PutUint32(p.Data[22:26], uint32(types.InvalidPageID))
+
+	p.updateFreeSpaceClass()
 }
 
 // Header accessors
@@ -131,6 +142,40 @@ func (p *Page) SetNextPageID(nextID types.PageID) {
 	p.IsDirty = true
 }
 
+// GetLiveTupleCount returns the number of non-deleted slots on the page.
+func (p *Page) GetLiveTupleCount() uint16 {
+	return binary.LittleEndian.Uint16(p.Data[26:28])
+}
+
+func (p *Page) setLiveTupleCount(count uint16) {
+	binary.LittleEndian.PutUint16(p.Data[26:28], count)
+}
+
+// FreeSpaceClasses is the number of free-space classes. Class c means the
+// page has at least c*FreeSpaceClassSize bytes free for a new tuple.
+const (
+	FreeSpaceClasses   = 16
+	FreeSpaceClassSize = PageSize / FreeSpaceClasses
+)
+
+// GetFreeSpaceClass returns the page's free-space class as of its last
+// mutation, without inspecting the slot array.
+func (p *Page) GetFreeSpaceClass() uint8 {
+	return p.Data[5]
+}
+
+func (p *Page) updateFreeSpaceClass() {
+	p.Data[5] = FreeSpaceClassOf(p.FreeSpace())
+}
+
+// FreeSpaceClassOf maps a free byte count to its free-space class.
+func FreeSpaceClassOf(free int) uint8 {
+	if free <= 0 {
+		return 0
+	}
+	return uint8(min(free/FreeSpaceClassSize, FreeSpaceClasses-1))
+}
+
 // Slot format: Offset (2 bytes) + Length (2 bytes)
 const slotSize = 4
 
@@ -158,14 +203,29 @@ func (p *Page) FreeSpace() int {
 	return freeEnd - freeOffset - slotSize
 }
 
+// reclaimableSpace returns the free space the page would have after Compact.
+func (p *Page) reclaimableSpace() int {
+	used := 0
+	count := p.GetSlotCount()
+	for i := uint16(0); i < count; i++ {
+		_, length := p.getSlot(i)
+		used += int(length)
+	}
+	return PageSize - int(p.GetFreeSpaceOffset()) - used - slotSize
+}
+
 // InsertTuple inserts a tuple into the page.
 // Returns the slot number or error if page is full.
 func (p *Page) InsertTuple(data []byte) (uint16, error) {
 	dataLen := len(data)
 
-	// Check if there's enough space
+	// Check if there's enough space. Deletes compact eagerly, but a page
+	// read from a file written without compaction can still hold holes.
 	if p.FreeSpace() < dataLen {
-		return 0, ErrPageFull
+		if p.reclaimableSpace() < dataLen {
+			return 0, ErrPageFull
+		}
+		p.Compact()
 	}
 
 	// Allocate space from the end (growing backwards)
@@ -184,7 +244,11 @@ func (p *Page) InsertTuple(data []byte) (uint16, error) {
 	// Update FreeSpaceOffset to track end of slot array
 	p.setFreeSpaceOffset(uint16(PageHeaderSize) + (slotNum+1)*uint16(slotSize))
 
+	p.setLiveTupleCount(p.GetLiveTupleCount() + 1)
+	p.updateFreeSpaceClass()
+
 	p.IsDirty = true
+	p.checkStrict("InsertTuple")
 	return slotNum, nil
 }
 
@@ -205,7 +269,8 @@ func (p *Page) GetTuple(slotNum uint16) ([]byte, error) {
 }
 
 // UpdateTuple updates the tuple at the given slot.
-// If new data is larger, returns ErrPageFull.
+// If new data is larger, it is relocated within the page, or ErrPageFull is
+// returned if it does not fit even after compaction.
 func (p *Page) UpdateTuple(slotNum uint16, data []byte) error {
 	if slotNum >= p.GetSlotCount() {
 		return ErrSlotNotFound
@@ -218,17 +283,26 @@ func (p *Page) UpdateTuple(slotNum uint16, data []byte) error {
 		// Fits in existing space
 		copy(p.Data[offset:], data)
 		p.setSlot(slotNum, offset, newLen)
+		if newLen == 0 && oldLen > 0 {
+			p.setLiveTupleCount(p.GetLiveTupleCount() - 1)
+		}
+		if newLen < oldLen {
+			p.Compact()
+		}
 		p.IsDirty = true
+		p.checkStrict("UpdateTuple")
 		return nil
 	}
 
-	// Need to relocate - check free space
-	if p.FreeSpace() < int(newLen) {
+	// Need to relocate - the old bytes are reclaimed by compaction, and the
+	// slot entry already exists
+	if p.FreeSpace()+slotSize+int(oldLen) < int(newLen) {
 		return ErrPageFull
 	}
 
-	// Mark old slot as deleted (length = 0)
+	// Mark old slot as deleted (length = 0) and reclaim its space
 	p.setSlot(slotNum, offset, 0)
+	p.Compact()
 
 	// Allocate new space
 	freeEnd := p.GetFreeSpaceEnd()
@@ -238,24 +312,58 @@ func (p *Page) UpdateTuple(slotNum uint16, data []byte) error {
 	// Copy new data
 	copy(p.Data[newEnd:freeEnd], data)
 
-	// Update slot
+	// Update slot; writing into a deleted slot revives it
 	p.setSlot(slotNum, newEnd, newLen)
+	if oldLen == 0 {
+		p.setLiveTupleCount(p.GetLiveTupleCount() + 1)
+	}
+	p.updateFreeSpaceClass()
 	p.IsDirty = true
+	p.checkStrict("UpdateTuple")
 	return nil
 }
 
-// DeleteTuple marks a tuple as deleted.
+// DeleteTuple marks a tuple as deleted and reclaims its space. The slot
+// entry stays so that the numbers of the other slots do not change.
 func (p *Page) DeleteTuple(slotNum uint16) error {
 	if slotNum >= p.GetSlotCount() {
 		return ErrSlotNotFound
 	}
 
-	offset, _ := p.getSlot(slotNum)
+	offset, length := p.getSlot(slotNum)
 	p.setSlot(slotNum, offset, 0) // Length = 0 means deleted
+	if length > 0 {
+		p.setLiveTupleCount(p.GetLiveTupleCount() - 1)
+		p.Compact()
+	}
 	p.IsDirty = true
+	p.checkStrict("DeleteTuple")
 	return nil
 }
 
+// Compact packs the live tuple data against the end of the page, reclaiming
+// space left by deleted and shrunk tuples. Slot numbers are unchanged.
+func (p *Page) Compact() {
+	var buf [PageSize]byte
+	end := uint16(PageSize)
+
+	count := p.GetSlotCount()
+	for i := uint16(0); i < count; i++ {
+		offset, length := p.getSlot(i)
+		if length == 0 {
+			continue
+		}
+		end -= length
+		copy(buf[end:], p.Data[offset:offset+length])
+		p.setSlot(i, end, length)
+	}
+
+	copy(p.Data[end:], buf[end:])
+	clear(p.Data[p.GetFreeSpaceOffset():end])
+	p.setFreeSpaceEnd(end)
+	p.updateFreeSpaceClass()
+}
+
 // GetAllTuples returns all non-deleted tuples with their slot numbers.
 func (p *Page) GetAllTuples() []struct {
 	SlotNum uint16
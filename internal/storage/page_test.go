@@ -2,6 +2,7 @@ package storage
 
 import (
 	"bytes"
+	"strings"
 	"minidb/pkg/types"
 	"testing"
 )
@@ -196,6 +197,90 @@ func TestDeleteTupleInvalidSlot(t *testing.T) {
 	}
 }
 
+func TestDeleteThenInsertReusesSpace(t *testing.T) {
+	p := NewPage(0, PageTypeData)
+	tuple := bytes.Repeat([]byte{'x'}, 500)
+
+	var slots []uint16
+	for {
+		slot, err := p.InsertTuple(tuple)
+		if err == ErrPageFull {
+			break
+		}
+		if err != nil {
+			t.Fatalf("InsertTuple() error = %v", err)
+		}
+		slots = append(slots, slot)
+	}
+
+	// Delete every other tuple; a tuple twice the size only fits in the
+	// reclaimed space
+	for i := 0; i < len(slots); i += 2 {
+		if err := p.DeleteTuple(slots[i]); err != nil {
+			t.Fatalf("DeleteTuple(%d) error = %v", slots[i], err)
+		}
+	}
+	big := bytes.Repeat([]byte{'y'}, 1000)
+	slot, err := p.InsertTuple(big)
+	if err != nil {
+		t.Fatalf("InsertTuple() after deletes error = %v", err)
+	}
+
+	got, err := p.GetTuple(slot)
+	if err != nil || !bytes.Equal(got, big) {
+		t.Errorf("GetTuple(%d) = %d bytes, %v; want the new tuple", slot, len(got), err)
+	}
+	for i := 1; i < len(slots); i += 2 {
+		got, err := p.GetTuple(slots[i])
+		if err != nil || !bytes.Equal(got, tuple) {
+			t.Errorf("GetTuple(%d) = %d bytes, %v; want the original tuple", slots[i], len(got), err)
+		}
+	}
+}
+
+func TestInsertTupleCompactsHoles(t *testing.T) {
+	p := NewPage(0, PageTypeData)
+	a, _ := p.InsertTuple(bytes.Repeat([]byte{'a'}, 1500))
+	b, _ := p.InsertTuple(bytes.Repeat([]byte{'b'}, 1500))
+	c, _ := p.InsertTuple(bytes.Repeat([]byte{'c'}, 1000))
+
+	// Zero b's length without compacting, as in a page written before
+	// deletes reclaimed their space
+	offset, _ := p.getSlot(b)
+	p.setSlot(b, offset, 0)
+	p.setLiveTupleCount(p.GetLiveTupleCount() - 1)
+
+	data := bytes.Repeat([]byte{'d'}, 1200)
+	if p.FreeSpace() >= len(data) {
+		t.Fatalf("FreeSpace() = %d, test tuple must not fit before compaction", p.FreeSpace())
+	}
+	d, err := p.InsertTuple(data)
+	if err != nil {
+		t.Fatalf("InsertTuple() error = %v", err)
+	}
+
+	for slot, want := range map[uint16][]byte{
+		a: bytes.Repeat([]byte{'a'}, 1500),
+		c: bytes.Repeat([]byte{'c'}, 1000),
+		d: data,
+	} {
+		got, err := p.GetTuple(slot)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("GetTuple(%d) = %d bytes, %v; want %d bytes of %q", slot, len(got), err, len(want), want[0])
+		}
+	}
+	if _, err := p.GetTuple(b); err != ErrSlotNotFound {
+		t.Errorf("GetTuple(%d) error = %v, want ErrSlotNotFound", b, err)
+	}
+	if p.GetLiveTupleCount() != 3 {
+		t.Errorf("LiveTupleCount = %d, want 3", p.GetLiveTupleCount())
+	}
+
+	if _, err := p.InsertTuple(make([]byte, PageSize)); err != ErrPageFull {
+		t.Errorf("oversized insert error = %v, want ErrPageFull", err)
+	}
+}
+
 func TestGetAllTuples(t *testing.T) {
 	p := NewPage(0, PageTypeData)
 	p.InsertTuple([]byte("a"))
@@ -293,3 +378,120 @@ func TestFreeSpace(t *testing.T) {
 		t.Errorf("FreeSpace should decrease after insert: before=%d, after=%d", initialFree, afterInsert)
 	}
 }
+
+func TestLiveTupleCountThroughEdits(t *testing.T) {
+	p := NewPage(0, PageTypeData)
+	if p.GetLiveTupleCount() != 0 {
+		t.Fatalf("initial LiveTupleCount = %d, want 0", p.GetLiveTupleCount())
+	}
+
+	for i := 0; i < 5; i++ {
+		p.InsertTuple([]byte("tuple"))
+	}
+	if p.GetLiveTupleCount() != 5 {
+		t.Errorf("after inserts LiveTupleCount = %d, want 5", p.GetLiveTupleCount())
+	}
+
+	// Same-size and relocating updates keep the count
+	p.UpdateTuple(0, []byte("TUPLE"))
+	p.UpdateTuple(1, []byte("a much longer tuple"))
+	if p.GetLiveTupleCount() != 5 {
+		t.Errorf("after updates LiveTupleCount = %d, want 5", p.GetLiveTupleCount())
+	}
+
+	// Deleting twice only counts once
+	p.DeleteTuple(2)
+	p.DeleteTuple(2)
+	if p.GetLiveTupleCount() != 4 {
+		t.Errorf("after delete LiveTupleCount = %d, want 4", p.GetLiveTupleCount())
+	}
+
+	// Rewriting a deleted slot (as redo does) revives it
+	p.UpdateTuple(2, []byte("revived"))
+	if p.GetLiveTupleCount() != 5 {
+		t.Errorf("after revive LiveTupleCount = %d, want 5", p.GetLiveTupleCount())
+	}
+
+	// The count survives a serialize round trip
+	p2 := &Page{}
+	p2.Deserialize(p.Serialize())
+	if p2.GetLiveTupleCount() != 5 {
+		t.Errorf("after round trip LiveTupleCount = %d, want 5", p2.GetLiveTupleCount())
+	}
+}
+
+func TestFreeSpaceClass(t *testing.T) {
+	p := NewPage(0, PageTypeData)
+	if got, want := p.GetFreeSpaceClass(), FreeSpaceClassOf(p.FreeSpace()); got != want || got != FreeSpaceClasses-1 {
+		t.Errorf("empty page class = %d, want %d", got, FreeSpaceClasses-1)
+	}
+
+	// Fill the page; the class must track FreeSpace after every insert
+	data := make([]byte, 200)
+	for {
+		if _, err := p.InsertTuple(data); err != nil {
+			break
+		}
+		if got, want := p.GetFreeSpaceClass(), FreeSpaceClassOf(p.FreeSpace()); got != want {
+			t.Fatalf("class = %d, want %d (free=%d)", got, want, p.FreeSpace())
+		}
+	}
+	if p.GetFreeSpaceClass() != 0 {
+		t.Errorf("full page class = %d, want 0", p.GetFreeSpaceClass())
+	}
+}
+
+// expectStrictPanic runs fn and fails unless it panics with a strict check
+// violation mentioning want.
+func expectStrictPanic(t *testing.T, want string, fn func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		msg, _ := r.(string)
+		if !strings.HasPrefix(msg, "strict check:") || !strings.Contains(msg, want) {
+			t.Errorf("panic = %v, want a strict check violation mentioning %q", r, want)
+		}
+	}()
+	fn()
+}
+
+func TestPageStrictChecks(t *testing.T) {
+	p := NewPage(0, PageTypeData)
+	p.strict = true
+
+	// Well-formed edits pass
+	for i := 0; i < 5; i++ {
+		p.InsertTuple([]byte("tuple"))
+	}
+	p.UpdateTuple(0, []byte("a much longer tuple"))
+	p.UpdateTuple(1, []byte("tup"))
+	p.DeleteTuple(2)
+	p.UpdateTuple(2, []byte("revived"))
+	if err := p.CheckInvariants(); err != nil {
+		t.Fatalf("CheckInvariants() error = %v", err)
+	}
+
+	// Slot 1 pointing into slot 0's data
+	off, _ := p.getSlot(0)
+	p.setSlot(1, off+2, 3)
+	expectStrictPanic(t, "overlaps", func() { p.UpdateTuple(3, []byte("TUPLE")) })
+
+	// A live tuple count that does not match the slots
+	p = NewPage(1, PageTypeData)
+	p.strict = true
+	p.InsertTuple([]byte("tuple"))
+	p.setLiveTupleCount(3)
+	expectStrictPanic(t, "LiveTupleCount", func() { p.InsertTuple([]byte("tuple")) })
+
+	// Tuple data below FreeSpaceEnd
+	p = NewPage(2, PageTypeData)
+	p.strict = true
+	p.InsertTuple([]byte("tuple"))
+	p.setFreeSpaceEnd(PageSize)
+	expectStrictPanic(t, "outside", func() { p.UpdateTuple(0, []byte("TUPLE")) })
+
+	// Without strict checks the same page goes unnoticed
+	p.strict = false
+	p.UpdateTuple(0, []byte("tuple"))
+}
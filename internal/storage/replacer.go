@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"container/list"
+	"fmt"
+	"minidb/pkg/types"
+)
+
+// Eviction policies accepted by SetEvictionPolicy.
+const (
+	EvictionLRU   = "lru"   // Least recently used first (the default)
+	EvictionClock = "clock" // Second chance: a page referenced since the last sweep is skipped once
+)
+
+// replacer tracks the pages of a buffer pool shard and picks the ones to
+// evict. The shard calls it with its lock held.
+type replacer interface {
+	// add records a page brought into the shard.
+	add(pageID types.PageID)
+	// touch records an access to a cached page.
+	touch(pageID types.PageID)
+	// remove forgets a page that left the shard.
+	remove(pageID types.PageID)
+	// victims returns up to count pages for which evictable is true, in
+	// the order the policy would evict them. It does not remove them.
+	victims(count int, evictable func(types.PageID) bool) []types.PageID
+}
+
+// newReplacer returns the replacer for policy ("" means EvictionLRU) of a
+// shard holding up to capacity pages.
+func newReplacer(policy string, capacity int) (replacer, error) {
+	switch policy {
+	case "", EvictionLRU:
+		return newLRUReplacer(), nil
+	case EvictionClock:
+		return newClockReplacer(capacity), nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy %q (want %q or %q)", policy, EvictionLRU, EvictionClock)
+}
+
+// lruReplacer evicts the least recently used page. The list is kept in
+// access order, most recent first, and lruMap finds a page's element so
+// that every update is O(1).
+type lruReplacer struct {
+	lruList *list.List
+	lruMap  map[types.PageID]*list.Element
+}
+
+func newLRUReplacer() *lruReplacer {
+	return &lruReplacer{
+		lruList: list.New(),
+		lruMap:  make(map[types.PageID]*list.Element),
+	}
+}
+
+func (r *lruReplacer) add(pageID types.PageID) {
+	r.lruMap[pageID] = r.lruList.PushFront(pageID)
+}
+
+func (r *lruReplacer) touch(pageID types.PageID) {
+	if e, ok := r.lruMap[pageID]; ok {
+		r.lruList.MoveToFront(e)
+	}
+}
+
+func (r *lruReplacer) remove(pageID types.PageID) {
+	if e, ok := r.lruMap[pageID]; ok {
+		r.lruList.Remove(e)
+		delete(r.lruMap, pageID)
+	}
+}
+
+// victims walks the list from its least recently used end.
+func (r *lruReplacer) victims(count int, evictable func(types.PageID) bool) []types.PageID {
+	var victims []types.PageID
+	for e := r.lruList.Back(); e != nil && len(victims) < count; e = e.Prev() {
+		if pageID := e.Value.(types.PageID); evictable(pageID) {
+			victims = append(victims, pageID)
+		}
+	}
+	return victims
+}
+
+// clockFrame is a slot of a clockReplacer's circular buffer.
+type clockFrame struct {
+	pageID     types.PageID
+	used       bool // Holds a page
+	referenced bool // Accessed since the hand last passed
+}
+
+// clockReplacer approximates LRU with one reference bit per page. An
+// access only sets the bit. To find a victim the hand sweeps the frames:
+// a referenced page has its bit cleared and is passed over, and the first
+// unreferenced one is taken. Pages the caller cannot evict are skipped
+// without touching their bit. A page gets its bit on its first hit, not
+// when it is added, so a page read once and never again goes first.
+type clockReplacer struct {
+	frames []clockFrame
+	frame  map[types.PageID]int // Page -> index in frames
+	free   []int                // Unused frames
+	hand   int
+}
+
+func newClockReplacer(capacity int) *clockReplacer {
+	r := &clockReplacer{
+		frames: make([]clockFrame, capacity),
+		frame:  make(map[types.PageID]int, capacity),
+		free:   make([]int, 0, capacity),
+	}
+	for i := capacity - 1; i >= 0; i-- {
+		r.free = append(r.free, i)
+	}
+	return r
+}
+
+func (r *clockReplacer) add(pageID types.PageID) {
+	if len(r.free) == 0 {
+		// The shard evicts before it adds, so this only happens if the
+		// pool was over capacity when the policy was set
+		r.frames = append(r.frames, clockFrame{})
+		r.free = append(r.free, len(r.frames)-1)
+	}
+	i := r.free[len(r.free)-1]
+	r.free = r.free[:len(r.free)-1]
+	r.frames[i] = clockFrame{pageID: pageID, used: true}
+	r.frame[pageID] = i
+}
+
+func (r *clockReplacer) touch(pageID types.PageID) {
+	if i, ok := r.frame[pageID]; ok {
+		r.frames[i].referenced = true
+	}
+}
+
+func (r *clockReplacer) remove(pageID types.PageID) {
+	if i, ok := r.frame[pageID]; ok {
+		r.frames[i] = clockFrame{}
+		r.free = append(r.free, i)
+		delete(r.frame, pageID)
+	}
+}
+
+// victims sweeps at most twice around the clock: once to clear reference
+// bits, once more to take the pages that had them.
+func (r *clockReplacer) victims(count int, evictable func(types.PageID) bool) []types.PageID {
+	var victims []types.PageID
+	taken := make(map[int]bool)
+	for step := 0; step < 2*len(r.frames) && len(victims) < count; step++ {
+		i := r.hand
+		r.hand = (r.hand + 1) % len(r.frames)
+
+		f := &r.frames[i]
+		if !f.used || taken[i] || !evictable(f.pageID) {
+			continue
+		}
+		if f.referenced {
+			f.referenced = false
+			continue
+		}
+		victims = append(victims, f.pageID)
+		taken[i] = true
+	}
+	return victims
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"fmt"
+	"minidb/pkg/types"
+	"sort"
+)
+
+// Strict checks validate the storage layout after every change made through
+// a BufferPool that has them enabled (SetStrictChecks). A violation panics
+// with the broken invariant, so that a bug is caught where it happens rather
+// than later as corruption. They walk the whole slot array on every change
+// and are meant for development and tests.
+
+// CheckInvariants verifies the slotted page layout: the slot array ends at
+// FreeSpaceOffset, tuple data lies between FreeSpaceEnd and the page end
+// without overlapping, and the live tuple count and free-space class match
+// the slots.
+func (p *Page) CheckInvariants() error {
+	count := p.GetSlotCount()
+	freeOffset := int(p.GetFreeSpaceOffset())
+	freeEnd := int(p.GetFreeSpaceEnd())
+
+	if want := PageHeaderSize + int(count)*slotSize; freeOffset != want {
+		return fmt.Errorf("FreeSpaceOffset = %d, want %d for %d slots", freeOffset, want, count)
+	}
+	if freeEnd < freeOffset || freeEnd > PageSize {
+		return fmt.Errorf("FreeSpaceEnd = %d outside [%d, %d]", freeEnd, freeOffset, PageSize)
+	}
+
+	type extent struct {
+		slot          uint16
+		offset, limit int
+	}
+	var live []extent
+	for i := uint16(0); i < count; i++ {
+		offset, length := p.getSlot(i)
+		if length == 0 {
+			continue
+		}
+		e := extent{i, int(offset), int(offset) + int(length)}
+		if e.offset < freeEnd || e.limit > PageSize {
+			return fmt.Errorf("slot %d data [%d, %d) outside [%d, %d)", i, e.offset, e.limit, freeEnd, PageSize)
+		}
+		live = append(live, e)
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].offset < live[j].offset })
+	for i := 1; i < len(live); i++ {
+		if live[i].offset < live[i-1].limit {
+			return fmt.Errorf("slot %d data [%d, %d) overlaps slot %d data [%d, %d)",
+				live[i].slot, live[i].offset, live[i].limit, live[i-1].slot, live[i-1].offset, live[i-1].limit)
+		}
+	}
+
+	if got := p.GetLiveTupleCount(); int(got) != len(live) {
+		return fmt.Errorf("LiveTupleCount = %d, but %d slots are live", got, len(live))
+	}
+	if got, want := p.GetFreeSpaceClass(), FreeSpaceClassOf(p.FreeSpace()); got != want {
+		return fmt.Errorf("free-space class = %d, want %d for %d free bytes", got, want, p.FreeSpace())
+	}
+	return nil
+}
+
+// checkStrict panics if the page has strict checks on and op left it
+// malformed.
+func (p *Page) checkStrict(op string) {
+	if !p.strict {
+		return
+	}
+	if err := p.CheckInvariants(); err != nil {
+		panic(fmt.Sprintf("strict check: page %d after %s: %v", p.ID, op, err))
+	}
+}
+
+// checkTupleRoundTrip panics if the page has strict checks on and the bytes
+// stored at slotNum do not deserialize back to tuple.
+func checkTupleRoundTrip(page *Page, slotNum uint16, tuple *types.Tuple) {
+	if !page.strict {
+		return
+	}
+	data, err := page.GetTuple(slotNum)
+	if err != nil {
+		panic(fmt.Sprintf("strict check: page %d slot %d: read back tuple: %v", page.ID, slotNum, err))
+	}
+	got, err := types.DeserializeTuple(data)
+	if err != nil {
+		panic(fmt.Sprintf("strict check: page %d slot %d: deserialize tuple: %v", page.ID, slotNum, err))
+	}
+	if got.XMin != tuple.XMin || got.XMax != tuple.XMax || got.Cid != tuple.Cid ||
+		got.TableID != tuple.TableID || got.RowID != tuple.RowID ||
+		got.PrevPageID != tuple.PrevPageID || got.PrevSlotNum != tuple.PrevSlotNum ||
+		!storedDataEqual(got, tuple) {
+		panic(fmt.Sprintf("strict check: page %d slot %d: tuple read back as %+v, wrote %+v", page.ID, slotNum, got, tuple))
+	}
+}
+
+// storedDataEqual reports whether a tuple read back from a page holds the
+// data of the tuple written: the same bytes, or the same overflow chain.
+func storedDataEqual(got, want *types.Tuple) bool {
+	if want.Overflow != nil {
+		return got.Overflow != nil && *got.Overflow == *want.Overflow
+	}
+	return got.Overflow == nil && string(got.Data) == string(want.Data)
+}
@@ -0,0 +1,250 @@
+package txn
+
+import (
+	"errors"
+	"fmt"
+	"minidb/pkg/types"
+	"sync"
+)
+
+// ErrDeadlock is returned by LockManager.Lock to the transaction chosen to
+// break a deadlock. The caller must roll the transaction back, which
+// releases its locks and lets the others go on.
+var ErrDeadlock = errors.New("deadlock detected")
+
+// RowKey identifies a row for locking.
+type RowKey struct {
+	TableID uint32
+	RowID   uint64
+}
+
+// lockRequest is a transaction's place in the queue of a row.
+type lockRequest struct {
+	txn     *Transaction
+	mode    LockMode
+	granted bool
+	victim  bool // Chosen to break a deadlock; the waiting Lock call fails
+}
+
+// LockManager hands out shared and exclusive row locks. Each locked row has
+// a queue of requests: the granted ones first, then the waiting ones in
+// arrival order. A request is granted when it is compatible with every
+// lock other transactions hold on the row and no earlier waiter conflicts
+// with it, so that a stream of shared locks cannot starve an exclusive one.
+//
+// A transaction about to wait checks the wait-for graph for a cycle through
+// itself. If there is one, the youngest transaction in it (the highest ID)
+// is the victim: its Lock call returns ErrDeadlock.
+type LockManager struct {
+	mu      sync.Mutex
+	changed *sync.Cond // Broadcast whenever a request is granted, released or made a victim
+	queues  map[RowKey][]*lockRequest
+	waiting map[types.TxnID]RowKey // The row each blocked transaction waits for
+}
+
+// NewLockManager creates a lock manager with no locks held.
+func NewLockManager() *LockManager {
+	lm := &LockManager{
+		queues:  make(map[RowKey][]*lockRequest),
+		waiting: make(map[types.TxnID]RowKey),
+	}
+	lm.changed = sync.NewCond(&lm.mu)
+	return lm
+}
+
+// conflicts reports whether two lock modes cannot be held at once.
+func conflicts(a, b LockMode) bool {
+	return a == LockExclusive || b == LockExclusive
+}
+
+// Lock takes a lock on a row for txn, waiting while other transactions hold
+// conflicting ones. A lock already held in the same or a stronger mode is
+// kept; a shared lock is upgraded to an exclusive one once txn is its only
+// holder. Locks are held until ReleaseAll.
+func (lm *LockManager) Lock(txn *Transaction, key RowKey, mode LockMode) error {
+	return lm.LockReleasing(txn, key, mode, nil)
+}
+
+// LockReleasing is Lock for a caller that holds held, a lock serializing
+// statements, while it asks for a row: if it has to wait, held is
+// unlocked for the wait and locked again before LockReleasing returns, so
+// that the transaction holding the row can go on and finish. held may be
+// nil.
+func (lm *LockManager) LockReleasing(txn *Transaction, key RowKey, mode LockMode, held sync.Locker) error {
+	released := false
+	defer func() {
+		// Runs after lm.mu is unlocked, so that the two are never
+		// acquired in the opposite order
+		if released {
+			held.Lock()
+		}
+	}()
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if held, ok := txn.HeldLocks[key]; ok && (held == mode || held == LockExclusive) {
+		return nil
+	}
+
+	req := &lockRequest{txn: txn, mode: mode}
+	lm.queues[key] = append(lm.queues[key], req)
+	for !lm.grantable(key, req) {
+		lm.waiting[txn.ID] = key
+		if victim := lm.deadlockVictim(txn.ID); victim != nil {
+			victim.victim = true
+			lm.changed.Broadcast()
+		}
+		if req.victim {
+			delete(lm.waiting, txn.ID)
+			lm.remove(key, req)
+			lm.changed.Broadcast()
+			return fmt.Errorf("%w: transaction %d aborted waiting for row %d of table %d", ErrDeadlock, txn.ID, key.RowID, key.TableID)
+		}
+		if held != nil && !released {
+			held.Unlock()
+			released = true
+		}
+		lm.changed.Wait()
+	}
+	delete(lm.waiting, txn.ID)
+
+	// An upgrade replaces the shared request txn already had
+	for _, r := range lm.queues[key] {
+		if r != req && r.txn == txn {
+			lm.remove(key, r)
+			break
+		}
+	}
+	req.granted = true
+	if txn.HeldLocks == nil {
+		txn.HeldLocks = make(map[RowKey]LockMode)
+	}
+	txn.HeldLocks[key] = mode
+	return nil
+}
+
+// grantable reports whether req can be granted now.
+func (lm *LockManager) grantable(key RowKey, req *lockRequest) bool {
+	_, upgrade := req.txn.HeldLocks[key]
+	for _, r := range lm.queues[key] {
+		if r == req {
+			return true
+		}
+		if r.txn == req.txn || !conflicts(r.mode, req.mode) {
+			continue
+		}
+		// An upgrade only waits for the other holders, not for the
+		// requests queued behind its own shared lock
+		if r.granted || !upgrade {
+			return false
+		}
+	}
+	return true
+}
+
+// blockers returns the transactions a waiting request is queued behind.
+func (lm *LockManager) blockers(key RowKey, req *lockRequest) []*Transaction {
+	_, upgrade := req.txn.HeldLocks[key]
+	var out []*Transaction
+	for _, r := range lm.queues[key] {
+		if r == req {
+			if upgrade {
+				continue
+			}
+			break
+		}
+		if r.txn != req.txn && conflicts(r.mode, req.mode) && (r.granted || !upgrade) {
+			out = append(out, r.txn)
+		}
+	}
+	return out
+}
+
+// pending returns the request a transaction is waiting on, or nil.
+func (lm *LockManager) pending(txnID types.TxnID) (RowKey, *lockRequest) {
+	key, ok := lm.waiting[txnID]
+	if !ok {
+		return key, nil
+	}
+	for _, r := range lm.queues[key] {
+		if r.txn.ID == txnID && !r.granted && !r.victim {
+			return key, r
+		}
+	}
+	return key, nil
+}
+
+// deadlockVictim looks for a cycle in the wait-for graph through start and
+// returns the waiting request of its youngest transaction, or nil if there
+// is no cycle.
+func (lm *LockManager) deadlockVictim(start types.TxnID) *lockRequest {
+	visited := make(map[types.TxnID]bool)
+	var path []types.TxnID
+	var visit func(id types.TxnID) bool
+	visit = func(id types.TxnID) bool {
+		key, req := lm.pending(id)
+		if req == nil {
+			return false
+		}
+		path = append(path, id)
+		for _, blocker := range lm.blockers(key, req) {
+			if blocker.ID == start {
+				return true
+			}
+			if !visited[blocker.ID] {
+				visited[blocker.ID] = true
+				if visit(blocker.ID) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if !visit(start) {
+		return nil
+	}
+
+	youngest := path[0]
+	for _, id := range path[1:] {
+		if id > youngest {
+			youngest = id
+		}
+	}
+	_, req := lm.pending(youngest)
+	return req
+}
+
+// remove drops a request from a row's queue.
+func (lm *LockManager) remove(key RowKey, req *lockRequest) {
+	queue := lm.queues[key]
+	for i, r := range queue {
+		if r == req {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(lm.queues, key)
+	} else {
+		lm.queues[key] = queue
+	}
+}
+
+// ReleaseAll releases every lock txn holds and wakes the transactions
+// waiting for them.
+func (lm *LockManager) ReleaseAll(txn *Transaction) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for key := range txn.HeldLocks {
+		for _, r := range lm.queues[key] {
+			if r.txn == txn {
+				lm.remove(key, r)
+				break
+			}
+		}
+	}
+	txn.HeldLocks = nil
+	lm.changed.Broadcast()
+}
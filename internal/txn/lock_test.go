@@ -0,0 +1,175 @@
+package txn
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockSharedCompatible(t *testing.T) {
+	m := newTestManager(t)
+	txn1 := m.Begin()
+	txn2 := m.Begin()
+	key := RowKey{TableID: 1, RowID: 1}
+
+	if err := m.LockRow(txn1, key, LockShared); err != nil {
+		t.Fatalf("LockRow(txn1) error = %v", err)
+	}
+	if err := m.LockRow(txn2, key, LockShared); err != nil {
+		t.Fatalf("LockRow(txn2) error = %v", err)
+	}
+	if txn1.HeldLocks[key] != LockShared || txn2.HeldLocks[key] != LockShared {
+		t.Errorf("HeldLocks = %v, %v, want both shared", txn1.HeldLocks, txn2.HeldLocks)
+	}
+}
+
+func TestLockExclusiveWaits(t *testing.T) {
+	m := newTestManager(t)
+	holder := m.Begin()
+	waiter := m.Begin()
+	key := RowKey{TableID: 1, RowID: 1}
+
+	if err := m.LockRow(holder, key, LockExclusive); err != nil {
+		t.Fatalf("LockRow(holder) error = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- m.LockRow(waiter, key, LockExclusive) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("LockRow(waiter) returned %v while the row was locked", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := m.Commit(holder); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("LockRow(waiter) error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("LockRow(waiter) still blocked after the holder committed")
+	}
+	if len(holder.HeldLocks) != 0 {
+		t.Errorf("holder.HeldLocks = %v after commit, want none", holder.HeldLocks)
+	}
+}
+
+func TestLockUpgrade(t *testing.T) {
+	m := newTestManager(t)
+	txn1 := m.Begin()
+	key := RowKey{TableID: 1, RowID: 1}
+
+	if err := m.LockRow(txn1, key, LockShared); err != nil {
+		t.Fatalf("LockRow(shared) error = %v", err)
+	}
+	if err := m.LockRow(txn1, key, LockExclusive); err != nil {
+		t.Fatalf("LockRow(exclusive) error = %v", err)
+	}
+	if txn1.HeldLocks[key] != LockExclusive {
+		t.Errorf("HeldLocks[key] = %v, want exclusive", txn1.HeldLocks[key])
+	}
+	if n := len(m.locks.queues[key]); n != 1 {
+		t.Errorf("queue length = %d after upgrade, want 1", n)
+	}
+}
+
+func TestLockDeadlock(t *testing.T) {
+	m := newTestManager(t)
+	older := m.Begin()
+	younger := m.Begin()
+	rowA := RowKey{TableID: 1, RowID: 1}
+	rowB := RowKey{TableID: 1, RowID: 2}
+
+	if err := m.LockRow(older, rowA, LockExclusive); err != nil {
+		t.Fatalf("LockRow(older, A) error = %v", err)
+	}
+	if err := m.LockRow(younger, rowB, LockExclusive); err != nil {
+		t.Fatalf("LockRow(younger, B) error = %v", err)
+	}
+
+	// older waits for B, then younger asks for A and closes the cycle
+	olderDone := make(chan error, 1)
+	go func() { olderDone <- m.LockRow(older, rowB, LockExclusive) }()
+	time.Sleep(20 * time.Millisecond)
+
+	youngerDone := make(chan error, 1)
+	go func() { youngerDone <- m.LockRow(younger, rowA, LockExclusive) }()
+
+	select {
+	case err := <-youngerDone:
+		if !errors.Is(err, ErrDeadlock) {
+			t.Fatalf("LockRow(younger, A) error = %v, want ErrDeadlock", err)
+		}
+	case err := <-olderDone:
+		t.Fatalf("older transaction returned %v before the victim was chosen", err)
+	case <-time.After(time.Second):
+		t.Fatal("deadlock was not detected")
+	}
+
+	if err := m.Rollback(younger); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	select {
+	case err := <-olderDone:
+		if err != nil {
+			t.Fatalf("LockRow(older, B) error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("older transaction still blocked after the victim rolled back")
+	}
+	if older.HeldLocks[rowA] != LockExclusive || older.HeldLocks[rowB] != LockExclusive {
+		t.Errorf("older.HeldLocks = %v, want A and B exclusive", older.HeldLocks)
+	}
+}
+
+func TestLockReleasingUnlocksWhileWaiting(t *testing.T) {
+	m := newTestManager(t)
+	holder := m.Begin()
+	waiter := m.Begin()
+	key := RowKey{TableID: 1, RowID: 1}
+	if err := m.LockRow(holder, key, LockExclusive); err != nil {
+		t.Fatalf("LockRow(holder) error = %v", err)
+	}
+
+	var stmt sync.Mutex
+	stmt.Lock()
+	done := make(chan error, 1)
+	go func() { done <- m.LockRowReleasing(waiter, key, LockExclusive, &stmt) }()
+
+	// The waiter gives up stmt, so the holder can take it to commit
+	locked := make(chan struct{})
+	go func() {
+		stmt.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("statement lock still held by the waiting transaction")
+	}
+	if err := m.Commit(holder); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	// The waiter needs stmt back before it returns
+	select {
+	case err := <-done:
+		t.Fatalf("LockRowReleasing returned %v without the statement lock", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	stmt.Unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("LockRowReleasing() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("LockRowReleasing still blocked")
+	}
+	stmt.Unlock() // Held again by the waiter
+}
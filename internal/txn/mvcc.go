@@ -14,6 +14,9 @@ type Snapshot struct {
 	
 	// Transactions that were active when snapshot was taken
 	ActiveTxns map[types.TxnID]bool
+
+	// Manager commit sequence number when the snapshot was taken
+	CommitSeq uint64
 }
 
 // IsVisible determines if a tuple version is visible to this snapshot.
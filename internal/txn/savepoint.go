@@ -0,0 +1,130 @@
+package txn
+
+import (
+	"fmt"
+	"minidb/pkg/types"
+)
+
+// UndoKind is the kind of write an UndoRecord reverts.
+type UndoKind uint8
+
+const (
+	UndoInsert UndoKind = iota // New version at (PageID, SlotNum)
+	UndoUpdate                 // New version at (PageID, SlotNum) replacing the one at (OldPageID, OldSlotNum)
+	UndoDelete                 // Version at (PageID, SlotNum) marked deleted
+)
+
+// UndoRecord describes a write that ROLLBACK or ROLLBACK TO SAVEPOINT may
+// revert.
+type UndoRecord struct {
+	Kind       UndoKind
+	TableID    uint32
+	PageID     types.PageID
+	SlotNum    uint16
+	OldPageID  types.PageID
+	OldSlotNum uint16
+	LSN        types.LSN // WAL record of the write, InvalidLSN if it was not logged
+}
+
+// Savepoint is a point within a transaction that it can roll back to.
+type Savepoint struct {
+	Name      string
+	CommandID types.CommandID // Last command run before the savepoint
+	LSN       types.LSN       // Transaction's last WAL record before the savepoint
+	undoLen   int             // Writes recorded before the savepoint
+}
+
+// SetSavepoint starts a savepoint after the transaction's current command.
+// lsn is the transaction's last WAL record. A name already in use is
+// shadowed until the new savepoint is released or rolled past.
+func (txn *Transaction) SetSavepoint(name string, lsn types.LSN) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	txn.savepoints = append(txn.savepoints, Savepoint{
+		Name:      name,
+		CommandID: txn.CommandID,
+		LSN:       lsn,
+		undoLen:   len(txn.undo),
+	})
+}
+
+// RecordInsert notes a new version written at (pageID, slotNum), logged
+// at lsn, for a later rollback.
+func (txn *Transaction) RecordInsert(tableID uint32, pageID types.PageID, slotNum uint16, lsn types.LSN) {
+	txn.recordUndo(UndoRecord{Kind: UndoInsert, TableID: tableID, PageID: pageID, SlotNum: slotNum, LSN: lsn})
+}
+
+// RecordUpdate notes a new version written at (pageID, slotNum) in place
+// of the one at (oldPageID, oldSlotNum), logged at lsn.
+func (txn *Transaction) RecordUpdate(tableID uint32, oldPageID types.PageID, oldSlotNum uint16, pageID types.PageID, slotNum uint16, lsn types.LSN) {
+	txn.recordUndo(UndoRecord{Kind: UndoUpdate, TableID: tableID, PageID: pageID, SlotNum: slotNum,
+		OldPageID: oldPageID, OldSlotNum: oldSlotNum, LSN: lsn})
+}
+
+// RecordDelete notes the version at (pageID, slotNum) marked deleted,
+// logged at lsn.
+func (txn *Transaction) RecordDelete(tableID uint32, pageID types.PageID, slotNum uint16, lsn types.LSN) {
+	txn.recordUndo(UndoRecord{Kind: UndoDelete, TableID: tableID, PageID: pageID, SlotNum: slotNum, LSN: lsn})
+}
+
+// recordUndo keeps a write until the transaction ends, since ROLLBACK
+// reverts all of them.
+func (txn *Transaction) recordUndo(rec UndoRecord) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	txn.undo = append(txn.undo, rec)
+}
+
+// TakeUndo returns every write the transaction has made and not rolled
+// back, in the order they were made, and forgets them along with its
+// savepoints.
+func (txn *Transaction) TakeUndo() []UndoRecord {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	undo := txn.undo
+	txn.undo = nil
+	txn.savepoints = nil
+	return undo
+}
+
+// RollbackToSavepoint forgets the writes made since the newest savepoint
+// named name and the savepoints set after it, and returns the savepoint
+// along with those writes in the order they were made. The savepoint
+// itself stays, so it can be rolled back to again.
+func (txn *Transaction) RollbackToSavepoint(name string) (Savepoint, []UndoRecord, error) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	i := txn.findSavepointLocked(name)
+	if i < 0 {
+		return Savepoint{}, nil, fmt.Errorf("savepoint %s does not exist", name)
+	}
+	sp := txn.savepoints[i]
+	undo := append([]UndoRecord(nil), txn.undo[sp.undoLen:]...)
+	txn.savepoints = txn.savepoints[:i+1]
+	txn.undo = txn.undo[:sp.undoLen]
+	return sp, undo, nil
+}
+
+// ReleaseSavepoint removes the newest savepoint named name and every
+// savepoint set after it, keeping their writes.
+func (txn *Transaction) ReleaseSavepoint(name string) error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	i := txn.findSavepointLocked(name)
+	if i < 0 {
+		return fmt.Errorf("savepoint %s does not exist", name)
+	}
+	txn.savepoints = txn.savepoints[:i]
+	return nil
+}
+
+// findSavepointLocked returns the index of the newest savepoint named
+// name, or -1 (must hold txn.mu).
+func (txn *Transaction) findSavepointLocked(name string) int {
+	for i := len(txn.savepoints) - 1; i >= 0; i-- {
+		if txn.savepoints[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
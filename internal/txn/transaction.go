@@ -7,6 +7,7 @@ import (
 	"minidb/pkg/types"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Manager handles transaction lifecycle and coordination.
@@ -19,14 +20,24 @@ type Manager struct {
 	// Active transactions
 	activeTxns map[types.TxnID]*Transaction
 
-	// Committed transactions (for VACUUM dead tuple validation)
-	committedTxns map[types.TxnID]bool
+	// Committed transactions and their commit times (for VACUUM dead
+	// tuple validation and retention)
+	committedTxns map[types.TxnID]time.Time
 
 	// WAL writer
 	walWriter *wal.Writer
 
 	// Global snapshot for visibility
 	globalXmin types.TxnID // Oldest active transaction
+
+	// Number of commits so far (lets callers detect stale snapshots)
+	commitSeq uint64
+
+	// Hooks invoked after a transaction commits
+	commitHooks []func(*Transaction)
+
+	// Row locks, released when a transaction ends
+	locks *LockManager
 }
 
 // Transaction represents an active transaction.
@@ -36,16 +47,43 @@ type Transaction struct {
 	StartTS   types.TxnID       // Start timestamp for snapshot
 	Snapshot  *Snapshot         // Visibility snapshot
 	CommandID types.CommandID   // Current command within transaction
+	Isolation IsolationLevel    // When the snapshot is taken
 	
 	// Undo information
+	FirstLSN  types.LSN // The BEGIN record
 	LastLSN   types.LSN
 	
-	// Locks held (simplified - in real DB would be more complex)
-	HeldLocks map[string]LockMode
+	// Row locks held, maintained by the LockManager under its mutex
+	HeldLocks map[RowKey]LockMode
+
+	// Tables modified by this transaction
+	writeSet map[uint32]bool
+
+	// Savepoints in the order they were set, and the writes made since the
+	// first of them
+	savepoints []Savepoint
+	undo       []UndoRecord
 	
 	mu sync.Mutex
 }
 
+// IsolationLevel selects when a transaction's snapshot is taken.
+type IsolationLevel int
+
+const (
+	// RepeatableRead uses one snapshot for the whole transaction.
+	RepeatableRead IsolationLevel = iota
+	// ReadCommitted takes a fresh snapshot for every statement.
+	ReadCommitted
+)
+
+func (l IsolationLevel) String() string {
+	if l == ReadCommitted {
+		return "read committed"
+	}
+	return "repeatable read"
+}
+
 // LockMode represents the type of lock.
 type LockMode int
 
@@ -59,9 +97,10 @@ func NewManager(walWriter *wal.Writer) *Manager {
 	return &Manager{
 		nextTxnID:     1,
 		activeTxns:    make(map[types.TxnID]*Transaction),
-		committedTxns: make(map[types.TxnID]bool),
+		committedTxns: make(map[types.TxnID]time.Time),
 		walWriter:     walWriter,
 		globalXmin:    types.MaxTxnID,
+		locks:         NewLockManager(),
 	}
 }
 
@@ -81,7 +120,7 @@ func (m *Manager) Begin() *Transaction {
 		StartTS:   txnID,
 		Snapshot:  snapshot,
 		CommandID: 0,
-		HeldLocks: make(map[string]LockMode),
+		HeldLocks: make(map[RowKey]LockMode),
 	}
 	
 	m.activeTxns[txnID] = txn
@@ -90,13 +129,31 @@ func (m *Manager) Begin() *Transaction {
 	// Log BEGIN
 	if m.walWriter != nil {
 		txn.LastLSN = m.walWriter.LogBegin(txnID)
+		txn.FirstLSN = txn.LastLSN
 	}
 	
 	return txn
 }
 
-// Commit commits a transaction.
+// Commit commits a transaction and then runs the registered commit hooks.
 func (m *Manager) Commit(txn *Transaction) error {
+	if err := m.commit(txn); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	hooks := m.commitHooks
+	m.mu.RUnlock()
+
+	for _, hook := range hooks {
+		hook(txn)
+	}
+
+	return nil
+}
+
+// commit marks the transaction committed.
+func (m *Manager) commit(txn *Transaction) error {
 	txn.mu.Lock()
 	defer txn.mu.Unlock()
 	
@@ -116,18 +173,70 @@ func (m *Manager) Commit(txn *Transaction) error {
 	txn.Status = types.TxnStatusCommitted
 
 	// Release locks
-	txn.HeldLocks = nil
+	m.locks.ReleaseAll(txn)
 
 	// Remove from active transactions and record as committed
 	m.mu.Lock()
 	delete(m.activeTxns, txn.ID)
-	m.committedTxns[txn.ID] = true
+	m.committedTxns[txn.ID] = time.Now()
+	m.commitSeq++
 	m.updateGlobalXmin()
 	m.mu.Unlock()
 
 	return nil
 }
 
+// LockRow takes a lock on a row for txn, waiting while another transaction
+// holds a conflicting one. It returns an error wrapping ErrDeadlock if txn
+// is chosen to break a deadlock. The lock is released when txn commits or
+// rolls back.
+func (m *Manager) LockRow(txn *Transaction, key RowKey, mode LockMode) error {
+	return m.locks.Lock(txn, key, mode)
+}
+
+// LockRowReleasing is LockRow for a caller holding held, which is unlocked
+// while the call waits (see LockManager.LockReleasing).
+func (m *Manager) LockRowReleasing(txn *Transaction, key RowKey, mode LockMode, held sync.Locker) error {
+	return m.locks.LockReleasing(txn, key, mode, held)
+}
+
+// OnCommit registers a hook that runs after every successful commit.
+func (m *Manager) OnCommit(hook func(*Transaction)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commitHooks = append(m.commitHooks, hook)
+}
+
+// RefreshSnapshot replaces a transaction's snapshot with one taken now, so
+// its next statement sees every change committed since (READ COMMITTED).
+func (m *Manager) RefreshSnapshot(txn *Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	// A snapshot taken in Begin ends just before the new transaction's own
+	// ID. This one covers every ID handed out so far; the transaction itself
+	// is still active, so its own writes stay excluded as before.
+	snap := m.createSnapshotLocked()
+	snap.Xmax++
+	txn.Snapshot = snap
+}
+
+// IsSnapshotCurrent returns true if no transaction has committed since the
+// snapshot was taken, i.e. the snapshot sees every committed change.
+func (m *Manager) IsSnapshotCurrent(snap *Snapshot) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return snap.CommitSeq == m.commitSeq
+}
+
+// CommitSeq returns the number of transactions committed so far. It is
+// bumped before the commit hooks run.
+func (m *Manager) CommitSeq() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.commitSeq
+}
+
 // Rollback aborts a transaction.
 func (m *Manager) Rollback(txn *Transaction) error {
 	txn.mu.Lock()
@@ -145,7 +254,7 @@ func (m *Manager) Rollback(txn *Transaction) error {
 	}
 	
 	// Release locks
-	txn.HeldLocks = nil
+	m.locks.ReleaseAll(txn)
 	
 	// Remove from active transactions
 	m.mu.Lock()
@@ -162,6 +271,7 @@ func (m *Manager) createSnapshotLocked() *Snapshot {
 		Xmin:       types.MaxTxnID,
 		Xmax:       types.TxnID(atomic.LoadUint64(&m.nextTxnID)),
 		ActiveTxns: make(map[types.TxnID]bool),
+		CommitSeq:  m.commitSeq,
 	}
 	
 	for txnID := range m.activeTxns {
@@ -207,6 +317,22 @@ func (m *Manager) GetActiveTxns() []types.TxnID {
 	return txns
 }
 
+// OldestActiveLSN returns the BEGIN record of the oldest active
+// transaction, or InvalidLSN if none is active. The log from there on is
+// needed to roll back every active transaction.
+func (m *Manager) OldestActiveLSN() types.LSN {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	oldest := types.InvalidLSN
+	for _, txn := range m.activeTxns {
+		if txn.FirstLSN != types.InvalidLSN && (oldest == types.InvalidLSN || txn.FirstLSN < oldest) {
+			oldest = txn.FirstLSN
+		}
+	}
+	return oldest
+}
+
 // GetTransaction returns a transaction by ID.
 func (m *Manager) GetTransaction(txnID types.TxnID) *Transaction {
 	m.mu.RLock()
@@ -222,6 +348,34 @@ func (txn *Transaction) NextCommandID() types.CommandID {
 	return txn.CommandID
 }
 
+// RecordWrite notes that the transaction modified the given table.
+func (txn *Transaction) RecordWrite(tableID uint32) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.writeSet == nil {
+		txn.writeSet = make(map[uint32]bool)
+	}
+	txn.writeSet[tableID] = true
+}
+
+// HasWritten returns true if the transaction modified the given table.
+func (txn *Transaction) HasWritten(tableID uint32) bool {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	return txn.writeSet[tableID]
+}
+
+// WriteSet returns the IDs of all tables modified by the transaction.
+func (txn *Transaction) WriteSet() []uint32 {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	tables := make([]uint32, 0, len(txn.writeSet))
+	for tableID := range txn.writeSet {
+		tables = append(tables, tableID)
+	}
+	return tables
+}
+
 // SetNextTxnID sets the next transaction ID (used during recovery).
 func (m *Manager) SetNextTxnID(id types.TxnID) {
 	atomic.StoreUint64(&m.nextTxnID, uint64(id))
@@ -231,7 +385,27 @@ func (m *Manager) SetNextTxnID(id types.TxnID) {
 func (m *Manager) IsTxnCommitted(txnID types.TxnID) bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.committedTxns[txnID]
+	_, ok := m.committedTxns[txnID]
+	return ok
+}
+
+// LastTxnID returns the most recently assigned transaction ID.
+func (m *Manager) LastTxnID() types.TxnID {
+	return types.TxnID(atomic.LoadUint64(&m.nextTxnID))
+}
+
+// OldestCommittedSince returns the lowest ID among transactions that
+// committed at or after since, or MaxTxnID if none did.
+func (m *Manager) OldestCommittedSince(since time.Time) types.TxnID {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	oldest := types.MaxTxnID
+	for txnID, committedAt := range m.committedTxns {
+		if !committedAt.Before(since) && txnID < oldest {
+			oldest = txnID
+		}
+	}
+	return oldest
 }
 
 // PruneCommittedBefore removes committed transaction records older than cutoff.
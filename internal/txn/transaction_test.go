@@ -5,6 +5,7 @@ import (
 	"minidb/pkg/types"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func newTestManager(t *testing.T) *Manager {
@@ -292,6 +293,29 @@ func TestPruneCommittedBefore(t *testing.T) {
 	}
 }
 
+func TestOldestCommittedSince(t *testing.T) {
+	m := newTestManager(t)
+
+	txn1 := m.Begin()
+	txn2 := m.Begin()
+	txn3 := m.Begin()
+	m.Commit(txn1)
+	time.Sleep(2 * time.Millisecond)
+	cutoff := time.Now()
+	m.Commit(txn3)
+	m.Commit(txn2)
+
+	if got := m.OldestCommittedSince(cutoff); got != txn2.ID {
+		t.Errorf("OldestCommittedSince() = %d, want %d", got, txn2.ID)
+	}
+	if got := m.OldestCommittedSince(time.Now().Add(time.Hour)); got != types.MaxTxnID {
+		t.Errorf("OldestCommittedSince(future) = %d, want MaxTxnID", got)
+	}
+	if got := m.LastTxnID(); got != txn3.ID {
+		t.Errorf("LastTxnID() = %d, want %d", got, txn3.ID)
+	}
+}
+
 func TestManagerWithNilWALWriter(t *testing.T) {
 	m := NewManager(nil)
 
@@ -303,3 +327,24 @@ func TestManagerWithNilWALWriter(t *testing.T) {
 		t.Fatalf("Commit() error = %v", err)
 	}
 }
+
+func TestRefreshSnapshot(t *testing.T) {
+	m := newTestManager(t)
+
+	reader := m.Begin()
+	writer := m.Begin()
+	row := &types.Tuple{XMin: writer.ID}
+	m.Commit(writer)
+
+	if reader.Snapshot.IsVisible(row) {
+		t.Fatal("row committed after BEGIN should be invisible to the original snapshot")
+	}
+
+	m.RefreshSnapshot(reader)
+	if !reader.Snapshot.IsVisible(row) {
+		t.Error("refreshed snapshot should see the committed row")
+	}
+	if reader.Snapshot.IsVisible(&types.Tuple{XMin: reader.ID}) {
+		t.Error("refreshed snapshot should still exclude the transaction's own writes")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"minidb/pkg/types"
+	"time"
 )
 
 // LogRecord represents a single WAL log entry.
@@ -25,14 +26,27 @@ type LogRecord struct {
 	// For CHECKPOINT
 	ActiveTxns  []types.TxnID
 	DirtyPages  map[types.PageID]types.LSN // PageID -> RecLSN
+	MaxTxnID    types.TxnID                // Highest TxnID logged so far
 	
 	// For CLR (Compensation Log Record)
 	UndoNextLSN types.LSN
+	
+	// For UPDATE: location of the old version (PageID/SlotNum hold the new one)
+	OldPageID  types.PageID
+	OldSlotNum uint16
+	
+	// Wall-clock time the record was appended (zero in version 2 logs)
+	Timestamp time.Time
 }
 
 // Header size: LSN(8) + PrevLSN(8) + TxnID(8) + Type(1) + TableID(4) + RowID(8) + PageID(4) + SlotNum(2) + BeforeLen(4) + AfterLen(4)
 const logRecordHeaderSize = 51
 
+// The timestamp (Unix nanoseconds) trails every record since WAL version 3.
+// Records are length-prefixed, so a version 2 record is recognised by ending
+// before it.
+const logRecordTimestampSize = 8
+
 // Serialize converts the log record to bytes.
 func (r *LogRecord) Serialize() []byte {
 	beforeLen := len(r.BeforeImage)
@@ -53,6 +67,13 @@ func (r *LogRecord) Serialize() []byte {
 		size += 8 // UndoNextLSN
 	}
 	
+	// Add old-version location for updates
+	if r.Type == types.LogRecordUpdate {
+		size += 6 // OldPageID + OldSlotNum
+	}
+	
+	size += logRecordTimestampSize
+	
 	buf := make([]byte, size)
 	offset := 0
 	
@@ -95,14 +116,30 @@ func (r *LogRecord) Serialize() []byte {
 	// Write CLR data
 	if r.Type == types.LogRecordCLR {
 		binary.LittleEndian.PutUint64(buf[offset:], uint64(r.UndoNextLSN))
+		offset += 8
+	}
+	
+	// Write old-version location
+	if r.Type == types.LogRecordUpdate {
+		binary.LittleEndian.PutUint32(buf[offset:], uint32(r.OldPageID))
+		offset += 4
+		binary.LittleEndian.PutUint16(buf[offset:], r.OldSlotNum)
+		offset += 2
+	}
+	
+	// Write timestamp
+	var nanos int64
+	if !r.Timestamp.IsZero() {
+		nanos = r.Timestamp.UnixNano()
 	}
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(nanos))
 	
 	return buf
 }
 
 func (r *LogRecord) serializeCheckpoint() []byte {
-	// Format: NumActiveTxns(4) + [TxnID(8)...] + NumDirtyPages(4) + [PageID(4) + RecLSN(8)...]
-	size := 4 + len(r.ActiveTxns)*8 + 4 + len(r.DirtyPages)*12
+	// Format: NumActiveTxns(4) + [TxnID(8)...] + NumDirtyPages(4) + [PageID(4) + RecLSN(8)...] + MaxTxnID(8)
+	size := 4 + len(r.ActiveTxns)*8 + 4 + len(r.DirtyPages)*12 + 8
 	buf := make([]byte, size)
 	offset := 0
 	
@@ -122,6 +159,8 @@ func (r *LogRecord) serializeCheckpoint() []byte {
 		offset += 8
 	}
 	
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(r.MaxTxnID))
+	
 	return buf
 }
 
@@ -195,6 +234,25 @@ func Deserialize(buf []byte) (*LogRecord, int, error) {
 		offset += 8
 	}
 	
+	// Read old-version location
+	if r.Type == types.LogRecordUpdate {
+		if len(buf) < offset+6 {
+			return nil, 0, fmt.Errorf("buffer too small for update location")
+		}
+		r.OldPageID = types.PageID(binary.LittleEndian.Uint32(buf[offset:]))
+		offset += 4
+		r.OldSlotNum = binary.LittleEndian.Uint16(buf[offset:])
+		offset += 2
+	}
+	
+	// Read timestamp, absent from version 2 records
+	if len(buf) >= offset+logRecordTimestampSize {
+		if nanos := int64(binary.LittleEndian.Uint64(buf[offset:])); nanos != 0 {
+			r.Timestamp = time.Unix(0, nanos)
+		}
+		offset += logRecordTimestampSize
+	}
+	
 	return r, offset, nil
 }
 
@@ -219,9 +277,18 @@ func (r *LogRecord) deserializeCheckpoint(buf []byte) {
 		offset += 8
 		r.DirtyPages[pageID] = recLSN
 	}
+	
+	// Checkpoints written before the log could be truncated end here
+	if len(buf) >= offset+8 {
+		r.MaxTxnID = types.TxnID(binary.LittleEndian.Uint64(buf[offset:]))
+	}
 }
 
 func (r *LogRecord) String() string {
-	return fmt.Sprintf("LogRecord{LSN:%d, TxnID:%d, Type:%s, Table:%d, Row:%d}",
-		r.LSN, r.TxnID, r.Type.String(), r.TableID, r.RowID)
+	if r.Timestamp.IsZero() {
+		return fmt.Sprintf("LogRecord{LSN:%d, TxnID:%d, Type:%s, Table:%d, Row:%d}",
+			r.LSN, r.TxnID, r.Type.String(), r.TableID, r.RowID)
+	}
+	return fmt.Sprintf("LogRecord{LSN:%d, TxnID:%d, Type:%s, Table:%d, Row:%d, Time:%s}",
+		r.LSN, r.TxnID, r.Type.String(), r.TableID, r.RowID, r.Timestamp.Format(time.RFC3339Nano))
 }
@@ -3,7 +3,9 @@ package wal
 import (
 	"bytes"
 	"minidb/pkg/types"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLogRecordSerializeDeserialize(t *testing.T) {
@@ -47,6 +49,7 @@ func TestLogRecordSerializeDeserialize(t *testing.T) {
 				PageID:     types.PageID(5),
 				SlotNum:    2,
 				AfterImage: []byte("inserted data"),
+				Timestamp:  time.Date(2024, 1, 1, 12, 0, 0, 123456789, time.UTC),
 			},
 		},
 		{
@@ -58,6 +61,8 @@ func TestLogRecordSerializeDeserialize(t *testing.T) {
 				Type:        types.LogRecordUpdate,
 				TableID:     1,
 				RowID:       100,
+				OldPageID:   types.PageID(4),
+				OldSlotNum:  7,
 				PageID:      types.PageID(5),
 				SlotNum:     2,
 				BeforeImage: []byte("old data"),
@@ -133,9 +138,15 @@ func TestLogRecordSerializeDeserialize(t *testing.T) {
 			if !bytes.Equal(got.AfterImage, tt.record.AfterImage) {
 				t.Errorf("AfterImage mismatch")
 			}
+			if got.OldPageID != tt.record.OldPageID || got.OldSlotNum != tt.record.OldSlotNum {
+				t.Errorf("old location = (%d, %d), want (%d, %d)", got.OldPageID, got.OldSlotNum, tt.record.OldPageID, tt.record.OldSlotNum)
+			}
 			if got.Type == types.LogRecordCLR && got.UndoNextLSN != tt.record.UndoNextLSN {
 				t.Errorf("UndoNextLSN = %d, want %d", got.UndoNextLSN, tt.record.UndoNextLSN)
 			}
+			if !got.Timestamp.Equal(tt.record.Timestamp) {
+				t.Errorf("Timestamp = %v, want %v", got.Timestamp, tt.record.Timestamp)
+			}
 		})
 	}
 }
@@ -250,6 +261,49 @@ func TestLogRecordString(t *testing.T) {
 	}
 }
 
+func TestLogRecordStringTimestamp(t *testing.T) {
+	r := &LogRecord{
+		LSN:       1,
+		TxnID:     types.TxnID(42),
+		Type:      types.LogRecordInsert,
+		Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if s := r.String(); !strings.Contains(s, "Time:2024-01-01T12:00:00Z") {
+		t.Errorf("String() = %q, want it to include the timestamp", s)
+	}
+}
+
+func TestDeserializeVersion2Record(t *testing.T) {
+	record := &LogRecord{
+		LSN:         5,
+		TxnID:       types.TxnID(1),
+		Type:        types.LogRecordUpdate,
+		PageID:      types.PageID(5),
+		OldPageID:   types.PageID(4),
+		OldSlotNum:  7,
+		BeforeImage: []byte("old"),
+		AfterImage:  []byte("new"),
+		Timestamp:   time.Now(),
+	}
+	// A version 2 record is the same bytes without the trailing timestamp
+	buf := record.Serialize()
+	buf = buf[:len(buf)-logRecordTimestampSize]
+
+	got, consumed, err := Deserialize(buf)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+	if consumed != len(buf) {
+		t.Errorf("consumed = %d, want %d", consumed, len(buf))
+	}
+	if !got.Timestamp.IsZero() {
+		t.Errorf("Timestamp = %v, want zero", got.Timestamp)
+	}
+	if got.OldPageID != record.OldPageID || got.OldSlotNum != record.OldSlotNum {
+		t.Errorf("old location = (%d, %d), want (%d, %d)", got.OldPageID, got.OldSlotNum, record.OldPageID, record.OldSlotNum)
+	}
+}
+
 func TestEmptyCheckpoint(t *testing.T) {
 	record := &LogRecord{
 		LSN:        1,
@@ -26,6 +26,9 @@ type RecoveryManager struct {
 
 	// WAL writer for CLR records during undo
 	walWriter *Writer
+
+	// Point-in-time recovery target (InvalidLSN recovers the whole log)
+	targetLSN types.LSN
 }
 
 // TxnEntry represents an entry in the Active Transaction Table.
@@ -57,9 +60,24 @@ func (rm *RecoveryManager) SetPageLSNCallback(cb func(types.PageID) types.LSN) {
 	rm.pageLSNCallback = cb
 }
 
+// SetTargetLSN limits recovery to the log up to and including lsn. Redo
+// stops there, and every transaction that had not committed by then is
+// rolled back, including ones that commit later in the log.
+func (rm *RecoveryManager) SetTargetLSN(lsn types.LSN) {
+	rm.targetLSN = lsn
+}
+
+// pastTarget reports whether a record lies beyond the recovery target.
+func (rm *RecoveryManager) pastTarget(lsn types.LSN) bool {
+	return rm.targetLSN != types.InvalidLSN && lsn > rm.targetLSN
+}
+
 // Recover performs full ARIES recovery: Analysis -> Redo -> Undo.
 func (rm *RecoveryManager) Recover() error {
 	fmt.Println("=== Starting ARIES Recovery ===")
+	if rm.targetLSN != types.InvalidLSN {
+		fmt.Printf("Recovery target LSN: %d\n", rm.targetLSN)
+	}
 	
 	// Phase 1: Analysis
 	fmt.Println("\n--- Phase 1: Analysis ---")
@@ -110,9 +128,15 @@ func (rm *RecoveryManager) analysisPhase() (types.LSN, error) {
 		return 0, err
 	}
 	
+	// A truncated log starts past LSN 1, and the pages already hold every
+	// change before its start
+	if rm.targetLSN != types.InvalidLSN && len(records) > 0 && records[0].LSN > 1 && rm.targetLSN < records[0].LSN {
+		return 0, fmt.Errorf("target LSN %d was truncated from the log, which starts at LSN %d", rm.targetLSN, records[0].LSN)
+	}
+	
 	// First pass: find checkpoint
 	for _, record := range records {
-		if record.Type == types.LogRecordCheckpoint {
+		if record.Type == types.LogRecordCheckpoint && !rm.pastTarget(record.LSN) {
 			lastCheckpointLSN = record.LSN
 			lastCheckpointRecord = record
 		}
@@ -134,6 +158,15 @@ func (rm *RecoveryManager) analysisPhase() (types.LSN, error) {
 	// Second pass: scan from checkpoint
 	for _, record := range records {
 		if lastCheckpointLSN > 0 && record.LSN <= lastCheckpointLSN {
+			// The checkpoint lists its active transactions but not the
+			// records undo starts from
+			if _, ok := rm.activeTxnTable[record.TxnID]; ok {
+				rm.trackUndoChain(record)
+			}
+			continue
+		}
+		if rm.pastTarget(record.LSN) {
+			rm.trackPastTarget(record)
 			continue
 		}
 		
@@ -156,7 +189,10 @@ func (rm *RecoveryManager) analysisPhase() (types.LSN, error) {
 			
 		case types.LogRecordUpdate, types.LogRecordInsert, types.LogRecordDelete:
 			if entry, ok := rm.activeTxnTable[record.TxnID]; ok {
+				// A write after a rollback to a savepoint is undone first;
+				// its PrevLSN chain leads to the CLRs
 				entry.LastLSN = record.LSN
+				entry.UndoNext = types.InvalidLSN
 			}
 			// Add to dirty page table
 			if _, exists := rm.dirtyPageTable[record.PageID]; !exists {
@@ -168,12 +204,56 @@ func (rm *RecoveryManager) analysisPhase() (types.LSN, error) {
 				entry.LastLSN = record.LSN
 				entry.UndoNext = record.UndoNextLSN
 			}
+			// A CLR can change a page flushed since the write it reverts
+			if _, exists := rm.dirtyPageTable[record.PageID]; !exists {
+				rm.dirtyPageTable[record.PageID] = record.LSN
+			}
 		}
 	}
 	
 	return lastCheckpointLSN, nil
 }
 
+// trackPastTarget notes a record beyond the recovery target. Such records
+// are never redone, and commits there do not count, so their transactions
+// stay in the ATT and undo can reach every change that may be on disk.
+func (rm *RecoveryManager) trackPastTarget(record *LogRecord) {
+	if record.Type == types.LogRecordBegin {
+		if _, ok := rm.activeTxnTable[record.TxnID]; !ok {
+			rm.activeTxnTable[record.TxnID] = &TxnEntry{
+				TxnID:   record.TxnID,
+				Status:  types.TxnStatusRunning,
+				LastLSN: record.LSN,
+			}
+		}
+		return
+	}
+	rm.trackUndoChain(record)
+}
+
+// trackUndoChain moves the ATT entry of a record's transaction, if any, to
+// the record, so that undo starts from the transaction's latest record.
+func (rm *RecoveryManager) trackUndoChain(record *LogRecord) {
+	switch record.Type {
+	case types.LogRecordUpdate, types.LogRecordInsert, types.LogRecordDelete:
+		if entry, ok := rm.activeTxnTable[record.TxnID]; ok {
+			entry.LastLSN = record.LSN
+			entry.UndoNext = types.InvalidLSN
+		}
+	
+	case types.LogRecordAbort:
+		if entry, ok := rm.activeTxnTable[record.TxnID]; ok {
+			entry.LastLSN = record.LSN
+		}
+	
+	case types.LogRecordCLR:
+		if entry, ok := rm.activeTxnTable[record.TxnID]; ok {
+			entry.LastLSN = record.LSN
+			entry.UndoNext = record.UndoNextLSN
+		}
+	}
+}
+
 // redoPhase replays all logged actions from the minimum RecLSN.
 func (rm *RecoveryManager) redoPhase() error {
 	if len(rm.dirtyPageTable) == 0 {
@@ -208,6 +288,9 @@ func (rm *RecoveryManager) redoPhase() error {
 		if record.LSN < minRecLSN {
 			continue
 		}
+		if rm.pastTarget(record.LSN) {
+			break
+		}
 		
 		// Only redo data-modifying records
 		if record.Type != types.LogRecordUpdate &&
@@ -323,6 +406,16 @@ func (rm *RecoveryManager) undoPhase() error {
 			continue
 		}
 		
+		// Past the target a change may never have reached its page; the
+		// page LSN tells whether it did
+		if rm.pastTarget(record.LSN) && rm.pageLSNCallback != nil &&
+			rm.pageLSNCallback(record.PageID) < record.LSN {
+			if record.PrevLSN != 0 {
+				toUndo = append(toUndo, record.PrevLSN)
+			}
+			continue
+		}
+		
 		// Apply undo
 		if rm.undoCallback != nil {
 			fmt.Printf("UNDO: %s\n", record.String())
@@ -395,6 +488,20 @@ func (rm *RecoveryManager) readAllRecords(file *os.File) ([]*LogRecord, error) {
 	return records, nil
 }
 
+// ReadRecords returns the complete records of a WAL file in log order.
+func ReadRecords(walPath string) ([]*LogRecord, error) {
+	file, err := os.Open(walPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	
+	if _, err := file.Seek(walFileHeader, 0); err != nil {
+		return nil, err
+	}
+	return NewRecoveryManager(walPath, nil).readAllRecords(file)
+}
+
 // GetActiveTxnTable returns the active transaction table after analysis.
 func (rm *RecoveryManager) GetActiveTxnTable() map[types.TxnID]*TxnEntry {
 	return rm.activeTxnTable
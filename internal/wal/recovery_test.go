@@ -70,7 +70,7 @@ func TestAnalysisDirtyPageTable(t *testing.T) {
 
 	w.LogBegin(types.TxnID(1))
 	w.LogInsert(types.TxnID(1), 1, 1, types.PageID(5), 0, []byte("data"))
-	w.LogUpdate(types.TxnID(1), 1, 1, types.PageID(7), 1, []byte("old"), []byte("new"))
+	w.LogUpdate(types.TxnID(1), 1, 1, types.PageID(7), 0, types.PageID(7), 1, []byte("old"), []byte("new"))
 	w.Flush()
 	w.Close()
 
@@ -132,6 +132,47 @@ func TestAnalysisFromCheckpoint(t *testing.T) {
 	}
 }
 
+func TestUndoBeforeCheckpoint(t *testing.T) {
+	walPath, w := setupRecoveryTest(t)
+
+	w.LogBegin(types.TxnID(1))
+	w.LogCommit(types.TxnID(1))
+
+	// Txn 2 writes only before the checkpoint that lists it as active
+	beginLSN := w.LogBegin(types.TxnID(2))
+	insertLSN := w.LogInsert(types.TxnID(2), 1, 1, types.PageID(0), 0, []byte("data"))
+	w.LogCheckpoint([]types.TxnID{types.TxnID(2)}, nil)
+	w.Flush()
+	w.Close()
+
+	// The log truncated by that checkpoint still recovers
+	w2, _ := NewWriter(walPath)
+	defer w2.Close()
+	if err := w2.Truncate(beginLSN); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+
+	rm := NewRecoveryManager(walPath, w2)
+	var undone []types.LSN
+	rm.SetCallbacks(nil, func(r *LogRecord) error {
+		undone = append(undone, r.LSN)
+		return nil
+	})
+	if err := rm.Recover(); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if len(undone) != 1 || undone[0] != insertLSN {
+		t.Errorf("undone LSNs = %v, want [%d]", undone, insertLSN)
+	}
+
+	// The state before the truncated records cannot be recovered
+	rm2 := NewRecoveryManager(walPath, nil)
+	rm2.SetTargetLSN(beginLSN - 1)
+	if err := rm2.Recover(); err == nil {
+		t.Error("Recover() to a truncated target LSN should error")
+	}
+}
+
 func TestRedoPhase(t *testing.T) {
 	walPath, w := setupRecoveryTest(t)
 
@@ -315,3 +356,90 @@ func TestRecoveryNoWALFile(t *testing.T) {
 		t.Errorf("checkpoint LSN = %d, want 0", lsn)
 	}
 }
+
+func TestRecoveryToTargetLSN(t *testing.T) {
+	walPath, w := setupRecoveryTest(t)
+
+	w.LogBegin(types.TxnID(1))
+	w.LogInsert(types.TxnID(1), 1, 1, types.PageID(0), 0, []byte("kept"))
+	target, _ := w.LogCommit(types.TxnID(1))
+
+	// Committed, but after the target
+	w.LogBegin(types.TxnID(2))
+	flushedLSN := w.LogInsert(types.TxnID(2), 1, 2, types.PageID(1), 0, []byte("on disk"))
+	notFlushedLSN := w.LogInsert(types.TxnID(2), 1, 3, types.PageID(2), 0, []byte("lost"))
+	w.LogCommit(types.TxnID(2))
+	w.Flush()
+	w.Close()
+
+	w2, _ := NewWriter(walPath)
+	defer w2.Close()
+
+	rm := NewRecoveryManager(walPath, w2)
+	rm.SetTargetLSN(target)
+
+	var redoRecords, undoRecords []*LogRecord
+	rm.SetCallbacks(
+		func(r *LogRecord) error {
+			redoRecords = append(redoRecords, r)
+			return nil
+		},
+		func(r *LogRecord) error {
+			undoRecords = append(undoRecords, r)
+			return nil
+		},
+	)
+	// Page 1 was written back after the target; page 2 never was
+	rm.SetPageLSNCallback(func(pageID types.PageID) types.LSN {
+		if pageID == types.PageID(1) {
+			return flushedLSN
+		}
+		return types.InvalidLSN
+	})
+
+	if err := rm.Recover(); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+
+	for _, r := range redoRecords {
+		if r.LSN > target {
+			t.Errorf("redo applied LSN %d past target %d", r.LSN, target)
+		}
+	}
+	if len(redoRecords) != 1 {
+		t.Errorf("redoRecords = %d, want 1", len(redoRecords))
+	}
+	if _, ok := rm.GetActiveTxnTable()[types.TxnID(2)]; !ok {
+		t.Error("txn 2 committed after the target and should be rolled back")
+	}
+	if len(undoRecords) != 1 || undoRecords[0].LSN != flushedLSN {
+		t.Errorf("undone records = %v, want only LSN %d (LSN %d never reached its page)", undoRecords, flushedLSN, notFlushedLSN)
+	}
+}
+
+func TestReadRecords(t *testing.T) {
+	walPath, w := setupRecoveryTest(t)
+
+	w.LogBegin(types.TxnID(1))
+	w.LogInsert(types.TxnID(1), 1, 1, types.PageID(0), 0, []byte("data"))
+	w.LogAbort(types.TxnID(1))
+	w.Close()
+
+	records, err := ReadRecords(walPath)
+	if err != nil {
+		t.Fatalf("ReadRecords() error = %v", err)
+	}
+	want := []types.LogRecordType{types.LogRecordBegin, types.LogRecordInsert, types.LogRecordAbort}
+	if len(records) != len(want) {
+		t.Fatalf("records = %d, want %d", len(records), len(want))
+	}
+	for i, record := range records {
+		if record.Type != want[i] || record.TxnID != 1 {
+			t.Errorf("record %d = %s txn %d, want %s txn 1", i, record.Type, record.TxnID, want[i])
+		}
+	}
+
+	if _, err := ReadRecords(filepath.Join(t.TempDir(), "missing.log")); err == nil {
+		t.Error("ReadRecords() of a missing file should error")
+	}
+}
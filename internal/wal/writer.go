@@ -6,7 +6,9 @@ import (
 	"io"
 	"minidb/pkg/types"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 // Writer handles WAL log writing and flushing.
@@ -22,30 +24,64 @@ type Writer struct {
 	flushedLSN types.LSN
 	
 	// Buffer for batching writes
-	buffer    []byte
-	bufferLSN types.LSN // LSN of first record in buffer
+	buffer     []byte
+	bufferLSN  types.LSN // LSN of first record in buffer
+	bufferSize int       // Auto-flush threshold in bytes
 	
 	// Transaction tracking for PrevLSN
 	txnLastLSN map[types.TxnID]types.LSN
 
 	// Max TxnID seen in WAL (for recovery)
 	maxTxnID types.TxnID
+	
+	// Records and bytes appended since the last checkpoint record
+	recordsSinceCheckpoint uint64
+	bytesSinceCheckpoint   uint64
+	
+	// Async commit skips the per-commit sync; the background flusher (or
+	// the next flush of any kind) makes commits durable
+	asyncCommit bool
+	flusherStop chan struct{}
+	flusherDone chan struct{}
+	
+	// Opened by NewReadOnlyWriter: records are numbered as usual but never
+	// written to the file
+	readOnly bool
 }
 
 const (
-	walBufferSize  = 64 * 1024 // 64KB buffer
+	// DefaultBufferSize is the auto-flush threshold used by NewWriter.
+	DefaultBufferSize = 64 * 1024
+	// MinBufferSize is the smallest buffer NewWriterSize accepts.
+	MinBufferSize = 4 * 1024
+)
+
+const (
 	walFileHeader  = 16        // Magic(8) + Version(4) + Reserved(4)
 	walMagic       = uint64(0x4D494E4944425741) // "MINIDBWA"
-	walVersion     = uint32(1)
+	walVersion     = uint32(3) // 3: records carry a wall-clock timestamp
+	walMinVersion  = uint32(2) // 2: UPDATE records carry the old version's location
 )
 
-// NewWriter creates a new WAL writer.
+// NewWriter creates a new WAL writer with the default buffer size.
 func NewWriter(path string) (*Writer, error) {
+	return NewWriterSize(path, DefaultBufferSize)
+}
+
+// NewWriterSize creates a new WAL writer that buffers up to bufferSize bytes
+// of records before flushing on its own. A larger buffer means fewer writes
+// and syncs for bulk work; a smaller one bounds memory.
+func NewWriterSize(path string, bufferSize int) (*Writer, error) {
+	if bufferSize < MinBufferSize {
+		return nil, fmt.Errorf("WAL buffer size %d is below the minimum of %d bytes", bufferSize, MinBufferSize)
+	}
+	
 	w := &Writer{
 		filePath:   path,
 		currentLSN: 1,
 		flushedLSN: 0,
-		buffer:     make([]byte, 0, walBufferSize),
+		buffer:     make([]byte, 0, bufferSize),
+		bufferSize: bufferSize,
 		txnLastLSN: make(map[types.TxnID]types.LSN),
 	}
 	
@@ -82,6 +118,36 @@ func NewWriter(path string) (*Writer, error) {
 	return w, nil
 }
 
+// NewReadOnlyWriter opens an existing WAL without write access. Records
+// appended to it, such as the CLRs and ABORTs of recovery's undo, get LSNs
+// as usual but are dropped instead of written, and Truncate fails.
+func NewReadOnlyWriter(path string) (*Writer, error) {
+	w := &Writer{
+		filePath:   path,
+		currentLSN: 1,
+		flushedLSN: 0,
+		buffer:     make([]byte, 0, MinBufferSize),
+		bufferSize: MinBufferSize,
+		txnLastLSN: make(map[types.TxnID]types.LSN),
+		readOnly:   true,
+	}
+	
+	var err error
+	w.file, err = os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL file: %w", err)
+	}
+	if err := w.readHeader(); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	if err := w.findLastLSN(); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
 func (w *Writer) writeHeader() error {
 	header := make([]byte, walFileHeader)
 	binary.LittleEndian.PutUint64(header[0:8], walMagic)
@@ -106,8 +172,28 @@ func (w *Writer) readHeader() error {
 	}
 	
 	version := binary.LittleEndian.Uint32(header[8:12])
-	if version != walVersion {
-		return fmt.Errorf("unsupported WAL version: %d", version)
+	if version < walMinVersion {
+		// Version 1 UPDATE records name the old version by a physical
+		// RowID, which no longer exists, so they cannot be replayed. The
+		// data file of that release has an older page layout too, which
+		// the DiskManager refuses on its own even without a log, so there
+		// is nothing to upgrade in place
+		return fmt.Errorf("unsupported WAL version %d in %s (this build reads versions %d to %d): "+
+			"the log and its data file predate this build; export the tables with the release that wrote them and load them into a new database",
+			version, w.filePath, walMinVersion, walVersion)
+	}
+	if version > walVersion {
+		return fmt.Errorf("unsupported WAL version %d in %s (this build reads versions %d to %d)", version, w.filePath, walMinVersion, walVersion)
+	}
+	
+	// Older records still read, and new ones are appended in the current
+	// format, so the file now holds the current version
+	if version < walVersion && !w.readOnly {
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], walVersion)
+		if _, err := w.file.WriteAt(buf[:], 8); err != nil {
+			return fmt.Errorf("failed to upgrade WAL header: %w", err)
+		}
 	}
 	
 	return nil
@@ -156,10 +242,14 @@ func (w *Writer) findLastLSN() error {
 		
 		lastLSN = record.LSN
 
-		// Track max TxnID
+		// Track max TxnID; a checkpoint carries the maximum of the records
+		// a truncation may have removed before it
 		if record.TxnID > w.maxTxnID {
 			w.maxTxnID = record.TxnID
 		}
+		if record.MaxTxnID > w.maxTxnID {
+			w.maxTxnID = record.MaxTxnID
+		}
 
 		// Track transaction's last LSN
 		if record.Type != types.LogRecordCheckpoint {
@@ -189,6 +279,14 @@ func (w *Writer) Append(record *LogRecord) types.LSN {
 	// Assign LSN
 	record.LSN = w.currentLSN
 	w.currentLSN++
+	record.Timestamp = time.Now()
+	
+	if record.TxnID > w.maxTxnID {
+		w.maxTxnID = record.TxnID
+	}
+	if record.Type == types.LogRecordCheckpoint {
+		record.MaxTxnID = w.maxTxnID
+	}
 	
 	// Set PrevLSN for this transaction
 	if prev, ok := w.txnLastLSN[record.TxnID]; ok {
@@ -216,8 +314,17 @@ func (w *Writer) Append(record *LogRecord) types.LSN {
 	w.buffer = append(w.buffer, lenBuf...)
 	w.buffer = append(w.buffer, data...)
 	
+	// A checkpoint starts a new counting window
+	if record.Type == types.LogRecordCheckpoint {
+		w.recordsSinceCheckpoint = 0
+		w.bytesSinceCheckpoint = 0
+	} else {
+		w.recordsSinceCheckpoint++
+		w.bytesSinceCheckpoint += uint64(len(lenBuf) + len(data))
+	}
+	
 	// Auto-flush if buffer is full
-	if len(w.buffer) >= walBufferSize {
+	if len(w.buffer) >= w.bufferSize {
 		w.flushLocked()
 	}
 	
@@ -240,6 +347,11 @@ func (w *Writer) flushLocked() error {
 	if len(w.buffer) == 0 {
 		return nil
 	}
+	if w.readOnly {
+		w.flushedLSN = w.currentLSN - 1
+		w.buffer = w.buffer[:0]
+		return nil
+	}
 	
 	// Write buffer to file
 	_, err := w.file.Write(w.buffer)
@@ -280,9 +392,15 @@ func (w *Writer) LogCommit(txnID types.TxnID) (types.LSN, error) {
 		Type:  types.LogRecordCommit,
 	})
 	
+	w.mu.Lock()
+	async := w.asyncCommit
+	w.mu.Unlock()
+	
 	// CRITICAL: Force commit record to disk for durability
-	if err := w.Force(lsn); err != nil {
-		return lsn, err
+	if !async {
+		if err := w.Force(lsn); err != nil {
+			return lsn, err
+		}
 	}
 	
 	// Clean up transaction tracking
@@ -293,6 +411,58 @@ func (w *Writer) LogCommit(txnID types.TxnID) (types.LSN, error) {
 	return lsn, nil
 }
 
+// SetAsyncCommit makes LogCommit return without syncing the commit record.
+// A crash can then lose commits made since the last flush; run StartFlusher
+// to bound that window.
+func (w *Writer) SetAsyncCommit(async bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.asyncCommit = async
+}
+
+// StartFlusher starts a goroutine that flushes the buffer every interval
+// until StopFlusher or Close. It does nothing if a flusher is running.
+func (w *Writer) StartFlusher(interval time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	
+	if w.flusherStop != nil {
+		return
+	}
+	w.flusherStop = make(chan struct{})
+	w.flusherDone = make(chan struct{})
+	go w.runFlusher(interval, w.flusherStop, w.flusherDone)
+}
+
+func (w *Writer) runFlusher(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// A failed flush keeps the buffer, so the next one retries it
+			w.Flush()
+		}
+	}
+}
+
+// StopFlusher stops the background flusher and waits for it to exit.
+func (w *Writer) StopFlusher() {
+	w.mu.Lock()
+	stop, done := w.flusherStop, w.flusherDone
+	w.flusherStop, w.flusherDone = nil, nil
+	w.mu.Unlock()
+	
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
 // LogAbort logs a transaction abort.
 func (w *Writer) LogAbort(txnID types.TxnID) types.LSN {
 	lsn := w.Append(&LogRecord{
@@ -307,8 +477,10 @@ func (w *Writer) LogAbort(txnID types.TxnID) types.LSN {
 	return lsn
 }
 
-// LogUpdate logs an update operation.
-func (w *Writer) LogUpdate(txnID types.TxnID, tableID uint32, rowID uint64, pageID types.PageID, slotNum uint16, before, after []byte) types.LSN {
+// LogUpdate logs an update operation. The old version at (oldPageID,
+// oldSlotNum) is marked deleted and the new version is written at
+// (pageID, slotNum).
+func (w *Writer) LogUpdate(txnID types.TxnID, tableID uint32, rowID uint64, oldPageID types.PageID, oldSlotNum uint16, pageID types.PageID, slotNum uint16, before, after []byte) types.LSN {
 	return w.Append(&LogRecord{
 		TxnID:       txnID,
 		Type:        types.LogRecordUpdate,
@@ -316,6 +488,8 @@ func (w *Writer) LogUpdate(txnID types.TxnID, tableID uint32, rowID uint64, page
 		RowID:       rowID,
 		PageID:      pageID,
 		SlotNum:     slotNum,
+		OldPageID:   oldPageID,
+		OldSlotNum:  oldSlotNum,
 		BeforeImage: before,
 		AfterImage:  after,
 	})
@@ -373,6 +547,107 @@ func (w *Writer) LogCLR(txnID types.TxnID, tableID uint32, rowID uint64, pageID
 	})
 }
 
+// Truncate removes the records before upToLSN from the WAL file, after
+// flushing the buffer. The header and the remaining records are copied to a
+// new file that then replaces the WAL, so a crash leaves either the old log
+// or the truncated one. LSNs are unchanged; only the records' offsets in
+// the file move.
+func (w *Writer) Truncate(upToLSN types.LSN) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	
+	if w.readOnly {
+		return fmt.Errorf("WAL %s is open read-only", w.filePath)
+	}
+	
+	if err := w.flushLocked(); err != nil {
+		return err
+	}
+	
+	// Find the first record to keep
+	if _, err := w.file.Seek(walFileHeader, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek WAL: %w", err)
+	}
+	offset := int64(walFileHeader)
+	lenBuf := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(w.file, lenBuf); err != nil {
+			break
+		}
+		recordLen := binary.LittleEndian.Uint32(lenBuf)
+		recordBuf := make([]byte, recordLen)
+		if _, err := io.ReadFull(w.file, recordBuf); err != nil {
+			break
+		}
+		record, _, err := Deserialize(recordBuf)
+		if err != nil || record.LSN >= upToLSN {
+			break
+		}
+		offset += int64(len(lenBuf)) + int64(recordLen)
+	}
+	if offset == walFileHeader {
+		_, err := w.file.Seek(0, io.SeekEnd)
+		return err
+	}
+	
+	info, err := w.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+	header := make([]byte, walFileHeader)
+	if _, err := w.file.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("failed to read WAL header: %w", err)
+	}
+	
+	tmpPath := w.filePath + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create WAL file: %w", err)
+	}
+	if _, err := tmp.Write(header); err == nil {
+		_, err = io.Copy(tmp, io.NewSectionReader(w.file, offset, info.Size()-offset))
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		w.file.Seek(0, io.SeekEnd)
+		return fmt.Errorf("failed to write truncated WAL: %w", err)
+	}
+	if err := os.Rename(tmpPath, w.filePath); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		w.file.Seek(0, io.SeekEnd)
+		return fmt.Errorf("failed to replace WAL file: %w", err)
+	}
+	
+	// Append to the new file from now on
+	w.file.Close()
+	w.file = tmp
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	
+	// The rename only survives a crash once the directory entry is on disk;
+	// without this the old, untruncated log may come back after power loss.
+	return syncDir(filepath.Dir(w.filePath))
+}
+
+// syncDir fsyncs a directory so that renames inside it are durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL directory: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL directory: %w", err)
+	}
+	return nil
+}
+
 // GetCurrentLSN returns the next LSN to be assigned.
 func (w *Writer) GetCurrentLSN() types.LSN {
 	w.mu.Lock()
@@ -387,8 +662,32 @@ func (w *Writer) GetFlushedLSN() types.LSN {
 	return w.flushedLSN
 }
 
-// Close closes the WAL file.
+// FileSize returns the size of the WAL file on disk, including the header.
+// Records still in the buffer are not counted until they are flushed.
+func (w *Writer) FileSize() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	
+	info, err := w.file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// SinceCheckpoint returns the number of records and bytes appended since the
+// last checkpoint record (or since the writer was opened).
+func (w *Writer) SinceCheckpoint() (records, bytes uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.recordsSinceCheckpoint, w.bytesSinceCheckpoint
+}
+
+// Close stops the background flusher, flushes the buffer and closes the
+// WAL file.
 func (w *Writer) Close() error {
+	w.StopFlusher()
+	
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	
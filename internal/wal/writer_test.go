@@ -1,10 +1,14 @@
 package wal
 
 import (
+	"bytes"
+	"encoding/binary"
 	"minidb/pkg/types"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func newTestWriter(t *testing.T) (*Writer, string) {
@@ -127,6 +131,52 @@ func TestLogCommitForcesToDisk(t *testing.T) {
 	}
 }
 
+func TestAsyncCommitFlushedInBackground(t *testing.T) {
+	w, _ := newTestWriter(t)
+	defer w.Close()
+
+	w.SetAsyncCommit(true)
+	w.LogBegin(types.TxnID(1))
+	lsn, err := w.LogCommit(types.TxnID(1))
+	if err != nil {
+		t.Fatalf("LogCommit() error = %v", err)
+	}
+	if w.GetFlushedLSN() >= lsn {
+		t.Fatalf("async commit was forced: FlushedLSN = %d, commitLSN = %d", w.GetFlushedLSN(), lsn)
+	}
+
+	interval := 10 * time.Millisecond
+	w.StartFlusher(interval)
+	deadline := time.Now().Add(50 * interval)
+	for w.GetFlushedLSN() < lsn {
+		if time.Now().After(deadline) {
+			t.Fatalf("commit not flushed in the background: FlushedLSN = %d, commitLSN = %d", w.GetFlushedLSN(), lsn)
+		}
+		time.Sleep(interval / 2)
+	}
+}
+
+func TestCloseStopsFlusherAndFlushes(t *testing.T) {
+	w, path := newTestWriter(t)
+
+	w.SetAsyncCommit(true)
+	w.StartFlusher(time.Hour)
+	w.LogBegin(types.TxnID(1))
+	lsn, _ := w.LogCommit(types.TxnID(1))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	w2, err := NewWriter(path)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer w2.Close()
+	if w2.GetCurrentLSN() != lsn+1 {
+		t.Errorf("CurrentLSN after reopen = %d, want %d", w2.GetCurrentLSN(), lsn+1)
+	}
+}
+
 func TestLogAbort(t *testing.T) {
 	w, _ := newTestWriter(t)
 	defer w.Close()
@@ -152,7 +202,7 @@ func TestLogUpdate(t *testing.T) {
 	w, _ := newTestWriter(t)
 	defer w.Close()
 
-	lsn := w.LogUpdate(types.TxnID(1), 1, 100, types.PageID(0), 0, []byte("old"), []byte("new"))
+	lsn := w.LogUpdate(types.TxnID(1), 1, 100, types.PageID(0), 0, types.PageID(0), 1, []byte("old"), []byte("new"))
 	if lsn == 0 {
 		t.Error("LogUpdate() returned 0")
 	}
@@ -264,6 +314,99 @@ func TestGetMaxTxnID(t *testing.T) {
 	}
 }
 
+func TestAppendSetsTimestamp(t *testing.T) {
+	w, path := newTestWriter(t)
+
+	before := time.Now()
+	w.LogBegin(types.TxnID(1))
+	after := time.Now()
+	w.Close()
+
+	rm := NewRecoveryManager(path, nil)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+	f.Seek(walFileHeader, 0)
+	records, _ := rm.readAllRecords(f)
+	if len(records) != 1 {
+		t.Fatalf("records = %d, want 1", len(records))
+	}
+	if ts := records[0].Timestamp; ts.Before(before.Truncate(0)) || ts.After(after) {
+		t.Errorf("Timestamp = %v, want between %v and %v", ts, before, after)
+	}
+}
+
+func TestOpenVersion2WAL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wal.log")
+
+	// A version 2 file: header plus one record without a timestamp
+	header := make([]byte, walFileHeader)
+	binary.LittleEndian.PutUint64(header[0:8], walMagic)
+	binary.LittleEndian.PutUint32(header[8:12], 2)
+	data := (&LogRecord{LSN: 1, TxnID: types.TxnID(7), Type: types.LogRecordBegin}).Serialize()
+	data = data[:len(data)-logRecordTimestampSize]
+	lenBuf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(lenBuf, uint32(len(data)))
+	content := append(append(header, lenBuf...), data...)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	w, err := NewWriter(path)
+	if err != nil {
+		t.Fatalf("NewWriter() on version 2 WAL error = %v", err)
+	}
+	if w.GetCurrentLSN() != 2 {
+		t.Errorf("CurrentLSN = %d, want 2", w.GetCurrentLSN())
+	}
+	if w.GetMaxTxnID() != 7 {
+		t.Errorf("MaxTxnID = %d, want 7", w.GetMaxTxnID())
+	}
+	w.LogCommit(types.TxnID(7))
+	w.Close()
+
+	// Both record layouts read back from the upgraded file
+	w2, err := NewWriter(path)
+	if err != nil {
+		t.Fatalf("Reopen NewWriter() error = %v", err)
+	}
+	defer w2.Close()
+	if w2.GetCurrentLSN() != 3 {
+		t.Errorf("CurrentLSN after reopen = %d, want 3", w2.GetCurrentLSN())
+	}
+	raw, _ := os.ReadFile(path)
+	if v := binary.LittleEndian.Uint32(raw[8:12]); v != walVersion {
+		t.Errorf("header version = %d, want %d", v, walVersion)
+	}
+}
+
+func TestOpenVersion1WAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+
+	// A version 1 file, as the first release wrote it
+	header := make([]byte, walFileHeader)
+	binary.LittleEndian.PutUint64(header[0:8], walMagic)
+	binary.LittleEndian.PutUint32(header[8:12], 1)
+	if err := os.WriteFile(path, header, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	_, err := NewWriter(path)
+	if err == nil || !strings.Contains(err.Error(), "unsupported WAL version 1") || !strings.Contains(err.Error(), "load them into a new database") {
+		t.Fatalf("NewWriter() on version 1 WAL error = %v, want an unsupported version error saying what to do", err)
+	}
+	// The file is left as it was
+	if raw, _ := os.ReadFile(path); !bytes.Equal(raw, header) {
+		t.Errorf("file changed to %x", raw)
+	}
+	if _, err := NewReadOnlyWriter(path); err == nil {
+		t.Error("NewReadOnlyWriter() on version 1 WAL succeeded")
+	}
+}
+
 func TestInvalidWALMagic(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "bad.wal")
@@ -276,3 +419,126 @@ func TestInvalidWALMagic(t *testing.T) {
 		t.Fatal("expected error for invalid WAL magic")
 	}
 }
+
+func TestNewWriterSize(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := NewWriterSize(filepath.Join(dir, "small.log"), MinBufferSize-1); err == nil {
+		t.Error("NewWriterSize() below the minimum should error")
+	}
+
+	w, err := NewWriterSize(filepath.Join(dir, "wal.log"), MinBufferSize)
+	if err != nil {
+		t.Fatalf("NewWriterSize() error = %v", err)
+	}
+	defer w.Close()
+
+	// Records past the buffer size are flushed without an explicit Force
+	data := make([]byte, 1024)
+	for i := 0; i < 8; i++ {
+		w.LogInsert(types.TxnID(1), 1, uint64(i), types.PageID(0), uint16(i), data)
+	}
+	if w.GetFlushedLSN() == 0 {
+		t.Error("FlushedLSN = 0, want records auto-flushed once the buffer filled")
+	}
+}
+
+func TestWriterFileSizeAndCounters(t *testing.T) {
+	w, _ := newTestWriter(t)
+	defer w.Close()
+
+	size, err := w.FileSize()
+	if err != nil {
+		t.Fatalf("FileSize() error = %v", err)
+	}
+	if size != walFileHeader {
+		t.Errorf("FileSize() = %d, want header only (%d)", size, walFileHeader)
+	}
+
+	w.LogBegin(types.TxnID(1))
+	w.LogInsert(types.TxnID(1), 1, 100, types.PageID(0), 0, []byte("data"))
+	if records, bytes := w.SinceCheckpoint(); records != 2 || bytes == 0 {
+		t.Errorf("SinceCheckpoint() = %d, %d; want 2 records and some bytes", records, bytes)
+	}
+
+	// Buffered records reach the file once flushed
+	if size, _ := w.FileSize(); size != walFileHeader {
+		t.Errorf("FileSize() before flush = %d, want %d", size, walFileHeader)
+	}
+	w.Flush()
+	_, bytes := w.SinceCheckpoint()
+	if size, _ := w.FileSize(); size != walFileHeader+int64(bytes) {
+		t.Errorf("FileSize() after flush = %d, want %d", size, walFileHeader+int64(bytes))
+	}
+
+	// A checkpoint resets the counters
+	if _, err := w.LogCheckpoint(nil, nil); err != nil {
+		t.Fatalf("LogCheckpoint() error = %v", err)
+	}
+	if records, bytes := w.SinceCheckpoint(); records != 0 || bytes != 0 {
+		t.Errorf("SinceCheckpoint() after checkpoint = %d, %d; want 0, 0", records, bytes)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	w, path := newTestWriter(t)
+
+	w.LogBegin(types.TxnID(7))
+	w.LogInsert(types.TxnID(7), 1, 100, types.PageID(0), 0, []byte("old"))
+	w.LogCommit(types.TxnID(7))
+	w.LogBegin(types.TxnID(8))
+	keep := w.LogInsert(types.TxnID(8), 1, 101, types.PageID(0), 1, []byte("new"))
+	before, _ := w.FileSize()
+
+	if err := w.Truncate(keep); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+	if after, _ := w.FileSize(); after >= before {
+		t.Errorf("FileSize() after Truncate = %d, want less than %d", after, before)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Stat(%s.tmp) after Truncate: err = %v, want not exist", path, err)
+	}
+
+	// Records appended afterwards follow the kept ones
+	w.LogCommit(types.TxnID(8))
+	if _, err := w.LogCheckpoint(nil, nil); err != nil {
+		t.Fatalf("LogCheckpoint() error = %v", err)
+	}
+	w.Close()
+
+	records, err := ReadRecords(path)
+	if err != nil {
+		t.Fatalf("ReadRecords() error = %v", err)
+	}
+	var lsns []types.LSN
+	for _, r := range records {
+		lsns = append(lsns, r.LSN)
+	}
+	if len(lsns) != 3 || lsns[0] != keep || lsns[1] != keep+1 || lsns[2] != keep+2 {
+		t.Errorf("LSNs after Truncate = %v, want [%d %d %d]", lsns, keep, keep+1, keep+2)
+	}
+
+	// Reopening continues the LSNs, and the checkpoint keeps the highest
+	// TxnID after truncating again
+	w2, err := NewWriter(path)
+	if err != nil {
+		t.Fatalf("NewWriter() reopen error = %v", err)
+	}
+	if got := w2.GetCurrentLSN(); got != keep+3 {
+		t.Errorf("GetCurrentLSN() after reopen = %d, want %d", got, keep+3)
+	}
+	if err := w2.Truncate(keep + 2); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+	w2.Close()
+
+	w3, err := NewWriter(path)
+	if err != nil {
+		t.Fatalf("NewWriter() reopen error = %v", err)
+	}
+	defer w3.Close()
+	if got := w3.GetMaxTxnID(); got != 8 {
+		t.Errorf("GetMaxTxnID() after truncating to the checkpoint = %d, want 8", got)
+	}
+}
@@ -3,7 +3,10 @@ package types
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // PageID represents a unique identifier for a page.
@@ -78,70 +81,139 @@ type Tuple struct {
 	Cid      CommandID // Command ID within transaction
 	TableID  uint32    // Table identifier
 	RowID    uint64    // Row identifier
+
+	// Location of the version this one replaced (InvalidPageID if none)
+	PrevPageID  PageID
+	PrevSlotNum uint16
+
 	Data     []byte    // Actual row data
+
+	// Where Data is kept when it is too large for a page; nil when it is
+	// stored in the tuple. Serialize then writes the reference in place of
+	// the data, and DeserializeTuple returns it with Data empty.
+	Overflow *OverflowRef
 }
 
+// OverflowRef locates row data stored in a chain of overflow pages.
+type OverflowRef struct {
+	FirstPage PageID // First page of the chain
+	Length    uint32 // Bytes of row data in the chain
+}
+
+// TupleHeaderSize is the size of the serialized MVCC header that precedes
+// the row data.
+const TupleHeaderSize = 42
+
+// overflowFlag is set in the serialized data length of a tuple whose data
+// is in overflow pages; what follows the header is then an OverflowRef of
+// overflowRefSize bytes.
+const (
+	overflowFlag    = 1 << 31
+	overflowRefSize = 8
+)
+
 // IsDeleted returns true if this tuple version has been deleted.
 func (t *Tuple) IsDeleted() bool {
 	return t.XMax != InvalidTxnID
 }
 
+// HasPrevVersion reports whether this version replaced an older one.
+func (t *Tuple) HasPrevVersion() bool {
+	return t.PrevPageID != InvalidPageID
+}
+
 // Clone creates a deep copy of the tuple.
 func (t *Tuple) Clone() *Tuple {
 	data := make([]byte, len(t.Data))
 	copy(data, t.Data)
 	return &Tuple{
-		XMin:    t.XMin,
-		XMax:    t.XMax,
-		Cid:     t.Cid,
-		TableID: t.TableID,
-		RowID:   t.RowID,
-		Data:    data,
+		XMin:        t.XMin,
+		XMax:        t.XMax,
+		Cid:         t.Cid,
+		TableID:     t.TableID,
+		RowID:       t.RowID,
+		PrevPageID:  t.PrevPageID,
+		PrevSlotNum: t.PrevSlotNum,
+		Data:        data,
+		Overflow:    t.Overflow,
 	}
 }
 
 // Serialize converts the tuple to bytes.
 func (t *Tuple) Serialize() []byte {
-	// Format: XMin(8) + XMax(8) + Cid(4) + TableID(4) + RowID(8) + DataLen(4) + Data
-	buf := make([]byte, 36+len(t.Data))
+	// Format: XMin(8) + XMax(8) + Cid(4) + TableID(4) + RowID(8) +
+	// PrevPageID(4) + PrevSlotNum(2) + DataLen(4) + Data, where an
+	// overflowed tuple has overflowFlag in DataLen and FirstPage(4) +
+	// Length(4) for Data
+	if t.Overflow != nil {
+		buf := make([]byte, TupleHeaderSize+overflowRefSize)
+		t.putHeader(buf, overflowFlag|overflowRefSize)
+		binary.LittleEndian.PutUint32(buf[TupleHeaderSize:], uint32(t.Overflow.FirstPage))
+		binary.LittleEndian.PutUint32(buf[TupleHeaderSize+4:], t.Overflow.Length)
+		return buf
+	}
+	buf := make([]byte, TupleHeaderSize+len(t.Data))
+	t.putHeader(buf, uint32(len(t.Data)))
+	copy(buf[TupleHeaderSize:], t.Data)
+	return buf
+}
+
+// putHeader writes the tuple's header with the given DataLen field.
+func (t *Tuple) putHeader(buf []byte, dataLen uint32) {
 	binary.LittleEndian.PutUint64(buf[0:8], uint64(t.XMin))
 	binary.LittleEndian.PutUint64(buf[8:16], uint64(t.XMax))
 	binary.LittleEndian.PutUint32(buf[16:20], uint32(t.Cid))
 	binary.LittleEndian.PutUint32(buf[20:24], t.TableID)
 	binary.LittleEndian.PutUint64(buf[24:32], t.RowID)
-	binary.LittleEndian.PutUint32(buf[32:36], uint32(len(t.Data)))
-	copy(buf[36:], t.Data)
-	return buf
+	binary.LittleEndian.PutUint32(buf[32:36], uint32(t.PrevPageID))
+	binary.LittleEndian.PutUint16(buf[36:38], t.PrevSlotNum)
+	binary.LittleEndian.PutUint32(buf[38:42], dataLen)
 }
 
 // DeserializeTuple creates a tuple from bytes.
 func DeserializeTuple(buf []byte) (*Tuple, error) {
-	if len(buf) < 36 {
+	if len(buf) < TupleHeaderSize {
 		return nil, fmt.Errorf("buffer too small for tuple header")
 	}
-	dataLen := binary.LittleEndian.Uint32(buf[32:36])
-	if len(buf) < 36+int(dataLen) {
+	dataLen := binary.LittleEndian.Uint32(buf[38:42])
+	var overflow *OverflowRef
+	if dataLen&overflowFlag != 0 {
+		dataLen &^= overflowFlag
+		if dataLen != overflowRefSize || len(buf) < TupleHeaderSize+overflowRefSize {
+			return nil, fmt.Errorf("malformed overflow reference")
+		}
+		overflow = &OverflowRef{
+			FirstPage: PageID(binary.LittleEndian.Uint32(buf[TupleHeaderSize:])),
+			Length:    binary.LittleEndian.Uint32(buf[TupleHeaderSize+4:]),
+		}
+		dataLen = 0
+	}
+	if len(buf) < TupleHeaderSize+int(dataLen) {
 		return nil, fmt.Errorf("buffer too small for tuple data")
 	}
 	data := make([]byte, dataLen)
-	copy(data, buf[36:36+dataLen])
+	copy(data, buf[TupleHeaderSize:TupleHeaderSize+int(dataLen)])
 	return &Tuple{
-		XMin:    TxnID(binary.LittleEndian.Uint64(buf[0:8])),
-		XMax:    TxnID(binary.LittleEndian.Uint64(buf[8:16])),
-		Cid:     CommandID(binary.LittleEndian.Uint32(buf[16:20])),
-		TableID: binary.LittleEndian.Uint32(buf[20:24]),
-		RowID:   binary.LittleEndian.Uint64(buf[24:32]),
-		Data:    data,
+		XMin:        TxnID(binary.LittleEndian.Uint64(buf[0:8])),
+		XMax:        TxnID(binary.LittleEndian.Uint64(buf[8:16])),
+		Cid:         CommandID(binary.LittleEndian.Uint32(buf[16:20])),
+		TableID:     binary.LittleEndian.Uint32(buf[20:24]),
+		RowID:       binary.LittleEndian.Uint64(buf[24:32]),
+		PrevPageID:  PageID(binary.LittleEndian.Uint32(buf[32:36])),
+		PrevSlotNum: binary.LittleEndian.Uint16(buf[36:38]),
+		Data:        data,
+		Overflow:    overflow,
 	}, nil
 }
 
 // Value represents a SQL value.
 type Value struct {
-	Type    ValueType
-	IsNull  bool
-	IntVal  int64
-	StrVal  string
-	BoolVal bool
+	Type     ValueType
+	IsNull   bool
+	IntVal   int64 // INT, and TIMESTAMP as seconds since the Unix epoch
+	StrVal   string
+	BoolVal  bool
+	BytesVal []byte
 }
 
 type ValueType int
@@ -151,6 +223,8 @@ const (
 	ValueTypeInt
 	ValueTypeString
 	ValueTypeBool
+	ValueTypeBytes
+	ValueTypeTimestamp
 )
 
 func (v Value) String() string {
@@ -164,11 +238,42 @@ func (v Value) String() string {
 		return v.StrVal
 	case ValueTypeBool:
 		return fmt.Sprintf("%t", v.BoolVal)
+	case ValueTypeBytes:
+		return FormatBytes(v.BytesVal)
+	case ValueTypeTimestamp:
+		return FormatTimestamp(v.IntVal)
 	default:
 		return "NULL"
 	}
 }
 
+// FormatBytes renders BLOB data as \x followed by lower-case hex digits.
+func FormatBytes(b []byte) string {
+	return `\x` + hex.EncodeToString(b)
+}
+
+// ParseBytes reads the hex digits of a BLOB value, with or without a
+// leading \x.
+func ParseBytes(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(s, `\x`))
+}
+
+// FormatTimestamp renders a TIMESTAMP as RFC 3339 in UTC.
+func FormatTimestamp(sec int64) string {
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+}
+
+// ParseTimestamp reads an RFC 3339 TIMESTAMP such as 2024-01-02T15:04:05Z
+// and returns its seconds since the Unix epoch. Fractions of a second are
+// dropped.
+func ParseTimestamp(s string) (int64, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid TIMESTAMP %q: want RFC 3339, e.g. 2024-01-02T15:04:05Z", s)
+	}
+	return t.Unix(), nil
+}
+
 // Row represents a row of values.
 type Row struct {
 	Values []Value
@@ -185,6 +290,52 @@ type Column struct {
 	Name     string
 	Type     ValueType
 	Nullable bool
+	Serial   bool // INT column filled from the table's sequence when omitted
+
+	Default    *Value    // Value used when the column is omitted from an INSERT
+	Unique     bool      // No two live rows may share a non-NULL value
+	PrimaryKey bool      // Unique and NOT NULL
+	Collation  Collation // How TEXT values compare, sort and are indexed
+}
+
+// Collation selects how TEXT values are compared.
+type Collation uint8
+
+const (
+	CollationBinary Collation = iota // Byte order, the default
+	CollationNoCase                  // Case-insensitive: compares lower-cased text
+)
+
+var collationNames = map[Collation]string{
+	CollationBinary: "BINARY",
+	CollationNoCase: "NOCASE",
+}
+
+// ParseCollation returns the collation with the given name, in any case.
+func ParseCollation(name string) (Collation, bool) {
+	for c, n := range collationNames {
+		if strings.EqualFold(n, name) {
+			return c, true
+		}
+	}
+	return CollationBinary, false
+}
+
+func (c Collation) String() string {
+	if name, ok := collationNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Collation(%d)", c)
+}
+
+// Key returns the value the collation compares in place of v: TEXT values
+// that the collation treats as equal map to the same key, which sorts
+// bytewise in the collation's order. Other values are returned unchanged.
+func (c Collation) Key(v Value) Value {
+	if c == CollationNoCase && v.Type == ValueTypeString && !v.IsNull {
+		v.StrVal = strings.ToLower(v.StrVal)
+	}
+	return v
 }
 
 // SerializeRow encodes a row as compact binary using the schema's column order.
@@ -196,6 +347,8 @@ type Column struct {
 //	  INT    → int64 little-endian (8 bytes)
 //	  STRING → uint16 LE length + UTF-8 bytes
 //	  BOOL   → 1 byte (0x00=false, 0x01=true)
+//	  BYTES  → uint32 LE length + raw bytes
+//	  TIMESTAMP → int64 LE seconds since the Unix epoch (8 bytes)
 func SerializeRow(schema *Schema, values map[string]Value) ([]byte, error) {
 	numCols := len(schema.Columns)
 	bitmapLen := (numCols + 7) / 8
@@ -209,8 +362,12 @@ func SerializeRow(schema *Schema, values map[string]Value) ([]byte, error) {
 			buf[i/8] |= 1 << (uint(i) % 8)
 			continue
 		}
+		if val.Type != col.Type {
+			// Encoding by the column type would store some other value
+			return nil, fmt.Errorf("type mismatch for column %s", col.Name)
+		}
 		switch col.Type {
-		case ValueTypeInt:
+		case ValueTypeInt, ValueTypeTimestamp:
 			b := make([]byte, 8)
 			binary.LittleEndian.PutUint64(b, uint64(val.IntVal))
 			buf = append(buf, b...)
@@ -229,6 +386,9 @@ func SerializeRow(schema *Schema, values map[string]Value) ([]byte, error) {
 			} else {
 				buf = append(buf, 0x00)
 			}
+		case ValueTypeBytes:
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(val.BytesVal)))
+			buf = append(buf, val.BytesVal...)
 		default:
 			return nil, fmt.Errorf("unsupported column type for column %s", col.Name)
 		}
@@ -263,6 +423,13 @@ func DeserializeRow(schema *Schema, data []byte) (map[string]Value, error) {
 			v := int64(binary.LittleEndian.Uint64(data[offset : offset+8]))
 			result[col.Name] = Value{Type: ValueTypeInt, IntVal: v}
 			offset += 8
+		case ValueTypeTimestamp:
+			if offset+8 > len(data) {
+				return nil, fmt.Errorf("data truncated reading TIMESTAMP column %s", col.Name)
+			}
+			v := int64(binary.LittleEndian.Uint64(data[offset : offset+8]))
+			result[col.Name] = Value{Type: ValueTypeTimestamp, IntVal: v}
+			offset += 8
 		case ValueTypeString:
 			if offset+2 > len(data) {
 				return nil, fmt.Errorf("data truncated reading STRING length for column %s", col.Name)
@@ -280,6 +447,19 @@ func DeserializeRow(schema *Schema, data []byte) (map[string]Value, error) {
 			}
 			result[col.Name] = Value{Type: ValueTypeBool, BoolVal: data[offset] != 0}
 			offset++
+		case ValueTypeBytes:
+			if offset+4 > len(data) {
+				return nil, fmt.Errorf("data truncated reading BYTES length for column %s", col.Name)
+			}
+			bLen := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
+			offset += 4
+			if bLen > len(data)-offset {
+				return nil, fmt.Errorf("data truncated reading BYTES data for column %s", col.Name)
+			}
+			b := make([]byte, bLen)
+			copy(b, data[offset:offset+bLen])
+			result[col.Name] = Value{Type: ValueTypeBytes, BytesVal: b}
+			offset += bLen
 		default:
 			return nil, fmt.Errorf("unsupported column type for column %s", col.Name)
 		}
@@ -101,6 +101,17 @@ func TestTupleSerializeDeserializeRoundTrip(t *testing.T) {
 				Data:    bytes.Repeat([]byte("x"), 1000),
 			},
 		},
+		{
+			name: "with previous version",
+			tuple: &Tuple{
+				XMin:        TxnID(7),
+				TableID:     3,
+				RowID:       12,
+				PrevPageID:  PageID(9),
+				PrevSlotNum: 4,
+				Data:        []byte("v2"),
+			},
+		},
 		{
 			name: "max values",
 			tuple: &Tuple{
@@ -136,6 +147,9 @@ func TestTupleSerializeDeserializeRoundTrip(t *testing.T) {
 			if got.RowID != tt.tuple.RowID {
 				t.Errorf("RowID = %d, want %d", got.RowID, tt.tuple.RowID)
 			}
+			if got.PrevPageID != tt.tuple.PrevPageID || got.PrevSlotNum != tt.tuple.PrevSlotNum {
+				t.Errorf("prev version = (%d, %d), want (%d, %d)", got.PrevPageID, got.PrevSlotNum, tt.tuple.PrevPageID, tt.tuple.PrevSlotNum)
+			}
 			if !bytes.Equal(got.Data, tt.tuple.Data) {
 				t.Errorf("Data mismatch")
 			}
@@ -143,8 +157,33 @@ func TestTupleSerializeDeserializeRoundTrip(t *testing.T) {
 	}
 }
 
+func TestTupleSerializeOverflowRef(t *testing.T) {
+	orig := &Tuple{
+		XMin: 7, XMax: 9, TableID: 3, RowID: 11, PrevPageID: InvalidPageID,
+		Data:     make([]byte, 10000),
+		Overflow: &OverflowRef{FirstPage: 42, Length: 10000},
+	}
+	buf := orig.Serialize()
+	if len(buf) != TupleHeaderSize+8 {
+		t.Fatalf("Serialize() = %d bytes, want the header and the reference only", len(buf))
+	}
+	got, err := DeserializeTuple(buf)
+	if err != nil {
+		t.Fatalf("DeserializeTuple() error = %v", err)
+	}
+	if got.Overflow == nil || *got.Overflow != *orig.Overflow {
+		t.Errorf("Overflow = %+v, want %+v", got.Overflow, orig.Overflow)
+	}
+	if got.XMin != 7 || got.XMax != 9 || got.RowID != 11 || len(got.Data) != 0 {
+		t.Errorf("DeserializeTuple() = %+v", got)
+	}
+	if _, err := DeserializeTuple(buf[:TupleHeaderSize+4]); err == nil {
+		t.Error("DeserializeTuple() of a truncated reference succeeded, want an error")
+	}
+}
+
 func TestDeserializeTupleTooSmallHeader(t *testing.T) {
-	_, err := DeserializeTuple(make([]byte, 35))
+	_, err := DeserializeTuple(make([]byte, TupleHeaderSize-1))
 	if err == nil {
 		t.Fatal("expected error for buffer smaller than header")
 	}
@@ -157,7 +196,7 @@ func TestDeserializeTupleTruncatedData(t *testing.T) {
 	}
 	buf := tuple.Serialize()
 	// Truncate: keep header but cut data short
-	_, err := DeserializeTuple(buf[:37])
+	_, err := DeserializeTuple(buf[:TupleHeaderSize+1])
 	if err == nil {
 		t.Fatal("expected error for truncated data")
 	}
@@ -223,6 +262,8 @@ func TestValueString(t *testing.T) {
 		{"string", Value{Type: ValueTypeString, StrVal: "hello"}, "hello"},
 		{"bool true", Value{Type: ValueTypeBool, BoolVal: true}, "true"},
 		{"bool false", Value{Type: ValueTypeBool, BoolVal: false}, "false"},
+		{"bytes", Value{Type: ValueTypeBytes, BytesVal: []byte{0x00, 0xff}}, `\x00ff`},
+		{"timestamp", Value{Type: ValueTypeTimestamp, IntVal: 1704207845}, "2024-01-02T15:04:05Z"},
 		{"unknown type", Value{Type: ValueType(99)}, "NULL"},
 	}
 	for _, tt := range tests {
@@ -496,6 +537,27 @@ func TestRowTruncatedData(t *testing.T) {
 	}
 }
 
+func TestRowTypeMismatch(t *testing.T) {
+	schema := &Schema{
+		TableName: "t",
+		Columns: []Column{
+			{Name: "id", Type: ValueTypeInt},
+			{Name: "flag", Type: ValueTypeBool},
+		},
+	}
+
+	tests := []map[string]Value{
+		{"id": {Type: ValueTypeString, StrVal: "1"}},
+		{"id": {Type: ValueTypeBool, BoolVal: true}},
+		{"flag": {Type: ValueTypeInt, IntVal: 1}},
+	}
+	for _, values := range tests {
+		if _, err := SerializeRow(schema, values); err == nil {
+			t.Errorf("SerializeRow(%v) should fail on a type mismatch", values)
+		}
+	}
+}
+
 func TestRowBoolFalse(t *testing.T) {
 	schema := &Schema{
 		TableName: "t",
@@ -526,6 +588,98 @@ func TestRowBoolFalse(t *testing.T) {
 	}
 }
 
+func TestRowBytes(t *testing.T) {
+	schema := &Schema{
+		TableName: "t",
+		Columns: []Column{
+			{Name: "data", Type: ValueTypeBytes},
+			{Name: "empty", Type: ValueTypeBytes},
+			{Name: "id", Type: ValueTypeInt},
+		},
+	}
+	blob := []byte{0x00, 0xde, 0xad, 0x00, 0xbe, 0xef, 0x00}
+	values := map[string]Value{
+		"data":  {Type: ValueTypeBytes, BytesVal: blob},
+		"empty": {Type: ValueTypeBytes, BytesVal: []byte{}},
+		"id":    {Type: ValueTypeInt, IntVal: 7},
+	}
+
+	data, err := SerializeRow(schema, values)
+	if err != nil {
+		t.Fatalf("SerializeRow failed: %v", err)
+	}
+	got, err := DeserializeRow(schema, data)
+	if err != nil {
+		t.Fatalf("DeserializeRow failed: %v", err)
+	}
+	if got["data"].Type != ValueTypeBytes || !bytes.Equal(got["data"].BytesVal, blob) {
+		t.Errorf("data = %+v, want %x", got["data"], blob)
+	}
+	if got["empty"].IsNull || len(got["empty"].BytesVal) != 0 {
+		t.Errorf("empty = %+v, want a non-NULL empty BLOB", got["empty"])
+	}
+	if got["id"].IntVal != 7 {
+		t.Errorf("id = %d, want 7", got["id"].IntVal)
+	}
+
+	if _, err := DeserializeRow(schema, data[:len(data)-10]); err == nil {
+		t.Error("DeserializeRow of truncated BLOB data should fail")
+	}
+}
+
+func TestRowTimestamp(t *testing.T) {
+	schema := &Schema{
+		TableName: "events",
+		Columns: []Column{
+			{Name: "at", Type: ValueTypeTimestamp},
+			{Name: "before", Type: ValueTypeTimestamp},
+			{Name: "id", Type: ValueTypeInt},
+		},
+	}
+	at, err := ParseTimestamp("2024-01-02T15:04:05Z")
+	if err != nil || at != 1704207845 {
+		t.Fatalf("ParseTimestamp() = %d, %v; want 1704207845", at, err)
+	}
+	values := map[string]Value{
+		"at":     {Type: ValueTypeTimestamp, IntVal: at},
+		"before": {Type: ValueTypeTimestamp, IntVal: -86400}, // 1969-12-31
+		"id":     {Type: ValueTypeInt, IntVal: 7},
+	}
+
+	data, err := SerializeRow(schema, values)
+	if err != nil {
+		t.Fatalf("SerializeRow failed: %v", err)
+	}
+	if len(data) != 1+3*8 {
+		t.Errorf("SerializeRow() = %d bytes, want 8 per column after the bitmap", len(data))
+	}
+	got, err := DeserializeRow(schema, data)
+	if err != nil {
+		t.Fatalf("DeserializeRow failed: %v", err)
+	}
+	for name, want := range values {
+		if g := got[name]; g.Type != want.Type || g.IntVal != want.IntVal || g.IsNull {
+			t.Errorf("%s = %+v, want %+v", name, got[name], want)
+		}
+	}
+	if s := got["before"].String(); s != "1969-12-31T00:00:00Z" {
+		t.Errorf("before = %s, want 1969-12-31T00:00:00Z", s)
+	}
+
+	// Offsets are kept, other zones are converted to UTC
+	if sec, _ := ParseTimestamp("2024-01-03T00:04:05+09:00"); sec != at {
+		t.Errorf("ParseTimestamp(+09:00) = %d, want %d", sec, at)
+	}
+	for _, bad := range []string{"2024-01-02", "2024-01-02 15:04:05", "yesterday"} {
+		if _, err := ParseTimestamp(bad); err == nil {
+			t.Errorf("ParseTimestamp(%q) succeeded, want an error", bad)
+		}
+	}
+	if _, err := DeserializeRow(schema, data[:len(data)-12]); err == nil {
+		t.Error("DeserializeRow of truncated TIMESTAMP data should fail")
+	}
+}
+
 func TestRowSizeComparisonVsJSON(t *testing.T) {
 	schema := &Schema{
 		TableName: "users",